@@ -3,6 +3,7 @@ package main
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
 
@@ -15,11 +16,23 @@ var stdlib string
 //go:embed version.txt
 var version string
 
+// Set via -ldflags "-X main.commit=... -X main.date=..." by release builds.
+var (
+	commit string
+	date   string
+)
+
 func main() {
 	if err := cmd.Execute(cmd.Assets{
 		Stdlib:  stdlib,
 		Version: version,
+		Commit:  commit,
+		Date:    date,
 	}); err != nil {
+		var exitErr *cmd.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runStdlib runs script in bash after loading the embedded stdlib, as an
+// .envrc in dir would be, and returns its stdout and stderr. Without
+// CASCADE_BIN, helpers that call back into cascade skip those checks.
+func runStdlib(t *testing.T, dir, script string, env ...string) (stdout, stderr string) {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	cmd := exec.Command(bash, "-c", `eval "$CASCADE_STDLIB"`+"\n"+script)
+	cmd.Env = append([]string{
+		"PATH=" + os.Getenv("PATH"),
+		"CASCADE_STDLIB=" + stdlib,
+		"CASCADE_DIR=" + dir,
+	}, env...)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("bash: %v\nstderr: %s", err, errOut.String())
+	}
+	return out.String(), errOut.String()
+}
+
+// TestStdlib_RootCopyMatches keeps the copy of stdlib.sh at the repository
+// root the same as the one embedded here, which is what cascade runs.
+func TestStdlib_RootCopyMatches(t *testing.T) {
+	root, err := os.ReadFile(filepath.Join("..", "..", "stdlib.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(root) != stdlib {
+		t.Error("stdlib.sh at the repository root differs from cmd/cascade/stdlib.sh; copy cmd/cascade/stdlib.sh over it")
+	}
+}
+
+func TestPathAdd_Deduplicates(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same directory spelled three ways is added once
+	stdout, stderr := runStdlib(t, dir, `PATH_add bin; PATH_add ./bin/; PATH_add "$CASCADE_DIR/bin"; path_add bin; echo "$PATH"`)
+	if got := strings.Count(":"+strings.TrimSpace(stdout)+":", ":"+bin+":"); got != 1 {
+		t.Errorf("PATH = %q, want %s once", stdout, bin)
+	}
+	if !strings.HasPrefix(stdout, bin+":") {
+		t.Errorf("PATH = %q, want %s prepended", stdout, bin)
+	}
+	if stderr != "" {
+		t.Errorf("stderr = %q, want nothing for an existing directory", stderr)
+	}
+
+	stdout, _ = runStdlib(t, dir, `MANPATH=/usr/share/man; MANPATH_add bin; MANPATH_add bin; echo "$MANPATH"`)
+	if want := bin + ":/usr/share/man\n"; stdout != want {
+		t.Errorf("MANPATH = %q, want %q", stdout, want)
+	}
+}
+
+func TestPathAdd_MissingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	stdout, stderr := runStdlib(t, dir, `PATH_add missing; echo "$PATH"`)
+	if !strings.HasPrefix(stdout, missing+":") {
+		t.Errorf("PATH = %q, want %s added anyway", stdout, missing)
+	}
+	if want := "cascade: PATH_add: " + missing + " does not exist (adding it anyway)\n"; stderr != want {
+		t.Errorf("stderr = %q, want %q", stderr, want)
+	}
+
+	stdout, stderr = runStdlib(t, dir, `PATH_add missing; MANPATH_add missing; echo "$PATH|${MANPATH:-}"`, "CASCADE_STRICT_PATH_ADD=1")
+	if strings.Contains(stdout, missing) {
+		t.Errorf("PATH|MANPATH = %q, want %s left out with CASCADE_STRICT_PATH_ADD=1", stdout, missing)
+	}
+	if strings.Count(stderr, "not adding "+missing+": directory does not exist") != 2 {
+		t.Errorf("stderr = %q, want one note per helper", stderr)
+	}
+}
+
+func TestMatchesSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		file     string
+		snapshot string
+		want     bool
+	}{
+		{"same", "export FOO=1\n\n", "export FOO=1\n\n", true},
+		{"empty", "", "", true},
+		{"changed", "export FOO=2\n", "export FOO=1\n", false},
+		{"trailing newline", "export FOO=1\n", "export FOO=1\n\n", false},
+		{"CRLF normalized", "export FOO=1\r\n", "export FOO=1\n", false},
+		{"NUL byte", "export FOO=1\n\x00a", "export FOO=1\n\x00b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, "file")
+			snapshot := filepath.Join(dir, "snapshot")
+			if err := os.WriteFile(file, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(snapshot, []byte(tt.snapshot), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			script := fmt.Sprintf(`if __matches_snapshot %q %q; then echo yes; else echo no; fi`, file, snapshot)
+			stdout, _ := runStdlib(t, dir, script)
+			if got := strings.TrimSpace(stdout) == "yes"; got != tt.want {
+				t.Errorf("__matches_snapshot = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A file that is gone matches nothing, not even an empty snapshot
+	script := fmt.Sprintf(`if __matches_snapshot %q %q; then echo yes; else echo no; fi`, filepath.Join(dir, "missing"), filepath.Join(dir, "snapshot"))
+	if stdout, stderr := runStdlib(t, dir, script); stdout != "no\n" || stderr != "" {
+		t.Errorf("__matches_snapshot of a missing file: stdout %q, stderr %q; want no and nothing", stdout, stderr)
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/xdg"
 )
 
 // AllowStatus represents the authorization state of an RC file.
@@ -35,35 +38,72 @@ func (s AllowStatus) String() string {
 	}
 }
 
+// Source identifies which record decided an AllowStatus.
+type Source string
+
+const (
+	SourceNone      Source = ""          // No record matched
+	SourceGlobal    Source = "global"    // Global allow/deny store
+	SourceWorkspace Source = "workspace" // In-workspace store (see WithWorkspace)
+	SourceTrust     Source = "trust"     // Trusted subtree
+	SourceWhitelist Source = "whitelist" // Config whitelist prefix
+	SourceSystem    Source = "system"    // Read-only system store (see WithSystem)
+	SourcePolicy    Source = "policy"    // Repository policy file (see package policy)
+)
+
 // Store manages allow/deny state for RC files.
 type Store struct {
-	allowDir string // ~/.local/share/cascade/allow/
-	denyDir  string // ~/.local/share/cascade/deny/
-	trustDir string // ~/.local/share/cascade/trust/
+	allowDir   string       // ~/.local/share/cascade/allow/
+	contentDir string       // ~/.local/share/cascade/content/ (see LastAllowedContent)
+	denyDir    string       // ~/.local/share/cascade/deny/
+	trustDir   string       // ~/.local/share/cascade/trust/
+	lockFile   string       // ~/.local/share/cascade/store.lock, held while changing records (see lock)
+	workspace  string       // Relative workspace store name (e.g. ".cascade"), empty if disabled
+	system     *systemStore // Read-only admin store, nil if disabled
+
+	auditDir     string    // ~/.local/share/cascade/audit/
+	auditSink    AuditSink // Receives audit records instead of the log, nil to write the log
+	auditMaxSize int64     // Rotate the log once it reaches this size, 0 for maxAuditSize
+	trigger      string    // Recorded in the audit log (see WithTrigger)
+	keepContent  bool      // Keep allowed content in the audit directory
 }
 
 // NewStore creates a Store with XDG-compliant paths.
-// Uses $XDG_DATA_HOME/cascade/ or ~/.local/share/cascade/.
+// Uses $CASCADE_DATA_DIR if set, else $XDG_DATA_HOME/cascade/ or
+// ~/.local/share/cascade/. If that directory cannot be written (see
+// ProbeWritable), the Store is returned for reading along with a
+// *NotWritableError.
 func NewStore() (*Store, error) {
-	dataHome := os.Getenv("XDG_DATA_HOME")
-	if dataHome == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("get home directory: %w", err)
-		}
-		dataHome = filepath.Join(home, ".local", "share")
+	baseDir, err := DefaultBaseDir()
+	if err != nil {
+		return nil, err
 	}
+	return NewStoreWithBase(baseDir), ProbeWritable(baseDir)
+}
 
-	baseDir := filepath.Join(dataHome, "cascade")
-	return NewStoreWithBase(baseDir), nil
+// DefaultBaseDir returns the directory NewStore keeps the store in,
+// without creating it.
+func DefaultBaseDir() (string, error) {
+	if baseDir := os.Getenv(DataDirEnv); baseDir != "" {
+		return baseDir, nil
+	}
+	dataHome, err := xdg.DataHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataHome, "cascade"), nil
 }
 
-// NewStoreWithBase creates a Store with a custom base directory (for testing).
+// NewStoreWithBase creates a Store with a custom base directory, which holds
+// what NewStore keeps in $XDG_DATA_HOME/cascade.
 func NewStoreWithBase(baseDir string) *Store {
 	return &Store{
-		allowDir: filepath.Join(baseDir, "allow"),
-		denyDir:  filepath.Join(baseDir, "deny"),
-		trustDir: filepath.Join(baseDir, "trust"),
+		allowDir:   filepath.Join(baseDir, "allow"),
+		contentDir: filepath.Join(baseDir, "content"),
+		denyDir:    filepath.Join(baseDir, "deny"),
+		trustDir:   filepath.Join(baseDir, "trust"),
+		lockFile:   filepath.Join(baseDir, "store.lock"),
+		auditDir:   filepath.Join(baseDir, "audit"),
 	}
 }
 
@@ -88,12 +128,41 @@ func (s *Store) Check(rc *envrc.RC) AllowStatus {
 // - Allowed if path is whitelisted (config-based)
 // - NotAllowed otherwise
 func (s *Store) CheckWithWhitelist(rc *envrc.RC, wl Whitelister) AllowStatus {
+	status, _ := s.Explain(rc, wl)
+	return status
+}
+
+// Explain returns the AllowStatus for an RC file along with the Source of the
+// record that decided it. The precedence is the same as CheckWithWhitelist,
+// with the workspace store consulted after the global store at each tier and
+// the system store after the user's own records:
+// global deny > workspace deny > system deny > global allow > workspace allow >
+// system allow > trust > system trust > whitelist.
+//
+// A user cannot override an admin's deny, but an admin's allow never
+// overrides a user's deny.
+//
+// Allow and Deny each remove the other's record, so a global deny next to a
+// global allow for the same file and content can only be left by a change
+// that was interrupted or made without the store lock. Explain repairs it
+// as Denied, removing the allow record, and notes the repair in the audit
+// log.
+func (s *Store) Explain(rc *envrc.RC, wl Whitelister) (AllowStatus, Source) {
+	ws, hasWorkspace := s.workspaceFor(rc.Path)
+
 	// Check deny first (path-based, takes precedence over everything)
 	pathHash, err := envrc.PathHash(rc.Path)
 	if err == nil {
 		denyFile := filepath.Join(s.denyDir, pathHash)
 		if _, err := os.Stat(denyFile); err == nil {
-			return Denied
+			s.repairAllowedAndDenied(rc, denyFile)
+			return Denied, SourceGlobal
+		}
+		if hasWorkspace && ws.has("deny", pathHash) {
+			return Denied, SourceWorkspace
+		}
+		if s.system != nil && s.system.has("deny", pathHash) {
+			return Denied, SourceSystem
 		}
 	}
 
@@ -101,26 +170,40 @@ func (s *Store) CheckWithWhitelist(rc *envrc.RC, wl Whitelister) AllowStatus {
 	if rc.ContentHash != "" {
 		allowFile := filepath.Join(s.allowDir, rc.ContentHash)
 		if _, err := os.Stat(allowFile); err == nil {
-			return Allowed
+			return Allowed, SourceGlobal
+		}
+		if hasWorkspace && ws.has("allow", rc.ContentHash) {
+			return Allowed, SourceWorkspace
+		}
+		if s.system != nil && s.system.has("allow", rc.ContentHash) {
+			return Allowed, SourceSystem
 		}
 	}
 
 	// Check trusted subtree (path-based)
 	if s.IsTrustedSubtree(rc.Path) {
-		return Allowed
+		return Allowed, SourceTrust
+	}
+	if s.system != nil {
+		if absPath, err := filepath.Abs(rc.Path); err == nil && s.system.isTrusted(absPath) {
+			return Allowed, SourceSystem
+		}
 	}
 
 	// Check whitelist (config-based, path prefix matching)
 	if wl != nil && wl.IsWhitelisted(rc.Path) {
-		return Allowed
+		return Allowed, SourceWhitelist
 	}
 
-	return NotAllowed
+	return NotAllowed, SourceNone
 }
 
 // Allow marks an RC file as allowed.
-// Creates allow file named by content hash, containing the path.
-// Removes any existing deny file.
+// Creates allow file named by content hash, containing the path, and keeps
+// the allowed content for diffing a later version (see LastAllowedContent).
+// Removes any existing deny file. Like every change to the store, a
+// successful Allow is recorded in the audit log. If the records are already
+// in place (see AllowChange), they are not written again or recorded.
 func (s *Store) Allow(rc *envrc.RC) error {
 	if !rc.Exists {
 		return fmt.Errorf("cannot allow non-existent file: %s", rc.Path)
@@ -130,6 +213,21 @@ func (s *Store) Allow(rc *envrc.RC) error {
 		return fmt.Errorf("cannot allow file without content hash: %s", rc.Path)
 	}
 
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.allowRecorded(rc) {
+		// Content kept since audit_keep_content was turned on, or for
+		// records written before content was kept, is not a change to the
+		// records
+		s.saveContent(rc)
+		s.keepAuditContent(rc)
+		return nil
+	}
+
 	// Create allow directory if needed
 	if err := os.MkdirAll(s.allowDir, 0755); err != nil {
 		return fmt.Errorf("create allow directory: %w", err)
@@ -152,18 +250,42 @@ func (s *Store) Allow(rc *envrc.RC) error {
 		return fmt.Errorf("remove deny file: %w", err)
 	}
 
+	// Mirror into the workspace store so the allow survives a global store wipe
+	if ws, ok := s.workspaceFor(rc.Path); ok {
+		if err := ws.write("allow", rc.ContentHash, rc.Path); err != nil {
+			return fmt.Errorf("write workspace allow file: %w", err)
+		}
+		if err := ws.remove("deny", pathHash); err != nil {
+			return fmt.Errorf("remove workspace deny file: %w", err)
+		}
+	}
+
+	s.audit(AuditAllow, rc.Path, rc.ContentHash)
+	s.saveContent(rc)
+	s.keepAuditContent(rc)
 	return nil
 }
 
 // Deny marks an RC file as denied.
 // Creates deny file named by path hash, containing the path.
-// Removes any existing allow file.
+// Removes any existing allow file. Like Allow, it writes nothing if the
+// records are already in place.
 func (s *Store) Deny(rc *envrc.RC) error {
 	pathHash, err := envrc.PathHash(rc.Path)
 	if err != nil {
 		return fmt.Errorf("compute path hash: %w", err)
 	}
 
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.denyRecorded(rc) {
+		return nil
+	}
+
 	// Create deny directory if needed
 	if err := os.MkdirAll(s.denyDir, 0755); err != nil {
 		return fmt.Errorf("create deny directory: %w", err)
@@ -181,13 +303,32 @@ func (s *Store) Deny(rc *envrc.RC) error {
 		if err := os.Remove(allowFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return fmt.Errorf("remove allow file: %w", err)
 		}
+		s.removeContent(rc.ContentHash)
+	}
+
+	if ws, ok := s.workspaceFor(rc.Path); ok {
+		if err := ws.write("deny", pathHash, rc.Path); err != nil {
+			return fmt.Errorf("write workspace deny file: %w", err)
+		}
+		if rc.ContentHash != "" {
+			if err := ws.remove("allow", rc.ContentHash); err != nil {
+				return fmt.Errorf("remove workspace allow file: %w", err)
+			}
+		}
 	}
 
+	s.audit(AuditDeny, rc.Path, rc.ContentHash)
 	return nil
 }
 
 // Revoke removes both allow and deny status (back to NotAllowed).
 func (s *Store) Revoke(rc *envrc.RC) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	var errs []error
 
 	// Remove allow file if content hash exists
@@ -196,6 +337,7 @@ func (s *Store) Revoke(rc *envrc.RC) error {
 		if err := os.Remove(allowFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
 			errs = append(errs, fmt.Errorf("remove allow file: %w", err))
 		}
+		s.removeContent(rc.ContentHash)
 	}
 
 	// Remove deny file
@@ -209,25 +351,89 @@ func (s *Store) Revoke(rc *envrc.RC) error {
 		}
 	}
 
-	return errors.Join(errs...)
+	if ws, ok := s.workspaceFor(rc.Path); ok {
+		if rc.ContentHash != "" {
+			if err := ws.remove("allow", rc.ContentHash); err != nil {
+				errs = append(errs, fmt.Errorf("remove workspace allow file: %w", err))
+			}
+		}
+		if pathHash != "" {
+			if err := ws.remove("deny", pathHash); err != nil {
+				errs = append(errs, fmt.Errorf("remove workspace deny file: %w", err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	s.audit(AuditRevoke, rc.Path, rc.ContentHash)
+	return nil
+}
+
+// repairAllowedAndDenied removes the global allow record for rc if rc is
+// also denied by denyFile, which only an interrupted change leaves behind.
+// The records are checked again under the store lock, so a change in
+// progress is never mistaken for one. Failures are ignored: rc is treated
+// as denied either way.
+func (s *Store) repairAllowedAndDenied(rc *envrc.RC, denyFile string) {
+	if rc.ContentHash == "" {
+		return
+	}
+	allowFile := filepath.Join(s.allowDir, rc.ContentHash)
+	if !hasRecord(allowFile, rc.Path) {
+		return
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if !hasRecord(allowFile, rc.Path) || !hasRecord(denyFile, rc.Path) {
+		return
+	}
+	if err := os.Remove(allowFile); err != nil {
+		return
+	}
+	s.removeContent(rc.ContentHash)
+	s.audit(AuditRepair, rc.Path, rc.ContentHash)
+}
+
+// PreviouslyAllowed reports whether an earlier version of rc's file was
+// allowed in the global store: an allow record names its path under a
+// different content hash. This is how a file shows up as "changed" rather
+// than new.
+func (s *Store) PreviouslyAllowed(rc *envrc.RC) bool {
+	for record, err := range s.Records(KindAllow) {
+		if err != nil {
+			return false
+		}
+		if record.Name != rc.ContentHash && record.Path == rc.Path {
+			return true
+		}
+	}
+	return false
 }
 
 // TrustSubtree marks a directory subtree as trusted.
 // Files under this path are auto-allowed when first loaded.
-// Creates a file in trustDir named by path hash, containing the absolute path.
+// Creates a file in trustDir named by path hash, containing the absolute path,
+// unless it is already there.
 func (s *Store) TrustSubtree(path string) error {
-	absPath, err := filepath.Abs(path)
+	trustFile, absPath, err := s.trustFile(path)
 	if err != nil {
-		return fmt.Errorf("resolve path: %w", err)
+		return err
 	}
 
-	// Verify the path exists and is a directory
-	info, err := os.Stat(absPath)
+	unlock, err := s.lock()
 	if err != nil {
-		return fmt.Errorf("stat path: %w", err)
+		return err
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("not a directory: %s", absPath)
+	defer unlock()
+
+	if hasRecord(trustFile, absPath) {
+		return nil
 	}
 
 	// Create trust directory if needed
@@ -235,18 +441,12 @@ func (s *Store) TrustSubtree(path string) error {
 		return fmt.Errorf("create trust directory: %w", err)
 	}
 
-	// Compute hash of the path for the filename
-	pathHash, err := dirPathHash(absPath)
-	if err != nil {
-		return fmt.Errorf("compute path hash: %w", err)
-	}
-
 	// Write trust file containing the path
-	trustFile := filepath.Join(s.trustDir, pathHash)
 	if err := os.WriteFile(trustFile, []byte(absPath), 0644); err != nil {
 		return fmt.Errorf("write trust file: %w", err)
 	}
 
+	s.audit(AuditTrust, absPath, "")
 	return nil
 }
 
@@ -262,6 +462,12 @@ func (s *Store) UntrustSubtree(path string) error {
 		return fmt.Errorf("compute path hash: %w", err)
 	}
 
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	trustFile := filepath.Join(s.trustDir, pathHash)
 	if err := os.Remove(trustFile); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -270,6 +476,7 @@ func (s *Store) UntrustSubtree(path string) error {
 		return fmt.Errorf("remove trust file: %w", err)
 	}
 
+	s.audit(AuditUntrust, absPath, "")
 	return nil
 }
 
@@ -296,7 +503,12 @@ func (s *Store) IsTrustedSubtree(path string) bool {
 
 // ListTrustedSubtrees returns all trusted subtree paths.
 func (s *Store) ListTrustedSubtrees() ([]string, error) {
-	entries, err := os.ReadDir(s.trustDir)
+	return listTrusted(s.trustDir)
+}
+
+// listTrusted returns the subtree paths recorded in a trust directory.
+func listTrusted(trustDir string) ([]string, error) {
+	entries, err := os.ReadDir(trustDir)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return nil, nil
@@ -310,7 +522,7 @@ func (s *Store) ListTrustedSubtrees() ([]string, error) {
 			continue
 		}
 
-		trustFile := filepath.Join(s.trustDir, entry.Name())
+		trustFile := filepath.Join(trustDir, entry.Name())
 		content, err := os.ReadFile(trustFile)
 		if err != nil {
 			continue // Skip unreadable files
@@ -336,17 +548,22 @@ func dirPathHash(path string) (string, error) {
 }
 
 // isUnderPath checks if child is under or equal to parent directory.
+// Windows paths are compared without regard to case, as its volumes are.
 func isUnderPath(child, parent string) bool {
 	// Clean paths for consistent comparison
 	child = filepath.Clean(child)
 	parent = filepath.Clean(parent)
+	if runtime.GOOS == "windows" {
+		child, parent = strings.ToLower(child), strings.ToLower(parent)
+	}
 
 	// Exact match
 	if child == parent {
 		return true
 	}
 
-	// Check if child starts with parent + separator
-	parentWithSep := parent + string(filepath.Separator)
-	return len(child) > len(parentWithSep) && child[:len(parentWithSep)] == parentWithSep
+	// Check if child starts with parent + separator; a root such as / or
+	// C:\ already ends with one
+	sep := string(filepath.Separator)
+	return strings.HasPrefix(child, strings.TrimSuffix(parent, sep)+sep)
 }
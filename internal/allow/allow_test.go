@@ -215,6 +215,42 @@ func TestContentChange_InvalidatesAllow(t *testing.T) {
 	}
 }
 
+func TestPreviouslyAllowed_AfterContentChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	envrcPath := filepath.Join(dir, ".envrc")
+
+	if err := os.WriteFile(envrcPath, []byte("export FOO=bar"), 0644); err != nil {
+		t.Fatalf("write envrc: %v", err)
+	}
+	rc1, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+	if store.PreviouslyAllowed(rc1) {
+		t.Error("PreviouslyAllowed() = true for a file never allowed")
+	}
+	if err := store.Allow(rc1); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if store.PreviouslyAllowed(rc1) {
+		t.Error("PreviouslyAllowed() = true for the currently allowed version")
+	}
+
+	if err := os.WriteFile(envrcPath, []byte("export FOO=changed"), 0644); err != nil {
+		t.Fatalf("write modified envrc: %v", err)
+	}
+	rc2, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC after modification: %v", err)
+	}
+	if !store.PreviouslyAllowed(rc2) {
+		t.Error("PreviouslyAllowed() = false after the allowed file changed")
+	}
+}
+
 func TestPathChange_SameContent_NotAllowed(t *testing.T) {
 	t.Parallel()
 
@@ -766,3 +802,44 @@ func TestExplicitAllow_TakesPrecedenceOverTrust(t *testing.T) {
 		t.Errorf("after untrust, Check() = %v, want Allowed (via explicit allow)", status)
 	}
 }
+
+func TestCheck_RepairsAllowedAndDenied(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	rc := writeRC(t, dir, "export FOO=bar")
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+
+	// As an interrupted Deny would leave it
+	pathHash, err := envrc.PathHash(rc.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(store.denyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store.denyDir, pathHash), []byte(rc.Path), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := store.Check(rc); status != Denied {
+		t.Errorf("Check() = %v, want Denied", status)
+	}
+	if _, err := os.Stat(filepath.Join(store.allowDir, rc.ContentHash)); !os.IsNotExist(err) {
+		t.Errorf("allow record kept next to the deny: %v", err)
+	}
+
+	var ops []string
+	for record, err := range store.AuditLog() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, record.Operation)
+	}
+	if len(ops) != 2 || ops[1] != AuditRepair {
+		t.Errorf("audit log = %v, want allow then repair", ops)
+	}
+}
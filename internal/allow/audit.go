@@ -0,0 +1,253 @@
+package allow
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditAllow   = "allow"
+	AuditDeny    = "deny"
+	AuditRevoke  = "revoke"
+	AuditTrust   = "trust"
+	AuditUntrust = "untrust"
+	AuditRepair  = "repair" // Allow record removed from a denied file (see Explain)
+)
+
+// AuditRecord is one change to the user store, as written to the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	Path      string    `json:"path"`              // .envrc file, or directory for trust and untrust
+	Hash      string    `json:"hash,omitempty"`    // Content hash of the .envrc, when it existed
+	Trigger   string    `json:"trigger,omitempty"` // What made the change (see WithTrigger)
+	UID       int       `json:"uid"`
+}
+
+const (
+	auditLogName  = "audit.jsonl"
+	auditPrevName = "audit.prev.jsonl" // The log before the last rotation
+	auditContent  = "content"
+	maxAuditSize  = 4 << 20 // Rotate the log once it reaches this size, by default
+	auditFileMode = 0600
+)
+
+// AuditSink receives the record of each change to the store. By default
+// records are appended to the audit log; tests can capture them instead
+// (see WithAuditSink).
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+// WithAuditSink returns a copy of the Store that passes its audit records
+// to sink instead of writing them to the audit log.
+func (s *Store) WithAuditSink(sink AuditSink) *Store {
+	cp := *s
+	cp.auditSink = sink
+	return &cp
+}
+
+// WithAuditMaxSize returns a copy of the Store that rotates the audit log
+// once it reaches size bytes. A size of 0 or less keeps the default.
+func (s *Store) WithAuditMaxSize(size int64) *Store {
+	cp := *s
+	cp.auditMaxSize = max(size, 0)
+	return &cp
+}
+
+// WithTrigger returns a copy of the Store whose changes are recorded in the
+// audit log as caused by trigger, such as "cascade allow" or the trusted
+// remote that auto-allowed a file.
+func (s *Store) WithTrigger(trigger string) *Store {
+	cp := *s
+	cp.trigger = trigger
+	return &cp
+}
+
+// WithAuditContent returns a copy of the Store that, when keep is true, also
+// saves the content of every file it allows next to the audit log, named by
+// content hash, so the approved text can be recovered later.
+func (s *Store) WithAuditContent(keep bool) *Store {
+	cp := *s
+	cp.keepContent = keep
+	return &cp
+}
+
+// AuditDir returns the directory holding the audit log.
+func (s *Store) AuditDir() string {
+	return s.auditDir
+}
+
+// audit records a change, in the audit sink if there is one and else in
+// the audit log.
+func (s *Store) audit(op, path, hash string) {
+	record := AuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: op,
+		Path:      path,
+		Hash:      hash,
+		Trigger:   s.trigger,
+		UID:       os.Getuid(),
+	}
+	if s.auditSink != nil {
+		s.auditSink.Record(record)
+		return
+	}
+	s.appendAudit(record)
+}
+
+// appendAudit appends record to the audit log. Each record is a single
+// O_APPEND write, so concurrent writers never interleave within a line.
+// Failures are ignored: the log must never block or fail the change it
+// records.
+func (s *Store) appendAudit(record AuditRecord) {
+	if s.auditDir == "" {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(s.auditDir, 0700); err != nil {
+		return
+	}
+
+	logFile := filepath.Join(s.auditDir, auditLogName)
+	maxSize := s.auditMaxSize
+	if maxSize == 0 {
+		maxSize = maxAuditSize
+	}
+	rotateAudit(logFile, maxSize)
+
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, auditFileMode)
+	if err != nil {
+		return
+	}
+	_, _ = f.Write(append(line, '\n'))
+	_ = f.Close()
+}
+
+// rotateAudit renames the log to auditPrevName once it reaches maxSize,
+// replacing the log rotated before it, so at most one previous log is
+// kept. Changes to the store, and so writes to the log, hold the store
+// lock, so two writers never rotate at once.
+func rotateAudit(logFile string, maxSize int64) {
+	info, err := os.Stat(logFile)
+	if err != nil || info.Size() < maxSize {
+		return
+	}
+	_ = os.Rename(logFile, filepath.Join(filepath.Dir(logFile), auditPrevName))
+}
+
+// keepAuditContent saves the content rc was allowed with, if enabled. The
+// content is re-read and verified against rc.ContentHash, so what is kept is
+// exactly what was approved.
+func (s *Store) keepAuditContent(rc *envrc.RC) {
+	if !s.keepContent || s.auditDir == "" {
+		return
+	}
+	writeContent(filepath.Join(s.auditDir, auditContent), rc)
+}
+
+// AuditContentFile returns the file holding the content kept for an allowed
+// file's content hash, if there is one.
+func (s *Store) AuditContentFile(hash string) (string, bool) {
+	if !validHash(hash) || s.auditDir == "" {
+		return "", false
+	}
+	file := filepath.Join(s.auditDir, auditContent, hash)
+	if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return file, true
+}
+
+// Concerns reports whether the record is about path: path itself, something
+// below it, or, for trust and untrust, a subtree containing it.
+func (r AuditRecord) Concerns(path string) bool {
+	if isUnderPath(r.Path, path) {
+		return true
+	}
+	return (r.Operation == AuditTrust || r.Operation == AuditUntrust) && isUnderPath(path, r.Path)
+}
+
+// AuditLog iterates over the audit log oldest first, the previous log
+// included. Lines that do not parse, such as one cut short by a full disk,
+// are skipped; an error opening or reading a log is yielded once and ends
+// the iteration.
+func (s *Store) AuditLog() iter.Seq2[AuditRecord, error] {
+	return func(yield func(AuditRecord, error) bool) {
+		// Logs rotated by earlier versions were named by rotation time
+		// and are still read
+		rotated, err := filepath.Glob(filepath.Join(s.auditDir, "audit-*.jsonl"))
+		if err != nil {
+			yield(AuditRecord{}, fmt.Errorf("list audit logs: %w", err))
+			return
+		}
+		slices.Sort(rotated)
+		files := append(rotated, filepath.Join(s.auditDir, auditPrevName), filepath.Join(s.auditDir, auditLogName))
+
+		for _, file := range files {
+			if !readAuditFile(file, yield) {
+				return
+			}
+		}
+	}
+}
+
+// readAuditFile yields the records in one log file, reporting whether the
+// iteration should continue.
+func readAuditFile(file string, yield func(AuditRecord, error) bool) bool {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		yield(AuditRecord{}, fmt.Errorf("open audit log: %w", err))
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !yield(record, nil) {
+			return false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		yield(AuditRecord{}, fmt.Errorf("read audit log %s: %w", file, err))
+		return false
+	}
+	return true
+}
+
+// validHash reports whether name looks like a hex SHA-256, so it can be
+// used as a file name without escaping the content directory.
+func validHash(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// auditRecords returns every record in the store's audit log.
+func auditRecords(t *testing.T, store *Store) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	for record, err := range store.AuditLog() {
+		if err != nil {
+			t.Fatalf("AuditLog: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAudit_EveryMutationRecordedOnce(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	envrcPath := filepath.Join(dir, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export FOO=bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStoreWithBase(filepath.Join(dir, "store")).WithWorkspace(".cascade")
+
+	steps := []struct {
+		op   string
+		path string
+		hash string
+		do   func(*Store) error
+	}{
+		{AuditAllow, rc.Path, rc.ContentHash, func(s *Store) error { return s.Allow(rc) }},
+		{AuditDeny, rc.Path, rc.ContentHash, func(s *Store) error { return s.Deny(rc) }},
+		{AuditRevoke, rc.Path, rc.ContentHash, func(s *Store) error { return s.Revoke(rc) }},
+		{AuditTrust, dir, "", func(s *Store) error { return s.TrustSubtree(dir) }},
+		{AuditUntrust, dir, "", func(s *Store) error { return s.UntrustSubtree(dir) }},
+	}
+	for i, step := range steps {
+		if err := step.do(store.WithTrigger("step " + step.op)); err != nil {
+			t.Fatalf("%s: %v", step.op, err)
+		}
+
+		records := auditRecords(t, store)
+		if len(records) != i+1 {
+			t.Fatalf("after %s: %d records, want %d", step.op, len(records), i+1)
+		}
+		got := records[i]
+		if got.Operation != step.op || got.Path != step.path || got.Hash != step.hash ||
+			got.Trigger != "step "+step.op || got.UID != os.Getuid() || got.Time.IsZero() {
+			t.Errorf("%s recorded %+v", step.op, got)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(store.AuditDir(), auditLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log mode = %o, want 600", perm)
+	}
+}
+
+func TestAudit_FailedMutationNotRecorded(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+
+	missing, err := envrc.NewRC(filepath.Join(dir, "missing", ".envrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Allow(missing); err == nil {
+		t.Fatal("Allow of a missing file succeeded")
+	}
+	if err := store.UntrustSubtree(dir); err == nil {
+		t.Fatal("UntrustSubtree of an untrusted directory succeeded")
+	}
+	if records := auditRecords(t, store); len(records) != 0 {
+		t.Errorf("failed operations recorded: %+v", records)
+	}
+}
+
+func TestAudit_UnwritableLogDoesNotFail(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	storeDir := filepath.Join(dir, "store")
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A file where the audit directory should be
+	if err := os.WriteFile(filepath.Join(storeDir, "audit"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStoreWithBase(storeDir).TrustSubtree(dir); err != nil {
+		t.Errorf("TrustSubtree failed because of the audit log: %v", err)
+	}
+}
+
+func TestAudit_KeepContent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	envrcPath := filepath.Join(dir, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export FOO=bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.AuditContentFile(rc.ContentHash); ok {
+		t.Error("content kept without WithAuditContent")
+	}
+
+	if err := store.WithAuditContent(true).Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+	file, ok := store.AuditContentFile(rc.ContentHash)
+	if !ok {
+		t.Fatal("content not kept")
+	}
+	// The file changing later does not touch what was kept
+	if err := os.WriteFile(envrcPath, []byte("export FOO=changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil || string(content) != "export FOO=bar\n" {
+		t.Errorf("kept content = %q, %v", content, err)
+	}
+
+	if _, ok := store.AuditContentFile("../" + rc.ContentHash); ok {
+		t.Error("AuditContentFile accepted a path")
+	}
+}
+
+func TestAudit_Rotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store")).WithAuditMaxSize(1024)
+	logFile := filepath.Join(store.AuditDir(), auditLogName)
+	prevFile := filepath.Join(store.AuditDir(), auditPrevName)
+
+	// Grow the log past the limit with lines that are skipped when read
+	grow := func() {
+		t.Helper()
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(strings.Repeat("x", 1023) + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.TrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+	grow()
+	if err := store.UntrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(logFile); err != nil || info.Size() >= 1024 {
+		t.Errorf("log not restarted after rotation: %v", err)
+	}
+	records := auditRecords(t, store)
+	if len(records) != 2 || records[0].Operation != AuditTrust || records[1].Operation != AuditUntrust {
+		t.Errorf("records across rotation = %+v", records)
+	}
+
+	// A second rotation replaces the previous log
+	grow()
+	if err := store.TrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(store.AuditDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("audit directory holds %d files, want the log and one previous log", len(entries))
+	}
+	if _, err := os.Stat(prevFile); err != nil {
+		t.Error(err)
+	}
+	records = auditRecords(t, store)
+	if len(records) != 2 || records[0].Operation != AuditUntrust || records[1].Operation != AuditTrust {
+		t.Errorf("records after a second rotation = %+v, want the last two", records)
+	}
+}
+
+func TestAudit_RotatedByEarlierVersionsStillRead(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	if err := os.MkdirAll(store.AuditDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	old := `{"time":"2024-01-01T00:00:00Z","op":"allow","path":"/old/.envrc","uid":0}` + "\n"
+	if err := os.WriteFile(filepath.Join(store.AuditDir(), "audit-20240101T000000.000000000Z.jsonl"), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	records := auditRecords(t, store)
+	if len(records) != 2 || records[0].Path != "/old/.envrc" || records[1].Operation != AuditTrust {
+		t.Errorf("records = %+v, want the old log's record first", records)
+	}
+}
+
+// memorySink collects audit records in memory.
+type memorySink []AuditRecord
+
+func (m *memorySink) Record(r AuditRecord) { *m = append(*m, r) }
+
+func TestAudit_Sink(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var sink memorySink
+	store := NewStoreWithBase(filepath.Join(dir, "store")).WithAuditSink(&sink).WithTrigger("test")
+	if err := store.TrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UntrustSubtree(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink) != 2 || sink[0].Operation != AuditTrust || sink[1].Operation != AuditUntrust || sink[0].Trigger != "test" {
+		t.Errorf("sink = %+v, want trust then untrust", sink)
+	}
+	if _, err := os.Stat(filepath.Join(store.AuditDir(), auditLogName)); !os.IsNotExist(err) {
+		t.Errorf("audit log written with a sink: %v", err)
+	}
+}
+
+func TestAuditRecord_Concerns(t *testing.T) {
+	t.Parallel()
+
+	file := AuditRecord{Operation: AuditAllow, Path: "/work/app/.envrc"}
+	trust := AuditRecord{Operation: AuditTrust, Path: "/work"}
+
+	tests := []struct {
+		record AuditRecord
+		path   string
+		want   bool
+	}{
+		{file, "/work/app/.envrc", true},
+		{file, "/work", true},
+		{file, "/work/other", false},
+		{trust, "/work/app/.envrc", true},
+		{trust, "/work/app", true},
+		{trust, "/elsewhere", false},
+		{AuditRecord{Operation: AuditAllow, Path: "/work"}, "/work/app/.envrc", false},
+	}
+	for _, tt := range tests {
+		if got := tt.record.Concerns(tt.path); got != tt.want {
+			t.Errorf("%s %s Concerns(%q) = %v, want %v", tt.record.Operation, tt.record.Path, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package allow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// Change is what Allow, Deny, or TrustSubtree did, or would do, to the
+// store. Its string form is part of the command output contract for
+// configuration management tools, so existing values must not change.
+type Change string
+
+const (
+	ChangeUnchanged Change = "unchanged" // An identical record was already present
+	ChangeAllowed   Change = "allowed"   // First allow for the path
+	ChangeUpdated   Change = "updated"   // An earlier version of the file was allowed
+	ChangeDenied    Change = "denied"
+	ChangeTrusted   Change = "trusted"
+)
+
+// Changed reports whether the store is (or would be) written.
+func (c Change) Changed() bool {
+	return c != ChangeUnchanged
+}
+
+// AllowChange returns what Allow would do for rc without writing anything:
+// ChangeUnchanged if rc's content is already allowed under its path with
+// no deny record to remove, in the workspace store as well if one applies.
+func (s *Store) AllowChange(rc *envrc.RC) Change {
+	switch {
+	case s.allowRecorded(rc):
+		return ChangeUnchanged
+	case s.PreviouslyAllowed(rc):
+		return ChangeUpdated
+	default:
+		return ChangeAllowed
+	}
+}
+
+// DenyChange returns what Deny would do for rc without writing anything.
+func (s *Store) DenyChange(rc *envrc.RC) Change {
+	if s.denyRecorded(rc) {
+		return ChangeUnchanged
+	}
+	return ChangeDenied
+}
+
+// TrustChange returns what TrustSubtree would do for path without writing
+// anything. It fails where TrustSubtree would, if path is not a directory.
+func (s *Store) TrustChange(path string) (Change, error) {
+	trustFile, absPath, err := s.trustFile(path)
+	if err != nil {
+		return "", err
+	}
+	if hasRecord(trustFile, absPath) {
+		return ChangeUnchanged, nil
+	}
+	return ChangeTrusted, nil
+}
+
+// allowRecorded reports whether every record Allow writes for rc is
+// already present with the same content, and every record it removes is
+// already gone.
+func (s *Store) allowRecorded(rc *envrc.RC) bool {
+	if rc.ContentHash == "" || !hasRecord(filepath.Join(s.allowDir, rc.ContentHash), rc.Path) {
+		return false
+	}
+	pathHash, err := envrc.PathHash(rc.Path)
+	if err != nil || !noRecord(filepath.Join(s.denyDir, pathHash)) {
+		return false
+	}
+	if ws, ok := s.workspaceFor(rc.Path); ok {
+		return hasRecord(ws.path("allow", rc.ContentHash), rc.Path) && noRecord(ws.path("deny", pathHash))
+	}
+	return true
+}
+
+// denyRecorded is allowRecorded for Deny.
+func (s *Store) denyRecorded(rc *envrc.RC) bool {
+	pathHash, err := envrc.PathHash(rc.Path)
+	if err != nil || !hasRecord(filepath.Join(s.denyDir, pathHash), rc.Path) {
+		return false
+	}
+	if rc.ContentHash != "" && !noRecord(filepath.Join(s.allowDir, rc.ContentHash)) {
+		return false
+	}
+	if ws, ok := s.workspaceFor(rc.Path); ok {
+		if !hasRecord(ws.path("deny", pathHash), rc.Path) {
+			return false
+		}
+		if rc.ContentHash != "" && !noRecord(ws.path("allow", rc.ContentHash)) {
+			return false
+		}
+	}
+	return true
+}
+
+// trustFile returns the trust record for the directory path, and path made
+// absolute. It fails if path is not a directory.
+func (s *Store) trustFile(path string) (file, absPath string, err error) {
+	absPath, err = filepath.Abs(path)
+	if err != nil {
+		return "", "", fmt.Errorf("resolve path: %w", err)
+	}
+
+	// Verify the path exists and is a directory
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", "", fmt.Errorf("stat path: %w", err)
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("not a directory: %s", absPath)
+	}
+
+	// Compute hash of the path for the filename
+	pathHash, err := dirPathHash(absPath)
+	if err != nil {
+		return "", "", fmt.Errorf("compute path hash: %w", err)
+	}
+	return filepath.Join(s.trustDir, pathHash), absPath, nil
+}
+
+// hasRecord reports whether the record file exists and holds content.
+func hasRecord(file, content string) bool {
+	data, err := os.ReadFile(file)
+	return err == nil && bytes.Equal(data, []byte(content))
+}
+
+// noRecord reports whether the record file does not exist. A record that
+// cannot be checked counts as present, so it is written or removed again.
+func noRecord(file string) bool {
+	_, err := os.Lstat(file)
+	return errors.Is(err, fs.ErrNotExist)
+}
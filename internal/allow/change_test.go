@@ -0,0 +1,193 @@
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// writeRC writes content to dir/.envrc and returns its RC.
+func writeRC(t *testing.T, dir, content string) *envrc.RC {
+	t.Helper()
+	path := filepath.Join(dir, ".envrc")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := envrc.NewRC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+// backdate moves the mtime of file an hour back and returns it.
+func backdate(t *testing.T, file string) time.Time {
+	t.Helper()
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(file, past, past); err != nil {
+		t.Fatal(err)
+	}
+	return past
+}
+
+func modTime(t *testing.T, file string) time.Time {
+	t.Helper()
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ModTime()
+}
+
+func TestAllowChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store")).WithWorkspace(".cascade")
+	if err := os.Mkdir(filepath.Join(dir, ".cascade"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// First time
+	rc := writeRC(t, dir, "export FOO=1\n")
+	if got := store.AllowChange(rc); got != ChangeAllowed {
+		t.Errorf("first AllowChange() = %q, want %q", got, ChangeAllowed)
+	}
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged: nothing is written again or audited
+	allowFile := filepath.Join(store.allowDir, rc.ContentHash)
+	past := backdate(t, allowFile)
+	if got := store.AllowChange(rc); got != ChangeUnchanged {
+		t.Errorf("repeated AllowChange() = %q, want %q", got, ChangeUnchanged)
+	}
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTime(t, allowFile); !got.Equal(past) {
+		t.Errorf("repeated Allow rewrote the allow record (mtime %v, want %v)", got, past)
+	}
+	if n := len(auditRecords(t, store)); n != 1 {
+		t.Errorf("audit log has %d records after a repeated Allow, want 1", n)
+	}
+
+	// A missing workspace mirror is a change
+	if err := os.Remove(filepath.Join(dir, ".cascade", "allow", rc.ContentHash)); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.AllowChange(rc); got != ChangeAllowed {
+		t.Errorf("AllowChange() without the workspace record = %q, want %q", got, ChangeAllowed)
+	}
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Content changed
+	rc = writeRC(t, dir, "export FOO=2\n")
+	if got := store.AllowChange(rc); got != ChangeUpdated {
+		t.Errorf("AllowChange() after a content change = %q, want %q", got, ChangeUpdated)
+	}
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.AllowChange(rc); got != ChangeUnchanged {
+		t.Errorf("AllowChange() after allowing the new content = %q, want %q", got, ChangeUnchanged)
+	}
+
+	// Denied: allowing removes the deny record
+	if err := store.Deny(rc); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.AllowChange(rc); !got.Changed() {
+		t.Errorf("AllowChange() of a denied file = %q, want a change", got)
+	}
+
+	if n := len(auditRecords(t, store)); n != 4 {
+		t.Errorf("audit log has %d records, want 4 (allow, allow, allow, deny)", n)
+	}
+}
+
+func TestDenyChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	rc := writeRC(t, dir, "export FOO=1\n")
+
+	if got := store.DenyChange(rc); got != ChangeDenied {
+		t.Errorf("first DenyChange() = %q, want %q", got, ChangeDenied)
+	}
+	if err := store.Deny(rc); err != nil {
+		t.Fatal(err)
+	}
+
+	pathHash, err := envrc.PathHash(rc.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyFile := filepath.Join(store.denyDir, pathHash)
+	past := backdate(t, denyFile)
+	if got := store.DenyChange(rc); got != ChangeUnchanged {
+		t.Errorf("repeated DenyChange() = %q, want %q", got, ChangeUnchanged)
+	}
+	if err := store.Deny(rc); err != nil {
+		t.Fatal(err)
+	}
+	if got := modTime(t, denyFile); !got.Equal(past) {
+		t.Errorf("repeated Deny rewrote the deny record (mtime %v, want %v)", got, past)
+	}
+
+	// Content changed: the deny record is by path, so it still stands
+	rc = writeRC(t, dir, "export FOO=2\n")
+	if got := store.DenyChange(rc); got != ChangeUnchanged {
+		t.Errorf("DenyChange() after a content change = %q, want %q", got, ChangeUnchanged)
+	}
+
+	// An allow record for the content has to be removed
+	if err := store.Allow(rc); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.DenyChange(rc); got != ChangeDenied {
+		t.Errorf("DenyChange() of an allowed file = %q, want %q", got, ChangeDenied)
+	}
+
+	if n := len(auditRecords(t, store)); n != 2 {
+		t.Errorf("audit log has %d records, want 2 (deny, allow)", n)
+	}
+}
+
+func TestTrustChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	subtree := filepath.Join(dir, "work")
+	if err := os.Mkdir(subtree, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := store.TrustChange(subtree); err != nil || got != ChangeTrusted {
+		t.Errorf("first TrustChange() = %q, %v; want %q", got, err, ChangeTrusted)
+	}
+	if err := store.TrustSubtree(subtree); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.TrustChange(subtree); err != nil || got != ChangeUnchanged {
+		t.Errorf("repeated TrustChange() = %q, %v; want %q", got, err, ChangeUnchanged)
+	}
+	if err := store.TrustSubtree(subtree); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(auditRecords(t, store)); n != 1 {
+		t.Errorf("audit log has %d records after a repeated TrustSubtree, want 1", n)
+	}
+
+	if _, err := store.TrustChange(filepath.Join(dir, "missing")); err == nil {
+		t.Error("TrustChange() of a missing directory succeeded")
+	}
+}
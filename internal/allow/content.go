@@ -0,0 +1,100 @@
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// saveContent keeps the content rc is allowed with in the content
+// directory, named by content hash like its allow record, so a later version
+// of the file can be diffed against it. Failures are ignored: a missing
+// copy only means there is nothing to diff against.
+func (s *Store) saveContent(rc *envrc.RC) {
+	if s.contentDir == "" {
+		return
+	}
+	writeContent(s.contentDir, rc)
+}
+
+// removeContent removes the content kept for hash, once no allow record
+// refers to it.
+func (s *Store) removeContent(hash string) {
+	if s.contentDir == "" || !validHash(hash) {
+		return
+	}
+	_ = os.Remove(filepath.Join(s.contentDir, hash))
+}
+
+// LastAllowedContent returns the content of the most recently allowed
+// earlier version of rc's file: the newest allow record for rc's path under
+// a different content hash. The content is looked up in the content
+// directory, then among the audit log's kept content. It reports false if
+// no earlier version was allowed or its content was not kept, as for files
+// allowed before content was kept.
+func (s *Store) LastAllowedContent(rc *envrc.RC) ([]byte, bool) {
+	var (
+		latest   string
+		latestAt time.Time
+	)
+	for record, err := range s.Records(KindAllow) {
+		if err != nil {
+			return nil, false
+		}
+		if record.Path != rc.Path || record.Name == rc.ContentHash || !validHash(record.Name) {
+			continue
+		}
+		if latest == "" || record.ModTime.After(latestAt) {
+			latest, latestAt = record.Name, record.ModTime
+		}
+	}
+	if latest == "" {
+		return nil, false
+	}
+
+	files := []string{}
+	if s.contentDir != "" {
+		files = append(files, filepath.Join(s.contentDir, latest))
+	}
+	if file, ok := s.AuditContentFile(latest); ok {
+		files = append(files, file)
+	}
+	for _, file := range files {
+		if content, err := os.ReadFile(file); err == nil {
+			return content, true
+		}
+	}
+	return nil, false
+}
+
+// writeContent saves the content of rc in dir, named by its content hash,
+// unless it is already there. The content is re-read and verified against
+// rc.ContentHash, so what is kept is exactly what was approved. It is
+// written to a temporary file and renamed into place, mode 0600, as the
+// content may hold secrets.
+func writeContent(dir string, rc *envrc.RC) {
+	file := filepath.Join(dir, rc.ContentHash)
+	if _, err := os.Stat(file); err == nil {
+		return
+	}
+	content, err := rc.Snapshot()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, rc.ContentHash+".tmp*")
+	if err != nil {
+		return
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, werr := tmp.Write(content)
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,89 @@
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_LastAllowedContent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+
+	v1 := writeRC(t, dir, "export V=1\n")
+	if _, ok := store.LastAllowedContent(v1); ok {
+		t.Error("LastAllowedContent found content for a file never allowed")
+	}
+	if err := store.Allow(v1); err != nil {
+		t.Fatal(err)
+	}
+	backdate(t, filepath.Join(store.allowDir, v1.ContentHash))
+	if _, ok := store.LastAllowedContent(v1); ok {
+		t.Error("LastAllowedContent returned the current version")
+	}
+
+	v2 := writeRC(t, dir, "export V=2\n")
+	if content, ok := store.LastAllowedContent(v2); !ok || string(content) != "export V=1\n" {
+		t.Errorf("LastAllowedContent(v2) = %q, %v, want v1", content, ok)
+	}
+	if err := store.Allow(v2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newest earlier version is the one to diff against
+	v3 := writeRC(t, dir, "export V=3\n")
+	if content, ok := store.LastAllowedContent(v3); !ok || string(content) != "export V=2\n" {
+		t.Errorf("LastAllowedContent(v3) = %q, %v, want v2", content, ok)
+	}
+
+	// Content is kept privately
+	info, err := os.Stat(filepath.Join(store.contentDir, v2.ContentHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("content mode = %o, want 600", perm)
+	}
+
+	// Removing an allow record removes its content
+	v2 = writeRC(t, dir, "export V=2\n")
+	if err := store.Deny(v2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(store.contentDir, v2.ContentHash)); !os.IsNotExist(err) {
+		t.Errorf("content of a denied version kept: %v", err)
+	}
+}
+
+func TestStore_LastAllowedContent_AuditFallback(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store")).WithAuditContent(true)
+
+	v1 := writeRC(t, dir, "export V=1\n")
+	if err := store.Allow(v1); err != nil {
+		t.Fatal(err)
+	}
+	// As for a record written before content was kept
+	if err := os.RemoveAll(store.contentDir); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := writeRC(t, dir, "export V=2\n")
+	if content, ok := store.LastAllowedContent(v2); !ok || string(content) != "export V=1\n" {
+		t.Errorf("LastAllowedContent(v2) = %q, %v, want v1 from the audit log", content, ok)
+	}
+
+	if _, ok := store.WithAuditContent(false).LastAllowedContent(v2); !ok {
+		t.Error("audit content not used without WithAuditContent")
+	}
+	if err := os.RemoveAll(filepath.Join(store.auditDir, auditContent)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.LastAllowedContent(v2); ok {
+		t.Error("LastAllowedContent found content that was never kept")
+	}
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// TestStore_ConcurrentAllowDeny runs Allow and Deny on the same file from
+// several goroutines, each with its own Store as separate cascade processes
+// would have, and checks that the records always end up describing one of
+// the two, never both or neither.
+func TestStore_ConcurrentAllowDeny(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "store")
+	rc := writeRC(t, dir, "export A=1\n")
+	pathHash, err := envrc.PathHash(rc.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowFile := filepath.Join(base, "allow", rc.ContentHash)
+	denyFile := filepath.Join(base, "deny", pathHash)
+
+	for round := range 300 {
+		// From no records, so neither call finds its work already done
+		if err := NewStoreWithBase(base).Revoke(rc); err != nil {
+			t.Fatal(err)
+		}
+
+		// Release the goroutines together so their changes overlap
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				store := NewStoreWithBase(base)
+				<-start
+				var err error
+				if i%2 == 0 {
+					err = store.Allow(rc)
+				} else {
+					err = store.Deny(rc)
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		allowed, denied := exists(allowFile), exists(denyFile)
+		if allowed == denied {
+			t.Fatalf("round %d: allow record %v, deny record %v, want exactly one", round, allowed, denied)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
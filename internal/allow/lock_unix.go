@@ -0,0 +1,40 @@
+//go:build unix
+
+package allow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lock takes an exclusive flock on the store's lock file, waiting for any
+// other cascade process that holds it, and returns the function that
+// releases it. The lock is released by the kernel if the process dies.
+func (s *Store) lock() (func(), error) {
+	if s.lockFile == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.lockFile), 0755); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+	f, err := os.OpenFile(s.lockFile, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open store lock: %w", err)
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock store: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package allow
+
+import "os"
+
+// fileOwner reports no owner where file ownership is not a uid, which
+// leaves the system store disabled.
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
+
+// ownedByUser reports true where file ownership is not a uid and cannot
+// be compared with the current user.
+func ownedByUser(info os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package allow
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid that owns a file.
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
+
+// ownedByUser reports whether the current user owns a file.
+func ownedByUser(info os.FileInfo) bool {
+	uid, ok := fileOwner(info)
+	return ok && uid == os.Getuid()
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package allow
+
+import "testing"
+
+func TestIsUnderPath_Windows(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		child, parent string
+		want          bool
+	}{
+		{`C:\Users\Me\project`, `C:\Users\Me`, true},
+		{`c:\users\me\project`, `C:\Users\Me`, true},
+		{`C:\USERS\ME`, `c:\users\me\`, true},
+		{`C:\Users\Me\project`, `C:\`, true},
+		{`C:\Users\Me2\project`, `C:\Users\Me`, false},
+		{`D:\Users\Me\project`, `C:\Users\Me`, false},
+		{`C:/Users/Me/project`, `C:\Users\Me`, true},
+	}
+	for _, tt := range tests {
+		if got := isUnderPath(tt.child, tt.parent); got != tt.want {
+			t.Errorf("isUnderPath(%q, %q) = %v, want %v", tt.child, tt.parent, got, tt.want)
+		}
+	}
+}
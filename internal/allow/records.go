@@ -0,0 +1,187 @@
+package allow
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// Kind names one of the record directories in a store.
+type Kind string
+
+const (
+	KindAllow Kind = "allow" // Named by content hash, holds the .envrc path
+	KindDeny  Kind = "deny"  // Named by path hash, holds the .envrc path
+	KindTrust Kind = "trust" // Named by path hash, holds the directory path
+)
+
+// RecordState says whether a record still describes what is on disk.
+type RecordState string
+
+const (
+	RecordOK      RecordState = "ok"      // The file or directory is as recorded
+	RecordChanged RecordState = "changed" // An allowed file's content no longer matches
+	RecordMissing RecordState = "missing" // The recorded path no longer exists
+)
+
+// Record is a single allow, deny, or trust entry in the global store.
+type Record struct {
+	Kind    Kind
+	Name    string    // File name: content hash (allow) or path hash (deny, trust)
+	Path    string    // Recorded .envrc or directory path
+	ModTime time.Time // When the record was written
+	File    string    // Record file in the store
+}
+
+// recordBatch is how many directory entries Records reads at a time.
+const recordBatch = 256
+
+// Records iterates over the global store's records of the given kind, in
+// directory order. The directory is read in batches, so memory use does not
+// grow with the size of the store. Unreadable records are skipped; an error
+// reading the directory itself is yielded once and ends the iteration.
+func (s *Store) Records(kind Kind) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		dir := s.kindDir(kind)
+		if dir == "" {
+			yield(Record{}, fmt.Errorf("unknown record kind %q", kind))
+			return
+		}
+
+		f, err := os.Open(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			yield(Record{}, fmt.Errorf("open %s directory: %w", kind, err))
+			return
+		}
+		defer func() { _ = f.Close() }()
+
+		for {
+			entries, err := f.ReadDir(recordBatch)
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				record, ok := readRecord(kind, dir, entry)
+				if !ok {
+					continue
+				}
+				if !yield(record, nil) {
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				yield(Record{}, fmt.Errorf("read %s directory: %w", kind, err))
+				return
+			}
+		}
+	}
+}
+
+// Entry is a path recorded in the global store with its hash: the content
+// hash an .envrc was allowed at, or the path hash of a denied one.
+type Entry struct {
+	Path string
+	Hash string
+}
+
+// ListAllows returns the .envrc files allowed in the global store with the
+// content hash each was allowed at, sorted by path. A file allowed at
+// several versions has an entry for each.
+func (s *Store) ListAllows() ([]Entry, error) {
+	return s.entries(KindAllow)
+}
+
+// ListDenies returns the .envrc files denied in the global store with
+// their path hashes, sorted by path.
+func (s *Store) ListDenies() ([]Entry, error) {
+	return s.entries(KindDeny)
+}
+
+// entries returns the path and record name of every record of kind, sorted.
+func (s *Store) entries(kind Kind) ([]Entry, error) {
+	var entries []Entry
+	for record, err := range s.Records(kind) {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Path: record.Path, Hash: record.Name})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Hash, b.Hash))
+	})
+	return entries, nil
+}
+
+// kindDir returns the global store directory holding records of kind.
+func (s *Store) kindDir(kind Kind) string {
+	switch kind {
+	case KindAllow:
+		return s.allowDir
+	case KindDeny:
+		return s.denyDir
+	case KindTrust:
+		return s.trustDir
+	default:
+		return ""
+	}
+}
+
+func readRecord(kind Kind, dir string, entry fs.DirEntry) (Record, bool) {
+	file := filepath.Join(dir, entry.Name())
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return Record{}, false
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return Record{}, false
+	}
+	return Record{
+		Kind:    kind,
+		Name:    entry.Name(),
+		Path:    string(content),
+		ModTime: info.ModTime(),
+		File:    file,
+	}, true
+}
+
+// Under reports whether the record's path is dir or below it.
+func (r Record) Under(dir string) bool {
+	return isUnderPath(r.Path, dir)
+}
+
+// State compares the record with the file system. An allow record is
+// changed when the file's content hash no longer matches; deny and trust
+// records only track whether their path still exists.
+func (r Record) State() RecordState {
+	if r.Kind == KindTrust {
+		if info, err := os.Stat(r.Path); err != nil || !info.IsDir() {
+			return RecordMissing
+		}
+		return RecordOK
+	}
+
+	rc, err := envrc.NewRC(r.Path)
+	if err != nil || !rc.Exists {
+		return RecordMissing
+	}
+	if r.Kind == KindAllow && rc.ContentHash != r.Name {
+		return RecordChanged
+	}
+	return RecordOK
+}
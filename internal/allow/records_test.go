@@ -0,0 +1,227 @@
+package allow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// setupSyntheticStore allows n .envrc files spread over two project trees,
+// then changes every third file and deletes every fifth (that is not also
+// changed). It returns the store and the expected state of each path.
+func setupSyntheticStore(t *testing.T, n int) (*Store, string, map[string]RecordState) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+	want := make(map[string]RecordState, n)
+
+	for i := range n {
+		project := filepath.Join(dir, []string{"alpha", "beta"}[i%2], fmt.Sprintf("p%03d", i))
+		if err := os.MkdirAll(project, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(project, ".envrc")
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("export N=%d\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := envrc.NewRC(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Allow(rc); err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case i%3 == 0:
+			if err := os.WriteFile(path, []byte("export N=changed\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			want[path] = RecordChanged
+		case i%5 == 0:
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			want[path] = RecordMissing
+		default:
+			want[path] = RecordOK
+		}
+	}
+
+	return store, dir, want
+}
+
+func TestRecords_ClassifiesAllowStore(t *testing.T) {
+	t.Parallel()
+
+	// More entries than one directory batch
+	store, _, want := setupSyntheticStore(t, 3*recordBatch/2)
+
+	seen := 0
+	for record, err := range store.Records(KindAllow) {
+		if err != nil {
+			t.Fatalf("Records: %v", err)
+		}
+		seen++
+		if got := record.State(); got != want[record.Path] {
+			t.Errorf("%s: State() = %q, want %q", record.Path, got, want[record.Path])
+		}
+		if record.ModTime.IsZero() {
+			t.Errorf("%s: ModTime is zero", record.Path)
+		}
+	}
+	if seen != len(want) {
+		t.Errorf("Records yielded %d records, want %d", seen, len(want))
+	}
+}
+
+func TestRecords_Under(t *testing.T) {
+	t.Parallel()
+
+	store, dir, want := setupSyntheticStore(t, 200)
+
+	alpha := filepath.Join(dir, "alpha")
+	wantUnder := 0
+	for path := range want {
+		if filepath.Dir(filepath.Dir(path)) == alpha {
+			wantUnder++
+		}
+	}
+
+	got := 0
+	for record, err := range store.Records(KindAllow) {
+		if err != nil {
+			t.Fatalf("Records: %v", err)
+		}
+		if record.Under(alpha) {
+			got++
+		}
+	}
+	if got != wantUnder || got == 0 {
+		t.Errorf("%d records under %s, want %d", got, alpha, wantUnder)
+	}
+
+	// A sibling with a common prefix is not under alpha
+	if (Record{Path: alpha + "-old/.envrc"}).Under(alpha) {
+		t.Error("Under() matched a sibling directory with the same prefix")
+	}
+}
+
+func TestRecords_DenyAndTrust(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+
+	kept := filepath.Join(dir, "kept")
+	gone := filepath.Join(dir, "gone")
+	for _, d := range []string{kept, gone} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, ".envrc"), []byte("export X=1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := envrc.NewRC(filepath.Join(d, ".envrc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Deny(rc); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.TrustSubtree(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.RemoveAll(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deny records name the .envrc, trust records its directory
+	for kind, project := range map[Kind]func(string) string{
+		KindDeny:  func(path string) string { return filepath.Base(filepath.Dir(path)) },
+		KindTrust: filepath.Base,
+	} {
+		states := make(map[string]RecordState)
+		for record, err := range store.Records(kind) {
+			if err != nil {
+				t.Fatalf("Records(%s): %v", kind, err)
+			}
+			states[project(record.Path)] = record.State()
+		}
+		if states["kept"] != RecordOK || states["gone"] != RecordMissing {
+			t.Errorf("Records(%s) states = %v, want kept ok and gone missing", kind, states)
+		}
+	}
+}
+
+func TestListAllowsAndDenies(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewStoreWithBase(filepath.Join(dir, "store"))
+
+	allowRC := func(path, content string) *envrc.RC {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := envrc.NewRC(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Allow(rc); err != nil {
+			t.Fatal(err)
+		}
+		return rc
+	}
+	b := allowRC(filepath.Join(dir, "b", ".envrc"), "export B=1\n")
+	a1 := allowRC(filepath.Join(dir, "a", ".envrc"), "export A=1\n")
+	a2 := allowRC(filepath.Join(dir, "a", ".envrc"), "export A=2\n")
+	denied := allowRC(filepath.Join(dir, "c", ".envrc"), "export C=1\n")
+	if err := store.Deny(denied); err != nil {
+		t.Fatal(err)
+	}
+
+	allows, err := store.ListAllows()
+	if err != nil {
+		t.Fatalf("ListAllows: %v", err)
+	}
+	if len(allows) != 3 || allows[2] != (Entry{Path: b.Path, Hash: b.ContentHash}) {
+		t.Fatalf("ListAllows = %v, want both versions of a, then b", allows)
+	}
+	for _, rc := range []*envrc.RC{a1, a2} {
+		if !slices.Contains(allows[:2], Entry{Path: rc.Path, Hash: rc.ContentHash}) {
+			t.Errorf("ListAllows = %v, missing %s at %s", allows, rc.Path, rc.ContentHash)
+		}
+	}
+
+	denies, err := store.ListDenies()
+	if err != nil {
+		t.Fatalf("ListDenies: %v", err)
+	}
+	pathHash, err := envrc.PathHash(denied.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Entry{{Path: denied.Path, Hash: pathHash}}; !slices.Equal(denies, want) {
+		t.Errorf("ListDenies = %v, want %v", denies, want)
+	}
+}
+
+func TestRecords_EmptyStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithBase(filepath.Join(t.TempDir(), "missing"))
+	for _, err := range store.Records(KindAllow) {
+		t.Fatalf("Records on a missing store yielded %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package allow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/gitinfo"
+	"github.com/unrss/cascade/internal/xdg"
+)
+
+// RemoteTrust recognizes .envrc files that are clean checkouts from a
+// trusted git remote, so they can be allowed without prompting.
+//
+// Positive answers are recorded by allowing the file. Negative answers
+// that only depend on where the file is, outside a work tree or in one
+// whose origin is not trusted, are recorded in decisionDir so git is not
+// asked again. A file that is untracked or modified is asked about at
+// every prompt, as committing it can make it a clean checkout without
+// changing its content hash.
+type RemoteTrust struct {
+	prefixes    []string // Trusted origin URL prefixes
+	decisionDir string   // Negative decisions, keyed by content hash
+}
+
+// errUntrustedRemote is returned by check for a work tree whose origin
+// does not start with a trusted prefix.
+var errUntrustedRemote = errors.New("origin is not a trusted remote")
+
+// errNotClean is returned by check for a file that is untracked or differs
+// from HEAD.
+var errNotClean = errors.New("not a clean checkout")
+
+// NewRemoteTrust creates a RemoteTrust for the given origin URL prefixes.
+// Negative decisions are cached in $XDG_CACHE_HOME/cascade/remote/.
+func NewRemoteTrust(prefixes []string) (*RemoteTrust, error) {
+	cacheHome, err := xdg.CacheHome()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRemoteTrustWithDir(prefixes, filepath.Join(cacheHome, "cascade", "remote")), nil
+}
+
+// NewRemoteTrustWithDir creates a RemoteTrust with a custom decision cache directory (for testing).
+func NewRemoteTrustWithDir(prefixes []string, dir string) *RemoteTrust {
+	return &RemoteTrust{prefixes: prefixes, decisionDir: dir}
+}
+
+// Match reports whether rc is tracked and unmodified in a git work tree
+// whose origin URL starts with a trusted prefix, returning that URL.
+// Files previously found outside a work tree or in an untrusted one are
+// not re-examined.
+func (r *RemoteTrust) Match(ctx context.Context, rc *envrc.RC) (string, bool) {
+	if len(r.prefixes) == 0 || !rc.Exists || rc.ContentHash == "" {
+		return "", false
+	}
+
+	decisionFile := filepath.Join(r.decisionDir, rc.ContentHash)
+	if _, err := os.Stat(decisionFile); err == nil {
+		return "", false
+	}
+
+	remote, err := r.check(ctx, rc)
+	if err != nil {
+		// Only where the file is decides; timeouts, a missing origin and
+		// uncommitted changes are asked about again next time
+		if errors.Is(err, errUntrustedRemote) || errors.Is(err, gitinfo.ErrNotRepo) {
+			// Best effort: a failed write only means we ask git again next time
+			if err := os.MkdirAll(r.decisionDir, 0o700); err == nil {
+				_ = os.WriteFile(decisionFile, []byte(rc.Path), 0o600)
+			}
+		}
+		return "", false
+	}
+
+	return remote, true
+}
+
+// check interrogates git, returning the origin URL of a clean checkout
+// from a trusted remote, or errUntrustedRemote, errNotClean, or why git
+// could not tell.
+func (r *RemoteTrust) check(ctx context.Context, rc *envrc.RC) (string, error) {
+	remote, err := gitinfo.OriginURL(ctx, rc.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	trusted := false
+	for _, prefix := range r.prefixes {
+		if prefix != "" && strings.HasPrefix(remote, prefix) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return "", errUntrustedRemote
+	}
+
+	clean, err := gitinfo.IsClean(ctx, rc.Path)
+	if err != nil {
+		return "", err
+	}
+	if !clean {
+		return "", errNotClean
+	}
+
+	return remote, nil
+}
@@ -0,0 +1,124 @@
+package allow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// systemOwner is the uid that must own the system store.
+const systemOwner = 0
+
+// systemStore is a read-only allow/deny/trust store provisioned by an
+// administrator (e.g. /usr/local/share/cascade) and shared by every user on
+// the machine. It has the same layout as the user store, so an admin can
+// populate it with: XDG_DATA_HOME=/usr/local/share cascade allow <file>.
+type systemStore struct {
+	dir string
+}
+
+// WithSystem returns a copy of the Store that also consults the system store
+// in dir, read-only, after the user's own records. The layer is ignored
+// unless CheckSystemDir accepts dir; an empty dir disables it.
+//
+// Writes (Allow, Deny, Revoke, TrustSubtree) only ever touch the user store.
+func (s *Store) WithSystem(dir string) *Store {
+	return s.withSystem(dir, systemOwner)
+}
+
+func (s *Store) withSystem(dir string, owner int) *Store {
+	cp := *s
+	cp.system = nil
+	if dir != "" && checkSystemDir(dir, owner) == nil {
+		cp.system = &systemStore{dir: dir}
+	}
+	return &cp
+}
+
+// SystemDir returns the system store directory in use, or empty if the
+// layer is disabled or was rejected.
+func (s *Store) SystemDir() string {
+	if s.system == nil {
+		return ""
+	}
+	return s.system.dir
+}
+
+// ErrNoSystemDir is returned by CheckSystemDir when dir does not exist.
+var ErrNoSystemDir = errors.New("system store does not exist")
+
+// CheckSystemDir reports why dir cannot be trusted as a system store: it and
+// its allow, deny and trust subdirectories must be owned by root and must
+// not be group- or world-writable, since anyone able to write there could
+// approve code for every user.
+func CheckSystemDir(dir string) error {
+	return checkSystemDir(dir, systemOwner)
+}
+
+func checkSystemDir(dir string, owner int) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoSystemDir
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := checkSystemPerms(dir, info, owner); err != nil {
+		return err
+	}
+
+	for _, kind := range []string{"allow", "deny", "trust"} {
+		sub := filepath.Join(dir, kind)
+		info, err := os.Stat(sub)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkSystemPerms(sub, info, owner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkSystemPerms(path string, info os.FileInfo, owner int) error {
+	uid, ok := fileOwner(info)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of %s", path)
+	}
+	if uid != owner {
+		return fmt.Errorf("%s is owned by uid %d, not %d", path, uid, owner)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by other users (%o)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// has reports whether a record named name exists in the given kind
+// subdirectory ("allow" or "deny").
+func (sys *systemStore) has(kind, name string) bool {
+	_, err := os.Stat(filepath.Join(sys.dir, kind, name))
+	return err == nil
+}
+
+// isTrusted reports whether path is under a subtree the system store trusts.
+func (sys *systemStore) isTrusted(path string) bool {
+	trusted, err := listTrusted(filepath.Join(sys.dir, "trust"))
+	if err != nil {
+		return false
+	}
+	for _, dir := range trusted {
+		if isUnderPath(path, dir) {
+			return true
+		}
+	}
+	return false
+}
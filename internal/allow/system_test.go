@@ -0,0 +1,196 @@
+package allow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// setupSystemStore returns a user store and a system store directory owned
+// by the current user, plus an .envrc outside both.
+func setupSystemStore(t *testing.T) (*Store, string, *envrc.RC) {
+	t.Helper()
+
+	dir := t.TempDir()
+	systemDir := filepath.Join(dir, "system")
+	if err := os.MkdirAll(systemDir, 0o755); err != nil {
+		t.Fatalf("mkdir system: %v", err)
+	}
+
+	project := filepath.Join(dir, "courses", "intro")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatalf("mkdir project: %v", err)
+	}
+	envrcPath := filepath.Join(project, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export COURSE=intro"), 0o644); err != nil {
+		t.Fatalf("write envrc: %v", err)
+	}
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	return NewStoreWithBase(filepath.Join(dir, "user")), systemDir, rc
+}
+
+func TestSystemStore_Precedence(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		user       []string // "allow", "deny", "trust"
+		system     []string
+		wantStatus AllowStatus
+		wantSource Source
+	}{
+		{"no records", nil, nil, NotAllowed, SourceNone},
+		{"system allow", nil, []string{"allow"}, Allowed, SourceSystem},
+		{"system deny", nil, []string{"deny"}, Denied, SourceSystem},
+		{"system trust", nil, []string{"trust"}, Allowed, SourceSystem},
+		{"user deny beats system allow", []string{"deny"}, []string{"allow"}, Denied, SourceGlobal},
+		{"system deny beats user allow", []string{"allow"}, []string{"deny"}, Denied, SourceSystem},
+		{"system deny beats user trust", []string{"trust"}, []string{"deny"}, Denied, SourceSystem},
+		{"user allow is redundant with system allow", []string{"allow"}, []string{"allow"}, Allowed, SourceGlobal},
+		{"system allow beats user trust", []string{"trust"}, []string{"allow"}, Allowed, SourceSystem},
+		{"user trust beats system trust", []string{"trust"}, []string{"trust"}, Allowed, SourceTrust},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			user, systemDir, rc := setupSystemStore(t)
+			projectDir := filepath.Dir(rc.Path)
+
+			// Records are written with a plain Store on each layer's directory
+			system := NewStoreWithBase(systemDir)
+			for _, layer := range []struct {
+				store   *Store
+				records []string
+			}{{user, tt.user}, {system, tt.system}} {
+				for _, record := range layer.records {
+					var err error
+					switch record {
+					case "allow":
+						err = layer.store.Allow(rc)
+					case "deny":
+						err = layer.store.Deny(rc)
+					case "trust":
+						err = layer.store.TrustSubtree(projectDir)
+					}
+					if err != nil {
+						t.Fatalf("%s: %v", record, err)
+					}
+				}
+			}
+
+			store := user.withSystem(systemDir, os.Getuid())
+			if store.SystemDir() != systemDir {
+				t.Fatalf("system store rejected: %v", checkSystemDir(systemDir, os.Getuid()))
+			}
+
+			status, source := store.Explain(rc, nil)
+			if status != tt.wantStatus || source != tt.wantSource {
+				t.Errorf("Explain() = %v, %q, want %v, %q", status, source, tt.wantStatus, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestSystemStore_WritesTargetUserStore(t *testing.T) {
+	t.Parallel()
+
+	user, systemDir, rc := setupSystemStore(t)
+	store := user.withSystem(systemDir, os.Getuid())
+
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if err := store.Deny(rc); err != nil {
+		t.Fatalf("Deny: %v", err)
+	}
+
+	entries, err := os.ReadDir(systemDir)
+	if err != nil {
+		t.Fatalf("read system dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("system store was written to: %v", entries)
+	}
+}
+
+func TestSystemStore_RejectsUnsafeDir(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, dir string)
+		owner   int
+		wantErr string
+	}{
+		{
+			name:    "wrong owner",
+			prepare: func(t *testing.T, dir string) {},
+			owner:   os.Getuid() + 1,
+			wantErr: "owned by uid",
+		},
+		{
+			name: "world-writable store",
+			prepare: func(t *testing.T, dir string) {
+				if err := os.Chmod(dir, 0o777); err != nil {
+					t.Fatal(err)
+				}
+			},
+			owner:   os.Getuid(),
+			wantErr: "writable by other users",
+		},
+		{
+			name: "group-writable allow directory",
+			prepare: func(t *testing.T, dir string) {
+				if err := os.Chmod(filepath.Join(dir, "allow"), 0o775); err != nil {
+					t.Fatal(err)
+				}
+			},
+			owner:   os.Getuid(),
+			wantErr: "writable by other users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			user, systemDir, rc := setupSystemStore(t)
+			if err := NewStoreWithBase(systemDir).Allow(rc); err != nil {
+				t.Fatalf("Allow: %v", err)
+			}
+			tt.prepare(t, systemDir)
+
+			err := checkSystemDir(systemDir, tt.owner)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkSystemDir() = %v, want error containing %q", err, tt.wantErr)
+			}
+
+			// A rejected store is ignored entirely
+			store := user.withSystem(systemDir, tt.owner)
+			if store.SystemDir() != "" {
+				t.Errorf("SystemDir() = %q, want empty", store.SystemDir())
+			}
+			if status := store.Check(rc); status != NotAllowed {
+				t.Errorf("Check() = %v, want %v", status, NotAllowed)
+			}
+		})
+	}
+}
+
+func TestCheckSystemDir_Missing(t *testing.T) {
+	t.Parallel()
+
+	err := CheckSystemDir(filepath.Join(t.TempDir(), "missing"))
+	if err != ErrNoSystemDir {
+		t.Errorf("CheckSystemDir() = %v, want ErrNoSystemDir", err)
+	}
+}
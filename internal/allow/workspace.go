@@ -0,0 +1,144 @@
+package allow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/unrss/cascade/internal/gitinfo"
+)
+
+// workspaceStore is an allow/deny store kept inside a workspace directory
+// (e.g. <workspace>/.cascade/) so records survive when the global data
+// directory is recreated, as happens on devcontainer rebuilds.
+type workspaceStore struct {
+	root string // Workspace root (directory containing the store)
+	dir  string // Store directory (<root>/<name>)
+}
+
+// WithWorkspace returns a copy of the Store that also consults and records
+// into an in-workspace store named name (a relative path such as ".cascade").
+// The workspace root for an .envrc is the nearest ancestor directory that
+// contains a directory called name. An empty or non-local name disables it.
+func (s *Store) WithWorkspace(name string) *Store {
+	cp := *s
+	cp.workspace = ""
+	if name != "" && filepath.IsLocal(name) {
+		cp.workspace = filepath.Clean(name)
+	}
+	return &cp
+}
+
+// WorkspaceRoot returns the workspace root that applies to path, if any.
+func (s *Store) WorkspaceRoot(path string) (string, bool) {
+	ws, ok := s.workspaceFor(path)
+	if !ok {
+		return "", false
+	}
+	return ws.root, true
+}
+
+// trackedStores remembers, by store directory, whether git tracks
+// anything in a workspace store, so git is asked once per process.
+var trackedStores sync.Map
+
+// workspaceFor locates the usable workspace store for an .envrc path.
+//
+// The store is never consulted for files outside the workspace root: the
+// symlink-resolved path must still be under the root. A store directory
+// that is a symlink, is world-writable, or is not owned by the current
+// user is ignored entirely. So is one git tracks anything in, or cannot
+// tell about: records committed to a repository would otherwise allow its
+// .envrc files on every clone.
+func (s *Store) workspaceFor(path string) (*workspaceStore, bool) {
+	if s.workspace == "" {
+		return nil, false
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+
+	for dir := filepath.Dir(absPath); ; {
+		candidate := filepath.Join(dir, s.workspace)
+		if info, err := os.Lstat(candidate); err == nil && info.IsDir() {
+			if !isUnderPath(resolvePath(absPath), resolvePath(dir)) {
+				return nil, false
+			}
+			if info.Mode().Perm()&0o002 != 0 || !ownedByUser(info) || storeTracked(candidate) {
+				return nil, false
+			}
+			return &workspaceStore{root: dir, dir: candidate}, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, false
+		}
+		dir = parent
+	}
+}
+
+// storeTracked reports whether git tracks any file in the store directory
+// dir, or could not tell.
+func storeTracked(dir string) bool {
+	if tracked, ok := trackedStores.Load(dir); ok {
+		return tracked.(bool)
+	}
+	tracked, err := gitinfo.Tracked(context.Background(), dir)
+	if err != nil {
+		return true // Not remembered; ask again next time
+	}
+	trackedStores.Store(dir, tracked)
+	return tracked
+}
+
+// has reports whether a record named name exists in the given kind
+// subdirectory ("allow" or "deny"). A subdirectory that is world-writable
+// or not owned by the current user is ignored, and so is a record that is
+// not a regular file owned by the current user.
+func (w *workspaceStore) has(kind, name string) bool {
+	sub := filepath.Join(w.dir, kind)
+	info, err := os.Lstat(sub)
+	if err != nil || !info.IsDir() || info.Mode().Perm()&0o002 != 0 || !ownedByUser(info) {
+		return false
+	}
+	info, err = os.Lstat(filepath.Join(sub, name))
+	return err == nil && info.Mode().IsRegular() && ownedByUser(info)
+}
+
+// path returns the file of the record name in the given kind subdirectory.
+func (w *workspaceStore) path(kind, name string) string {
+	return filepath.Join(w.dir, kind, name)
+}
+
+// write records name in the given kind subdirectory with content.
+func (w *workspaceStore) write(kind, name, content string) error {
+	sub := filepath.Join(w.dir, kind)
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		return fmt.Errorf("create %s directory: %w", kind, err)
+	}
+	return os.WriteFile(filepath.Join(sub, name), []byte(content), 0o644)
+}
+
+// remove deletes a record, ignoring records that do not exist.
+func (w *workspaceStore) remove(kind, name string) error {
+	err := os.Remove(filepath.Join(w.dir, kind, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resolvePath evaluates symlinks in path, returning it unchanged on error.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
@@ -0,0 +1,285 @@
+package allow
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// setupWorkspace creates a workspace with a .cascade store and an .envrc
+// in a project subdirectory, returning the workspace root and the RC.
+func setupWorkspace(t *testing.T) (string, *envrc.RC) {
+	t.Helper()
+
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, "workspace")
+	project := filepath.Join(workspace, "project")
+
+	if err := os.MkdirAll(filepath.Join(workspace, ".cascade"), 0o755); err != nil {
+		t.Fatalf("mkdir store: %v", err)
+	}
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatalf("mkdir project: %v", err)
+	}
+
+	envrcPath := filepath.Join(project, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export FOO=bar"), 0o644); err != nil {
+		t.Fatalf("write envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	return workspace, rc
+}
+
+func TestWorkspace_SurvivesGlobalStoreWipe(t *testing.T) {
+	t.Parallel()
+
+	workspace, rc := setupWorkspace(t)
+	globalDir := filepath.Join(t.TempDir(), "global")
+
+	store := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(workspace, ".cascade", "allow", rc.ContentHash)); err != nil {
+		t.Fatalf("workspace allow file not written: %v", err)
+	}
+
+	// Simulate a container rebuild: the global store is gone
+	if err := os.RemoveAll(globalDir); err != nil {
+		t.Fatalf("remove global store: %v", err)
+	}
+
+	rebuilt := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	status, source := rebuilt.Explain(rc, nil)
+	if status != Allowed || source != SourceWorkspace {
+		t.Errorf("Explain() = %v, %q, want Allowed, %q", status, source, SourceWorkspace)
+	}
+
+	// Without the workspace layer the file is no longer allowed
+	if got := NewStoreWithBase(globalDir).Check(rc); got != NotAllowed {
+		t.Errorf("Check() without workspace = %v, want NotAllowed", got)
+	}
+}
+
+func TestWorkspace_GlobalDenyWins(t *testing.T) {
+	t.Parallel()
+
+	_, rc := setupWorkspace(t)
+	globalDir := filepath.Join(t.TempDir(), "global")
+
+	store := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+
+	// Deny only in the global store; the workspace allow remains
+	if err := NewStoreWithBase(globalDir).Deny(rc); err != nil {
+		t.Fatalf("Deny: %v", err)
+	}
+
+	status, source := store.Explain(rc, nil)
+	if status != Denied || source != SourceGlobal {
+		t.Errorf("Explain() = %v, %q, want Denied, %q", status, source, SourceGlobal)
+	}
+}
+
+func TestWorkspace_DenyRecordedInWorkspace(t *testing.T) {
+	t.Parallel()
+
+	_, rc := setupWorkspace(t)
+	globalDir := filepath.Join(t.TempDir(), "global")
+
+	store := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if err := store.Deny(rc); err != nil {
+		t.Fatalf("Deny: %v", err)
+	}
+	if err := os.RemoveAll(globalDir); err != nil {
+		t.Fatalf("remove global store: %v", err)
+	}
+
+	status, source := store.Explain(rc, nil)
+	if status != Denied || source != SourceWorkspace {
+		t.Errorf("Explain() = %v, %q, want Denied, %q", status, source, SourceWorkspace)
+	}
+}
+
+func TestWorkspace_IgnoresFilesOutsideRoot(t *testing.T) {
+	t.Parallel()
+
+	workspace, _ := setupWorkspace(t)
+
+	// An .envrc outside the workspace, symlinked into it
+	outside := filepath.Join(filepath.Dir(workspace), "outside")
+	if err := os.MkdirAll(outside, 0o755); err != nil {
+		t.Fatalf("mkdir outside: %v", err)
+	}
+	target := filepath.Join(outside, ".envrc")
+	if err := os.WriteFile(target, []byte("export EVIL=1"), 0o644); err != nil {
+		t.Fatalf("write outside envrc: %v", err)
+	}
+	linkDir := filepath.Join(workspace, "linked")
+	if err := os.MkdirAll(linkDir, 0o755); err != nil {
+		t.Fatalf("mkdir linked: %v", err)
+	}
+	link := filepath.Join(linkDir, ".envrc")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	store := NewStoreWithBase(filepath.Join(t.TempDir(), "global")).WithWorkspace(".cascade")
+
+	for _, path := range []string{target, link} {
+		rc, err := envrc.NewRC(path)
+		if err != nil {
+			t.Fatalf("NewRC(%s): %v", path, err)
+		}
+
+		// Plant a record in the workspace store as if an attacker had written it
+		allowDir := filepath.Join(workspace, ".cascade", "allow")
+		if err := os.MkdirAll(allowDir, 0o755); err != nil {
+			t.Fatalf("mkdir allow: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(allowDir, rc.ContentHash), []byte(rc.Path), 0o644); err != nil {
+			t.Fatalf("plant allow: %v", err)
+		}
+
+		if status := store.Check(rc); status != NotAllowed {
+			t.Errorf("Check(%s) = %v, want NotAllowed", path, status)
+		}
+	}
+}
+
+func TestWorkspace_WorldWritableStoreIgnored(t *testing.T) {
+	t.Parallel()
+
+	workspace, rc := setupWorkspace(t)
+	globalDir := filepath.Join(t.TempDir(), "global")
+
+	store := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if err := os.RemoveAll(globalDir); err != nil {
+		t.Fatalf("remove global store: %v", err)
+	}
+
+	if err := os.Chmod(filepath.Join(workspace, ".cascade"), 0o777); err != nil { //nolint:gosec // testing world-writable rejection
+		t.Fatalf("chmod: %v", err)
+	}
+
+	if status := store.Check(rc); status != NotAllowed {
+		t.Errorf("Check() = %v, want NotAllowed for world-writable store", status)
+	}
+}
+
+func TestWorkspace_ForeignRecordsIgnored(t *testing.T) {
+	t.Parallel()
+
+	workspace, rc := setupWorkspace(t)
+	globalDir := filepath.Join(t.TempDir(), "global")
+
+	store := NewStoreWithBase(globalDir).WithWorkspace(".cascade")
+	if err := store.Allow(rc); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if err := os.RemoveAll(globalDir); err != nil {
+		t.Fatalf("remove global store: %v", err)
+	}
+
+	// A symlink is not a record, wherever it points
+	record := filepath.Join(workspace, ".cascade", "allow", rc.ContentHash)
+	target := filepath.Join(workspace, "record")
+	if err := os.Rename(record, target); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, record); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if status := store.Check(rc); status != NotAllowed {
+		t.Errorf("Check() = %v, want NotAllowed for a symlinked record", status)
+	}
+	if err := os.Remove(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(target, record); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor is a record another user wrote
+	if os.Getuid() != 0 {
+		return
+	}
+	if err := os.Chown(record, 12345, 12345); err != nil {
+		t.Fatalf("chown: %v", err)
+	}
+	if status := store.Check(rc); status != NotAllowed {
+		t.Errorf("Check() = %v, want NotAllowed for a record owned by another user", status)
+	}
+	if err := os.Chown(record, 0, 0); err != nil {
+		t.Fatalf("chown: %v", err)
+	}
+	if err := os.Chown(filepath.Join(workspace, ".cascade"), 12345, 12345); err != nil {
+		t.Fatalf("chown: %v", err)
+	}
+	if status := store.Check(rc); status != NotAllowed {
+		t.Errorf("Check() = %v, want NotAllowed for a store owned by another user", status)
+	}
+}
+
+func TestWorkspace_TrackedStoreIgnored(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	workspace, rc := setupWorkspace(t)
+
+	// A record the repository ships, as after a clone
+	records := filepath.Join(workspace, ".cascade", "allow")
+	if err := os.MkdirAll(records, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(records, rc.ContentHash), []byte(rc.Path), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", ".cascade"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workspace
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	store := NewStoreWithBase(filepath.Join(t.TempDir(), "global")).WithWorkspace(".cascade")
+	if status := store.Check(rc); status != NotAllowed {
+		t.Errorf("Check() = %v, want NotAllowed for a store tracked in git", status)
+	}
+	if _, ok := store.WorkspaceRoot(rc.Path); ok {
+		t.Error("WorkspaceRoot() found a store tracked in git")
+	}
+}
+
+func TestWithWorkspace_RejectsNonLocalNames(t *testing.T) {
+	t.Parallel()
+
+	_, rc := setupWorkspace(t)
+
+	for _, name := range []string{"", "/tmp/store", "../store"} {
+		store := NewStoreWithBase(t.TempDir()).WithWorkspace(name)
+		if _, ok := store.WorkspaceRoot(rc.Path); ok {
+			t.Errorf("WithWorkspace(%q) enabled a workspace store", name)
+		}
+	}
+}
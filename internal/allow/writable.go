@@ -0,0 +1,41 @@
+package allow
+
+import (
+	"fmt"
+	"os"
+)
+
+// DataDirEnv overrides the directory NewStore keeps the store in, for
+// machines where $XDG_DATA_HOME cannot be written.
+const DataDirEnv = "CASCADE_DATA_DIR"
+
+// NotWritableError reports a store directory that cannot be created or
+// written to, as on a read-only filesystem. Records already in it can still
+// be read, but nothing can be allowed, denied or trusted.
+type NotWritableError struct {
+	Dir string // The store's base directory
+	Err error  // What creating the directory or a file in it failed with
+}
+
+func (e *NotWritableError) Error() string {
+	return fmt.Sprintf("allow store %s is not writable: %v", e.Dir, e.Err)
+}
+
+func (e *NotWritableError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeWritable creates dir if needed and checks that a file can be created
+// in it, returning a *NotWritableError if not.
+func ProbeWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return &NotWritableError{Dir: dir, Err: err}
+	}
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return &NotWritableError{Dir: dir, Err: err}
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return nil
+}
@@ -0,0 +1,56 @@
+package allow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeWritable(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "cascade")
+	if err := ProbeWritable(dir); err != nil {
+		t.Fatalf("ProbeWritable(new dir) = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("probe left files behind: %v", entries)
+	}
+
+	// A directory that cannot be created, as under a regular file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	blocked := filepath.Join(file, "cascade")
+	var notWritable *NotWritableError
+	if err := ProbeWritable(blocked); !errors.As(err, &notWritable) || notWritable.Dir != blocked {
+		t.Errorf("ProbeWritable(%s) = %v, want a *NotWritableError for it", blocked, err)
+	}
+}
+
+func TestNewStore_NotWritable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(DataDirEnv, filepath.Join(file, "cascade"))
+
+	// The store can still be read
+	store, err := NewStore()
+	var notWritable *NotWritableError
+	if !errors.As(err, &notWritable) {
+		t.Fatalf("NewStore() error = %v, want a *NotWritableError", err)
+	}
+	if store == nil {
+		t.Fatal("NewStore returned no store to read")
+	}
+	if status := store.Check(writeRC(t, t.TempDir(), "export A=1\n")); status != NotAllowed {
+		t.Errorf("Check = %v, want not allowed", status)
+	}
+}
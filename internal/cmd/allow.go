@@ -1,56 +1,302 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/unrss/cascade/internal/allow"
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/textdiff"
 )
 
 func newAllowCmd() *cobra.Command {
-	var recursive bool
+	var (
+		recursive     bool
+		checkMode     bool
+		all           bool
+		includeDenied bool
+		yes           bool
+		showDiffOnly  bool
+		fromStdin     bool
+		printHash     bool
+		listOpts      recordListOptions
+	)
 
 	cmd := &cobra.Command{
 		Use:   "allow [path]",
 		Short: "Allow an .envrc file to be loaded",
 		Long: `Mark an .envrc file as trusted, allowing it to be evaluated.
-If no path is provided, defaults to ./.envrc in the current directory.
+If no path is provided, defaults to ./.envrc in the current directory. A
+directory stands for the .envrc in it.
 
-Use --recursive to trust all .envrc files under a directory.`,
-		Args: cobra.MaximumNArgs(1),
+Use --recursive to trust all .envrc files under a directory.
+
+Use --all to allow every .envrc in the chain from the cascade root to the
+current directory that is not allowed yet. Each file is shown with a short
+preview before one confirmation (skip it with --yes). Denied files are left
+alone unless --include-denied is given; a deny from the system store is never
+overridden.
+
+Use --list to show every allowed file with its state: ok, changed (the
+content no longer matches what was allowed), or missing.
+
+Allowing prints one line, "cascade: <status> <path>", where status is
+allowed (first allow for the path), updated (an earlier version was
+allowed), or unchanged (already allowed; nothing is written or audited).
+--check-mode writes nothing and reports would-allow, would-update, or
+unchanged instead, and --json prints {"changed": ..., "status": ...}.
+Both exit 0 whether or not there is a change.
+
+When an earlier version of the file was allowed and stdin is a terminal,
+allow shows a diff from the last allowed content and asks before allowing.
+--show-diff-only prints that diff (the whole file if it was never allowed)
+and exits 1 without allowing, or exits 0 with no output if the file is
+already allowed.
+
+Use --stdin to allow each .envrc listed on standard input, one path per
+line; blank lines and lines starting with # are skipped. Every file is
+tried, with a line for each and a summary at the end, and the exit status
+is 1 if any of them could not be allowed.
+
+Use --print-hash to print the content hash of the file without allowing
+it: the hash allow records, and that cascade audit shows.`,
+		Example: `  cascade allow                     # Allow ./.envrc
+  cascade allow ~/work/api/.envrc
+  cascade allow ~/work/api          # Same, by directory
+  cascade allow --recursive ~/work  # Allow every .envrc under ~/work
+  cascade allow --all               # Allow the rest of the current chain
+  cascade allow --all --yes         # ... without asking, for scripts
+  cascade allow --list --stale      # Allowed files that changed or went missing
+  cascade allow --check-mode --json # Would allowing ./.envrc change anything?
+  cascade allow --show-diff-only    # What changed since ./.envrc was allowed?
+  cascade allow --print-hash        # Hash to look for in cascade audit
+  cascade allow --stdin < envrcs.txt`,
+		Annotations:       map[string]string{envAnnotation: dataEnv},
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChainEnvrc(isNotAllowed),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Create allow store
-			store, err := allow.NewStore()
+			store, err := newAllowStore()
 			if err != nil {
 				return fmt.Errorf("create allow store: %w", err)
 			}
 
+			if fromStdin {
+				switch {
+				case len(args) > 0:
+					return errors.New("--stdin cannot be used with a path")
+				case all, recursive, listOpts.list, checkMode, listOpts.json, showDiffOnly, printHash:
+					return errors.New("--stdin cannot be used with --all, --recursive, --list, --check-mode, --json, --show-diff-only or --print-hash")
+				}
+				store = store.WithTrigger("cascade allow --stdin")
+				return runBatch(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), "allowed", func(path string) error {
+					return allowListed(cmd.OutOrStdout(), cmd.ErrOrStderr(), store, path)
+				})
+			}
+			if all {
+				switch {
+				case len(args) > 0:
+					return errors.New("--all cannot be used with a path")
+				case recursive, listOpts.list, checkMode, listOpts.json, showDiffOnly, printHash:
+					return errors.New("--all cannot be used with --recursive, --list, --check-mode, --json, --show-diff-only or --print-hash")
+				}
+				return runAllowAll(cmd.InOrStdin(), cmd.OutOrStdout(), store, includeDenied, yes)
+			}
+			if includeDenied || yes {
+				return errors.New("--include-denied and --yes need --all")
+			}
+			if showDiffOnly && (recursive || listOpts.list || checkMode || listOpts.json) {
+				return errors.New("--show-diff-only cannot be used with --recursive, --list, --check-mode or --json")
+			}
+			if printHash {
+				if recursive || listOpts.list || checkMode || listOpts.json || showDiffOnly {
+					return errors.New("--print-hash cannot be used with --recursive, --list, --check-mode, --json or --show-diff-only")
+				}
+				return printAllowHash(cmd.OutOrStdout(), args)
+			}
+			if listOpts.list {
+				if checkMode {
+					return errors.New("--check-mode cannot be used with --list")
+				}
+				return runRecordList(cmd.OutOrStdout(), args, store, allow.KindAllow, listOpts)
+			}
 			if recursive {
-				return runAllowRecursive(cmd, args, store)
+				return runAllowRecursive(cmd, args, store, checkMode, listOpts.json)
 			}
-			return runAllowSingle(cmd, args, store)
+			return runAllowSingle(cmd, args, store, checkMode, listOpts.json, showDiffOnly)
 		},
 	}
 
 	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false,
 		"Trust all .envrc files under this directory")
+	cmd.Flags().BoolVar(&all, "all", false,
+		"Allow every .envrc in the current chain that is not allowed")
+	cmd.Flags().BoolVar(&includeDenied, "include-denied", false,
+		"With --all, also allow files that were denied")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false,
+		"With --all, allow without asking for confirmation")
+	cmd.Flags().BoolVar(&showDiffOnly, "show-diff-only", false,
+		"Print the diff from the last allowed content and exit 1 without allowing")
+	cmd.Flags().BoolVar(&fromStdin, "stdin", false,
+		"Allow the .envrc files listed on stdin, one per line")
+	cmd.Flags().BoolVar(&printHash, "print-hash", false,
+		"Print the content hash of the file without allowing it")
+	addRecordListFlags(cmd, &listOpts, "allowed .envrc files")
+	addCheckModeFlag(cmd, &checkMode)
 
 	return cmd
 }
 
-func runAllowSingle(cmd *cobra.Command, args []string, store *allow.Store) error {
+// dataDirEnv overrides the location of the allow store, like --data-dir,
+// and of state.
+const dataDirEnv = allow.DataDirEnv
+
+// allowDataDir returns the allow store directory set by --data-dir or else
+// CASCADE_DATA_DIR, made absolute, and the name of the one that set it. It
+// returns "", "" if neither is set and the store is in its default place.
+// The cache is not affected, and state only by CASCADE_DATA_DIR.
+func allowDataDir() (dir, source string) {
+	switch {
+	case dataDirFlag != "":
+		dir, source = dataDirFlag, "--data-dir"
+	case os.Getenv(dataDirEnv) != "":
+		dir, source = os.Getenv(dataDirEnv), dataDirEnv
+	default:
+		return "", ""
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir, source
+}
+
+// openAllowStore creates the allow store with config-driven layers applied.
+// A store whose directory cannot be written is still returned, for reading,
+// along with its *allow.NotWritableError.
+func openAllowStore() (*allow.Store, error) {
+	var store *allow.Store
+	var err error
+	if dir, _ := allowDataDir(); dir != "" {
+		store, err = allow.NewStoreWithBase(dir), allow.ProbeWritable(dir)
+	} else {
+		store, err = allow.NewStore()
+	}
+	if store == nil {
+		return nil, err
+	}
+	store = store.WithWorkspace(cfg.WorkspaceStore).WithSystem(cfg.SystemDataDir)
+	return store.WithAuditContent(cfg.AuditKeepContent).WithAuditMaxSize(int64(cfg.AuditMaxSizeMB) << 20), err
+}
+
+// newAllowStore is openAllowStore for commands that change the store: one
+// that cannot be written is an error saying how to move it.
+func newAllowStore() (*allow.Store, error) {
+	store, err := openAllowStore()
+	var notWritable *allow.NotWritableError
+	if errors.As(err, &notWritable) {
+		return nil, notWritableHint(notWritable)
+	}
+	return store, err
+}
+
+// readAllowStore is openAllowStore for commands that only read the store,
+// which works whether or not it can be written.
+func readAllowStore() (*allow.Store, error) {
+	store, err := openAllowStore()
+	var notWritable *allow.NotWritableError
+	if errors.As(err, &notWritable) {
+		return store, nil
+	}
+	return store, err
+}
+
+// peekAllowStore is readAllowStore for commands that must not write
+// anything, such as chain in a prompt: it skips the writability probe,
+// which creates the store's directory.
+func peekAllowStore() (*allow.Store, error) {
+	dir, _ := allowDataDir()
+	if dir == "" {
+		var err error
+		if dir, err = allow.DefaultBaseDir(); err != nil {
+			return nil, err
+		}
+	}
+	return allow.NewStoreWithBase(dir).WithWorkspace(cfg.WorkspaceStore).WithSystem(cfg.SystemDataDir), nil
+}
+
+// storeNotWritable returns the reason the allow store cannot be written,
+// with how to move it, or nil if it can be.
+func storeNotWritable() error {
+	_, err := openAllowStore()
+	var notWritable *allow.NotWritableError
+	if errors.As(err, &notWritable) {
+		return notWritableHint(notWritable)
+	}
+	return nil
+}
+
+// notWritableHint adds to err what to do about it: point CASCADE_DATA_DIR,
+// or the option that placed the store, at a writable directory.
+func notWritableHint(err *allow.NotWritableError) error {
+	if _, source := allowDataDir(); source != "" {
+		return fmt.Errorf("%w (set by %s; choose a writable directory)", err, source)
+	}
+	return fmt.Errorf("%w (set %s to a writable directory to keep the store there instead)", err, dataDirEnv)
+}
+
+// envrcArg resolves the path argument of allow and deny to an absolute
+// path: ./.envrc without one, and the .envrc in it for a directory, which
+// must have one.
+func envrcArg(args []string) (string, error) {
 	path := ".envrc"
 	if len(args) > 0 {
 		path = args[0]
 	}
-
-	// Resolve to absolute path
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return fmt.Errorf("resolve path: %w", err)
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	if !isDir(absPath) {
+		return absPath, nil
+	}
+	rcPath := filepath.Join(absPath, ".envrc")
+	if _, err := os.Lstat(rcPath); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("no .envrc in %s", absPath)
+	}
+	return rcPath, nil
+}
+
+// printAllowHash prints the content hash allow would record for the file
+// in args, ./.envrc by default.
+func printAllowHash(w io.Writer, args []string) error {
+	absPath, err := envrcArg(args)
+	if err != nil {
+		return err
+	}
+	rc, err := envrc.NewRC(absPath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if !rc.Exists {
+		return fmt.Errorf("file does not exist: %s", absPath)
+	}
+	fmt.Fprintln(w, rc.ContentHash)
+	return nil
+}
+
+func runAllowSingle(cmd *cobra.Command, args []string, store *allow.Store, checkMode, jsonOutput, showDiffOnly bool) error {
+	absPath, err := envrcArg(args)
+	if err != nil {
+		return err
 	}
 
 	// Create RC to validate file exists and compute hash
@@ -63,16 +309,41 @@ func runAllowSingle(cmd *cobra.Command, args []string, store *allow.Store) error
 		return fmt.Errorf("file does not exist: %s", absPath)
 	}
 
+	change := store.AllowChange(rc)
+	if showDiffOnly {
+		return showAllowDiff(cmd.OutOrStdout(), store, rc, change)
+	}
+	if checkMode {
+		return printChange(cmd.OutOrStdout(), change, rc.Path, true, jsonOutput)
+	}
+
+	// Show what changed before re-allowing, when someone can answer
+	if change == allow.ChangeUpdated && !jsonOutput && isTerminal(cmd.InOrStdin()) {
+		ok, err := confirmAllowDiff(cmd.InOrStdin(), cmd.OutOrStdout(), store, rc)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(cmd.ErrOrStderr(), "cascade: %s was not allowed\n", rc.Path)
+			return nil
+		}
+	}
+
 	// Allow the file
-	if err := store.Allow(rc); err != nil {
+	if err := store.WithTrigger("cascade allow").Allow(rc); err != nil {
 		return fmt.Errorf("allow file: %w", err)
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "cascade: allowed %s\n", rc.Path)
+	if err := printChange(cmd.OutOrStdout(), change, rc.Path, false, jsonOutput); err != nil {
+		return err
+	}
+	if status, source := store.Explain(rc, cfg); status == allow.Denied && source == allow.SourceSystem {
+		newLogger(cmd.ErrOrStderr()).Warnf("%s is still denied by the system store (%s)", rc.Path, store.SystemDir())
+	}
 	return nil
 }
 
-func runAllowRecursive(cmd *cobra.Command, args []string, store *allow.Store) error {
+func runAllowRecursive(cmd *cobra.Command, args []string, store *allow.Store, checkMode, jsonOutput bool) error {
 	path := "."
 	if len(args) > 0 {
 		path = args[0]
@@ -84,11 +355,248 @@ func runAllowRecursive(cmd *cobra.Command, args []string, store *allow.Store) er
 		return fmt.Errorf("resolve path: %w", err)
 	}
 
-	// Trust the subtree
-	if err := store.TrustSubtree(absPath); err != nil {
-		return fmt.Errorf("trust subtree: %w", err)
+	return trustSubtree(cmd.OutOrStdout(), store.WithTrigger("cascade allow --recursive"), absPath, checkMode, jsonOutput)
+}
+
+// allowDiff returns the unified diff from the last allowed content of rc to
+// its current content, and whether there was earlier content to diff
+// against. A file never allowed is diffed against nothing.
+func allowDiff(store *allow.Store, rc *envrc.RC, change allow.Change) (string, bool, error) {
+	content, err := rc.Content()
+	if err != nil {
+		return "", false, fmt.Errorf("read file: %w", err)
+	}
+	if change == allow.ChangeAllowed {
+		return textdiff.Unified("/dev/null", rc.Path, "", string(content)), true, nil
+	}
+	previous, ok := store.LastAllowedContent(rc)
+	if !ok {
+		return "", false, nil
+	}
+	return textdiff.Unified(rc.Path+" (last allowed)", rc.Path, string(previous), string(content)), true, nil
+}
+
+// changedDiff returns the diff from the last allowed content of rc, or ""
+// if no earlier version was allowed or its content was not kept.
+func changedDiff(store *allow.Store, rc *envrc.RC) string {
+	if store.AllowChange(rc) != allow.ChangeUpdated {
+		return ""
+	}
+	diff, _, _ := allowDiff(store, rc, allow.ChangeUpdated)
+	return diff
+}
+
+// showAllowDiff prints the diff for allow --show-diff-only and fails with
+// exit status 1 if rc is not allowed yet.
+func showAllowDiff(stdout io.Writer, store *allow.Store, rc *envrc.RC, change allow.Change) error {
+	if !change.Changed() {
+		return nil
+	}
+	diff, ok, err := allowDiff(store, rc, change)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no earlier allowed content of %s was kept to diff against", rc.Path)
+	}
+	printDiff(stdout, newColorizer(stdout), diff)
+	return &ExitError{Code: 1}
+}
+
+// confirmAllowDiff shows what changed since rc's file was last allowed and
+// asks whether to allow the new content. With no earlier content kept, or
+// no difference in content, there is nothing to show and it does not ask.
+func confirmAllowDiff(stdin io.Reader, stdout io.Writer, store *allow.Store, rc *envrc.RC) (bool, error) {
+	diff, ok, err := allowDiff(store, rc, allow.ChangeUpdated)
+	if err != nil {
+		return false, err
+	}
+	if !ok || diff == "" {
+		return true, nil
+	}
+
+	fmt.Fprintf(stdout, "%s changed since it was allowed:\n\n", rc.Path)
+	printDiff(stdout, newColorizer(stdout), diff)
+	fmt.Fprint(stdout, "\nAllow the new content? [y/N] ")
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+	return strings.EqualFold(strings.TrimSpace(answer), "y"), nil
+}
+
+// printDiff writes a unified diff, colored when w is a terminal.
+func printDiff(w io.Writer, c *colorizer, diff string) {
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(diff, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			line = c.bold(line)
+		case strings.HasPrefix(line, "@@"):
+			line = c.dim(line)
+		case strings.HasPrefix(line, "+"):
+			line = c.green(line)
+		case strings.HasPrefix(line, "-"):
+			line = c.red(line)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// isTerminal reports whether r is a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// errAllowAllNeedsTTY explains how to run allow --all without a terminal.
+var errAllowAllNeedsTTY = errors.New("--all needs an interactive terminal to confirm; pass --yes to allow without asking")
+
+// runAllowAll allows every file in the current chain that is not allowed,
+// and with includeDenied every denied file the user's own stores can allow.
+// It previews the files, as a diff from the last allowed version for files
+// that changed, and asks once unless yes is set.
+func runAllowAll(stdin io.Reader, stdout io.Writer, store *allow.Store, includeDenied, yes bool) error {
+	plan, err := planCurrentDir()
+	if err != nil {
+		return err
+	}
+
+	c := newColorizer(stdout)
+	var pending []*envrc.RC
+	skippedDenied := 0
+	for _, level := range plan.Levels {
+		status, source := store.Explain(level.RC, cfg)
+		switch {
+		case status == allow.Allowed:
+			continue
+		case status == allow.Denied && (!includeDenied || source == allow.SourceSystem):
+			skippedDenied++
+			continue
+		}
+		pending = append(pending, level.RC)
+		if !yes {
+			fmt.Fprintf(stdout, "%s\n", c.bold(level.RC.Path))
+			fmt.Fprintf(stdout, "  status: %s\n", describeStatus(c, store, level.RC, status, source))
+			if diff := changedDiff(store, level.RC); diff != "" {
+				printDiff(stdout, c, diff)
+			} else {
+				printFilePreview(stdout, c, level.RC)
+			}
+			fmt.Fprintln(stdout)
+		}
+	}
+
+	if len(pending) > 0 && !yes {
+		if !isTerminal(stdin) {
+			return errAllowAllNeedsTTY
+		}
+		fmt.Fprintf(stdout, "Allow %d %s? [y/N] ", len(pending), filesNoun(len(pending)))
+		answer, _ := bufio.NewReader(stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Fprintln(stdout, "cascade: nothing allowed")
+			return nil
+		}
+	}
+
+	store = store.WithTrigger("cascade allow --all")
+	for _, rc := range pending {
+		change := store.AllowChange(rc)
+		if err := store.Allow(rc); err != nil {
+			return fmt.Errorf("allow %s: %w", rc.Path, err)
+		}
+		if err := printChange(stdout, change, rc.Path, false, false); err != nil {
+			return err
+		}
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "cascade: trusted subtree %s\n", absPath)
+	summary := fmt.Sprintf("cascade: allowed %d %s", len(pending), filesNoun(len(pending)))
+	if skippedDenied > 0 {
+		summary += fmt.Sprintf(", skipped %d denied", skippedDenied)
+	}
+	_, err = fmt.Fprintln(stdout, summary)
+	return err
+}
+
+// filesNoun returns "file" or "files" for n.
+func filesNoun(n int) string {
+	if n == 1 {
+		return "file"
+	}
+	return "files"
+}
+
+// readPathList reads the paths allow and deny --stdin take: one per line,
+// skipping blank lines and lines starting with #.
+func readPathList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read paths: %w", err)
+	}
+	return paths, nil
+}
+
+// runBatch applies apply to each path listed on stdin (see readPathList),
+// going on past files that fail, each of which is reported on stderr. It
+// ends with a summary, "cascade: <done> N files", adding how many failed,
+// and exit status 1 if any did.
+func runBatch(stdin io.Reader, stdout, stderr io.Writer, done string, apply func(path string) error) error {
+	paths, err := readPathList(stdin)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range paths {
+		if err := apply(path); err != nil {
+			fmt.Fprintf(stderr, "cascade: error: %s: %v\n", path, err)
+			failed++
+		}
+	}
+
+	ok := len(paths) - failed
+	summary := fmt.Sprintf("cascade: %s %d %s", done, ok, filesNoun(ok))
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed", failed)
+	}
+	if _, err := fmt.Fprintln(stdout, summary); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &ExitError{Code: 1}
+	}
+	return nil
+}
+
+// allowListed allows the .envrc at path for allow --stdin, printing its
+// change as allow does, without asking about changed content.
+func allowListed(stdout, stderr io.Writer, store *allow.Store, path string) error {
+	absPath, err := envrcArg([]string{path})
+	if err != nil {
+		return err
+	}
+	rc, err := envrc.NewRC(absPath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if !rc.Exists {
+		return errors.New("file does not exist")
+	}
+
+	change := store.AllowChange(rc)
+	if err := store.Allow(rc); err != nil {
+		return err
+	}
+	if err := printChange(stdout, change, rc.Path, false, false); err != nil {
+		return err
+	}
+	if status, source := store.Explain(rc, cfg); status == allow.Denied && source == allow.SourceSystem {
+		newLogger(stderr).Warnf("%s is still denied by the system store (%s)", rc.Path, store.SystemDir())
+	}
 	return nil
 }
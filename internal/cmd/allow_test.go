@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAllowDataDir(t *testing.T) {
+	flagDir := t.TempDir()
+	envDir := t.TempDir()
+
+	tests := []struct {
+		name       string
+		flag       string
+		env        string
+		wantDir    string
+		wantSource string
+	}{
+		{name: "default", wantDir: "", wantSource: ""},
+		{name: "env", env: envDir, wantDir: envDir, wantSource: dataDirEnv},
+		{name: "flag", flag: flagDir, wantDir: flagDir, wantSource: "--data-dir"},
+		{name: "flag beats env", flag: flagDir, env: envDir, wantDir: flagDir, wantSource: "--data-dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(dataDirEnv, tt.env)
+			dataDirFlag = tt.flag
+			t.Cleanup(func() { dataDirFlag = "" })
+
+			dir, source := allowDataDir()
+			if dir != tt.wantDir || source != tt.wantSource {
+				t.Errorf("allowDataDir() = %q, %q; want %q, %q", dir, source, tt.wantDir, tt.wantSource)
+			}
+		})
+	}
+
+	t.Run("relative", func(t *testing.T) {
+		t.Chdir(flagDir)
+		t.Setenv(dataDirEnv, "store")
+		if dir, _ := allowDataDir(); dir != filepath.Join(flagDir, "store") {
+			t.Errorf("allowDataDir() = %q, want it made absolute", dir)
+		}
+	})
+}
+
+func TestReadPathList(t *testing.T) {
+	input := "/a/.envrc\n\n  # provisioned by setup\n  b/.envrc  \r\n#/c/.envrc\n/d/.envrc"
+	got, err := readPathList(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/a/.envrc", "b/.envrc", "/d/.envrc"}; !slices.Equal(got, want) {
+		t.Errorf("readPathList() = %q, want %q", got, want)
+	}
+}
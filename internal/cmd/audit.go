@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+)
+
+// AuditOutput is the JSON representation of cascade audit.
+type AuditOutput struct {
+	OutputHeader
+	Records []AuditEntry `json:"records"`
+}
+
+// AuditEntry is one audit log record.
+type AuditEntry struct {
+	allow.AuditRecord
+	Content string `json:"content,omitempty"` // Kept content of an allowed file (audit_keep_content)
+}
+
+func newAuditCmd() *cobra.Command {
+	var path, since string
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the log of allow, deny, and trust changes",
+		Long: `Show the audit log: every change to the allow store (allow, deny, revoke,
+trust, untrust), when it was made, by which uid, what triggered it, and the
+content hash of the .envrc at the time. Changes made automatically, such as
+auto-allowing a clean checkout of a trusted remote, are recorded too.
+
+With audit_keep_content set, the content of each allowed file is kept as
+well; --json names the file holding it.
+
+--since takes a duration such as 30d, 12h or 90m, or a date (2006-01-02).`,
+		Example: `  cascade audit --since 30d
+  cascade audit --path ~/work/api
+  cascade audit --json | jq '.records[] | select(.op == "trust")'`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAudit(cmd.OutOrStdout(), path, since, jsonOutput)
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Only show records about this file or directory")
+	cmd.Flags().StringVar(&since, "since", "", "Only show records this recent (e.g. 30d)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runAudit(w io.Writer, path, since string, jsonOutput bool) error {
+	var cutoff time.Time
+	if since != "" {
+		var err error
+		if cutoff, err = parseSince(since, time.Now()); err != nil {
+			return err
+		}
+	}
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("resolve path: %w", err)
+		}
+		path = abs
+	}
+
+	store, err := readAllowStore()
+	if err != nil {
+		return fmt.Errorf("create allow store: %w", err)
+	}
+
+	entries := []AuditEntry{}
+	for record, err := range store.AuditLog() {
+		if err != nil {
+			return err
+		}
+		if record.Time.Before(cutoff) || (path != "" && !record.Concerns(path)) {
+			continue
+		}
+		entry := AuditEntry{AuditRecord: record}
+		if record.Operation == allow.AuditAllow {
+			entry.Content, _ = store.AuditContentFile(record.Hash)
+		}
+		entries = append(entries, entry)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(AuditOutput{OutputHeader: newOutputHeader(), Records: entries})
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No audit records")
+		return nil
+	}
+
+	home, _ := os.UserHomeDir()
+	fmt.Fprintf(w, "%-19s  %-7s  %-12s  %-5s  %s\n", "TIME", "OP", "HASH", "UID", "PATH")
+	for _, e := range entries {
+		hash := e.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		line := fmt.Sprintf("%-19s  %-7s  %-12s  %-5d  %s", e.Time.Local().Format(time.DateTime), e.Operation, hash, e.UID, shortenPath(e.Path, home))
+		if e.Trigger != "" {
+			line += " (" + e.Trigger + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// parseSince returns the cutoff time for --since: a duration before now,
+// which may use a d suffix for days, or a date in the local time zone.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want a duration like 30d or 12h, or a date like 2006-01-02)", s)
+}
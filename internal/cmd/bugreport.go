@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/xdg"
+)
+
+// BugReport is the document written by cascade bugreport.
+type BugReport struct {
+	OutputHeader
+	Platform    string         `json:"platform"`
+	CascadeRoot string         `json:"cascade_root"`
+	Dirs        BugReportDirs  `json:"dirs"`
+	Config      ConfigOutput   `json:"config"`
+	Status      *StatusOutput  `json:"status"`
+	Envrcs      []EnvrcContent `json:"envrc_contents,omitempty"`
+}
+
+// BugReportDirs lists the directories cascade resolved for this user.
+type BugReportDirs struct {
+	Config string `json:"config"`
+	Data   string `json:"data"`  // allow, deny, and trust stores
+	State  string `json:"state"` // Per-shell state
+	Cache  string `json:"cache"` // Evaluation cache
+	KV     string `json:"kv"`    // cache_output values
+}
+
+// EnvrcContent is an .envrc included with --include-envrc.
+type EnvrcContent struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+func newBugreportCmd() *cobra.Command {
+	var output string
+	var includeEnvrc bool
+
+	cmd := &cobra.Command{
+		Use:   "bugreport",
+		Short: "Collect diagnostics for a bug report",
+		Long: `Gather the cascade version, configuration, resolved directories, and
+the .envrc chain for the current directory into one JSON document.
+
+Values of variables that look like secrets (tokens, passwords, keys, and
+anything matching cache_exclude) are redacted. .envrc contents are only
+included with --include-envrc; review the report before sharing it.`,
+		Example: `  cascade bugreport -o report.json
+  cascade bugreport --include-envrc -o report.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBugreport(cmd.OutOrStdout(), output, includeEnvrc)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "File to write (- for stdout)")
+	cmd.Flags().BoolVar(&includeEnvrc, "include-envrc", false, "Include the contents of each .envrc in the chain")
+
+	return cmd
+}
+
+func runBugreport(stdout io.Writer, output string, includeEnvrc bool) error {
+	report, err := gatherBugReport(includeEnvrc)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+	data = append(data, '\n')
+
+	if output == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	// Private: the report may include .envrc contents
+	if err := os.WriteFile(output, data, 0o600); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	fmt.Fprintf(stdout, "Wrote %s\n", output)
+	return nil
+}
+
+func gatherBugReport(includeEnvrc bool) (*BugReport, error) {
+	cwd, err := resolveTargetDir("")
+	if err != nil {
+		return nil, err
+	}
+	status, err := gatherStatus(env.FromGoEnv(os.Environ()), cwd)
+	if err != nil {
+		return nil, err
+	}
+	for name := range status.Variables {
+		if env.IsSecret(name, cfg.CacheExclude) {
+			status.Variables[name] = StatusValue{Value: env.Redacted}
+		}
+	}
+
+	root, err := cfg.GetCascadeRoot()
+	if err != nil {
+		return nil, fmt.Errorf("get cascade root: %w", err)
+	}
+
+	dirs, err := resolveDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BugReport{
+		OutputHeader: newOutputHeader(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		CascadeRoot:  root,
+		Dirs:         dirs,
+		Config:       gatherConfig(),
+		Status:       status,
+	}
+
+	if includeEnvrc {
+		for _, entry := range status.Chain {
+			content, err := os.ReadFile(entry.Path)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", entry.Path, err)
+			}
+			report.Envrcs = append(report.Envrcs, EnvrcContent{Path: entry.Path, Content: string(content)})
+		}
+	}
+
+	return report, nil
+}
+
+// resolveDirs returns the XDG directories cascade uses, following the same
+// rules as the stores that own them: --data-dir or CASCADE_DATA_DIR moves
+// the data directory (see allowDataDir), and only CASCADE_DATA_DIR state.
+func resolveDirs() (BugReportDirs, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return BugReportDirs{}, fmt.Errorf("get home directory: %w", err)
+	}
+	config := filepath.Join(home, ".config", "cascade")
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		config = filepath.Join(dir, "cascade")
+	}
+
+	dataHome, err := xdg.DataHome()
+	if err != nil {
+		return BugReportDirs{}, err
+	}
+	cacheHome, err := xdg.CacheHome()
+	if err != nil {
+		return BugReportDirs{}, err
+	}
+	data := filepath.Join(dataHome, "cascade")
+	stateDir := filepath.Join(data, "state")
+	if dir, _ := allowDataDir(); dir != "" {
+		data = dir
+	}
+	if dir := os.Getenv(dataDirEnv); dir != "" {
+		stateDir = filepath.Join(dir, "state")
+	}
+	cache := filepath.Join(cacheHome, "cascade")
+	return BugReportDirs{
+		Config: config,
+		Data:   data,
+		State:  stateDir,
+		Cache:  cache,
+		KV:     filepath.Join(cache, "kv"),
+	}, nil
+}
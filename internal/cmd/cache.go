@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/kv"
+)
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached evaluation results",
+		Long: `Manage the evaluation cache.
+
+Results are cached by each .envrc's content hash and the environment it was
+evaluated with, so unchanged levels are not run again at every prompt.
+Values stored by cache_output are kept alongside them. Set
+cache_enabled = false to turn caching off, or CASCADE_REFRESH=1 to bypass
+it for one run.`,
+		Example: `  cascade cache gc
+  cascade cache clear`,
+		Annotations: map[string]string{envAnnotation: cacheEnv},
+	}
+
+	cmd.AddCommand(newCacheClearCmd())
+	cmd.AddCommand(newCacheGCCmd())
+
+	return cmd
+}
+
+func newCacheClearCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear",
+		Short: "Remove all cached results",
+		Long: `Remove cached .envrc evaluation results and values stored by cache_output.
+
+The next prompt re-evaluates every .envrc and re-runs every cached command.`,
+		Example:     `  cascade cache clear`,
+		Annotations: map[string]string{envAnnotation: cacheEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheClear(cmd.OutOrStdout())
+		},
+	}
+}
+
+func newCacheGCCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove stale and old cached results",
+		Long: `Remove cached evaluation results that will not be used again: unreadable
+entries, those whose .envrc or a file it watched no longer exists, and
+those older than cache_max_age. If the cache still holds more than
+cache_max_entries entries or cache_max_size_mb megabytes, the oldest are
+removed until it fits.
+
+Export does this by itself about once a day, so running it is rarely
+needed.`,
+		Example: `  cascade cache gc
+  cascade cache gc --dry-run`,
+		Annotations: map[string]string{envAnnotation: cacheEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheGC(cmd.OutOrStdout(), dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report how many entries would be removed without removing them")
+
+	return cmd
+}
+
+// cacheGCInterval is how often export collects the evaluation cache.
+const cacheGCInterval = 24 * time.Hour
+
+// cacheGCOptions returns the cache limits from the config.
+func cacheGCOptions() eval.GCOptions {
+	return eval.GCOptions{
+		MaxAge:     cfg.CacheMaxAgeDuration(),
+		MaxEntries: cfg.CacheMaxEntries,
+		MaxBytes:   int64(cfg.CacheMaxSizeMB) << 20,
+	}
+}
+
+// autoCacheGC collects the evaluation cache if it was not collected within
+// cacheGCInterval, for export. A cache that does not exist yet is left
+// alone rather than created. Failures only cost disk space, so they are
+// ignored.
+func autoCacheGC() {
+	if !cfg.CacheEnabled {
+		return
+	}
+	if dir, err := eval.CacheDir(); err != nil || !isDir(dir) {
+		return
+	}
+	cache, err := eval.NewCache()
+	if err != nil {
+		return
+	}
+	_, _ = cache.GCIfDue(cacheGCInterval, cacheGCOptions())
+}
+
+func runCacheGC(w io.Writer, dryRun bool) error {
+	cache, err := eval.NewCache()
+	if err != nil {
+		return fmt.Errorf("open cache: %w", err)
+	}
+	opts := cacheGCOptions()
+	opts.DryRun = dryRun
+	removed, err := cache.GC(opts)
+	if err != nil {
+		return fmt.Errorf("collect evaluation cache: %w", err)
+	}
+
+	noun := "entries"
+	if removed == 1 {
+		noun = "entry"
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Would remove %d stale cache %s\n", removed, noun)
+	} else {
+		fmt.Fprintf(w, "Removed %d stale cache %s\n", removed, noun)
+	}
+	return nil
+}
+
+func runCacheClear(w io.Writer) error {
+	cache, err := eval.NewCache()
+	if err != nil {
+		return fmt.Errorf("open cache: %w", err)
+	}
+	if err := cache.Clear(); err != nil {
+		return fmt.Errorf("clear evaluation cache: %w", err)
+	}
+
+	store, err := kv.NewStore()
+	if err != nil {
+		return fmt.Errorf("open kv store: %w", err)
+	}
+	if err := store.Clear(); err != nil {
+		return fmt.Errorf("clear cached outputs: %w", err)
+	}
+
+	fmt.Fprintln(w, "Cache cleared")
+	return nil
+}
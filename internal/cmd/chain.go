@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// ChainOutput is the JSON representation of cascade chain.
+type ChainOutput struct {
+	OutputHeader
+	Target  string      `json:"target"`
+	Files   []CheckFile `json:"files"`
+	Allowed int         `json:"allowed"`
+	Pending int         `json:"pending"` // Not allowed yet
+	Denied  int         `json:"denied"`
+}
+
+func newChainCmd() *cobra.Command {
+	var (
+		format     string
+		jsonOutput bool
+		dir        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "chain",
+		Short: "List the chain's .envrc files and their status, for prompts",
+		Long: `List every .envrc in the chain for the current directory with its allow
+status, one "<status><TAB><path>" line per file from the cascade root down.
+Status is allowed, not allowed, or denied.
+
+Nothing is evaluated: chain only finds the files and looks them up in the
+allow store, without the cache or the saved state, and never creates a
+directory or file. It is meant for shell prompts, which run it at every
+prompt.
+
+--format starship prints a one-line summary instead, such as "⚡3 ⚠1":
+allowed files after ⚡, files pending approval after ⚠, and denied files
+after ✗, leaving out counts of zero. Nothing is printed without an .envrc.
+
+--json prints {"files": [...], "allowed": N, "pending": N, "denied": N}.`,
+		Example: `  cascade chain
+  cascade chain --format starship
+  cascade chain --json | jq .pending
+
+  # starship.toml
+  [custom.cascade]
+  command = "cascade chain --format starship"
+  when = true`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch format {
+			case "", "starship":
+			default:
+				return fmt.Errorf("unknown format %q (want starship)", format)
+			}
+			target, err := resolveTargetDir(dir)
+			if err != nil {
+				return err
+			}
+			output, err := gatherChain(target)
+			if err != nil {
+				return err
+			}
+			return printChain(cmd.OutOrStdout(), output, format, jsonOutput)
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "Print a summary in `FORMAT` (starship)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.MarkFlagsMutuallyExclusive("format", "json")
+	addDirFlag(cmd, &dir)
+
+	return cmd
+}
+
+// gatherChain finds the .envrc files of target's chain and their status.
+func gatherChain(target string) (*ChainOutput, error) {
+	root, err := cfg.GetCascadeRoot()
+	if err != nil {
+		return nil, fmt.Errorf("get cascade root: %w", err)
+	}
+	chain, _, _, err := envrc.FindRootedChain(root, target, chainOptions())
+	if err != nil {
+		return nil, fmt.Errorf("find envrc chain: %w", err)
+	}
+
+	output := &ChainOutput{Target: target, Files: []CheckFile{}}
+	var resolver *policy.Resolver
+	for _, rc := range chain {
+		if !rc.Exists {
+			continue
+		}
+		// Opened for the first file, so directories without one never look
+		if resolver == nil {
+			store, err := peekAllowStore()
+			if err != nil {
+				return nil, fmt.Errorf("open allow store: %w", err)
+			}
+			pol, _ := policy.Find(run.ChainDirs(chain), store, cfg)
+			resolver = policy.NewResolver(store, pol)
+		}
+		status, source := resolver.Explain(rc, cfg)
+		switch status {
+		case allow.Allowed:
+			output.Allowed++
+		case allow.Denied:
+			output.Denied++
+		default:
+			output.Pending++
+		}
+		output.Files = append(output.Files, CheckFile{
+			Path:   rc.Path,
+			Status: status.String(),
+			Source: sourceLabel(string(source)),
+		})
+	}
+	return output, nil
+}
+
+// printChain writes output as lines, a summary in format, or JSON.
+func printChain(w io.Writer, output *ChainOutput, format string, jsonOutput bool) error {
+	if jsonOutput {
+		output.OutputHeader = newOutputHeader()
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
+	}
+	if format == "starship" {
+		if summary := starshipSummary(output); summary != "" {
+			_, err := fmt.Fprintln(w, summary)
+			return err
+		}
+		return nil
+	}
+	for _, file := range output.Files {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", file.Status, file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// starshipSummary returns the counts of output as "⚡3 ⚠1 ✗1", leaving out
+// those that are zero.
+func starshipSummary(output *ChainOutput) string {
+	var parts []string
+	for _, count := range []struct {
+		symbol string
+		n      int
+	}{{"⚡", output.Allowed}, {"⚠", output.Pending}, {"✗", output.Denied}} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%s%d", count.symbol, count.n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
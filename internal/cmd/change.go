@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+)
+
+// ChangeOutput is the JSON representation of allow, deny, or trust when
+// they record a file or subtree.
+type ChangeOutput struct {
+	OutputHeader
+	Changed bool   `json:"changed"`
+	Status  string `json:"status"` // See changeStatus
+	Path    string `json:"path"`
+}
+
+// wouldStatus is the status --check-mode reports for a change it did not make.
+var wouldStatus = map[allow.Change]string{
+	allow.ChangeAllowed: "would-allow",
+	allow.ChangeUpdated: "would-update",
+	allow.ChangeDenied:  "would-deny",
+	allow.ChangeTrusted: "would-trust",
+}
+
+// addCheckModeFlag registers --check-mode on cmd.
+func addCheckModeFlag(cmd *cobra.Command, checkMode *bool) {
+	cmd.Flags().BoolVar(checkMode, "check-mode", false,
+		"Report what would change without writing (exit 0 either way)")
+}
+
+// changeStatus returns the status word for change: the change itself
+// ("allowed", "updated", "denied", "trusted", or "unchanged"), or with
+// checkMode what it would be ("would-allow" and so on, or "unchanged").
+func changeStatus(change allow.Change, checkMode bool) string {
+	if checkMode && change.Changed() {
+		return wouldStatus[change]
+	}
+	return string(change)
+}
+
+// printChange reports change to path as one line, "cascade: <status> <path>",
+// or as a ChangeOutput document. The line is stable for scripts to parse.
+func printChange(w io.Writer, change allow.Change, path string, checkMode, jsonOutput bool) error {
+	status := changeStatus(change, checkMode)
+	if !jsonOutput {
+		_, err := fmt.Fprintf(w, "cascade: %s %s\n", status, path)
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ChangeOutput{
+		OutputHeader: newOutputHeader(),
+		Changed:      change.Changed(),
+		Status:       status,
+		Path:         path,
+	})
+}
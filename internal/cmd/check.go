@@ -2,73 +2,257 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
 	"github.com/unrss/cascade/internal/allow"
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/run"
 )
 
+// CheckOutput is the JSON representation of cascade check.
+type CheckOutput struct {
+	OutputHeader
+	Files []CheckFile `json:"files"`
+
+	// Broken names the denied .envrc that keeps the files below it in the
+	// chain from loading (see --strict). Only set when checking a chain.
+	Broken string `json:"broken,omitempty"`
+}
+
+// CheckFile is the status of one .envrc.
+type CheckFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`           // "allowed", "not allowed" or "denied"
+	Source string `json:"source,omitempty"` // Record that decided, unless the default store
+}
+
+// checkOptions holds the check command's flags.
+type checkOptions struct {
+	silent bool
+	json   bool
+	strict bool // Also fail if a denied parent breaks the chain
+	fix    bool
+	dir    string
+}
+
 func newCheckCmd() *cobra.Command {
-	var silent bool
+	var opts checkOptions
 
 	cmd := &cobra.Command{
-		Use:   "check <file>",
+		Use:   "check [file|dir]",
 		Short: "Check if an envrc file is allowed",
 		Long: `Check the allow status of a specific .envrc file.
 
 Returns exit code 0 if allowed, 1 if not allowed or denied.
-Use --silent for scripting (no output, exit code only).`,
-		Args: cobra.ExactArgs(1),
+Use --silent for scripting (no output, exit code only).
+
+Given a directory, or no argument for the current directory, check every
+.envrc in its chain instead, as export would load it: the exit code is 0
+only if all of them are allowed. Together with --data-dir this verifies,
+for example in CI, that a prepared allow store covers a checkout. With
+--strict, the check also fails, naming the file, when the chain is broken
+by a denied parent: an .envrc exists below a denied one, and export loads
+neither.
+
+--json prints {"files": [{"path": ..., "status": ...}]} for the file or the
+chain, with the same exit code.
+
+With --fix, walk every .envrc in the current directory's chain that is not
+allowed, showing why and a preview of each, and allow, deny, edit, or skip
+it. Requires a terminal.
+
+With --dir, check DIR/.envrc, or with --fix, the chain for DIR.`,
+		Example: `  cascade check                   # Exit 0 if the current chain is allowed
+  cascade check --silent ~/work/api/.envrc && echo allowed
+  cascade check --json --strict ~/work/api
+  cascade check --fix             # Review each unapproved .envrc in the chain
+  cascade check --data-dir /opt/ci-cascade .  # Is the whole chain allowed by this store?`,
+		Annotations: map[string]string{envAnnotation: dataEnv + `
+VISUAL: Editor opened by the edit choice of --fix
+EDITOR: Editor to use if VISUAL is not set`},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if opts.fix || opts.dir != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.MaximumNArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeChainEnvrc(anyStatus),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runCheck(cmd.OutOrStdout(), cmd.ErrOrStderr(), args[0], silent)
+			if opts.dir != "" {
+				target, err := resolveTargetDir(opts.dir)
+				if err != nil {
+					return err
+				}
+				opts.dir = target
+			}
+			if opts.fix {
+				return runCheckFix(cmd.InOrStdin(), cmd.OutOrStdout(), opts.dir)
+			}
+			if opts.dir != "" {
+				args = []string{filepath.Join(opts.dir, ".envrc")}
+			} else if len(args) == 0 || isDir(args[0]) {
+				var arg string
+				if len(args) > 0 {
+					arg = args[0]
+				}
+				target, err := resolveTargetDir(arg)
+				if err != nil {
+					return err
+				}
+				return runCheckChain(cmd.OutOrStdout(), cmd.ErrOrStderr(), target, opts)
+			}
+			return runCheck(cmd.OutOrStdout(), cmd.ErrOrStderr(), args[0], opts)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&silent, "silent", "s", false, "suppress output (exit code only)")
+	cmd.Flags().BoolVarP(&opts.silent, "silent", "s", false, "suppress output (exit code only)")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "output in JSON format")
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "also fail if a denied parent breaks the chain")
+	cmd.Flags().BoolVar(&opts.fix, "fix", false, "interactively resolve problems in the current chain")
+	cmd.MarkFlagsMutuallyExclusive("silent", "fix")
+	cmd.MarkFlagsMutuallyExclusive("silent", "json")
+	cmd.MarkFlagsMutuallyExclusive("json", "fix")
+	addDirFlag(cmd, &opts.dir)
 
 	return cmd
 }
 
-func runCheck(stdout, stderr io.Writer, path string, silent bool) error {
+func runCheck(stdout, stderr io.Writer, path string, opts checkOptions) error {
 	rc, err := envrc.NewRC(path)
 	if err != nil {
-		if !silent {
+		if !opts.silent {
 			fmt.Fprintf(stderr, "error: %v\n", err)
 		}
 		return err
 	}
 
-	store, err := allow.NewStore()
+	store, err := readAllowStore()
 	if err != nil {
-		if !silent {
+		if !opts.silent {
 			fmt.Fprintf(stderr, "error: %v\n", err)
 		}
 		return err
 	}
 
-	status := store.CheckWithWhitelist(rc, cfg)
+	status, source := policy.NewResolver(store, chainPolicy(rc.Dir, store)).Explain(rc, cfg)
+	if opts.json {
+		file := CheckFile{Path: rc.Path, Status: status.String(), Source: sourceLabel(string(source))}
+		if err := outputCheckJSON(stdout, CheckOutput{Files: []CheckFile{file}}); err != nil {
+			return err
+		}
+		opts.silent = true
+	}
+
+	// Name the record that decided, unless it is the default global store
+	via := ""
+	if label := sourceLabel(string(source)); label != "" {
+		via = " (via " + label + ")"
+	}
 
 	switch status {
 	case allow.Allowed:
-		if !silent {
-			fmt.Fprintf(stdout, "allowed: %s\n", rc.Path)
+		if !opts.silent {
+			fmt.Fprintf(stdout, "allowed: %s%s\n", rc.Path, via)
 		}
 		return nil
 	case allow.NotAllowed:
-		if !silent {
+		if !opts.silent {
 			fmt.Fprintf(stdout, "not allowed: %s\n", rc.Path)
 		}
 		return errors.New("not allowed")
 	case allow.Denied:
-		if !silent {
-			fmt.Fprintf(stdout, "denied: %s\n", rc.Path)
+		if !opts.silent {
+			fmt.Fprintf(stdout, "denied: %s%s\n", rc.Path, via)
 		}
 		return errors.New("denied")
 	default:
 		return fmt.Errorf("unknown status: %v", status)
 	}
 }
+
+// runCheckChain checks every .envrc in the chain for dir. It fails unless
+// all of them are allowed; with opts.strict, a chain broken by a denied
+// parent fails with that reason first.
+func runCheckChain(stdout, stderr io.Writer, dir string, opts checkOptions) error {
+	plan, err := planDir(dir)
+	if err != nil {
+		if !opts.silent {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+		}
+		return err
+	}
+
+	output := CheckOutput{Files: []CheckFile{}, Broken: chainBreak(plan)}
+	blocked := 0
+	for _, level := range plan.Levels {
+		if level.Status != allow.Allowed {
+			blocked++
+		}
+		output.Files = append(output.Files, CheckFile{
+			Path:   level.RC.Path,
+			Status: level.Status.String(),
+			Source: sourceLabel(string(level.Source)),
+		})
+	}
+
+	switch {
+	case opts.json:
+		if err := outputCheckJSON(stdout, output); err != nil {
+			return err
+		}
+	case !opts.silent:
+		for _, file := range output.Files {
+			via := ""
+			if file.Source != "" {
+				via = " (via " + file.Source + ")"
+			}
+			fmt.Fprintf(stdout, "%s: %s%s\n", file.Status, file.Path, via)
+		}
+		if len(plan.Levels) == 0 {
+			fmt.Fprintf(stdout, "no .envrc files in the chain for %s\n", dir)
+		}
+		if opts.strict && output.Broken != "" {
+			fmt.Fprintf(stdout, "broken: %s\n", output.Broken)
+		}
+	}
+
+	if opts.strict && output.Broken != "" {
+		return fmt.Errorf("the chain is broken: %s", output.Broken)
+	}
+	if blocked > 0 {
+		return fmt.Errorf("%d of %d .envrc files in the chain are not allowed", blocked, len(plan.Levels))
+	}
+	return nil
+}
+
+// chainBreak describes the denied parent that breaks the chain of plan: the
+// shallowest denied .envrc with another .envrc below it, which export then
+// does not load either. It returns "" if there is none.
+func chainBreak(plan *run.Plan) string {
+	for i, level := range plan.Levels {
+		if level.Status != allow.Denied {
+			continue
+		}
+		switch below := len(plan.Levels) - i - 1; {
+		case below == 1:
+			return level.RC.Path + " is denied, which blocks the .envrc below it"
+		case below > 1:
+			return fmt.Sprintf("%s is denied, which blocks the %d .envrc files below it", level.RC.Path, below)
+		}
+	}
+	return ""
+}
+
+func outputCheckJSON(w io.Writer, output CheckOutput) error {
+	output.OutputHeader = newOutputHeader()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(output)
+}
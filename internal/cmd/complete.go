@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+)
+
+// Completion functions for arguments that depend on the shell's state.
+// They must answer while the user waits at a prompt, so none of them
+// evaluates an .envrc: variable names come from CASCADE_DIFF, and paths from
+// the chain walk and the allow store.
+
+// completeLoadedVar completes the one variable name of which.
+func completeLoadedVar(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return loadedVarNames(nil, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeLoadedVars completes the variable names of tree, each once.
+func completeLoadedVars(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return loadedVarNames(args, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// loadedVarNames returns the variables the loaded chain set in this shell,
+// according to CASCADE_DIFF, that start with prefix and are not in exclude,
+// sorted. Variables it unset are left out.
+func loadedVarNames(exclude []string, prefix string) []string {
+	diff, err := env.Unmarshal(os.Getenv("CASCADE_DIFF"))
+	if err != nil || diff == nil {
+		return nil
+	}
+
+	names := append(slices.Collect(maps.Keys(diff.Next)), diff.Sensitive...)
+	var matches []string
+	for _, name := range names {
+		if _, set := os.LookupEnv(name); !set || env.IgnoredEnv(name) {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) && !slices.Contains(exclude, name) {
+			matches = append(matches, name)
+		}
+	}
+	slices.Sort(matches)
+	return slices.Compact(matches)
+}
+
+// completeChainEnvrc returns a completion function for the .envrc files of
+// the current chain whose status satisfies want. Without any, the shell
+// falls back to completing file names.
+func completeChainEnvrc(want func(allow.AllowStatus) bool) cobra.CompletionFunc {
+	return func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		paths := chainEnvrcPaths(want, toComplete)
+		if len(paths) == 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		return paths, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// chainEnvrcPaths returns the .envrc files of the current chain whose status
+// satisfies want and that start with prefix, from the cascade root down.
+// They are spelled relative to the working directory unless prefix is an
+// absolute path.
+func chainEnvrcPaths(want func(allow.AllowStatus) bool, prefix string) []string {
+	plan, err := planCurrentDir()
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, level := range plan.Levels {
+		if !want(level.Status) {
+			continue
+		}
+		path := level.RC.Path
+		if !filepath.IsAbs(prefix) {
+			if rel, err := filepath.Rel(plan.Target, path); err == nil {
+				path = rel
+			}
+		}
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Status filters for completeChainEnvrc.
+func isAllowed(status allow.AllowStatus) bool    { return status == allow.Allowed }
+func isNotAllowed(status allow.AllowStatus) bool { return status != allow.Allowed }
+func anyStatus(allow.AllowStatus) bool           { return true }
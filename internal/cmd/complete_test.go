@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/env"
+)
+
+func TestCompleteLoadedVars(t *testing.T) {
+	diff := env.BuildEnvDiff(
+		env.Env{"GOPATH": "/go", "OLD": "x"},
+		env.Env{"GOPATH": "/work/go", "GOFLAGS": "-mod=mod", "DATABASE_URL": "postgres://"},
+	)
+	diff.Sensitive = []string{"API_TOKEN"}
+	encoded, err := env.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CASCADE_DIFF", encoded)
+	for name, value := range map[string]string{"GOPATH": "/work/go", "GOFLAGS": "-mod=mod", "DATABASE_URL": "postgres://", "API_TOKEN": "secret"} {
+		t.Setenv(name, value)
+	}
+
+	got, directive := completeLoadedVar(nil, nil, "")
+	if want := []string{"API_TOKEN", "DATABASE_URL", "GOFLAGS", "GOPATH"}; !slices.Equal(got, want) {
+		t.Errorf("which completions = %v, want %v (OLD was unset)", got, want)
+	}
+	if directive != cobra.ShellCompDirectiveNoFileComp {
+		t.Errorf("directive = %v, want NoFileComp", directive)
+	}
+	if got, _ := completeLoadedVar(nil, nil, "GO"); !slices.Equal(got, []string{"GOFLAGS", "GOPATH"}) {
+		t.Errorf("which completions for GO = %v, want [GOFLAGS GOPATH]", got)
+	}
+	if got, _ := completeLoadedVar(nil, []string{"GOPATH"}, ""); len(got) != 0 {
+		t.Errorf("which completions after its argument = %v, want none", got)
+	}
+
+	// tree takes several names, each once
+	if got, _ := completeLoadedVars(nil, []string{"GOPATH"}, "GO"); !slices.Equal(got, []string{"GOFLAGS"}) {
+		t.Errorf("tree completions = %v, want [GOFLAGS]", got)
+	}
+
+	// Nothing loaded, nothing to complete
+	t.Setenv("CASCADE_DIFF", "")
+	if got, _ := completeLoadedVar(nil, nil, ""); len(got) != 0 {
+		t.Errorf("completions without CASCADE_DIFF = %v, want none", got)
+	}
+}
+
+func TestCompleteChainEnvrc(t *testing.T) {
+	store, paths := setupFixChain(t)
+	if err := store.Allow(mustRC(t, paths[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Deny(mustRC(t, paths[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		fn     cobra.CompletionFunc
+		prefix string
+		want   []string
+	}{
+		{"allow", completeChainEnvrc(isNotAllowed), "", []string{"../.envrc", ".envrc"}},
+		{"deny", completeChainEnvrc(isAllowed), "", []string{"../../.envrc"}},
+		{"check", completeChainEnvrc(anyStatus), "", []string{"../../.envrc", "../.envrc", ".envrc"}},
+		{"check with prefix", completeChainEnvrc(anyStatus), "../", []string{"../../.envrc", "../.envrc"}},
+		{"absolute prefix", completeChainEnvrc(isNotAllowed), "/", []string{paths[1], paths[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, directive := tt.fn(nil, nil, tt.prefix)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("completions = %v, want %v", got, tt.want)
+			}
+			if directive != cobra.ShellCompDirectiveNoFileComp {
+				t.Errorf("directive = %v, want NoFileComp", directive)
+			}
+		})
+	}
+
+	// Other file names are completed when the chain has none to offer
+	if got, directive := completeChainEnvrc(isAllowed)(nil, nil, "x"); len(got) != 0 || directive != cobra.ShellCompDirectiveDefault {
+		t.Errorf("completions for an unmatched prefix = %v, %v, want none and Default", got, directive)
+	}
+	if got, _ := completeChainEnvrc(anyStatus)(nil, []string{".envrc"}, ""); len(got) != 0 {
+		t.Errorf("completions after the path = %v, want none", got)
+	}
+}
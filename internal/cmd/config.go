@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -15,12 +16,19 @@ import (
 
 // ConfigOutput is the JSON representation of cascade configuration.
 type ConfigOutput struct {
+	OutputHeader
 	ConfigFile      string   `json:"config_file,omitempty"`
 	WhitelistPrefix []string `json:"whitelist_prefix,omitempty"`
 	BashPath        string   `json:"bash_path,omitempty"`
 	DisabledShells  []string `json:"disabled_shells,omitempty"`
 	CascadeRoot     string   `json:"cascade_root,omitempty"`
 	CacheEnabled    bool     `json:"cache_enabled"`
+	WorkspaceStore  string   `json:"workspace_store,omitempty"`
+	SystemDataDir   string   `json:"system_data_dir,omitempty"`
+
+	// Profile is the [profiles] entry that applies in the working
+	// directory, with the settings that came from it.
+	Profile *config.Profile `json:"profile,omitempty"`
 }
 
 func newConfigCmd() *cobra.Command {
@@ -30,7 +38,17 @@ func newConfigCmd() *cobra.Command {
 		Use:   "config",
 		Short: "Show current configuration",
 		Long: `Display the current cascade configuration including values from
-the config file, environment variables, and defaults.`,
+the config file, environment variables, and defaults.
+
+A [profiles] entry whose match_prefix contains the working directory
+overrides settings of the config file, the longest prefix winning if
+several match; environment variables still override it. The active
+profile is shown with the settings that came from it.`,
+		Example: `  cascade config
+  cascade config --json
+  CASCADE_LOG_ENV_DIFF=false cascade config`,
+		Annotations: map[string]string{envAnnotation: `XDG_CONFIG_HOME: Location of cascade/config.toml (default ~/.config)
+CASCADE_<KEY>: Overrides the setting <key>`},
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runConfig(cmd.OutOrStdout(), jsonOutput)
@@ -43,16 +61,10 @@ the config file, environment variables, and defaults.`,
 }
 
 func runConfig(w io.Writer, jsonOutput bool) error {
-	output := ConfigOutput{
-		ConfigFile:      config.ConfigFile(),
-		WhitelistPrefix: cfg.WhitelistPrefix,
-		BashPath:        cfg.BashPath,
-		DisabledShells:  cfg.DisabledShells,
-		CascadeRoot:     cfg.CascadeRoot,
-		CacheEnabled:    cfg.CacheEnabled,
-	}
+	output := gatherConfig()
 
 	if jsonOutput {
+		output.OutputHeader = newOutputHeader()
 		enc := json.NewEncoder(w)
 		enc.SetIndent("", "  ")
 		return enc.Encode(output)
@@ -61,6 +73,20 @@ func runConfig(w io.Writer, jsonOutput bool) error {
 	return outputConfigHuman(w, output)
 }
 
+func gatherConfig() ConfigOutput {
+	return ConfigOutput{
+		ConfigFile:      cfg.File,
+		WhitelistPrefix: cfg.WhitelistPrefix,
+		BashPath:        cfg.BashPath,
+		DisabledShells:  cfg.DisabledShells,
+		CascadeRoot:     cfg.CascadeRoot,
+		CacheEnabled:    cfg.CacheEnabled,
+		WorkspaceStore:  cfg.WorkspaceStore,
+		SystemDataDir:   cfg.SystemDataDir,
+		Profile:         cfg.Profile,
+	}
+}
+
 func outputConfigHuman(w io.Writer, output ConfigOutput) error {
 	c := newConfigColorizer(w)
 
@@ -73,6 +99,22 @@ func outputConfigHuman(w io.Writer, output ConfigOutput) error {
 		fmt.Fprintf(w, "  %s %s\n", c.label("Config file:"), c.dim("(none)"))
 	}
 
+	// Profile
+	fmt.Fprintf(w, "  %s", c.label("Profile:"))
+	if output.Profile == nil {
+		fmt.Fprintf(w, " %s\n", c.dim("(none)"))
+	} else {
+		fmt.Fprintf(w, " %s %s\n", output.Profile.Name, c.dim("(match_prefix = "+output.Profile.MatchPrefix+")"))
+		keys := make([]string, 0, len(output.Profile.Settings))
+		for key := range output.Profile.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "    %s = %v\n", key, output.Profile.Settings[key])
+		}
+	}
+
 	// Whitelist prefixes
 	fmt.Fprintf(w, "  %s", c.label("Whitelist prefixes:"))
 	if len(output.WhitelistPrefix) == 0 {
@@ -116,6 +158,22 @@ func outputConfigHuman(w io.Writer, output ConfigOutput) error {
 		fmt.Fprintf(w, " %s\n", c.yellow("false"))
 	}
 
+	// Workspace store
+	fmt.Fprintf(w, "  %s", c.label("Workspace store:"))
+	if output.WorkspaceStore != "" {
+		fmt.Fprintf(w, " %s\n", output.WorkspaceStore)
+	} else {
+		fmt.Fprintf(w, " %s\n", c.dim("(disabled)"))
+	}
+
+	// System store
+	fmt.Fprintf(w, "  %s", c.label("System store:"))
+	if output.SystemDataDir != "" {
+		fmt.Fprintf(w, " %s\n", output.SystemDataDir)
+	} else {
+		fmt.Fprintf(w, " %s\n", c.dim("(disabled)"))
+	}
+
 	return nil
 }
 
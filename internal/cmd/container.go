@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// containerPath is the PATH a container chain is evaluated from, matching
+// the default of common base images rather than the host's PATH.
+const containerPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ContainerManifest records which .envrc files produced an env-file, for
+// embedding as an OCI label.
+type ContainerManifest struct {
+	Dir       string         `json:"dir"`
+	Files     []ManifestFile `json:"files"`
+	Variables []string       `json:"variables"`
+
+	// Sensitive lists the variables (declared with sensitive_env) written as
+	// bare names, which Docker passes through from the host environment.
+	Sensitive []string `json:"sensitive,omitempty"`
+}
+
+// ManifestFile is a single .envrc in a ContainerManifest.
+type ManifestFile struct {
+	Path        string `json:"path"`
+	ContentHash string `json:"content_hash"`
+	Source      string `json:"source"` // "global", "workspace", "trust", "whitelist", "system"
+}
+
+func newExportContainerCmd(stdlib string) *cobra.Command {
+	var output, manifest string
+	var check, partial bool
+
+	cmd := &cobra.Command{
+		Use:   "container [DIR]",
+		Short: "Write a Docker env-file for a directory's cascade",
+		Long: `Evaluate the .envrc chain for DIR (default: current directory) from a
+clean base environment and write the variables it sets as a Docker
+--env-file (KEY=VALUE per line, no quoting).
+
+When writing to a file, a sidecar manifest (<output>.manifest.json) records
+each .envrc's path, content hash, and allow source, for use as an OCI label.
+
+Every .envrc in the chain must be allowed unless --partial is given.
+Values containing newlines cannot be represented and are rejected.`,
+		Example: `  # Write env.list and env.list.manifest.json
+  cascade export container --output env.list
+
+  # Fail if env.list no longer matches the chain (for CI)
+  cascade export container --output env.list --check
+
+  # Use it
+  docker run --env-file env.list myimage`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if manifest == "" && output != "-" {
+				manifest = output + ".manifest.json"
+			}
+			if check {
+				if output == "-" {
+					return errors.New("--check requires --output")
+				}
+				return runContainerCheck(cmd.OutOrStdout(), cmd.ErrOrStderr(), dir, stdlib, output, partial)
+			}
+			return runExportContainer(cmd.OutOrStdout(), cmd.ErrOrStderr(), dir, stdlib, output, manifest, partial)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "Env-file to write (- for stdout)")
+	cmd.Flags().StringVar(&manifest, "manifest", "", "Manifest to write (default: <output>.manifest.json)")
+	cmd.Flags().BoolVar(&check, "check", false, "Compare --output against a fresh evaluation and fail on drift")
+	cmd.Flags().BoolVar(&partial, "partial", false, "Skip .envrc files that are not allowed instead of failing")
+
+	return cmd
+}
+
+func runExportContainer(stdout, stderr io.Writer, dir, stdlib, output, manifest string, partial bool) error {
+	vars, m, err := evaluateContainer(stderr, dir, stdlib, partial)
+	if err != nil {
+		return err
+	}
+
+	envFile, err := formatEnvFile(vars, m.Sensitive)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		if _, err := io.WriteString(stdout, envFile); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(output, []byte(envFile), 0o600); err != nil {
+		return fmt.Errorf("write env-file: %w", err)
+	}
+
+	if manifest != "" {
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal manifest: %w", err)
+		}
+		if err := os.WriteFile(manifest, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func runContainerCheck(stdout, stderr io.Writer, dir, stdlib, output string, partial bool) error {
+	existing, err := readEnvFile(output)
+	if err != nil {
+		return err
+	}
+
+	vars, _, err := evaluateContainer(stderr, dir, stdlib, partial)
+	if err != nil {
+		return err
+	}
+
+	drift := diffEnvFile(existing, vars)
+	if len(drift) == 0 {
+		fmt.Fprintf(stdout, "%s is up to date\n", output)
+		return nil
+	}
+
+	// Report keys only; values may be secrets
+	for _, line := range drift {
+		fmt.Fprintf(stdout, "  %s\n", line)
+	}
+	return fmt.Errorf("%s is out of date (%d variables differ)", output, len(drift))
+}
+
+// evaluateContainer evaluates dir's chain from a clean base and returns the
+// variables it sets along with the provenance manifest. Sensitive variables
+// are returned with empty values, which are never written.
+func evaluateContainer(stderr io.Writer, dir, stdlib string, partial bool) (env.Env, *ContainerManifest, error) {
+	plan, result, vars, err := evaluateClean(stderr, dir, stdlib, partial, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowed := plan.Filter(allow.Allowed)
+	m := &ContainerManifest{
+		Dir:       plan.Target,
+		Files:     make([]ManifestFile, 0, len(allowed)),
+		Variables: sortedKeys(vars),
+	}
+	for _, name := range result.Sensitive {
+		if _, ok := vars[name]; ok {
+			vars[name] = ""
+			m.Sensitive = append(m.Sensitive, name)
+		}
+	}
+	for _, level := range allowed {
+		m.Files = append(m.Files, ManifestFile{
+			Path:        level.RC.Path,
+			ContentHash: level.RC.ContentHash,
+			Source:      string(level.Source),
+		})
+	}
+
+	return vars, m, nil
+}
+
+// evaluateClean evaluates dir's chain from the clean container base and
+// returns the plan, the run result, and the variables the chain set or
+// changed. Every .envrc must be allowed unless partial is set.
+func evaluateClean(stderr io.Writer, dir, stdlib string, partial, useCache bool) (*run.Plan, *run.Result, env.Env, error) {
+	plan, err := planDir(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(plan.Levels) == 0 {
+		return nil, nil, nil, fmt.Errorf("no .envrc files found for %s", plan.Target)
+	}
+
+	var blocked []string
+	for _, level := range plan.Levels {
+		if level.Status != allow.Allowed {
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", level.RC.Path, level.Status))
+		}
+	}
+	if len(blocked) > 0 && !partial {
+		return nil, nil, nil, fmt.Errorf("chain is not fully allowed (use --partial to skip):\n  %s", strings.Join(blocked, "\n  "))
+	}
+
+	if len(plan.Filter(allow.Allowed)) == 0 {
+		return nil, nil, nil, fmt.Errorf("no allowed .envrc files for %s", plan.Target)
+	}
+
+	evaluator, err := newEvaluator(stderr, stdlib, useCache)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	base := containerBaseEnv()
+	result := run.Run(plan, base, evaluator, run.Options{})
+	if result.Err != nil {
+		return nil, nil, nil, fmt.Errorf("evaluate %s: %w", result.Failed.RC.Path, result.Err)
+	}
+
+	// Keep only what the chain set or changed
+	vars := make(env.Env)
+	for key, value := range result.Env {
+		if env.IgnoredEnv(key) {
+			continue
+		}
+		if prev, ok := base[key]; ok && prev == value {
+			continue
+		}
+		vars[key] = value
+	}
+
+	return plan, result, vars, nil
+}
+
+// containerBaseEnv returns the clean base environment for container
+// evaluation: a standard PATH plus the identity variables .envrc files
+// commonly rely on.
+func containerBaseEnv() env.Env {
+	base := env.Env{"PATH": containerPath}
+	for _, key := range []string{"HOME", "USER"} {
+		if value, ok := os.LookupEnv(key); ok {
+			base[key] = value
+		}
+	}
+	return base
+}
+
+// formatEnvFile renders vars as a Docker env-file. Docker reads values
+// verbatim up to the end of the line, so values with newlines are rejected.
+// Variables in passThrough are written as bare names, which Docker fills in
+// from the environment of the docker command.
+func formatEnvFile(vars env.Env, passThrough []string) (string, error) {
+	var invalid []string
+	var b strings.Builder
+	for _, key := range sortedKeys(vars) {
+		if slices.Contains(passThrough, key) {
+			fmt.Fprintf(&b, "%s\n", key)
+			continue
+		}
+		value := vars[key]
+		if strings.ContainsAny(value, "\r\n") {
+			invalid = append(invalid, key)
+			continue
+		}
+		fmt.Fprintf(&b, "%s=%s\n", key, value)
+	}
+
+	if len(invalid) > 0 {
+		return "", fmt.Errorf("values of %s contain newlines, which env-files cannot represent", strings.Join(invalid, ", "))
+	}
+	return b.String(), nil
+}
+
+// readEnvFile parses a Docker env-file, skipping blank lines and comments.
+func readEnvFile(path string) (env.Env, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read env-file: %w", err)
+	}
+	defer file.Close()
+
+	vars := make(env.Env)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		vars[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read env-file: %w", err)
+	}
+
+	return vars, nil
+}
+
+// diffEnvFile describes how fresh differs from existing, by key only.
+func diffEnvFile(existing, fresh env.Env) []string {
+	var drift []string
+	for _, key := range sortedKeys(fresh) {
+		old, ok := existing[key]
+		switch {
+		case !ok:
+			drift = append(drift, "+ "+key)
+		case old != fresh[key]:
+			drift = append(drift, "~ "+key)
+		}
+	}
+	for _, key := range sortedKeys(existing) {
+		if _, ok := fresh[key]; !ok {
+			drift = append(drift, "- "+key)
+		}
+	}
+	return drift
+}
+
+func sortedKeys(vars env.Env) []string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/env"
+)
+
+func TestFormatEnvFile(t *testing.T) {
+	got, err := formatEnvFile(env.Env{"B": "two words", "A": "x=y"}, nil)
+	if err != nil {
+		t.Fatalf("formatEnvFile: %v", err)
+	}
+	if want := "A=x=y\nB=two words\n"; got != want {
+		t.Errorf("formatEnvFile() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatEnvFile_PassThrough(t *testing.T) {
+	got, err := formatEnvFile(env.Env{"A": "1", "TOKEN": "s3cret\nwith newline"}, []string{"TOKEN"})
+	if err != nil {
+		t.Fatalf("formatEnvFile: %v", err)
+	}
+	if want := "A=1\nTOKEN\n"; got != want {
+		t.Errorf("formatEnvFile() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatEnvFile_RejectsNewlines(t *testing.T) {
+	_, err := formatEnvFile(env.Env{"OK": "fine", "CERT": "a\nb", "KEY": "c\r\nd"}, nil)
+	if err == nil {
+		t.Fatal("expected error for values with newlines")
+	}
+	if !strings.Contains(err.Error(), "CERT, KEY") {
+		t.Errorf("error = %q, want it to list CERT, KEY", err)
+	}
+	if strings.Contains(err.Error(), "OK") {
+		t.Errorf("error = %q, should not list valid keys", err)
+	}
+}
+
+func TestReadEnvFile_RoundTrip(t *testing.T) {
+	vars := env.Env{"A": "1", "EMPTY": "", "URL": "postgres://u:p@h/db?x=1", "TOKEN": ""}
+	data, err := formatEnvFile(vars, []string{"TOKEN"})
+	if err != nil {
+		t.Fatalf("formatEnvFile: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "env.list")
+	if err := os.WriteFile(path, []byte("# comment\n\n"+data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readEnvFile(path)
+	if err != nil {
+		t.Fatalf("readEnvFile: %v", err)
+	}
+	if len(diffEnvFile(got, vars)) != 0 {
+		t.Errorf("readEnvFile() = %v, want %v", got, vars)
+	}
+}
+
+func TestDiffEnvFile(t *testing.T) {
+	existing := env.Env{"SAME": "1", "CHANGED": "old", "GONE": "x"}
+	fresh := env.Env{"SAME": "1", "CHANGED": "new", "NEW": "y"}
+
+	got := diffEnvFile(existing, fresh)
+	want := []string{"~ CHANGED", "+ NEW", "- GONE"}
+	if !slices.Equal(got, want) {
+		t.Errorf("diffEnvFile() = %v, want %v", got, want)
+	}
+}
@@ -1,8 +1,9 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
 
 	"github.com/spf13/cobra"
 
@@ -11,22 +12,67 @@ import (
 )
 
 func newDenyCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		listOpts  recordListOptions
+		checkMode bool
+		fromStdin bool
+	)
+
+	cmd := &cobra.Command{
 		Use:   "deny [path]",
 		Short: "Deny an .envrc file from being loaded",
 		Long: `Revoke trust for an .envrc file, preventing it from being evaluated.
-If no path is provided, defaults to ./.envrc in the current directory.`,
-		Args: cobra.MaximumNArgs(1),
+If no path is provided, defaults to ./.envrc in the current directory. A
+directory stands for the .envrc in it, which must exist; a path to a file
+can be denied before the file is created.
+
+Use --list to show every denied file.
+
+Denying prints "cascade: denied <path>", or "cascade: unchanged <path>" if
+the file was already denied, in which case nothing is written.
+--check-mode and --json work as for allow.
+
+Use --stdin to deny each .envrc listed on standard input, one path per
+line, as for allow --stdin.`,
+		Example: `  cascade deny                          # Block ./.envrc
+  cascade deny ~/Downloads/repo/.envrc
+  cascade deny ~/Downloads/repo         # Same, by directory
+  cascade deny --list
+  cascade deny --stdin < envrcs.txt`,
+		Annotations:       map[string]string{envAnnotation: dataEnv},
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChainEnvrc(isAllowed),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			path := ".envrc"
-			if len(args) > 0 {
-				path = args[0]
+			if fromStdin {
+				switch {
+				case len(args) > 0:
+					return errors.New("--stdin cannot be used with a path")
+				case listOpts.list, checkMode, listOpts.json:
+					return errors.New("--stdin cannot be used with --list, --check-mode or --json")
+				}
+				store, err := newAllowStore()
+				if err != nil {
+					return fmt.Errorf("create allow store: %w", err)
+				}
+				store = store.WithTrigger("cascade deny --stdin")
+				return runBatch(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), "denied", func(path string) error {
+					return denyListed(cmd.OutOrStdout(), store, path)
+				})
+			}
+			if listOpts.list {
+				if checkMode {
+					return errors.New("--check-mode cannot be used with --list")
+				}
+				store, err := newAllowStore()
+				if err != nil {
+					return fmt.Errorf("create allow store: %w", err)
+				}
+				return runRecordList(cmd.OutOrStdout(), args, store, allow.KindDeny, listOpts)
 			}
 
-			// Resolve to absolute path
-			absPath, err := filepath.Abs(path)
+			absPath, err := envrcArg(args)
 			if err != nil {
-				return fmt.Errorf("resolve path: %w", err)
+				return err
 			}
 
 			// Create RC - file doesn't need to exist for deny
@@ -36,18 +82,45 @@ If no path is provided, defaults to ./.envrc in the current directory.`,
 			}
 
 			// Create allow store
-			store, err := allow.NewStore()
+			store, err := newAllowStore()
 			if err != nil {
 				return fmt.Errorf("create allow store: %w", err)
 			}
 
-			// Deny the file
-			if err := store.Deny(rc); err != nil {
-				return fmt.Errorf("deny file: %w", err)
+			change := store.DenyChange(rc)
+			if !checkMode {
+				// Deny the file
+				if err := store.WithTrigger("cascade deny").Deny(rc); err != nil {
+					return fmt.Errorf("deny file: %w", err)
+				}
 			}
 
-			fmt.Fprintf(cmd.OutOrStdout(), "cascade: denied %s\n", rc.Path)
-			return nil
+			return printChange(cmd.OutOrStdout(), change, rc.Path, checkMode, listOpts.json)
 		},
 	}
+
+	cmd.Flags().BoolVar(&fromStdin, "stdin", false, "Deny the .envrc files listed on stdin, one per line")
+	addRecordListFlags(cmd, &listOpts, "denied .envrc files")
+	addCheckModeFlag(cmd, &checkMode)
+
+	return cmd
+}
+
+// denyListed denies the .envrc at path for deny --stdin, which need not
+// exist, printing its change as deny does.
+func denyListed(stdout io.Writer, store *allow.Store, path string) error {
+	absPath, err := envrcArg([]string{path})
+	if err != nil {
+		return err
+	}
+	rc, err := envrc.NewRC(absPath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	change := store.DenyChange(rc)
+	if err := store.Deny(rc); err != nil {
+		return err
+	}
+	return printChange(stdout, change, rc.Path, false, false)
 }
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// DiffOutput is the JSON representation of cascade diff.
+type DiffOutput struct {
+	OutputHeader
+	DirA    string                `json:"dir_a"`
+	DirB    string                `json:"dir_b"`
+	OnlyA   map[string]string     `json:"only_a"`  // Variables set in A's environment only
+	OnlyB   map[string]string     `json:"only_b"`  // Variables set in B's environment only
+	Changed map[string]DiffChange `json:"changed"` // Variables set in both, to different values
+}
+
+// DiffChange is a variable whose value differs between the two directories.
+type DiffChange struct {
+	A string `json:"a"`
+	B string `json:"b"`
+
+	// Separator is set for list variables (PATH-like or merge_var), whose
+	// entries found on one side only are listed in OnlyA and OnlyB.
+	Separator string   `json:"separator,omitempty"`
+	OnlyA     []string `json:"only_a,omitempty"`
+	OnlyB     []string `json:"only_b,omitempty"`
+}
+
+func newDiffCmd(stdlib string) *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "diff DIR_A DIR_B",
+		Short: "Compare the environments of two directories",
+		Long: `Evaluate the .envrc chains of DIR_A and DIR_B, as export would for a
+shell in each, and print the variables that differ: those set in one
+environment only, and those set in both to different values. For PATH-like
+and merge_var variables, the entries found on one side only are listed
+instead of the whole values.
+
+Files are authorized as by export: a denied file in either chain is an
+error, and files that are not allowed are skipped with a warning. Both
+chains start from this shell's environment with the changes its hook
+applied undone. Values of sensitive_env variables are redacted.`,
+		Example: `  cascade diff ~/work/api ~/work/web
+  cascade diff . ../other --json | jq .changed`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, args[0], args[1], jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func runDiff(stdout, stderr io.Writer, stdlib, dirA, dirB string, jsonOutput bool) error {
+	base := revertedEnv(stderr)
+	sideA, err := diffSide(stderr, stdlib, dirA, base)
+	if err != nil {
+		return err
+	}
+	sideB, err := diffSide(stderr, stdlib, dirB, base)
+	if err != nil {
+		return err
+	}
+
+	output := compareSides(sideA, sideB)
+	output.DirA, output.DirB = sideA.dir, sideB.dir
+	if jsonOutput {
+		output.OutputHeader = newOutputHeader()
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
+	}
+	printEnvDiff(stdout, newColorizer(stdout), output)
+	return nil
+}
+
+// diffEnv is one side of cascade diff: the environment a directory's chain
+// produces.
+type diffEnv struct {
+	dir   string        // Target directory of the chain
+	vars  env.Env       // Resulting environment, sensitive values redacted
+	merge env.MergeSpec // List-merged variables
+}
+
+// diffSide evaluates the allowed levels of dir's chain from base.
+func diffSide(stderr io.Writer, stdlib, dir string, base env.Env) (*diffEnv, error) {
+	plan, err := planDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if denied := plan.Filter(allow.Denied); len(denied) > 0 {
+		paths := make([]string, len(denied))
+		for i, level := range denied {
+			paths[i] = level.RC.Path
+		}
+		return nil, fmt.Errorf("cannot evaluate %s: the chain contains denied files (run `cascade allow` to unblock):\n  %s",
+			plan.Target, strings.Join(paths, "\n  "))
+	}
+	for _, level := range plan.Filter(allow.NotAllowed) {
+		fmt.Fprintf(stderr, "cascade: %s is not allowed. Run `cascade allow %s` to allow.\n", level.RC.Path, level.RC.Path)
+	}
+
+	side := &diffEnv{dir: plan.Target, vars: base}
+	allowed := plan.Filter(allow.Allowed)
+	if len(allowed) == 0 {
+		return side, nil
+	}
+	evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled)
+	if err != nil {
+		return nil, err
+	}
+	result := run.Run(plan, base, evaluator, run.Options{Optional: optionalRoot(plan, allowed)})
+	if result.Err != nil {
+		if errors.Is(result.Err, envrc.ErrChanged) {
+			return nil, fmt.Errorf("%s changed between approval and evaluation, re-run `cascade allow %s`", result.Failed.RC.Path, result.Failed.RC.Path)
+		}
+		return nil, fmt.Errorf("evaluate %s: %w", result.Failed.RC.Path, result.Err)
+	}
+	for _, level := range allowed {
+		if level.Err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: %s failed, continuing without it (root_envrc = %q): %v\n", level.RC.Path, cfg.RootEnvrc, level.Err)
+		}
+	}
+	side.vars = redactNames(result.Env, result.Sensitive)
+	side.merge = result.Merge
+	return side, nil
+}
+
+// compareSides returns the variables whose values differ between a and b.
+func compareSides(a, b *diffEnv) DiffOutput {
+	output := DiffOutput{
+		OnlyA:   map[string]string{},
+		OnlyB:   map[string]string{},
+		Changed: map[string]DiffChange{},
+	}
+	for name, valueA := range a.vars {
+		if env.IgnoredEnv(name) {
+			continue
+		}
+		valueB, ok := b.vars[name]
+		switch {
+		case !ok:
+			output.OnlyA[name] = valueA
+		case valueA != valueB:
+			change := DiffChange{A: valueA, B: valueB}
+			if sep, ok := listSeparator(name, a.merge, b.merge); ok {
+				change.Separator = sep
+				change.OnlyB, change.OnlyA = env.ListDiff(valueA, valueB, sep)
+			}
+			output.Changed[name] = change
+		}
+	}
+	for name, valueB := range b.vars {
+		if _, ok := a.vars[name]; !ok && !env.IgnoredEnv(name) {
+			output.OnlyB[name] = valueB
+		}
+	}
+	return output
+}
+
+// listSeparator returns the separator of a list variable: the one
+// merge_var declared on either side, or the path list separator for
+// PATH-like variables.
+func listSeparator(name string, merges ...env.MergeSpec) (string, bool) {
+	for _, merge := range merges {
+		if sep, ok := merge[name]; ok {
+			return sep, true
+		}
+	}
+	if treeIsPathLikeVar(name) {
+		return string(os.PathListSeparator), true
+	}
+	return "", false
+}
+
+// printEnvDiff writes the human form of output: variables only in A marked
+// "-", only in B marked "+", and changed ones marked "~" with both values,
+// or for list variables the entries on one side only.
+func printEnvDiff(w io.Writer, c *colorizer, output DiffOutput) {
+	home, _ := os.UserHomeDir()
+	if len(output.OnlyA)+len(output.OnlyB)+len(output.Changed) == 0 {
+		fmt.Fprintf(w, "%s\n", c.dim("No differences between "+shortenPath(output.DirA, home)+" and "+shortenPath(output.DirB, home)))
+		return
+	}
+
+	fmt.Fprintln(w, c.red("--- "+shortenPath(output.DirA, home)))
+	fmt.Fprintln(w, c.green("+++ "+shortenPath(output.DirB, home)))
+	for _, name := range sortedKeys(output.OnlyA) {
+		fmt.Fprintln(w, c.red("- "+name+"="+output.OnlyA[name]))
+	}
+	for _, name := range sortedKeys(output.OnlyB) {
+		fmt.Fprintln(w, c.green("+ "+name+"="+output.OnlyB[name]))
+	}
+
+	names := make([]string, 0, len(output.Changed))
+	for name := range output.Changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		change := output.Changed[name]
+		fmt.Fprintln(w, c.yellow("~ "+name))
+		if change.Separator == "" {
+			fmt.Fprintln(w, c.red("    - "+change.A))
+			fmt.Fprintln(w, c.green("    + "+change.B))
+			continue
+		}
+		for _, entry := range change.OnlyA {
+			fmt.Fprintln(w, c.red("    - "+entry))
+		}
+		for _, entry := range change.OnlyB {
+			fmt.Fprintln(w, c.green("    + "+entry))
+		}
+		if len(change.OnlyA)+len(change.OnlyB) == 0 {
+			fmt.Fprintln(w, c.dim("    (same entries in another order)"))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/run"
+	"github.com/unrss/cascade/internal/state"
+)
+
+// Divergence compares the variables the .envrc chain would set with the
+// environment of the shell cascade was run from.
+type Divergence struct {
+	Source    string         `json:"source"`    // "state" (saved by export) or "evaluation"
+	Expected  int            `json:"expected"`  // Variables the chain sets or unsets
+	Variables []DivergentVar `json:"variables"` // Variables the shell disagrees on
+}
+
+// DivergentVar is a variable whose value in the shell differs from what the
+// chain would set.
+type DivergentVar struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"` // "missing", "differs", "not_unset"
+
+	// MissingEntries lists the components the chain adds to a list variable
+	// (PATH-like or merge_var) that the shell's value lacks.
+	MissingEntries []string `json:"missing_entries,omitempty"`
+}
+
+// Missing returns the number of variables the shell does not have at all.
+func (d *Divergence) Missing() int {
+	n := 0
+	for _, v := range d.Variables {
+		if v.Reason == "missing" {
+			n++
+		}
+	}
+	return n
+}
+
+// gatherDivergence compares the chain's expected variables with current.
+// The expected variables come from the state export saved for the chain
+// when it is still fresh, otherwise from evaluating the chain through the
+// evaluation cache. Returns nil if no .envrc in the chain is allowed.
+func gatherDivergence(stderr io.Writer, stdlib string, plan *run.Plan, current env.Env) (*Divergence, error) {
+	allowed := plan.Filter(allow.Allowed)
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+
+	source := "state"
+	expected := freshStateDiff(allowed)
+	if expected == nil {
+		evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled)
+		if err != nil {
+			return nil, err
+		}
+		base := revertedEnvFrom(stderr, current)
+		result := run.Run(plan, base, evaluator, run.Options{
+			ContinueOnError: true,
+			Progress:        warnOnLevelError(stderr),
+		})
+		source = "evaluation"
+		expected = env.BuildEnvDiff(base, result.Env)
+		expected.Merge = result.Merge.Subset(expected.Next)
+		expected = expected.Conceal(result.Sensitive)
+	}
+
+	return compareDiff(source, expected, current), nil
+}
+
+// freshStateDiff returns the diff export saved for the chain ending at the
+// deepest allowed level, or nil if there is none or an allowed .envrc has
+// changed since it was saved.
+func freshStateDiff(allowed []*run.Level) *env.EnvDiff {
+	stateStore, err := state.NewStore()
+	if err != nil {
+		return nil
+	}
+	leaf := allowed[len(allowed)-1].RC
+	st, err := stateStore.Load(leaf.Path)
+	if err != nil || st == nil || st.Diff == nil || st.ContentHash != leaf.ContentHash {
+		return nil
+	}
+	for _, level := range allowed {
+		info, err := os.Stat(level.RC.Path)
+		if err != nil || info.ModTime().After(st.Timestamp) {
+			return nil
+		}
+	}
+	return st.Diff
+}
+
+// compareDiff checks every variable in expected against current. Values of
+// sensitive variables are unknown, so for them only presence is checked.
+func compareDiff(source string, expected *env.EnvDiff, current env.Env) *Divergence {
+	d := &Divergence{
+		Source:    source,
+		Expected:  len(expected.Next) + len(expected.Sensitive),
+		Variables: []DivergentVar{},
+	}
+
+	names := make([]string, 0, d.Expected)
+	for name := range expected.Next {
+		names = append(names, name)
+	}
+	names = append(names, expected.Sensitive...)
+	sort.Strings(names)
+
+	for _, name := range names {
+		if slices.Contains(expected.Sensitive, name) {
+			if _, ok := current[name]; !ok {
+				d.Variables = append(d.Variables, DivergentVar{Name: name, Reason: "missing"})
+			}
+			continue
+		}
+		sep := expected.Merge[name]
+		if sep == "" && isPathLikeVar(name) {
+			sep = ":"
+		}
+		if v := compareVar(name, expected.Prev[name], expected.Next[name], sep, current); v != nil {
+			d.Variables = append(d.Variables, *v)
+		}
+	}
+	return d
+}
+
+// compareVar checks one variable the chain changes from prev to next
+// (next empty and prev set meaning unset) against current. For list
+// variables only the components the chain adds must be present, since the
+// rest of the value belongs to the shell. Returns nil if current agrees.
+func compareVar(name, prev, next, sep string, current env.Env) *DivergentVar {
+	actual, ok := current[name]
+	switch {
+	case next == "" && prev != "":
+		if ok {
+			return &DivergentVar{Name: name, Reason: "not_unset"}
+		}
+	case !ok:
+		return &DivergentVar{Name: name, Reason: "missing"}
+	case sep != "" && prev != "":
+		added, _ := env.ListDiff(prev, next, sep)
+		have := env.SplitList(actual, sep)
+		var missing []string
+		for _, part := range added {
+			if !slices.Contains(have, part) {
+				missing = append(missing, part)
+			}
+		}
+		if len(missing) > 0 {
+			return &DivergentVar{Name: name, Reason: "differs", MissingEntries: missing}
+		}
+	case actual != next:
+		return &DivergentVar{Name: name, Reason: "differs"}
+	}
+	return nil
+}
+
+// divergenceSummary describes d in one line, or "" if the shell agrees
+// with the chain.
+func divergenceSummary(d *Divergence, active bool) string {
+	if len(d.Variables) == 0 {
+		return ""
+	}
+
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 variable"
+		}
+		return fmt.Sprintf("%d variables", n)
+	}
+
+	var summary string
+	if missing := d.Missing(); missing > 0 {
+		summary = fmt.Sprintf("This shell is missing %s the chain would set", plural(missing))
+		switch other := len(d.Variables) - missing; {
+		case other == 1:
+			summary += ", and 1 more differs"
+		case other > 1:
+			summary += fmt.Sprintf(", and %d more differ", other)
+		}
+	} else {
+		summary = fmt.Sprintf("This shell has %s that differ from what the chain would set", plural(len(d.Variables)))
+	}
+	if !active {
+		summary += " (hook not active here?)"
+	}
+	return summary
+}
+
+// printDivergence writes the human form of d, marking the variables in
+// changed.
+func printDivergence(w io.Writer, c *colorizer, d *Divergence, active bool, changed map[string]bool) {
+	summary := divergenceSummary(d, active)
+	if summary == "" {
+		fmt.Fprintf(w, "%s\n\n", c.dim("Shell environment matches the .envrc chain"))
+		return
+	}
+
+	fmt.Fprintf(w, "%s %s\n", c.yellow("⚠"), summary)
+	for _, v := range d.Variables {
+		mark := changedMark(c, changed[v.Name])
+		switch {
+		case len(v.MissingEntries) > 0:
+			fmt.Fprintf(w, "  %s %s%s\n", v.Name, c.dim("(missing "+strings.Join(v.MissingEntries, ", ")+")"), mark)
+		case v.Reason == "not_unset":
+			fmt.Fprintf(w, "  %s %s%s\n", v.Name, c.dim("(should be unset)"), mark)
+		default:
+			fmt.Fprintf(w, "  %s %s%s\n", v.Name, c.dim("("+v.Reason+")"), mark)
+		}
+	}
+	fmt.Fprintln(w)
+}
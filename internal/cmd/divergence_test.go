@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/state"
+)
+
+func TestCompareDiff(t *testing.T) {
+	expected := &env.EnvDiff{
+		Prev: map[string]string{
+			"PATH":   "/usr/bin",
+			"OLD":    "gone",
+			"LIST":   "a,b",
+			"SAME":   "",
+			"FOO":    "",
+			"OTHER":  "",
+			"CHANGE": "before",
+		},
+		Next: map[string]string{
+			"PATH":   "/proj/bin:/usr/bin",
+			"OLD":    "",
+			"LIST":   "a,b,c",
+			"SAME":   "same",
+			"FOO":    "foo",
+			"OTHER":  "other",
+			"CHANGE": "after",
+		},
+		Merge: env.MergeSpec{"LIST": ","},
+	}
+
+	tests := []struct {
+		name    string
+		current env.Env
+		want    map[string]string // name -> reason
+	}{
+		{
+			name: "in sync",
+			current: env.Env{
+				"PATH": "/proj/bin:/usr/local/bin:/bin", "LIST": "c,a", "SAME": "same",
+				"FOO": "foo", "OTHER": "other", "CHANGE": "after",
+			},
+			want: map[string]string{},
+		},
+		{
+			name:    "hook never ran",
+			current: env.Env{"PATH": "/usr/bin", "OLD": "gone", "LIST": "a,b", "CHANGE": "before"},
+			want: map[string]string{
+				"PATH": "differs", "OLD": "not_unset", "LIST": "differs", "SAME": "missing",
+				"FOO": "missing", "OTHER": "missing", "CHANGE": "differs",
+			},
+		},
+		{
+			name: "one stale value",
+			current: env.Env{
+				"PATH": "/proj/bin:/usr/bin", "LIST": "a,b,c", "SAME": "same",
+				"FOO": "foo", "OTHER": "other", "CHANGE": "edited by hand",
+			},
+			want: map[string]string{"CHANGE": "differs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := compareDiff("state", expected, tt.current)
+			if d.Expected != len(expected.Next) {
+				t.Errorf("Expected = %d, want %d", d.Expected, len(expected.Next))
+			}
+			got := make(map[string]string)
+			for _, v := range d.Variables {
+				got[v.Name] = v.Reason
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("divergent = %v, want %v", got, tt.want)
+			}
+			for name, reason := range tt.want {
+				if got[name] != reason {
+					t.Errorf("%s: reason = %q, want %q", name, got[name], reason)
+				}
+			}
+		})
+	}
+
+	d := compareDiff("state", expected, env.Env{"PATH": "/usr/bin", "LIST": "b"})
+	for _, v := range d.Variables {
+		switch v.Name {
+		case "PATH":
+			if !slices.Equal(v.MissingEntries, []string{"/proj/bin"}) {
+				t.Errorf("PATH missing entries = %v, want [/proj/bin]", v.MissingEntries)
+			}
+		case "LIST":
+			if !slices.Equal(v.MissingEntries, []string{"c"}) {
+				t.Errorf("LIST missing entries = %v, want [c]", v.MissingEntries)
+			}
+		}
+	}
+
+	// Sensitive variables have no value to compare; only presence counts
+	concealed := expected.Conceal([]string{"FOO"})
+	d = compareDiff("state", concealed, env.Env{
+		"PATH": "/proj/bin:/usr/bin", "LIST": "a,b,c", "SAME": "same",
+		"FOO": "anything", "OTHER": "other", "CHANGE": "after",
+	})
+	if d.Expected != len(expected.Next) || len(d.Variables) != 0 {
+		t.Errorf("with FOO present: Expected = %d, divergent = %v", d.Expected, d.Variables)
+	}
+	d = compareDiff("state", concealed, env.Env{
+		"PATH": "/proj/bin:/usr/bin", "LIST": "a,b,c", "SAME": "same",
+		"OTHER": "other", "CHANGE": "after",
+	})
+	if len(d.Variables) != 1 || d.Variables[0].Name != "FOO" || d.Variables[0].Reason != "missing" {
+		t.Errorf("with FOO absent: divergent = %v, want FOO missing", d.Variables)
+	}
+}
+
+func TestDivergenceSummary(t *testing.T) {
+	d := &Divergence{Variables: []DivergentVar{
+		{Name: "A", Reason: "missing"},
+		{Name: "B", Reason: "missing"},
+		{Name: "C", Reason: "missing"},
+		{Name: "D", Reason: "missing"},
+		{Name: "E", Reason: "differs"},
+	}}
+
+	want := "This shell is missing 4 variables the chain would set, and 1 more differs (hook not active here?)"
+	if got := divergenceSummary(d, false); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if got := divergenceSummary(d, true); strings.Contains(got, "hook") {
+		t.Errorf("summary for an active shell mentions the hook: %q", got)
+	}
+	if got := divergenceSummary(&Divergence{}, false); got != "" {
+		t.Errorf("summary with no divergence = %q, want empty", got)
+	}
+}
+
+func TestGatherDivergence_FromState(t *testing.T) {
+	store, paths := setupFixChain(t)
+	for _, path := range paths {
+		if err := store.Allow(mustRC(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := planCurrentDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// State as export would have saved it for the chain
+	stateStore, err := state.NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &env.EnvDiff{
+		Prev: map[string]string{"LEVEL": "", "TOKEN": ""},
+		Next: map[string]string{"LEVEL": "project", "TOKEN": "x"},
+	}
+	leaf := mustRC(t, paths[len(paths)-1])
+	if err := stateStore.Save(leaf.Path, leaf.ContentHash, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// A shell that never ran the hook: no CASCADE_DIFF, no chain variables.
+	// The state is fresh, so nothing is evaluated (stdlib is empty).
+	current := env.Env{"PATH": "/usr/bin", "TOKEN": "stale"}
+	d, err := gatherDivergence(io.Discard, "", plan, current)
+	if err != nil {
+		t.Fatalf("gatherDivergence: %v", err)
+	}
+	if d.Source != "state" {
+		t.Errorf("Source = %q, want state", d.Source)
+	}
+	if d.Missing() != 1 || len(d.Variables) != 2 {
+		t.Errorf("divergence = %+v, want LEVEL missing and TOKEN differing", d.Variables)
+	}
+
+	var out bytes.Buffer
+	status := &StatusOutput{Divergence: d}
+	if err := outputHuman(&out, status, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "This shell is missing 1 variable the chain would set") {
+		t.Errorf("status output missing summary:\n%s", out.String())
+	}
+
+	// Editing an .envrc after the state was saved makes it stale
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(paths[0], future, future); err != nil {
+		t.Fatal(err)
+	}
+	if freshStateDiff(plan.Filter(allow.Allowed)) != nil {
+		t.Error("freshStateDiff() used state older than an .envrc")
+	}
+}
@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envAnnotation lists the environment variables a command reads or writes,
+// one "NAME: description" per line. They make up the ENVIRONMENT section
+// of its man page.
+const envAnnotation = "cascade.env"
+
+// overviewSection is a section of the cascade(1) overview beyond what the
+// root command's help describes.
+type overviewSection struct {
+	title string
+	body  string
+}
+
+// overviewSections are added to the page for the root command.
+var overviewSections = []overviewSection{
+	{"SHELL SETUP", `Add the hook to your shell's startup file, then open a new shell:
+
+  # ~/.bashrc
+  eval "$(cascade hook bash)"
+
+  # ~/.zshrc
+  eval "$(cascade hook zsh)"
+
+  # ~/.config/fish/config.fish
+  cascade hook fish | source
+
+At every prompt the hook runs cascade export, which evaluates the .envrc
+chain from the cascade root (default: $HOME) down to the current directory
+and applies the difference to the shell. Leaving the chain restores what
+was there before.`},
+	{"ALLOW MODEL", `No .envrc is evaluated until it is approved:
+
+  - allow approves one file by the SHA-256 of its path and content. Any
+    edit makes it not allowed again until it is re-allowed.
+  - deny blocks a file by path. It takes precedence over allow and trust.
+  - trust approves every .envrc under a directory, present and future.
+
+The hash is checked again when a file is evaluated, and bash sources a
+private copy of exactly the approved bytes. Approvals live in
+$XDG_DATA_HOME/cascade (default ~/.local/share/cascade), with an optional
+read-only system store and an optional store inside the workspace. Every
+change is recorded in an audit log; see cascade audit.`},
+}
+
+// shellStateEnv documents the variables cascade keeps in the shell, and
+// those it sets while an .envrc is evaluated.
+var shellStateEnv = []string{
+	"CASCADE_DIFF: Changes applied by the last export, used to revert them: a v2: prefix, zlib+base64 JSON, then . and a checksum (a v1: prefix is also read)",
+	"CASCADE_DIR: Directory of the deepest .envrc loaded; while an .envrc is evaluated, its own directory",
+	"CASCADE_FILE: Path of the deepest .envrc loaded",
+	"DIRENV_DIR, DIRENV_FILE: Copies of CASCADE_DIR and CASCADE_FILE, with direnv_compat",
+	"CASCADE_WATCHES: Files whose changes make the next prompt evaluate the chain again",
+	"CASCADE_NEGCACHE: Directory mtimes recorded where no .envrc applied, so the next prompt there skips chain discovery",
+	"CASCADE_HOOK_VERSION: Version of cascade that generated the shell hook",
+	"CASCADE_HOOK_CHECKED: Set once export has compared the hook version with the cascade on PATH",
+	"CASCADE_BIN: Path of the cascade binary, set while an .envrc is evaluated",
+	"CASCADE_RC_HASH: Content hash of the .envrc being evaluated",
+	"CASCADE_DUMP_FORMAT: Set to json-v2 while an .envrc is evaluated, so the environment dump carries a KEY=VALUE fallback",
+	"CASCADE_ROOT_DIR: The cascade root, set while an .envrc is evaluated (used by source_up)",
+	"CASCADE_REFRESH: When set, cached results are ignored and cache_output re-runs its commands",
+	"CASCADE_DRY_RUN: Set while export --dry-run or --explain evaluates an .envrc, so cache_output stores nothing",
+	"CASCADE_DATA_DIR: Location of the allow store instead of $XDG_DATA_HOME/cascade, like --data-dir, and of state (which --data-dir leaves in place)",
+	"CASCADE_<KEY>: Overrides the config file setting <key>, e.g. CASCADE_LOG_ENV_DIFF=false",
+	"XDG_CONFIG_HOME: Location of cascade/config.toml (default ~/.config)",
+	"XDG_DATA_HOME: Location of the allow store, state, and audit log (default ~/.local/share, %AppData% on Windows)",
+	"XDG_CACHE_HOME: Location of the evaluation cache (default ~/.cache, %LocalAppData% on Windows)",
+	"NO_COLOR: Disables colored output",
+}
+
+// dataEnv documents the variables read by commands that use the allow store.
+const dataEnv = `XDG_DATA_HOME: Location of the allow store (default ~/.local/share, %AppData% on Windows)
+CASCADE_DATA_DIR: Location of the allow store and state instead of $XDG_DATA_HOME/cascade; --data-dir moves only the allow store`
+
+// cacheEnv documents the variable read by commands that use the cache.
+const cacheEnv = "XDG_CACHE_HOME: Location of the evaluation cache (default ~/.cache, %LocalAppData% on Windows)"
+
+// shellEnv documents the variables read by commands that describe the
+// environment export applied to this shell.
+const shellEnv = `CASCADE_DIFF: Changes applied to this shell by the last export
+CASCADE_DIR: Directory of the deepest .envrc loaded in this shell
+CASCADE_WATCHES: Files export watches for changes`
+
+func newDocsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "docs",
+		Short:       "Generate documentation",
+		Long:        `Generate man pages or markdown for every cascade command, for packagers and the website.`,
+		Hidden:      true,
+		Annotations: map[string]string{skipConfig: ""},
+	}
+
+	var manDir, mdDir string
+	man := &cobra.Command{
+		Use:   "man",
+		Short: "Write troff man pages",
+		Long: `Write a man page (section 1) for every command to --output-dir, plus the
+cascade(1) overview. The date on each page is taken from SOURCE_DATE_EPOCH
+when set, so builds are reproducible.`,
+		Example:     `  cascade docs man --output-dir share/man/man1`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipConfig: "", envAnnotation: "SOURCE_DATE_EPOCH: Date to print on each page, in seconds since the epoch"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return genManTree(cmd.Root(), manDir, docsDate())
+		},
+	}
+	man.Flags().StringVar(&manDir, "output-dir", ".", "Directory to write the pages to")
+
+	markdown := &cobra.Command{
+		Use:         "markdown",
+		Short:       "Write markdown pages",
+		Long:        `Write a markdown page for every command to --output-dir, linked to each other.`,
+		Example:     `  cascade docs markdown --output-dir site/docs/cli`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipConfig: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return genMarkdownTree(cmd.Root(), mdDir)
+		},
+	}
+	markdown.Flags().StringVar(&mdDir, "output-dir", ".", "Directory to write the pages to")
+
+	cmd.AddCommand(man, markdown)
+	return cmd
+}
+
+// docsDate returns SOURCE_DATE_EPOCH as a time if it is set, otherwise now.
+func docsDate() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now()
+}
+
+// documented returns cmd and every command below it that appears in the
+// docs: hidden and deprecated commands, and help, are left out.
+func documented(cmd *cobra.Command) []*cobra.Command {
+	if !cmd.IsAvailableCommand() && cmd.HasParent() {
+		return nil
+	}
+	cmds := []*cobra.Command{cmd}
+	for _, sub := range cmd.Commands() {
+		cmds = append(cmds, documented(sub)...)
+	}
+	return cmds
+}
+
+// docName returns the base name of a command's page: "cascade-envrc-fmt".
+func docName(cmd *cobra.Command, sep string) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", sep)
+}
+
+// envEntries splits the envAnnotation of cmd into name and description.
+func envEntries(lines []string) [][2]string {
+	var entries [][2]string
+	for _, line := range lines {
+		name, desc, _ := strings.Cut(line, ":")
+		if name = strings.TrimSpace(name); name != "" {
+			entries = append(entries, [2]string{name, strings.TrimSpace(desc)})
+		}
+	}
+	return entries
+}
+
+// commandEnv returns the environment entries documented for cmd. The root
+// command lists every variable cascade uses.
+func commandEnv(cmd *cobra.Command) [][2]string {
+	if !cmd.HasParent() {
+		return envEntries(shellStateEnv)
+	}
+	if doc := cmd.Annotations[envAnnotation]; doc != "" {
+		return envEntries(strings.Split(doc, "\n"))
+	}
+	return nil
+}
+
+// genManTree writes a man page for root and each documented command below it.
+func genManTree(root *cobra.Command, dir string, date time.Time) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	for _, cmd := range documented(root) {
+		file := filepath.Join(dir, docName(cmd, "-")+".1")
+		if err := os.WriteFile(file, manPage(cmd, date), 0644); err != nil {
+			return fmt.Errorf("write man page: %w", err)
+		}
+	}
+	return nil
+}
+
+// manPage renders the man page for cmd.
+func manPage(cmd *cobra.Command, date time.Time) []byte {
+	cmd.InitDefaultHelpFlag()
+	name := docName(cmd, "-")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, ".TH %q \"1\" %q %q \"Cascade Manual\"\n", strings.ToUpper(name), date.Format("Jan 2006"), "cascade "+cascadeVersion)
+	fmt.Fprintf(&b, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(cmd.Short))
+	fmt.Fprintf(&b, ".SH SYNOPSIS\n\\fB%s\\fP\n", roffEscape(cmd.UseLine()))
+
+	fmt.Fprintln(&b, ".SH DESCRIPTION")
+	long := cmd.Long
+	if long == "" {
+		long = cmd.Short
+	}
+	roffText(&b, long)
+
+	roffFlags(&b, "OPTIONS", cmd.NonInheritedFlags())
+	roffFlags(&b, "OPTIONS INHERITED FROM PARENT COMMANDS", cmd.InheritedFlags())
+
+	if cmd.Example != "" {
+		fmt.Fprintln(&b, ".SH EXAMPLES")
+		roffBlock(&b, strings.Split(cmd.Example, "\n"))
+	}
+
+	if !cmd.HasParent() {
+		for _, section := range overviewSections {
+			fmt.Fprintf(&b, ".SH %s\n", section.title)
+			roffText(&b, section.body)
+		}
+	}
+
+	if env := commandEnv(cmd); len(env) > 0 {
+		fmt.Fprintln(&b, ".SH ENVIRONMENT")
+		for _, e := range env {
+			fmt.Fprintf(&b, ".TP\n\\fB%s\\fP\n%s\n", roffEscape(e[0]), roffEscape(e[1]))
+		}
+	}
+
+	if related := seeAlso(cmd); len(related) > 0 {
+		fmt.Fprintln(&b, ".SH SEE ALSO")
+		refs := make([]string, len(related))
+		for i, c := range related {
+			refs[i] = fmt.Sprintf("\\fB%s\\fP(1)", roffEscape(docName(c, "-")))
+		}
+		fmt.Fprintln(&b, strings.Join(refs, ", "))
+	}
+	return b.Bytes()
+}
+
+// seeAlso returns the parent and documented children of cmd.
+func seeAlso(cmd *cobra.Command) []*cobra.Command {
+	var related []*cobra.Command
+	if cmd.HasParent() {
+		related = append(related, cmd.Parent())
+	}
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			related = append(related, sub)
+		}
+	}
+	return related
+}
+
+// roffText renders help text: paragraphs separated by blank lines, with
+// paragraphs containing indented lines (examples, lists) kept as they are.
+func roffText(b *bytes.Buffer, text string) {
+	for _, para := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		lines := strings.Split(strings.Trim(para, "\n"), "\n")
+		indented := false
+		for _, line := range lines {
+			indented = indented || strings.HasPrefix(line, " ")
+		}
+		if indented {
+			roffBlock(b, lines)
+			continue
+		}
+		fmt.Fprintf(b, ".PP\n%s\n", roffEscape(strings.Join(lines, "\n")))
+	}
+}
+
+// roffBlock renders lines verbatim, indented.
+func roffBlock(b *bytes.Buffer, lines []string) {
+	fmt.Fprintln(b, ".PP\n.RS\n.nf")
+	for _, line := range lines {
+		fmt.Fprintln(b, roffEscape(strings.TrimPrefix(line, "  ")))
+	}
+	fmt.Fprintln(b, ".fi\n.RE")
+}
+
+// roffFlags renders a flag set as a section of tagged paragraphs.
+func roffFlags(b *bytes.Buffer, title string, flags *pflag.FlagSet) {
+	if !flags.HasAvailableFlags() {
+		return
+	}
+	fmt.Fprintf(b, ".SH %s\n", title)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		tag := "\\fB\\-\\-" + roffEscape(f.Name) + "\\fP"
+		if f.Shorthand != "" {
+			tag = "\\fB\\-" + f.Shorthand + "\\fP, " + tag
+		}
+		if varname, _ := pflag.UnquoteUsage(f); varname != "" {
+			tag += " \\fI" + roffEscape(varname) + "\\fP"
+		}
+		usage := f.Usage
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "[]" {
+			usage += fmt.Sprintf(" (default %q)", f.DefValue)
+		}
+		fmt.Fprintf(b, ".TP\n%s\n%s\n", tag, roffEscape(usage))
+	})
+}
+
+// roffEscape escapes text for roff: backslashes and hyphens, and lines
+// that would otherwise start a request.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// genMarkdownTree writes a markdown page for root and each documented
+// command below it.
+func genMarkdownTree(root *cobra.Command, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	for _, cmd := range documented(root) {
+		file := filepath.Join(dir, docName(cmd, "_")+".md")
+		if err := os.WriteFile(file, markdownPage(cmd), 0644); err != nil {
+			return fmt.Errorf("write markdown page: %w", err)
+		}
+	}
+	return nil
+}
+
+// markdownPage renders the markdown page for cmd.
+func markdownPage(cmd *cobra.Command) []byte {
+	cmd.InitDefaultHelpFlag()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "## %s\n\n%s\n\n", cmd.CommandPath(), cmd.Short)
+	if cmd.Long != "" {
+		fmt.Fprintf(&b, "### Synopsis\n\n%s\n\n", markdownText(cmd.Long))
+	}
+	fmt.Fprintf(&b, "```\n%s\n```\n\n", cmd.UseLine())
+
+	if cmd.Example != "" {
+		fmt.Fprintf(&b, "### Examples\n\n```\n%s\n```\n\n", cmd.Example)
+	}
+	if flags := cmd.NonInheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&b, "### Options\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+	if flags := cmd.InheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&b, "### Options inherited from parent commands\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+
+	if !cmd.HasParent() {
+		for _, section := range overviewSections {
+			fmt.Fprintf(&b, "### %s\n\n%s\n\n", strings.ToUpper(section.title[:1])+strings.ToLower(section.title[1:]), markdownText(section.body))
+		}
+	}
+
+	if env := commandEnv(cmd); len(env) > 0 {
+		fmt.Fprintf(&b, "### Environment\n\n")
+		for _, e := range env {
+			fmt.Fprintf(&b, "- `%s`: %s\n", e[0], e[1])
+		}
+		fmt.Fprintln(&b)
+	}
+
+	if related := seeAlso(cmd); len(related) > 0 {
+		fmt.Fprintf(&b, "### See also\n\n")
+		for _, c := range related {
+			fmt.Fprintf(&b, "* [%s](%s.md) - %s\n", c.CommandPath(), docName(c, "_"), c.Short)
+		}
+	}
+	return b.Bytes()
+}
+
+// markdownText renders help text, fencing paragraphs with indented lines so
+// examples and lists keep their layout.
+func markdownText(text string) string {
+	paras := strings.Split(strings.TrimSpace(text), "\n\n")
+	for i, para := range paras {
+		for _, line := range strings.Split(para, "\n") {
+			if strings.HasPrefix(line, " ") {
+				paras[i] = "```\n" + para + "\n```"
+				break
+			}
+		}
+	}
+	return strings.Join(paras, "\n\n")
+}
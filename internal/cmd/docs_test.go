@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentedCommandsHaveExamples(t *testing.T) {
+	root := newRootCmd(Assets{Stdlib: "x", Version: "1.0.0"})
+	for _, cmd := range documented(root) {
+		if cmd.Hidden {
+			t.Errorf("%s: hidden command is documented", cmd.CommandPath())
+		}
+		if cmd.Long == "" {
+			t.Errorf("%s: missing Long description", cmd.CommandPath())
+		}
+		if cmd.Example == "" {
+			t.Errorf("%s: missing Example", cmd.CommandPath())
+		}
+	}
+}
+
+func TestGenManTree(t *testing.T) {
+	root := newRootCmd(Assets{Stdlib: "x", Version: "1.0.0"})
+	dir := t.TempDir()
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := genManTree(root, dir, date); err != nil {
+		t.Fatalf("genManTree() error = %v", err)
+	}
+
+	for _, cmd := range documented(root) {
+		data, err := os.ReadFile(filepath.Join(dir, docName(cmd, "-")+".1"))
+		if err != nil {
+			t.Fatalf("%s: %v", cmd.CommandPath(), err)
+		}
+		page := string(data)
+		for _, want := range []string{".TH", "Mar 2024", ".SH DESCRIPTION", ".SH EXAMPLES"} {
+			if !strings.Contains(page, want) {
+				t.Errorf("%s: man page missing %q", cmd.CommandPath(), want)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cascade-docs.1")); !os.IsNotExist(err) {
+		t.Errorf("hidden docs command got a man page")
+	}
+
+	root1, _ := os.ReadFile(filepath.Join(dir, "cascade.1"))
+	for _, want := range []string{"CASCADE_DIFF", "SHELL SETUP", "ALLOW MODEL"} {
+		if !strings.Contains(string(root1), want) {
+			t.Errorf("cascade.1 missing %q", want)
+		}
+	}
+}
+
+func TestGenMarkdownTree(t *testing.T) {
+	root := newRootCmd(Assets{Stdlib: "x", Version: "1.0.0"})
+	dir := t.TempDir()
+	if err := genMarkdownTree(root, dir); err != nil {
+		t.Fatalf("genMarkdownTree() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "cascade_envrc_fmt.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# cascade envrc fmt", "cascade envrc fmt --check", "cascade_envrc.md"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("cascade_envrc_fmt.md missing %q", want)
+		}
+	}
+}
+
+func TestRoffEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"--flag", `\-\-flag`},
+		{`a\b`, `a\eb`},
+		{".envrc files", `\&.envrc files`},
+		{"'quoted'", `\&'quoted'`},
+		{"one\n.two", "one\n\\&.two"},
+	}
+	for _, tt := range tests {
+		if got := roffEscape(tt.in); got != tt.want {
+			t.Errorf("roffEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,15 +11,22 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/eval"
 	"github.com/unrss/cascade/internal/shell"
+	"github.com/unrss/cascade/internal/xdg"
 )
 
 func newDoctorCmd() *cobra.Command {
-	return &cobra.Command{
+	var fix, yes bool
+
+	cmd := &cobra.Command{
 		Use:   "doctor",
 		Short: "Check cascade installation for common issues",
 		Long: `Run diagnostic checks to identify potential issues with your cascade setup.
@@ -26,24 +35,69 @@ Checks performed:
   - Shell hook installation (bash, zsh, fish)
   - Bash version compatibility (requires 4.0+)
   - XDG data directory permissions
+  - Allow store writability (a read-only filesystem allows nothing)
+  - System allow store ownership and permissions
   - Configuration file validity
   - Cache directory state
-  - Common misconfigurations`,
+  - Clock skew between this host and the filesystem
+  - Common misconfigurations
+
+With --fix, doctor then offers the safe remediations it found and applies
+them after one confirmation (skip it with --yes): creating the data and
+cache directories with mode 0700, removing group and other write access
+from the data directory, and appending the hook line, marked
+"# added by cascade doctor", to the RC file of the current shell after
+copying it to <file>.cascade.bak. Nothing is removed, no RC file is
+created (a new ~/.bash_profile would hide ~/.profile from bash), and the
+RC files of other shells are left alone. Each fix is reported as applied, skipped or
+failed.`,
+		Example: `  cascade doctor
+  cascade doctor --fix        # Apply the safe fixes after confirming
+  cascade doctor --fix --yes  # ... without asking, for scripts`,
+		Annotations: map[string]string{envAnnotation: `SHELL: Shell whose hook line is suggested
+CASCADE_HOOK_VERSION: Compared with the version of this binary
+` + dataEnv + "\n" + cacheEnv},
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDoctor(cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if yes && !fix {
+				return errors.New("--yes needs --fix")
+			}
+			return runDoctor(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), fix, yes)
 		},
 	}
+
+	cmd.Flags().BoolVar(&fix, "fix", false, "Apply the safe fixes for the issues found")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "With --fix, apply without asking for confirmation")
+
+	return cmd
 }
 
 type checkResult struct {
 	name    string
 	status  string // "ok", "warn", "error", "skip"
 	message string
-	detail  string // optional additional info
+	detail  string     // optional additional info
+	fix     *doctorFix // optional remediation for --fix
+}
+
+// doctorFix is a remediation doctor --fix can apply. Only safe ones are
+// offered: they create or append, and never remove anything.
+type doctorFix struct {
+	description string
+	apply       func() error
+
+	// skip, if set, is why the fix is not safe to apply and what to do
+	// instead; --fix reports it as skipped without asking
+	skip string
 }
 
-func runDoctor(stdout, stderr io.Writer) error {
+// doctorHookComment marks the hook line doctor --fix appends to an RC file.
+const doctorHookComment = "# added by cascade doctor"
+
+// errDoctorFixNeedsTTY explains how to run doctor --fix without a terminal.
+var errDoctorFixNeedsTTY = errors.New("--fix needs an interactive terminal to confirm; pass --yes to apply without asking")
+
+func runDoctor(stdin io.Reader, stdout, stderr io.Writer, fix, yes bool) error {
 	c := newColorizer(stdout)
 
 	fmt.Fprintf(stdout, "%s\n\n", c.bold("Cascade Doctor"))
@@ -53,10 +107,16 @@ func runDoctor(stdout, stderr io.Writer) error {
 	// Run all checks
 	results = append(results, checkBashVersion(c))
 	results = append(results, checkDataDirectory(c))
+	results = append(results, checkAllowStoreOverride(c))
+	results = append(results, checkAllowStoreWritable(c))
+	results = append(results, checkSystemStore(c))
 	results = append(results, checkConfigFile(c))
 	results = append(results, checkCacheDirectory(c))
 	results = append(results, checkShellHooks(c)...)
+	results = append(results, checkHookVersion(c))
 	results = append(results, checkCascadeRoot(c))
+	results = append(results, checkCaseVariants(c))
+	results = append(results, checkClockSkew(c))
 
 	// Output results
 	var warnings, errors int
@@ -86,23 +146,100 @@ func runDoctor(stdout, stderr io.Writer) error {
 	fmt.Fprintln(stdout)
 
 	// Summary
+	var err error
 	if errors > 0 {
 		fmt.Fprintf(stdout, "%s Found %d error(s) and %d warning(s)\n", c.red("✗"), errors, warnings)
-		return fmt.Errorf("doctor found %d error(s)", errors)
+		err = fmt.Errorf("doctor found %d error(s)", errors)
 	} else if warnings > 0 {
 		fmt.Fprintf(stdout, "%s Found %d warning(s), but cascade should work\n", c.yellow("!"), warnings)
 	} else {
 		fmt.Fprintf(stdout, "%s All checks passed\n", c.green("✓"))
 	}
 
+	if fix {
+		if fixErr := applyDoctorFixes(stdin, stdout, results, yes); fixErr != nil {
+			return fixErr
+		}
+	}
+	return err
+}
+
+// applyDoctorFixes lists the fixes of results and, once confirmed unless
+// yes, applies them in order, reporting each as applied, skipped or failed.
+func applyDoctorFixes(stdin io.Reader, stdout io.Writer, results []checkResult, yes bool) error {
+	var fixes, skipped []*doctorFix
+	for _, r := range results {
+		switch {
+		case r.fix == nil:
+		case r.fix.skip != "":
+			skipped = append(skipped, r.fix)
+		default:
+			fixes = append(fixes, r.fix)
+		}
+	}
+
+	fmt.Fprintln(stdout)
+	for _, f := range skipped {
+		fmt.Fprintf(stdout, "cascade: skipped %s: %s\n", f.description, f.skip)
+	}
+	if len(fixes) == 0 {
+		if len(skipped) == 0 {
+			fmt.Fprintln(stdout, "cascade: nothing to fix")
+		}
+		return nil
+	}
+
+	fmt.Fprintln(stdout, "Fixes:")
+	for _, f := range fixes {
+		fmt.Fprintf(stdout, "  - %s\n", f.description)
+	}
+	apply := true
+	if !yes {
+		if !isTerminal(stdin) {
+			return errDoctorFixNeedsTTY
+		}
+		fmt.Fprintf(stdout, "Apply %d %s? [y/N] ", len(fixes), fixesNoun(len(fixes)))
+		answer, _ := bufio.NewReader(stdin).ReadString('\n')
+		apply = strings.EqualFold(strings.TrimSpace(answer), "y")
+	}
+
+	failed := 0
+	for _, f := range fixes {
+		if !apply {
+			fmt.Fprintf(stdout, "cascade: skipped %s\n", f.description)
+			continue
+		}
+		if err := f.apply(); err != nil {
+			fmt.Fprintf(stdout, "cascade: failed %s: %v\n", f.description, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "cascade: applied %s\n", f.description)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d %s failed", failed, fixesNoun(failed))
+	}
 	return nil
 }
 
+// fixesNoun returns "fix" or "fixes" for n.
+func fixesNoun(n int) string {
+	if n == 1 {
+		return "fix"
+	}
+	return "fixes"
+}
+
 func checkBashVersion(c *colorizer) checkResult {
 	result := checkResult{name: "Bash version"}
 
 	// Find bash binary
 	bashPath := cfg.BashPath
+	if bashPath == "" && runtime.GOOS == "windows" {
+		result.status = "error"
+		result.message = eval.ErrWindowsBash.Error()
+		return result
+	}
 	if bashPath == "" {
 		var err error
 		bashPath, err = exec.LookPath("bash")
@@ -151,15 +288,11 @@ func checkBashVersion(c *colorizer) checkResult {
 func checkDataDirectory(c *colorizer) checkResult {
 	result := checkResult{name: "Data directory"}
 
-	dataHome := os.Getenv("XDG_DATA_HOME")
-	if dataHome == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			result.status = "error"
-			result.message = "could not determine home directory"
-			return result
-		}
-		dataHome = filepath.Join(home, ".local", "share")
+	dataHome, err := xdg.DataHome()
+	if err != nil {
+		result.status = "error"
+		result.message = "could not determine home directory"
+		return result
 	}
 
 	cascadeDir := filepath.Join(dataHome, "cascade")
@@ -168,6 +301,7 @@ func checkDataDirectory(c *colorizer) checkResult {
 	if os.IsNotExist(err) {
 		result.status = "ok"
 		result.message = cascadeDir + " (will be created on first use)"
+		result.fix = createDirFix(cascadeDir)
 		return result
 	}
 	if err != nil {
@@ -188,6 +322,10 @@ func checkDataDirectory(c *colorizer) checkResult {
 		result.status = "warn"
 		result.message = fmt.Sprintf("%s has permissive permissions (%o)", cascadeDir, mode)
 		result.detail = "Consider: chmod 700 " + cascadeDir
+		result.fix = &doctorFix{
+			description: fmt.Sprintf("remove group and other write access from %s (chmod %o)", cascadeDir, mode&^0022),
+			apply:       func() error { return os.Chmod(cascadeDir, mode&^0022) },
+		}
 		return result
 	}
 
@@ -209,10 +347,93 @@ func checkDataDirectory(c *colorizer) checkResult {
 	return result
 }
 
+// checkAllowStoreOverride reports an allow store moved by --data-dir or
+// CASCADE_DATA_DIR. Only allow, deny and trust records live there, and
+// state with CASCADE_DATA_DIR; the cache stays where it is.
+func checkAllowStoreOverride(c *colorizer) checkResult {
+	result := checkResult{name: "Allow store"}
+
+	dir, source := allowDataDir()
+	if dir == "" {
+		result.status = "skip"
+		result.message = "in the data directory"
+		return result
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		result.status = "warn"
+		result.message = fmt.Sprintf("%s (set by %s) does not exist", dir, source)
+		result.detail = "Nothing is allowed until a file is allowed with this store"
+	case err != nil:
+		result.status = "error"
+		result.message = fmt.Sprintf("cannot access %s: %v", dir, err)
+	case !info.IsDir():
+		result.status = "error"
+		result.message = fmt.Sprintf("%s (set by %s) is not a directory", dir, source)
+	default:
+		result.status = "ok"
+		result.message = fmt.Sprintf("%s (set by %s)", dir, source)
+	}
+	return result
+}
+
+// checkAllowStoreWritable verifies that the allow store's directory can be
+// written, without which nothing can be allowed.
+func checkAllowStoreWritable(c *colorizer) checkResult {
+	result := checkResult{name: "Allow store writable"}
+
+	_, err := openAllowStore()
+	var notWritable *allow.NotWritableError
+	switch {
+	case errors.As(err, &notWritable):
+		result.status = "error"
+		result.message = notWritable.Dir + " cannot be written"
+		result.detail = fmt.Sprintf("%v\nNothing can be allowed, denied or trusted. Keep the store in a writable directory:\n  export %s=/path/to/writable/dir",
+			notWritable.Err, dataDirEnv)
+	case err != nil:
+		result.status = "error"
+		result.message = err.Error()
+	default:
+		result.status = "ok"
+		result.message = "yes"
+	}
+	return result
+}
+
+// checkSystemStore verifies the read-only system store is safe to honor.
+// An unsafe store is ignored rather than trusted.
+func checkSystemStore(c *colorizer) checkResult {
+	result := checkResult{name: "System store"}
+
+	if cfg.SystemDataDir == "" {
+		result.status = "skip"
+		result.message = "disabled"
+		return result
+	}
+
+	err := allow.CheckSystemDir(cfg.SystemDataDir)
+	switch {
+	case errors.Is(err, allow.ErrNoSystemDir):
+		result.status = "skip"
+		result.message = cfg.SystemDataDir + " (not present)"
+	case err != nil:
+		result.status = "warn"
+		result.message = "ignoring " + cfg.SystemDataDir
+		result.detail = fmt.Sprintf("%v\nThe store must be owned by root and not writable by other users:\n  sudo chown -R root %s && sudo chmod -R go-w %s",
+			err, cfg.SystemDataDir, cfg.SystemDataDir)
+	default:
+		result.status = "ok"
+		result.message = cfg.SystemDataDir + " (read-only layer)"
+	}
+	return result
+}
+
 func checkConfigFile(c *colorizer) checkResult {
 	result := checkResult{name: "Config file"}
 
-	configFile := config.ConfigFile()
+	configFile := cfg.File
 	if configFile == "" {
 		result.status = "ok"
 		result.message = "no config file (using defaults)"
@@ -228,15 +449,11 @@ func checkConfigFile(c *colorizer) checkResult {
 func checkCacheDirectory(c *colorizer) checkResult {
 	result := checkResult{name: "Cache directory"}
 
-	cacheHome := os.Getenv("XDG_CACHE_HOME")
-	if cacheHome == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			result.status = "error"
-			result.message = "could not determine home directory"
-			return result
-		}
-		cacheHome = filepath.Join(home, ".cache")
+	cacheHome, err := xdg.CacheHome()
+	if err != nil {
+		result.status = "error"
+		result.message = "could not determine home directory"
+		return result
 	}
 
 	cascadeCache := filepath.Join(cacheHome, "cascade")
@@ -251,6 +468,7 @@ func checkCacheDirectory(c *colorizer) checkResult {
 	if os.IsNotExist(err) {
 		result.status = "ok"
 		result.message = cascadeCache + " (will be created when needed)"
+		result.fix = createDirFix(cascadeCache)
 		return result
 	}
 	if err != nil {
@@ -300,7 +518,7 @@ func checkShellHooks(c *colorizer) []checkResult {
 			continue
 		}
 
-		rcPath := getShellRCPath(shellName)
+		rcPath, hasHook, err := rcFileHook(shellName)
 		if rcPath == "" {
 			result.status = "skip"
 			result.message = "RC file path unknown"
@@ -308,12 +526,17 @@ func checkShellHooks(c *colorizer) []checkResult {
 			continue
 		}
 
-		// Check if RC file exists
-		content, err := os.ReadFile(rcPath)
+		// Check if RC file exists. doctor --fix does not create one: a new
+		// ~/.bash_profile, for one, stops bash login shells reading ~/.profile
 		if os.IsNotExist(err) {
 			if shellName == currentShell {
 				result.status = "warn"
 				result.message = rcPath + " does not exist"
+				result.detail = "Add to your shell's startup file: " + shell.LoadLine(shellName)
+				result.fix = &doctorFix{
+					description: fmt.Sprintf("add the %s hook to %s", shellName, rcPath),
+					skip:        "the file does not exist, and doctor does not create startup files; add " + shell.LoadLine(shellName) + " to the one your shell reads",
+				}
 			} else {
 				result.status = "skip"
 				result.message = rcPath + " does not exist"
@@ -328,28 +551,14 @@ func checkShellHooks(c *colorizer) []checkResult {
 			continue
 		}
 
-		// Check for cascade hook
-		hookPatterns := []string{
-			"cascade hook",
-			"eval \"$(cascade",
-			"cascade hook " + shellName,
-		}
-
-		hasHook := false
-		for _, pattern := range hookPatterns {
-			if strings.Contains(string(content), pattern) {
-				hasHook = true
-				break
-			}
-		}
-
 		if hasHook {
 			result.status = "ok"
 			result.message = "hook found in " + rcPath
 		} else if shellName == currentShell {
 			result.status = "warn"
 			result.message = "hook not found in " + rcPath
-			result.detail = "Add to " + rcPath + ": eval \"$(cascade hook " + shellName + ")\""
+			result.detail = "Add to " + rcPath + ": " + shell.LoadLine(shellName)
+			result.fix = appendHookFix(rcPath, shellName)
 		} else {
 			result.status = "skip"
 			result.message = "hook not found in " + rcPath + " (not current shell)"
@@ -361,6 +570,86 @@ func checkShellHooks(c *colorizer) []checkResult {
 	return results
 }
 
+// createDirFix returns the fix creating dir with mode 0700.
+func createDirFix(dir string) *doctorFix {
+	return &doctorFix{
+		description: fmt.Sprintf("create %s with mode 0700", dir),
+		apply:       func() error { return os.MkdirAll(dir, 0o700) },
+	}
+}
+
+// appendHookFix returns the fix appending the hook line of shellName to
+// rcPath, after copying rcPath to rcPath.cascade.bak if it exists.
+func appendHookFix(rcPath, shellName string) *doctorFix {
+	return &doctorFix{
+		description: fmt.Sprintf("add the %s hook to %s", shellName, rcPath),
+		apply:       func() error { return appendHook(rcPath, shellName) },
+	}
+}
+
+// appendHook appends the hook line of shellName, under doctorHookComment,
+// to rcPath after copying it to rcPath.cascade.bak. The file must exist,
+// and is left alone if that backup already exists.
+func appendHook(rcPath, shellName string) error {
+	content, err := os.ReadFile(rcPath)
+	if err != nil {
+		return err
+	}
+	backup := rcPath + ".cascade.bak"
+	if _, err := os.Lstat(backup); err == nil {
+		return fmt.Errorf("backup %s already exists", backup)
+	}
+	if err := os.WriteFile(backup, content, 0o600); err != nil {
+		return fmt.Errorf("back up %s: %w", rcPath, err)
+	}
+
+	var b strings.Builder
+	if len(content) > 0 {
+		if content[len(content)-1] != '\n' {
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(doctorHookComment + "\n" + shell.LoadLine(shellName) + "\n")
+
+	f, err := os.OpenFile(rcPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func checkHookVersion(c *colorizer) checkResult {
+	result := checkResult{name: "Shell hook version"}
+
+	hookVersion := os.Getenv("CASCADE_HOOK_VERSION")
+	if hookVersion == "" {
+		result.status = "skip"
+		result.message = "not recorded (no hook loaded, or hook_resolve_path is set)"
+		return result
+	}
+
+	if hookVersionMismatch(hookVersion, cascadeVersion) {
+		result.status = "warn"
+		result.message = fmt.Sprintf("shell hook was generated by cascade v%s, this is v%s",
+			strings.TrimPrefix(hookVersion, "v"), strings.TrimPrefix(cascadeVersion, "v"))
+		result.detail = "Restart your shell"
+		if load := shell.LoadLine(detectCurrentShell()); load != "" {
+			result.detail += " or re-run: " + load
+		}
+		result.detail += "\nSet hook_resolve_path = true to pick up upgrades automatically"
+		return result
+	}
+
+	result.status = "ok"
+	result.message = "generated by cascade v" + strings.TrimPrefix(hookVersion, "v")
+	return result
+}
+
 func checkCascadeRoot(c *colorizer) checkResult {
 	result := checkResult{name: "Cascade root"}
 
@@ -394,19 +683,154 @@ func checkCascadeRoot(c *colorizer) checkResult {
 	return result
 }
 
+// checkCaseVariants looks for files spelling .envrc in another case, such
+// as .Envrc, in the chain for the current directory. cascade never loads
+// them, and on a case-insensitive volume they are easy to miss.
+func checkCaseVariants(c *colorizer) checkResult {
+	result := checkResult{name: "Case variants"}
+
+	plan, err := planCurrentDir()
+	if err != nil {
+		result.status = "skip"
+		result.message = fmt.Sprintf("could not find the .envrc chain: %v", err)
+		return result
+	}
+
+	var found []string
+	for _, rc := range plan.Chain {
+		for _, name := range rc.CaseVariants {
+			found = append(found, filepath.Join(rc.Dir, name))
+		}
+	}
+
+	volume := "case-sensitive"
+	if envrc.CaseInsensitive(plan.Target) {
+		volume = "case-insensitive"
+	}
+	if len(found) > 0 {
+		result.status = "warn"
+		result.message = fmt.Sprintf("%d file(s) in the chain spell .envrc in another case (%s filesystem)", len(found), volume)
+		result.detail = strings.Join(found, "\n") + "\ncascade only loads .envrc; rename them"
+		return result
+	}
+
+	result.status = "ok"
+	result.message = "none in the chain (" + volume + " filesystem)"
+	return result
+}
+
+// checkClockSkew writes a temp file where .envrc files live and compares its
+// mtime with the local clock. Large skew (common on NFS) breaks mtime-based
+// change detection.
+func checkClockSkew(c *colorizer) checkResult {
+	result := checkResult{name: "Clock skew"}
+
+	dirs := []string{}
+	if root, err := cfg.GetCascadeRoot(); err == nil {
+		dirs = append(dirs, root)
+	}
+	if dataHome, err := xdg.DataHome(); err == nil {
+		dirs = append(dirs, filepath.Join(dataHome, "cascade"))
+	}
+
+	for _, dir := range dirs {
+		skew, err := measureClockSkew(dir)
+		if err != nil {
+			continue
+		}
+
+		if skew.Abs() > env.FutureTolerance {
+			result.status = "warn"
+			result.message = fmt.Sprintf("files in %s are stamped %s %s the local clock", dir, skew.Abs().Round(time.Second), aheadOrBehind(skew))
+			result.detail = "Watched-file change detection may misfire. Consider: watch_hash = true in config.toml\nto detect changes by content instead of mtime."
+			return result
+		}
+
+		result.status = "ok"
+		result.message = "none detected in " + dir
+		return result
+	}
+
+	result.status = "skip"
+	result.message = "could not write a test file"
+	return result
+}
+
+// measureClockSkew returns how far a new file's mtime in dir is ahead of the
+// local clock (negative if behind).
+func measureClockSkew(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".cascade-skew-*")
+	if err != nil {
+		return 0, err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+
+	if _, err := f.WriteString("skew"); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().Sub(now), nil
+}
+
+func aheadOrBehind(skew time.Duration) string {
+	if skew > 0 {
+		return "ahead of"
+	}
+	return "behind"
+}
+
 func detectCurrentShell() string {
-	// Try SHELL environment variable
-	shellPath := os.Getenv("SHELL")
+	return supportedShell(os.Getenv("SHELL"))
+}
+
+// supportedShell returns the name of the shell at shellPath, such as the
+// value of $SHELL, or "" if cascade has no hook for it.
+func supportedShell(shellPath string) string {
 	if shellPath != "" {
 		base := filepath.Base(shellPath)
 		if shell.Get(base) != nil {
 			return base
 		}
 	}
-
 	return ""
 }
 
+// rcFileHook reports whether the RC file of shellName loads the cascade
+// hook. rcPath is "" if the RC file is unknown; err is set if it cannot be
+// read, including when it does not exist.
+func rcFileHook(shellName string) (rcPath string, found bool, err error) {
+	rcPath = getShellRCPath(shellName)
+	if rcPath == "" {
+		return "", false, nil
+	}
+	content, err := os.ReadFile(rcPath)
+	if err != nil {
+		return rcPath, false, err
+	}
+
+	hookPatterns := []string{
+		"cascade hook",
+		"eval \"$(cascade",
+		"cascade hook " + shellName,
+	}
+	for _, pattern := range hookPatterns {
+		if strings.Contains(string(content), pattern) {
+			return rcPath, true, nil
+		}
+	}
+	return rcPath, false, nil
+}
+
 func getShellRCPath(shellName string) string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -425,6 +849,12 @@ func getShellRCPath(shellName string) string {
 		return filepath.Join(home, ".zshrc")
 	case "fish":
 		return filepath.Join(home, ".config", "fish", "config.fish")
+	case "pwsh":
+		// $PROFILE for the current user and host
+		if runtime.GOOS == "windows" {
+			return filepath.Join(home, "Documents", "PowerShell", "Microsoft.PowerShell_profile.ps1")
+		}
+		return filepath.Join(home, ".config", "powershell", "Microsoft.PowerShell_profile.ps1")
 	default:
 		return ""
 	}
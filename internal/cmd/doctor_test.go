@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/shell"
+)
+
+// setupDoctorHome makes a temporary home directory with zsh as the current
+// shell, and returns it.
+func setupDoctorHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("SHELL", "/bin/zsh")
+	prev := cfg
+	cfg = config.Default()
+	t.Cleanup(func() { cfg = prev })
+	return home
+}
+
+// doctorFixResults runs the checks that offer fixes.
+func doctorFixResults() []checkResult {
+	c := &colorizer{}
+	results := []checkResult{checkDataDirectory(c), checkCacheDirectory(c)}
+	return append(results, checkShellHooks(c)...)
+}
+
+func TestApplyDoctorFixes(t *testing.T) {
+	home := setupDoctorHome(t)
+	dataDir := filepath.Join(home, ".local", "share", "cascade")
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dataDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	zshrc := filepath.Join(home, ".zshrc")
+	if err := os.WriteFile(zshrc, []byte("alias ll='ls -l'"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bashrc := filepath.Join(home, ".bashrc")
+	if err := os.WriteFile(bashrc, []byte("export EDITOR=vi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := applyDoctorFixes(strings.NewReader(""), &out, doctorFixResults(), true); err != nil {
+		t.Fatalf("applyDoctorFixes: %v\n%s", err, out.String())
+	}
+
+	cacheDir := filepath.Join(home, ".cache", "cascade")
+	for _, want := range []string{
+		"cascade: applied remove group and other write access from " + dataDir + " (chmod 755)\n",
+		"cascade: applied create " + cacheDir + " with mode 0700\n",
+		"cascade: applied add the zsh hook to " + zshrc + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	for dir, want := range map[string]os.FileMode{dataDir: 0o755, cacheDir: 0o700} {
+		if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s: %v, %v; want mode %o", dir, info, err, want)
+		}
+	}
+
+	content, _ := os.ReadFile(zshrc)
+	want := "alias ll='ls -l'\n\n" + doctorHookComment + "\n" + shell.LoadLine("zsh") + "\n"
+	if string(content) != want {
+		t.Errorf(".zshrc = %q, want %q", content, want)
+	}
+	if backup, err := os.ReadFile(zshrc + ".cascade.bak"); err != nil || string(backup) != "alias ll='ls -l'" {
+		t.Errorf("backup = %q, %v; want the original .zshrc", backup, err)
+	}
+	// Only the current shell's RC file is touched
+	if content, _ := os.ReadFile(bashrc); string(content) != "export EDITOR=vi\n" {
+		t.Errorf(".bashrc = %q, want it unchanged", content)
+	}
+
+	// Everything is fixed now
+	out.Reset()
+	if err := applyDoctorFixes(strings.NewReader(""), &out, doctorFixResults(), true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "cascade: nothing to fix") {
+		t.Errorf("second run output:\n%s", out.String())
+	}
+}
+
+// TestApplyDoctorFixes_MissingRCFile tests that no RC file is created: a
+// new ~/.bash_profile would stop bash login shells reading ~/.profile.
+func TestApplyDoctorFixes_MissingRCFile(t *testing.T) {
+	home := setupDoctorHome(t)
+	t.Setenv("SHELL", "/bin/bash")
+	profile := filepath.Join(home, ".profile")
+	if err := os.WriteFile(profile, []byte("export FROM_PROFILE=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := applyDoctorFixes(strings.NewReader(""), &out, doctorFixResults(), true); err != nil {
+		t.Fatalf("applyDoctorFixes: %v\n%s", err, out.String())
+	}
+
+	bashProfile := filepath.Join(home, ".bash_profile")
+	if want := "cascade: skipped add the bash hook to " + bashProfile + ": the file does not exist"; !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+	if !strings.Contains(out.String(), shell.LoadLine("bash")) {
+		t.Errorf("output does not give the hook line to add:\n%s", out.String())
+	}
+	for _, name := range []string{".bash_profile", ".bashrc"} {
+		if _, err := os.Stat(filepath.Join(home, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should not be created: %v", name, err)
+		}
+	}
+	if content, _ := os.ReadFile(profile); string(content) != "export FROM_PROFILE=1\n" {
+		t.Errorf(".profile = %q, want it unchanged", content)
+	}
+	// The other fixes still apply
+	if info, err := os.Stat(filepath.Join(home, ".local", "share", "cascade")); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("data directory: %v, %v; want mode 700", info, err)
+	}
+
+	// A skipped fix alone asks nothing
+	t.Setenv("SHELL", "/usr/bin/fish")
+	out.Reset()
+	if err := applyDoctorFixes(strings.NewReader(""), &out, doctorFixResults(), false); err != nil {
+		t.Fatalf("applyDoctorFixes: %v\n%s", err, out.String())
+	}
+	if want := "cascade: skipped add the fish hook to " + filepath.Join(home, ".config", "fish", "config.fish"); !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+}
+
+func TestApplyDoctorFixes_BackupExists(t *testing.T) {
+	home := setupDoctorHome(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	if err := os.WriteFile(zshrc, []byte("# mine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(zshrc+".cascade.bak", []byte("# older\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := applyDoctorFixes(strings.NewReader(""), &out, doctorFixResults(), true)
+	if err == nil || err.Error() != "1 fix failed" {
+		t.Errorf("err = %v, want 1 fix failed", err)
+	}
+	if want := "cascade: failed add the zsh hook to " + zshrc + ": backup " + zshrc + ".cascade.bak already exists\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+	if content, _ := os.ReadFile(zshrc); string(content) != "# mine\n" {
+		t.Errorf(".zshrc = %q, want it unchanged", content)
+	}
+}
+
+func TestApplyDoctorFixes_NeedsTTY(t *testing.T) {
+	home := setupDoctorHome(t)
+
+	var out bytes.Buffer
+	err := applyDoctorFixes(strings.NewReader("y\n"), &out, doctorFixResults(), false)
+	if !errors.Is(err, errDoctorFixNeedsTTY) {
+		t.Errorf("err = %v, want errDoctorFixNeedsTTY", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".local", "share", "cascade")); !os.IsNotExist(err) {
+		t.Errorf("data directory should not be created without confirmation: %v", err)
+	}
+}
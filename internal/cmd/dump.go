@@ -12,10 +12,11 @@ import (
 
 func newDumpCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:    "dump",
-		Short:  "Dump environment in various formats",
-		Long:   `Output the current environment in the specified format. Used internally by stdlib.sh.`,
-		Hidden: true, // Internal command
+		Use:         "dump",
+		Short:       "Dump environment in various formats",
+		Long:        `Output the current environment in the specified format. Used internally by stdlib.sh.`,
+		Hidden:      true, // Internal command
+		Annotations: map[string]string{skipConfig: ""},
 	}
 
 	cmd.AddCommand(newDumpJSONCmd())
@@ -27,12 +28,20 @@ func newDumpJSONCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "json",
 		Short: "Dump environment as JSON",
-		Long:  `Output the current environment as JSON. Called by stdlib.sh __dump_at_exit trap.`,
-		Args:  cobra.NoArgs,
+		Long: `Output the current environment as JSON. Called by stdlib.sh __dump_at_exit trap.
+
+With CASCADE_DUMP_FORMAT=json-v2, set by the evaluator, the JSON is framed
+together with a NUL-separated KEY=VALUE copy to fall back on.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipConfig: ""},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			currentEnv := env.FromGoEnv(os.Environ())
 
-			if err := eval.DumpJSON(currentEnv, cmd.OutOrStdout()); err != nil {
+			dump := eval.DumpJSON
+			if os.Getenv(eval.DumpFormatVar) == eval.DumpFormatV2 {
+				dump = eval.DumpV2
+			}
+			if err := dump(currentEnv, cmd.OutOrStdout()); err != nil {
 				return fmt.Errorf("dump json: %w", err)
 			}
 
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// editOptions holds the edit command's flags.
+type editOptions struct {
+	create bool // Create the file if there is none
+	force  bool // Allow the edited file even if it was denied
+}
+
+func newEditCmd() *cobra.Command {
+	var opts editOptions
+
+	cmd := &cobra.Command{
+		Use:   "edit [path]",
+		Short: "Edit an .envrc and allow the result",
+		Long: `Open an .envrc in $VISUAL or $EDITOR (falling back to vi) and allow it
+when the editor exits, if its content changed. Like direnv edit.
+
+Without a path, the nearest .envrc in the chain from the cascade root to
+the current directory is edited; with --create, ./.envrc is created if
+there is none. A path may name an .envrc or the directory holding one.
+
+A denied file is not allowed after editing unless --force is given, and a
+deny from the system store is never overridden. The resulting status is
+printed: "cascade: <status> <path>" as for allow when the file was
+allowed, or its unchanged status otherwise.`,
+		Example: `  cascade edit                 # Edit the nearest .envrc
+  cascade edit --create        # Create ./.envrc if there is none
+  cascade edit ~/work/api`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := editTarget(args, opts.create)
+			if err != nil {
+				return err
+			}
+			return runEdit(cmd.OutOrStdout(), cmd.ErrOrStderr(), path, opts, openInEditor)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.create, "create", false, "Create the .envrc if it does not exist")
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Allow the edited file even if it is denied")
+
+	return cmd
+}
+
+// editTarget returns the .envrc edit opens: the one args names, or else the
+// nearest one in the current chain. A file that does not exist is only
+// returned with create.
+func editTarget(args []string, create bool) (string, error) {
+	if len(args) > 0 {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return "", fmt.Errorf("resolve path: %w", err)
+		}
+		if isDir(path) {
+			path = filepath.Join(path, ".envrc")
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) && !create {
+			return "", fmt.Errorf("%s does not exist (use --create to create it)", path)
+		}
+		return path, nil
+	}
+
+	plan, err := planCurrentDir()
+	if err != nil {
+		return "", err
+	}
+	for i := len(plan.Chain) - 1; i >= 0; i-- {
+		if plan.Chain[i].Exists {
+			return plan.Chain[i].Path, nil
+		}
+	}
+	if !create {
+		return "", fmt.Errorf("no .envrc from %s to %s (use --create to create ./.envrc)", plan.Root, plan.Target)
+	}
+	return filepath.Join(plan.Target, ".envrc"), nil
+}
+
+// runEdit opens path with edit and allows the result if its content changed.
+func runEdit(stdout, stderr io.Writer, path string, opts editOptions, edit func(path string) error) error {
+	before, err := envrc.NewRC(path)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	if err := edit(path); err != nil {
+		return fmt.Errorf("edit %s: %w", path, err)
+	}
+
+	after, err := envrc.NewRC(path)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if !after.Exists {
+		fmt.Fprintf(stderr, "cascade: %s was not saved\n", path)
+		return nil
+	}
+
+	store, err := newAllowStore()
+	if err != nil {
+		return fmt.Errorf("create allow store: %w", err)
+	}
+	status, source := store.Explain(after, cfg)
+
+	if after.ContentHash == before.ContentHash {
+		_, err := fmt.Fprintf(stdout, "cascade: %s unchanged (%s)\n", after.Path, status)
+		return err
+	}
+	if status == allow.Denied && source == allow.SourceSystem {
+		fmt.Fprintf(stderr, "cascade: %s is denied by the system store (%s); not allowing it\n", after.Path, store.SystemDir())
+		return nil
+	}
+	if status == allow.Denied && !opts.force {
+		fmt.Fprintf(stderr, "cascade: %s is denied; not allowing it (use --force to allow it anyway)\n", after.Path)
+		return nil
+	}
+
+	change := store.AllowChange(after)
+	if err := store.WithTrigger("cascade edit").Allow(after); err != nil {
+		return fmt.Errorf("allow file: %w", err)
+	}
+	return printChange(stdout, change, after.Path, false, false)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/allow"
+)
+
+// appendLine returns an editor that appends line to the file.
+func appendLine(line string) func(string) error {
+	return func(path string) error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(line + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+}
+
+func TestEditTarget(t *testing.T) {
+	_, paths := setupFixChain(t)
+
+	if got, err := editTarget(nil, false); err != nil || got != paths[2] {
+		t.Errorf("editTarget() = %q, %v, want %q", got, err, paths[2])
+	}
+
+	// The nearest .envrc may be in a parent directory
+	if err := os.Remove(paths[2]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := editTarget(nil, false); err != nil || got != paths[1] {
+		t.Errorf("editTarget() = %q, %v, want %q", got, err, paths[1])
+	}
+
+	// A directory names its .envrc, which must exist unless created
+	if got, err := editTarget([]string{filepath.Dir(paths[0])}, false); err != nil || got != paths[0] {
+		t.Errorf("editTarget(dir) = %q, %v, want %q", got, err, paths[0])
+	}
+	if _, err := editTarget([]string{"."}, false); err == nil || !strings.Contains(err.Error(), "--create") {
+		t.Errorf("editTarget(.) error = %v, want a hint about --create", err)
+	}
+	if got, err := editTarget([]string{"."}, true); err != nil || got != paths[2] {
+		t.Errorf("editTarget(., create) = %q, %v, want %q", got, err, paths[2])
+	}
+}
+
+func TestRunEdit(t *testing.T) {
+	store, paths := setupFixChain(t)
+	var stdout, stderr bytes.Buffer
+
+	// Unchanged content is not allowed
+	if err := runEdit(&stdout, &stderr, paths[2], editOptions{}, func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cascade: " + paths[2] + " unchanged (not allowed)\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if status := store.Check(mustRC(t, paths[2])); status != allow.NotAllowed {
+		t.Errorf("status after an unchanged edit = %v, want not allowed", status)
+	}
+
+	// Changed content is allowed
+	stdout.Reset()
+	if err := runEdit(&stdout, &stderr, paths[2], editOptions{}, appendLine("export EDITED=1")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cascade: allowed " + paths[2] + "\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if status := store.Check(mustRC(t, paths[2])); status != allow.Allowed {
+		t.Errorf("status after editing = %v, want allowed", status)
+	}
+
+	// A failed edit allows nothing
+	failing := func(path string) error {
+		_ = appendLine("export BROKEN=1")(path)
+		return errors.New("exit status 1")
+	}
+	if err := runEdit(&stdout, &stderr, paths[2], editOptions{}, failing); err == nil {
+		t.Error("runEdit succeeded although the editor failed")
+	}
+	if status := store.Check(mustRC(t, paths[2])); status != allow.NotAllowed {
+		t.Errorf("status after a failed edit = %v, want not allowed", status)
+	}
+}
+
+func TestRunEdit_Denied(t *testing.T) {
+	store, paths := setupFixChain(t)
+	if err := store.Deny(mustRC(t, paths[1])); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+
+	if err := runEdit(&stdout, &stderr, paths[1], editOptions{}, appendLine("export A=1")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "--force") {
+		t.Errorf("stderr = %q, want a hint about --force", stderr.String())
+	}
+	if status := store.Check(mustRC(t, paths[1])); status != allow.Denied {
+		t.Errorf("status = %v, want still denied", status)
+	}
+
+	if err := runEdit(&stdout, &stderr, paths[1], editOptions{force: true}, appendLine("export B=1")); err != nil {
+		t.Fatal(err)
+	}
+	if status := store.Check(mustRC(t, paths[1])); status != allow.Allowed {
+		t.Errorf("status with --force = %v, want allowed", status)
+	}
+}
+
+func TestRunEdit_Create(t *testing.T) {
+	store, paths := setupFixChain(t)
+	path := filepath.Join(filepath.Dir(paths[2]), "sub", ".envrc")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+
+	// Quitting without saving creates nothing
+	if err := runEdit(&stdout, &stderr, path, editOptions{create: true}, func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "was not saved") {
+		t.Errorf("stderr = %q, want a note that nothing was saved", stderr.String())
+	}
+
+	if err := runEdit(&stdout, &stderr, path, editOptions{create: true}, appendLine("export NEW=1")); err != nil {
+		t.Fatal(err)
+	}
+	if status := store.Check(mustRC(t, path)); status != allow.Allowed {
+		t.Errorf("status of a created file = %v, want allowed", status)
+	}
+}
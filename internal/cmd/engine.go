@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// planCurrentDir discovers and authorizes the .envrc chain from the cascade
+// root to the current working directory.
+func planCurrentDir() (*run.Plan, error) {
+	return planDir("")
+}
+
+// planDir discovers and authorizes the .envrc chain from the cascade root
+// to dir, or to the current working directory if dir is empty.
+func planDir(dir string) (*run.Plan, error) {
+	// Get cascade root for chain traversal (from config or default to home)
+	root, err := cfg.GetCascadeRoot()
+	if err != nil {
+		return nil, fmt.Errorf("get cascade root: %w", err)
+	}
+
+	absDir, err := resolveTargetDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The store is opened when the first .envrc is checked, so directories
+	// without one never touch it
+	auth := &lazyAuthorizer{}
+	plan, err := run.NewPlan(root, absDir, chainOptions(), auth, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if auth.err != nil {
+		return nil, fmt.Errorf("create allow store: %w", auth.err)
+	}
+	return plan, nil
+}
+
+// chainOptions returns the chain discovery settings from the config.
+func chainOptions() envrc.ChainOptions {
+	return envrc.ChainOptions{
+		Markers:         cfg.SkipMarkers,
+		CrossFilesystem: cfg.CrossFilesystem,
+		CrossAllow:      cfg.CrossFilesystemMounts(),
+		StatTimeout:     envrc.DefaultStatTimeout,
+		OutsideRoot:     cfg.ChainOutsideRoot,
+		MaxWalkDepth:    cfg.MaxWalkDepth,
+	}
+}
+
+// chainPolicy returns the policy file of the chain ending at dir, or nil if
+// there is none, it cannot be read, or auth does not allow it (see
+// run.Plan.Policy).
+func chainPolicy(dir string, auth policy.Authorizer) *policy.Policy {
+	root, err := cfg.GetCascadeRoot()
+	if err != nil {
+		return nil
+	}
+	chain, _, _, err := envrc.FindRootedChain(root, dir, chainOptions())
+	if err != nil {
+		return nil
+	}
+	p, _ := policy.Find(run.ChainDirs(chain), auth, cfg)
+	return p
+}
+
+// lazyAuthorizer creates the allow store on its first use. If creation
+// fails, every file is reported not allowed and err is set.
+type lazyAuthorizer struct {
+	store *allow.Store
+	err   error
+}
+
+func (a *lazyAuthorizer) Explain(rc *envrc.RC, wl allow.Whitelister) (allow.AllowStatus, allow.Source) {
+	if a.store == nil && a.err == nil {
+		a.store, a.err = readAllowStore()
+	}
+	if a.err != nil {
+		return allow.NotAllowed, allow.SourceNone
+	}
+	return a.store.Explain(rc, wl)
+}
+
+// resolveTargetDir returns the directory a command analyzes: dir resolved
+// against the working directory, or the working directory itself if dir is
+// empty. It fails early if dir is not an existing directory.
+func resolveTargetDir(dir string) (string, error) {
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("get working directory: %w", err)
+		}
+		return cwd, nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve path: %w", err)
+	}
+	info, err := os.Stat(absDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s: no such directory", dir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("stat %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", dir)
+	}
+	return absDir, nil
+}
+
+// isShellDir reports whether target is the working directory, i.e. whether
+// the environment cascade runs in belongs to target's chain.
+func isShellDir(target string) bool {
+	cwd, err := os.Getwd()
+	return err == nil && cwd == target
+}
+
+// addDirFlag registers --dir on a command that inspects a chain.
+func addDirFlag(cmd *cobra.Command, dir *string) {
+	cmd.Flags().StringVar(dir, "dir", "", "Analyze the chain for `DIR` instead of the current directory")
+}
+
+// inconsistentDiffWarning is printed when CASCADE_DIFF is ignored because
+// none of the values it set are still there (see env.EnvDiff.ConsistentWith).
+const inconsistentDiffWarning = "cascade: warning: CASCADE_DIFF does not match the environment (none of the values it set are still there), ignoring it"
+
+// revertedEnv returns the current environment (filtered) with the changes
+// recorded in CASCADE_DIFF undone: the base export evaluates the chain from.
+func revertedEnv(stderr io.Writer) env.Env {
+	return revertedEnvFrom(stderr, env.FromGoEnv(os.Environ()))
+}
+
+// revertedEnvFrom is revertedEnv for an explicit environment.
+func revertedEnvFrom(stderr io.Writer, current env.Env) env.Env {
+	base := current.Filtered()
+
+	if diffStr := current["CASCADE_DIFF"]; diffStr != "" {
+		diff, err := env.Unmarshal(diffStr)
+		if err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: invalid CASCADE_DIFF, ignoring: %v\n", err)
+			return base
+		}
+		if !diff.ConsistentWith(current) {
+			fmt.Fprintln(stderr, inconsistentDiffWarning)
+			return base
+		}
+		base = diff.Keep(userOverridden(diff, current), current["CASCADE_DIR"]).Reverse().Patch(base)
+	}
+
+	return base
+}
+
+// userOverridden returns the variables diff applied that were changed in
+// current since, which export leaves as they are unless revert_mode is
+// "force". Path-like variables are not included: other tools' prompt hooks
+// adjust PATH all the time, and keeping it would leave the .envrc's
+// entries behind.
+func userOverridden(diff *env.EnvDiff, current env.Env) []string {
+	if cfg.RevertMode == config.RevertModeForce {
+		return nil
+	}
+	return slices.DeleteFunc(diff.Overridden(current), isPathLikeVar)
+}
+
+// newEvaluator creates an evaluator for the embedded stdlib.
+// When useCache is true and the cache is available, results are cached.
+func newEvaluator(stderr io.Writer, stdlib string, useCache bool) (*eval.Evaluator, error) {
+	// Get self path for evaluator
+	selfPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("get executable path: %w", err)
+	}
+
+	evaluator, err := eval.New(cfg.BashPath, stdlib, selfPath)
+	if err != nil {
+		return nil, fmt.Errorf("create evaluator: %w", err)
+	}
+	evaluator = evaluator.WithStderr(stderr, cfg.EvalStderrLines).WithRefresh(os.Getenv("CASCADE_REFRESH") != "").WithLogger(newLogger(stderr))
+	if root, err := cfg.GetCascadeRoot(); err == nil {
+		evaluator = evaluator.WithRoot(root)
+	}
+	if dir, _ := allowDataDir(); dir != "" {
+		evaluator = evaluator.WithDataDir(dir)
+	}
+
+	if useCache {
+		if cache := openCache(stderr); cache != nil {
+			evaluator = evaluator.WithCache(cache)
+		}
+	}
+
+	return evaluator, nil
+}
+
+// openCache returns the evaluation cache with the configured exclusions and
+// maximum age, or nil after a warning if it is unavailable.
+func openCache(stderr io.Writer) *eval.Cache {
+	cache, err := eval.NewCache()
+	if err != nil {
+		// Cache creation failure is not fatal - just log and continue
+		fmt.Fprintf(stderr, "cascade: warning: cache unavailable: %v\n", err)
+		return nil
+	}
+	return cache.WithExclude(cfg.CacheExclude).WithMaxAge(cfg.CacheMaxAgeDuration())
+}
+
+// warnOnLevelError returns a progress callback that prints a warning for each
+// level that fails, for commands that continue past evaluation errors.
+func warnOnLevelError(stderr io.Writer) func(run.Progress) {
+	return func(p run.Progress) {
+		if p.Done && p.Level.Err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: error evaluating %s: %v\n", p.Level.RC.Path, p.Level.Err)
+		}
+	}
+}
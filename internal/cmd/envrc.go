@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+func newEnvrcCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "envrc",
+		Short: "Work with .envrc files",
+		Long: `Work with the content of .envrc files.
+
+These commands never evaluate an .envrc, so they work on files that are
+not allowed.`,
+		Example: `  cascade envrc fmt --check`,
+	}
+
+	cmd.AddCommand(newEnvrcFmtCmd())
+
+	return cmd
+}
+
+// fmtOptions holds the flags of envrc fmt.
+type fmtOptions struct {
+	stdin bool
+	check bool
+	write bool
+	allow bool
+}
+
+func newEnvrcFmtCmd() *cobra.Command {
+	var opts fmtOptions
+
+	cmd := &cobra.Command{
+		Use:   "fmt [PATH]",
+		Short: "Format an .envrc file",
+		Long: `Format an .envrc (default: ./.envrc) and print the result.
+
+Indentation is normalized to two spaces per block, trailing whitespace is
+removed, blank lines are collapsed to one, and contiguous runs of simple
+exports (export NAME=VALUE alone on a line) are sorted by name unless one
+of them references a variable another sets. Heredocs, multi-line commands,
+case statements, and anything else the formatter does not fully understand
+are kept verbatim.
+
+Formatting changes an .envrc's content hash. When --write reformats an
+allowed file, cascade offers to re-allow it, or does so with --allow.`,
+		Example: `  # Fail if .envrc is not formatted (for CI)
+  cascade envrc fmt --check
+
+  # Format in place and keep it allowed
+  cascade envrc fmt --write --allow`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnvrcFmt(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.stdin, "stdin", false, "Format standard input instead of a file")
+	cmd.Flags().BoolVar(&opts.check, "check", false, "Exit non-zero if formatting would change the file")
+	cmd.Flags().BoolVarP(&opts.write, "write", "w", false, "Write the result to the file instead of stdout")
+	cmd.Flags().BoolVar(&opts.allow, "allow", false, "With --write, re-allow a reformatted file that was allowed")
+
+	return cmd
+}
+
+func runEnvrcFmt(stdin io.Reader, stdout, stderr io.Writer, args []string, opts fmtOptions) error {
+	switch {
+	case opts.stdin && len(args) > 0:
+		return errors.New("--stdin does not take a path")
+	case opts.stdin && opts.write:
+		return errors.New("--write cannot be used with --stdin")
+	case opts.check && opts.write:
+		return errors.New("--check and --write are mutually exclusive")
+	case opts.allow && !opts.write:
+		return errors.New("--allow requires --write")
+	}
+
+	name := "<stdin>"
+	var src []byte
+	var err error
+	if opts.stdin {
+		src, err = io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+	} else {
+		name = ".envrc"
+		if len(args) > 0 {
+			name = args[0]
+		}
+		src, err = os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+	}
+
+	formatted := envrc.Format(src)
+	changed := !bytes.Equal(src, formatted)
+
+	switch {
+	case opts.check:
+		if changed {
+			return fmt.Errorf("%s is not formatted (run `cascade envrc fmt --write %s`)", name, name)
+		}
+		return nil
+	case opts.write:
+		if !changed {
+			return nil
+		}
+		return writeFormatted(stdin, stdout, stderr, name, formatted, opts.allow)
+	default:
+		_, err := stdout.Write(formatted)
+		return err
+	}
+}
+
+// writeFormatted replaces path with formatted. If that takes away the
+// file's allowed status, the file is re-allowed when reallow is set or the
+// user agrees at a prompt; otherwise the user is told how to re-allow it.
+func writeFormatted(stdin io.Reader, stdout, stderr io.Writer, path string, formatted []byte, reallow bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolve path: %w", err)
+	}
+	before, err := envrc.NewRC(absPath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	store, err := newAllowStore()
+	if err != nil {
+		return fmt.Errorf("create allow store: %w", err)
+	}
+	wasAllowed, _ := store.Explain(before, cfg)
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	if err := os.WriteFile(absPath, formatted, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	fmt.Fprintf(stdout, "cascade: formatted %s\n", before.Path)
+
+	after, err := envrc.NewRC(absPath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if wasAllowed != allow.Allowed {
+		return nil
+	}
+	if status, _ := store.Explain(after, cfg); status == allow.Allowed {
+		return nil
+	}
+
+	if !reallow {
+		if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			fmt.Fprintf(stdout, "%s was allowed; re-allow the formatted file? [y/N] ", after.Path)
+			answer, _ := bufio.NewReader(stdin).ReadString('\n')
+			reallow = strings.EqualFold(strings.TrimSpace(answer), "y")
+		}
+	}
+	if !reallow {
+		fmt.Fprintf(stderr, "cascade: %s is no longer allowed. Run `cascade allow %s` to allow.\n", after.Path, after.Path)
+		return nil
+	}
+
+	if err := store.WithTrigger("cascade envrc fmt (re-allow)").Allow(after); err != nil {
+		return fmt.Errorf("allow file: %w", err)
+	}
+	fmt.Fprintf(stdout, "cascade: allowed %s\n", after.Path)
+	return nil
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/run"
+)
+
+func newExecCmd(stdlib string) *cobra.Command {
+	var skipNotAllowed bool
+
+	cmd := &cobra.Command{
+		Use:   "exec DIR COMMAND [ARG...]",
+		Short: "Run a command with a directory's environment",
+		Long: `Evaluate the .envrc chain for DIR and run COMMAND with the variables it
+sets applied over the current environment, without the shell hook and
+without changing directory. Like direnv exec, for scripts, CI and cron.
+
+Files are authorized as by export: a denied file anywhere in the chain
+refuses to run COMMAND, and files that are not allowed are skipped with a
+warning (silently with --skip-not-allowed). Changes the shell's own hook
+applied for the current directory are undone first.
+
+COMMAND's exit status becomes cascade's. Flags for cascade go before DIR:
+everything after it belongs to COMMAND.`,
+		Example: `  cascade exec ~/work/api make test
+  cascade exec . -- env | grep AWS_
+
+  # In a crontab
+  0 * * * * cascade exec --skip-not-allowed ~/jobs/sync ./sync.sh`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			command := args[1:]
+			if command[0] == "--" {
+				command = command[1:]
+			}
+			if len(command) == 0 {
+				return errors.New("no command given")
+			}
+			return runExec(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, args[0], command, skipNotAllowed)
+		},
+	}
+
+	// Flags after DIR belong to COMMAND
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().BoolVar(&skipNotAllowed, "skip-not-allowed", false, "Skip .envrc files that are not allowed without a warning")
+
+	return cmd
+}
+
+func runExec(stdin io.Reader, stdout, stderr io.Writer, stdlib, dir string, command []string, skipNotAllowed bool) error {
+	plan, err := planDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if denied := plan.Filter(allow.Denied); len(denied) > 0 {
+		paths := make([]string, len(denied))
+		for i, level := range denied {
+			paths[i] = level.RC.Path
+		}
+		return fmt.Errorf("not running %s: the chain contains denied files (run `cascade allow` to unblock):\n  %s",
+			command[0], strings.Join(paths, "\n  "))
+	}
+	if !skipNotAllowed {
+		for _, level := range plan.Filter(allow.NotAllowed) {
+			fmt.Fprintf(stderr, "cascade: %s is not allowed. Run `cascade allow %s` to allow.\n", level.RC.Path, level.RC.Path)
+		}
+	}
+
+	current := env.FromGoEnv(os.Environ())
+	vars := revertedEnvFrom(stderr, current)
+	if allowed := plan.Filter(allow.Allowed); len(allowed) > 0 {
+		evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled)
+		if err != nil {
+			return err
+		}
+		result := run.Run(plan, vars, evaluator, run.Options{Optional: optionalRoot(plan, allowed)})
+		if result.Err != nil {
+			if errors.Is(result.Err, envrc.ErrChanged) {
+				return fmt.Errorf("%s changed between approval and evaluation, re-run `cascade allow %s`", result.Failed.RC.Path, result.Failed.RC.Path)
+			}
+			return fmt.Errorf("evaluate %s: %w", result.Failed.RC.Path, result.Err)
+		}
+		for _, level := range allowed {
+			if level.Err != nil {
+				fmt.Fprintf(stderr, "cascade: warning: %s failed, continuing without it (root_envrc = %q): %v\n", level.RC.Path, cfg.RootEnvrc, level.Err)
+			}
+		}
+		vars = result.Env
+	}
+
+	// Variables cascade never tracks (PWD, SHLVL, its configuration) pass
+	// through, but not the state the shell's hook keeps for its directory
+	for key, value := range current {
+		if env.IgnoredEnv(key) && !slices.Contains(cascadeStateVars, key) {
+			vars[key] = value
+		}
+	}
+
+	c := exec.Command(command[0], command[1:]...) //nolint:gosec // runs the user's command
+	c.Env = vars.ToGoEnv()
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("run %s: %w", command[0], err)
+	}
+	return nil
+}
@@ -1,51 +1,200 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/config"
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
 	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/logging"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/run"
 	"github.com/unrss/cascade/internal/shell"
 	"github.com/unrss/cascade/internal/state"
 )
 
 func newExportCmd(stdlib string) *cobra.Command {
-	var noCache bool
+	var noCache, dryRun, explain, jsonOutput, force, timings bool
+	var format, output string
 
 	cmd := &cobra.Command{
-		Use:       "export <shell>",
-		Short:     "Export environment variables for the current directory",
-		Long:      `Evaluate .envrc files and output shell commands to set environment variables.`,
-		Args:      cobra.ExactArgs(1),
-		ValidArgs: []string{"bash", "zsh", "fish"},
+		Use:   "export [shell]",
+		Short: "Export environment variables for the current directory",
+		Long: `Evaluate .envrc files and output shell commands to set environment variables.
+
+This is what the shell hook runs at every prompt; it is rarely run by hand.
+It evaluates the allowed .envrc files in the chain for the current
+directory and prints commands for <shell> (bash, zsh, fish or pwsh) that
+apply the difference from the previous prompt, or revert it once no .envrc
+applies. Files that are not allowed are skipped with a message; a denied
+file reverts everything.
+
+With --dry-run, export evaluates the chain exactly as the next prompt
+would, against this shell's environment, but prints a summary of the
+files, variable changes and watches instead of shell commands. Nothing
+is saved and the shell is left alone: cached evaluations are used, but
+neither the cache nor the state is written, and cache_output stores
+nothing.
+
+--explain is the same dry run, but prints the shell commands export would
+print, each variable's preceded by a comment naming the .envrc that set
+it last, or saying that it is cascade's own state or is being restored.
+Values are printed as export would print them, secrets included, and the
+commands are not wrapped in a function.
+
+The commands are printed as the body of a function that the last line
+calls, so a shell that reads truncated output (export was killed
+mid-write) applies nothing rather than half the changes. CASCADE_DIFF
+carries a checksum; a corrupted value is ignored with a warning and
+cleared.
+
+With export_fast_path = true in the config, export prints nothing and
+returns at once while the shell stays in the directory of the deepest
+.envrc loaded and neither a watched file nor the allow store changed;
+--force evaluates the chain anyway.
+
+When evaluating the chain takes longer than slow_warning_ms (500 by
+default), export warns and names the slowest .envrc. --timings prints how
+long each level took at every run instead.
+
+For editors and other tools that cannot run a shell hook, --format dotenv
+or --format json writes the variables the chain sets, as KEY=VALUE lines
+or a JSON object, instead of shell commands; no shell is needed. Only
+what the chain sets is written, without CASCADE_* bookkeeping or
+sensitive_env variables. --output writes it to a file, replaced
+atomically, instead of stdout.`,
+		Example: `  # What the bash hook runs at each prompt
+  eval "$(cascade export bash)"
+
+  # Preview what the next prompt will change
+  cascade export --dry-run bash
+  cascade export --dry-run --json bash | jq .changes
+
+  # Show the shell code the next prompt would run, and where it comes from
+  cascade export --explain bash
+
+  # Write what the chain sets for an editor to read
+  cascade export --format dotenv --output .env.cascade`,
+		Annotations: map[string]string{envAnnotation: `CASCADE_DIFF: Read to revert the previous prompt's changes, and written
+CASCADE_DIR: Read and written: directory of the deepest .envrc loaded
+CASCADE_FILE: Read to find the on_unload commands to run, and written: path of the deepest .envrc loaded
+CASCADE_PENDING: Written: path of the deepest .envrc of the chain that is not allowed yet
+DIRENV_DIR, DIRENV_FILE: Written with direnv_compat, as copies of CASCADE_DIR and CASCADE_FILE
+CASCADE_NOTIFIED: Read and written: directories already reminded of an .envrc that is not allowed
+CASCADE_WATCHES: Read to skip evaluation when nothing changed, and written
+CASCADE_NEGCACHE: Read to return at once where no .envrc applied last time, and written
+CASCADE_HOOK_VERSION: Compared with the cascade on PATH to warn about a stale hook
+CASCADE_HOOK_CHECKED: Written once the hook version has been compared
+CASCADE_LOG_LEVEL: Messages to print: error, warn, info or debug
+CASCADE_REFRESH: When set, cached results are ignored`},
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "pwsh"},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "" {
+				if dryRun || explain || jsonOutput || force || timings {
+					return errors.New("--format cannot be used with --dry-run, --explain, --json, --force or --timings")
+				}
+				return runExportFile(cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, format, output, noCache)
+			}
+			if output != "" {
+				return errors.New("--output requires --format")
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("requires a shell argument (supported: %v) or --format", shell.Supported())
+			}
 			shellName := args[0]
 
-			sh := shell.Get(shellName)
+			sh := exportShell(shellName)
 			if sh == nil {
 				return fmt.Errorf("unsupported shell: %s (supported: %v)", shellName, shell.Supported())
 			}
 
-			return runExport(cmd, sh, stdlib, noCache)
+			if explain && (dryRun || jsonOutput) {
+				return errors.New("--explain cannot be used with --dry-run or --json")
+			}
+			if !dryRun && !explain {
+				if jsonOutput {
+					return errors.New("--json requires --dry-run")
+				}
+				// Nothing to print: the shell is as the last prompt left it
+				if !force && !timings && exportUnchanged() {
+					return nil
+				}
+				return runExportWrapped(cmd, sh, stdlib, noCache, timings)
+			}
+
+			preview := newPreview()
+			if err := runExport(cmd, sh, stdlib, noCache, timings, preview); err != nil {
+				return err
+			}
+			if explain {
+				return printExplain(cmd.OutOrStdout(), sh, preview)
+			}
+			return printPreview(cmd.OutOrStdout(), preview, jsonOutput)
 		},
 	}
 
 	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Disable evaluation caching")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what the next prompt would change instead of printing shell commands")
+	cmd.Flags().BoolVar(&explain, "explain", false, "Print the shell commands the next prompt would run, annotated, without applying or saving anything")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "With --dry-run, output in JSON format")
+	cmd.Flags().BoolVar(&force, "force", false, "Evaluate the chain even if export_fast_path finds nothing changed")
+	cmd.Flags().BoolVar(&timings, "timings", false, "Print how long each .envrc took to evaluate")
+	cmd.Flags().StringVar(&format, "format", "", "Write the variables the chain sets as `dotenv` or json instead of shell commands")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "With --format, write to `FILE` instead of stdout")
+	cmd.AddCommand(newExportContainerCmd(stdlib))
 
 	return cmd
 }
 
-func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool) error {
+// runExportWrapped runs export, printing its output wrapped by sh so the
+// shell applies all of it or none.
+func runExportWrapped(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache, timings bool) error {
+	stdout := cmd.OutOrStdout()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	err := runExport(cmd, sh, stdlib, noCache, timings, nil)
+	cmd.SetOut(stdout)
+
+	if _, werr := io.WriteString(stdout, sh.Wrap(out.String())); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// runExport prints the shell commands that bring this shell up to date. If
+// preview is not nil, it is a dry run: what would change is recorded in
+// preview, and nothing is printed to stdout or written to disk. With timings, the
+// time each level took is printed to stderr.
+func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache, timings bool, preview *PreviewOutput) error {
 	stderr := cmd.ErrOrStderr()
 	stdout := cmd.OutOrStdout()
+	log := newLogger(stderr)
+
+	if preview == nil {
+		warnStaleHook(stdout, stderr, sh)
+		autoCacheGC()
+
+		// Outside any project, nothing has changed since the last prompt
+		if negCacheHit() {
+			return nil
+		}
+	}
 
 	// Get current environment
 	currentEnv := env.FromGoEnv(os.Environ())
@@ -57,110 +206,116 @@ func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool)
 		var err error
 		prevDiff, err = env.Unmarshal(prevDiffStr)
 		if err != nil {
-			fmt.Fprintf(stderr, "cascade: warning: invalid CASCADE_DIFF, ignoring: %v\n", err)
+			if errors.Is(err, env.ErrDiffFormat) {
+				// Likely from a newer release: what it set cannot be read,
+				// so it stays in the environment once the diff is cleared
+				log.Warnf("%v, ignoring it: the variables it set cannot be reverted and are kept (start a new shell to drop them)", err)
+			} else {
+				log.Warnf("invalid CASCADE_DIFF, ignoring: %v", err)
+			}
 			prevDiff = nil
+			// Clear it, or every prompt would warn again where no
+			// .envrc applies and nothing else rewrites it
+			if preview == nil {
+				fmt.Fprint(stdout, sh.Export(shell.ShellExport{"CASCADE_DIFF": nil}))
+			}
 		}
 	}
-
-	// Get cascade root for chain traversal (from config or default to home)
-	home, err := cfg.GetCascadeRoot()
-	if err != nil {
-		return fmt.Errorf("get cascade root: %w", err)
+	// A diff that no longer describes this environment would revert to
+	// values that are not there; start over from the environment as it is
+	if !prevDiff.ConsistentWith(currentEnv) {
+		fmt.Fprintln(stderr, inconsistentDiffWarning)
+		prevDiff = nil
+		if preview == nil {
+			fmt.Fprint(stdout, sh.Export(shell.ShellExport{"CASCADE_DIFF": nil}))
+		}
 	}
+	prevDiff = keepOverridden(stderr, prevDiff, currentEnv)
 
-	// Get current working directory
-	cwd, err := os.Getwd()
+	// Find and authorize the .envrc chain from root to cwd
+	plan, err := planCurrentDir()
 	if err != nil {
-		return fmt.Errorf("get working directory: %w", err)
+		return err
 	}
-
-	// Find .envrc chain from home to cwd
-	chain, err := envrc.FindChain(home, cwd)
-	if err != nil {
-		// If cwd is not under home, just use cwd itself
-		chain, err = envrc.FindChain(cwd, cwd)
-		if err != nil {
-			return fmt.Errorf("find envrc chain: %w", err)
-		}
+	warnCaseVariants(stderr, plan.Chain)
+	warnUnsearchedTimeout(stderr, plan.Chain)
+	switch {
+	case errors.Is(plan.PolicyErr, policy.ErrNotAllowed):
+		// Like a new .envrc, until the user reviews it; status says so
+		log.Debugf("ignoring the policy file: %v", plan.PolicyErr)
+	case plan.PolicyErr != nil:
+		log.Warnf("ignoring the policy file: %v", plan.PolicyErr)
 	}
 
-	// Filter to existing files only
-	existing := envrc.ExistingOnly(chain)
-
 	// If no .envrc files and we have previous state, revert
-	if len(existing) == 0 {
-		return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil)
+	if len(plan.Levels) == 0 {
+		if preview != nil {
+			preview.addPlan(plan)
+		} else if (prevDiff == nil || prevDiff.IsEmpty()) && os.Getenv("CASCADE_DIR") == "" {
+			recordNegCache(stdout, sh, plan)
+		}
+		return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil, preview)
 	}
 
-	// Create allow store
-	store, err := allow.NewStore()
-	if err != nil {
-		return fmt.Errorf("create allow store: %w", err)
+	// Auto-allow clean checkouts from trusted remotes before evaluating anything
+	if len(cfg.TrustedRemotes) > 0 {
+		autoAllowTrustedRemotes(stderr, plan, preview != nil)
 	}
-
-	// Check allow status for each file (considering whitelist from config)
-	var notAllowed []*envrc.RC
-	var denied []*envrc.RC
-	var allowed []*envrc.RC
-
-	for _, rc := range existing {
-		switch store.CheckWithWhitelist(rc, cfg) {
-		case allow.Allowed:
-			allowed = append(allowed, rc)
-		case allow.NotAllowed:
-			notAllowed = append(notAllowed, rc)
-		case allow.Denied:
-			denied = append(denied, rc)
-		}
+	if preview != nil {
+		preview.addPlan(plan)
 	}
 
+	denied := plan.Filter(allow.Denied)
+	notAllowed := plan.Filter(allow.NotAllowed)
+	allowed := plan.Filter(allow.Allowed)
+
 	// If any denied, print error and revert
 	if len(denied) > 0 {
 		// Create state store for potential recovery
 		stateStore, _ := state.NewStore() // Ignore error - best effort
 
 		deniedPaths := make([]string, len(denied))
-		for i, rc := range denied {
-			fmt.Fprintf(stderr, "cascade: error: %s is blocked. Run `cascade allow %s` to unblock.\n", rc.Path, rc.Path)
-			deniedPaths[i] = rc.Path
+		for i, level := range denied {
+			fmt.Fprintf(stderr, "cascade: error: %s is blocked. Run `cascade allow %s` to unblock.\n", level.RC.Path, level.RC.Path)
+			deniedPaths[i] = level.RC.Path
 		}
-		return handleNoEnvrc(stdout, stderr, sh, prevDiff, stateStore, deniedPaths)
+		return handleNoEnvrc(stdout, stderr, sh, prevDiff, stateStore, deniedPaths, preview)
 	}
 
-	// If any not allowed, print warning and skip those
-	if len(notAllowed) > 0 {
-		for _, rc := range notAllowed {
-			fmt.Fprintf(stderr, "cascade: %s is not allowed. Run `cascade allow %s` to allow.\n", rc.Path, rc.Path)
+	// If any not allowed, remind once per session and skip those
+	notified, reminded := remindNotAllowed(log, plan)
+	if notified != os.Getenv(notifiedVar) {
+		if preview != nil {
+			preview.notified = notifiedExport(notified)
+		} else {
+			defer fmt.Fprint(stdout, sh.Export(notifiedExport(notified)))
+		}
+	}
+	// Allowing them is no help while the store cannot be written
+	if reminded > 0 {
+		if err := storeNotWritable(); err != nil {
+			log.Warnf("%v", err)
 		}
 	}
 
 	// If no allowed files, revert
 	if len(allowed) == 0 {
-		return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil)
+		return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil, preview)
 	}
 
-	// Get self path for evaluator
-	selfPath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("get executable path: %w", err)
+	// Create evaluator, caching unless disabled by flag or config. A dry
+	// run reads the cache but never creates or writes it.
+	useCache := cfg.CacheEnabled && !noCache
+	if preview != nil && useCache {
+		dir, err := eval.CacheDir()
+		useCache = err == nil && isDir(dir)
 	}
-
-	// Create evaluator
-	evaluator, err := eval.New("", stdlib, selfPath)
+	evaluator, err := newEvaluator(stderr, stdlib, useCache)
 	if err != nil {
-		return fmt.Errorf("create evaluator: %w", err)
+		return err
 	}
-
-	// Enable caching unless disabled by flag or config
-	cacheEnabled := cfg.CacheEnabled && !noCache
-	if cacheEnabled {
-		cache, err := eval.NewCache()
-		if err != nil {
-			// Cache creation failure is not fatal - just log and continue
-			fmt.Fprintf(stderr, "cascade: warning: cache unavailable: %v\n", err)
-		} else {
-			evaluator = evaluator.WithCache(cache)
-		}
+	if preview != nil {
+		evaluator = evaluator.WithDryRun()
 	}
 
 	// Start with current environment (filtered)
@@ -173,19 +328,36 @@ func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool)
 	}
 
 	// Evaluate each allowed .envrc in order, accumulating env
-	var lastRC *envrc.RC
-	var allExtraWatches []string
-	for _, rc := range allowed {
-		result, err := evaluator.Evaluate(rc, workingEnv)
-		if err != nil {
-			fmt.Fprintf(stderr, "cascade: error evaluating %s: %v\n", rc.Path, err)
-			// Continue with other files? For now, abort and revert
-			return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil)
+	result := run.Run(plan, workingEnv, evaluator, run.Options{
+		Optional:     optionalRoot(plan, allowed),
+		CollectDiffs: preview != nil, // To tell where each variable comes from
+	})
+	reportEvalTimes(stderr, allowed, timings || log.Enabled(logging.LevelDebug), time.Duration(cfg.SlowWarningMS)*time.Millisecond)
+	if result.Err != nil {
+		if errors.Is(result.Err, envrc.ErrChanged) {
+			fmt.Fprintf(stderr, "cascade: error: %s changed between approval and evaluation — not loaded, re-run `cascade allow %s`\n", result.Failed.RC.Path, result.Failed.RC.Path)
+		} else {
+			fmt.Fprintf(stderr, "cascade: error evaluating %s: %v\n", result.Failed.RC.Path, result.Err)
 		}
-		workingEnv = result.Env
-		allExtraWatches = append(allExtraWatches, result.ExtraWatches...)
-		lastRC = rc
+		if preview != nil {
+			preview.fail(result.Failed.RC.Path, result.Err)
+		} else {
+			recordEvalFailure(stderr, allowed[len(allowed)-1].RC.Path, result.Failed.RC.Path, result.Err, currentEnv, redactPatterns(result.Sensitive, sensitiveOf(prevDiff)))
+		}
+		// Abort and revert
+		return handleNoEnvrc(stdout, stderr, sh, prevDiff, nil, nil, preview)
 	}
+	for _, level := range allowed {
+		if level.Err != nil {
+			log.Warnf("%s failed, continuing without it (root_envrc = %q): %v", level.RC.Path, cfg.RootEnvrc, level.Err)
+			if preview != nil {
+				preview.fail(level.RC.Path, level.Err)
+			}
+		}
+	}
+	workingEnv = result.Env
+	allExtraWatches := result.ExtraWatches
+	lastRC := result.Last.RC
 
 	// Compute diff from original (reverted) env to final env
 	baseEnv := currentEnv.Filtered()
@@ -194,6 +366,11 @@ func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool)
 		baseEnv = reversed.Patch(baseEnv)
 	}
 	newDiff := env.BuildEnvDiff(baseEnv, workingEnv)
+	newDiff.Merge = result.Merge.Subset(newDiff.Next)
+	newDiff = newDiff.KeepFrom(prevDiff, lastRC.Dir)
+
+	// What is remembered (CASCADE_DIFF, state) leaves out sensitive values
+	stored := newDiff.Conceal(result.Sensitive)
 
 	// Log environment variable changes if enabled
 	// Only log when: directory changed OR diff effect changed (avoids spam on every prompt)
@@ -201,13 +378,13 @@ func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool)
 	// even when the actual effect (what variables are being set) is identical.
 	prevDir := os.Getenv("CASCADE_DIR")
 	dirChanged := prevDir != lastRC.Dir
-	diffChanged := !newDiff.EqualEffect(prevDiff)
-	if cfg.LogEnvDiff && (dirChanged || diffChanged) {
-		logEnvDiff(stderr, newDiff, false)
+	diffChanged := !stored.EqualEffect(prevDiff)
+	if cfg.LogEnvDiff && (dirChanged || diffChanged) && preview == nil {
+		logEnvDiff(stderr, newDiff, false, newDiffLogOptions(redactPatterns(result.Sensitive, sensitiveOf(prevDiff))))
 	}
 
 	// Marshal the new diff for CASCADE_DIFF
-	diffStr, err := env.Marshal(newDiff)
+	diffStr, err := env.Marshal(stored)
 	if err != nil {
 		return fmt.Errorf("marshal diff: %w", err)
 	}
@@ -234,50 +411,381 @@ func runExport(cmd *cobra.Command, sh shell.Shell, stdlib string, noCache bool)
 
 	export.Set("CASCADE_DIR", lastRC.Dir)
 	export.Set("CASCADE_FILE", lastRC.Path)
+	mirrorDirenvVars(export, lastRC.Dir, lastRC.Path)
+	// Files not allowed yet are skipped, which can leave CASCADE_DIR at a
+	// parent of the deepest .envrc; name it so prompts can tell
+	if len(notAllowed) > 0 {
+		export.Set("CASCADE_PENDING", notAllowed[len(notAllowed)-1].RC.Path)
+	} else if os.Getenv("CASCADE_PENDING") != "" {
+		export.Unset("CASCADE_PENDING")
+	}
+	if os.Getenv(negCacheVar) != "" {
+		export.Unset(negCacheVar)
+	}
 
 	// Build watch list: all .envrc files plus extra watches
 	watchPaths := make([]string, 0, len(allowed)+len(allExtraWatches))
-	for _, rc := range allowed {
-		watchPaths = append(watchPaths, rc.Path)
+	for _, level := range allowed {
+		watchPaths = append(watchPaths, level.RC.Path)
+		// A lockfile next to the .envrc is watched so editing it reloads
+		lock := filepath.Join(level.RC.Dir, lockFileName)
+		if slices.Contains(watchPaths, lock) {
+			continue // Another file of the same directory
+		}
+		if info, err := os.Stat(lock); err == nil && info.Mode().IsRegular() {
+			watchPaths = append(watchPaths, lock)
+		}
 	}
 	watchPaths = append(watchPaths, allExtraWatches...)
+	// So does editing or removing the policy file that allowed some of them
+	if plan.Policy != nil {
+		watchPaths = append(watchPaths, plan.Policy.File)
+	}
 
-	// Serialize and set CASCADE_WATCHES
-	watchList := env.NewWatchList(watchPaths)
+	// Serialize and set CASCADE_WATCHES, carrying adopted clock skew over
+	// from the previous watch list so it is reported only once
+	prevWatches, _ := env.ParseWatchList(os.Getenv("CASCADE_WATCHES"))
+	watchList := prevWatches.Rebase(watchPaths, cfg.WatchHash)
 	if watchStr, err := watchList.Serialize(); err == nil && watchStr != "" {
 		export.Set("CASCADE_WATCHES", watchStr)
 	}
 
-	// Output shell commands
+	if preview != nil {
+		preview.addExport(currentEnv, export, redactPatterns(result.Sensitive, sensitiveOf(prevDiff)))
+		preview.addScript(unloadCommands(allowed), export, allowed)
+		preview.Watches = watchPaths
+		return nil
+	}
+
+	// Output shell commands, after the on_unload commands of levels left
+	// behind, which still see their variables
+	for _, command := range unloadCommands(allowed) {
+		fmt.Fprintln(stdout, command)
+	}
 	fmt.Fprint(stdout, sh.Export(export))
 
+	// Share the applied variables with shells started outside the hook
+	if prevDir != "" && prevDir != lastRC.Dir {
+		if err := removeSessionFile(prevDir); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	if err := writeSessionFile(lastRC.Dir, withoutNames(export, result.Sensitive)); err != nil {
+		log.Warnf("%v", err)
+	}
+
 	// Save state for future revert capability
 	stateStore, stateErr := state.NewStore()
 	if stateErr != nil {
-		fmt.Fprintf(stderr, "cascade: warning: state storage unavailable: %v\n", stateErr)
+		log.Warnf("state storage unavailable: %v", stateErr)
 	} else {
 		// Save state for the last evaluated .envrc (the leaf of the chain)
-		if saveErr := stateStore.Save(lastRC.Path, lastRC.ContentHash, newDiff); saveErr != nil {
-			fmt.Fprintf(stderr, "cascade: warning: failed to save state: %v\n", saveErr)
+		var sourced map[string][]string
+		var unload []state.UnloadHooks
+		for _, level := range allowed {
+			if len(level.Sourced) > 0 {
+				if sourced == nil {
+					sourced = make(map[string][]string)
+				}
+				sourced[level.RC.Path] = level.Sourced
+			}
+			if level.Evaluated && len(level.Unload) > 0 {
+				unload = append(unload, state.UnloadHooks{Path: level.RC.Path, Commands: level.Unload})
+			}
+		}
+		if saveErr := stateStore.SaveChain(lastRC.Path, lastRC.ContentHash, stored, sourced, unload); saveErr != nil {
+			log.Warnf("failed to save state: %v", saveErr)
 		}
 	}
 
 	return nil
 }
 
+// redactPatterns returns the name patterns redacted in addition to
+// env.SecretPatterns: cache_exclude plus the given sensitive variables.
+func redactPatterns(sensitive ...[]string) []string {
+	patterns := slices.Clone(cfg.CacheExclude)
+	for _, names := range sensitive {
+		patterns = append(patterns, names...)
+	}
+	return patterns
+}
+
+// sensitiveOf returns the sensitive variables of diff, which may be nil.
+func sensitiveOf(diff *env.EnvDiff) []string {
+	if diff == nil {
+		return nil
+	}
+	return diff.Sensitive
+}
+
+// redactNames returns a copy of vars with the values of names replaced by
+// env.Redacted.
+func redactNames(vars env.Env, names []string) env.Env {
+	if len(names) == 0 || vars == nil {
+		return vars
+	}
+	cp := vars.Copy()
+	for _, name := range names {
+		if _, ok := cp[name]; ok {
+			cp[name] = env.Redacted
+		}
+	}
+	return cp
+}
+
+// withoutNames returns a copy of e without the given variables.
+func withoutNames(e shell.ShellExport, names []string) shell.ShellExport {
+	if len(names) == 0 {
+		return e
+	}
+	cp := maps.Clone(e)
+	for _, name := range names {
+		delete(cp, name)
+	}
+	return cp
+}
+
+// staleWarnAfter is how many consecutive failed evaluations of a chain
+// export tolerates before warning that the environment is not refreshing.
+const staleWarnAfter = 3
+
+// recordEvalFailure counts a failed evaluation in the state for the chain
+// ending at leafPath and warns once failures reach staleWarnAfter, then
+// again each time the count doubles.
+func recordEvalFailure(stderr io.Writer, leafPath, failedPath string, evalErr error, currentEnv env.Env, redact []string) {
+	stateStore, err := state.NewStore()
+	if err != nil {
+		return
+	}
+
+	// The error may quote .envrc output, so keep secrets out of the state file
+	msg := env.RedactText(evalErr.Error(), currentEnv, redact)
+	st, err := stateStore.RecordFailure(leafPath, failedPath, msg)
+	if err != nil {
+		fmt.Fprintf(stderr, "cascade: warning: failed to save state: %v\n", err)
+		return
+	}
+
+	if !shouldWarnStale(st.Failures) {
+		return
+	}
+	since := "it has never loaded here"
+	if !st.Timestamp.IsZero() {
+		since = "it last loaded at " + formatClock(st.Timestamp)
+	}
+	fmt.Fprintf(stderr, "cascade: warning: environment is not refreshing (%s); %s has failed %d times in a row; last error: %s\n",
+		since, st.FailedPath, st.Failures, st.LastError)
+}
+
+// shouldWarnStale reports whether failures is staleWarnAfter times a power
+// of two, so the warning backs off while the chain stays broken.
+func shouldWarnStale(failures int) bool {
+	if failures < staleWarnAfter || failures%staleWarnAfter != 0 {
+		return false
+	}
+	n := failures / staleWarnAfter
+	return n&(n-1) == 0
+}
+
+// exportShell returns the Shell to export for name, with fish setting the
+// variables of fish_list_vars as lists. It returns nil if name is not
+// supported.
+func exportShell(name string) shell.Shell {
+	if name == "fish" && cfg != nil {
+		return shell.NewFish(cfg.FishListVars)
+	}
+	return shell.Get(name)
+}
+
+// optionalRoot returns the run.Options.Optional policy for export. Unless
+// root_envrc is "required", the .envrc at the cascade root may fail without
+// aborting the chain, provided a deeper allowed level is left to apply.
+func optionalRoot(plan *run.Plan, allowed []*run.Level) func(*run.Level) bool {
+	if cfg.RootEnvrc == config.RootEnvrcRequired || len(allowed) < 2 || len(plan.Chain) == 0 {
+		return nil
+	}
+	root := allowed[0]
+	if root.RC.Dir != plan.Chain[0].Dir {
+		return nil
+	}
+	return func(l *run.Level) bool { return l == root }
+}
+
+// formatClock formats t as a time of day, with the date if it was not today.
+func formatClock(t time.Time) string {
+	now := time.Now()
+	if y, m, d := t.Date(); y == now.Year() && m == now.Month() && d == now.Day() {
+		return t.Format("15:04")
+	}
+	return t.Format("Jan 2 15:04")
+}
+
+// reportEvalTimes prints how long each evaluated level took when all is
+// set. Otherwise, it warns when evaluating them took longer than slow in
+// total, naming the slowest .envrc, unless slow is 0.
+func reportEvalTimes(w io.Writer, levels []*run.Level, all bool, slow time.Duration) {
+	home, _ := os.UserHomeDir()
+	var total time.Duration
+	var slowest *run.Level
+	for _, level := range levels {
+		if !level.Evaluated && level.Err == nil {
+			continue // Not reached
+		}
+		total += level.Duration
+		if slowest == nil || level.Duration > slowest.Duration {
+			slowest = level
+		}
+		if all {
+			line := fmt.Sprintf("cascade: %s %s", formatEvalTime(level.Duration), shortenPath(level.RC.Path, home))
+			if level.Cached {
+				line += " (cached)"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	if slowest == nil {
+		return
+	}
+	if all {
+		fmt.Fprintf(w, "cascade: %s total\n", formatEvalTime(total))
+		return
+	}
+	if slow > 0 && total > slow {
+		fmt.Fprintf(w, "cascade: %s took %s (consider caching)\n", shortenPath(slowest.RC.Path, home), formatEvalTime(slowest.Duration))
+	}
+}
+
+// formatEvalTime formats an evaluation time as milliseconds below a
+// second, e.g. "230ms", and seconds above, e.g. "1.3s".
+func formatEvalTime(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// autoAllowTrustedRemotes allows not-allowed levels that are clean checkouts
+// from a trusted git remote. Denied levels are never considered. With
+// dryRun, the levels are treated as allowed but nothing is recorded.
+func autoAllowTrustedRemotes(stderr io.Writer, plan *run.Plan, dryRun bool) {
+	notAllowed := plan.Filter(allow.NotAllowed)
+	if len(notAllowed) == 0 {
+		return
+	}
+
+	remotes, err := allow.NewRemoteTrust(cfg.TrustedRemotes)
+	if err != nil {
+		return
+	}
+	store, err := newAllowStore()
+	if err != nil {
+		return
+	}
+
+	for _, level := range notAllowed {
+		remote, ok := remotes.Match(context.Background(), level.RC)
+		if !ok {
+			continue
+		}
+		if dryRun {
+			level.Status = allow.Allowed
+			fmt.Fprintf(stderr, "cascade: would auto-allow %s (clean checkout of trusted remote %s)\n", level.RC.Path, remote)
+			continue
+		}
+		if err := store.WithTrigger("trusted remote " + remote).Allow(level.RC); err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: failed to auto-allow %s: %v\n", level.RC.Path, err)
+			continue
+		}
+		level.Status = allow.Allowed
+		level.Source = allow.SourceGlobal
+		fmt.Fprintf(stderr, "cascade: auto-allowed %s (clean checkout of trusted remote %s)\n", level.RC.Path, remote)
+	}
+}
+
+// notifiedVar lists the directories, separated as in PATH, whose .envrc
+// this shell was reminded is not allowed, so each reminder is printed once
+// per session.
+const notifiedVar = "CASCADE_NOTIFIED"
+
+// remindNotAllowed prints at info level how to allow each file of plan
+// that is not allowed, unless this shell was already reminded of its
+// directory; a file that changed since the chain's policy pinned it is
+// reported at warn level instead. It returns the new value of notifiedVar and how many reminders
+// it printed. A directory whose file is allowed again is dropped from the
+// list, so a later change to the file is reported again.
+func remindNotAllowed(log *logging.Logger, plan *run.Plan) (notified string, reminded int) {
+	var dirs []string
+	if old := os.Getenv(notifiedVar); old != "" {
+		dirs = filepath.SplitList(old)
+	}
+	for _, level := range plan.Levels {
+		i := slices.Index(dirs, level.RC.Dir)
+		switch {
+		case level.Status == allow.Allowed && i >= 0:
+			dirs = slices.Delete(dirs, i, i+1)
+		case level.PolicyMismatch && i < 0 && log.Enabled(logging.LevelWarn):
+			log.Warnf("%s differs from the version %s allows. Review it and run `cascade allow %s` to allow.", level.RC.Path, plan.Policy.File, level.RC.Path)
+			dirs = append(dirs, level.RC.Dir)
+			reminded++
+		case level.Status == allow.NotAllowed && i < 0 && log.Enabled(logging.LevelInfo):
+			log.Infof("%s is not allowed. Run `cascade allow %s` to allow.", level.RC.Path, level.RC.Path)
+			dirs = append(dirs, level.RC.Dir)
+			reminded++
+		}
+	}
+	return strings.Join(dirs, string(os.PathListSeparator)), reminded
+}
+
+// notifiedExport sets notifiedVar to notified, or unsets it if empty.
+func notifiedExport(notified string) shell.ShellExport {
+	export := make(shell.ShellExport)
+	if notified == "" {
+		export.Unset(notifiedVar)
+	} else {
+		export.Set(notifiedVar, notified)
+	}
+	return export
+}
+
+// mirrorDirenvVars sets DIRENV_DIR and DIRENV_FILE to dir and file with
+// direnv_compat, or unsets them if dir is empty. Mirrors left from a
+// session that had direnv_compat are unset, but never direnv's own.
+func mirrorDirenvVars(export shell.ShellExport, dir, file string) {
+	mirrored := os.Getenv("DIRENV_FILE") != "" && os.Getenv("DIRENV_FILE") == os.Getenv("CASCADE_FILE")
+	switch {
+	case cfg.DirenvCompat && dir != "":
+		export.Set("DIRENV_DIR", dir)
+		export.Set("DIRENV_FILE", file)
+	case cfg.DirenvCompat || mirrored:
+		export.Unset("DIRENV_DIR")
+		export.Unset("DIRENV_FILE")
+	}
+}
+
 // handleNoEnvrc handles the case when no .envrc files apply.
 // If we have previous state, revert it. Otherwise, do nothing.
-func handleNoEnvrc(stdout io.Writer, stderr io.Writer, sh shell.Shell, prevDiff *env.EnvDiff, stateStore *state.Store, deniedPaths []string) error {
-	// Try CASCADE_DIFF first
-	if prevDiff != nil && !prevDiff.IsEmpty() {
-		return revertAndCleanup(stdout, stderr, sh, prevDiff, stateStore, deniedPaths)
+// A non-nil preview makes it a dry run (see runExport).
+func handleNoEnvrc(stdout io.Writer, stderr io.Writer, sh shell.Shell, prevDiff *env.EnvDiff, stateStore *state.Store, deniedPaths []string, preview *PreviewOutput) error {
+	// The session file of the directory being left must not outlive it
+	if prevDir := os.Getenv("CASCADE_DIR"); prevDir != "" && preview == nil {
+		if err := removeSessionFile(prevDir); err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: %v\n", err)
+		}
+	}
+
+	// Try CASCADE_DIFF first; a chain that set nothing may still have
+	// on_unload commands to run
+	unload := unloadCommands(nil)
+	if prevDiff != nil && (!prevDiff.IsEmpty() || len(unload) > 0) {
+		return revertAndCleanup(stdout, stderr, sh, prevDiff, unload, stateStore, deniedPaths, preview)
 	}
 
 	// Fall back to persistent state for denied files
 	if stateStore != nil && len(deniedPaths) > 0 {
 		for _, path := range deniedPaths {
 			if savedState, err := stateStore.Load(path); err == nil && savedState != nil && savedState.Diff != nil {
-				return revertAndCleanup(stdout, stderr, sh, savedState.Diff, stateStore, deniedPaths)
+				return revertAndCleanup(stdout, stderr, sh, savedState.Diff, unload, stateStore, deniedPaths, preview)
 			}
 		}
 	}
@@ -291,16 +799,19 @@ func handleNoEnvrc(stdout io.Writer, stderr io.Writer, sh shell.Shell, prevDiff
 	return nil
 }
 
-// revertAndCleanup reverts the diff and cleans up state files
-func revertAndCleanup(stdout, stderr io.Writer, sh shell.Shell, diff *env.EnvDiff, stateStore *state.Store, deniedPaths []string) error {
-	// Log environment variable changes if enabled
-	if cfg.LogEnvDiff {
-		logEnvDiff(stderr, diff, true)
+// revertAndCleanup reverts the diff, after running the unload commands, and
+// cleans up state files
+func revertAndCleanup(stdout, stderr io.Writer, sh shell.Shell, diff *env.EnvDiff, unload []string, stateStore *state.Store, deniedPaths []string, preview *PreviewOutput) error {
+	reversed := diff.Reverse()
+
+	// Log environment variable changes if enabled: those of the revert,
+	// not the ones diff made
+	if cfg.LogEnvDiff && preview == nil {
+		logEnvDiff(stderr, reversed, true, newDiffLogOptions(redactPatterns(sensitiveOf(diff))))
 	}
 
 	export := make(shell.ShellExport)
 
-	reversed := diff.Reverse()
 	for key, value := range reversed.Next {
 		if value == "" {
 			export.Unset(key)
@@ -313,8 +824,25 @@ func revertAndCleanup(stdout, stderr io.Writer, sh shell.Shell, diff *env.EnvDif
 	export.Unset("CASCADE_DIFF")
 	export.Unset("CASCADE_DIR")
 	export.Unset("CASCADE_FILE")
+	mirrorDirenvVars(export, "", "")
 	export.Unset("CASCADE_WATCHES")
+	if os.Getenv("CASCADE_PENDING") != "" {
+		export.Unset("CASCADE_PENDING")
+	}
+	if os.Getenv(negCacheVar) != "" {
+		export.Unset(negCacheVar)
+	}
+
+	if preview != nil {
+		preview.Unload = true
+		preview.addExport(env.FromGoEnv(os.Environ()), export, redactPatterns(sensitiveOf(diff)))
+		preview.addScript(unload, export, nil)
+		return nil
+	}
 
+	for _, command := range unload {
+		fmt.Fprintln(stdout, command)
+	}
 	fmt.Fprint(stdout, sh.Export(export))
 
 	// Clean up state files after successful revert
@@ -327,15 +855,102 @@ func revertAndCleanup(stdout, stderr io.Writer, sh shell.Shell, diff *env.EnvDif
 	return nil
 }
 
+// unloadCommands returns the on_unload commands to run as the chain loaded
+// in this shell (CASCADE_FILE) is left: those of its levels that are not
+// evaluated levels of loaded, the chain replacing it. The deepest level's
+// come first, and each level's in reverse order of registration.
+func unloadCommands(loaded []*run.Level) []string {
+	leaf := os.Getenv("CASCADE_FILE")
+	if leaf == "" {
+		return nil
+	}
+	stateStore, err := state.NewStore()
+	if err != nil {
+		return nil
+	}
+	saved, err := stateStore.Load(leaf)
+	if err != nil || saved == nil {
+		return nil
+	}
+
+	var commands []string
+	for _, hooks := range slices.Backward(saved.Unload) {
+		if slices.ContainsFunc(loaded, func(l *run.Level) bool { return l.Evaluated && l.RC.Path == hooks.Path }) {
+			continue
+		}
+		for _, command := range slices.Backward(hooks.Commands) {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// keepOverridden returns diff without the variables changed in the shell
+// (current) since it applied them, noting each on stderr: they are the
+// user's now, and neither reverting nor refreshing the diff touches them
+// while the chain loaded stays the one they were kept in or goes deeper
+// (see env.EnvDiff.KeepFrom). With revert_mode "force", diff is returned
+// as it is.
+func keepOverridden(stderr io.Writer, diff *env.EnvDiff, current env.Env) *env.EnvDiff {
+	keys := userOverridden(diff, current)
+	for _, key := range keys {
+		fmt.Fprintf(stderr, "cascade: keeping %s (changed manually since load)\n", key)
+	}
+	return diff.Keep(keys, current["CASCADE_DIR"])
+}
+
+// warnCaseVariants warns about files in the chain that spell .envrc in
+// another case, which cascade never loads.
+func warnCaseVariants(w io.Writer, chain []*envrc.RC) {
+	for _, rc := range chain {
+		for _, name := range rc.CaseVariants {
+			fmt.Fprintf(w, "cascade: warning: found %s — cascade only loads .envrc; rename it\n", filepath.Join(rc.Dir, name))
+		}
+	}
+}
+
+// warnUnsearchedTimeout warns about the first level of the chain that did
+// not answer in time, such as a dead network mount; it and the levels
+// below it were treated as having no .envrc.
+func warnUnsearchedTimeout(w io.Writer, chain []*envrc.RC) {
+	for _, rc := range chain {
+		if rc.Unsearched == envrc.UnsearchedTimeout {
+			fmt.Fprintf(w, "cascade: warning: %s did not respond within %s; it and the directories below it were not searched for .envrc\n", rc.Dir, envrc.DefaultStatTimeout)
+			return
+		}
+	}
+}
+
+// diffLogOptions controls how logEnvDiff formats a diff.
+type diffLogOptions struct {
+	max    int      // Above this many changes, print counts only; zero or less never does
+	values bool     // Preview the values of added and changed variables
+	secret []string // Extra patterns whose values are never previewed (see env.IsSecret)
+}
+
+// newDiffLogOptions returns the diff log options set in the config. Values
+// of the secret variables are left out of previews, on top of
+// env.SecretPatterns.
+func newDiffLogOptions(secret []string) diffLogOptions {
+	return diffLogOptions{max: cfg.LogEnvDiffMax, values: cfg.LogEnvDiffValues, secret: secret}
+}
+
+// maxPreviewLen bounds each value shown in a diff log preview, in runes.
+const maxPreviewLen = 24
+
 // logEnvDiff logs environment variable changes to stderr.
 // Format: "cascade export: +VAR -VAR ~VAR" or "cascade unloading: ..."
-func logEnvDiff(w io.Writer, diff *env.EnvDiff, unloading bool) {
+// Above opts.max changes, only the counts are logged.
+func logEnvDiff(w io.Writer, diff *env.EnvDiff, unloading bool, opts diffLogOptions) {
 	if diff == nil || diff.IsEmpty() {
 		return
 	}
 
 	c := newColorizer(w)
-	var parts []string
+	prefix := "cascade export:"
+	if unloading {
+		prefix = "cascade unloading:"
+	}
 
 	// Collect and sort keys for deterministic output
 	keys := make([]string, 0, len(diff.Next))
@@ -343,26 +958,114 @@ func logEnvDiff(w io.Writer, diff *env.EnvDiff, unloading bool) {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	if len(keys) == 0 {
+		return
+	}
+
+	if opts.max > 0 && len(keys) > opts.max {
+		var added, changed, removed int
+		for _, key := range keys {
+			switch {
+			case diff.Prev[key] == "" && diff.Next[key] != "":
+				added++
+			case diff.Prev[key] != "" && diff.Next[key] == "":
+				removed++
+			default:
+				changed++
+			}
+		}
+		var counts []string
+		if added > 0 {
+			counts = append(counts, c.green(fmt.Sprintf("%d added", added)))
+		}
+		if changed > 0 {
+			counts = append(counts, c.yellow(fmt.Sprintf("%d changed", changed)))
+		}
+		if removed > 0 {
+			counts = append(counts, c.red(fmt.Sprintf("%d removed", removed)))
+		}
+		summary := strings.Join(counts, ", ")
+		if !unloading {
+			summary += " (run `cascade status` for details)"
+		}
+		fmt.Fprintf(w, "%s %s\n", prefix, summary)
+		return
+	}
 
+	parts := make([]string, 0, len(keys))
 	for _, key := range keys {
 		newVal := diff.Next[key]
 		oldVal := diff.Prev[key]
 
+		var part string
 		switch {
 		case oldVal == "" && newVal != "": // Added
-			parts = append(parts, c.green("+"+key))
+			part = c.green("+" + key)
 		case oldVal != "" && newVal == "": // Removed
 			parts = append(parts, c.red("-"+key))
+			continue
 		default: // Changed
-			parts = append(parts, c.yellow("~"+key))
+			part = c.yellow("~" + key)
 		}
+		if preview := diffPreview(key, oldVal, newVal, diff.Merge, opts); preview != "" {
+			part += c.dim(preview)
+		}
+		parts = append(parts, part)
+	}
+
+	fmt.Fprintf(w, "%s %s\n", prefix, strings.Join(parts, " "))
+}
+
+// diffPreview returns the value preview appended to an added or changed
+// variable in the diff log, or "" if previews are off or the value is
+// secret. Path-like and merged variables count the entries added and
+// removed instead of showing the value.
+func diffPreview(key, oldVal, newVal string, merge env.MergeSpec, opts diffLogOptions) string {
+	if !opts.values || env.IsSecret(key, opts.secret) {
+		return ""
+	}
+
+	var added, removed []string
+	switch sep, ok := merge[key]; {
+	case ok:
+		added, removed = env.ListDiff(oldVal, newVal, sep)
+	case treeIsPathLikeVar(key):
+		added, removed = pathComponentDiff(oldVal, newVal)
+	default:
+		if oldVal == "" {
+			return "(" + previewValue(newVal) + ")"
+		}
+		return "(" + previewValue(oldVal) + "\u2192" + previewValue(newVal) + ")"
+	}
+
+	var counts []string
+	if len(added) > 0 {
+		counts = append(counts, fmt.Sprintf("+%d", len(added)))
+	}
+	if len(removed) > 0 {
+		counts = append(counts, fmt.Sprintf("-%d", len(removed)))
 	}
+	if len(counts) == 0 { // Reordered only
+		return "(reordered)"
+	}
+	noun := "entries"
+	if len(added)+len(removed) == 1 {
+		noun = "entry"
+	}
+	return "(" + strings.Join(counts, " ") + " " + noun + ")"
+}
 
-	if len(parts) > 0 {
-		prefix := "cascade export:"
-		if unloading {
-			prefix = "cascade unloading:"
+// previewValue shortens a value for the diff log: control characters
+// become spaces and anything past maxPreviewLen runes is cut with "…".
+func previewValue(value string) string {
+	runes := []rune(strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
 		}
-		fmt.Fprintf(w, "%s %s\n", prefix, strings.Join(parts, " "))
+		return r
+	}, value))
+	if len(runes) <= maxPreviewLen {
+		return string(runes)
 	}
+	return string(runes[:maxPreviewLen-1]) + "\u2026"
 }
@@ -2,9 +2,15 @@ package cmd
 
 import (
 	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/unrss/cascade/internal/config"
 	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/shell"
 )
 
 func TestLogEnvDiff(t *testing.T) {
@@ -12,6 +18,7 @@ func TestLogEnvDiff(t *testing.T) {
 		name      string
 		diff      *env.EnvDiff
 		unloading bool
+		opts      diffLogOptions
 		want      string
 	}{
 		{
@@ -71,15 +78,196 @@ func TestLogEnvDiff(t *testing.T) {
 			unloading: false,
 			want:      "cascade export: +AAA +MMM +ZZZ\n",
 		},
+		{
+			name: "at the threshold lists names",
+			diff: diffOfChanges(2, 1, 1),
+			opts: diffLogOptions{max: 4},
+			want: "cascade export: +ADD0 +ADD1 ~CHG0 -DEL0\n",
+		},
+		{
+			name: "above the threshold summarizes",
+			diff: diffOfChanges(12, 3, 1),
+			opts: diffLogOptions{max: 10, values: true},
+			want: "cascade export: 12 added, 3 changed, 1 removed (run `cascade status` for details)\n",
+		},
+		{
+			name: "summary leaves out empty counts",
+			diff: diffOfChanges(0, 11, 0),
+			opts: diffLogOptions{max: 10},
+			want: "cascade export: 11 changed (run `cascade status` for details)\n",
+		},
+		{
+			name:      "unloading summary",
+			diff:      diffOfChanges(0, 0, 11),
+			unloading: true,
+			opts:      diffLogOptions{max: 10},
+			want:      "cascade unloading: 11 removed\n",
+		},
+		{
+			name: "no threshold never summarizes",
+			diff: diffOfChanges(12, 0, 0),
+			want: "cascade export: +ADD0 +ADD1 +ADD10 +ADD11 +ADD2 +ADD3 +ADD4 +ADD5 +ADD6 +ADD7 +ADD8 +ADD9\n",
+		},
+		{
+			name: "value previews",
+			diff: &env.EnvDiff{
+				Prev: map[string]string{"AWS_PROFILE": "dev", "REGION": "", "OLD": "gone"},
+				Next: map[string]string{"AWS_PROFILE": "prod", "REGION": "eu-west-1", "OLD": ""},
+			},
+			opts: diffLogOptions{max: 10, values: true},
+			want: "cascade export: ~AWS_PROFILE(dev\u2192prod) -OLD +REGION(eu-west-1)\n",
+		},
+		{
+			name: "previews off by default",
+			diff: &env.EnvDiff{
+				Prev: map[string]string{"AWS_PROFILE": "dev"},
+				Next: map[string]string{"AWS_PROFILE": "prod"},
+			},
+			opts: diffLogOptions{max: 10},
+			want: "cascade export: ~AWS_PROFILE\n",
+		},
+		{
+			name: "secret values are not previewed",
+			diff: &env.EnvDiff{
+				Prev: map[string]string{"API_TOKEN": "", "DB_URL": "old", "USER_NAME": ""},
+				Next: map[string]string{"API_TOKEN": "tok", "DB_URL": "new", "USER_NAME": "me"},
+			},
+			opts: diffLogOptions{values: true, secret: []string{"DB_URL"}},
+			want: "cascade export: +API_TOKEN ~DB_URL +USER_NAME(me)\n",
+		},
+		{
+			name: "long values are truncated",
+			diff: &env.EnvDiff{
+				Prev: map[string]string{"MESSAGE": ""},
+				Next: map[string]string{"MESSAGE": "line one\n" + strings.Repeat("x", 40)},
+			},
+			opts: diffLogOptions{values: true},
+			want: "cascade export: +MESSAGE(line one xxxxxxxxxxxxxx\u2026)\n",
+		},
+		{
+			name: "path-like variables count entries",
+			diff: &env.EnvDiff{
+				Prev: map[string]string{"PATH": "/usr/bin:/bin", "MANPATH": "/a:/b", "GOPATH": "", "CDPATH": "/x:/y"},
+				Next: map[string]string{"PATH": "/p/bin:/p/node_modules/.bin:/usr/bin:/bin", "MANPATH": "/a:/c", "GOPATH": "/go", "CDPATH": "/y:/x"},
+			},
+			opts: diffLogOptions{values: true},
+			want: "cascade export: ~CDPATH(reordered) +GOPATH(+1 entry) ~MANPATH(+1 -1 entries) ~PATH(+2 entries)\n",
+		},
+		{
+			name: "merged variables count entries",
+			diff: &env.EnvDiff{
+				Prev:  map[string]string{"FEATURES": "a,b"},
+				Next:  map[string]string{"FEATURES": "a,b,c,d"},
+				Merge: env.MergeSpec{"FEATURES": ","},
+			},
+			opts: diffLogOptions{values: true},
+			want: "cascade export: ~FEATURES(+2 entries)\n",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buf bytes.Buffer
-			logEnvDiff(&buf, tt.diff, tt.unloading)
+			logEnvDiff(&buf, tt.diff, tt.unloading, tt.opts)
 			if got := buf.String(); got != tt.want {
 				t.Errorf("logEnvDiff() = %q, want %q", got, tt.want)
 			}
 		})
 	}
 }
+
+// TestRevertAndCleanup_LogsRevert tests that unloading logs the changes
+// the revert makes, not those the reverted diff made.
+func TestRevertAndCleanup_LogsRevert(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg = config.Default()
+	cfg.LogEnvDiff = true
+	cfg.LogEnvDiffValues = true
+
+	diff := &env.EnvDiff{
+		Prev: map[string]string{"A": "", "B": "", "PROFILE": "dev"},
+		Next: map[string]string{"A": "1", "B": "2", "PROFILE": "prod"},
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := revertAndCleanup(&stdout, &stderr, shell.Get("bash"), diff, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cascade unloading: -A -B ~PROFILE(prod\u2192dev)\n"; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+
+	cfg.LogEnvDiffMax = 2
+	stderr.Reset()
+	if err := revertAndCleanup(&stdout, &stderr, shell.Get("bash"), diff, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cascade unloading: 1 changed, 2 removed\n"; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+}
+
+// diffOfChanges returns a diff adding ADD0..., changing CHG0... and
+// removing DEL0... variables.
+func diffOfChanges(added, changed, removed int) *env.EnvDiff {
+	diff := &env.EnvDiff{Prev: map[string]string{}, Next: map[string]string{}}
+	for i := range added {
+		diff.Prev[fmt.Sprintf("ADD%d", i)] = ""
+		diff.Next[fmt.Sprintf("ADD%d", i)] = "new"
+	}
+	for i := range changed {
+		diff.Prev[fmt.Sprintf("CHG%d", i)] = "old"
+		diff.Next[fmt.Sprintf("CHG%d", i)] = "new"
+	}
+	for i := range removed {
+		diff.Prev[fmt.Sprintf("DEL%d", i)] = "old"
+		diff.Next[fmt.Sprintf("DEL%d", i)] = ""
+	}
+	return diff
+}
+
+func TestShouldWarnStale(t *testing.T) {
+	var warned []int
+	for failures := 0; failures <= 30; failures++ {
+		if shouldWarnStale(failures) {
+			warned = append(warned, failures)
+		}
+	}
+
+	want := []int{staleWarnAfter, 2 * staleWarnAfter, 4 * staleWarnAfter, 8 * staleWarnAfter}
+	if !slices.Equal(warned, want) {
+		t.Errorf("warned at %v, want %v", warned, want)
+	}
+}
+
+func TestFormatDotenv(t *testing.T) {
+	vars := env.Env{
+		"PLAIN":  "/usr/local/bin:/usr/bin",
+		"SPACES": "two words $HOME",
+		"QUOTE":  "it's",
+		"MULTI":  "line one\nline \"two\" \\ end",
+		"EMPTY":  "",
+		"EXPAND": "it's $HOME and `id`",
+	}
+	want := `EMPTY=
+EXPAND="it's \$HOME and \` + "`id\\`" + `"
+MULTI="line one\nline \"two\" \\ end"
+PLAIN=/usr/local/bin:/usr/bin
+QUOTE="it's"
+SPACES='two words $HOME'
+`
+	got := formatDotenv(vars)
+	if got != want {
+		t.Errorf("formatDotenv() =\n%s\nwant\n%s", got, want)
+	}
+
+	// It reads back as written
+	parsed, err := env.ParseDotenv([]byte(got))
+	if err != nil {
+		t.Fatalf("ParseDotenv: %v", err)
+	}
+	if !maps.Equal(parsed, vars) {
+		t.Errorf("ParseDotenv(formatDotenv()) = %q, want %q", parsed, vars)
+	}
+}
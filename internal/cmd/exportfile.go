@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// Formats of export --format, for tools that read a file instead of
+// running a shell hook.
+const (
+	exportFormatDotenv = "dotenv"
+	exportFormatJSON   = "json"
+)
+
+// runExportFile evaluates the chain for the current directory and writes the
+// variables it sets in format, to output or else to stdout. Only what the
+// chain sets is written: not the rest of the environment, not variables it
+// unsets, and not the CASCADE_* bookkeeping. Sensitive variables are left
+// out, as their values are never persisted. A file is replaced atomically,
+// readable only by the user.
+func runExportFile(stdout, stderr io.Writer, stdlib, format, output string, noCache bool) error {
+	if format != exportFormatDotenv && format != exportFormatJSON {
+		return fmt.Errorf("unsupported format: %s (supported: %s, %s)", format, exportFormatDotenv, exportFormatJSON)
+	}
+	vars, sensitive, err := chainVariables(stderr, stdlib, noCache)
+	if err != nil {
+		return err
+	}
+	if len(sensitive) > 0 {
+		fmt.Fprintf(stderr, "cascade: leaving out sensitive variables: %s\n", strings.Join(sensitive, ", "))
+	}
+
+	data := []byte(formatDotenv(vars))
+	if format == exportFormatJSON {
+		if data, err = json.MarshalIndent(vars, "", "  "); err != nil {
+			return fmt.Errorf("marshal variables: %w", err)
+		}
+		data = append(data, '\n')
+	}
+
+	if output == "" || output == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(output, data)
+}
+
+// chainVariables evaluates the chain for the current directory as export
+// does, from this shell's environment with CASCADE_DIFF reverted, and
+// returns the variables it sets, without the sensitive ones, whose names
+// are returned sorted. Files that are not allowed are skipped with a
+// message; a denied file is an error.
+func chainVariables(stderr io.Writer, stdlib string, noCache bool) (env.Env, []string, error) {
+	plan, err := planCurrentDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	if denied := plan.Filter(allow.Denied); len(denied) > 0 {
+		path := denied[0].RC.Path
+		return nil, nil, fmt.Errorf("%s is blocked. Run `cascade allow %s` to unblock", path, path)
+	}
+	for _, level := range plan.Filter(allow.NotAllowed) {
+		fmt.Fprintf(stderr, "cascade: %s is not allowed. Run `cascade allow %s` to allow.\n", level.RC.Path, level.RC.Path)
+	}
+	allowed := plan.Filter(allow.Allowed)
+	if len(allowed) == 0 {
+		return env.Env{}, nil, nil
+	}
+
+	evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled && !noCache)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := revertedEnv(stderr)
+	result := run.Run(plan, base, evaluator, run.Options{Optional: optionalRoot(plan, allowed)})
+	if result.Err != nil {
+		return nil, nil, fmt.Errorf("evaluate %s: %w", result.Failed.RC.Path, result.Err)
+	}
+
+	vars := make(env.Env)
+	var sensitive []string
+	for key, value := range env.BuildEnvDiff(base, result.Env).Next {
+		switch {
+		case value == "":
+			continue
+		case slices.Contains(result.Sensitive, key):
+			sensitive = append(sensitive, key)
+		default:
+			vars[key] = value
+		}
+	}
+	slices.Sort(sensitive)
+	return vars, sensitive, nil
+}
+
+// dotenvBare matches values written without quotes in dotenv output.
+var dotenvBare = regexp.MustCompile(`^[A-Za-z0-9_./:,@%+=-]*$`)
+
+// formatDotenv renders vars as KEY=VALUE lines sorted by name, in the
+// quoting dotenv readers share: values with only safe characters are bare,
+// others single-quoted (taken literally), and values with a newline or a
+// single quote double-quoted with \n, \r, \", \\, \$ and \` escapes, so
+// readers that expand variables or commands in double quotes take none.
+func formatDotenv(vars env.Env) string {
+	var b strings.Builder
+	for _, key := range sortedKeys(vars) {
+		value := vars[key]
+		switch {
+		case dotenvBare.MatchString(value):
+		case !strings.ContainsAny(value, "'\r\n"):
+			value = "'" + value + "'"
+		default:
+			value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`).Replace(value) + `"`
+		}
+		fmt.Fprintf(&b, "%s=%s\n", key, value)
+	}
+	return b.String()
+}
+
+// writeFileAtomic replaces path with data, mode 0600, through a temporary
+// file in the same directory, so readers see the old content or the new.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s: %w", path, err)
+	}
+	return nil
+}
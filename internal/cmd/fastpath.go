@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// exportUnchanged reports whether what the last prompt applied still
+// holds, so export can print nothing without planning the chain or opening
+// the allow store and cache: export_fast_path is on, the shell is in the
+// directory of the deepest .envrc loaded (CASCADE_DIR), no file in
+// CASCADE_WATCHES, which include the chain's policy file, changed, and
+// neither the config file, a record in the user's or the system allow
+// store, nor a directory of the chain changed since the watches were
+// recorded. A refresh never takes the fast path, and neither does a
+// workspace store or trusted_remotes, whose verdicts depend on files and
+// git state it cannot check cheaply.
+func exportUnchanged() bool {
+	if !cfg.ExportFastPath || os.Getenv("CASCADE_REFRESH") != "" {
+		return false
+	}
+	if cfg.WorkspaceStore != "" || len(cfg.TrustedRemotes) > 0 {
+		return false
+	}
+	dir, encoded := os.Getenv("CASCADE_DIR"), os.Getenv("CASCADE_WATCHES")
+	if dir == "" || encoded == "" {
+		return false
+	}
+	if cwd, err := os.Getwd(); err != nil || cwd != dir {
+		return false
+	}
+
+	watches, err := env.ParseWatchList(encoded)
+	if err != nil || len(watches) == 0 || watches.Check() {
+		return false
+	}
+	recorded := watches[0].Recorded
+	for _, ft := range watches {
+		recorded = min(recorded, ft.Recorded)
+	}
+	return recorded != 0 && !storeChangedSince(recorded) && !chainDirsChangedSince(dir, recorded)
+}
+
+// chainDirsChangedSince reports whether dir or one of its ancestors up to
+// the cascade root changed at or after the Unix time since, or cannot be
+// checked. The watches cover only the .envrc files that were loaded;
+// creating one in another directory of the chain, or a skip marker,
+// changes that directory's mtime instead. The chain of a directory outside
+// the root that walks up (chain_outside_root) is not checked, and counts as
+// changed.
+func chainDirsChangedSince(dir string, since int64) bool {
+	root, err := cfg.GetCascadeRoot()
+	if err != nil {
+		return true
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return true
+	}
+	root = envrc.CanonicalCase(root)
+	top := dir
+	if inOrBelow(dir, root) {
+		top = root
+	} else if cfg.ChainOutsideRoot == config.ChainOutsideRootWalkUp {
+		return true
+	}
+
+	for {
+		info, err := os.Stat(dir)
+		if err != nil || info.ModTime().Unix() >= since {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if dir == top || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// inOrBelow reports whether dir is parent or below it. Both must be clean.
+func inOrBelow(dir, parent string) bool {
+	sep := string(filepath.Separator)
+	return dir == parent || strings.HasPrefix(dir, strings.TrimSuffix(parent, sep)+sep)
+}
+
+// storeChangedSince reports whether the config file or a record directory
+// of the user's or the system allow store changed at or after the Unix
+// time since, or cannot be checked. Adding or removing a record changes its
+// directory's mtime; the same second counts as a change, as mtimes are
+// compared in whole seconds.
+func storeChangedSince(since int64) bool {
+	dirs, err := resolveDirs()
+	if err != nil {
+		return true
+	}
+	paths := []string{cfg.File}
+	for _, base := range []string{dirs.Data, cfg.SystemDataDir} {
+		if base == "" {
+			continue
+		}
+		for _, kind := range []string{"allow", "deny", "trust"} {
+			paths = append(paths, filepath.Join(base, kind))
+		}
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || info.ModTime().Unix() >= since {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/run"
+)
+
+// previewLines is how much of a file check --fix and allow --all show
+// before asking.
+const previewLines = 10
+
+// errFixNeedsTTY explains how to resolve a chain without check --fix.
+var errFixNeedsTTY = errors.New(`--fix needs an interactive terminal; use "cascade status" to list the chain and "cascade allow <file>" or "cascade deny <file>" to resolve it`)
+
+// fixSession walks the problems in the current .envrc chain one file at a
+// time, applying each answer immediately.
+type fixSession struct {
+	in    *bufio.Reader
+	out   io.Writer
+	c     *colorizer
+	store *allow.Store
+	edit  func(path string) error // Opens a file in the user's editor
+	dir   string                  // Directory whose chain to fix; empty for the working directory
+}
+
+func runCheckFix(stdin io.Reader, stdout io.Writer, dir string) error {
+	if f, ok := stdin.(*os.File); !ok || !term.IsTerminal(int(f.Fd())) {
+		return errFixNeedsTTY
+	}
+
+	store, err := newAllowStore()
+	if err != nil {
+		return fmt.Errorf("create allow store: %w", err)
+	}
+
+	s := &fixSession{
+		in:    bufio.NewReader(stdin),
+		out:   stdout,
+		c:     newColorizer(stdout),
+		store: store.WithTrigger("cascade check --fix"),
+		edit:  openInEditor,
+		dir:   dir,
+	}
+	return s.run()
+}
+
+// run resolves each problem level of the current chain, then prints what the
+// next prompt will load.
+func (s *fixSession) run() error {
+	plan, err := planDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var problems []*run.Level
+	for _, level := range plan.Levels {
+		if level.Status != allow.Allowed {
+			problems = append(problems, level)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(s.out, "Every .envrc in the chain is allowed.")
+		return nil
+	}
+
+	for i, level := range problems {
+		fmt.Fprintf(s.out, "\n[%d/%d] ", i+1, len(problems))
+		quit, err := s.resolve(level.RC)
+		if err != nil {
+			return err
+		}
+		if quit {
+			break
+		}
+	}
+
+	return s.summary()
+}
+
+// resolve prompts for a single file until it is handled or skipped. It
+// reports whether the user asked to stop.
+func (s *fixSession) resolve(rc *envrc.RC) (bool, error) {
+	for {
+		status, source := s.store.Explain(rc, cfg)
+		fmt.Fprintf(s.out, "%s\n", s.c.bold(rc.Path))
+		fmt.Fprintf(s.out, "  status: %s\n", s.describe(rc, status, source))
+		if status == allow.Allowed {
+			return false, nil
+		}
+		s.preview(rc)
+
+		// An admin's deny cannot be overridden, so there is nothing to offer
+		if status == allow.Denied && source == allow.SourceSystem {
+			fmt.Fprintf(s.out, "  ask an administrator to remove the deny in %s\n", s.store.SystemDir())
+			return false, nil
+		}
+
+		choices := "[a]llow, [d]eny, [e]dit, [s]kip, [q]uit"
+		if status == allow.Denied {
+			choices = "[a]llow, [e]dit, [s]kip, [q]uit"
+		}
+		answer, err := s.ask("  " + choices + "? ")
+		if err != nil {
+			return true, err
+		}
+
+		switch answer {
+		case "a", "allow":
+			if status == allow.Denied {
+				confirm, err := s.ask("  This file was denied. Allow it anyway? [y/N] ")
+				if err != nil {
+					return true, err
+				}
+				if confirm != "y" && confirm != "yes" {
+					continue
+				}
+			}
+			if err := s.store.Allow(rc); err != nil {
+				return false, fmt.Errorf("allow file: %w", err)
+			}
+			fmt.Fprintf(s.out, "  %s\n", s.c.green("allowed"))
+			return false, nil
+		case "d", "deny":
+			if status == allow.Denied {
+				fmt.Fprintln(s.out, "  unchanged (already denied)")
+				return false, nil
+			}
+			if err := s.store.Deny(rc); err != nil {
+				return false, fmt.Errorf("deny file: %w", err)
+			}
+			fmt.Fprintf(s.out, "  %s\n", s.c.red("denied"))
+			return false, nil
+		case "e", "edit":
+			if err := s.edit(rc.Path); err != nil {
+				fmt.Fprintf(s.out, "  cascade: edit failed: %v\n", err)
+			}
+			// Editing changes the content hash, so check the new version
+			updated, err := envrc.NewRC(rc.Path)
+			if err != nil {
+				return false, fmt.Errorf("read file: %w", err)
+			}
+			if !updated.Exists {
+				fmt.Fprintln(s.out, "  file was removed")
+				return false, nil
+			}
+			rc = updated
+			fmt.Fprintln(s.out)
+			continue
+		case "s", "skip", "":
+			return false, nil
+		case "q", "quit":
+			return true, nil
+		}
+		fmt.Fprintf(s.out, "  unknown choice %q\n", answer)
+	}
+}
+
+// describe explains a level's status, naming the record that decided it.
+func (s *fixSession) describe(rc *envrc.RC, status allow.AllowStatus, source allow.Source) string {
+	return describeStatus(s.c, s.store, rc, status, source)
+}
+
+// preview prints what changed since the file was last allowed, or else its
+// first previewLines lines.
+func (s *fixSession) preview(rc *envrc.RC) {
+	if diff := changedDiff(s.store, rc); diff != "" {
+		printDiff(s.out, s.c, diff)
+		return
+	}
+	printFilePreview(s.out, s.c, rc)
+}
+
+// describeStatus explains the status of rc, naming the record that decided it.
+func describeStatus(c *colorizer, store *allow.Store, rc *envrc.RC, status allow.AllowStatus, source allow.Source) string {
+	switch {
+	case status == allow.NotAllowed && store.PreviouslyAllowed(rc):
+		return c.yellow("not allowed") + " (changed since it was allowed)"
+	case status == allow.NotAllowed:
+		return c.yellow("not allowed") + " (never allowed)"
+	case status == allow.Denied:
+		desc := c.red("denied")
+		if label := sourceLabel(string(source)); label != "" {
+			desc += " (via " + label + ")"
+		}
+		return desc
+	default:
+		return c.green(status.String())
+	}
+}
+
+// printFilePreview prints the first previewLines lines of rc, indented.
+func printFilePreview(w io.Writer, c *colorizer, rc *envrc.RC) {
+	content, err := rc.Content()
+	if err != nil {
+		fmt.Fprintf(w, "  cannot read file: %v\n", err)
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i, line := range lines {
+		if i == previewLines {
+			fmt.Fprintf(w, "  %s\n", c.dim(fmt.Sprintf("... %d more lines", len(lines)-previewLines)))
+			break
+		}
+		fmt.Fprintf(w, "  %s %s\n", c.dim("|"), line)
+	}
+}
+
+// ask prints prompt and returns the lower-cased answer. End of input is
+// treated as quitting.
+func (s *fixSession) ask(prompt string) (string, error) {
+	fmt.Fprint(s.out, prompt)
+	line, err := s.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && line == "" {
+		fmt.Fprintln(s.out)
+		return "q", nil
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read answer: %w", err)
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
+
+// summary re-checks the chain and prints what the next prompt will load.
+func (s *fixSession) summary() error {
+	plan, err := planDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	allowed := plan.Filter(allow.Allowed)
+	where := "The next prompt"
+	if !isShellDir(plan.Target) {
+		home, _ := os.UserHomeDir()
+		where = "A prompt in " + shortenPath(plan.Target, home)
+	}
+	fmt.Fprintf(s.out, "\n%s will load %d of %d .envrc files:\n", where, len(allowed), len(plan.Levels))
+	for _, level := range plan.Levels {
+		if level.Status == allow.Allowed {
+			fmt.Fprintf(s.out, "  %s %s\n", s.c.green("load"), level.RC.Path)
+		} else {
+			fmt.Fprintf(s.out, "  %s %s (%s)\n", s.c.dim("skip"), level.RC.Path, level.Status)
+		}
+	}
+	return nil
+}
+
+// openInEditor opens path in $VISUAL or $EDITOR, falling back to vi.
+func openInEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...) //nolint:gosec // user-chosen editor
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
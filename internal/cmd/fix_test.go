@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// setupFixChain creates a three-level chain under a temporary cascade root
+// and makes it the current directory. It returns the store and each .envrc.
+func setupFixChain(t *testing.T) (*allow.Store, []string) {
+	t.Helper()
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := filepath.Join(root, "home")
+	deepest := filepath.Join(home, "work", "project")
+
+	var paths []string
+	for _, dir := range []string{home, filepath.Join(home, "work"), deepest} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, ".envrc")
+		if err := os.WriteFile(path, []byte("export LEVEL="+filepath.Base(dir)+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	t.Setenv("XDG_DATA_HOME", filepath.Join(root, "data"))
+	t.Chdir(deepest)
+	prev := cfg
+	cfg = config.Default()
+	cfg.CascadeRoot = home
+	t.Cleanup(func() { cfg = prev })
+
+	store, err := newAllowStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, paths
+}
+
+func newTestFixSession(store *allow.Store, input string, edit func(string) error) (*fixSession, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &fixSession{
+		in:    bufio.NewReader(strings.NewReader(input)),
+		out:   &out,
+		c:     &colorizer{},
+		store: store,
+		edit:  edit,
+	}, &out
+}
+
+func mustRC(t *testing.T, path string) *envrc.RC {
+	t.Helper()
+	rc, err := envrc.NewRC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+func TestFixSession_ResolvesEachProblem(t *testing.T) {
+	store, paths := setupFixChain(t)
+
+	// Level 1 is new, level 2 changed since it was allowed, level 3 is denied
+	if err := store.Allow(mustRC(t, paths[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths[1], []byte("export LEVEL=changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Deny(mustRC(t, paths[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	// Allow the new file, skip the changed one, allow the denied one after confirming
+	s, out := newTestFixSession(store, "a\ns\na\ny\n", nil)
+	if err := s.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	got := out.String()
+
+	for _, want := range []string{
+		"[1/3] " + paths[0],
+		"status: not allowed (never allowed)",
+		"| export LEVEL=home",
+		"status: not allowed (changed since it was allowed)",
+		"status: denied",
+		"This file was denied. Allow it anyway?",
+		"The next prompt will load 2 of 3 .envrc files:",
+		"skip " + paths[1] + " (not allowed)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+
+	for i, want := range []allow.AllowStatus{allow.Allowed, allow.NotAllowed, allow.Allowed} {
+		if status := store.Check(mustRC(t, paths[i])); status != want {
+			t.Errorf("level %d status = %v, want %v", i, status, want)
+		}
+	}
+}
+
+func TestFixSession_DeniedNeedsConfirmation(t *testing.T) {
+	store, paths := setupFixChain(t)
+	for _, path := range paths[:2] {
+		if err := store.Allow(mustRC(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Deny(mustRC(t, paths[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	// Declining the confirmation re-prompts; end of input quits
+	s, out := newTestFixSession(store, "a\nn\n", nil)
+	if err := s.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if status := store.Check(mustRC(t, paths[2])); status != allow.Denied {
+		t.Errorf("status = %v, want denied", status)
+	}
+	if strings.Contains(out.String(), "[d]eny") {
+		t.Errorf("deny offered for an already denied file:\n%s", out.String())
+	}
+}
+
+func TestFixSession_DenyDeniedIsUnchanged(t *testing.T) {
+	store, paths := setupFixChain(t)
+	for _, path := range paths[:2] {
+		if err := store.Allow(mustRC(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Deny(mustRC(t, paths[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	// "d" is not offered, but typing it leaves the file denied and moves on
+	s, out := newTestFixSession(store, "d\n", nil)
+	if err := s.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if status := store.Check(mustRC(t, paths[2])); status != allow.Denied {
+		t.Errorf("status = %v, want denied", status)
+	}
+	got := out.String()
+	if !strings.Contains(got, "unchanged (already denied)") || strings.Contains(got, "unknown choice") {
+		t.Errorf("output = %q, want the file reported unchanged", got)
+	}
+}
+
+func TestFixSession_EditRechecks(t *testing.T) {
+	store, paths := setupFixChain(t)
+	for _, path := range paths[:2] {
+		if err := store.Allow(mustRC(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit := func(path string) error {
+		return os.WriteFile(path, []byte("export LEVEL=edited\n"), 0o644)
+	}
+	s, out := newTestFixSession(store, "e\na\n", edit)
+	if err := s.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if !strings.Contains(out.String(), "| export LEVEL=edited") {
+		t.Errorf("edited content not previewed:\n%s", out.String())
+	}
+	if status := store.Check(mustRC(t, paths[2])); status != allow.Allowed {
+		t.Errorf("edited file status = %v, want allowed", status)
+	}
+}
+
+func TestFixSession_Dir(t *testing.T) {
+	store, paths := setupFixChain(t)
+
+	// Fix the chain for the middle directory from the deepest one
+	s, out := newTestFixSession(store, "a\na\n", nil)
+	s.dir = filepath.Dir(paths[1])
+	if err := s.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if !strings.Contains(out.String(), "A prompt in "+s.dir+" will load 2 of 2 .envrc files:") {
+		t.Errorf("summary does not name the target directory:\n%s", out.String())
+	}
+	if status := store.Check(mustRC(t, paths[2])); status != allow.NotAllowed {
+		t.Errorf("file below the target status = %v, want not allowed", status)
+	}
+}
+
+func TestRunCheckFix_RequiresTerminal(t *testing.T) {
+	err := runCheckFix(strings.NewReader(""), &bytes.Buffer{}, "")
+	if err != errFixNeedsTTY {
+		t.Errorf("runCheckFix() = %v, want errFixNeedsTTY", err)
+	}
+}
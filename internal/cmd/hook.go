@@ -1,26 +1,68 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/unrss/cascade/internal/shell"
 )
 
+// hookOptions holds the hook command's flags.
+type hookOptions struct {
+	relocatable   bool // Look cascade up on PATH at every prompt
+	printPathOnly bool // Print the binary the hook would run instead of the hook
+}
+
 func newHookCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:       "hook <shell>",
-		Short:     "Print shell hook for cascade integration",
-		Long:      `Print the shell hook that should be evaluated in your shell's rc file.`,
+	var hookOpts hookOptions
+
+	cmd := &cobra.Command{
+		Use:   "hook <shell>",
+		Short: "Print shell hook for cascade integration",
+		Long: `Print the shell hook that should be evaluated in your shell's rc file,
+for bash, zsh, fish or pwsh (PowerShell).
+
+` + "`cascade hook tmux`" + ` instead prints tmux.conf lines that start new windows
+and panes in the current pane's directory with its cascade environment
+(requires session_export_file).
+
+The hook runs this cascade binary and records its version, so export can
+tell when the shell still uses a hook from another release. With
+--relocatable (or hook_resolve_path set), the hook looks cascade up on PATH
+at every prompt instead, falling back to this binary's path, so it keeps
+working after a package manager moves cascade or when synced to another
+machine.
+
+--print-path-only prints the cascade binary the hook would run, without
+the hook.`,
+		Example: `  eval "$(cascade hook bash)"      # in ~/.bashrc
+  eval "$(cascade hook zsh)"       # in ~/.zshrc
+  cascade hook fish | source       # in ~/.config/fish/config.fish
+  Invoke-Expression (& cascade hook pwsh | Out-String)  # in $PROFILE
+  cascade hook tmux >> ~/.tmux.conf
+  cascade hook bash --relocatable >> ~/.bashrc
+  cascade hook zsh --print-path-only`,
 		Args:      cobra.ExactArgs(1),
-		ValidArgs: []string{"bash", "zsh", "fish"},
+		ValidArgs: []string{"bash", "zsh", "fish", "pwsh", "tmux"},
+		Annotations: map[string]string{
+			skipConfig:    "",
+			envAnnotation: "CASCADE_HOOK_VERSION: Set by the hook to the version of cascade that generated it",
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			shellName := args[0]
 
 			sh := shell.Get(shellName)
-			if sh == nil {
+			if sh == nil && shellName != "tmux" {
 				return fmt.Errorf("unsupported shell: %s (supported: %v)", shellName, shell.Supported())
 			}
 
@@ -29,8 +71,157 @@ func newHookCmd() *cobra.Command {
 				return fmt.Errorf("get executable path: %w", err)
 			}
 
-			fmt.Fprint(cmd.OutOrStdout(), sh.Hook(selfPath))
+			if sh == nil {
+				if hookOpts.relocatable {
+					return errors.New("--relocatable is not supported for tmux")
+				}
+				if hookOpts.printPathOnly {
+					fmt.Fprintln(cmd.OutOrStdout(), selfPath)
+					return nil
+				}
+				fmt.Fprint(cmd.OutOrStdout(), tmuxHook(selfPath))
+				return nil
+			}
+
+			// A broken config file must not stop a new shell from getting
+			// its hook, so fall back to the default
+			opts := shell.HookOptions{SelfPath: selfPath, Version: cascadeVersion, ResolvePath: hookOpts.relocatable}
+			if !opts.ResolvePath {
+				if c, err := loadConfig(); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "cascade: warning: %v\n", err)
+				} else {
+					opts.ResolvePath = c.HookResolvePath
+				}
+			}
+
+			if hookOpts.printPathOnly {
+				fmt.Fprintln(cmd.OutOrStdout(), hookBinary(opts))
+				return nil
+			}
+			fmt.Fprint(cmd.OutOrStdout(), sh.Hook(opts))
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&hookOpts.relocatable, "relocatable", false, "Look cascade up on PATH at every prompt instead of using this binary's path")
+	cmd.Flags().BoolVar(&hookOpts.printPathOnly, "print-path-only", false, "Print the cascade binary the hook would run instead of the hook")
+
+	return cmd
+}
+
+// hookBinary returns the cascade binary a hook generated with opts would
+// run right now: the one on PATH for a relocatable hook, if there is one,
+// and SelfPath otherwise.
+func hookBinary(opts shell.HookOptions) string {
+	if opts.ResolvePath {
+		if path, err := exec.LookPath("cascade"); err == nil {
+			if abs, err := filepath.Abs(path); err == nil {
+				return abs
+			}
+			return path
+		}
+	}
+	return opts.SelfPath
+}
+
+// tmuxQuote escapes the characters tmux treats specially in double quotes.
+var tmuxQuote = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// tmuxHook returns tmux.conf lines that open windows and panes in the
+// current pane's directory through cascade session exec, so they start
+// with the environment the pane's shell exported.
+func tmuxHook(selfPath string) string {
+	// default-command is run by sh -c, inside a tmux double-quoted string
+	command := tmuxQuote.Replace(shell.BashQuote(selfPath) + " session exec")
+	return `# cascade: start new windows and panes with the current pane's environment.
+# Add to ~/.tmux.conf (requires session_export_file in cascade's config).
+set-option -g default-command "` + command + `"
+bind-key c new-window -c "#{pane_current_path}"
+bind-key '"' split-window -c "#{pane_current_path}"
+bind-key % split-window -h -c "#{pane_current_path}"
+`
+}
+
+// pathVersionTimeout bounds asking another cascade binary for its version.
+const pathVersionTimeout = 2 * time.Second
+
+// warnStaleHook tells the user when the shell's hook was generated by a
+// release of cascade more than a patch version away from the cascade on
+// PATH, which is what a new shell would use. The binary checked is
+// recorded in CASCADE_HOOK_CHECKED, so a session warns at most once and
+// runs a cascade other than this one at most once.
+func warnStaleHook(stdout, stderr io.Writer, sh shell.Shell) {
+	hookVersion := os.Getenv("CASCADE_HOOK_VERSION")
+	if hookVersion == "" {
+		return
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	self = resolveBinary(self)
+	onPath := self
+	if path, err := exec.LookPath("cascade"); err == nil {
+		onPath = resolveBinary(path)
+	}
+	if os.Getenv("CASCADE_HOOK_CHECKED") == onPath {
+		return
+	}
+
+	current := cascadeVersion
+	if onPath != self {
+		// A cascade that cannot report its version is only asked once too
+		current, _ = binaryVersion(onPath)
+	} else if !hookVersionMismatch(hookVersion, current) {
+		// Nothing to remember: checking this binary costs nothing
+		return
+	}
+
+	if hookVersionMismatch(hookVersion, current) {
+		fmt.Fprintf(stderr, "cascade: your shell hook was generated by cascade v%s — restart your shell or re-run %s to use v%s\n",
+			strings.TrimPrefix(hookVersion, "v"), shell.LoadLine(sh.Name()), strings.TrimPrefix(current, "v"))
+	}
+	checked := make(shell.ShellExport)
+	checked.Set("CASCADE_HOOK_CHECKED", onPath)
+	fmt.Fprint(stdout, sh.Export(checked))
+}
+
+// hookVersionMismatch reports whether versions a and b differ in their
+// major or minor version. Versions that do not parse never mismatch.
+func hookVersionMismatch(a, b string) bool {
+	va, err := parseSemver(a)
+	if err != nil {
+		return false
+	}
+	vb, err := parseSemver(b)
+	if err != nil {
+		return false
+	}
+	return va.nums[0] != vb.nums[0] || va.nums[1] != vb.nums[1]
+}
+
+// resolveBinary returns path with symlinks resolved, or path itself if it
+// cannot be resolved.
+func resolveBinary(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// binaryVersion asks the cascade binary at path for its version.
+func binaryVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pathVersionTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "version", "--json").Output() //nolint:gosec // the cascade on PATH
+	if err != nil {
+		return "", fmt.Errorf("run %s version: %w", path, err)
+	}
+	var header OutputHeader
+	if err := json.Unmarshal(out, &header); err != nil {
+		return "", fmt.Errorf("parse %s version: %w", path, err)
+	}
+	return header.Version, nil
 }
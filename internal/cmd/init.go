@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newInitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Create an in-workspace allow store",
+		Long: `Create the workspace allow store (configured by workspace_store) in a
+directory, defaulting to the current directory.
+
+Allow and deny records for .envrc files under that directory are then also
+kept in the workspace, so they survive when the global data directory is
+recreated (for example on devcontainer rebuilds). Keep the store out of
+version control: one git tracks anything in is ignored, as are records
+owned by another user.`,
+		Example: `  # With workspace_store = ".cascade" in config.toml
+  cascade init
+  cascade init /workspaces/myproject`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return runInit(cmd.OutOrStdout(), dir)
+		},
+	}
+}
+
+func runInit(w io.Writer, dir string) error {
+	if cfg.WorkspaceStore == "" {
+		return errors.New("workspace_store is not configured (set workspace_store = \".cascade\" in config.toml)")
+	}
+	if !filepath.IsLocal(cfg.WorkspaceStore) {
+		return fmt.Errorf("workspace_store must be a relative path inside the workspace: %s", cfg.WorkspaceStore)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolve path: %w", err)
+	}
+
+	storeDir := filepath.Join(absDir, cfg.WorkspaceStore)
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		return fmt.Errorf("create workspace store: %w", err)
+	}
+
+	fmt.Fprintf(w, "cascade: created workspace store %s\n", storeDir)
+
+	if !gitignoreContains(filepath.Join(absDir, ".gitignore"), cfg.WorkspaceStore) {
+		fmt.Fprintf(w, "cascade: consider adding %s/ to %s\n", cfg.WorkspaceStore, filepath.Join(absDir, ".gitignore"))
+	}
+
+	return nil
+}
+
+// gitignoreContains reports whether a .gitignore file lists the entry.
+func gitignoreContains(path, entry string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	entry = strings.Trim(filepath.ToSlash(entry), "/")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.Trim(strings.TrimSpace(scanner.Text()), "/") == entry {
+			return true
+		}
+	}
+	return false
+}
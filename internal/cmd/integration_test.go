@@ -2,14 +2,26 @@ package cmd_test
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/shell"
 )
 
 // testBinary holds the path to the compiled cascade binary.
@@ -22,7 +34,7 @@ var (
 
 // buildTestBinary compiles the cascade binary for testing.
 // Returns the path to the binary or an error.
-func buildTestBinary(t *testing.T) string {
+func buildTestBinary(t testing.TB) string {
 	t.Helper()
 
 	testBinaryOnce.Do(func() {
@@ -64,7 +76,7 @@ func (e *buildError) Error() string {
 
 // testEnv holds the test environment configuration.
 type testEnv struct {
-	t         *testing.T
+	t         testing.TB
 	binary    string
 	homeDir   string
 	dataDir   string
@@ -76,7 +88,7 @@ type testEnv struct {
 // setupTestEnv creates an isolated test environment.
 // homeDir is used as HOME and the root for .envrc chain discovery.
 // dataDir is used as XDG_DATA_HOME for allow/deny state.
-func setupTestEnv(t *testing.T) *testEnv {
+func setupTestEnv(t testing.TB) *testEnv {
 	t.Helper()
 
 	binary := buildTestBinary(t)
@@ -134,6 +146,29 @@ func (e *testEnv) withEnv(extra ...string) *testEnv {
 	return &cp
 }
 
+// withoutEnv returns a copy of testEnv without the given variables.
+func (e *testEnv) withoutEnv(unset ...string) *testEnv {
+	cp := *e
+	cp.baseEnv = slices.DeleteFunc(slices.Clone(e.baseEnv), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		return slices.Contains(unset, name)
+	})
+	cp.stderrBuf = new(bytes.Buffer)
+	return &cp
+}
+
+// withApplied returns a copy of testEnv with the variables set by an
+// export applied, as the shell evaluating it would have them.
+func (e *testEnv) withApplied(exports map[string]string) *testEnv {
+	var extra []string
+	for key, value := range exports {
+		if value != "" {
+			extra = append(extra, key+"="+value)
+		}
+	}
+	return e.withEnv(extra...)
+}
+
 // run executes cascade with the given arguments.
 // Returns stdout, stderr, and any error.
 func (e *testEnv) run(args ...string) (stdout, stderr string, err error) {
@@ -215,26 +250,51 @@ func (e *testEnv) createDir(dir string) {
 	}
 }
 
+// decodeGzenv decodes a CASCADE_WATCHES or CASCADE_DIFF value into its
+// JSON form, ignoring CASCADE_DIFF's checksum.
+func decodeGzenv(t *testing.T, encoded string) string {
+	t.Helper()
+	encoded = strings.TrimPrefix(encoded, "v2:")
+	encoded, _, _ = strings.Cut(encoded, ".")
+	compressed, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode %q: %v", encoded, err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("decompress %q: %v", encoded, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompress %q: %v", encoded, err)
+	}
+	return string(data)
+}
+
 // parseExport parses bash export output into a map.
-// Handles: export KEY="value"; and unset KEY;
+// Handles: export KEY='value'; and unset KEY;
 func parseExport(output string) map[string]string {
 	result := make(map[string]string)
 
-	// Match export KEY="value";
-	exportRe := regexp.MustCompile(`export ([A-Za-z_][A-Za-z0-9_]*)="([^"]*)";`)
-	for _, match := range exportRe.FindAllStringSubmatch(output, -1) {
-		key := match[1]
-		value := match[2]
-		// Unescape common bash escapes
-		value = strings.ReplaceAll(value, `\"`, `"`)
-		value = strings.ReplaceAll(value, `\\`, `\`)
-		result[key] = value
-	}
-
-	// Match unset KEY;
-	unsetRe := regexp.MustCompile(`unset ([A-Za-z_][A-Za-z0-9_]*);`)
-	for _, match := range unsetRe.FindAllStringSubmatch(output, -1) {
-		result[match[1]] = "" // Empty string indicates unset
+	// Match export KEY='value'; where the value is single-quoted runs
+	// joined by \' for embedded single quotes, or unset KEY;, in the order
+	// the shell runs them
+	stmtRe := regexp.MustCompile(`export ([A-Za-z_][A-Za-z0-9_]*)=((?:'[^']*'|\\')+);|unset ([A-Za-z_][A-Za-z0-9_]*);`)
+	runRe := regexp.MustCompile(`'([^']*)'|\\'`)
+	for _, match := range stmtRe.FindAllStringSubmatch(output, -1) {
+		if match[3] != "" {
+			result[match[3]] = "" // Empty string indicates unset
+			continue
+		}
+		var value strings.Builder
+		for _, run := range runRe.FindAllStringSubmatch(match[2], -1) {
+			if run[0] == `\'` {
+				value.WriteByte('\'')
+			} else {
+				value.WriteString(run[1])
+			}
+		}
+		result[match[1]] = value.String()
 	}
 
 	return result
@@ -554,6 +614,86 @@ export PROJECT="project_value"`)
 	assertStderrContains(t, stderr, "not allowed")
 }
 
+// TestIntegration_TreeAllSourceEnv tests that tree --all nests the files a
+// level sources with source_env under it, and that a cycle of source_env
+// calls is cut once and marked instead of recursing.
+func TestIntegration_TreeAllSourceEnv(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	sharedDir := filepath.Join(env.homeDir, "shared")
+	projectDir := filepath.Join(env.homeDir, "project")
+	sharedRC := filepath.Join(sharedDir, ".envrc")
+	projectRC := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(sharedDir, "export SHARED=shared_value\nsource_env ../project\n")
+	env.createEnvrc(projectDir, "source_env ../shared\nexport PROJECT=project_value\n")
+	for _, path := range []string{sharedRC, projectRC} {
+		if err := env.runAllow(path); err != nil {
+			t.Fatalf("allow %s: %v", path, err)
+		}
+	}
+
+	projectEnv := env.withWorkDir(projectDir)
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "source_env: skipping "+projectRC+": it is already being sourced (cycle)")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "SHARED", "shared_value")
+	assertExportContains(t, exports, "PROJECT", "project_value")
+
+	type include struct {
+		Path      string `json:"path"`
+		Status    string `json:"status"`
+		Cycle     bool   `json:"cycle"`
+		Variables []struct {
+			Name string `json:"name"`
+		} `json:"variables"`
+		Sourced []json.RawMessage `json:"sourced"`
+	}
+	stdout, _, err = projectEnv.run("tree", "--all", "--json")
+	if err != nil {
+		t.Fatalf("tree --all --json: %v", err)
+	}
+	var tree struct {
+		Levels []struct {
+			Path     string    `json:"path"`
+			Sourced  []string  `json:"sourced"`
+			Includes []include `json:"includes"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &tree); err != nil {
+		t.Fatalf("parse tree: %v\n%s", err, stdout)
+	}
+	level := tree.Levels[len(tree.Levels)-1]
+	if level.Path != projectRC || !slices.Equal(level.Sourced, []string{sharedRC}) || len(level.Includes) != 1 {
+		t.Fatalf("tree level = %+v, want %s sourcing %s", level, projectRC, sharedRC)
+	}
+	shared := level.Includes[0]
+	if shared.Path != sharedRC || shared.Status != "allowed" || shared.Cycle ||
+		len(shared.Variables) != 1 || shared.Variables[0].Name != "SHARED" || len(shared.Sourced) != 1 {
+		t.Fatalf("include = %+v, want %s allowed, setting SHARED and sourcing one file", shared, sharedRC)
+	}
+	var back include
+	if err := json.Unmarshal(shared.Sourced[0], &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Path != projectRC || !back.Cycle || len(back.Sourced) != 0 {
+		t.Errorf("nested include = %+v, want %s marked as a cycle", back, projectRC)
+	}
+
+	stdout, _, err = projectEnv.run("tree", "--all")
+	if err != nil {
+		t.Fatalf("tree --all: %v", err)
+	}
+	if strings.Count(stdout, "(cycle)") != 1 || !strings.Contains(stdout, "sources ~/shared/.envrc") {
+		t.Errorf("tree --all output does not nest the sourced files once:\n%s", stdout)
+	}
+}
+
 // TestIntegration_PathAdd tests PATH_add functionality.
 func TestIntegration_PathAdd(t *testing.T) {
 	if testing.Short() {
@@ -596,6 +736,43 @@ export MY_VAR="test"`)
 	}
 }
 
+// TestIntegration_PathAddDeduplicates tests that a bin directory every level
+// of the chain adds appears in PATH once, also after prompts that reload
+// the chain, and that a missing directory is noted.
+func TestIntegration_PathAddDeduplicates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	binDir := filepath.Join(env.homeDir, "bin")
+	workDir := filepath.Join(env.homeDir, "work")
+	projectDir := filepath.Join(workDir, "project")
+	env.createDir(binDir)
+	env.createEnvrc(env.homeDir, "PATH_add bin\n")
+	env.createEnvrc(workDir, "PATH_add ../bin\n")
+	env.createEnvrc(projectDir, "PATH_add \"$HOME/bin\"\nPATH_add missing\n")
+	for _, dir := range []string{env.homeDir, workDir, projectDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shell := env.withWorkDir(projectDir)
+	for prompt := range 3 {
+		stdout, stderr, err := shell.withEnv("CASCADE_REFRESH=1").runExport()
+		if err != nil {
+			t.Fatalf("export %d: %v\nstderr: %s", prompt, err, stderr)
+		}
+		assertStderrContains(t, stderr, "PATH_add: "+filepath.Join(projectDir, "missing")+" does not exist")
+		exports := parseExport(stdout)
+		if n := strings.Count(":"+exports["PATH"]+":", ":"+binDir+":"); n != 1 {
+			t.Fatalf("export %d: PATH = %q, want %s exactly once (found %d)", prompt, exports["PATH"], binDir, n)
+		}
+		shell = shell.withApplied(exports)
+	}
+}
+
 // TestIntegration_CdOut tests environment reversion when leaving a directory.
 func TestIntegration_CdOut(t *testing.T) {
 	if testing.Short() {
@@ -650,6 +827,212 @@ func TestIntegration_CdOut(t *testing.T) {
 	}
 }
 
+// TestIntegration_CorruptDiff tests that export wraps its output so it is
+// applied whole, and that a CASCADE_DIFF whose checksum does not match, or
+// in an unknown format, is ignored with a warning and cleared rather than
+// warned about at every prompt.
+func TestIntegration_CorruptDiff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, `export PROJECT_VAR="in_project"`)
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export in project: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.HasPrefix(stdout, "_cascade_apply() {\n") || !strings.HasSuffix(stdout, "\n_cascade_apply; unset -f _cascade_apply;\n") {
+		t.Errorf("export output is not wrapped:\n%s", stdout)
+	}
+	cascadeDiff := parseExport(stdout)["CASCADE_DIFF"]
+
+	// As if the shell kept a value cut short in its checksum
+	truncated := cascadeDiff[:len(cascadeDiff)-3]
+	stdout, stderr, err = env.withEnv("CASCADE_DIFF=" + truncated).runExport()
+	if err != nil {
+		t.Fatalf("export in home: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "invalid CASCADE_DIFF, ignoring: checksum mismatch")
+	assertExportUnsets(t, parseExport(stdout), "CASCADE_DIFF")
+
+	// A format from a newer release is cleared too, saying that what it
+	// set is left in place
+	newer := "v9" + strings.TrimPrefix(cascadeDiff, "v2")
+	stdout, stderr, err = env.withEnv("CASCADE_DIFF=" + newer).runExport()
+	if err != nil {
+		t.Fatalf("export in home: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, `unknown CASCADE_DIFF format "v9"`)
+	assertStderrContains(t, stderr, "cannot be reverted")
+	assertExportUnsets(t, parseExport(stdout), "CASCADE_DIFF")
+}
+
+// TestIntegration_ManualOverride changes variables in the shell after the
+// project's .envrc set them and checks that cd-ing out, or a refresh in the
+// project, leaves them alone: one changed, one unset, and one the .envrc
+// unset that was set again. revert_mode = "force" reverts them all.
+func TestIntegration_ManualOverride(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t).withEnv("EDITOR=vim", "PAGER=less", "BROWSER=firefox")
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export EDITOR=nvim PAGER=most UNTOUCHED=yes\nunset BROWSER\nPATH_add bin\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createDir(otherDir)
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	applied := parseExport(stdout)
+	assertExportContains(t, applied, "EDITOR", "nvim")
+	assertExportUnsets(t, applied, "BROWSER")
+
+	// The user runs export EDITOR=emacs, unset PAGER, export BROWSER=chrome
+	manual := maps.Clone(applied)
+	manual["EDITOR"] = "emacs"
+	manual["BROWSER"] = "chrome"
+	delete(manual, "PAGER")
+	// Another tool's prompt hook adds to PATH, which is reverted anyway
+	manual["PATH"] = "/opt/tool/bin:" + applied["PATH"]
+	shell := env.withoutEnv("PAGER").withApplied(manual)
+
+	keeping := []string{"BROWSER", "EDITOR", "PAGER"}
+	assertKept := func(t *testing.T, stderr string, exports map[string]string) {
+		t.Helper()
+		for _, key := range keeping {
+			assertExportNotContains(t, exports, key)
+			assertStderrContains(t, stderr, "cascade: keeping "+key+" (changed manually since load)")
+		}
+		assertStderrNotContains(t, stderr, "keeping UNTOUCHED")
+	}
+
+	t.Run("cd out", func(t *testing.T) {
+		stdout, stderr, _ := shell.withWorkDir(otherDir).runExport()
+		exports := parseExport(stdout)
+		assertKept(t, stderr, exports)
+		assertExportUnsets(t, exports, "UNTOUCHED")
+		assertExportUnsets(t, exports, "CASCADE_DIFF")
+		if path, ok := exports["PATH"]; !ok || strings.Contains(path, filepath.Join(projectDir, "bin")) {
+			t.Errorf("PATH = %q, still has the project's bin", exports["PATH"])
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		stdout, stderr, err := shell.withWorkDir(projectDir).withEnv("CASCADE_REFRESH=1").runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		exports := parseExport(stdout)
+		assertKept(t, stderr, exports)
+
+		// The kept variables stay the user's at later prompts, without
+		// another note, and are still not reverted on the way out
+		next := shell.withApplied(exports)
+		stdout, stderr, err = next.withWorkDir(projectDir).runExport()
+		if err != nil {
+			t.Fatalf("second export: %v\nstderr: %s", err, stderr)
+		}
+		exports = parseExport(stdout)
+		for _, key := range keeping {
+			assertExportNotContains(t, exports, key)
+		}
+		assertStderrNotContains(t, stderr, "keeping")
+
+		stdout, stderr, _ = next.withApplied(exports).withWorkDir(otherDir).runExport()
+		exports = parseExport(stdout)
+		for _, key := range keeping {
+			assertExportNotContains(t, exports, key)
+		}
+		assertStderrNotContains(t, stderr, "keeping")
+		assertExportUnsets(t, exports, "UNTOUCHED")
+	})
+
+	t.Run("force", func(t *testing.T) {
+		stdout, stderr, _ := shell.withWorkDir(otherDir).withEnv("CASCADE_REVERT_MODE=force").runExport()
+		exports := parseExport(stdout)
+		assertExportContains(t, exports, "EDITOR", "vim")
+		assertExportContains(t, exports, "PAGER", "less")
+		assertExportContains(t, exports, "BROWSER", "firefox")
+		assertExportUnsets(t, exports, "UNTOUCHED")
+		assertStderrNotContains(t, stderr, "keeping")
+	})
+}
+
+// TestIntegration_ManualOverrideSibling tests that a variable kept in one
+// chain is not kept in a sibling's, whose .envrc sets it again.
+func TestIntegration_ManualOverrideSibling(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	parentDir := filepath.Join(env.homeDir, "parent")
+	aDir := filepath.Join(parentDir, "a")
+	bDir := filepath.Join(parentDir, "b")
+	env.createEnvrc(aDir, "export AWS_PROFILE=a\n")
+	env.createEnvrc(bDir, "export AWS_PROFILE=b\n")
+	for _, dir := range []string{aDir, bDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subDir := filepath.Join(aDir, "sub")
+	env.createDir(subDir)
+
+	stdout, stderr, err := env.withWorkDir(aDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	applied := parseExport(stdout)
+	assertExportContains(t, applied, "AWS_PROFILE", "a")
+
+	// The user runs export AWS_PROFILE=manual, and the next prompt keeps it
+	applied["AWS_PROFILE"] = "manual"
+	stdout, stderr, err = env.withApplied(applied).withWorkDir(aDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "cascade: keeping AWS_PROFILE")
+	maps.Copy(applied, parseExport(stdout))
+	shell := env.withApplied(applied)
+
+	t.Run("deeper", func(t *testing.T) {
+		stdout, stderr, err := shell.withWorkDir(subDir).runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		assertExportNotContains(t, parseExport(stdout), "AWS_PROFILE")
+	})
+
+	t.Run("sibling", func(t *testing.T) {
+		stdout, stderr, err := shell.withWorkDir(bDir).runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		exports := parseExport(stdout)
+		assertExportContains(t, exports, "AWS_PROFILE", "b")
+
+		// Back in a, a's .envrc sets it again too
+		stdout, stderr, err = shell.withApplied(exports).withWorkDir(aDir).runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		assertExportContains(t, parseExport(stdout), "AWS_PROFILE", "a")
+	})
+}
+
 // TestIntegration_HookOutput tests that hook output contains expected shell setup.
 func TestIntegration_HookOutput(t *testing.T) {
 	if testing.Short() {
@@ -679,6 +1062,41 @@ func TestIntegration_HookOutput(t *testing.T) {
 	}
 }
 
+// TestIntegration_HookRelocatable tests that --relocatable makes the hook
+// look cascade up on PATH and --print-path-only names the binary it runs.
+func TestIntegration_HookRelocatable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+
+	for _, sh := range []string{"bash", "zsh", "fish"} {
+		stdout, _, err := env.run("hook", sh, "--relocatable")
+		if err != nil {
+			t.Fatalf("hook %s --relocatable: %v", sh, err)
+		}
+		if !strings.Contains(stdout, `echo "`+env.binary+`"`) {
+			t.Errorf("hook %s --relocatable should fall back to %s:\n%s", sh, env.binary, stdout)
+		}
+		if strings.Contains(stdout, "CASCADE_HOOK_VERSION=") || strings.Contains(stdout, "CASCADE_HOOK_VERSION '") {
+			t.Errorf("hook %s --relocatable should not record its version:\n%s", sh, stdout)
+		}
+	}
+
+	stdout, _, err := env.run("hook", "bash", "--print-path-only")
+	if err != nil {
+		t.Fatalf("hook --print-path-only: %v", err)
+	}
+	if stdout != env.binary+"\n" {
+		t.Errorf("hook --print-path-only = %q, want %q", stdout, env.binary+"\n")
+	}
+
+	if _, _, err := env.run("hook", "tmux", "--relocatable"); err == nil {
+		t.Error("hook tmux --relocatable should fail")
+	}
+}
+
 // TestIntegration_Status tests the status command output.
 func TestIntegration_Status(t *testing.T) {
 	if testing.Short() {
@@ -809,6 +1227,35 @@ func TestIntegration_ContentChangeInvalidatesAllow(t *testing.T) {
 	assertExportContains(t, exports, "TEST_VAR", "modified")
 }
 
+// TestIntegration_EnvrcSourcedUnderOwnName tests that an .envrc runs under
+// its own path, though the evaluator verifies a private copy: BASH_SOURCE
+// names it, and so do bash's error messages.
+func TestIntegration_EnvrcSourcedUnderOwnName(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	projectRC := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(projectDir, `export SOURCED_AS="${BASH_SOURCE[0]}"
+export SOURCE_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+cascade_no_such_command || true
+`)
+	if err := env.runAllow(projectRC); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "SOURCED_AS", projectRC)
+	assertExportContains(t, exports, "SOURCE_DIR", projectDir)
+	assertStderrContains(t, stderr, projectRC+": line 3: cascade_no_such_command: command not found")
+}
+
 // TestIntegration_PartialChainAllowed tests that only allowed files in chain are evaluated.
 func TestIntegration_PartialChainAllowed(t *testing.T) {
 	if testing.Short() {
@@ -961,6 +1408,26 @@ func TestIntegration_VersionCommand(t *testing.T) {
 	if len(strings.TrimSpace(stdout)) == 0 {
 		t.Error("version output is empty")
 	}
+
+	// --check exits 10 when the manifest names a newer version
+	manifest := filepath.Join(env.homeDir, "cascade.json")
+	if err := os.WriteFile(manifest, []byte(`{"version": "99.0.0"}`), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	stdout, _, err = env.withEnv("CASCADE_UPDATE_MANIFEST="+manifest).run("version", "--check")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 10 {
+		t.Fatalf("version --check error = %v, want exit status 10", err)
+	}
+	if !strings.Contains(stdout, "Update available") {
+		t.Errorf("stdout = %q, want an update notice", stdout)
+	}
+
+	// disable_update_check turns the check off
+	_, stderr, err := env.withEnv("CASCADE_UPDATE_MANIFEST="+manifest, "CASCADE_DISABLE_UPDATE_CHECK=true").run("version", "--check-update")
+	if err == nil || !strings.Contains(stderr, "update checks are disabled") {
+		t.Errorf("version --check-update with disable_update_check: err = %v, stderr = %q", err, stderr)
+	}
 }
 
 // TestIntegration_CheckCommand tests the check command for all statuses.
@@ -1029,6 +1496,122 @@ func TestIntegration_CheckCommand(t *testing.T) {
 	}
 }
 
+// TestIntegration_CheckChain tests check without an argument: it checks the
+// current directory's chain, as text or JSON, and with --strict names the
+// denied parent that breaks the chain.
+func TestIntegration_CheckChain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	rootRC := filepath.Join(env.homeDir, ".envrc")
+	workRC := filepath.Join(workDir, ".envrc")
+	env.createEnvrc(env.homeDir, "export ROOT=1\n")
+	env.createEnvrc(workDir, "export WORK=1\n")
+	if err := env.runAllow(rootRC); err != nil {
+		t.Fatal(err)
+	}
+
+	work := env.withWorkDir(workDir)
+	stdout, _, err := work.run("check")
+	if err == nil {
+		t.Error("check succeeded with a file of the chain not allowed")
+	}
+	if want := "allowed: " + rootRC + "\nnot allowed: " + workRC + "\n"; stdout != want {
+		t.Errorf("check stdout = %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = work.run("check", "--json")
+	if err == nil {
+		t.Error("check --json succeeded with a file of the chain not allowed")
+	}
+	var output struct {
+		Files []struct {
+			Path   string `json:"path"`
+			Status string `json:"status"`
+		} `json:"files"`
+		Broken string `json:"broken"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("parse check --json: %v\n%s", err, stdout)
+	}
+	if len(output.Files) != 2 || output.Files[0].Path != rootRC || output.Files[0].Status != "allowed" ||
+		output.Files[1].Path != workRC || output.Files[1].Status != "not allowed" {
+		t.Errorf("check --json files = %+v, want %s allowed and %s not allowed", output.Files, rootRC, workRC)
+	}
+
+	if err := env.runAllow(workRC); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := work.run("check", "--silent"); err != nil {
+		t.Errorf("check --silent failed with the whole chain allowed: %v", err)
+	}
+
+	// A skip marker ends the chain as export does, which --strict accepts
+	projectDir := filepath.Join(workDir, "project")
+	env.createEnvrc(projectDir, "export PROJECT=1\n")
+	skip := filepath.Join(projectDir, ".cascade-skip")
+	if err := os.WriteFile(skip, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	project := env.withWorkDir(projectDir)
+	if _, stderr, err := project.run("check", "--strict"); err != nil {
+		t.Errorf("check --strict failed for a skipped chain: %v\n%s", err, stderr)
+	}
+	if err := os.Remove(skip); err != nil {
+		t.Fatal(err)
+	}
+
+	// A denied parent breaks the chain below it, which --strict names
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.runDeny(workRC); err != nil {
+		t.Fatal(err)
+	}
+	broken := workRC + " is denied, which blocks the .envrc below it"
+	stdout, _, err = project.run("check")
+	if err == nil || strings.Contains(stdout, "broken") {
+		t.Errorf("check = %v, stdout %q, want a failure without the break named", err, stdout)
+	}
+	stdout, _, err = project.run("check", "--strict")
+	if err == nil {
+		t.Error("check --strict succeeded for a chain broken by a denied parent")
+	}
+	if !strings.Contains(stdout, "broken: "+broken) {
+		t.Errorf("check --strict stdout = %q, want %q", stdout, "broken: "+broken)
+	}
+	stdout, _, _ = project.run("check", "--json", "--strict")
+	output.Broken = ""
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("parse check --json: %v\n%s", err, stdout)
+	}
+	if output.Broken != broken {
+		t.Errorf("check --json broken = %q, want %q", output.Broken, broken)
+	}
+}
+
+// TestIntegration_CheckFixRequiresTerminal tests that check --fix refuses to
+// prompt without a terminal and points at the individual commands.
+func TestIntegration_CheckFixRequiresTerminal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	env.createEnvrc(env.homeDir, `export TEST_VAR="test_value"`)
+
+	_, stderr, err := env.run("check", "--fix")
+	if err == nil {
+		t.Fatal("check --fix should fail without a terminal")
+	}
+	if !strings.Contains(stderr, "cascade allow <file>") {
+		t.Errorf("stderr = %q, want a pointer to cascade allow", stderr)
+	}
+}
+
 // TestIntegration_StateRecovery_AfterCascadeDiffCleared tests that persistent state
 // is used to revert variables when CASCADE_DIFF is not available (new shell session).
 func TestIntegration_StateRecovery_AfterCascadeDiffCleared(t *testing.T) {
@@ -1139,10 +1722,10 @@ func TestIntegration_StateRecovery_ChainWithMixedStatus(t *testing.T) {
 	assertExportContains(t, exports, "VAR2", "from_project")
 
 	// Verify CASCADE_DIFF was set
-	cascadeDiff, ok := exports["CASCADE_DIFF"]
-	if !ok {
+	if _, ok := exports["CASCADE_DIFF"]; !ok {
 		t.Fatal("CASCADE_DIFF not set after export")
 	}
+	applied := exports
 
 	// Now simulate: CASCADE_DIFF is cleared (new shell session)
 	// Deny the parent home/.envrc but keep project/.envrc allowed
@@ -1166,7 +1749,7 @@ func TestIntegration_StateRecovery_ChainWithMixedStatus(t *testing.T) {
 
 	// Now test with CASCADE_DIFF present (simulating same shell session)
 	// This should properly unset both variables
-	projectEnvWithDiff := projectEnv.withEnv("CASCADE_DIFF=" + cascadeDiff)
+	projectEnvWithDiff := projectEnv.withApplied(applied)
 	stdout, _, _ = projectEnvWithDiff.runExport()
 
 	exports = parseExport(stdout)
@@ -1454,9 +2037,61 @@ func TestIntegration_TreeDeniedFile(t *testing.T) {
 	if !strings.Contains(stdout, "denied") {
 		t.Error("tree output missing 'denied' status")
 	}
+
+	// And preview the variable the file would set, without running it
+	if !strings.Contains(stdout, "TEST_VAR") || !strings.Contains(stdout, "(not evaluated)") {
+		t.Errorf("tree output missing the declared variable:\n%s", stdout)
+	}
 }
 
-// TestIntegration_TreeNoEnvrc tests tree output when no .envrc files exist.
+func TestIntegration_TreeNotAllowedPreview(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	marker := filepath.Join(env.homeDir, "ran")
+	env.createEnvrc(env.homeDir, "export API_URL=https://example.com\nPATH_add bin\ntouch "+marker+"\n")
+
+	stdout, _, err := env.run("tree", "--json", "--values")
+	if err != nil {
+		t.Fatalf("tree --json: %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("tree ran a file that is not allowed")
+	}
+
+	type varEntry struct {
+		Name   string   `json:"name"`
+		Action string   `json:"action"`
+		Value  string   `json:"value"`
+		Added  []string `json:"added"`
+		Static bool     `json:"static"`
+	}
+	var tree struct {
+		Levels []struct {
+			Status    string     `json:"status"`
+			Variables []varEntry `json:"variables"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &tree); err != nil {
+		t.Fatalf("parse JSON: %v\n%s", err, stdout)
+	}
+	if len(tree.Levels) != 1 || tree.Levels[0].Status != "not allowed" {
+		t.Fatalf("levels = %+v, want one not allowed level", tree.Levels)
+	}
+	vars := tree.Levels[0].Variables
+	if len(vars) != 2 ||
+		vars[0].Name != "API_URL" || vars[0].Action != "declared" || vars[0].Value != "https://example.com" || !vars[0].Static ||
+		vars[1].Name != "PATH" || !vars[1].Static || !slices.Equal(vars[1].Added, []string{filepath.Join(env.homeDir, "bin")}) {
+		t.Errorf("variables = %+v, want API_URL and PATH declared", vars)
+	}
+	if !strings.Contains(stdout, `"static": true`) {
+		t.Errorf("JSON missing the static field:\n%s", stdout)
+	}
+}
+
+// TestIntegration_TreeNoEnvrc tests tree output when no .envrc files exist.
 func TestIntegration_TreeNoEnvrc(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test in short mode")
@@ -1530,3 +2165,5363 @@ func TestIntegration_TreePathPrepend(t *testing.T) {
 		t.Error("PATH not found in tree output")
 	}
 }
+
+// setupTrustedCheckout creates a git work tree under home with the given
+// origin, commits an .envrc, and configures trusted_remotes.
+func setupTrustedCheckout(t *testing.T, env *testEnv, origin string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	env.createDir(configDir)
+	config := "trusted_remotes = [\"git@github.com:ourorg/\"]\n"
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(config), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	repoDir := filepath.Join(env.homeDir, "repo")
+	env.createEnvrc(repoDir, `export REPO_VAR="from_repo"`)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", origin},
+		{"add", ".envrc"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	return repoDir
+}
+
+// TestIntegration_TrustedRemoteAutoAllow tests that clean checkouts from a
+// trusted remote are allowed without prompting.
+func TestIntegration_TrustedRemoteAutoAllow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	repoDir := setupTrustedCheckout(t, env, "git@github.com:ourorg/repo.git")
+	// The clone's own config must not get git to run anything
+	ran := filepath.Join(env.homeDir, "fsmonitor-ran")
+	cmd := exec.Command("git", "config", "core.fsmonitor", "touch "+ran+"; false")
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config: %v\n%s", err, out)
+	}
+
+	stdout, stderr, err := env.withWorkDir(repoDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Error("git ran the repository's core.fsmonitor")
+	}
+
+	assertStderrContains(t, stderr, "auto-allowed")
+	assertExportContains(t, parseExport(stdout), "REPO_VAR", "from_repo")
+
+	records := readAuditLog(t, env)
+	if len(records) != 1 || records[0].Op != "allow" || records[0].Path != filepath.Join(repoDir, ".envrc") ||
+		records[0].Trigger != "trusted remote git@github.com:ourorg/repo.git" || records[0].Hash == "" {
+		t.Errorf("audit records after auto-allow = %+v", records)
+	}
+}
+
+// TestIntegration_TrustedRemoteDirty tests that local modifications disable
+// auto-allow, until they are committed.
+func TestIntegration_TrustedRemoteDirty(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	repoDir := setupTrustedCheckout(t, env, "git@github.com:ourorg/repo.git")
+	env.createEnvrc(repoDir, `export REPO_VAR="modified"`)
+
+	stdout, stderr, err := env.withWorkDir(repoDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+
+	assertStderrNotContains(t, stderr, "auto-allowed")
+	assertExportNotContains(t, parseExport(stdout), "REPO_VAR")
+
+	// Committing the same content makes it a clean checkout
+	cmd := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "modify")
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit: %v\n%s", err, out)
+	}
+	stdout, stderr, err = env.withWorkDir(repoDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "auto-allowed")
+	assertExportContains(t, parseExport(stdout), "REPO_VAR", "modified")
+}
+
+// TestIntegration_TrustedRemoteUntrusted tests that other remotes are not auto-allowed.
+func TestIntegration_TrustedRemoteUntrusted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	repoDir := setupTrustedCheckout(t, env, "git@github.com:someone-else/repo.git")
+
+	stdout, stderr, err := env.withWorkDir(repoDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+
+	assertStderrNotContains(t, stderr, "auto-allowed")
+	assertExportNotContains(t, parseExport(stdout), "REPO_VAR")
+}
+
+// setupCacheOutput creates an .envrc whose cache_output command appends to
+// a counter file, and returns a function reporting how often it ran.
+func setupCacheOutput(t *testing.T, env *testEnv) func() int {
+	t.Helper()
+
+	counter := filepath.Join(env.homeDir, "runs")
+	env.createEnvrc(env.homeDir, `cache_output 1h API_TOKEN -- sh -c 'echo run >> "$HOME/runs"; echo s3cr3t'`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	return func() int {
+		data, err := os.ReadFile(counter)
+		if err != nil {
+			return 0
+		}
+		return strings.Count(string(data), "run\n")
+	}
+}
+
+// TestIntegration_CacheOutputRunsOnce tests that cache_output reuses the
+// stored value across evaluations even when the eval cache is bypassed.
+func TestIntegration_CacheOutputRunsOnce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	runs := setupCacheOutput(t, env)
+
+	for i := range 2 {
+		stdout, stderr, err := env.run("export", "bash", "--no-cache")
+		if err != nil {
+			t.Fatalf("export %d: %v\nstderr: %s", i, err, stderr)
+		}
+		assertExportContains(t, parseExport(stdout), "API_TOKEN", "s3cr3t")
+	}
+	if got := runs(); got != 1 {
+		t.Errorf("command ran %d times, want 1", got)
+	}
+
+	// CASCADE_REFRESH forces a re-run
+	if _, stderr, err := env.withEnv("CASCADE_REFRESH=1").runExport(); err != nil {
+		t.Fatalf("export (refresh): %v\nstderr: %s", err, stderr)
+	}
+	if got := runs(); got != 2 {
+		t.Errorf("command ran %d times after refresh, want 2", got)
+	}
+
+	// cache clear wipes stored outputs
+	if _, stderr, err := env.run("cache", "clear"); err != nil {
+		t.Fatalf("cache clear: %v\nstderr: %s", err, stderr)
+	}
+	if _, stderr, err := env.run("export", "bash", "--no-cache"); err != nil {
+		t.Fatalf("export (after clear): %v\nstderr: %s", err, stderr)
+	}
+	if got := runs(); got != 3 {
+		t.Errorf("command ran %d times after cache clear, want 3", got)
+	}
+}
+
+// TestIntegration_CacheGC tests that cache gc drops entries for deleted
+// projects and keeps the rest, and that --dry-run only counts them.
+func TestIntegration_CacheGC(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	kept := filepath.Join(env.homeDir, "kept")
+	gone := filepath.Join(env.homeDir, "gone")
+	for _, dir := range []string{kept, gone} {
+		env.createEnvrc(dir, "export PROJECT=template")
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if _, stderr, err := env.withWorkDir(dir).runExport(); err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+	}
+	if err := os.RemoveAll(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := env.run("cache", "gc", "--dry-run")
+	if err != nil {
+		t.Fatalf("cache gc --dry-run: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Would remove 1 stale cache entry") {
+		t.Errorf("cache gc --dry-run output = %q, want one entry to remove", stdout)
+	}
+
+	stdout, stderr, err = env.run("cache", "gc")
+	if err != nil {
+		t.Fatalf("cache gc: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Removed 1 stale cache entry") {
+		t.Errorf("cache gc output = %q, want one entry removed", stdout)
+	}
+
+	stdout, _, err = env.run("cache", "gc")
+	if err != nil {
+		t.Fatalf("cache gc (again): %v", err)
+	}
+	if !strings.Contains(stdout, "Removed 0 stale cache entries") {
+		t.Errorf("second cache gc output = %q, want nothing removed", stdout)
+	}
+}
+
+// dataFileTimes returns the modification time of every file under dir.
+func dataFileTimes(t *testing.T, dir string) map[string]time.Time {
+	t.Helper()
+	times := map[string]time.Time{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		times[path] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", dir, err)
+	}
+	return times
+}
+
+// TestIntegration_ExportDryRun tests that export --dry-run reports what the
+// next prompt would change without printing shell code or saving state.
+func TestIntegration_ExportDryRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t).withEnv("EDITOR=vi", "OLD_TOKEN=hunter2")
+	project := filepath.Join(env.homeDir, "project")
+	sub := filepath.Join(project, "sub")
+	env.createEnvrc(project, "export PROJECT=api\nexport EDITOR=nano\nexport API_TOKEN=s3cr3t")
+	env.createEnvrc(sub, "export SUB=1")
+	if err := env.runAllow(filepath.Join(project, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	// A real export first, so there is state and a CASCADE_DIFF to revert
+	stdout, stderr, err := env.withWorkDir(project).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	subEnv := env.withWorkDir(sub).withEnv(
+		"CASCADE_DIFF="+exports["CASCADE_DIFF"],
+		"CASCADE_DIR="+exports["CASCADE_DIR"],
+		"PROJECT=api", "EDITOR=nano", "API_TOKEN=s3cr3t",
+	)
+	before := dataFileTimes(t, env.dataDir)
+
+	stdout, stderr, err = subEnv.run("export", "--dry-run", "--json", "bash")
+	if err != nil {
+		t.Fatalf("export --dry-run: %v\nstderr: %s", err, stderr)
+	}
+	var preview struct {
+		Directory string `json:"directory"`
+		Files     []struct {
+			Path   string `json:"path"`
+			Action string `json:"action"`
+		} `json:"files"`
+		Changes []struct {
+			Name   string `json:"name"`
+			Action string `json:"action"`
+			Old    string `json:"old"`
+			New    string `json:"new"`
+		} `json:"changes"`
+		Watches []string `json:"watches"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &preview); err != nil {
+		t.Fatalf("parse preview: %v\n%s", err, stdout)
+	}
+
+	if preview.Directory != sub {
+		t.Errorf("directory = %q, want %q", preview.Directory, sub)
+	}
+	actions := map[string]string{}
+	for _, f := range preview.Files {
+		actions[f.Path] = f.Action
+	}
+	if got := actions[filepath.Join(project, ".envrc")]; got != "evaluate" {
+		t.Errorf("project .envrc action = %q, want evaluate", got)
+	}
+	if got := actions[filepath.Join(sub, ".envrc")]; got != "skip" {
+		t.Errorf("sub .envrc action = %q, want skip", got)
+	}
+	// Against this shell, which already has the project loaded, nothing
+	// changes and the secret is never shown
+	if len(preview.Changes) != 0 {
+		t.Errorf("changes = %+v, want none", preview.Changes)
+	}
+	if !slices.Contains(preview.Watches, filepath.Join(project, ".envrc")) {
+		t.Errorf("watches = %q, want the project .envrc", preview.Watches)
+	}
+
+	// From a fresh shell, the same directory adds and changes variables
+	stdout, stderr, err = env.withWorkDir(sub).run("export", "--dry-run", "bash")
+	if err != nil {
+		t.Fatalf("export --dry-run: %v\nstderr: %s", err, stderr)
+	}
+	if exports := parseExport(stdout); len(exports) != 0 {
+		t.Errorf("dry run printed shell statements: %v", exports)
+	}
+	for _, want := range []string{"+ PROJECT = api", "~ EDITOR = vi → nano", "+ API_TOKEN = [redacted]", "skip", "not allowed"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("preview missing %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "s3cr3t") {
+		t.Errorf("preview shows a secret value:\n%s", stdout)
+	}
+
+	// Leaving the project previews the revert
+	stdout, stderr, err = subEnv.withWorkDir(env.homeDir).run("export", "--dry-run", "bash")
+	if err != nil {
+		t.Fatalf("export --dry-run: %v\nstderr: %s", err, stderr)
+	}
+	for _, want := range []string{"unloaded", "- PROJECT", "~ EDITOR = nano → vi"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("unload preview missing %q:\n%s", want, stdout)
+		}
+	}
+
+	after := dataFileTimes(t, env.dataDir)
+	if len(after) != len(before) {
+		t.Errorf("dry run changed the data directory: %d files before, %d after", len(before), len(after))
+	}
+	for path, mtime := range before {
+		if !after[path].Equal(mtime) {
+			t.Errorf("dry run modified %s", path)
+		}
+	}
+
+	if _, _, err := env.run("export", "--json", "bash"); err == nil {
+		t.Error("export --json without --dry-run should fail")
+	}
+}
+
+// TestIntegration_ExportExplain tests that export --explain prints the
+// shell code the next prompt would run, naming where each variable comes
+// from, without writing the state, the cache or cache_output's store.
+func TestIntegration_ExportExplain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	project := filepath.Join(env.homeDir, "project")
+	sub := filepath.Join(project, "sub")
+	env.createEnvrc(project, "export PROJECT=api\n")
+	env.createEnvrc(sub, "export SUB=1\ncache_output 1h TOKEN -- echo tok\n")
+	for _, dir := range []string{project, sub} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+	}
+
+	// A real export first, so there is state and a cache to leave alone
+	stdout, stderr, err := env.withWorkDir(project).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	applied := env.withApplied(parseExport(stdout))
+	cacheDir := filepath.Join(env.homeDir, ".cache")
+	before := dataFileTimes(t, env.dataDir)
+	maps.Copy(before, dataFileTimes(t, cacheDir))
+	if len(before) == 0 {
+		t.Fatal("no state or cache files to compare")
+	}
+
+	stdout, stderr, err = applied.withWorkDir(sub).run("export", "--explain", "bash")
+	if err != nil {
+		t.Fatalf("export --explain: %v\nstderr: %s", err, stderr)
+	}
+	for _, want := range []string{
+		"# PROJECT: set by ~/project/.envrc\nexport PROJECT='api';\n",
+		"# SUB: set by ~/project/sub/.envrc\nexport SUB='1';\n",
+		"# TOKEN: set by ~/project/sub/.envrc\nexport TOKEN='tok';\n",
+		"# CASCADE_DIR: cascade state\nexport CASCADE_DIR='" + sub + "';\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("explain output missing %q:\n%s", want, stdout)
+		}
+	}
+
+	// Leaving the project explains the revert
+	stdout, stderr, err = applied.withWorkDir(env.homeDir).run("export", "--explain", "bash")
+	if err != nil {
+		t.Fatalf("export --explain: %v\nstderr: %s", err, stderr)
+	}
+	if want := "# PROJECT: unset, as it was before cascade set it\nunset PROJECT;\n"; !strings.Contains(stdout, want) {
+		t.Errorf("explain output missing %q:\n%s", want, stdout)
+	}
+
+	after := dataFileTimes(t, env.dataDir)
+	maps.Copy(after, dataFileTimes(t, cacheDir))
+	if len(after) != len(before) {
+		t.Errorf("explain changed the data or cache directory: %d files before, %d after", len(before), len(after))
+	}
+	for path, mtime := range before {
+		if !after[path].Equal(mtime) {
+			t.Errorf("explain modified %s", path)
+		}
+	}
+
+	if _, _, err := env.run("export", "--explain", "--dry-run", "bash"); err == nil {
+		t.Error("export --explain --dry-run should fail")
+	}
+}
+
+// TestIntegration_DataDir tests that --data-dir and CASCADE_DATA_DIR move
+// the allow store, with the flag taking precedence, and that
+// CASCADE_DATA_DIR moves state along with it.
+func TestIntegration_DataDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	project := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(project, "export PROJECT=api")
+	rcPath := filepath.Join(project, ".envrc")
+	ciStore := filepath.Join(t.TempDir(), "ci-cascade")
+	emptyStore := filepath.Join(t.TempDir(), "empty")
+
+	if _, stderr, err := env.run("allow", "--data-dir", ciStore, rcPath); err != nil {
+		t.Fatalf("allow --data-dir: %v\nstderr: %s", err, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(ciStore, "allow")); err != nil {
+		t.Errorf("allow record not written to --data-dir: %v", err)
+	}
+
+	// The default store knows nothing about it
+	if _, _, err := env.run("check", "--silent", rcPath); err == nil {
+		t.Error("check with the default store: want not allowed")
+	}
+
+	// The whole chain checks out against the prepared store
+	stdout, stderr, err := env.run("check", "--data-dir", ciStore, project)
+	if err != nil {
+		t.Errorf("check --data-dir DIR: %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+	if !strings.Contains(stdout, "allowed: "+rcPath) {
+		t.Errorf("check output = %q, want %s allowed", stdout, rcPath)
+	}
+
+	// Flag beats env, env beats the default
+	if _, _, err := env.withEnv("CASCADE_DATA_DIR="+emptyStore).run("check", "--silent", "--data-dir", ciStore, rcPath); err != nil {
+		t.Errorf("--data-dir should take precedence over %s: %v", "CASCADE_DATA_DIR", err)
+	}
+	if _, _, err := env.withEnv("CASCADE_DATA_DIR="+emptyStore).run("check", "--silent", rcPath); err == nil {
+		t.Error("CASCADE_DATA_DIR pointing at an empty store: want not allowed")
+	}
+
+	// export honors the env var, and keeps state there too
+	projectEnv := env.withWorkDir(project).withEnv("CASCADE_DATA_DIR=" + ciStore)
+	stdout, stderr, err = projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportContains(t, parseExport(stdout), "PROJECT", "api")
+	if _, err := os.Stat(filepath.Join(ciStore, "state")); err != nil {
+		t.Errorf("state not saved in CASCADE_DATA_DIR: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.dataDir, "cascade", "state")); err == nil {
+		t.Error("state saved in the default data directory")
+	}
+
+	stdout, _, _ = projectEnv.run("doctor")
+	if !strings.Contains(stdout, ciStore+" (set by CASCADE_DATA_DIR)") {
+		t.Errorf("doctor does not report the override:\n%s", stdout)
+	}
+
+	// The flag moves only the allow store, leaving state in place, and
+	// bugreport reports both
+	flagStore := filepath.Join(t.TempDir(), "flag")
+	if _, stderr, err := env.run("allow", "--data-dir", flagStore, rcPath); err != nil {
+		t.Fatalf("allow --data-dir: %v\nstderr: %s", err, stderr)
+	}
+	if _, stderr, err := env.withWorkDir(project).run("export", "bash", "--data-dir", flagStore); err != nil {
+		t.Fatalf("export --data-dir: %v\nstderr: %s", err, stderr)
+	}
+	defaultState := filepath.Join(env.dataDir, "cascade", "state")
+	if _, err := os.Stat(defaultState); err != nil {
+		t.Errorf("state not saved in the default data directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(flagStore, "state")); err == nil {
+		t.Error("state saved in --data-dir")
+	}
+	for _, tt := range []struct {
+		name      string
+		env       *testEnv
+		args      []string
+		wantData  string
+		wantState string
+	}{
+		{"flag", env, []string{"bugreport", "--data-dir", flagStore}, flagStore, defaultState},
+		{"env", projectEnv, []string{"bugreport"}, ciStore, filepath.Join(ciStore, "state")},
+	} {
+		stdout, stderr, err := tt.env.run(tt.args...)
+		if err != nil {
+			t.Fatalf("%s: bugreport: %v\nstderr: %s", tt.name, err, stderr)
+		}
+		var r struct {
+			Dirs struct {
+				Data  string `json:"data"`
+				State string `json:"state"`
+			} `json:"dirs"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &r); err != nil {
+			t.Fatalf("%s: parse bugreport: %v\n%s", tt.name, err, stdout)
+		}
+		if r.Dirs.Data != tt.wantData || r.Dirs.State != tt.wantState {
+			t.Errorf("%s: dirs = %+v, want data %s and state %s", tt.name, r.Dirs, tt.wantData, tt.wantState)
+		}
+	}
+}
+
+// TestIntegration_CacheOutputExcluded tests that cache_exclude keeps
+// matching values off disk, so the command runs on every evaluation.
+func TestIntegration_CacheOutputExcluded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	runs := setupCacheOutput(t, env)
+
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	env.createDir(configDir)
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("cache_exclude = [\"*_TOKEN\"]\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	for i := range 2 {
+		stdout, stderr, err := env.runExport()
+		if err != nil {
+			t.Fatalf("export %d: %v\nstderr: %s", i, err, stderr)
+		}
+		assertExportContains(t, parseExport(stdout), "API_TOKEN", "s3cr3t")
+	}
+	if got := runs(); got != 2 {
+		t.Errorf("command ran %d times, want 2 (excluded values must not be cached)", got)
+	}
+
+	// Neither the eval cache nor the kv store may hold the secret
+	err := filepath.WalkDir(filepath.Join(env.homeDir, ".cache"), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err == nil && strings.Contains(string(data), "s3cr3t") {
+			t.Errorf("secret written to %s", path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("walk cache: %v", err)
+	}
+}
+
+// TestIntegration_InternalKV tests the kv callback used by cache_output.
+func TestIntegration_InternalKV(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+
+	kv := func(rcHash, stdin string, args ...string) (string, error) {
+		cmd := exec.Command(env.binary, append([]string{"internal", "kv"}, args...)...) //nolint:gosec // intentional CLI test harness
+		cmd.Dir = env.workDir
+		cmd.Env = append(append([]string{}, env.baseEnv...), "CASCADE_RC_HASH="+rcHash)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		return string(out), err
+	}
+
+	if _, err := kv("abc", "", "get", "KEY"); err == nil {
+		t.Error("get before set should fail")
+	}
+	if _, err := kv("abc", "hello world\n", "set", "KEY", "1h"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if got, err := kv("abc", "", "get", "KEY"); err != nil || got != "hello world\n" {
+		t.Errorf("get = %q, %v, want stored value", got, err)
+	}
+	if _, err := kv("other", "", "get", "KEY"); err == nil {
+		t.Error("get with a different content hash should fail")
+	}
+	if _, err := kv("abc", "x", "set", "KEY", "soon"); err == nil {
+		t.Error("set with an invalid duration should fail")
+	}
+	if _, err := kv("", "", "get", "KEY"); err == nil {
+		t.Error("get without CASCADE_RC_HASH should fail")
+	}
+}
+
+// TestIntegration_ExportContainer tests env-file and manifest generation.
+func TestIntegration_ExportContainer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	env.createEnvrc(env.homeDir, `export ORG="acme"`)
+	env.createEnvrc(workDir, `export APP="api"
+PATH_add bin`)
+
+	// Not-allowed chains are refused by default
+	if _, stderr, err := env.run("export", "container", workDir); err == nil {
+		t.Fatal("expected error for a chain that is not allowed")
+	} else {
+		assertStderrContains(t, stderr, "not fully allowed")
+	}
+
+	for _, dir := range []string{env.homeDir, workDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	output := filepath.Join(env.homeDir, "env.list")
+	if _, stderr, err := env.run("export", "container", workDir, "--output", output); err != nil {
+		t.Fatalf("export container: %v\nstderr: %s", err, stderr)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read env.list: %v", err)
+	}
+	want := "APP=api\nORG=acme\nPATH=" + filepath.Join(workDir, "bin") + ":/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin\n"
+	if string(data) != want {
+		t.Errorf("env.list = %q, want %q", data, want)
+	}
+
+	var manifest struct {
+		Dir   string `json:"dir"`
+		Files []struct {
+			Path        string `json:"path"`
+			ContentHash string `json:"content_hash"`
+			Source      string `json:"source"`
+		} `json:"files"`
+		Variables []string `json:"variables"`
+	}
+	data, err = os.ReadFile(output + ".manifest.json")
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+
+	if manifest.Dir != workDir {
+		t.Errorf("manifest dir = %q, want %q", manifest.Dir, workDir)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("manifest has %d files, want 2", len(manifest.Files))
+	}
+	for i, dir := range []string{env.homeDir, workDir} {
+		f := manifest.Files[i]
+		if f.Path != filepath.Join(dir, ".envrc") || len(f.ContentHash) != 64 || f.Source != "global" {
+			t.Errorf("manifest file %d = %+v, want %s allowed globally", i, f, filepath.Join(dir, ".envrc"))
+		}
+	}
+	if strings.Join(manifest.Variables, ",") != "APP,ORG,PATH" {
+		t.Errorf("manifest variables = %v, want [APP ORG PATH]", manifest.Variables)
+	}
+}
+
+// TestIntegration_ExportContainerCheck tests drift detection against an
+// existing env-file.
+func TestIntegration_ExportContainerCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	env.createEnvrc(env.homeDir, `export APP="api"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	output := filepath.Join(env.homeDir, "env.list")
+	if _, stderr, err := env.run("export", "container", "--output", output); err != nil {
+		t.Fatalf("export container: %v\nstderr: %s", err, stderr)
+	}
+	if stdout, stderr, err := env.run("export", "container", "--output", output, "--check"); err != nil {
+		t.Fatalf("check (fresh): %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+
+	// Change the chain; the baked env-file is now stale
+	env.createEnvrc(env.homeDir, `export APP="web"
+export TOKEN="secret-value"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	stdout, _, err := env.run("export", "container", "--output", output, "--check")
+	if err == nil {
+		t.Fatal("expected check to fail on drift")
+	}
+	if !strings.Contains(stdout, "~ APP") || !strings.Contains(stdout, "+ TOKEN") {
+		t.Errorf("drift report = %q, want ~ APP and + TOKEN", stdout)
+	}
+	if strings.Contains(stdout, "secret-value") {
+		t.Error("drift report must not include values")
+	}
+}
+
+// TestIntegration_Lock tests writing a lockfile, verifying it, drift in
+// files and variables, and that export watches the lockfile.
+func TestIntegration_Lock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	env.createEnvrc(env.homeDir, `export ORG="acme"`)
+	env.createEnvrc(workDir, `export APP="api"`)
+	for _, dir := range []string{env.homeDir, workDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	if _, stderr, err := env.run("lock", workDir); err != nil {
+		t.Fatalf("lock: %v\nstderr: %s", err, stderr)
+	}
+
+	lockPath := filepath.Join(workDir, ".cascade.lock")
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatalf("read lockfile: %v", err)
+	}
+	var lock struct {
+		Files []struct {
+			Path string `json:"path"`
+			Hash string `json:"hash"`
+		} `json:"files"`
+		Variables []string          `json:"variables"`
+		Values    map[string]string `json:"values"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		t.Fatalf("parse lockfile: %v", err)
+	}
+	if len(lock.Files) != 2 || lock.Files[0].Path != "../.envrc" || lock.Files[1].Path != ".envrc" {
+		t.Errorf("lockfile files = %+v, want ../.envrc and .envrc", lock.Files)
+	}
+	if !strings.HasPrefix(lock.Files[1].Hash, "sha256:") {
+		t.Errorf("file hash = %q, want sha256: prefix", lock.Files[1].Hash)
+	}
+	if strings.Join(lock.Variables, ",") != "APP,ORG" {
+		t.Errorf("lockfile variables = %v, want [APP ORG]", lock.Variables)
+	}
+	if lock.Values != nil {
+		t.Error("lockfile has value hashes without --hash-values")
+	}
+	if strings.Contains(string(data), "acme") {
+		t.Error("lockfile must not contain values")
+	}
+
+	// A clean chain verifies
+	if stdout, stderr, err := env.run("lock", workDir, "--verify"); err != nil {
+		t.Fatalf("verify (clean): %v\nstdout: %s\nstderr: %s", err, stdout, stderr)
+	}
+
+	// Export watches the lockfile
+	stdout, _, err := env.withWorkDir(workDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if watches := parseExport(stdout)["CASCADE_WATCHES"]; !strings.Contains(decodeGzenv(t, watches), lockPath) {
+		t.Errorf("CASCADE_WATCHES does not include %s", lockPath)
+	}
+
+	// Drift: changed files, an added variable, and a removed one
+	env.createEnvrc(workDir, `export APP="api"
+export TOKEN="secret-value"`)
+	if err := env.runAllow(filepath.Join(workDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	env.createEnvrc(env.homeDir, `export OTHER="x"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	stdout, _, err = env.run("lock", workDir, "--verify")
+	if err == nil {
+		t.Fatal("expected verify to fail on drift")
+	}
+	for _, want := range []string{"~ file .envrc", "~ file ../.envrc", "+ variable TOKEN", "+ variable OTHER", "- variable ORG"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("drift report missing %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "secret-value") {
+		t.Error("drift report must not include values")
+	}
+
+	// Value hashes catch a changed value under the same name
+	if _, stderr, err := env.run("lock", workDir, "--hash-values"); err != nil {
+		t.Fatalf("lock --hash-values: %v\nstderr: %s", err, stderr)
+	}
+	env.createEnvrc(workDir, `export APP="web"
+export TOKEN="secret-value"`)
+	if err := env.runAllow(filepath.Join(workDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	stdout, _, err = env.run("lock", workDir, "--verify")
+	if err == nil {
+		t.Fatal("expected verify to fail on a changed value")
+	}
+	if !strings.Contains(stdout, "~ variable APP") || strings.Contains(stdout, "variable TOKEN") {
+		t.Errorf("drift report = %q, want only ~ variable APP among variables", stdout)
+	}
+}
+
+// TestIntegration_MergeVar tests list-merged variables across the chain,
+// with a skipped middle level, and their revert.
+func TestIntegration_MergeVar(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	apiDir := filepath.Join(workDir, "api")
+	env.createEnvrc(env.homeDir, `merge_var FEATURE_FLAGS ,
+export FEATURE_FLAGS="$FEATURE_FLAGS,a,b"`)
+	env.createEnvrc(workDir, `export FEATURE_FLAGS="$FEATURE_FLAGS,b,c"`)
+	env.createEnvrc(apiDir, `export FEATURE_FLAGS="$FEATURE_FLAGS,b,d,ambient"`)
+
+	// Leave the middle level not allowed
+	for _, dir := range []string{env.homeDir, apiDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	apiEnv := env.withWorkDir(apiDir).withEnv("FEATURE_FLAGS=ambient")
+	stdout, stderr, err := apiEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "FEATURE_FLAGS", "ambient,a,b,d")
+
+	// tree reports per-level contributions as components
+	treeOut, _, err := apiEnv.run("tree", "FEATURE_FLAGS", "--json")
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	var tree struct {
+		Levels []struct {
+			Dir       string `json:"dir"`
+			Variables []struct {
+				Name  string   `json:"name"`
+				Added []string `json:"added"`
+			} `json:"variables"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(treeOut), &tree); err != nil {
+		t.Fatalf("parse tree: %v", err)
+	}
+	for _, level := range tree.Levels {
+		if level.Dir == apiDir {
+			if len(level.Variables) != 1 || strings.Join(level.Variables[0].Added, ",") != "d" {
+				t.Errorf("api level variables = %+v, want FEATURE_FLAGS adding [d]", level.Variables)
+			}
+		}
+	}
+
+	// Leaving the chain restores the ambient value untouched
+	outside := filepath.Join(filepath.Dir(env.homeDir), "outside")
+	env.createDir(outside)
+	leaveEnv := env.withWorkDir(outside).withEnv(
+		"FEATURE_FLAGS="+exports["FEATURE_FLAGS"],
+		"CASCADE_DIFF="+exports["CASCADE_DIFF"],
+	)
+	stdout, stderr, err = leaveEnv.runExport()
+	if err != nil {
+		t.Fatalf("export (leave): %v\nstderr: %s", err, stderr)
+	}
+	assertExportContains(t, parseExport(stdout), "FEATURE_FLAGS", "ambient")
+}
+
+// TestIntegration_Bugreport tests that bugreport redacts secrets and only
+// includes .envrc contents when asked.
+func TestIntegration_Bugreport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	env.createEnvrc(env.homeDir, `export API_TOKEN="hunter2"
+export EDITOR_THEME="dark"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	stdout, stderr, err := env.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	loaded := env.withEnv("CASCADE_DIR="+env.homeDir, "CASCADE_DIFF="+parseExport(stdout)["CASCADE_DIFF"])
+
+	type report struct {
+		Version       string `json:"version"`
+		SchemaVersion int    `json:"schema_version"`
+		Dirs          struct {
+			Data string `json:"data"`
+		} `json:"dirs"`
+		Status struct {
+			Chain     []struct{ Path string } `json:"chain"`
+			Variables map[string]string       `json:"variables"`
+		} `json:"status"`
+		Envrcs []struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		} `json:"envrc_contents"`
+	}
+
+	stdout, stderr, err = loaded.run("bugreport")
+	if err != nil {
+		t.Fatalf("bugreport: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "hunter2") {
+		t.Errorf("bugreport leaked a secret:\n%s", stdout)
+	}
+	var r report
+	if err := json.Unmarshal([]byte(stdout), &r); err != nil {
+		t.Fatalf("parse bugreport: %v\n%s", err, stdout)
+	}
+	if r.Version == "" || r.SchemaVersion == 0 {
+		t.Errorf("version = %q, schema_version = %d, want both set", r.Version, r.SchemaVersion)
+	}
+	if r.Dirs.Data != filepath.Join(env.dataDir, "cascade") {
+		t.Errorf("dirs.data = %q, want %q", r.Dirs.Data, filepath.Join(env.dataDir, "cascade"))
+	}
+	if r.Status.Variables["API_TOKEN"] != "[redacted]" || r.Status.Variables["EDITOR_THEME"] != "dark" {
+		t.Errorf("variables = %v, want API_TOKEN redacted and EDITOR_THEME kept", r.Status.Variables)
+	}
+	if len(r.Status.Chain) != 1 || len(r.Envrcs) != 0 {
+		t.Errorf("chain = %v, envrc_contents = %v, want one entry and no contents", r.Status.Chain, r.Envrcs)
+	}
+
+	// Individual --json outputs carry the same header
+	stdout, _, err = loaded.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	if !strings.Contains(stdout, `"schema_version": 1`) {
+		t.Errorf("status --json missing schema_version:\n%s", stdout)
+	}
+
+	// Contents are opt-in, and written to a file when --output is given
+	out := filepath.Join(env.homeDir, "report.json")
+	if _, stderr, err := loaded.run("bugreport", "--include-envrc", "--output", out); err != nil {
+		t.Fatalf("bugreport --include-envrc: %v\nstderr: %s", err, stderr)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	r = report{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("parse report: %v", err)
+	}
+	if len(r.Envrcs) != 1 || !strings.Contains(r.Envrcs[0].Content, "EDITOR_THEME") {
+		t.Errorf("envrc_contents = %v, want the home .envrc", r.Envrcs)
+	}
+}
+
+// TestIntegration_SlowEnvrcWarning tests that export names the slowest
+// .envrc when the chain takes longer than slow_warning_ms, and that
+// --timings reports every level.
+func TestIntegration_SlowEnvrcWarning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	env.createEnvrc(env.homeDir, `export HOME_VAR=1`)
+	env.createEnvrc(workDir, "sleep 0.2\nexport WORK_VAR=1")
+	for _, dir := range []string{env.homeDir, workDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+	workEnv := env.withWorkDir(workDir)
+
+	_, stderr, err := workEnv.withEnv("CASCADE_CACHE_ENABLED=false", "CASCADE_SLOW_WARNING_MS=100").runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "cascade: ~/work/.envrc took ")
+	assertStderrContains(t, stderr, "(consider caching)")
+
+	_, stderr, err = workEnv.withEnv("CASCADE_CACHE_ENABLED=false", "CASCADE_SLOW_WARNING_MS=0").runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stderr, "consider caching") {
+		t.Errorf("slow_warning_ms = 0 still warned:\n%s", stderr)
+	}
+
+	_, stderr, err = workEnv.withEnv("CASCADE_CACHE_ENABLED=false").run("export", "--timings", "bash")
+	if err != nil {
+		t.Fatalf("export --timings: %v\nstderr: %s", err, stderr)
+	}
+	for _, want := range []string{" ~/.envrc\n", " ~/work/.envrc\n", " total\n"} {
+		assertStderrContains(t, stderr, want)
+	}
+
+	stdout, stderr, err := workEnv.run("tree", "--json", "--fresh")
+	if err != nil {
+		t.Fatalf("tree: %v\nstderr: %s", err, stderr)
+	}
+	var result struct {
+		Levels []struct {
+			Dir        string `json:"dir"`
+			DurationMS int64  `json:"duration_ms"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("parse tree: %v\n%s", err, stdout)
+	}
+	var durationMS int64
+	for _, level := range result.Levels {
+		if level.Dir == workDir {
+			durationMS = level.DurationMS
+		}
+	}
+	if durationMS < 200 {
+		t.Errorf("tree duration_ms for %s = %d, want at least 200\n%s", workDir, durationMS, stdout)
+	}
+}
+
+// TestIntegration_TreeReusesExportCache tests that tree reuses the levels
+// export just evaluated, and that --fresh evaluates them again.
+func TestIntegration_TreeReusesExportCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	counter := filepath.Join(env.homeDir, "evaluations")
+	env.createEnvrc(env.homeDir, `echo home >> "`+counter+`"
+export HOME_VAR="from_home"`)
+	env.createEnvrc(workDir, `echo work >> "`+counter+`"
+export WORK_VAR="$HOME_VAR/work"`)
+	for _, dir := range []string{env.homeDir, workDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	workEnv := env.withWorkDir(workDir)
+	if _, stderr, err := workEnv.runExport(); err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+
+	tree := func(args ...string) map[string]bool {
+		t.Helper()
+		stdout, stderr, err := workEnv.run(append([]string{"tree", "--json", "--profile"}, args...)...)
+		if err != nil {
+			t.Fatalf("tree: %v\nstderr: %s", err, stderr)
+		}
+		var result struct {
+			Levels []struct {
+				Dir       string `json:"dir"`
+				Cached    bool   `json:"cached"`
+				Variables []struct {
+					Name string `json:"name"`
+				} `json:"variables"`
+			} `json:"levels"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+			t.Fatalf("parse tree: %v\n%s", err, stdout)
+		}
+		cached := make(map[string]bool)
+		for _, level := range result.Levels {
+			cached[level.Dir] = level.Cached
+			if len(level.Variables) != 1 {
+				t.Errorf("%s variables = %v, want one", level.Dir, level.Variables)
+			}
+		}
+		return cached
+	}
+	evaluations := func() int {
+		t.Helper()
+		data, err := os.ReadFile(counter)
+		if err != nil {
+			t.Fatalf("read counter: %v", err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+
+	before := evaluations()
+	if cached := tree(); !cached[env.homeDir] || !cached[workDir] {
+		t.Errorf("cached = %v, want every level reused from export", cached)
+	}
+	if got := evaluations(); got != before {
+		t.Errorf("tree evaluated %d .envrc files, want 0", got-before)
+	}
+
+	// Editing the home .envrc misses it and everything below it
+	env.createEnvrc(env.homeDir, `echo home >> "`+counter+`"
+export HOME_VAR="edited"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("re-allow home: %v", err)
+	}
+	before = evaluations()
+	if cached := tree(); cached[env.homeDir] || cached[workDir] {
+		t.Errorf("cached = %v, want no level reused after an upstream edit", cached)
+	}
+	if got := evaluations(); got != before+2 {
+		t.Errorf("tree evaluated %d .envrc files, want 2", got-before)
+	}
+
+	// --fresh ignores the entries the previous tree just wrote
+	before = evaluations()
+	if cached := tree("--fresh"); cached[env.homeDir] || cached[workDir] {
+		t.Errorf("cached = %v, want none with --fresh", cached)
+	}
+	if got := evaluations(); got != before+2 {
+		t.Errorf("tree --fresh evaluated %d .envrc files, want 2", got-before)
+	}
+}
+
+// TestIntegration_RefreshFailuresTracked tests that export counts failed
+// evaluations of a chain that used to load, warns at the threshold, and
+// clears the count once the .envrc is fixed.
+func TestIntegration_RefreshFailuresTracked(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t).withEnv("API_TOKEN=hunter2-secret")
+	envrcPath := filepath.Join(env.homeDir, ".envrc")
+
+	refresh := func() (failures int, lastError string, refreshed bool) {
+		t.Helper()
+		stdout, _, err := env.run("status", "--json")
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		var result struct {
+			Refresh *struct {
+				LastRefreshed string `json:"last_refreshed"`
+				Failures      int    `json:"failures"`
+				LastError     string `json:"last_error"`
+			} `json:"refresh"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+			t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+		}
+		if result.Refresh == nil {
+			t.Fatalf("status has no refresh section:\n%s", stdout)
+		}
+		return result.Refresh.Failures, result.Refresh.LastError, result.Refresh.LastRefreshed != ""
+	}
+
+	env.createEnvrc(env.homeDir, `export GOOD=1`)
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if _, _, err := env.runExport(); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if failures, _, refreshed := refresh(); failures != 0 || !refreshed {
+		t.Fatalf("after a good load: failures = %d, refreshed = %v", failures, refreshed)
+	}
+
+	// Break the file; every export now fails
+	env.createEnvrc(env.homeDir, `echo "token $API_TOKEN rejected" >&2; exit 1`)
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		_, stderr, _ := env.runExport()
+		warned := strings.Contains(stderr, "environment is not refreshing")
+		if warned != (i == 3) {
+			t.Errorf("export %d: warned = %v, want warning only at the threshold\nstderr: %s", i, warned, stderr)
+		}
+		failures, lastError, refreshed := refresh()
+		if failures != i {
+			t.Errorf("export %d: failures = %d, want %d", i, failures, i)
+		}
+		if !refreshed {
+			t.Errorf("export %d: lost the last successful refresh time", i)
+		}
+		if strings.Contains(lastError, "hunter2-secret") {
+			t.Errorf("last error leaks the token: %q", lastError)
+		}
+	}
+
+	stdout, _, err := env.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "refresh failing") {
+		t.Errorf("status output missing the stale marker:\n%s", stdout)
+	}
+
+	// Fixing the file clears everything
+	env.createEnvrc(env.homeDir, `export GOOD=2`)
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if _, _, err := env.runExport(); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if failures, lastError, _ := refresh(); failures != 0 || lastError != "" {
+		t.Errorf("after fixing: failures = %d, last error = %q, want cleared", failures, lastError)
+	}
+}
+
+// TestIntegration_SkipMarker tests that a .cascade-skip marker leaves its
+// directory and everything below out of the chain while parents still load.
+func TestIntegration_SkipMarker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	generated := filepath.Join(env.homeDir, "repo", "generated")
+	workDir := filepath.Join(generated, "vendor", "pkg")
+
+	env.createEnvrc(env.homeDir, `export HOME_VAR="from_home"`)
+	env.createEnvrc(workDir, `export VENDORED="yes"`)
+	if err := os.WriteFile(filepath.Join(generated, ".cascade-skip"), nil, 0o644); err != nil {
+		t.Fatalf("write marker: %v", err)
+	}
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	workEnv := env.withWorkDir(workDir)
+	stdout, stderr, err := workEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "HOME_VAR", "from_home")
+	if _, ok := exports["VENDORED"]; ok {
+		t.Error("VENDORED exported from a skipped subtree")
+	}
+	if strings.Contains(stderr, "not allowed") {
+		t.Errorf("skipped .envrc reported as not allowed: %s", stderr)
+	}
+
+	// --show-ignored reveals the skipped .envrc
+	stdout, _, err = workEnv.run("tree", "--json", "--show-ignored")
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	var tree struct {
+		Skipped string `json:"skipped"`
+		Levels  []struct {
+			Path   string `json:"path"`
+			Exists bool   `json:"exists"`
+			Status string `json:"status"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &tree); err != nil {
+		t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+	}
+	if tree.Skipped != generated {
+		t.Errorf("skipped = %q, want %q", tree.Skipped, generated)
+	}
+	found := false
+	for _, level := range tree.Levels {
+		if level.Path == filepath.Join(workDir, ".envrc") {
+			found = level.Exists && level.Status == "skipped"
+		}
+	}
+	if !found {
+		t.Errorf("tree --show-ignored did not list the skipped .envrc: %s", stdout)
+	}
+}
+
+func TestIntegration_RecordLists(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	kept := filepath.Join(env.homeDir, "work", "kept")
+	edited := filepath.Join(env.homeDir, "work", "edited")
+	other := filepath.Join(env.homeDir, "other")
+
+	for _, dir := range []string{kept, edited, other} {
+		env.createEnvrc(dir, `export X="1"`)
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+	env.createEnvrc(edited, `export X="2"`)
+
+	listAllowed := func(args ...string) map[string]string {
+		t.Helper()
+		stdout, stderr, err := env.run(append([]string{"allow", "--list", "--json"}, args...)...)
+		if err != nil {
+			t.Fatalf("allow --list: %v\nstderr: %s", err, stderr)
+		}
+		var out struct {
+			Kind    string `json:"kind"`
+			Records []struct {
+				Path  string `json:"path"`
+				State string `json:"state"`
+			} `json:"records"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+			t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+		}
+		if out.Kind != "allow" {
+			t.Errorf("kind = %q, want allow", out.Kind)
+		}
+		states := make(map[string]string)
+		for _, r := range out.Records {
+			states[filepath.Base(filepath.Dir(r.Path))] = r.State
+		}
+		return states
+	}
+
+	states := listAllowed()
+	if len(states) != 3 || states["kept"] != "ok" || states["edited"] != "changed" || states["other"] != "ok" {
+		t.Errorf("allow --list states = %v", states)
+	}
+
+	states = listAllowed("--stale")
+	if len(states) != 1 || states["edited"] != "changed" {
+		t.Errorf("allow --list --stale states = %v, want only edited", states)
+	}
+
+	states = listAllowed("--under", filepath.Join(env.homeDir, "work"), "--sort", "date")
+	if len(states) != 2 || states["other"] != "" {
+		t.Errorf("allow --list --under states = %v, want kept and edited", states)
+	}
+
+	if _, _, err := env.run("allow", "--list", "--sort", "size"); err == nil {
+		t.Error("allow --list --sort size succeeded, want error")
+	}
+
+	// Deny and trust share the listing
+	if err := env.runDeny(filepath.Join(other, ".envrc")); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	stdout, _, err := env.run("deny", "--list")
+	if err != nil {
+		t.Fatalf("deny --list: %v", err)
+	}
+	if !strings.Contains(stdout, "DENIED ON") || !strings.Contains(stdout, "~/other/.envrc") {
+		t.Errorf("deny --list output:\n%s", stdout)
+	}
+
+	stdout, _, err = env.run("trust", "--list")
+	if err != nil {
+		t.Fatalf("trust --list: %v", err)
+	}
+	if !strings.Contains(stdout, "No trusted subtrees") {
+		t.Errorf("trust --list output:\n%s", stdout)
+	}
+}
+
+// TestIntegration_StatusWatch tests that status --watch --json writes one
+// JSON sample per line until interrupted, and exits cleanly on SIGINT.
+func TestIntegration_StatusWatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	env.createEnvrc(env.homeDir, `export HOME_VAR="from_home"`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := env.run("status", "--interval", "1s"); err == nil {
+		t.Error("status --interval without --watch succeeded")
+	}
+
+	cmd := exec.Command(env.binary, "status", "--watch", "--json", "--interval", "50ms") //nolint:gosec // intentional CLI test harness
+	cmd.Dir = env.homeDir
+	cmd.Env = env.baseEnv
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(stdout)
+	for i := range 2 {
+		var sample struct {
+			Chain []struct {
+				Path   string `json:"path"`
+				Status string `json:"status"`
+			} `json:"chain"`
+		}
+		if err := dec.Decode(&sample); err != nil {
+			_ = cmd.Process.Kill()
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if len(sample.Chain) != 1 || sample.Chain[0].Status != "allowed" {
+			t.Errorf("sample %d chain = %+v, want the allowed home .envrc", i, sample.Chain)
+		}
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("status --watch after SIGINT: %v", err)
+	}
+}
+
+func TestIntegration_StatusDivergence(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	env.createEnvrc(env.homeDir, `export HOME_VAR="from_home"`)
+	env.createEnvrc(workDir, `export WORK_VAR="from_work"`)
+	for _, dir := range []string{env.homeDir, workDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+	workEnv := env.withWorkDir(workDir)
+
+	divergence := func(e *testEnv) (source string, reasons map[string]string) {
+		t.Helper()
+		stdout, stderr, err := e.run("status", "--json")
+		if err != nil {
+			t.Fatalf("status: %v\nstderr: %s", err, stderr)
+		}
+		var status struct {
+			Divergence *struct {
+				Source    string `json:"source"`
+				Variables []struct {
+					Name   string `json:"name"`
+					Reason string `json:"reason"`
+				} `json:"variables"`
+			} `json:"divergence"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+			t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+		}
+		if status.Divergence == nil {
+			t.Fatalf("status has no divergence section: %s", stdout)
+		}
+		reasons = make(map[string]string)
+		for _, v := range status.Divergence.Variables {
+			reasons[v.Name] = v.Reason
+		}
+		return status.Divergence.Source, reasons
+	}
+
+	// No hook has ever run: the chain is evaluated to find what is expected
+	source, reasons := divergence(workEnv)
+	if source != "evaluation" || reasons["HOME_VAR"] != "missing" || reasons["WORK_VAR"] != "missing" {
+		t.Errorf("before export: source %q, divergence %v", source, reasons)
+	}
+
+	// Another shell ran the hook and saved state; this one still lacks it
+	if _, stderr, err := workEnv.runExport(); err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	source, reasons = divergence(workEnv)
+	if source != "state" || len(reasons) != 2 {
+		t.Errorf("after export: source %q, divergence %v", source, reasons)
+	}
+	stdout, _, err := workEnv.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "This shell is missing 2 variables the chain would set (hook not active here?)") {
+		t.Errorf("status output missing divergence summary:\n%s", stdout)
+	}
+
+	// A shell with a stale value
+	_, reasons = divergence(workEnv.withEnv("HOME_VAR=from_home", "WORK_VAR=old"))
+	if len(reasons) != 1 || reasons["WORK_VAR"] != "differs" {
+		t.Errorf("stale shell divergence = %v, want WORK_VAR differs", reasons)
+	}
+
+	// which --compare answers for a single variable
+	stdout, _, err = workEnv.run("which", "--compare", "--json", "WORK_VAR")
+	if err != nil {
+		t.Fatalf("which: %v", err)
+	}
+	var which struct {
+		Divergence *struct {
+			Reason string `json:"reason"`
+		} `json:"divergence"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &which); err != nil {
+		t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+	}
+	if which.Divergence == nil || which.Divergence.Reason != "missing" {
+		t.Errorf("which --compare divergence = %+v, want missing", which.Divergence)
+	}
+	stdout, _, err = workEnv.withEnv("WORK_VAR=from_work").run("which", "--compare", "--json", "WORK_VAR")
+	if err != nil {
+		t.Fatalf("which: %v", err)
+	}
+	if strings.Contains(stdout, `"divergence"`) {
+		t.Errorf("which --compare reported divergence for a matching shell: %s", stdout)
+	}
+}
+
+func TestIntegration_DirFlag(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	problem := filepath.Join(env.homeDir, "work", "problem")
+	outside := filepath.Join(filepath.Dir(env.homeDir), "elsewhere", "project")
+
+	env.createEnvrc(env.homeDir, `export HOME_VAR="from_home"`)
+	env.createEnvrc(problem, `export PROBLEM_VAR="from_problem"`)
+	env.createEnvrc(outside, `export OUTSIDE_VAR="from_outside"`)
+	for _, dir := range []string{env.homeDir, problem} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	treeLevels := func(args ...string) (root string, levels []string) {
+		t.Helper()
+		stdout, stderr, err := env.run(append([]string{"tree", "--json"}, args...)...)
+		if err != nil {
+			t.Fatalf("tree %v: %v\nstderr: %s", args, err, stderr)
+		}
+		var tree struct {
+			Root    string `json:"root"`
+			Current string `json:"current"`
+			Levels  []struct {
+				Path   string `json:"path"`
+				Exists bool   `json:"exists"`
+			} `json:"levels"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &tree); err != nil {
+			t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+		}
+		for _, level := range tree.Levels {
+			if level.Exists {
+				levels = append(levels, level.Path)
+			}
+		}
+		return tree.Root, levels
+	}
+
+	// Relative --dir resolves against the working directory (home)
+	_, levels := treeLevels("--dir", filepath.Join("work", "problem"))
+	if len(levels) != 2 || levels[1] != filepath.Join(problem, ".envrc") {
+		t.Errorf("tree --dir levels = %v, want home and problem", levels)
+	}
+
+	// Outside the cascade root the chain is just the directory itself
+	root, levels := treeLevels("--dir", outside)
+	if root != outside || len(levels) != 1 {
+		t.Errorf("tree --dir outside: root %q, levels %v", root, levels)
+	}
+
+	// which reports for the target, and --compare is refused there
+	stdout, _, err := env.run("which", "--dir", problem, "--json", "PROBLEM_VAR")
+	if err != nil {
+		t.Fatalf("which --dir: %v", err)
+	}
+	var which struct {
+		Target string `json:"target"`
+		SetBy  []struct {
+			Path string `json:"path"`
+		} `json:"set_by"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &which); err != nil {
+		t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+	}
+	if which.Target != problem || len(which.SetBy) != 1 || which.SetBy[0].Path != filepath.Join(problem, ".envrc") {
+		t.Errorf("which --dir output = %+v", which)
+	}
+	if _, stderr, err := env.run("which", "--dir", problem, "--compare", "PROBLEM_VAR"); err == nil {
+		t.Error("which --dir elsewhere --compare succeeded, want error")
+	} else {
+		assertStderrContains(t, stderr, "--compare")
+	}
+
+	// status keeps this shell apart from the target's chain
+	stdout, _, err = env.run("status", "--dir", problem, "--json")
+	if err != nil {
+		t.Fatalf("status --dir: %v", err)
+	}
+	var status struct {
+		Active bool   `json:"active"`
+		Target string `json:"target"`
+		Chain  []struct {
+			Path string `json:"path"`
+		} `json:"chain"`
+		Divergence json.RawMessage `json:"divergence"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+	}
+	if status.Target != problem || len(status.Chain) != 2 || status.Divergence != nil {
+		t.Errorf("status --dir = %+v", status)
+	}
+	stdout, _, err = env.run("status", "--dir", outside)
+	if err != nil {
+		t.Fatalf("status --dir outside: %v", err)
+	}
+	for _, want := range []string{"Cascade is not active in this shell", ".envrc chain for " + outside + ":", "not allowed"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("status --dir output missing %q:\n%s", want, stdout)
+		}
+	}
+
+	// check looks at DIR/.envrc
+	stdout, _, err = env.run("check", "--dir", problem)
+	if err != nil || !strings.Contains(stdout, "allowed: "+filepath.Join(problem, ".envrc")) {
+		t.Errorf("check --dir problem: %v\n%s", err, stdout)
+	}
+	if _, _, err := env.run("check", "--dir", outside); err == nil {
+		t.Error("check --dir on a not allowed .envrc succeeded")
+	}
+
+	// Bad targets fail early with a clean error
+	for arg, want := range map[string]string{
+		filepath.Join(env.homeDir, "missing"):        "no such directory",
+		filepath.Join(env.homeDir, ".envrc"):         "not a directory",
+		filepath.Join(env.homeDir, "missing", "sub"): "no such directory",
+	} {
+		for _, command := range [][]string{{"tree"}, {"status"}, {"which", "X"}, {"check"}} {
+			_, stderr, err := env.run(append(command, "--dir", arg)...)
+			if err == nil {
+				t.Errorf("%v --dir %s succeeded", command, arg)
+				continue
+			}
+			assertStderrContains(t, stderr, want)
+		}
+	}
+}
+
+// TestIntegration_EnvrcFmt tests fmt --check, --stdin, and --write with
+// and without --allow on an allowed file.
+func TestIntegration_EnvrcFmt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	unformatted := "export B=2\nexport A=1\n\n\nif true; then\nPATH_add bin\nfi\n"
+	formatted := "export A=1\nexport B=2\n\nif true; then\n  PATH_add bin\nfi\n"
+	envrcPath := filepath.Join(env.homeDir, ".envrc")
+	env.createEnvrc(env.homeDir, unformatted)
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	if stdout, _, err := env.run("envrc", "fmt"); err != nil || stdout != formatted {
+		t.Errorf("fmt = %q, %v; want %q", stdout, err, formatted)
+	}
+	if _, stderr, err := env.run("envrc", "fmt", "--check"); err == nil {
+		t.Error("fmt --check succeeded on an unformatted file")
+	} else {
+		assertStderrContains(t, stderr, "is not formatted")
+	}
+
+	// --write without --allow and without a terminal leaves the file unallowed
+	if _, stderr, err := env.run("envrc", "fmt", "--write"); err != nil {
+		t.Fatalf("fmt --write: %v\nstderr: %s", err, stderr)
+	} else {
+		assertStderrContains(t, stderr, "is no longer allowed")
+	}
+	data, err := os.ReadFile(envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != formatted {
+		t.Errorf(".envrc after --write = %q, want %q", data, formatted)
+	}
+	if _, _, err := env.run("envrc", "fmt", "--check"); err != nil {
+		t.Errorf("fmt --check after --write: %v", err)
+	}
+	_, stderr, _ := env.runExport()
+	assertStderrContains(t, stderr, "is not allowed")
+
+	// --write --allow keeps an allowed file allowed
+	env.createEnvrc(env.homeDir, unformatted)
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if stdout, stderr, err := env.run("envrc", "fmt", "--write", "--allow"); err != nil {
+		t.Fatalf("fmt --write --allow: %v\nstderr: %s", err, stderr)
+	} else if !strings.Contains(stdout, "allowed") {
+		t.Errorf("fmt --write --allow output = %q, want it to report re-allowing", stdout)
+	}
+	stdout, stderr, err := env.runExport()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	assertStderrNotContains(t, stderr, "is not allowed")
+	assertExportContains(t, parseExport(stdout), "A", "1")
+
+	// --stdin formats standard input
+	cmd := exec.Command(env.binary, "envrc", "fmt", "--stdin")
+	cmd.Env = env.baseEnv
+	cmd.Stdin = strings.NewReader(unformatted)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("fmt --stdin: %v", err)
+	}
+	if string(out) != formatted {
+		t.Errorf("fmt --stdin = %q, want %q", out, formatted)
+	}
+}
+
+// TestIntegration_BrokenConfig tests that a config file that fails to parse
+// is reported by commands that read the configuration and ignored by those
+// that do not.
+func TestIntegration_BrokenConfig(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("cache_enabled = [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"export", "bash"}, {"status"}, {"config"}, {"version", "--check"}} {
+		if _, stderr, err := env.run(args...); err == nil {
+			t.Errorf("%v succeeded with a broken config file", args)
+		} else {
+			assertStderrContains(t, stderr, "While parsing config")
+		}
+	}
+
+	for _, args := range [][]string{{"hook", "bash"}, {"version"}, {"dump", "json"}} {
+		if _, stderr, err := env.run(args...); err != nil {
+			t.Errorf("%v: %v\nstderr: %s", args, err, stderr)
+		}
+	}
+}
+
+// TestIntegration_RootEnvrcOptional verifies that a failing .envrc at the
+// cascade root is skipped with a warning by default, and aborts the chain
+// with root_envrc = "required".
+func TestIntegration_RootEnvrcOptional(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(env.homeDir, "export ROOT=yes\nexit 1\n")
+	env.createEnvrc(projectDir, "export PROJECT=yes\n")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	projectEnv := env.withWorkDir(projectDir)
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "PROJECT", "yes")
+	assertExportNotContains(t, exports, "ROOT")
+	assertStderrContains(t, stderr, "warning: "+filepath.Join(env.homeDir, ".envrc")+" failed")
+
+	// Leaving the project reverts what was applied
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createDir(otherDir)
+	stdout, _, _ = env.withWorkDir(otherDir).withApplied(exports).runExport()
+	assertExportUnsets(t, parseExport(stdout), "PROJECT")
+
+	stdout, stderr, _ = projectEnv.withEnv("CASCADE_ROOT_ENVRC=required").runExport()
+	exports = parseExport(stdout)
+	assertExportNotContains(t, exports, "PROJECT")
+	assertExportNotContains(t, exports, "ROOT")
+	assertStderrContains(t, stderr, "error evaluating "+filepath.Join(env.homeDir, ".envrc"))
+	assertStderrNotContains(t, stderr, "warning:")
+}
+
+// TestIntegration_SessionExportFile verifies that export writes the applied
+// variables to session_export_file, that session exec applies them, and
+// that leaving the directory removes the file.
+func TestIntegration_SessionExportFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	runtimeDir := filepath.Join(filepath.Dir(env.homeDir), "run")
+	env = env.withEnv(
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"CASCADE_SESSION_EXPORT_FILE=$XDG_RUNTIME_DIR/cascade/$CASCADE_DIR_HASH.env",
+	)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export PROJECT='two\nlines'\nunset REMOVED\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	projectEnv := env.withWorkDir(projectDir).withEnv("REMOVED=yes")
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+
+	path, _, err := projectEnv.run("session", "path")
+	if err != nil {
+		t.Fatalf("session path: %v", err)
+	}
+	path = strings.TrimSpace(path)
+	if filepath.Dir(path) != filepath.Join(runtimeDir, "cascade") {
+		t.Errorf("session file %s is not under %s", path, runtimeDir)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("session file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("session file mode = %o, want 600", perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := shell.ParseNull(data)
+	if err != nil {
+		t.Fatalf("parse session file: %v", err)
+	}
+	if v := vars["PROJECT"]; v == nil || *v != "two\nlines" {
+		t.Errorf("PROJECT in session file = %v, want set", v)
+	}
+	if v, ok := vars["REMOVED"]; !ok || v != nil {
+		t.Errorf("REMOVED in session file should be an unset entry")
+	}
+	if v := vars["CASCADE_DIR"]; v == nil || *v != projectDir {
+		t.Errorf("CASCADE_DIR in session file = %v, want %s", v, projectDir)
+	}
+
+	// A shell started from a subdirectory picks the environment up
+	subDir := filepath.Join(projectDir, "sub")
+	env.createDir(subDir)
+	out, _, err := projectEnv.withWorkDir(subDir).run("session", "exec", "--", "sh", "-c", `printf '%s|%s' "$PROJECT" "${REMOVED-unset}"`)
+	if err != nil {
+		t.Fatalf("session exec: %v", err)
+	}
+	if want := "two\nlines|unset"; out != want {
+		t.Errorf("session exec saw %q, want %q", out, want)
+	}
+
+	// Leaving the project reverts and removes the file
+	_, _, err = env.withEnv(
+		"CASCADE_DIFF="+exports["CASCADE_DIFF"],
+		"CASCADE_DIR="+exports["CASCADE_DIR"],
+	).runExport()
+	if err != nil {
+		t.Fatalf("export outside project: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("session file still exists after leaving: %v", err)
+	}
+	if _, _, err := projectEnv.run("session", "path"); err == nil {
+		t.Error("session path succeeded with no session file")
+	}
+}
+
+// TestIntegration_MaskSecrets tests that status, tree and which mask the
+// values of variables that look like secrets unless --show-secrets is given.
+func TestIntegration_MaskSecrets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	const secret = "wJalrXUtnFEMI/K7MDENG"
+	env.createEnvrc(env.homeDir, `export AWS_SECRET_ACCESS_KEY="`+secret+`"
+export EDITOR=nvim`)
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	stdout, stderr, err := env.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	shell := env.withApplied(parseExport(stdout))
+
+	for _, args := range [][]string{{"status"}, {"tree", "--values"}, {"which", "AWS_SECRET_ACCESS_KEY"}} {
+		stdout, _, err := shell.run(args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if strings.Contains(stdout, secret) || !strings.Contains(stdout, "wJ••••") {
+			t.Errorf("%v should mask the secret:\n%s", args, stdout)
+		}
+		if args[0] != "which" && !strings.Contains(stdout, "nvim") {
+			t.Errorf("%v should show EDITOR:\n%s", args, stdout)
+		}
+
+		stdout, _, err = shell.run(append(args, "--show-secrets")...)
+		if err != nil {
+			t.Fatalf("%v --show-secrets: %v", args, err)
+		}
+		if !strings.Contains(stdout, secret) {
+			t.Errorf("%v --show-secrets should show the secret:\n%s", args, stdout)
+		}
+	}
+
+	stdout, _, err = shell.run("tree", "--values", "--json")
+	if err != nil {
+		t.Fatalf("tree --json: %v", err)
+	}
+	if strings.Contains(stdout, secret) || !strings.Contains(stdout, `"masked": true`) {
+		t.Errorf("tree --json should mark the secret masked without its value:\n%s", stdout)
+	}
+	stdout, _, err = shell.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	var status struct {
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		t.Fatalf("parse status --json: %v\n%s", err, stdout)
+	}
+	if got, ok := status.Variables["AWS_SECRET_ACCESS_KEY"].(map[string]any); !ok || len(got) != 1 || got["masked"] != true {
+		t.Errorf("status --json AWS_SECRET_ACCESS_KEY = %v, want only masked: true", status.Variables["AWS_SECRET_ACCESS_KEY"])
+	}
+	if got := status.Variables["EDITOR"]; got != "nvim" {
+		t.Errorf("status --json EDITOR = %v, want nvim", got)
+	}
+	if strings.Contains(stdout, "wJ••••") {
+		t.Errorf("status --json should not carry the masked value:\n%s", stdout)
+	}
+}
+
+// TestIntegration_Diff tests comparing the environments of two directories.
+func TestIntegration_Diff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	apiDir := filepath.Join(env.homeDir, "api")
+	webDir := filepath.Join(env.homeDir, "web")
+	env.createEnvrc(env.homeDir, `export SHARED=1`)
+	env.createEnvrc(apiDir, "export DB=api\nexport API_ONLY=1\nPATH_add bin\nPATH_add tools")
+	env.createEnvrc(webDir, "export DB=web\nexport WEB_ONLY=1\nPATH_add tools")
+	for _, dir := range []string{env.homeDir, apiDir, webDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatalf("allow %s: %v", dir, err)
+		}
+	}
+
+	stdout, stderr, err := env.run("diff", apiDir, webDir, "--json")
+	if err != nil {
+		t.Fatalf("diff: %v\nstderr: %s", err, stderr)
+	}
+	var output struct {
+		OnlyA   map[string]string `json:"only_a"`
+		OnlyB   map[string]string `json:"only_b"`
+		Changed map[string]struct {
+			A     string   `json:"a"`
+			B     string   `json:"b"`
+			OnlyA []string `json:"only_a"`
+			OnlyB []string `json:"only_b"`
+		} `json:"changed"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("parse: %v\n%s", err, stdout)
+	}
+	if len(output.OnlyA) != 1 || output.OnlyA["API_ONLY"] != "1" {
+		t.Errorf("only_a = %v, want API_ONLY", output.OnlyA)
+	}
+	if len(output.OnlyB) != 1 || output.OnlyB["WEB_ONLY"] != "1" {
+		t.Errorf("only_b = %v, want WEB_ONLY", output.OnlyB)
+	}
+	if db := output.Changed["DB"]; db.A != "api" || db.B != "web" {
+		t.Errorf("changed DB = %+v, want api and web", db)
+	}
+	path := output.Changed["PATH"]
+	if !slices.Equal(path.OnlyA, []string{filepath.Join(apiDir, "tools"), filepath.Join(apiDir, "bin")}) || !slices.Equal(path.OnlyB, []string{filepath.Join(webDir, "tools")}) {
+		t.Errorf("changed PATH = only_a %v, only_b %v, want each side's own entries", path.OnlyA, path.OnlyB)
+	}
+	if _, ok := output.Changed["SHARED"]; ok {
+		t.Error("diff reports SHARED, which both chains set alike")
+	}
+
+	stdout, _, err = env.run("diff", apiDir, webDir)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	for _, want := range []string{"--- ~/api\n", "+++ ~/web\n", "- API_ONLY=1\n", "+ WEB_ONLY=1\n", "~ DB\n    - api\n    + web\n", "    - " + filepath.Join(apiDir, "bin") + "\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("diff output missing %q:\n%s", want, stdout)
+		}
+	}
+
+	// A directory outside the cascade root has a chain of its own
+	outside := filepath.Join(filepath.Dir(env.homeDir), "outside")
+	env.createDir(outside)
+	stdout, _, err = env.run("diff", outside, webDir, "--json")
+	if err != nil {
+		t.Fatalf("diff outside the root: %v", err)
+	}
+	output.OnlyA, output.OnlyB, output.Changed = nil, nil, nil
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("parse: %v\n%s", err, stdout)
+	}
+	if output.OnlyB["SHARED"] != "1" || output.OnlyB["DB"] != "web" {
+		t.Errorf("only_b = %v, want everything web's chain sets", output.OnlyB)
+	}
+
+	// A denied file stops the side it belongs to
+	if err := env.runDeny(filepath.Join(webDir, ".envrc")); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	_, stderr, err = env.run("diff", apiDir, webDir)
+	if err == nil {
+		t.Fatal("diff with a denied file succeeded")
+	}
+	assertStderrContains(t, stderr, "cannot evaluate "+webDir+": the chain contains denied files")
+}
+
+// TestIntegration_Exec tests that exec runs a command with a directory's
+// chain applied, passes its exit status through, and refuses denied files.
+func TestIntegration_Exec(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	appDir := filepath.Join(projectDir, "app")
+	env.createEnvrc(projectDir, "export PROJECT=yes\nexport SHARED=project\n")
+	env.createEnvrc(appDir, "export APP=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run from elsewhere; app/.envrc is not allowed yet
+	script := `printf '%s|%s|%s|%s' "$PROJECT" "${APP-unset}" "$SHARED" "$KEEP"; exit 3`
+	stdout, stderr, err := env.withEnv("KEEP=kept", "SHARED=shell").run("exec", appDir, "sh", "-c", script)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("exec error = %v, want exit status 3\nstderr: %s", err, stderr)
+	}
+	if want := "yes|unset|project|kept"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	assertStderrContains(t, stderr, filepath.Join(appDir, ".envrc")+" is not allowed")
+
+	_, stderr, err = env.run("exec", "--skip-not-allowed", appDir, "true")
+	if err != nil {
+		t.Fatalf("exec --skip-not-allowed: %v\nstderr: %s", err, stderr)
+	}
+	if stderr != "" {
+		t.Errorf("stderr = %q, want nothing with --skip-not-allowed", stderr)
+	}
+
+	// A denied file anywhere in the chain refuses to run the command
+	if err := env.runDeny(filepath.Join(appDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(env.homeDir, "ran")
+	_, stderr, err = env.run("exec", appDir, "touch", marker)
+	if err == nil {
+		t.Fatal("exec succeeded with a denied file in the chain")
+	}
+	assertStderrContains(t, stderr, "denied")
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("exec ran the command despite a denied file")
+	}
+}
+
+// TestIntegration_AllowAll tests that allow --all allows the rest of the
+// chain, leaves denied files alone unless asked, and needs --yes without a
+// terminal.
+func TestIntegration_AllowAll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	appDir := filepath.Join(projectDir, "app")
+	webDir := filepath.Join(appDir, "web")
+	env.createEnvrc(env.homeDir, "export HOME_VAR=yes\n")
+	env.createEnvrc(projectDir, "export PROJECT_VAR=yes\n")
+	env.createEnvrc(appDir, "export APP_VAR=yes\n")
+	env.createEnvrc(webDir, "export WEB_VAR=yes\n")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.runDeny(filepath.Join(appDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	web := env.withWorkDir(webDir)
+
+	// Without a terminal there is nobody to confirm
+	_, stderr, err := web.run("allow", "--all")
+	if err == nil {
+		t.Fatal("allow --all succeeded without a terminal or --yes")
+	}
+	assertStderrContains(t, stderr, "--yes")
+
+	stdout, stderr, err := web.run("allow", "--all", "--yes")
+	if err != nil {
+		t.Fatalf("allow --all --yes: %v\nstderr: %s", err, stderr)
+	}
+	for _, dir := range []string{projectDir, webDir} {
+		assertStderrContains(t, stdout, "cascade: allowed "+filepath.Join(dir, ".envrc"))
+	}
+	assertStderrNotContains(t, stdout, filepath.Join(env.homeDir, ".envrc"))
+	assertStderrContains(t, stdout, "allowed 2 files, skipped 1 denied")
+
+	// The denied file still blocks the chain
+	_, stderr, _ = web.runExport()
+	assertStderrContains(t, stderr, filepath.Join(appDir, ".envrc")+" is blocked")
+
+	stdout, stderr, err = web.run("allow", "--all", "--yes", "--include-denied")
+	if err != nil {
+		t.Fatalf("allow --all --include-denied: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stdout, "cascade: allowed 1 file\n")
+
+	stdout, stderr, err = web.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "APP_VAR", "yes")
+	assertExportContains(t, exports, "WEB_VAR", "yes")
+
+	if _, _, err := web.run("allow", "--all", "--recursive"); err == nil {
+		t.Error("allow --all --recursive succeeded")
+	}
+}
+
+// TestIntegration_AllowShowDiffOnly tests that allow --show-diff-only
+// prints what changed since a file was allowed and allows nothing.
+func TestIntegration_AllowShowDiffOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	path := filepath.Join(env.homeDir, ".envrc")
+	env.createEnvrc(env.homeDir, "export KEEP=1\nexport V=1\n")
+
+	// Never allowed: the whole file is new
+	stdout, _, err := env.run("allow", "--show-diff-only")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("allow --show-diff-only error = %v, want exit status 1", err)
+	}
+	for _, want := range []string{"--- /dev/null", "+++ " + path, "+export V=1"} {
+		assertStderrContains(t, stdout, want)
+	}
+
+	if err := env.runAllow(""); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = env.run("allow", "--show-diff-only")
+	if err != nil || stdout != "" {
+		t.Errorf("allow --show-diff-only on an allowed file = %q, %v, want no output", stdout, err)
+	}
+
+	env.createEnvrc(env.homeDir, "export KEEP=1\nexport V=2\n")
+	stdout, _, err = env.run("allow", "--show-diff-only")
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("allow --show-diff-only error = %v, want exit status 1", err)
+	}
+	for _, want := range []string{"--- " + path + " (last allowed)", " export KEEP=1", "-export V=1", "+export V=2"} {
+		assertStderrContains(t, stdout, want)
+	}
+	_, stderr, _ := env.runExport()
+	assertStderrContains(t, stderr, "not allowed")
+
+	// Without a terminal, allow does not ask
+	stdout, stderr, err = env.run("allow")
+	if err != nil {
+		t.Fatalf("allow: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stdout, "cascade: updated "+path)
+}
+
+// TestIntegration_CacheSkipsUnchangedLevels counts bash runs through a
+// wrapper to check that a prompt re-evaluates only the levels whose own
+// content or ancestors' output changed, whatever shell bookkeeping (OLDPWD,
+// SHLVL, cascade's state) changed in between.
+func TestIntegration_CacheSkipsUnchangedLevels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	env := setupTestEnv(t)
+	tmp := filepath.Dir(env.homeDir)
+	runs := filepath.Join(tmp, "bash-runs")
+	wrapper := filepath.Join(tmp, "bash-wrapper")
+	script := "#!/bin/sh\necho run >> '" + runs + "'\nexec '" + bash + "' \"$@\"\n"
+	if err := os.WriteFile(wrapper, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	countRuns := func() int {
+		t.Helper()
+		data, err := os.ReadFile(runs)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(runs)
+		return strings.Count(string(data), "run\n")
+	}
+
+	projectDir := filepath.Join(env.homeDir, "project")
+	appDir := filepath.Join(projectDir, "app")
+	env.createEnvrc(env.homeDir, "export HOME_VAR=1\n")
+	env.createEnvrc(projectDir, "export PROJECT_VAR=\"$HOME_VAR:2\"\n")
+	env.createEnvrc(appDir, "export APP_VAR=\"$PROJECT_VAR:3\"\n")
+	for _, dir := range []string{env.homeDir, projectDir, appDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shell := env.withWorkDir(appDir).withEnv("CASCADE_BASH_PATH="+wrapper, "SHLVL=1", "OLDPWD="+env.homeDir)
+
+	stdout, stderr, err := shell.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if n := countRuns(); n != 3 {
+		t.Errorf("first export ran bash %d times, want 3", n)
+	}
+
+	// The next prompt, in a shell with the export applied and its
+	// bookkeeping moved on
+	applied := parseExport(stdout)
+	next := func() *testEnv {
+		return shell.withApplied(applied).withEnv("SHLVL=2", "OLDPWD="+projectDir, "_=/usr/bin/ls")
+	}
+	stdout, stderr, err = next().runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if n := countRuns(); n != 0 {
+		t.Errorf("unchanged chain ran bash %d times, want 0", n)
+	}
+	assertExportContains(t, parseExport(stdout), "APP_VAR", "1:2:3")
+
+	// Editing the deepest level re-runs only it
+	env.createEnvrc(appDir, "export APP_VAR=\"$PROJECT_VAR:4\"\n")
+	if err := env.runAllow(filepath.Join(appDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = next().runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if n := countRuns(); n != 1 {
+		t.Errorf("export after editing the deepest level ran bash %d times, want 1", n)
+	}
+	assertExportContains(t, parseExport(stdout), "APP_VAR", "1:2:4")
+
+	// A level that is never cached (source_up) runs at every prompt, and
+	// its output names the working directory; the levels below it are
+	// still cached when the prompt moves to a subdirectory
+	env.createEnvrc(env.homeDir, "source_up_if_exists\nexport HOME_VAR=1\n")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, err := shell.runExport(); err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	// What it exports is unchanged, so the levels below are cached
+	if n := countRuns(); n != 1 {
+		t.Errorf("export after editing the root level ran bash %d times, want 1", n)
+	}
+	subDir := filepath.Join(appDir, "sub")
+	env.createDir(subDir)
+	stdout, stderr, err = shell.withWorkDir(subDir).withEnv("OLDPWD=" + appDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if n := countRuns(); n != 1 {
+		t.Errorf("export from a subdirectory ran bash %d times, want 1 (the uncached root level)", n)
+	}
+	assertExportContains(t, parseExport(stdout), "APP_VAR", "1:2:4")
+}
+
+// TestIntegration_SensitiveEnv verifies that a sensitive_env value reaches
+// the shell but is never written to disk during a load/unload cycle, and
+// that leaving the directory unsets it.
+func TestIntegration_SensitiveEnv(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	const secret = "hunter2-sekrit"
+	const token = "tok-sekrit-42"
+
+	env := setupTestEnv(t)
+	runtimeDir := filepath.Join(filepath.Dir(env.homeDir), "run")
+	env = env.withEnv(
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"CASCADE_SESSION_EXPORT_FILE=$XDG_RUNTIME_DIR/cascade/$CASCADE_DIR_HASH.env",
+	)
+
+	// Values are assembled at evaluation time so the .envrc files
+	// themselves do not contain them
+	projectDir := filepath.Join(env.homeDir, "project")
+	appDir := filepath.Join(projectDir, "app")
+	env.createEnvrc(projectDir, `sensitive_env DB_PASSWORD API_TOKEN
+export DB_PASSWORD="$(printf '%s-%s' hunter2 sekrit)"
+cache_output 1h API_TOKEN -- printf '%s-%s-%s' tok sekrit 42
+export APP=yes
+`)
+	env.createEnvrc(appDir, "export LEAF=yes\n")
+	for _, dir := range []string{projectDir, appDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appEnv := env.withWorkDir(appDir)
+	var exports map[string]string
+	for range 2 { // The second run would be served from the cache if allowed
+		stdout, stderr, err := appEnv.runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		exports = parseExport(stdout)
+	}
+	assertExportContains(t, exports, "DB_PASSWORD", secret)
+	assertExportContains(t, exports, "API_TOKEN", token)
+	assertExportContains(t, exports, "LEAF", "yes")
+
+	diff := decodeGzenv(t, exports["CASCADE_DIFF"])
+	if strings.Contains(diff, secret) || strings.Contains(diff, token) {
+		t.Errorf("CASCADE_DIFF contains a sensitive value: %s", diff)
+	}
+	if !strings.Contains(diff, "DB_PASSWORD") {
+		t.Errorf("CASCADE_DIFF does not record DB_PASSWORD by name: %s", diff)
+	}
+
+	inShell := appEnv.withApplied(exports)
+	stdout, _, err := inShell.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if strings.Contains(stdout, secret) || !strings.Contains(stdout, "[redacted]") {
+		t.Errorf("status does not redact DB_PASSWORD:\n%s", stdout)
+	}
+	stdout, _, err = inShell.run("which", "DB_PASSWORD")
+	if err != nil {
+		t.Fatalf("which: %v", err)
+	}
+	if strings.Contains(stdout, secret) || !strings.Contains(stdout, "project/.envrc") {
+		t.Errorf("which should name the file but not the value:\n%s", stdout)
+	}
+
+	// Leaving unsets the sensitive variables without knowing their values
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createDir(otherDir)
+	stdout, stderr, err := env.withWorkDir(otherDir).withEnv(
+		"CASCADE_DIFF="+exports["CASCADE_DIFF"],
+		"CASCADE_DIR="+exports["CASCADE_DIR"],
+		"DB_PASSWORD="+secret,
+		"API_TOKEN="+token,
+		"APP="+exports["APP"],
+	).runExport()
+	if err != nil {
+		t.Fatalf("export outside project: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportUnsets(t, exports, "DB_PASSWORD")
+	assertExportUnsets(t, exports, "API_TOKEN")
+	assertExportUnsets(t, exports, "APP")
+
+	for _, dir := range []string{env.homeDir, env.dataDir, runtimeDir} {
+		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(data, []byte(secret)) || bytes.Contains(data, []byte(token)) {
+				t.Errorf("%s contains a sensitive value", path)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("walk %s: %v", dir, err)
+		}
+	}
+}
+
+// BenchmarkExportNoop measures process startup for the common prompt case:
+// export in a directory with no .envrc and nothing to revert, with a config
+// file present so configuration loading is included.
+func BenchmarkExportNoop(b *testing.B) {
+	benchStartup(b, setupStartupBench(b), "export", "bash")
+}
+
+// BenchmarkHookStartup measures startup for a command that needs no
+// configuration.
+func BenchmarkHookStartup(b *testing.B) {
+	benchStartup(b, setupStartupBench(b), "hook", "bash")
+}
+
+// BenchmarkChain measures chain, which prompts run at every prompt, for a
+// chain of three files. It should stay under 5ms.
+func BenchmarkChain(b *testing.B) {
+	env := setupStartupBench(b)
+	dir := env.homeDir
+	for _, name := range []string{"work", "project", "sub"} {
+		dir = filepath.Join(dir, name)
+		env.createEnvrc(dir, "export LEVEL="+name+"\n")
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	benchStartup(b, env.withWorkDir(dir), "chain", "--format", "starship")
+}
+
+// benchStartup runs cascade with args once per iteration. Besides wall time
+// it reports the CPU time the process used (cpu-ns/op), which varies far
+// less from run to run than wall time when comparing builds.
+func benchStartup(b *testing.B, env *testEnv, args ...string) {
+	b.Helper()
+	var cpu time.Duration
+	for b.Loop() {
+		cmd := exec.Command(env.binary, args...) //nolint:gosec // intentional CLI test harness
+		cmd.Dir = env.workDir
+		cmd.Env = env.baseEnv
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			b.Fatalf("%s: %v\nstderr: %s", args[0], err, stderr.String())
+		}
+		cpu += cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+	b.ReportMetric(float64(cpu.Nanoseconds())/float64(b.N), "cpu-ns/op")
+}
+
+func setupStartupBench(b *testing.B) *testEnv {
+	b.Helper()
+	env := setupTestEnv(b)
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("log_env_diff = true\n"), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return env
+}
+
+// TestIntegration_StaleHookWarning verifies that export warns once per
+// session when the shell hook came from a release more than a patch version
+// away from the cascade on PATH.
+func TestIntegration_StaleHookWarning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	self, err := filepath.EvalSymlinks(env.binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const notice = "your shell hook was generated by cascade v"
+
+	hook, _, err := env.run("hook", "bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hook, "export CASCADE_HOOK_VERSION=") {
+		t.Errorf("hook does not export CASCADE_HOOK_VERSION:\n%s", hook)
+	}
+	hook, _, err = env.withEnv("CASCADE_HOOK_RESOLVE_PATH=true").run("hook", "bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hook, "unset CASCADE_HOOK_VERSION") || !strings.Contains(hook, "type -P cascade") {
+		t.Errorf("hook_resolve_path hook does not resolve cascade at runtime:\n%s", hook)
+	}
+
+	// The test binary is the cascade on PATH
+	binDir := t.TempDir()
+	if err := os.Symlink(env.binary, filepath.Join(binDir, "cascade")); err != nil {
+		t.Fatal(err)
+	}
+	onPath := env.withEnv("PATH=" + binDir + ":/usr/bin:/bin")
+
+	stdout, stderr, err := onPath.withEnv("CASCADE_HOOK_VERSION=0.0.1").runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, notice+"0.0.1")
+	assertStderrContains(t, stderr, `re-run eval "$(cascade hook bash)"`)
+	assertExportContains(t, parseExport(stdout), "CASCADE_HOOK_CHECKED", self)
+
+	// Once warned, the session stays quiet
+	_, stderr, err = onPath.withEnv("CASCADE_HOOK_VERSION=0.0.1", "CASCADE_HOOK_CHECKED="+self).runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStderrNotContains(t, stderr, notice)
+
+	// A patch-level difference is not worth a warning
+	stdout, stderr, err = onPath.withEnv("CASCADE_HOOK_VERSION=0.1.9").runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStderrNotContains(t, stderr, notice)
+	assertExportNotContains(t, parseExport(stdout), "CASCADE_HOOK_CHECKED")
+
+	// A different cascade on PATH is asked for its version, once
+	upgradeDir := t.TempDir()
+	upgraded := filepath.Join(upgradeDir, "cascade")
+	script := "#!/bin/sh\necho '{\"version\":\"0.4.0\",\"schema_version\":1}'\n"
+	if err := os.WriteFile(upgraded, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	upgradedPath := env.withEnv("PATH="+upgradeDir+":/usr/bin:/bin", "CASCADE_HOOK_VERSION=0.1.0")
+
+	stdout, stderr, err = upgradedPath.runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStderrContains(t, stderr, notice+"0.1.0 — restart your shell")
+	assertStderrContains(t, stderr, "to use v0.4.0")
+	assertExportContains(t, parseExport(stdout), "CASCADE_HOOK_CHECKED", upgraded)
+
+	_, stderr, err = upgradedPath.withEnv("CASCADE_HOOK_CHECKED=" + upgraded).runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStderrNotContains(t, stderr, notice)
+}
+
+// auditEntry is a record of cascade audit --json.
+type auditEntry struct {
+	Time    string `json:"time"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	Hash    string `json:"hash"`
+	Trigger string `json:"trigger"`
+	UID     int    `json:"uid"`
+	Content string `json:"content"`
+}
+
+// readAuditLog runs cascade audit --json with args and returns its records.
+func readAuditLog(t *testing.T, env *testEnv, args ...string) []auditEntry {
+	t.Helper()
+	stdout, stderr, err := env.run(append([]string{"audit", "--json"}, args...)...)
+	if err != nil {
+		t.Fatalf("audit: %v\nstderr: %s", err, stderr)
+	}
+	var result struct {
+		Records []auditEntry `json:"records"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("json.Unmarshal: %v\nstdout: %s", err, stdout)
+	}
+	return result.Records
+}
+
+// TestIntegration_AuditLog verifies that each command that changes the allow
+// store appends exactly one audit record, and that audit filters them.
+func TestIntegration_AuditLog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	envrcPath := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(projectDir, "export B=2\nexport A=1\n")
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createDir(otherDir)
+
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	env.createDir(configDir)
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("audit_keep_content = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		args    []string
+		op      string
+		path    string
+		trigger string
+	}{
+		{[]string{"allow", envrcPath}, "allow", envrcPath, "cascade allow"},
+		{[]string{"deny", envrcPath}, "deny", envrcPath, "cascade deny"},
+		{[]string{"allow", envrcPath}, "allow", envrcPath, "cascade allow"},
+		{[]string{"envrc", "fmt", "--write", "--allow", envrcPath}, "allow", envrcPath, "cascade envrc fmt (re-allow)"},
+		{[]string{"trust", otherDir}, "trust", otherDir, "cascade trust"},
+		{[]string{"trust", "--remove", otherDir}, "untrust", otherDir, "cascade trust --remove"},
+		{[]string{"allow", "--recursive", otherDir}, "trust", otherDir, "cascade allow --recursive"},
+		// Reading state changes nothing
+		{[]string{"status"}, "", "", ""},
+		{[]string{"allow", "--list"}, "", "", ""},
+		{[]string{"allow", "--print-hash", envrcPath}, "", "", ""},
+	}
+	want := 0
+	for _, step := range steps {
+		if _, stderr, err := env.run(step.args...); err != nil {
+			t.Fatalf("%v: %v\nstderr: %s", step.args, err, stderr)
+		}
+		records := readAuditLog(t, env)
+		if step.op != "" {
+			want++
+		}
+		if len(records) != want {
+			t.Fatalf("after %v: %d audit records, want %d: %+v", step.args, len(records), want, records)
+		}
+		if step.op == "" {
+			continue
+		}
+		got := records[want-1]
+		if got.Op != step.op || got.Path != step.path || got.Trigger != step.trigger || got.UID != os.Getuid() {
+			t.Errorf("%v recorded %+v", step.args, got)
+		}
+		if (step.op == "allow" || step.op == "deny") && got.Hash == "" {
+			t.Errorf("%v recorded no content hash", step.args)
+		}
+	}
+
+	// The first allow kept the original text, the re-allow the formatted one
+	records := readAuditLog(t, env, "--path", projectDir)
+	if len(records) != 4 {
+		t.Fatalf("--path %s: %d records, want 4", projectDir, len(records))
+	}
+	for i, content := range map[int]string{0: "export B=2\nexport A=1\n", 3: "export A=1\nexport B=2\n"} {
+		data, err := os.ReadFile(records[i].Content)
+		if err != nil || string(data) != content {
+			t.Errorf("record %d kept content %q, %v; want %q", i, data, err, content)
+		}
+	}
+	if records[1].Content != "" {
+		t.Errorf("deny record names kept content %q", records[1].Content)
+	}
+
+	// allow --print-hash prints the hash the last allow recorded
+	stdout, _, err := env.run("allow", "--print-hash", envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(stdout) != records[3].Hash {
+		t.Errorf("allow --print-hash = %q, want %s", stdout, records[3].Hash)
+	}
+
+	// A file under a trusted directory shows the trust records
+	if records := readAuditLog(t, env, "--path", filepath.Join(otherDir, ".envrc")); len(records) != 3 {
+		t.Errorf("--path under trusted dir: %d records, want 3", len(records))
+	}
+	for _, since := range []string{"1h", "30d"} {
+		if records := readAuditLog(t, env, "--since", since); len(records) != 7 {
+			t.Errorf("--since %s: %d records, want 7", since, len(records))
+		}
+	}
+	if records := readAuditLog(t, env, "--since", "2999-01-01"); len(records) != 0 {
+		t.Errorf("--since in the future: %d records, want 0", len(records))
+	}
+
+	stdout, _, err = env.run("audit", "--path", envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "~/project/.envrc (cascade deny)") {
+		t.Errorf("audit output missing deny record:\n%s", stdout)
+	}
+
+	info, err := os.Stat(filepath.Join(env.dataDir, "cascade", "audit", "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log mode = %o, want 600", perm)
+	}
+}
+
+// TestIntegration_IdempotentRecords walks allow, deny, and trust through
+// first-time, repeated, and content-changed runs, with and without
+// --check-mode, checking the status each reports and that only real
+// changes reach the audit log.
+func TestIntegration_IdempotentRecords(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	envrcPath := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(projectDir, "export A=1\n")
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createDir(otherDir)
+
+	steps := []struct {
+		args    []string
+		edit    string // New .envrc content written before the step
+		status  string
+		path    string
+		changed bool // Whether the step adds an audit record
+	}{
+		{args: []string{"allow", "--check-mode", envrcPath}, status: "would-allow", path: envrcPath},
+		{args: []string{"allow", envrcPath}, status: "allowed", path: envrcPath, changed: true},
+		{args: []string{"allow", envrcPath}, status: "unchanged", path: envrcPath},
+		{args: []string{"allow", "--check-mode", envrcPath}, status: "unchanged", path: envrcPath},
+		{args: []string{"allow", "--check-mode", envrcPath}, edit: "export A=2\n", status: "would-update", path: envrcPath},
+		{args: []string{"allow", envrcPath}, status: "updated", path: envrcPath, changed: true},
+		{args: []string{"deny", "--check-mode", envrcPath}, status: "would-deny", path: envrcPath},
+		{args: []string{"deny", envrcPath}, status: "denied", path: envrcPath, changed: true},
+		{args: []string{"deny", envrcPath}, status: "unchanged", path: envrcPath},
+		{args: []string{"trust", "--check-mode", otherDir}, status: "would-trust", path: otherDir},
+		{args: []string{"trust", otherDir}, status: "trusted", path: otherDir, changed: true},
+		{args: []string{"allow", "--recursive", otherDir}, status: "unchanged", path: otherDir},
+	}
+	records := 0
+	for _, step := range steps {
+		if step.edit != "" {
+			env.createEnvrc(projectDir, step.edit)
+		}
+
+		stdout, stderr, err := env.run(step.args...)
+		if err != nil {
+			t.Fatalf("%v: %v\nstderr: %s", step.args, err, stderr)
+		}
+		if want := "cascade: " + step.status + " " + step.path + "\n"; stdout != want {
+			t.Errorf("%v printed %q, want %q", step.args, stdout, want)
+		}
+
+		stdout, stderr, err = env.run(append(step.args[:1:1], append([]string{"--json"}, step.args[1:]...)...)...)
+		if err != nil {
+			t.Fatalf("%v --json: %v\nstderr: %s", step.args, err, stderr)
+		}
+		var output struct {
+			Changed bool   `json:"changed"`
+			Status  string `json:"status"`
+			Path    string `json:"path"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+			t.Fatalf("%v --json: %v\n%s", step.args, err, stdout)
+		}
+		// Run again, a step that made a change now finds it made
+		wantStatus, wantChanged := step.status, step.changed || strings.HasPrefix(step.status, "would-")
+		if step.changed {
+			wantStatus, wantChanged = "unchanged", false
+		}
+		if output.Status != wantStatus || output.Changed != wantChanged || output.Path != step.path {
+			t.Errorf("%v --json = %+v, want status %q, changed %v", step.args, output, wantStatus, wantChanged)
+		}
+
+		if step.changed {
+			records++
+		}
+		if got := readAuditLog(t, env); len(got) != records {
+			t.Fatalf("after %v: %d audit records, want %d: %+v", step.args, len(got), records, got)
+		}
+	}
+
+	if _, _, err := env.run("allow", "--check-mode", "--list"); err == nil {
+		t.Error("allow --check-mode --list succeeded")
+	}
+}
+
+// TestIntegration_SourceUpOutsideRoot tests that source_up in a project
+// outside the cascade root pulls in an ancestor only once it is allowed,
+// watches it, and shows it under the level that sourced it.
+func TestIntegration_SourceUpOutsideRoot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	srvDir := filepath.Join(filepath.Dir(env.homeDir), "srv")
+	projectDir := filepath.Join(srvDir, "project")
+	sharedRC := filepath.Join(srvDir, ".envrc")
+	projectRC := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(srvDir, "export SHARED=one\n")
+	env.createEnvrc(projectDir, "source_up\nexport PROJECT=yes\n")
+	if err := env.runAllow(projectRC); err != nil {
+		t.Fatal(err)
+	}
+
+	projectEnv := env.withWorkDir(projectDir)
+
+	// Not allowed: warned about and skipped, the project still loads
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "source_up: skipping "+sharedRC+": not allowed")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "PROJECT", "yes")
+	assertExportNotContains(t, exports, "SHARED")
+
+	if err := env.runAllow(sharedRC); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "SHARED", "one")
+
+	// A changed ancestor is picked up, not served from the cache
+	env.createEnvrc(srvDir, "export SHARED=two\n")
+	if err := env.runAllow(sharedRC); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "SHARED", "two")
+
+	loaded := projectEnv.withEnv(
+		"CASCADE_DIFF="+exports["CASCADE_DIFF"],
+		"CASCADE_DIR="+exports["CASCADE_DIR"],
+		"CASCADE_WATCHES="+exports["CASCADE_WATCHES"],
+	)
+	stdout, _, err = loaded.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	var status struct {
+		Chain []struct {
+			Path    string   `json:"path"`
+			Sourced []string `json:"sourced"`
+		} `json:"chain"`
+		Watches []struct {
+			Path  string `json:"path"`
+			Extra bool   `json:"extra"`
+		} `json:"watches"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		t.Fatalf("parse status: %v\n%s", err, stdout)
+	}
+	if len(status.Chain) != 1 || status.Chain[0].Path != projectRC ||
+		len(status.Chain[0].Sourced) != 1 || status.Chain[0].Sourced[0] != sharedRC {
+		t.Errorf("status chain = %+v, want %s sourcing %s", status.Chain, projectRC, sharedRC)
+	}
+	watched := false
+	for _, w := range status.Watches {
+		watched = watched || (w.Path == sharedRC && w.Extra)
+	}
+	if !watched {
+		t.Errorf("status watches = %+v, want %s as an extra watch", status.Watches, sharedRC)
+	}
+
+	stdout, _, err = projectEnv.run("tree", "--json")
+	if err != nil {
+		t.Fatalf("tree --json: %v", err)
+	}
+	var tree struct {
+		Levels []struct {
+			Path    string   `json:"path"`
+			Sourced []string `json:"sourced"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &tree); err != nil {
+		t.Fatalf("parse tree: %v\n%s", err, stdout)
+	}
+	if len(tree.Levels) != 1 || len(tree.Levels[0].Sourced) != 1 || tree.Levels[0].Sourced[0] != sharedRC {
+		t.Errorf("tree levels = %+v, want %s sourcing %s", tree.Levels, projectRC, sharedRC)
+	}
+
+	stdout, _, err = projectEnv.run("tree")
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	if !strings.Contains(stdout, "sources "+sharedRC) {
+		t.Errorf("tree output does not list the sourced ancestor:\n%s", stdout)
+	}
+}
+
+// TestIntegration_SourceUpSourcesSnapshot tests that source_up sources the
+// ancestor under its own name while it matches a copy of the approved
+// content, the copy when it does not (here because its line endings were
+// normalized), and removes the copy afterwards.
+func TestIntegration_SourceUpSourcesSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	srvDir := filepath.Join(filepath.Dir(env.homeDir), "srv")
+	projectDir := filepath.Join(srvDir, "project")
+	sharedRC := filepath.Join(srvDir, ".envrc")
+	env.createEnvrc(srvDir, "export SHARED=one\r\nexport SOURCED_AS=\"${BASH_SOURCE[0]}\"\r\n")
+	env.createEnvrc(projectDir, "source_up\n")
+	for _, rc := range []string{sharedRC, filepath.Join(projectDir, ".envrc")} {
+		if err := env.runAllow(rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "SHARED", "one")
+	sourced := exports["SOURCED_AS"]
+	if sourced == "" || sourced == sharedRC {
+		t.Errorf("SOURCED_AS = %q, want a snapshot of %s", sourced, sharedRC)
+	}
+	if _, err := os.Stat(sourced); !os.IsNotExist(err) {
+		t.Errorf("snapshot %s was not removed: %v", sourced, err)
+	}
+
+	// Unchanged by the copy, the ancestor is sourced under its own name
+	lf := "export SHARED=two\nexport SOURCED_AS=\"${BASH_SOURCE[0]}\"\n"
+	env.createEnvrc(srvDir, lf)
+	if err := env.runAllow(sharedRC); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "SHARED", "two")
+	assertExportContains(t, exports, "SOURCED_AS", sharedRC)
+
+	// The snapshot command copies only allowed, unchanged content
+	stdout, stderr, err = env.run("internal", "snapshot", sharedRC)
+	if err != nil {
+		t.Fatalf("internal snapshot: %v\nstderr: %s", err, stderr)
+	}
+	snapshot := strings.TrimSpace(stdout)
+	defer os.Remove(snapshot)
+	content, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != lf {
+		t.Errorf("snapshot content = %q, want %q", content, lf)
+	}
+
+	env.createEnvrc(srvDir, "export SHARED=three\n")
+	if _, _, err := env.run("internal", "snapshot", sharedRC); err == nil {
+		t.Error("internal snapshot of a changed file succeeded")
+	}
+}
+
+// TestIntegration_SourceUpStopsAtRoot tests that source_up inside the
+// cascade root neither sources a parent .envrc that is already a level of
+// the chain nor looks above the root.
+func TestIntegration_SourceUpStopsAtRoot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	above := filepath.Join(filepath.Dir(env.homeDir), ".above")
+	if err := os.WriteFile(above, []byte("export ABOVE=yes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env.createEnvrc(env.homeDir, "export ROOT_COUNT=$(( ${ROOT_COUNT:-0} + 1 ))\n")
+	env.createEnvrc(projectDir, "source_up\nsource_up_if_exists .above\nexport PROJECT=yes\n")
+	for _, dir := range []string{env.homeDir, projectDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := env.runAllow(above); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrNotContains(t, stderr, "error")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "ROOT_COUNT", "1")
+	assertExportContains(t, exports, "PROJECT", "yes")
+	assertExportNotContains(t, exports, "ABOVE")
+}
+
+// TestIntegration_SourceUpNotedOnce tests that a chain of migrated .envrc
+// files calling source_up loads each parent once and notes that source_up
+// is unnecessary once per export, not once per file.
+func TestIntegration_SourceUpNotedOnce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	appDir := filepath.Join(projectDir, "app")
+	env.createEnvrc(env.homeDir, "export PARENTS=home\n")
+	env.createEnvrc(projectDir, "source_up\nexport PARENTS=\"$PARENTS:project\"\n")
+	env.createEnvrc(appDir, "source_up_if_exists\nexport PARENTS=\"$PARENTS:app\"\n")
+	for _, dir := range []string{env.homeDir, projectDir, appDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, err := env.withWorkDir(appDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "PARENTS", "home:project:app")
+	assertExportNotContains(t, exports, "CASCADE_SOURCE_UP_NOTED")
+	if n := strings.Count(stderr, "is not needed"); n != 1 {
+		t.Errorf("stderr has %d notes about source_up, want 1:\n%s", n, stderr)
+	}
+	assertStderrContains(t, stderr, "note: source_up in "+projectDir+" is not needed")
+	assertStderrNotContains(t, stderr, "error")
+}
+
+// TestIntegration_Dotenv tests that dotenv exports a .env file without
+// expanding it and watches it, and that only dotenv fails when it is missing.
+func TestIntegration_Dotenv(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "dotenv\ndotenv_if_exists .env.local\nexport AFTER=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	dotenvFile := filepath.Join(projectDir, ".env")
+	content := "# settings\nexport GREETING=\"hello\\nworld\"\nRAW=$HOME/$(id)\nQUOTED='a \"b\"'\n"
+	if err := os.WriteFile(dotenvFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrNotContains(t, stderr, "dotenv")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "GREETING", "hello\nworld")
+	assertExportContains(t, exports, "RAW", "$HOME/$(id)")
+	assertExportContains(t, exports, "QUOTED", `a "b"`)
+	assertExportContains(t, exports, "AFTER", "yes")
+
+	watches := decodeGzenv(t, exports["CASCADE_WATCHES"])
+	for _, file := range []string{dotenvFile, dotenvFile + ".local"} {
+		if !strings.Contains(watches, file) {
+			t.Errorf("CASCADE_WATCHES does not watch %s: %s", file, watches)
+		}
+	}
+
+	if err := os.Remove(dotenvFile); err != nil {
+		t.Fatal(err)
+	}
+	_, stderr, _ = env.withWorkDir(projectDir).runExport()
+	assertStderrContains(t, stderr, "dotenv: "+dotenvFile+" not found")
+	assertStderrNotContains(t, stderr, "dotenv_if_exists")
+}
+
+// TestIntegration_WatchFileGlob tests that a quoted glob pattern passed to
+// watch_file watches the files it matches and their directory.
+func TestIntegration_WatchFileGlob(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	configDir := filepath.Join(projectDir, "config")
+	env.createDir(configDir)
+	env.createEnvrc(projectDir, "watch_file 'config/*.yaml'\nexport LOADED=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{filepath.Join(configDir, "app.yaml"), filepath.Join(configDir, "my db.yaml")}
+	for _, file := range files {
+		if err := os.WriteFile(file, []byte("x: 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "LOADED", "yes")
+
+	watches := decodeGzenv(t, exports["CASCADE_WATCHES"])
+	for _, path := range append(files, configDir) {
+		if !strings.Contains(watches, `"`+path+`"`) {
+			t.Errorf("CASCADE_WATCHES does not watch %s: %s", path, watches)
+		}
+	}
+	if strings.Contains(watches, "*.yaml") {
+		t.Errorf("CASCADE_WATCHES holds the pattern itself: %s", watches)
+	}
+}
+
+// TestIntegration_WatchDir tests that watch_dir records a directory watch,
+// which status labels and reports changed when a file is added to it.
+func TestIntegration_WatchDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	configDir := filepath.Join(projectDir, "config")
+	env.createDir(configDir)
+	env.createEnvrc(projectDir, "watch_dir config\nexport LOADED=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	projectEnv := env.withWorkDir(projectDir)
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "LOADED", "yes")
+	assertExportNotContains(t, exports, "CASCADE_WATCH_DIRS")
+
+	watches := decodeGzenv(t, exports["CASCADE_WATCHES"])
+	if !strings.Contains(watches, `"p":"`+configDir+`"`) || !strings.Contains(watches, `"d":true`) {
+		t.Errorf("CASCADE_WATCHES does not watch %s as a directory: %s", configDir, watches)
+	}
+
+	inShell := projectEnv.withApplied(exports)
+	stdout, _, err = inShell.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "~/project/config/ (directory - unchanged)") {
+		t.Errorf("status does not label the directory watch:\n%s", stdout)
+	}
+
+	if err := os.WriteFile(filepath.Join(configDir, "app.yaml"), []byte("x: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = inShell.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "~/project/config/ (directory - changed)") {
+		t.Errorf("status does not report the added file:\n%s", stdout)
+	}
+}
+
+// TestIntegration_ChainOutsideRoot tests that a directory outside the
+// cascade root gets only its own .envrc by default, and the .envrc files of
+// its ancestors too with chain_outside_root = "walk-up".
+func TestIntegration_ChainOutsideRoot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectsDir := filepath.Join(filepath.Dir(env.homeDir), "srv", "projects")
+	apiDir := filepath.Join(projectsDir, "api")
+	env.createEnvrc(projectsDir, "export TOP=yes\n")
+	env.createEnvrc(apiDir, "export LEAF=yes\n")
+	for _, dir := range []string{projectsDir, apiDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apiEnv := env.withWorkDir(apiDir)
+	stdout, stderr, err := apiEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "LEAF", "yes")
+	assertExportNotContains(t, exports, "TOP")
+
+	walkEnv := apiEnv.withEnv("CASCADE_CHAIN_OUTSIDE_ROOT=walk-up")
+	stdout, stderr, err = walkEnv.runExport()
+	if err != nil {
+		t.Fatalf("export with walk-up: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "LEAF", "yes")
+	assertExportContains(t, exports, "TOP", "yes")
+
+	stdout, _, err = walkEnv.withApplied(exports).runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, filepath.Join(projectsDir, ".envrc")) {
+		t.Errorf("status does not list %s/.envrc:\n%s", projectsDir, stdout)
+	}
+	stdout, _, err = walkEnv.run("which", "TOP")
+	if err != nil {
+		t.Fatalf("which: %v", err)
+	}
+	if !strings.Contains(stdout, filepath.Join(projectsDir, ".envrc")) {
+		t.Errorf("which TOP does not name %s/.envrc:\n%s", projectsDir, stdout)
+	}
+
+	// max_walk_depth bounds the walk
+	srvDir := filepath.Dir(projectsDir)
+	env.createEnvrc(srvDir, "export HIGH=yes\n")
+	if err := env.runAllow(filepath.Join(srvDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	for depth, wantHigh := range map[string]bool{"0": true, "1": false, "2": true} {
+		stdout, stderr, err = walkEnv.withEnv("CASCADE_MAX_WALK_DEPTH=" + depth).runExport()
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		exports = parseExport(stdout)
+		assertExportContains(t, exports, "TOP", "yes")
+		if _, ok := exports["HIGH"]; ok != wantHigh {
+			t.Errorf("max_walk_depth = %s: HIGH exported = %v, want %v", depth, ok, wantHigh)
+		}
+	}
+}
+
+// TestIntegration_StatusMissingHook tests that status points out a missing
+// hook when the chain has allowed files but the shell never ran export.
+func TestIntegration_StatusMissingHook(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export LOADED=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	projectEnv := env.withWorkDir(projectDir)
+
+	stdout, _, err := projectEnv.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "Hook not detected") || !strings.Contains(stdout, `Add to ~/.bash_profile: eval "$(cascade hook bash)"`) {
+		t.Errorf("status does not suggest installing the hook:\n%s", stdout)
+	}
+
+	stdout, _, err = projectEnv.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	var output struct {
+		HookDetected *bool `json:"hook_detected"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if output.HookDetected == nil || *output.HookDetected {
+		t.Errorf("hook_detected = %v, want false", output.HookDetected)
+	}
+
+	// Installed but not loaded yet
+	if err := os.WriteFile(filepath.Join(env.homeDir, ".bashrc"), []byte(`eval "$(cascade hook bash)"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = projectEnv.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "Hook not loaded in this shell") || !strings.Contains(stdout, "source ~/.bashrc") {
+		t.Errorf("status does not suggest restarting the shell:\n%s", stdout)
+	}
+
+	// A shell that ran the hook
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	stdout, _, err = projectEnv.withApplied(parseExport(stdout)).runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if strings.Contains(stdout, "Hook not") {
+		t.Errorf("status reports a missing hook in a shell that ran it:\n%s", stdout)
+	}
+}
+
+// TestIntegration_ConfigProfile tests that the [profiles] entry matching
+// the working directory applies, and that config shows it.
+func TestIntegration_ConfigProfile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	acmeDir := filepath.Join(env.homeDir, "clients", "acme")
+	env.createDir(acmeDir)
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	env.createDir(configDir)
+	config := "[profiles.acme]\nmatch_prefix = \"~/clients/acme\"\ncache_enabled = false\n"
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := env.withWorkDir(acmeDir).run("config")
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if !strings.Contains(stdout, "Profile: acme (match_prefix = ~/clients/acme)") || !strings.Contains(stdout, "cache_enabled = false") {
+		t.Errorf("config does not show the acme profile:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "Cache enabled: false") {
+		t.Errorf("config does not apply the acme profile:\n%s", stdout)
+	}
+
+	stdout, _, err = env.run("config", "--json")
+	if err != nil {
+		t.Fatalf("config --json: %v", err)
+	}
+	var output struct {
+		Profile      *struct{ Name string } `json:"profile"`
+		CacheEnabled bool                   `json:"cache_enabled"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if output.Profile != nil || !output.CacheEnabled {
+		t.Errorf("outside ~/clients/acme: profile = %+v, cache_enabled = %v, want none and true", output.Profile, output.CacheEnabled)
+	}
+}
+
+// TestIntegration_PendingApproval tests that a deeper file that is not
+// allowed leaves CASCADE_DIR at the allowed parent, is named by
+// CASCADE_PENDING, and is listed by status as pending approval.
+func TestIntegration_PendingApproval(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	subDir := filepath.Join(projectDir, "sub")
+	env.createEnvrc(projectDir, "export PARENT=yes\n")
+	env.createEnvrc(subDir, "export CHILD=yes\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	subEnv := env.withWorkDir(subDir)
+
+	stdout, stderr, err := subEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "PARENT", "yes")
+	assertExportNotContains(t, exports, "CHILD")
+	assertExportContains(t, exports, "CASCADE_DIR", projectDir)
+	assertExportContains(t, exports, "CASCADE_PENDING", filepath.Join(subDir, ".envrc"))
+
+	applied := subEnv.withApplied(exports)
+	stdout, _, err = applied.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "1 file pending approval:\n  ~/project/sub/.envrc\n") {
+		t.Errorf("status does not list the pending file:\n%s", stdout)
+	}
+
+	stdout, _, err = applied.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	var output struct {
+		Pending []string `json:"pending"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if want := []string{filepath.Join(subDir, ".envrc")}; !slices.Equal(output.Pending, want) {
+		t.Errorf("pending = %q, want %q", output.Pending, want)
+	}
+
+	// Once allowed, nothing is pending
+	if err := env.runAllow(filepath.Join(subDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = applied.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "CHILD", "yes")
+	assertExportContains(t, exports, "CASCADE_DIR", subDir)
+	assertExportUnsets(t, exports, "CASCADE_PENDING")
+}
+
+// TestIntegration_AllowDenyDirectory tests that allow and deny given a
+// directory act on the .envrc in it.
+func TestIntegration_AllowDenyDirectory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	subDir := filepath.Join(projectDir, "sub")
+	rcPath := filepath.Join(projectDir, ".envrc")
+	env.createEnvrc(projectDir, "export LOADED=yes\n")
+	env.createDir(subDir)
+	projectEnv := env.withWorkDir(projectDir)
+
+	// The most common invocation, from inside the project
+	stdout, stderr, err := projectEnv.run("allow", ".")
+	if err != nil {
+		t.Fatalf("allow .: %v\nstderr: %s", err, stderr)
+	}
+	if want := "cascade: allowed " + rcPath + "\n"; stdout != want {
+		t.Errorf("allow . printed %q, want %q", stdout, want)
+	}
+	if _, _, err := projectEnv.run("check", "--silent", rcPath); err != nil {
+		t.Errorf("check after allow .: %v", err)
+	}
+
+	// Relative paths and trailing slashes name the same file
+	for _, arg := range []string{"..", "../", filepath.Join("..", "sub", "..") + "/"} {
+		stdout, stderr, err := env.withWorkDir(subDir).run("allow", arg)
+		if err != nil {
+			t.Fatalf("allow %s: %v\nstderr: %s", arg, err, stderr)
+		}
+		if want := "cascade: unchanged " + rcPath + "\n"; stdout != want {
+			t.Errorf("allow %s printed %q, want %q", arg, stdout, want)
+		}
+	}
+
+	// A directory without an .envrc is an error naming it
+	_, stderr, err = env.withWorkDir(subDir).run("allow", ".")
+	if err == nil {
+		t.Fatal("allow . succeeded in a directory without an .envrc")
+	}
+	if !strings.Contains(stderr, "no .envrc in "+subDir) {
+		t.Errorf("allow . stderr = %q, want it to name %s", stderr, subDir)
+	}
+	if _, stderr, err := env.withWorkDir(subDir).run("deny", "."); err == nil || !strings.Contains(stderr, "no .envrc in "+subDir) {
+		t.Errorf("deny . in a directory without an .envrc: err = %v, stderr = %q", err, stderr)
+	}
+
+	stdout, stderr, err = env.run("deny", projectDir+"/")
+	if err != nil {
+		t.Fatalf("deny: %v\nstderr: %s", err, stderr)
+	}
+	if want := "cascade: denied " + rcPath + "\n"; stdout != want {
+		t.Errorf("deny printed %q, want %q", stdout, want)
+	}
+	stdout, _, _ = projectEnv.run("check", rcPath)
+	if !strings.HasPrefix(stdout, "denied: "+rcPath) {
+		t.Errorf("check after deny = %q, want denied", stdout)
+	}
+}
+
+// TestIntegration_Chain tests the status lines, starship summary and JSON
+// of chain, and that it creates nothing.
+func TestIntegration_Chain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	subDir := filepath.Join(projectDir, "sub")
+	env.createEnvrc(env.homeDir, "export ROOT=1\n")
+	env.createEnvrc(projectDir, "export PROJECT=1\n")
+	env.createEnvrc(subDir, "export SUB=1\n")
+	for _, dir := range []string{env.homeDir, projectDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subEnv := env.withWorkDir(subDir)
+
+	stdout, stderr, err := subEnv.run("chain")
+	if err != nil {
+		t.Fatalf("chain: %v\nstderr: %s", err, stderr)
+	}
+	want := "allowed\t" + filepath.Join(env.homeDir, ".envrc") + "\n" +
+		"allowed\t" + filepath.Join(projectDir, ".envrc") + "\n" +
+		"not allowed\t" + filepath.Join(subDir, ".envrc") + "\n"
+	if stdout != want {
+		t.Errorf("chain printed:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	if stdout, _, _ := subEnv.run("chain", "--format", "starship"); stdout != "⚡2 ⚠1\n" {
+		t.Errorf("chain --format starship = %q, want %q", stdout, "⚡2 ⚠1\n")
+	}
+	if _, stderr, err := env.run("deny", projectDir); err != nil {
+		t.Fatalf("deny: %v\nstderr: %s", err, stderr)
+	}
+	if stdout, _, _ := subEnv.run("chain", "--format", "starship"); stdout != "⚡1 ⚠1 ✗1\n" {
+		t.Errorf("chain --format starship after deny = %q, want %q", stdout, "⚡1 ⚠1 ✗1\n")
+	}
+
+	stdout, _, err = subEnv.run("chain", "--json")
+	if err != nil {
+		t.Fatalf("chain --json: %v", err)
+	}
+	var output struct {
+		Files []struct {
+			Path   string `json:"path"`
+			Status string `json:"status"`
+		} `json:"files"`
+		Allowed int `json:"allowed"`
+		Pending int `json:"pending"`
+		Denied  int `json:"denied"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if len(output.Files) != 3 || output.Allowed != 1 || output.Pending != 1 || output.Denied != 1 {
+		t.Errorf("chain --json = %+v, want 3 files: 1 allowed, 1 pending, 1 denied", output)
+	}
+
+	// Nothing is created, not even an empty store
+	fresh := filepath.Join(t.TempDir(), "data")
+	freshEnv := subEnv.withEnv("XDG_DATA_HOME=" + fresh)
+	if _, stderr, err := freshEnv.run("chain"); err != nil {
+		t.Fatalf("chain: %v\nstderr: %s", err, stderr)
+	}
+	for _, dir := range []string{fresh, filepath.Join(env.homeDir, ".cache"), filepath.Join(env.homeDir, ".local")} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("chain created %s", dir)
+		}
+	}
+	if stdout, _, _ := env.withWorkDir(t.TempDir()).run("chain", "--format", "starship"); stdout != "" {
+		t.Errorf("chain --format starship without an .envrc = %q, want nothing", stdout)
+	}
+}
+
+// TestIntegration_LogLevel tests that reminders about files that are not
+// allowed are printed once per directory per session, and that the log
+// level hides them or adds evaluation details.
+func TestIntegration_LogLevel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	subDir := filepath.Join(projectDir, "sub")
+	env.createEnvrc(projectDir, "export PROJECT=1\n")
+	env.createEnvrc(subDir, "export SUB=1\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	subEnv := env.withWorkDir(subDir)
+	const reminder = "sub/.envrc is not allowed"
+
+	stdout, stderr, err := subEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stderr, reminder) {
+		t.Errorf("first export does not remind about the file:\n%s", stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "CASCADE_NOTIFIED", subDir)
+
+	// The same session is not reminded again
+	applied := subEnv.withApplied(exports)
+	stdout, stderr, err = applied.run("export", "--force", "bash")
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stderr, reminder) {
+		t.Errorf("second export reminds about the file again:\n%s", stderr)
+	}
+	assertExportNotContains(t, parseExport(stdout), "CASCADE_NOTIFIED")
+
+	// Nor is a session that asked for warnings only
+	for _, tt := range []struct {
+		env  *testEnv
+		args []string
+	}{
+		{subEnv.withEnv("CASCADE_LOG_LEVEL=warn"), []string{"export", "bash"}},
+		{subEnv, []string{"--quiet", "export", "bash"}},
+	} {
+		stdout, stderr, err := tt.env.run(tt.args...)
+		if err != nil {
+			t.Fatalf("%v: %v\nstderr: %s", tt.args, err, stderr)
+		}
+		if strings.Contains(stderr, reminder) {
+			t.Errorf("%v reminds about the file:\n%s", tt.args, stderr)
+		}
+		assertExportNotContains(t, parseExport(stdout), "CASCADE_NOTIFIED")
+	}
+
+	// Once the file is allowed, its directory is forgotten
+	if err := env.runAllow(filepath.Join(subDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = applied.run("export", "--log-level", "debug", "bash")
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportUnsets(t, parseExport(stdout), "CASCADE_NOTIFIED")
+	wantDebug := "cascade: debug: " + filepath.Join(subDir, ".envrc") + ": running "
+	if !strings.Contains(stderr, wantDebug) || !strings.Contains(stderr, " -c ") || !strings.Contains(stderr, "bash finished in") {
+		t.Errorf("export --log-level debug does not show the bash invocation:\n%s", stderr)
+	}
+
+	if _, stderr, err := subEnv.run("--log-level", "loud", "export", "bash"); err == nil || !strings.Contains(stderr, "unknown log level") {
+		t.Errorf("--log-level loud: err = %v, stderr = %q", err, stderr)
+	}
+}
+
+// TestIntegration_UseRuntime tests that use_node and use_go find versions
+// installed by nvm and goenv, and that a missing version fails the .envrc.
+func TestIntegration_UseRuntime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	nvmVersions := filepath.Join(env.homeDir, ".nvm", "versions", "node")
+	goenvRoot := filepath.Join(env.homeDir, "goenv")
+	for _, dir := range []string{
+		filepath.Join(nvmVersions, "v18.17.0", "bin"),
+		filepath.Join(nvmVersions, "v18.19.1", "bin"),
+		filepath.Join(nvmVersions, "v20.5.0", "bin"),
+		filepath.Join(goenvRoot, "versions", "1.21.13", "bin"),
+		filepath.Join(goenvRoot, "versions", "1.22.1", "bin"),
+	} {
+		env.createDir(dir)
+	}
+	env = env.withEnv("GOENV_ROOT=" + goenvRoot)
+
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "use node 18\nuse_go 1.22\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	goRoot := filepath.Join(goenvRoot, "versions", "1.22.1")
+	assertExportContains(t, exports, "GOROOT", goRoot)
+	wantPath := filepath.Join(goRoot, "bin") + ":" + filepath.Join(nvmVersions, "v18.19.1", "bin") + ":"
+	if !strings.HasPrefix(exports["PATH"], wantPath) {
+		t.Errorf("PATH = %q, want prefix %q", exports["PATH"], wantPath)
+	}
+
+	missingDir := filepath.Join(env.homeDir, "missing")
+	env.createEnvrc(missingDir, "export BEFORE=yes\nuse_python 3.12\n")
+	if err := env.runAllow(filepath.Join(missingDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, _ = env.withWorkDir(missingDir).runExport()
+	assertStderrContains(t, stderr, `use_python: no python matching "3.12" installed (looked in ~/.pyenv/versions, `)
+	exports = parseExport(stdout)
+	assertExportNotContains(t, exports, "BEFORE")
+	assertExportNotContains(t, exports, "PATH")
+}
+
+// TestIntegration_TrustExportImport tests that trust export and import copy
+// allows, denies and trusted subtrees to another home directory, skipping
+// allows whose file differs there.
+func TestIntegration_TrustExportImport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	// Two machines with the same projects, one of them edited on the second
+	first, second := setupTestEnv(t), setupTestEnv(t)
+	for _, env := range []*testEnv{first, second} {
+		env.createEnvrc(filepath.Join(env.homeDir, "same"), "export SAME=1\n")
+		env.createEnvrc(filepath.Join(env.homeDir, "blocked"), "export BLOCKED=1\n")
+		env.createDir(filepath.Join(env.homeDir, "work"))
+	}
+	first.createEnvrc(filepath.Join(first.homeDir, "edited"), "export EDITED=1\n")
+	second.createEnvrc(filepath.Join(second.homeDir, "edited"), "export EDITED=2\n")
+	for _, dir := range []string{"same", "edited"} {
+		if err := first.runAllow(filepath.Join(first.homeDir, dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, stderr, err := first.run("deny", filepath.Join(first.homeDir, "blocked")); err != nil {
+		t.Fatalf("deny: %v\nstderr: %s", err, stderr)
+	}
+	if _, stderr, err := first.run("trust", filepath.Join(first.homeDir, "work")); err != nil {
+		t.Fatalf("trust: %v\nstderr: %s", err, stderr)
+	}
+
+	stdout, stderr, err := first.run("trust", "export")
+	if err != nil {
+		t.Fatalf("trust export: %v\nstderr: %s", err, stderr)
+	}
+	var doc struct {
+		Allows []struct {
+			Path string `json:"path"`
+			Hash string `json:"hash"`
+		} `json:"allows"`
+		Denies  []string `json:"denies"`
+		Trusted []string `json:"trusted"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &doc); err != nil {
+		t.Fatalf("parse export: %v\n%s", err, stdout)
+	}
+	if len(doc.Allows) != 2 || doc.Allows[0].Path != "~/edited/.envrc" || doc.Allows[1].Path != "~/same/.envrc" || doc.Allows[0].Hash == "" {
+		t.Errorf("allows = %+v, want ~/edited/.envrc and ~/same/.envrc with hashes", doc.Allows)
+	}
+	if !slices.Equal(doc.Denies, []string{"~/blocked/.envrc"}) || !slices.Equal(doc.Trusted, []string{"~/work"}) {
+		t.Errorf("denies = %q, trusted = %q", doc.Denies, doc.Trusted)
+	}
+	file := filepath.Join(second.homeDir, "trust.json")
+	if err := os.WriteFile(file, []byte(stdout), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sameRC := filepath.Join(second.homeDir, "same", ".envrc")
+	editedRC := filepath.Join(second.homeDir, "edited", ".envrc")
+	blockedRC := filepath.Join(second.homeDir, "blocked", ".envrc")
+	before := dataFileTimes(t, second.dataDir)
+	stdout, stderr, err = second.run("trust", "import", "--dry-run", file)
+	if err != nil {
+		t.Fatalf("trust import --dry-run: %v\nstderr: %s", err, stderr)
+	}
+	for _, want := range []string{
+		"cascade: would-trust " + filepath.Join(second.homeDir, "work") + "\n",
+		"cascade: would-allow " + sameRC + "\n",
+		"cascade: skipped " + editedRC + ": content differs",
+		"cascade: would-deny " + blockedRC + "\n",
+		"cascade: 3 changed, 0 unchanged, 1 skipped\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("dry run output missing %q:\n%s", want, stdout)
+		}
+	}
+	if after := dataFileTimes(t, second.dataDir); len(after) != len(before) {
+		t.Errorf("dry run wrote to the store: %d files before, %d after", len(before), len(after))
+	}
+
+	if stdout, stderr, err = second.run("trust", "import", file); err != nil {
+		t.Fatalf("trust import: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "cascade: allowed "+sameRC+"\n") {
+		t.Errorf("import output missing the allow:\n%s", stdout)
+	}
+	for rc, want := range map[string]string{sameRC: "allowed", editedRC: "not allowed", blockedRC: "denied"} {
+		stdout, _, _ := second.run("check", rc)
+		if got := strings.TrimSpace(stdout); got != want+": "+rc {
+			t.Errorf("check %s = %q, want %s", rc, got, want)
+		}
+	}
+	stdout, _, err = second.run("trust", "--list", "--json")
+	if err != nil || !strings.Contains(stdout, filepath.Join(second.homeDir, "work")) {
+		t.Errorf("trust --list = %q, %v; want ~/work trusted", stdout, err)
+	}
+
+	// Importing again changes nothing
+	stdout, stderr, err = second.run("trust", "import", file)
+	if err != nil {
+		t.Fatalf("trust import: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "cascade: 0 changed, 3 unchanged, 1 skipped\n") {
+		t.Errorf("second import output:\n%s", stdout)
+	}
+}
+
+// TestIntegration_PolicyFile tests that a policy file at the top of a
+// repository, once allowed itself, allows the files it pins without
+// cascade allow, but not one that changed since, and that status and tree
+// say so. A policy edited since it was allowed is ignored.
+func TestIntegration_PolicyFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	repo := filepath.Join(env.homeDir, "repo")
+	api := filepath.Join(repo, "api")
+	env.createEnvrc(repo, "export REPO=1\n")
+	env.createEnvrc(api, "export API=changed\n")
+	doc := "[[envrc]]\npath = \".envrc\"\nsha256 = \"" + policy.Hash([]byte("export REPO=1\n")) + "\"\n" +
+		"[[envrc]]\npath = \"api/.envrc\"\nsha256 = \"" + policy.Hash([]byte("export API=1\n")) + "\"\n"
+	if err := os.WriteFile(filepath.Join(repo, policy.FileName), []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	apiEnv := env.withWorkDir(api)
+	apiRC := filepath.Join(api, ".envrc")
+	policyFile := filepath.Join(repo, policy.FileName)
+
+	// A policy file is ignored until it is allowed itself
+	stdout, stderr, err := apiEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportNotContains(t, parseExport(stdout), "REPO")
+	stdout, _, err = apiEnv.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if want := "ignoring the policy file: " + policyFile + " is not allowed"; !strings.Contains(stdout, want) {
+		t.Errorf("status missing %q:\n%s", want, stdout)
+	}
+	if err := env.runAllow(policyFile); err != nil {
+		t.Fatalf("allow policy: %v", err)
+	}
+
+	stdout, stderr, err = apiEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportContains(t, parseExport(stdout), "REPO", "1")
+	assertExportNotContains(t, parseExport(stdout), "API")
+	assertStderrContains(t, stderr, "cascade: warning: "+apiRC+" differs from the version "+policyFile+" allows")
+
+	stdout, _, err = apiEnv.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, want := range []string{"~/repo/.envrc (allowed via policy)", "~/repo/api/.envrc (not allowed, differs from the policy)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("status missing %q:\n%s", want, stdout)
+		}
+	}
+	stdout, _, err = apiEnv.run("tree")
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	for _, want := range []string{"allowed (policy)", "not allowed (differs from the policy)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("tree missing %q:\n%s", want, stdout)
+		}
+	}
+
+	// Editing the policy, as a pull could, takes its allow away, even where
+	// export would take its fast path
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(filepath.Join(env.dataDir, "cascade", "allow"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	repoEnv := env.withWorkDir(repo).withEnv("CASCADE_EXPORT_FAST_PATH=true")
+	stdout, stderr, err = repoEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	loaded := repoEnv.withApplied(parseExport(stdout))
+	doc += "[[envrc]]\npath = \"api/.envrc\"\nsha256 = \"" + policy.Hash([]byte("export API=changed\n")) + "\"\n"
+	if err := os.WriteFile(policyFile, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(policyFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = loaded.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportUnsets(t, parseExport(stdout), "REPO")
+	stdout, stderr, err = apiEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportNotContains(t, parseExport(stdout), "API")
+
+	// An explicit deny outranks the policy
+	if err := env.runDeny(filepath.Join(repo, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, _ = apiEnv.run("check", filepath.Join(repo, ".envrc"))
+	if !strings.HasPrefix(stdout, "denied") {
+		t.Errorf("check after deny = %q, want denied", stdout)
+	}
+}
+
+// TestIntegration_StatusAddedEntries tests that status lists the entries
+// the chain added to PATH instead of the truncated value.
+func TestIntegration_StatusAddedEntries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createDir(filepath.Join(projectDir, "bin"))
+	env.createEnvrc(projectDir, "PATH_add bin\nexport NOTE="+strings.Repeat("x", 100)+"\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	projectEnv := env.withWorkDir(projectDir)
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	inShell := projectEnv.withApplied(parseExport(stdout))
+
+	stdout, _, err = inShell.runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, "PATH += ~/project/bin\n") || strings.Contains(stdout, strings.Repeat("x", 100)) {
+		t.Errorf("status should list the added entries and truncate NOTE:\n%s", stdout)
+	}
+
+	stdout, _, err = inShell.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status --json: %v", err)
+	}
+	var output struct {
+		Added map[string][]string `json:"added"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if want := []string{filepath.Join(projectDir, "bin")}; !slices.Equal(output.Added["PATH"], want) {
+		t.Errorf("added = %v, want PATH %v", output.Added, want)
+	}
+
+	// value_width = 0 never truncates
+	stdout, _, err = inShell.withEnv("CASCADE_VALUE_WIDTH=0").runStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(stdout, strings.Repeat("x", 100)) {
+		t.Errorf("value_width = 0 should not truncate NOTE:\n%s", stdout)
+	}
+}
+
+// TestIntegration_LayoutPython tests that layout python creates a venv
+// on the first evaluation, activates it, and reuses it afterwards.
+func TestIntegration_LayoutPython(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	if err := exec.Command(python, "-c", "import venv, ensurepip").Run(); err != nil {
+		t.Skip("python3 cannot create virtual environments")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "layout python\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	projectEnv := env.withWorkDir(projectDir).withEnv("CASCADE_CACHE_ENABLED=false")
+
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	venv := filepath.Join(projectDir, ".venv")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "VIRTUAL_ENV", venv)
+	if !strings.HasPrefix(exports["PATH"], filepath.Join(venv, "bin")+string(filepath.ListSeparator)) {
+		t.Errorf("PATH = %q, want the venv's bin first", exports["PATH"])
+	}
+	assertStderrContains(t, stderr, "layout python: creating "+venv)
+	cfgFile := filepath.Join(venv, "pyvenv.cfg")
+	if !strings.Contains(decodeGzenv(t, exports["CASCADE_WATCHES"]), cfgFile) {
+		t.Errorf("CASCADE_WATCHES does not include %s", cfgFile)
+	}
+	info, err := os.Stat(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second evaluation finds the venv and leaves it alone
+	stdout, stderr, err = projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("second export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportContains(t, parseExport(stdout), "VIRTUAL_ENV", venv)
+	if strings.Contains(stderr, "creating") {
+		t.Errorf("second export recreated the venv:\n%s", stderr)
+	}
+	if again, err := os.Stat(cfgFile); err != nil || !again.ModTime().Equal(info.ModTime()) {
+		t.Errorf("pyvenv.cfg was rewritten: %v", err)
+	}
+}
+
+// TestIntegration_DirenvCompat tests that direnv_compat mirrors CASCADE_DIR
+// and CASCADE_FILE as DIRENV_DIR and DIRENV_FILE, and unsets them on leave.
+func TestIntegration_DirenvCompat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	otherDir := filepath.Join(env.homeDir, "other")
+	env.createEnvrc(projectDir, "export PROJECT=1\n")
+	env.createDir(otherDir)
+	envrcPath := filepath.Join(projectDir, ".envrc")
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Off by default
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if _, ok := parseExport(stdout)["DIRENV_DIR"]; ok {
+		t.Errorf("DIRENV_DIR exported without direnv_compat:\n%s", stdout)
+	}
+
+	compat := env.withEnv("CASCADE_DIRENV_COMPAT=1")
+	stdout, stderr, err = compat.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "DIRENV_DIR", projectDir)
+	assertExportContains(t, exports, "DIRENV_FILE", envrcPath)
+	if _, ok := exports["DIRENV_DIFF"]; ok {
+		t.Error("DIRENV_DIFF should not be exported")
+	}
+	if diff := decodeGzenv(t, exports["CASCADE_DIFF"]); strings.Contains(diff, "DIRENV_") {
+		t.Errorf("CASCADE_DIFF tracks the mirrored variables: %s", diff)
+	}
+
+	// Leaving unsets them, even with direnv_compat turned off since
+	stdout, stderr, err = env.withWorkDir(otherDir).withApplied(exports).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	left := parseExport(stdout)
+	assertExportUnsets(t, left, "PROJECT")
+	assertExportUnsets(t, left, "DIRENV_DIR")
+	assertExportUnsets(t, left, "DIRENV_FILE")
+
+	// A real direnv's variables are left alone
+	stdout, stderr, err = env.withWorkDir(otherDir).withApplied(exports).withEnv("DIRENV_FILE=/elsewhere/.envrc").runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if _, ok := parseExport(stdout)["DIRENV_FILE"]; ok {
+		t.Errorf("direnv's DIRENV_FILE was touched:\n%s", stdout)
+	}
+}
+
+// TestIntegration_WhichAll tests that which --all attributes every
+// variable the chain set from a single evaluation, grouped by file.
+func TestIntegration_WhichAll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	apiDir := filepath.Join(projectDir, "api")
+	counter := filepath.Join(env.homeDir, "evaluations")
+	env.createDir(filepath.Join(projectDir, "bin"))
+	env.createEnvrc(projectDir, "echo x >> "+counter+"\nPATH_add bin\nexport SHARED=root\nexport API_TOKEN=hunter2\n")
+	env.createEnvrc(apiDir, "echo x >> "+counter+"\nexport SHARED=api\nexport API_ONLY=1\n")
+	for _, dir := range []string{projectDir, apiDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apiEnv := env.withWorkDir(apiDir).withEnv("CASCADE_CACHE_ENABLED=false")
+
+	stdout, stderr, err := apiEnv.run("which", "--all", "--json")
+	if err != nil {
+		t.Fatalf("which --all: %v\nstderr: %s", err, stderr)
+	}
+	if data, _ := os.ReadFile(counter); strings.Count(string(data), "x") != 2 {
+		t.Errorf("which --all evaluated %d files, want each of the 2 once", strings.Count(string(data), "x"))
+	}
+	type setBy struct {
+		Path  string   `json:"path"`
+		Added []string `json:"added"`
+	}
+	var output struct {
+		Variables map[string]struct {
+			Value  string  `json:"value"`
+			SetBy  []setBy `json:"set_by"`
+			Masked bool    `json:"masked"`
+		} `json:"variables"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, stdout)
+	}
+	if names := slices.Sorted(maps.Keys(output.Variables)); !slices.Equal(names, []string{"API_ONLY", "API_TOKEN", "PATH", "SHARED"}) {
+		t.Errorf("variables = %v, want API_ONLY, API_TOKEN, PATH, SHARED", names)
+	}
+	if shared := output.Variables["SHARED"]; len(shared.SetBy) != 2 || shared.Value != "api" {
+		t.Errorf("SHARED = %+v, want set by both files with value api", shared)
+	}
+	if path := output.Variables["PATH"]; len(path.SetBy) != 1 || !slices.Equal(path.SetBy[0].Added, []string{filepath.Join(projectDir, "bin")}) {
+		t.Errorf("PATH = %+v, want bin added by the project", path)
+	}
+	if token := output.Variables["API_TOKEN"]; !token.Masked || strings.Contains(stdout, "hunter2") {
+		t.Errorf("API_TOKEN should be masked: %+v", token)
+	}
+
+	stdout, stderr, err = apiEnv.run("which", "--all")
+	if err != nil {
+		t.Fatalf("which --all: %v\nstderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "hunter2") {
+		t.Errorf("which --all shows the API_TOKEN value:\n%s", stdout)
+	}
+	want := "  PATH (prepended)\n" +
+		"      + ~/project/bin\n" +
+		"  SHARED (base value)\n" +
+		"\n" +
+		"~/project/api/.envrc\n" +
+		"  API_ONLY (base value) = 1\n" +
+		"  SHARED (overrides) = api\n"
+	if !strings.Contains(stdout, want) {
+		t.Errorf("which --all output:\n%s\nwant it to contain:\n%s", stdout, want)
+	}
+
+	if _, _, err := apiEnv.run("which", "--all", "SHARED"); err == nil {
+		t.Error("which --all with a variable succeeded, want an error")
+	}
+}
+
+// TestIntegration_CaseVariantEnvrc tests that a file spelling .envrc in
+// another case is not loaded, and that export and doctor name it.
+func TestIntegration_CaseVariantEnvrc(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createDir(projectDir)
+	variant := filepath.Join(projectDir, ".Envrc")
+	if err := os.WriteFile(variant, []byte("export VARIANT=loaded\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	projectEnv := env.withWorkDir(projectDir)
+	stdout, stderr, err := projectEnv.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "found "+variant+" — cascade only loads .envrc; rename it")
+	assertExportNotContains(t, parseExport(stdout), "VARIANT")
+
+	stdout, _, err = projectEnv.run("doctor")
+	if err != nil {
+		t.Fatalf("doctor: %v\n%s", err, stdout)
+	}
+	if !strings.Contains(stdout, "Case variants: 1 file(s) in the chain spell .envrc in another case") || !strings.Contains(stdout, variant) {
+		t.Errorf("doctor does not report %s:\n%s", variant, stdout)
+	}
+}
+
+// requireShellsEnv lists shells (comma-separated, or "all") whose absence
+// fails the shell matrix tests instead of skipping them, so CI notices when
+// a runner loses zsh or fish.
+const requireShellsEnv = "CASCADE_TEST_REQUIRE_SHELLS"
+
+// matrixShells are the shells the hook is tested in.
+var matrixShells = []string{"bash", "zsh", "fish"}
+
+// forEachShell runs fn as a subtest for each shell in matrixShells that is
+// installed. Missing shells are skipped unless listed in requireShellsEnv.
+func forEachShell(t *testing.T, fn func(t *testing.T, sh *shellSession)) {
+	t.Helper()
+
+	required := strings.Split(os.Getenv(requireShellsEnv), ",")
+	for _, name := range matrixShells {
+		t.Run(name, func(t *testing.T) {
+			path, err := exec.LookPath(name)
+			if err != nil {
+				if slices.Contains(required, name) || slices.Contains(required, "all") {
+					t.Fatalf("%s is not installed, but %s requires it", name, requireShellsEnv)
+				}
+				t.Skipf("%s is not installed (set %s=all to make this an error)", name, requireShellsEnv)
+			}
+			fn(t, newShellSession(t, setupTestEnv(t), name, path))
+		})
+	}
+}
+
+// shellSession scripts a non-interactive shell that loads the cascade hook
+// the way a user's rc file does and then runs a sequence of directory
+// changes and prompt cycles. Variables are read back with printenv from
+// inside the shell, so they reflect what the shell actually exported.
+type shellSession struct {
+	t     *testing.T
+	env   *testEnv
+	name  string // bash, zsh or fish
+	path  string // Shell binary
+	out   string // Directory printenv writes snapshots to
+	lines []string
+	reads int
+}
+
+func newShellSession(t *testing.T, env *testEnv, name, path string) *shellSession {
+	s := &shellSession{t: t, env: env, name: name, path: path, out: t.TempDir()}
+	bin := s.quote(env.binary)
+	if name == "fish" {
+		s.lines = append(s.lines, bin+" hook fish | source")
+	} else {
+		s.lines = append(s.lines, `eval "$(`+bin+` hook `+name+`)"`)
+	}
+	s.prompt()
+	return s
+}
+
+// withEnv adds variables to the environment the shell starts with.
+func (s *shellSession) withEnv(extra ...string) *shellSession {
+	s.env = s.env.withEnv(extra...)
+	return s
+}
+
+// quote quotes a word for the session's shell.
+func (s *shellSession) quote(word string) string {
+	if s.name == "fish" {
+		return "'" + shell.FishEscape(word) + "'"
+	}
+	return shell.BashQuote(word)
+}
+
+// prompt runs what the shell runs before drawing a prompt.
+func (s *shellSession) prompt() {
+	switch s.name {
+	case "bash":
+		s.lines = append(s.lines, `for __cmd in "${PROMPT_COMMAND[@]}"; do eval "$__cmd"; done`)
+	case "zsh":
+		s.lines = append(s.lines, `for __fn in $precmd_functions; do $__fn; done`)
+	case "fish":
+		s.lines = append(s.lines, "emit fish_prompt")
+	}
+}
+
+// cd changes directory, firing the shell's own directory-change hooks, and
+// then runs a prompt cycle.
+func (s *shellSession) cd(dir string) {
+	s.lines = append(s.lines, "cd "+s.quote(dir))
+	s.prompt()
+}
+
+// cascade runs a cascade command inside the shell, as a user would at the
+// prompt, followed by the next prompt cycle.
+func (s *shellSession) cascade(args ...string) {
+	words := []string{s.quote(s.env.binary)}
+	for _, arg := range args {
+		words = append(words, s.quote(arg))
+	}
+	s.lines = append(s.lines, strings.Join(words, " "))
+	s.prompt()
+}
+
+// read records the current values of names and returns the index of the
+// snapshot in the result of run.
+func (s *shellSession) read(names ...string) int {
+	dir := filepath.Join(s.out, strconv.Itoa(s.reads))
+	s.lines = append(s.lines, "mkdir "+s.quote(dir))
+	for _, name := range names {
+		file := s.quote(filepath.Join(dir, name))
+		s.lines = append(s.lines, "printenv "+name+" > "+file+" || rm -f "+file)
+	}
+	s.reads++
+	return s.reads - 1
+}
+
+// run executes the session and returns each snapshot, mapping the names
+// read to their values; unset variables are absent. stderr is returned for
+// checking messages.
+func (s *shellSession) run() (snapshots []map[string]string, stderr string) {
+	t := s.t
+	t.Helper()
+
+	script := filepath.Join(t.TempDir(), "session."+s.name)
+	if err := os.WriteFile(script, []byte(strings.Join(s.lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	var args []string
+	switch s.name {
+	case "bash":
+		args = []string{"--norc", "--noprofile", script}
+	case "zsh":
+		args = []string{"-f", script}
+	case "fish":
+		args = []string{"--no-config", script}
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.path, args...) //nolint:gosec // intentional shell test harness
+	cmd.Dir = s.env.workDir
+	cmd.Env = append(append([]string{}, s.env.baseEnv...), "SHELL="+s.path)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%s session: %v\nstderr: %s\nscript:\n%s", s.name, err, stderrBuf.String(), strings.Join(s.lines, "\n"))
+	}
+
+	snapshots = make([]map[string]string, s.reads)
+	for i := range snapshots {
+		snapshots[i] = map[string]string{}
+		entries, err := os.ReadDir(filepath.Join(s.out, strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("read snapshot %d: %v\nstderr: %s", i, err, stderrBuf.String())
+		}
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(s.out, strconv.Itoa(i), entry.Name()))
+			if err != nil {
+				t.Fatalf("read snapshot %d: %v", i, err)
+			}
+			snapshots[i][entry.Name()] = strings.TrimSuffix(string(data), "\n")
+		}
+	}
+	return snapshots, stderrBuf.String()
+}
+
+// assertShellVar checks a variable read back from a shell session.
+func assertShellVar(t *testing.T, snapshot map[string]string, name, want string) {
+	t.Helper()
+	got, ok := snapshot[name]
+	if !ok {
+		t.Errorf("%s is unset, want %q", name, want)
+	} else if got != want {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+// assertShellUnset checks that a variable is unset in a shell session.
+func assertShellUnset(t *testing.T, snapshot map[string]string, name string) {
+	t.Helper()
+	if got, ok := snapshot[name]; ok {
+		t.Errorf("%s = %q, want unset", name, got)
+	}
+}
+
+// TestShellMatrix_Inheritance tests that a live shell picks up variables
+// from every level of the chain, with deeper levels winning.
+func TestShellMatrix_Inheritance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	forEachShell(t, func(t *testing.T, sh *shellSession) {
+		env := sh.env
+		project := filepath.Join(env.homeDir, "project")
+		env.createEnvrc(env.homeDir, "export ROOT_VAR=root\nexport SHARED=root")
+		env.createEnvrc(project, "export PROJECT_VAR=project\nexport SHARED=project\nPATH_add bin")
+		for _, dir := range []string{env.homeDir, project} {
+			if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+				t.Fatalf("allow: %v", err)
+			}
+		}
+
+		home := sh.read("ROOT_VAR", "PROJECT_VAR", "SHARED")
+		sh.cd(project)
+		inProject := sh.read("ROOT_VAR", "PROJECT_VAR", "SHARED", "PATH")
+		snapshots, stderr := sh.run()
+
+		assertShellVar(t, snapshots[home], "ROOT_VAR", "root")
+		assertShellVar(t, snapshots[home], "SHARED", "root")
+		assertShellUnset(t, snapshots[home], "PROJECT_VAR")
+
+		assertShellVar(t, snapshots[inProject], "ROOT_VAR", "root")
+		assertShellVar(t, snapshots[inProject], "PROJECT_VAR", "project")
+		assertShellVar(t, snapshots[inProject], "SHARED", "project")
+		if path := snapshots[inProject]["PATH"]; !strings.HasPrefix(path, filepath.Join(project, "bin")+":") {
+			t.Errorf("PATH = %q, want it to start with %s", path, filepath.Join(project, "bin"))
+		}
+		if t.Failed() {
+			t.Logf("stderr:\n%s", stderr)
+		}
+	})
+}
+
+// TestShellMatrix_ZshInstantPrompt tests that under powerlevel10k's
+// instant prompt the zsh hook skips the first prompt and evaluates at the
+// second.
+func TestShellMatrix_ZshInstantPrompt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	path, err := exec.LookPath("zsh")
+	if err != nil {
+		required := strings.Split(os.Getenv(requireShellsEnv), ",")
+		if slices.Contains(required, "zsh") || slices.Contains(required, "all") {
+			t.Fatalf("zsh is not installed, but %s requires it", requireShellsEnv)
+		}
+		t.Skipf("zsh is not installed (set %s=all to make this an error)", requireShellsEnv)
+	}
+	env := setupTestEnv(t)
+	env.createEnvrc(env.homeDir, "export ROOT_VAR=root")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+
+	sh := newShellSession(t, env.withEnv("P9K_INSTANT_PROMPT=1"), "zsh", path)
+	first := sh.read("ROOT_VAR")
+	sh.prompt()
+	second := sh.read("ROOT_VAR")
+	snapshots, stderr := sh.run()
+
+	assertShellUnset(t, snapshots[first], "ROOT_VAR")
+	assertShellVar(t, snapshots[second], "ROOT_VAR", "root")
+	if t.Failed() {
+		t.Logf("stderr:\n%s", stderr)
+	}
+}
+
+// TestShellMatrix_AllowDeny tests that allowing and denying an .envrc from
+// the prompt takes effect at the next prompt.
+func TestShellMatrix_AllowDeny(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	forEachShell(t, func(t *testing.T, sh *shellSession) {
+		env := sh.env
+		project := filepath.Join(env.homeDir, "project")
+		env.createEnvrc(project, "export PROJECT_VAR=project")
+		rcPath := filepath.Join(project, ".envrc")
+
+		sh.cd(project)
+		notAllowed := sh.read("PROJECT_VAR")
+		sh.cascade("allow", rcPath)
+		allowed := sh.read("PROJECT_VAR")
+		sh.cascade("deny", rcPath)
+		denied := sh.read("PROJECT_VAR")
+		snapshots, stderr := sh.run()
+
+		assertShellUnset(t, snapshots[notAllowed], "PROJECT_VAR")
+		assertShellVar(t, snapshots[allowed], "PROJECT_VAR", "project")
+		assertShellUnset(t, snapshots[denied], "PROJECT_VAR")
+		for _, want := range []string{"is not allowed", "is blocked"} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+			}
+		}
+	})
+}
+
+// TestShellMatrix_CdOutReverts tests that leaving a directory restores
+// the variables its .envrc set or overrode.
+func TestShellMatrix_CdOutReverts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	forEachShell(t, func(t *testing.T, sh *shellSession) {
+		env := sh.withEnv("EDITOR=vi").env
+		project := filepath.Join(env.homeDir, "project")
+		other := filepath.Join(env.homeDir, "other")
+		env.createEnvrc(project, "export PROJECT_VAR=project\nexport EDITOR=nano")
+		env.createDir(other)
+		if err := env.runAllow(filepath.Join(project, ".envrc")); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+
+		sh.cd(project)
+		inside := sh.read("PROJECT_VAR", "EDITOR")
+		sh.cd(other)
+		outside := sh.read("PROJECT_VAR", "EDITOR", "CASCADE_DIFF")
+		snapshots, stderr := sh.run()
+
+		assertShellVar(t, snapshots[inside], "PROJECT_VAR", "project")
+		assertShellVar(t, snapshots[inside], "EDITOR", "nano")
+		assertShellUnset(t, snapshots[outside], "PROJECT_VAR")
+		assertShellVar(t, snapshots[outside], "EDITOR", "vi")
+		assertShellUnset(t, snapshots[outside], "CASCADE_DIFF")
+		if t.Failed() {
+			t.Logf("stderr:\n%s", stderr)
+		}
+	})
+}
+
+// TestShellMatrix_SpecialCharacters tests that values survive each shell's
+// quoting intact.
+func TestShellMatrix_SpecialCharacters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	values := map[string]string{
+		"SINGLE_QUOTE": "it's",
+		"DOUBLE_QUOTE": `say "hi"`,
+		"DOLLAR":       "$HOME and ${PATH} and $(id)",
+		"BACKSLASH":    `C:\Users\n\t`,
+		"NEWLINE":      "line1\nline2",
+		"TAB":          "a\tb",
+		"UNICODE":      "naïve ✓ 日本",
+		"OPERATORS":    "a;b|c&d<e>f",
+		"GLOB":         "*.go ?[ab]",
+		"SPACES":       "  padded  ",
+		"COLONS":       "a:b::c",
+	}
+
+	forEachShell(t, func(t *testing.T, sh *shellSession) {
+		env := sh.env
+		project := filepath.Join(env.homeDir, "project")
+		var content strings.Builder
+		for name, value := range values {
+			content.WriteString("export " + name + "=" + shell.BashQuote(value) + "\n")
+		}
+		env.createEnvrc(project, content.String())
+		if err := env.runAllow(filepath.Join(project, ".envrc")); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sh.cd(project)
+		inside := sh.read(names...)
+		sh.cd(env.homeDir)
+		outside := sh.read(names...)
+		snapshots, stderr := sh.run()
+
+		for name, want := range values {
+			assertShellVar(t, snapshots[inside], name, want)
+			assertShellUnset(t, snapshots[outside], name)
+		}
+		if t.Failed() {
+			t.Logf("stderr:\n%s", stderr)
+		}
+	})
+}
+
+// TestIntegration_NegativeCache verifies that export in a directory with no
+// .envrc in its chain records a negative cache, returns without output
+// while it holds, and notices an .envrc created in a parent directory.
+func TestIntegration_NegativeCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	parent := filepath.Join(env.homeDir, "a")
+	leaf := filepath.Join(parent, "b")
+	env.createDir(leaf)
+
+	// Directories changed within the last couple of seconds are not relied on
+	past := time.Now().Add(-time.Hour)
+	for _, dir := range []string{env.homeDir, parent, leaf} {
+		if err := os.Chtimes(dir, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr, err := env.withWorkDir(leaf).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	negCache := parseExport(stdout)["CASCADE_NEGCACHE"]
+	if negCache == "" {
+		t.Fatalf("export did not record CASCADE_NEGCACHE:\n%s", stdout)
+	}
+
+	cached := env.withEnv("CASCADE_NEGCACHE=" + negCache)
+	stdout, _, err = cached.withWorkDir(leaf).runExport()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if stdout != "" {
+		t.Errorf("export with a valid negative cache printed %q, want nothing", stdout)
+	}
+
+	// After cd, the cache is recorded again for the new directory
+	stdout, _, err = cached.withWorkDir(parent).runExport()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if got := parseExport(stdout)["CASCADE_NEGCACHE"]; got == "" || got == negCache {
+		t.Errorf("export after cd: CASCADE_NEGCACHE = %q, want a new cache", got)
+	}
+
+	// An .envrc in a parent changes the parent's mtime
+	env.createEnvrc(parent, "export FROM_PARENT=yes\n")
+	if err := env.runAllow(filepath.Join(parent, ".envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	stdout, stderr, err = cached.withWorkDir(leaf).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "FROM_PARENT", "yes")
+	assertExportUnsets(t, exports, "CASCADE_NEGCACHE")
+}
+
+// TestIntegration_EnvrcFragments verifies that a directory's .envrc and
+// envrc.d fragments are evaluated in lexical order, each allowed on its
+// own, and that tree numbers them.
+func TestIntegration_EnvrcFragments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	project := filepath.Join(env.homeDir, "project")
+	fragments := filepath.Join(project, "envrc.d")
+	env.createEnvrc(project, "export ORDER=base\n")
+	env.createDir(fragments)
+	files := map[string]string{
+		"20-secrets.envrc": "export ORDER=\"$ORDER:secrets\"\n",
+		"10-base.envrc":    "export ORDER=\"$ORDER:fragment-base\"\nexport FRAGMENT_DIR=\"$CASCADE_DIR\"\n",
+		"30-local.envrc":   "export ORDER=\"$ORDER:local\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(fragments, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(project, ".envrc"),
+		filepath.Join(fragments, "10-base.envrc"),
+		filepath.Join(fragments, "30-local.envrc"),
+	} {
+		if err := env.runAllow(path); err != nil {
+			t.Fatalf("allow %s: %v", path, err)
+		}
+	}
+
+	// 20-secrets.envrc is not allowed yet, so it is skipped on its own
+	stdout, stderr, err := env.withWorkDir(project).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "ORDER", "base:fragment-base:local")
+	assertExportContains(t, exports, "FRAGMENT_DIR", project)
+	assertStderrContains(t, stderr, filepath.Join(fragments, "20-secrets.envrc")+" is not allowed")
+
+	if err := env.runAllow(filepath.Join(fragments, "20-secrets.envrc")); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	stdout, stderr, err = env.withWorkDir(project).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "ORDER", "base:fragment-base:secrets:local")
+
+	watches := decodeGzenv(t, exports["CASCADE_WATCHES"])
+	for name := range files {
+		if !strings.Contains(watches, filepath.Join(fragments, name)) {
+			t.Errorf("CASCADE_WATCHES does not watch %s: %s", name, watches)
+		}
+	}
+
+	stdout, _, err = env.withWorkDir(project).run("tree")
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	for _, want := range []string{"1. .envrc", "2. envrc.d/10-base.envrc", "3. envrc.d/20-secrets.envrc", "4. envrc.d/30-local.envrc"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("tree output missing %q:\n%s", want, stdout)
+		}
+	}
+	if n := strings.Count(stdout, "~/project"); n != 1 {
+		t.Errorf("tree prints the project directory %d times, want once:\n%s", n, stdout)
+	}
+}
+
+func TestIntegration_ExportFastPath(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export PROJECT_VAR=1\n")
+	envrcPath := filepath.Join(projectDir, ".envrc")
+	if err := env.runAllow(envrcPath); err != nil {
+		t.Fatal(err)
+	}
+	shell := env.withWorkDir(projectDir).withEnv("CASCADE_EXPORT_FAST_PATH=true")
+	stdout, stderr, err := shell.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	// Records added and directories changed in the second the watches are
+	// recorded count as a change, so move the store's and the chain's last
+	// change, such as the cache export created in the home directory, into
+	// the past
+	past := time.Now().Add(-time.Minute)
+	for _, dir := range []string{filepath.Join(env.dataDir, "cascade", "allow"), env.homeDir, projectDir} {
+		if err := os.Chtimes(dir, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	applied := parseExport(stdout)
+	if applied["PROJECT_VAR"] != "1" {
+		t.Fatalf("first export did not load the .envrc:\n%s", stdout)
+	}
+	next := shell.withApplied(applied)
+
+	stdout, stderr, err = next.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("export with nothing changed printed:\n%s", stdout)
+	}
+
+	// --force evaluates anyway, finding nothing to change either
+	if _, stderr, err := next.run("export", "bash", "--force"); err != nil {
+		t.Fatalf("export --force: %v\nstderr: %s", err, stderr)
+	}
+
+	// A watched file changing ends it too
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(envrcPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = next.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	if stdout == "" {
+		t.Error("export after a watched file changed printed nothing")
+	}
+
+	// So does a change to the allow store
+	if err := os.Chtimes(envrcPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = shell.runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next = shell.withApplied(parseExport(stdout))
+	if err := env.runDeny(envrcPath); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = next.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportUnsets(t, parseExport(stdout), "PROJECT_VAR")
+}
+
+// TestIntegration_ExportFastPathSources tests that a change to the config
+// file, the system store or a directory of the chain ends the fast path,
+// and that a workspace store or trusted_remotes turns it off. Export logs
+// at debug level only when it plans the chain.
+func TestIntegration_ExportFastPathSources(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	workDir := filepath.Join(env.homeDir, "work")
+	projectDir := filepath.Join(workDir, "project")
+	env.createEnvrc(projectDir, "export PROJECT_VAR=1\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	configDir := filepath.Join(env.homeDir, ".config", "cascade")
+	env.createDir(configDir)
+	configPath := filepath.Join(configDir, "config.toml")
+	if err := os.WriteFile(configPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	systemDir := t.TempDir()
+	env.createDir(filepath.Join(systemDir, "allow"))
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+	touch := func(t *testing.T, when time.Time, paths ...string) {
+		t.Helper()
+		for _, path := range paths {
+			if err := os.Chtimes(path, when, when); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	touch(t, past, filepath.Join(env.dataDir, "cascade", "allow"), configPath, filepath.Join(systemDir, "allow"))
+
+	shell := env.withWorkDir(projectDir).withEnv("CASCADE_EXPORT_FAST_PATH=true", "CASCADE_SYSTEM_DATA_DIR="+systemDir)
+	stdout, stderr, err := shell.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	// The first export creates its cache in the home directory
+	touch(t, past, env.homeDir, workDir, projectDir)
+	next := shell.withApplied(parseExport(stdout))
+	fastPath := func(t *testing.T, e *testEnv) bool {
+		t.Helper()
+		_, stderr, err := e.run("export", "bash", "--log-level", "debug")
+		if err != nil {
+			t.Fatalf("export: %v\nstderr: %s", err, stderr)
+		}
+		return stderr == ""
+	}
+	if !fastPath(t, next) {
+		t.Fatal("export with nothing changed did not take the fast path")
+	}
+
+	for _, path := range []string{configPath, filepath.Join(systemDir, "allow")} {
+		touch(t, future, path)
+		if fastPath(t, next) {
+			t.Errorf("export took the fast path after %s changed", path)
+		}
+		touch(t, past, path)
+	}
+
+	// A new .envrc in a directory the chain passes through has no watch,
+	// but changes the directory
+	env.createEnvrc(workDir, "export WORK_VAR=1\n")
+	if fastPath(t, next) {
+		t.Error("export took the fast path after an .envrc was created in a parent directory")
+	}
+	if err := os.Remove(filepath.Join(workDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, past, workDir)
+
+	if fastPath(t, next.withEnv("CASCADE_WORKSPACE_STORE=.cascade")) {
+		t.Error("export took the fast path with a workspace store")
+	}
+	if err := os.WriteFile(configPath, []byte("trusted_remotes = [\"git@github.com:ourorg/\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, past, configPath)
+	if fastPath(t, next) {
+		t.Error("export took the fast path with trusted_remotes")
+	}
+}
+
+// TestIntegration_InconsistentDiff starts a prompt with a CASCADE_DIFF none
+// of whose values are in the environment, as after the shell's environment
+// was rebuilt underneath it, and checks that export evaluates from the
+// environment as it is instead of reverting to the diff's stale values.
+func TestIntegration_InconsistentDiff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	binDir := filepath.Join(projectDir, "bin")
+	env.createDir(binDir)
+	env.createEnvrc(projectDir, "export MODE=dev REGION=eu\nPATH_add bin\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	project := env.withWorkDir(projectDir).withoutEnv("PATH").withEnv("PATH=/old/bin:/usr/bin:/bin", "MODE=prod")
+	stdout, stderr, err := project.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	applied := parseExport(stdout)
+
+	// The environment was rebuilt from newer dotfiles, keeping CASCADE_DIFF
+	rebuilt := env.withWorkDir(projectDir).withoutEnv("PATH").withEnv(
+		"PATH=/new/bin:/usr/bin:/bin",
+		"MODE=staging",
+		"CASCADE_DIFF="+applied["CASCADE_DIFF"],
+		"CASCADE_DIR="+applied["CASCADE_DIR"],
+	)
+	stdout, stderr, err = rebuilt.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, "CASCADE_DIFF does not match the environment")
+	exports := parseExport(stdout)
+	assertExportContains(t, exports, "MODE", "dev")
+	assertExportContains(t, exports, "PATH", binDir+":/new/bin:/usr/bin:/bin")
+
+	// Leaving the project restores the rebuilt environment, not the old one
+	next := rebuilt.withApplied(exports)
+	stdout, stderr, err = next.withWorkDir(env.homeDir).runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrNotContains(t, stderr, "does not match")
+	exports = parseExport(stdout)
+	assertExportContains(t, exports, "MODE", "staging")
+	assertExportContains(t, exports, "PATH", "/new/bin:/usr/bin:/bin")
+	assertExportUnsets(t, exports, "REGION")
+}
+
+func TestIntegration_AllowDenyStdin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	apiDir := filepath.Join(env.homeDir, "api")
+	webDir := filepath.Join(env.homeDir, "web")
+	env.createEnvrc(apiDir, "export API=1\n")
+	env.createEnvrc(webDir, "export WEB=1\n")
+	missing := filepath.Join(env.homeDir, "missing", ".envrc")
+
+	withStdin := func(input string, args ...string) (stdout, stderr string, err error) {
+		t.Helper()
+		cmd := exec.Command(env.binary, args...) //nolint:gosec // intentional CLI test harness
+		cmd.Dir = env.homeDir
+		cmd.Env = env.baseEnv
+		cmd.Stdin = strings.NewReader(input)
+		var outBuf, errBuf bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+		err = cmd.Run()
+		return outBuf.String(), errBuf.String(), err
+	}
+
+	// Relative paths resolve against the working directory; one failure
+	// does not stop the rest
+	list := "# dev machine\napi/.envrc\n\n" + missing + "\n" + filepath.Join(webDir, ".envrc") + "\n"
+	stdout, stderr, err := withStdin(list, "allow", "--stdin")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("allow --stdin with a missing file: err = %v, want exit status 1", err)
+	}
+	for _, want := range []string{
+		"cascade: allowed " + filepath.Join(apiDir, ".envrc") + "\n",
+		"cascade: allowed " + filepath.Join(webDir, ".envrc") + "\n",
+		"cascade: allowed 2 files, 1 failed\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stdout missing %q:\n%s", want, stdout)
+		}
+	}
+	assertStderrContains(t, stderr, missing+": file does not exist")
+
+	stdout, _, err = env.withWorkDir(apiDir).runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExportContains(t, parseExport(stdout), "API", "1")
+
+	// deny takes the same list, and files that do not exist yet
+	stdout, _, err = withStdin(list, "deny", "--stdin")
+	if err != nil {
+		t.Fatalf("deny --stdin: %v", err)
+	}
+	if !strings.Contains(stdout, "cascade: denied 3 files\n") {
+		t.Errorf("stdout missing the summary:\n%s", stdout)
+	}
+	stdout, stderr, _ = env.withWorkDir(webDir).runExport()
+	assertStderrContains(t, stderr, "is blocked")
+	assertExportNotContains(t, parseExport(stdout), "WEB")
+
+	if _, _, err := withStdin("", "allow", "--stdin", "api/.envrc"); err == nil {
+		t.Error("allow --stdin with a path succeeded")
+	}
+}
+
+func TestIntegration_ExportFormat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export MODE=dev NOTE=\"two words\"\nexport MULTI=$'a\\nb'\nexport TOKEN=secret\nsensitive_env TOKEN\nunset EDITOR\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	project := env.withWorkDir(projectDir).withEnv("EDITOR=vim", "KEEP=1")
+
+	// No shell argument is needed
+	stdout, stderr, err := project.run("export", "--format", "dotenv")
+	if err != nil {
+		t.Fatalf("export --format dotenv: %v\nstderr: %s", err, stderr)
+	}
+	if want := "MODE=dev\nMULTI=\"a\\nb\"\nNOTE='two words'\n"; stdout != want {
+		t.Errorf("dotenv output = %q, want %q", stdout, want)
+	}
+	assertStderrContains(t, stderr, "leaving out sensitive variables: TOKEN")
+
+	output := filepath.Join(projectDir, ".env.json")
+	if _, stderr, err := project.run("export", "--format", "json", "--output", output); err != nil {
+		t.Fatalf("export --format json: %v\nstderr: %s", err, stderr)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars map[string]string
+	if err := json.Unmarshal(data, &vars); err != nil {
+		t.Fatalf("parse %s: %v\n%s", output, err, data)
+	}
+	if len(vars) != 3 || vars["MODE"] != "dev" || vars["MULTI"] != "a\nb" || vars["NOTE"] != "two words" {
+		t.Errorf("JSON variables = %v, want MODE, MULTI and NOTE", vars)
+	}
+	if info, err := os.Stat(output); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("output mode = %v, %v, want 0600", info.Mode(), err)
+	}
+
+	// Variables the previous prompt loaded count as set by the chain
+	stdout, _, err = project.runExport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = project.withApplied(parseExport(stdout)).run("export", "--format", "dotenv")
+	if err != nil || !strings.Contains(stdout, "MODE=dev\n") || strings.Contains(stdout, "CASCADE_") {
+		t.Errorf("dotenv output in a loaded shell = %q, %v", stdout, err)
+	}
+
+	if _, _, err := project.run("export", "--output", output); err == nil {
+		t.Error("export --output without --format succeeded")
+	}
+	if _, _, err := project.run("export"); err == nil {
+		t.Error("export without a shell or --format succeeded")
+	}
+}
+
+func TestIntegration_WhichFromCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	env := setupTestEnv(t)
+	tmp := filepath.Dir(env.homeDir)
+	runs := filepath.Join(tmp, "bash-runs")
+	wrapper := filepath.Join(tmp, "bash-wrapper")
+	script := "#!/bin/sh\necho run >> '" + runs + "'\nexec '" + bash + "' \"$@\"\n"
+	if err := os.WriteFile(wrapper, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	countRuns := func() int {
+		t.Helper()
+		data, err := os.ReadFile(runs)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(runs)
+		return strings.Count(string(data), "run\n")
+	}
+
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(env.homeDir, "export SHARED=home\n")
+	env.createEnvrc(projectDir, "export SHARED=project\n")
+	for _, dir := range []string{env.homeDir, projectDir} {
+		if err := env.runAllow(filepath.Join(dir, ".envrc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shell := env.withWorkDir(projectDir).withEnv("CASCADE_BASH_PATH=" + wrapper)
+
+	stdout, stderr, err := shell.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	countRuns()
+	applied := shell.withApplied(parseExport(stdout))
+
+	which := func(e *testEnv, args ...string) (source, value string, setBy []string) {
+		t.Helper()
+		stdout, stderr, err := e.run(append([]string{"which", "--json"}, args...)...)
+		if err != nil {
+			t.Fatalf("which: %v\nstderr: %s", err, stderr)
+		}
+		var out struct {
+			Source string `json:"source"`
+			Value  string `json:"value"`
+			SetBy  []struct {
+				Path string `json:"path"`
+			} `json:"set_by"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+			t.Fatalf("parse JSON: %v\n%s", err, stdout)
+		}
+		for _, entry := range out.SetBy {
+			setBy = append(setBy, entry.Path)
+		}
+		return out.Source, out.Value, setBy
+	}
+	wantSetBy := []string{filepath.Join(env.homeDir, ".envrc"), filepath.Join(projectDir, ".envrc")}
+
+	// In the shell the chain was loaded in, no .envrc runs
+	source, value, setBy := which(applied, "SHARED")
+	if source != "cache" || value != "project" || !slices.Equal(setBy, wantSetBy) {
+		t.Errorf("which = %q, %q, %v, want cache, project, %v", source, value, setBy, wantSetBy)
+	}
+	if n := countRuns(); n != 0 {
+		t.Errorf("which from the cache ran bash %d times, want 0", n)
+	}
+
+	// --evaluate runs the chain, as does a shell where it is not loaded
+	source, value, setBy = which(applied, "--evaluate", "SHARED")
+	if source != "evaluation" || value != "project" || !slices.Equal(setBy, wantSetBy) {
+		t.Errorf("which --evaluate = %q, %q, %v, want evaluation, project, %v", source, value, setBy, wantSetBy)
+	}
+	if source, _, _ := which(shell, "SHARED"); source != "evaluation" {
+		t.Errorf("which without the chain loaded: source = %q, want evaluation", source)
+	}
+
+	// With a level that is never cached (source_up), a variable the chain
+	// did not set is still answered without running anything, and one it
+	// did set is evaluated
+	env.createEnvrc(env.homeDir, "source_up_if_exists\nexport SHARED=home\n")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = shell.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	applied = shell.withApplied(parseExport(stdout))
+	countRuns()
+	if source, _, setBy := which(applied, "OTHER"); source != "cache" || len(setBy) != 0 {
+		t.Errorf("which OTHER = %q, %v, want cache and not set", source, setBy)
+	}
+	if n := countRuns(); n != 0 {
+		t.Errorf("which for a variable the chain did not set ran bash %d times, want 0", n)
+	}
+	if source, value, _ := which(applied, "SHARED"); source != "evaluation" || value != "project" {
+		t.Errorf("which SHARED = %q, %q, want evaluation, project", source, value)
+	}
+	if n := countRuns(); n == 0 {
+		t.Error("which for a level without a cached result did not run bash")
+	}
+}
+
+func TestIntegration_OnUnload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	env := setupTestEnv(t)
+	// source runs export output in a bash with e's environment, as the hook does
+	source := func(e *testEnv, script string) {
+		t.Helper()
+		cmd := exec.Command(bash, "-c", script)
+		cmd.Dir = e.workDir
+		cmd.Env = e.baseEnv
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("bash: %v\n%s", err, out)
+		}
+	}
+	marker := filepath.Join(env.homeDir, "unloaded")
+	readMarker := func() string {
+		t.Helper()
+		data, err := os.ReadFile(marker)
+		if errors.Is(err, os.ErrNotExist) {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(marker)
+		return string(data)
+	}
+
+	// The command is kept as written and runs while the variables are set
+	projectDir := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(projectDir, "export PROJECT_VAR=in_project\non_unload 'printf \"%s\\n\" \"$PROJECT_VAR\" >> \"$HOME/unloaded\"'\n")
+	if err := env.runAllow(filepath.Join(projectDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export in project: %v\nstderr: %s", err, stderr)
+	}
+	inProject := env.withWorkDir(projectDir).withApplied(parseExport(stdout))
+	if readMarker() != "" {
+		t.Error("on_unload command ran on entering the directory")
+	}
+
+	// cd out to a directory where no .envrc applies
+	left := inProject.withWorkDir(env.homeDir)
+	stdout, stderr, err = left.runExport()
+	if err != nil {
+		t.Fatalf("export in home: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, `printf "%s\n" "$PROJECT_VAR" >> "$HOME/unloaded"`) {
+		t.Errorf("export output lacks the on_unload command as written:\n%s", stdout)
+	}
+	source(left, stdout)
+	if got := readMarker(); got != "in_project\n" {
+		t.Errorf("on_unload wrote %q, want %q", got, "in_project\n")
+	}
+
+	// Leaving for a directory with its own chain runs only the commands of
+	// the levels left behind
+	env.createEnvrc(env.homeDir, "export HOME_VAR=1\non_unload 'echo home >> \"$HOME/unloaded\"'\n")
+	if err := env.runAllow(filepath.Join(env.homeDir, ".envrc")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err = env.withWorkDir(projectDir).runExport()
+	if err != nil {
+		t.Fatalf("export in project: %v\nstderr: %s", err, stderr)
+	}
+	inProject = env.withWorkDir(projectDir).withApplied(parseExport(stdout))
+	left = inProject.withWorkDir(env.homeDir)
+	stdout, stderr, err = left.runExport()
+	if err != nil {
+		t.Fatalf("export in home: %v\nstderr: %s", err, stderr)
+	}
+	source(left, stdout)
+	if got := readMarker(); got != "in_project\n" {
+		t.Errorf("moving up to home: on_unload wrote %q, want only the project's command", got)
+	}
+
+	// cascade unload runs every level's commands, deepest first
+	stdout, stderr, err = inProject.run("unload", "bash")
+	if err != nil {
+		t.Fatalf("unload: %v\nstderr: %s", err, stderr)
+	}
+	source(inProject, stdout)
+	if got := readMarker(); got != "in_project\nhome\n" {
+		t.Errorf("unload: on_unload wrote %q, want the project's then home's", got)
+	}
+	if !strings.Contains(stdout, "unset PROJECT_VAR") || !strings.Contains(stdout, "unset CASCADE_DIFF") {
+		t.Errorf("unload does not revert the environment:\n%s", stdout)
+	}
+}
+
+// TestIntegration_StoreNotWritable tests that an allow store that cannot be
+// written is reported by the commands that need it, with CASCADE_DATA_DIR
+// as the way out.
+func TestIntegration_StoreNotWritable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	project := filepath.Join(env.homeDir, "project")
+	env.createEnvrc(project, "export PROJECT=api")
+	rcPath := filepath.Join(project, ".envrc")
+
+	// As on a read-only filesystem: the store's directory cannot be created
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	blocked := env.withWorkDir(project).withEnv("XDG_DATA_HOME=" + file)
+	storeDir := filepath.Join(file, "cascade")
+
+	for _, args := range [][]string{{"allow", rcPath}, {"deny", rcPath}, {"trust", project}} {
+		_, stderr, err := blocked.run(args...)
+		if err == nil {
+			t.Errorf("%s succeeded with a store that cannot be written", args[0])
+		}
+		if !strings.Contains(stderr, storeDir+" is not writable") || !strings.Contains(stderr, "CASCADE_DATA_DIR") {
+			t.Errorf("%s: stderr = %q, want the directory and a hint about CASCADE_DATA_DIR", args[0], stderr)
+		}
+	}
+
+	// Reading still works, and says why nothing is allowed
+	_, stderr, err := blocked.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertStderrContains(t, stderr, storeDir+" is not writable")
+
+	stdout, stderr, err := blocked.run("status", "--json")
+	if err != nil {
+		t.Fatalf("status: %v\nstderr: %s", err, stderr)
+	}
+	var status struct {
+		StoreNotWritable string `json:"store_not_writable"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		t.Fatalf("parse status JSON: %v\n%s", err, stdout)
+	}
+	if !strings.Contains(status.StoreNotWritable, storeDir) {
+		t.Errorf("status store_not_writable = %q, want it to name %s", status.StoreNotWritable, storeDir)
+	}
+
+	stdout, _, _ = blocked.run("doctor")
+	if !strings.Contains(stdout, storeDir+" cannot be written") {
+		t.Errorf("doctor does not report the store:\n%s", stdout)
+	}
+
+	// CASCADE_DATA_DIR moves the store somewhere writable
+	moved := blocked.withEnv("CASCADE_DATA_DIR=" + filepath.Join(t.TempDir(), "cascade"))
+	if err := moved.runAllow(rcPath); err != nil {
+		t.Fatalf("allow with CASCADE_DATA_DIR: %v", err)
+	}
+	stdout, stderr, err = moved.runExport()
+	if err != nil {
+		t.Fatalf("export: %v\nstderr: %s", err, stderr)
+	}
+	assertExportContains(t, parseExport(stdout), "PROJECT", "api")
+	assertStderrNotContains(t, stderr, "not writable")
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/kv"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/shell"
+	"github.com/unrss/cascade/internal/toolchain"
+)
+
+func newInternalCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "internal",
+		Short:  "Callbacks used by stdlib.sh",
+		Long:   `Commands called by stdlib.sh helpers while an .envrc is being evaluated.`,
+		Hidden: true, // Internal command
+	}
+
+	cmd.AddCommand(newKVCmd(), newDotenvCmd(), newFindRuntimeCmd(), newSnapshotCmd())
+
+	return cmd
+}
+
+func newDotenvCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "dotenv FILE",
+		Short: "Print a .env file as bash exports",
+		Long: `Parse the .env file FILE and print its variables as bash export
+statements for the dotenv stdlib function to eval.
+
+Values are never expanded, so $VAR and $(cmd) in FILE stay literal.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{skipConfig: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDotenv(cmd.OutOrStdout(), args[0])
+		},
+	}
+}
+
+func runDotenv(stdout io.Writer, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	vars, err := env.ParseDotenv(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	_, err = io.WriteString(stdout, shell.Get("bash").Dump(vars))
+	return err
+}
+
+func newSnapshotCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "snapshot FILE",
+		Short: "Copy an allowed file for sourcing",
+		Long: `Copy FILE to a private temporary file and print its path, for
+source_up to source in place of FILE. The copy is exactly the approved
+content: FILE must be allowed, and fails if it changed since it was
+checked. The caller removes the copy.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSnapshot(cmd.OutOrStdout(), args[0])
+		},
+	}
+}
+
+func runSnapshot(stdout io.Writer, file string) error {
+	rc, err := envrc.NewRC(file)
+	if err != nil {
+		return err
+	}
+	store, err := readAllowStore()
+	if err != nil {
+		return err
+	}
+	if status, _ := policy.NewResolver(store, chainPolicy(rc.Dir, store)).Explain(rc, cfg); status != allow.Allowed {
+		return fmt.Errorf("%s: %s", rc.Path, status)
+	}
+
+	// Re-read and verify after the check, so what is sourced is what was
+	// allowed even if FILE is swapped in between
+	content, err := rc.Snapshot()
+	if err != nil {
+		return err
+	}
+	content, _ = envrc.NormalizeLineEndings(content)
+	snapshot, err := eval.WriteSnapshot(content)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(stdout, snapshot); err != nil {
+		_ = os.Remove(snapshot)
+		return err
+	}
+	return nil
+}
+
+func newFindRuntimeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "find-runtime TOOL VERSION",
+		Short: "Print the root of an installed runtime",
+		Long: `Print the directory of the newest installed TOOL (node, go or python)
+matching VERSION, for use_node, use_go and use_python.
+
+Versions installed by nvm, mise, goenv, pyenv and golang.org/dl are
+searched. VERSION is a prefix such as 18 or 18.17, an exact version such
+as =18.17.0, a range such as >=18.2 or <20, ^18.2, ~18.2, or latest.`,
+		Args:        cobra.ExactArgs(2),
+		Annotations: map[string]string{skipConfig: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFindRuntime(cmd.OutOrStdout(), args[0], args[1])
+		},
+	}
+}
+
+func runFindRuntime(stdout io.Writer, tool, version string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("get home directory: %w", err)
+	}
+	dirs, err := toolchain.Dirs(tool, home, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	install, err := toolchain.Find(dirs, version)
+	if err != nil {
+		return err
+	}
+	if install == nil {
+		looked := make([]string, len(dirs))
+		for i, dir := range dirs {
+			looked[i] = shortenPath(dir, home)
+		}
+		return fmt.Errorf("no %s matching %q installed (looked in %s)", tool, version, strings.Join(looked, ", "))
+	}
+
+	_, err = fmt.Fprintln(stdout, install.Root)
+	return err
+}
+
+func newKVCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kv",
+		Short: "Store values for the .envrc being evaluated",
+		Long: `Get and set values scoped to the .envrc being evaluated.
+
+Entries are keyed by CASCADE_RC_HASH, which cascade sets during evaluation,
+so editing the .envrc discards its stored values. Used by cache_output.`,
+	}
+
+	cmd.AddCommand(newKVGetCmd(), newKVSetCmd())
+
+	return cmd
+}
+
+func newKVGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a stored value",
+		Long:  `Print the value stored for KEY. Exits 1 if it is missing or expired.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runKVGet(cmd.OutOrStdout(), args[0])
+		},
+	}
+}
+
+func newKVSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set KEY DURATION",
+		Short: "Store a value read from stdin",
+		Long: `Store the value read from stdin for KEY until DURATION (e.g. 30m, 1h) elapses.
+
+The value is read from stdin so secrets never appear in process arguments.
+Keys matching cache_exclude or declared with sensitive_env are accepted
+but not stored, and so is every key during a dry run (CASCADE_DRY_RUN).`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runKVSet(cmd.InOrStdin(), args[0], args[1])
+		},
+	}
+}
+
+func runKVGet(stdout io.Writer, key string) error {
+	store, rcHash, err := openKV()
+	if err != nil {
+		return err
+	}
+
+	value, ok := store.Get(rcHash, key)
+	if !ok {
+		return fmt.Errorf("%s: not stored or expired", key)
+	}
+
+	_, err = io.WriteString(stdout, value)
+	return err
+}
+
+func runKVSet(stdin io.Reader, key, duration string) error {
+	ttl, err := time.ParseDuration(duration)
+	if err != nil {
+		return fmt.Errorf("parse duration: %w", err)
+	}
+	if os.Getenv("CASCADE_DRY_RUN") != "" {
+		_, err := io.Copy(io.Discard, stdin)
+		return err
+	}
+
+	store, rcHash, err := openKV()
+	if err != nil {
+		return err
+	}
+
+	value, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("read value: %w", err)
+	}
+
+	return store.Set(rcHash, key, string(value), ttl)
+}
+
+// openKV opens the kv store for the .envrc currently being evaluated.
+func openKV() (*kv.Store, string, error) {
+	rcHash := os.Getenv("CASCADE_RC_HASH")
+	if rcHash == "" {
+		return nil, "", errors.New("CASCADE_RC_HASH is not set (only available while evaluating an .envrc)")
+	}
+
+	store, err := kv.NewStore()
+	if err != nil {
+		return nil, "", fmt.Errorf("create kv store: %w", err)
+	}
+
+	// Variable names are valid patterns matching only themselves
+	exclude := slices.Clone(cfg.CacheExclude)
+	for _, name := range strings.Split(os.Getenv("CASCADE_SENSITIVE_VARS"), "\n") {
+		if name != "" {
+			exclude = append(exclude, name)
+		}
+	}
+	return store.WithExclude(exclude), rcHash, nil
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+)
+
+// lockFileName is the lockfile cascade lock writes next to the leaf .envrc.
+const lockFileName = ".cascade.lock"
+
+// lockVersion is the current lockfile format version.
+const lockVersion = 1
+
+// Lockfile records what a directory's .envrc chain consists of and which
+// variables it sets, so drift can be detected later with lock --verify.
+type Lockfile struct {
+	Version   int          `json:"version"`
+	Files     []LockedFile `json:"files"`
+	Variables []string     `json:"variables"`
+	Watches   []LockedFile `json:"watches"`
+
+	// Values maps variable names to keyed hashes of their values. The key
+	// is local to this machine, so value hashes only verify where they
+	// were written. ValueKey identifies the key without revealing it.
+	// Variables declared with sensitive_env have no value hash.
+	Values   map[string]string `json:"values,omitempty"`
+	ValueKey string            `json:"value_key,omitempty"`
+}
+
+// LockedFile is a file in a Lockfile. Path is relative to the locked
+// directory and uses forward slashes; Hash is "" if the file is missing.
+type LockedFile struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+func newLockCmd(stdlib string) *cobra.Command {
+	var verify, hashValues, partial bool
+
+	cmd := &cobra.Command{
+		Use:   "lock [DIR]",
+		Short: "Write or verify a lockfile for a directory's cascade",
+		Long: `Evaluate the .envrc chain for DIR (default: current directory) from a
+clean base environment and write DIR/.cascade.lock, recording each .envrc's
+path and content hash, the names of the variables the chain sets, and the
+files it watches.
+
+With --verify, compare the lockfile against a fresh evaluation, print what
+was added (+), removed (-), or changed (~), and exit non-zero on drift.
+
+Value hashes are off by default. --hash-values records a keyed hash of
+each value; the key is stored in cascade's data directory and never leaves
+this machine, so value hashes only verify where they were written.
+
+Paths of .envrc files above DIR are recorded relative to it and depend on
+where the project is checked out.`,
+		Example: `  # Lock the current directory's chain
+  cascade lock
+
+  # Fail if the chain has drifted from the lockfile (for CI)
+  cascade lock --verify
+
+  # Also detect changed values, on this machine only
+  cascade lock --hash-values`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			if verify {
+				return runLockVerify(cmd.OutOrStdout(), cmd.ErrOrStderr(), dir, stdlib, partial)
+			}
+			return runLock(cmd.OutOrStdout(), cmd.ErrOrStderr(), dir, stdlib, hashValues, partial)
+		},
+	}
+
+	cmd.Flags().BoolVar(&verify, "verify", false, "Compare the lockfile against a fresh evaluation and fail on drift")
+	cmd.Flags().BoolVar(&hashValues, "hash-values", false, "Also record keyed hashes of variable values (local key)")
+	cmd.Flags().BoolVar(&partial, "partial", false, "Skip .envrc files that are not allowed instead of failing")
+
+	return cmd
+}
+
+func runLock(stdout, stderr io.Writer, dir, stdlib string, hashValues, partial bool) error {
+	target, lock, err := buildLockfile(stderr, dir, stdlib, hashValues, partial)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal lockfile: %w", err)
+	}
+	path := filepath.Join(target, lockFileName)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write lockfile: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Wrote %s (%d files, %d variables)\n", path, len(lock.Files), len(lock.Variables))
+	return nil
+}
+
+func runLockVerify(stdout, stderr io.Writer, dir, stdlib string, partial bool) error {
+	target, err := resolveTargetDir(dir)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(target, lockFileName)
+	existing, err := readLockfile(path)
+	if err != nil {
+		return err
+	}
+
+	_, fresh, err := buildLockfile(stderr, dir, stdlib, existing.Values != nil, partial)
+	if err != nil {
+		return err
+	}
+	if existing.Values != nil && existing.ValueKey != fresh.ValueKey {
+		fmt.Fprintf(stderr, "cascade: warning: value hashes in %s were written with another key; skipping value comparison\n", path)
+		existing.Values, fresh.Values = nil, nil
+	}
+
+	drift := diffLockfile(existing, fresh)
+	if len(drift) == 0 {
+		fmt.Fprintf(stdout, "%s is up to date\n", path)
+		return nil
+	}
+
+	// Report names only; values may be secrets
+	for _, line := range drift {
+		fmt.Fprintf(stdout, "  %s\n", line)
+	}
+	return fmt.Errorf("%s is out of date (%d differences)", path, len(drift))
+}
+
+// buildLockfile evaluates dir's chain and returns the directory it resolved
+// to and the lockfile describing it.
+func buildLockfile(stderr io.Writer, dir, stdlib string, hashValues, partial bool) (string, *Lockfile, error) {
+	plan, result, vars, err := evaluateClean(stderr, dir, stdlib, partial, cfg.CacheEnabled)
+	if err != nil {
+		return "", nil, err
+	}
+
+	lock := &Lockfile{
+		Version:   lockVersion,
+		Files:     []LockedFile{},
+		Variables: sortedKeys(vars),
+		Watches:   []LockedFile{},
+	}
+	for _, level := range plan.Filter(allow.Allowed) {
+		lock.Files = append(lock.Files, lockedFile(plan.Target, level.RC.Path))
+	}
+
+	seen := make(map[string]bool)
+	for _, watch := range env.ExpandWatches(result.ExtraWatches) {
+		if seen[watch] || filepath.Base(watch) == lockFileName {
+			continue
+		}
+		seen[watch] = true
+		lock.Watches = append(lock.Watches, lockedFile(plan.Target, watch))
+	}
+	slices.SortFunc(lock.Watches, func(a, b LockedFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	if hashValues {
+		key, err := lockValueKey()
+		if err != nil {
+			return "", nil, err
+		}
+		id := sha256.Sum256(key)
+		lock.ValueKey = hex.EncodeToString(id[:8])
+		lock.Values = make(map[string]string, len(vars))
+		for name, value := range vars {
+			// Even a keyed hash of a sensitive_env value stays off disk
+			if slices.Contains(result.Sensitive, name) {
+				continue
+			}
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(name + "=" + value))
+			lock.Values[name] = "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+		}
+	}
+
+	return plan.Target, lock, nil
+}
+
+// lockedFile describes path relative to dir with a hash of its content.
+// Unlike RC.ContentHash, the hash does not depend on where the project is
+// checked out.
+func lockedFile(dir, path string) LockedFile {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = path
+	}
+	f := LockedFile{Path: filepath.ToSlash(rel)}
+	if content, err := os.ReadFile(path); err == nil {
+		sum := sha256.Sum256(content)
+		f.Hash = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return f
+}
+
+// lockValueKey returns this machine's key for hashing values, creating it
+// on first use.
+func lockValueKey() ([]byte, error) {
+	dirs, err := resolveDirs()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dirs.Data, "lock.key")
+
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read lock key: %w", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate lock key: %w", err)
+	}
+	if err := os.MkdirAll(dirs.Data, 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, fmt.Errorf("write lock key: %w", err)
+	}
+	return key, nil
+}
+
+func readLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s not found (run `cascade lock` first)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read lockfile: %w", err)
+	}
+
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if lock.Version > lockVersion {
+		return nil, fmt.Errorf("%s has version %d; this cascade understands up to %d", path, lock.Version, lockVersion)
+	}
+	return &lock, nil
+}
+
+// diffLockfile lists how fresh differs from existing, one line per
+// difference: "+" for additions, "-" for removals, "~" for changed hashes
+// (of a file's content, or of a variable's value when both have them).
+func diffLockfile(existing, fresh *Lockfile) []string {
+	var drift []string
+	drift = append(drift, diffLockedFiles("file", existing.Files, fresh.Files)...)
+
+	// A variable is "~" only when both sides have value hashes to compare
+	compare := existing.Values != nil && fresh.Values != nil
+	variables := func(lock *Lockfile) env.Env {
+		m := make(env.Env, len(lock.Variables))
+		for _, name := range lock.Variables {
+			m[name] = ""
+			if compare {
+				m[name] = lock.Values[name]
+			}
+		}
+		return m
+	}
+	drift = append(drift, prefixDrift("variable", diffEnvFile(variables(existing), variables(fresh)))...)
+
+	drift = append(drift, diffLockedFiles("watch", existing.Watches, fresh.Watches)...)
+	return drift
+}
+
+// diffLockedFiles compares two lists of locked files by path.
+func diffLockedFiles(what string, existing, fresh []LockedFile) []string {
+	old := make(env.Env, len(existing))
+	for _, f := range existing {
+		old[f.Path] = f.Hash
+	}
+	cur := make(env.Env, len(fresh))
+	for _, f := range fresh {
+		cur[f.Path] = f.Hash
+	}
+	return prefixDrift(what, diffEnvFile(old, cur))
+}
+
+// prefixDrift inserts what after the +, -, or ~ of each drift line.
+func prefixDrift(what string, drift []string) []string {
+	for i, line := range drift {
+		drift[i] = line[:2] + what + " " + line[2:]
+	}
+	return drift
+}
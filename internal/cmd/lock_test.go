@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffLockfile(t *testing.T) {
+	base := func() *Lockfile {
+		return &Lockfile{
+			Version:   lockVersion,
+			Files:     []LockedFile{{Path: "../.envrc", Hash: "sha256:aa"}, {Path: ".envrc", Hash: "sha256:bb"}},
+			Variables: []string{"APP", "ORG"},
+			Watches:   []LockedFile{{Path: "config.yaml", Hash: "sha256:cc"}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(l *Lockfile)
+		want   []string
+	}{
+		{
+			name:   "clean",
+			change: func(l *Lockfile) {},
+		},
+		{
+			name:   "file changed",
+			change: func(l *Lockfile) { l.Files[1].Hash = "sha256:dd" },
+			want:   []string{"~ file .envrc"},
+		},
+		{
+			name:   "file added",
+			change: func(l *Lockfile) { l.Files = append(l.Files, LockedFile{Path: "api/.envrc", Hash: "sha256:ee"}) },
+			want:   []string{"+ file api/.envrc"},
+		},
+		{
+			name:   "file removed",
+			change: func(l *Lockfile) { l.Files = l.Files[1:] },
+			want:   []string{"- file ../.envrc"},
+		},
+		{
+			name:   "variables added and removed",
+			change: func(l *Lockfile) { l.Variables = []string{"APP", "TOKEN"} },
+			want:   []string{"+ variable TOKEN", "- variable ORG"},
+		},
+		{
+			name: "watches",
+			change: func(l *Lockfile) {
+				l.Watches = []LockedFile{{Path: "config.yaml", Hash: ""}, {Path: "secrets.env", Hash: "sha256:ff"}}
+			},
+			want: []string{"~ watch config.yaml", "+ watch secrets.env"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fresh := base()
+			tt.change(fresh)
+			if got := diffLockfile(base(), fresh); !slices.Equal(got, tt.want) {
+				t.Errorf("diffLockfile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLockfile_Values(t *testing.T) {
+	existing := &Lockfile{
+		Variables: []string{"APP", "ORG"},
+		Values:    map[string]string{"APP": "hmac-sha256:01", "ORG": "hmac-sha256:02"},
+	}
+	fresh := &Lockfile{
+		Variables: []string{"APP", "ORG"},
+		Values:    map[string]string{"APP": "hmac-sha256:01", "ORG": "hmac-sha256:03"},
+	}
+	if got, want := diffLockfile(existing, fresh), []string{"~ variable ORG"}; !slices.Equal(got, want) {
+		t.Errorf("diffLockfile() = %q, want %q", got, want)
+	}
+
+	// Without value hashes on both sides only names are compared
+	fresh.Values = nil
+	if got := diffLockfile(existing, fresh); len(got) != 0 {
+		t.Errorf("diffLockfile() without fresh values = %q, want none", got)
+	}
+}
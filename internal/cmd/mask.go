@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"slices"
+
+	"github.com/unrss/cascade/internal/env"
+)
+
+// secretMask masks the values of variables that look like secrets (see
+// env.IsMasked) in the output of status, tree and which, so they do not
+// end up in screenshots and pasted bug reports.
+type secretMask struct {
+	show  bool     // --show-secrets: mask nothing
+	extra []string // mask_patterns and cache_exclude
+}
+
+// newSecretMask returns the mask for the configured patterns, or one that
+// masks nothing if show is set.
+func newSecretMask(show bool) secretMask {
+	return secretMask{show: show, extra: slices.Concat(cfg.MaskPatterns, cfg.CacheExclude)}
+}
+
+// matches reports whether name is masked.
+func (m secretMask) matches(name string) bool {
+	return !m.show && env.IsMasked(name, m.extra)
+}
+
+// masks reports whether the value of name is masked. Empty values and
+// values already redacted (sensitive_env) have nothing to mask.
+func (m secretMask) masks(name, value string) bool {
+	return value != "" && value != env.Redacted && m.matches(name)
+}
+
+// value returns value as output shows it for name, and whether it was
+// masked.
+func (m secretMask) value(name, value string) (string, bool) {
+	if !m.masks(name, value) {
+		return value, false
+	}
+	return env.Mask(value), true
+}
+
+// vars masks the values in vars in place and returns the masked names,
+// sorted.
+func (m secretMask) vars(vars map[string]string) []string {
+	var masked []string
+	for name, value := range vars {
+		if v, ok := m.value(name, value); ok {
+			vars[name] = v
+			masked = append(masked, name)
+		}
+	}
+	slices.Sort(masked)
+	return masked
+}
+
+// entries masks the values of the tree entries in vars in place, including
+// the components listed for list variables.
+func (m secretMask) entries(vars []VarEntry) {
+	for i := range vars {
+		v := &vars[i]
+		if !m.matches(v.Name) {
+			continue
+		}
+		v.Value, v.Masked = m.value(v.Name, v.Value)
+		for _, list := range [][]string{v.Added, v.Removed} {
+			for j := range list {
+				list[j] = env.Mask(list[j])
+				v.Masked = true
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/env"
+)
+
+func TestSecretMask(t *testing.T) {
+	prev := cfg
+	t.Cleanup(func() { cfg = prev })
+	cfg = config.Default()
+	cfg.MaskPatterns = []string{"DATABASE_*"}
+
+	vars := map[string]string{
+		"AWS_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG",
+		"DATABASE_URL":          "postgres://user:pw@db",
+		"API_TOKEN":             env.Redacted, // sensitive_env
+		"EDITOR":                "nvim",
+	}
+	masked := newSecretMask(false).vars(vars)
+	if want := []string{"AWS_SECRET_ACCESS_KEY", "DATABASE_URL"}; !slices.Equal(masked, want) {
+		t.Errorf("masked = %v, want %v", masked, want)
+	}
+	if vars["AWS_SECRET_ACCESS_KEY"] != "wJ••••" || vars["DATABASE_URL"] != "po••••" {
+		t.Errorf("vars = %v, want the secrets masked", vars)
+	}
+	if vars["API_TOKEN"] != env.Redacted || vars["EDITOR"] != "nvim" {
+		t.Errorf("vars = %v, want API_TOKEN and EDITOR left alone", vars)
+	}
+
+	entries := []VarEntry{
+		{Name: "GITHUB_TOKEN", Action: "set", Value: "ghp_0123456789"},
+		{Name: "SECRET_PATH", Action: "prepend", Added: []string{"/opt/secret/bin"}},
+		{Name: "EDITOR", Action: "set", Value: "nvim"},
+	}
+	newSecretMask(false).entries(entries)
+	if e := entries[0]; e.Value != "gh••••" || !e.Masked {
+		t.Errorf("GITHUB_TOKEN = %+v, want it masked", e)
+	}
+	if e := entries[1]; !slices.Equal(e.Added, []string{"/o••••"}) || !e.Masked {
+		t.Errorf("SECRET_PATH = %+v, want its components masked", e)
+	}
+	if e := entries[2]; e.Value != "nvim" || e.Masked {
+		t.Errorf("EDITOR = %+v, want it left alone", e)
+	}
+
+	// --show-secrets masks nothing
+	vars = map[string]string{"GITHUB_TOKEN": "ghp_0123456789"}
+	if masked := newSecretMask(true).vars(vars); masked != nil || vars["GITHUB_TOKEN"] != "ghp_0123456789" {
+		t.Errorf("with --show-secrets: masked %v, vars %v, want nothing masked", masked, vars)
+	}
+}
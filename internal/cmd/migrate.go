@@ -25,11 +25,11 @@ type incompatiblePattern struct {
 var incompatiblePatterns = []incompatiblePattern{
 	{regexp.MustCompile(`\buse_nix\b`), "use_nix is not supported - consider using nix-direnv or mise"},
 	{regexp.MustCompile(`\buse_flake\b`), "use_flake is not supported - consider using nix-direnv"},
-	{regexp.MustCompile(`\blayout\s+python`), "layout python may work differently - test after migration"},
+	{regexp.MustCompile(`\blayout\s+python`), "layout python keeps its venv in .venv, not .direnv/python-VERSION - set CASCADE_VENV_DIR to reuse an existing one"},
 	{regexp.MustCompile(`\blayout\s+ruby`), "layout ruby may work differently - test after migration"},
 	{regexp.MustCompile(`\blayout\s+node`), "layout node may work differently - test after migration"},
-	{regexp.MustCompile(`\bsource_up\b`), "source_up is handled automatically by cascade - remove this line"},
-	{regexp.MustCompile(`\bDIRENV_`), "DIRENV_* variables should be changed to CASCADE_*"},
+	{regexp.MustCompile(`\bsource_up\b`), "source_up is supported but usually unnecessary - parent .envrc files under the cascade root are already in the chain"},
+	{regexp.MustCompile(`\bDIRENV_`), "DIRENV_* variables should be changed to CASCADE_* (or set direnv_compat = true in the config for DIRENV_DIR and DIRENV_FILE)"},
 }
 
 // migrationResult holds the outcome of migrating a single file.
@@ -58,7 +58,11 @@ This command will:
 1. Import allowed .envrc files from direnv
 2. Warn about .envrc patterns that may not work in cascade
 3. Generate a migration report`,
-		RunE: runMigrate,
+		Example: `  cascade migrate --dry-run     # Preview the import
+  cascade migrate --check-only  # Only look for incompatible .envrc patterns
+  cascade migrate`,
+		Annotations: map[string]string{envAnnotation: `XDG_DATA_HOME: Location of direnv's allow list and of cascade's allow store (default ~/.local/share)`},
+		RunE:        runMigrate,
 	}
 
 	cmd.Flags().Bool("dry-run", false, "Show what would be migrated without making changes")
@@ -101,7 +105,7 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 	// Create cascade allow store (unless check-only)
 	var store *allow.Store
 	if !checkOnly {
-		store, err = allow.NewStore()
+		store, err = newAllowStore()
 		if err != nil {
 			return fmt.Errorf("create allow store: %w", err)
 		}
@@ -136,7 +140,7 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 
 		// Migrate (allow in cascade) unless dry-run or check-only
 		if !checkOnly && !dryRun {
-			if err := store.Allow(rc); err != nil {
+			if err := store.WithTrigger("cascade migrate (allowed in direnv)").Allow(rc); err != nil {
 				result.skipped = true
 				result.reason = fmt.Sprintf("allow failed: %v", err)
 				results = append(results, result)
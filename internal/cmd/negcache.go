@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/run"
+	"github.com/unrss/cascade/internal/shell"
+)
+
+// negCacheVar holds the envrc.NegativeCache export records when the chain
+// for the working directory has no .envrc, so the next prompt in the same
+// place can return after a stat per directory instead of walking the chain.
+const negCacheVar = "CASCADE_NEGCACHE"
+
+// negCacheKey fingerprints the settings chain discovery depends on, so a
+// config change invalidates the negative cache.
+func negCacheKey() string {
+	root, _ := cfg.GetCascadeRoot()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%t\x00%s\x00%s\x00%d", root, strings.Join(cfg.SkipMarkers, "\x00"),
+		cfg.CrossFilesystem, strings.Join(cfg.CrossFilesystemMounts(), "\x00"), cfg.ChainOutsideRoot, cfg.MaxWalkDepth)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// negCacheHit reports whether the negative cache in this shell says the
+// chain for the working directory still has no .envrc. It never hits
+// while export has something applied or a refresh is asked for.
+func negCacheHit() bool {
+	encoded := os.Getenv(negCacheVar)
+	if encoded == "" || os.Getenv("CASCADE_DIFF") != "" || os.Getenv("CASCADE_DIR") != "" || os.Getenv("CASCADE_REFRESH") != "" {
+		return false
+	}
+	c, err := envrc.ParseNegativeCache(encoded)
+	if err != nil {
+		return false
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	return c.Valid(cwd, negCacheKey())
+}
+
+// recordNegCache prints the shell commands that record plan, which has no
+// .envrc, in the negative cache. The directories recorded are the chain
+// and the one whose skip marker ended it, since adding an .envrc or
+// removing the marker changes their mtimes; levels on another filesystem
+// were not searched and are left out. If the directories changed too
+// recently to be relied on, a level timed out, or a misspelled .envrc is
+// warned about at every prompt, a stale cache is cleared instead.
+func recordNegCache(w io.Writer, sh shell.Shell, plan *run.Plan) {
+	dirs := make([]string, 0, len(plan.Chain)+1)
+	uncertain := false
+	for _, rc := range plan.Chain {
+		if rc.Unsearched == "" {
+			dirs = append(dirs, rc.Dir)
+			// A fragment added to an existing envrc.d changes only its mtime
+			if frag := filepath.Join(rc.Dir, envrc.FragmentDir); isDir(frag) {
+				dirs = append(dirs, frag)
+			}
+		}
+		uncertain = uncertain || len(rc.CaseVariants) > 0 || rc.Unsearched == envrc.UnsearchedTimeout
+	}
+	if plan.Skipped != "" {
+		dirs = append(dirs, plan.Skipped)
+	}
+
+	export := make(shell.ShellExport)
+	prev := os.Getenv(negCacheVar)
+	if c, ok := envrc.NewNegativeCache(plan.Target, negCacheKey(), dirs); ok && !uncertain {
+		if encoded, err := c.Serialize(); err == nil && encoded != prev {
+			export.Set(negCacheVar, encoded)
+		}
+	} else if prev != "" {
+		export.Unset(negCacheVar)
+	}
+	if len(export) > 0 {
+		fmt.Fprint(w, sh.Export(export))
+	}
+}
@@ -0,0 +1,19 @@
+package cmd
+
+// schemaVersion is the version of the --json output formats. Bump it when a
+// field is removed or changes meaning; adding fields does not require it.
+const schemaVersion = 1
+
+// cascadeVersion is the version of the running binary, set by Execute.
+var cascadeVersion string
+
+// OutputHeader makes a JSON document self-describing. It is embedded in
+// each --json output and left empty when the output is nested in another.
+type OutputHeader struct {
+	Version       string `json:"version,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+}
+
+func newOutputHeader() OutputHeader {
+	return OutputHeader{Version: cascadeVersion, SchemaVersion: schemaVersion}
+}
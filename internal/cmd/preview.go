@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/run"
+	"github.com/unrss/cascade/internal/shell"
+)
+
+// PreviewOutput is the JSON representation of cascade export --dry-run: what
+// the next prompt would do to this shell.
+type PreviewOutput struct {
+	OutputHeader
+	Directory string          `json:"directory"`
+	Files     []PreviewFile   `json:"files"`
+	Changes   []PreviewChange `json:"changes"`
+	Watches   []string        `json:"watches,omitempty"`
+	Unload    bool            `json:"unload,omitempty"` // True if the previous environment is reverted and nothing loaded
+
+	// The commands export would print, for --explain
+	unloadCmds []string
+	export     shell.ShellExport
+	notified   shell.ShellExport // CASCADE_NOTIFIED, printed after the rest
+	origins    map[string]string // Variable name to the .envrc that changed it last
+}
+
+// PreviewFile is an .envrc in the chain and what export does with it.
+type PreviewFile struct {
+	Path   string `json:"path"`
+	Action string `json:"action"` // "evaluate", "skip" (not allowed), "block" (denied), "fail"
+	Error  string `json:"error,omitempty"`
+}
+
+// PreviewChange is a variable the next prompt would set or unset.
+type PreviewChange struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` // "add", "change", "remove"
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// Actions of PreviewFile.
+const (
+	previewEvaluate = "evaluate"
+	previewSkip     = "skip"
+	previewBlock    = "block"
+	previewFail     = "fail"
+)
+
+// cascadeStateVars are the variables export keeps its own state in. They
+// change at every prompt and are left out of the preview.
+var cascadeStateVars = []string{"CASCADE_DIFF", "CASCADE_DIR", "CASCADE_FILE", "CASCADE_PENDING", "CASCADE_WATCHES", negCacheVar, "DIRENV_DIR", "DIRENV_FILE"}
+
+func newPreview() *PreviewOutput {
+	return &PreviewOutput{Files: []PreviewFile{}, Changes: []PreviewChange{}}
+}
+
+// addPlan records what export does with each .envrc of plan.
+func (p *PreviewOutput) addPlan(plan *run.Plan) {
+	p.Directory = plan.Target
+	for _, level := range plan.Levels {
+		action := previewEvaluate
+		switch level.Status {
+		case allow.Denied:
+			action = previewBlock
+		case allow.NotAllowed:
+			action = previewSkip
+		}
+		p.Files = append(p.Files, PreviewFile{Path: level.RC.Path, Action: action})
+	}
+}
+
+// fail marks the .envrc at path as failing to evaluate.
+func (p *PreviewOutput) fail(path string, err error) {
+	for i := range p.Files {
+		if p.Files[i].Path == path {
+			p.Files[i].Action = previewFail
+			p.Files[i].Error = err.Error()
+		}
+	}
+}
+
+// addExport records the changes export would make to current, the shell's
+// environment. Values of secret variables (see env.IsSecret) are redacted.
+func (p *PreviewOutput) addExport(current env.Env, export shell.ShellExport, secret []string) {
+	names := make([]string, 0, len(export))
+	for name := range export {
+		if !slices.Contains(cascadeStateVars, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	show := func(name, value string) string {
+		if env.IsSecret(name, secret) {
+			return env.Redacted
+		}
+		return value
+	}
+	for _, name := range names {
+		old, had := current[name]
+		value := export[name]
+		switch {
+		case value == nil && had:
+			p.Changes = append(p.Changes, PreviewChange{Name: name, Action: "remove", Old: show(name, old)})
+		case value == nil:
+		case !had:
+			p.Changes = append(p.Changes, PreviewChange{Name: name, Action: "add", New: show(name, *value)})
+		case old != *value:
+			p.Changes = append(p.Changes, PreviewChange{Name: name, Action: "change", Old: show(name, old), New: show(name, *value)})
+		}
+	}
+}
+
+// addScript records the commands export would print: the on_unload
+// commands unload, then export. levels are the evaluated levels, looked at
+// for the .envrc that set or unset each variable last.
+func (p *PreviewOutput) addScript(unload []string, export shell.ShellExport, levels []*run.Level) {
+	p.unloadCmds = unload
+	p.export = export
+	p.origins = make(map[string]string)
+	for _, level := range levels {
+		if !level.Evaluated {
+			continue
+		}
+		for name := range export {
+			before, had := level.Before[name]
+			after, has := level.After[name]
+			if had != has || before != after {
+				p.origins[name] = level.RC.Path
+			}
+		}
+	}
+}
+
+// printExplain writes the commands export would print for sh, each
+// variable's preceded by a comment saying where it comes from.
+func printExplain(w io.Writer, sh shell.Shell, p *PreviewOutput) error {
+	home, _ := os.UserHomeDir()
+	var b strings.Builder
+
+	if len(p.unloadCmds) > 0 {
+		b.WriteString("# on_unload commands of the .envrc files being left\n")
+		for _, command := range p.unloadCmds {
+			b.WriteString(command + "\n")
+		}
+	}
+	for _, export := range []shell.ShellExport{p.export, p.notified} {
+		for _, name := range slices.Sorted(maps.Keys(export)) {
+			value := export[name]
+			fmt.Fprintf(&b, "# %s: %s\n", name, p.origin(name, value, home))
+			b.WriteString(sh.Export(shell.ShellExport{name: value}))
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("# Nothing to change\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// origin says where the value export gives name comes from.
+func (p *PreviewOutput) origin(name string, value *string, home string) string {
+	if strings.HasPrefix(name, "CASCADE_") {
+		return "cascade state"
+	}
+	if path, ok := p.origins[name]; ok {
+		if value == nil {
+			return "unset by " + shortenPath(path, home)
+		}
+		return "set by " + shortenPath(path, home)
+	}
+	if value == nil {
+		return "unset, as it was before cascade set it"
+	}
+	return "restored to its value before cascade changed it"
+}
+
+// printPreview writes the preview as JSON or for humans.
+func printPreview(w io.Writer, p *PreviewOutput, jsonOutput bool) error {
+	if jsonOutput {
+		p.OutputHeader = newOutputHeader()
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+
+	c := newColorizer(w)
+	home, _ := os.UserHomeDir()
+
+	if len(p.Files) > 0 {
+		fmt.Fprintf(w, "%s\n", c.bold(".envrc files:"))
+		for _, file := range p.Files {
+			path := shortenPath(file.Path, home)
+			switch file.Action {
+			case previewEvaluate:
+				fmt.Fprintf(w, "  %s %s\n", c.green("evaluate"), path)
+			case previewSkip:
+				fmt.Fprintf(w, "  %s %s (not allowed)\n", c.yellow("skip    "), path)
+			case previewBlock:
+				fmt.Fprintf(w, "  %s %s (denied)\n", c.red("block   "), path)
+			case previewFail:
+				fmt.Fprintf(w, "  %s %s: %s\n", c.red("fail    "), path, file.Error)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	if p.Unload {
+		fmt.Fprintf(w, "%s\n", c.bold("The environment loaded earlier would be unloaded:"))
+	} else {
+		fmt.Fprintf(w, "%s\n", c.bold("Changes to this shell:"))
+	}
+	if len(p.Changes) == 0 {
+		fmt.Fprintf(w, "  %s\n", c.dim("none"))
+	}
+	for _, change := range p.Changes {
+		switch change.Action {
+		case "add":
+			fmt.Fprintf(w, "  %s %s = %s\n", c.green("+"), change.Name, formatValue(truncateValue(change.New, 50)))
+		case "change":
+			// Path-like values are long; count the entries that differ
+			if treeIsPathLikeVar(change.Name) {
+				fmt.Fprintf(w, "  %s %s %s\n", c.yellow("~"), change.Name,
+					diffPreview(change.Name, change.Old, change.New, nil, diffLogOptions{values: true}))
+				continue
+			}
+			fmt.Fprintf(w, "  %s %s = %s %s %s\n", c.yellow("~"), change.Name,
+				formatValue(truncateValue(change.Old, 50)), c.dim("→"), formatValue(truncateValue(change.New, 50)))
+		case "remove":
+			fmt.Fprintf(w, "  %s %s\n", c.red("-"), change.Name)
+		}
+	}
+
+	if len(p.Watches) > 0 {
+		fmt.Fprintf(w, "\n%s\n", c.bold("Watched files:"))
+		for _, path := range p.Watches {
+			fmt.Fprintf(w, "  %s\n", shortenPath(path, home))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+)
+
+// RecordListOutput is the JSON representation of allow, deny, or trust --list.
+type RecordListOutput struct {
+	OutputHeader
+	Kind    string        `json:"kind"`
+	Records []RecordEntry `json:"records"`
+}
+
+// RecordEntry is a single record in a --list.
+type RecordEntry struct {
+	Path    string    `json:"path"`
+	State   string    `json:"state"` // "ok", "changed", "missing"
+	Hash    string    `json:"hash"`  // Record name: content hash (allow) or path hash
+	Written time.Time `json:"written"`
+}
+
+// recordListOptions holds the flags shared by allow, deny, and trust --list.
+type recordListOptions struct {
+	list   bool
+	under  string
+	stale  bool
+	sortBy string
+	json   bool
+}
+
+// addRecordListFlags registers --list and its filters on cmd.
+func addRecordListFlags(cmd *cobra.Command, opts *recordListOptions, what string) {
+	cmd.Flags().BoolVarP(&opts.list, "list", "l", false, "List "+what)
+	cmd.Flags().StringVar(&opts.under, "under", "", "With --list, only show paths under this directory")
+	cmd.Flags().BoolVar(&opts.stale, "stale", false, "With --list, only show changed or missing entries")
+	cmd.Flags().StringVar(&opts.sortBy, "sort", "path", "With --list, sort by path or date")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Output in JSON format")
+}
+
+// listRecords returns the store's records of kind that pass opts' filters,
+// sorted as requested.
+func listRecords(store *allow.Store, kind allow.Kind, opts recordListOptions) ([]RecordEntry, error) {
+	if opts.sortBy != "path" && opts.sortBy != "date" {
+		return nil, fmt.Errorf("invalid --sort %q (want path or date)", opts.sortBy)
+	}
+
+	under := ""
+	if opts.under != "" {
+		abs, err := filepath.Abs(opts.under)
+		if err != nil {
+			return nil, fmt.Errorf("resolve path: %w", err)
+		}
+		under = abs
+	}
+
+	entries := []RecordEntry{}
+	for record, err := range store.Records(kind) {
+		if err != nil {
+			return nil, err
+		}
+		if under != "" && !record.Under(under) {
+			continue
+		}
+		state := record.State()
+		if opts.stale && state == allow.RecordOK {
+			continue
+		}
+		entries = append(entries, RecordEntry{
+			Path:    record.Path,
+			State:   string(state),
+			Hash:    record.Name,
+			Written: record.ModTime,
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b RecordEntry) int {
+		if opts.sortBy == "date" {
+			if c := a.Written.Compare(b.Written); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return entries, nil
+}
+
+// recordListText is the per-kind wording of the human listing.
+var recordListText = map[allow.Kind]struct{ date, empty string }{
+	allow.KindAllow: {"ALLOWED ON", "No allowed .envrc files"},
+	allow.KindDeny:  {"DENIED ON", "No denied .envrc files"},
+	allow.KindTrust: {"TRUSTED ON", "No trusted subtrees"},
+}
+
+// runRecordList prints the store's records of kind.
+func runRecordList(w io.Writer, args []string, store *allow.Store, kind allow.Kind, opts recordListOptions) error {
+	if len(args) > 0 {
+		return errors.New("--list does not take a path; use --under to filter")
+	}
+
+	entries, err := listRecords(store, kind, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(RecordListOutput{
+			OutputHeader: newOutputHeader(),
+			Kind:         string(kind),
+			Records:      entries,
+		})
+	}
+
+	text := recordListText[kind]
+	if len(entries) == 0 {
+		fmt.Fprintln(w, text.empty)
+		return nil
+	}
+
+	c := newColorizer(w)
+	home, _ := os.UserHomeDir()
+
+	fmt.Fprintf(w, "%-8s %-10s %s\n", "STATE", text.date, "PATH")
+	for _, e := range entries {
+		// Pad before coloring so escape codes do not break alignment
+		state := fmt.Sprintf("%-8s", e.State)
+		switch allow.RecordState(e.State) {
+		case allow.RecordChanged:
+			state = c.yellow(state)
+		case allow.RecordMissing:
+			state = c.red(state)
+		}
+		fmt.Fprintf(w, "%s %-10s %s\n", state, e.Written.Format(time.DateOnly), shortenPath(e.Path, home))
+	}
+	return nil
+}
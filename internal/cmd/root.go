@@ -2,19 +2,55 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
 	"github.com/spf13/cobra"
 
 	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/logging"
 )
 
 // Assets holds embedded files passed from main.
 type Assets struct {
 	Stdlib  string
 	Version string
+	Commit  string // VCS revision, set by release builds
+	Date    string // Build date, set by release builds
+}
+
+// ExitError makes main exit with Code without printing anything, for
+// commands whose exit status carries meaning beyond failure.
+type ExitError struct {
+	Code int
 }
 
-// cfg holds the loaded configuration, available to all commands.
-var cfg *config.Config
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// cfg holds the loaded configuration. It is set by loadConfig, which runs
+// before every command except those marked with skipConfig.
+var (
+	cfg     *config.Config
+	cfgOnce sync.Once
+	cfgErr  error
+
+	// dataDirFlag is --data-dir (see allowDataDir)
+	dataDirFlag string
+
+	// logLevelFlag is --log-level, or what --quiet or --verbose stand for
+	// (see newLogger)
+	logLevelFlag string
+)
+
+// skipConfig is the annotation marking a command that does not need the
+// configuration, so starting it skips loading the config file.
+const skipConfig = "cascade.skip_config"
 
 // Execute runs the root command with the provided assets.
 func Execute(assets Assets) error {
@@ -23,18 +59,49 @@ func Execute(assets Assets) error {
 }
 
 func newRootCmd(assets Assets) *cobra.Command {
+	cascadeVersion = strings.TrimSpace(assets.Version)
+
 	cmd := &cobra.Command{
 		Use:   "cascade",
 		Short: "Hierarchical environment variable management",
 		Long: `cascade is a direnv-like tool for managing environment variables
-with hierarchical inheritance across directories.`,
+with hierarchical inheritance across directories.
+
+Unlike direnv, which loads only the nearest .envrc, cascade evaluates every
+.envrc from the cascade root (default: $HOME) down to the current directory,
+so settings in ~/.envrc and ~/work/.envrc are inherited by the projects
+below them. Nothing is evaluated until it is allowed; see cascade allow and
+cascade trust.`,
+		Example: `  # Set up the shell hook (see cascade hook for zsh and fish)
+  eval "$(cascade hook bash)"
+
+  # Approve the .envrc in the current directory
+  cascade allow
+
+  # See what is loaded and where each variable comes from
+  cascade status
+  cascade tree --values`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return initConfig()
+			if err := resolveLogLevelFlags(cmd); err != nil {
+				return err
+			}
+			if _, ok := cmd.Annotations[skipConfig]; ok {
+				return nil
+			}
+			_, err := loadConfig()
+			return err
 		},
 	}
 
+	cmd.PersistentFlags().StringVar(&dataDirFlag, "data-dir", "",
+		"Use the allow store in `DIR` instead of $XDG_DATA_HOME/cascade (also "+dataDirEnv+")")
+	cmd.PersistentFlags().StringVar(&logLevelFlag, "log-level", "",
+		"Print messages up to `LEVEL`: error, warn, info or debug (also CASCADE_LOG_LEVEL)")
+	cmd.PersistentFlags().Bool("quiet", false, "Print errors only (--log-level error)")
+	cmd.PersistentFlags().Bool("verbose", false, "Also print debugging details (--log-level debug)")
+
 	// Add subcommands
 	cmd.AddCommand(
 		newHookCmd(),
@@ -42,22 +109,78 @@ with hierarchical inheritance across directories.`,
 		newAllowCmd(),
 		newDenyCmd(),
 		newTrustCmd(),
-		newStatusCmd(),
+		newEditCmd(),
+		newStatusCmd(assets.Stdlib),
 		newCheckCmd(),
-		newVersionCmd(assets.Version),
+		newVersionCmd(newBuildInfo(assets)),
 		newDumpCmd(),
 		newWhichCmd(assets.Stdlib),
 		newConfigCmd(),
 		newMigrateCmd(),
 		newTreeCmd(assets.Stdlib),
 		newDoctorCmd(),
+		newInitCmd(),
+		newCacheCmd(),
+		newInternalCmd(),
+		newBugreportCmd(),
+		newLockCmd(assets.Stdlib),
+		newEnvrcCmd(),
+		newSessionCmd(),
+		newExecCmd(assets.Stdlib),
+		newAuditCmd(),
+		newDocsCmd(),
+		newUnloadCmd(),
+		newStateCmd(),
+		newDiffCmd(assets.Stdlib),
+		newChainCmd(),
 	)
 
 	return cmd
 }
 
-func initConfig() error {
-	var err error
-	cfg, err = config.Load()
-	return err
+// resolveLogLevelFlags checks --log-level and turns --quiet or --verbose
+// into it.
+func resolveLogLevelFlags(cmd *cobra.Command) error {
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	switch {
+	case quiet && verbose, (quiet || verbose) && logLevelFlag != "":
+		return errors.New("--log-level, --quiet and --verbose cannot be used together")
+	case quiet:
+		logLevelFlag = logging.LevelError.String()
+	case verbose:
+		logLevelFlag = logging.LevelDebug.String()
+	case logLevelFlag != "":
+		if _, err := logging.ParseLevel(logLevelFlag); err != nil {
+			return fmt.Errorf("invalid --log-level: %w", err)
+		}
+	}
+	return nil
+}
+
+// newLogger returns the logger printing to w at the level of --log-level,
+// or else of log_level and CASCADE_LOG_LEVEL.
+func newLogger(w io.Writer) *logging.Logger {
+	name := logLevelFlag
+	if name == "" && cfg != nil {
+		name = cfg.LogLevel
+	} else if name == "" {
+		name = os.Getenv("CASCADE_LOG_LEVEL")
+	}
+	level, err := logging.ParseLevel(name)
+	if err != nil {
+		level = logging.DefaultLevel
+	}
+	return logging.New(w, level)
+}
+
+// loadConfig loads the configuration on first use, with the profile for
+// the working directory applied. Later calls return the same result,
+// including the error from a broken config file.
+func loadConfig() (*config.Config, error) {
+	cfgOnce.Do(func() {
+		cwd, _ := os.Getwd()
+		cfg, cfgErr = config.LoadForDir(cwd)
+	})
+	return cfg, cfgErr
 }
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/shell"
+)
+
+func newSessionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Share the current environment with new tmux windows and shells",
+		Long: `With session_export_file set, export also writes the variables it applies
+for a directory to that file (NUL-separated NAME=VALUE entries, bare NAME
+for unset variables), and removes it when the environment is reverted.
+
+These commands find the file for the current directory so programs that
+start shells outside the prompt hook can pick the environment up. See
+` + "`cascade hook tmux`" + ` for tmux.`,
+		Example: `  # Start a shell with the environment of the current directory
+  cascade session exec
+
+  # Run one command with it
+  cascade session exec make test`,
+	}
+
+	cmd.AddCommand(newSessionPathCmd(), newSessionExecCmd())
+
+	return cmd
+}
+
+func newSessionPathCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "path",
+		Short: "Print the session export file for the current directory",
+		Long:  `Print the session export file that applies to the current directory. Exits 1 if there is none.`,
+		Example: `  cascade session path
+  tr '\0' '\n' < "$(cascade session path)"`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := findSessionFile()
+			if err != nil {
+				return err
+			}
+			if path == "" {
+				return &ExitError{Code: 1}
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), path)
+			return nil
+		},
+	}
+}
+
+func newSessionExecCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "exec [COMMAND [ARG...]]",
+		Short: "Run a command with the session environment applied",
+		Long: `Apply the session export file for the current directory, if any, to the
+environment and run COMMAND (default: $SHELL). Suitable for tmux's
+default-command.
+
+A broken config file or session file is reported but never stops COMMAND
+from starting, so a new window always gets a shell.`,
+		Example: `  cascade session exec
+  cascade session exec -- make test
+
+  # In tmux.conf (see cascade hook tmux)
+  set -g default-command "cascade session exec"`,
+		Args:               cobra.ArbitraryArgs,
+		DisableFlagParsing: true,
+		Annotations: map[string]string{
+			skipConfig:    "",
+			envAnnotation: "SHELL: Command run when none is given",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSessionExec(cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), args)
+		},
+	}
+}
+
+func runSessionExec(stdin io.Reader, stdout, stderr io.Writer, args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		sh := os.Getenv("SHELL")
+		if sh == "" {
+			sh = "/bin/sh"
+		}
+		args = []string{sh}
+	}
+
+	environ := os.Environ()
+	var path string
+	_, err := loadConfig()
+	if err == nil {
+		path, err = findSessionFile()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "cascade: warning: %v\n", err)
+	} else if path != "" {
+		if environ, err = applySessionFile(environ, path); err != nil {
+			fmt.Fprintf(stderr, "cascade: warning: %v\n", err)
+		}
+	}
+
+	// A shell started here keeps using the allow store given by --data-dir
+	if dir, source := allowDataDir(); source == "--data-dir" {
+		environ = append(environ, dataDirEnv+"="+dir)
+	}
+
+	c := exec.Command(args[0], args[1:]...) //nolint:gosec // runs the user's command
+	c.Env = environ
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("run %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// applySessionFile returns environ with the entries of the session export
+// file at path applied.
+func applySessionFile(environ []string, path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return environ, fmt.Errorf("read session file: %w", err)
+	}
+	vars, err := shell.ParseNull(data)
+	if err != nil {
+		return environ, fmt.Errorf("parse session file %s: %w", path, err)
+	}
+
+	current := env.FromGoEnv(environ)
+	for name, value := range vars {
+		if value == nil {
+			delete(current, name)
+		} else {
+			current[name] = *value
+		}
+	}
+	return current.ToGoEnv(), nil
+}
+
+// sessionFile returns the session export file for dir, or "" when
+// session_export_file is not configured.
+func sessionFile(dir string) (string, error) {
+	if cfg.SessionExportFile == "" {
+		return "", nil
+	}
+
+	sum := sha256.Sum256([]byte(dir))
+	var missing []string
+	path := os.Expand(cfg.SessionExportFile, func(name string) string {
+		if name == "CASCADE_DIR_HASH" {
+			return hex.EncodeToString(sum[:])
+		}
+		value := os.Getenv(name)
+		if value == "" {
+			missing = append(missing, "$"+name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("session_export_file: %s not set", strings.Join(missing, ", "))
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("session_export_file: %s is not an absolute path", path)
+	}
+	return path, nil
+}
+
+// writeSessionFile atomically replaces the session export file for dir with
+// vars, readable only by the user.
+func writeSessionFile(dir string, vars shell.ShellExport) error {
+	path, err := sessionFile(dir)
+	if err != nil || path == "" {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create session directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create session file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(shell.FormatNull(vars)); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write session file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write session file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename session file: %w", err)
+	}
+	return nil
+}
+
+// removeSessionFile removes the session export file for dir, if any.
+func removeSessionFile(dir string) error {
+	path, err := sessionFile(dir)
+	if err != nil || path == "" {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove session file: %w", err)
+	}
+	return nil
+}
+
+// findSessionFile returns the session export file for the nearest
+// directory at or above the working directory that has one, or "".
+func findSessionFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working directory: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	for {
+		path, err := sessionFile(dir)
+		if err != nil || path == "" {
+			return "", err
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/config"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/state"
+)
+
+// StateListOutput is the JSON representation of cascade state list.
+type StateListOutput struct {
+	OutputHeader
+	Entries []StateEntry `json:"entries"`
+	Corrupt []string     `json:"corrupt,omitempty"` // State files that could not be read
+}
+
+// StateEntry summarizes one stored state.
+type StateEntry struct {
+	Path      string    `json:"path"`      // .envrc the chain ended at
+	Timestamp time.Time `json:"timestamp"` // Last written (see state.DirState.LastUsed)
+	Vars      int       `json:"vars"`      // Variables in the stored diff
+	Missing   bool      `json:"missing,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+}
+
+func newStateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect and clean up stored export state",
+		Long: `Inspect and clean up the state export keeps for each chain it loads: the
+diff it applied, the files the chain sourced, its on_unload commands, and
+recent failures. There is one entry per deepest .envrc, kept in the state
+directory next to the allow store.
+
+Entries are never removed on their own. Use clean to drop those whose
+.envrc was deleted or that have not been used for a while.`,
+		Example: `  cascade state list
+  cascade state show ~/work/api
+  cascade state clean --older-than 90d`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+	}
+
+	cmd.AddCommand(newStateListCmd())
+	cmd.AddCommand(newStateShowCmd())
+	cmd.AddCommand(newStateCleanCmd())
+
+	return cmd
+}
+
+func newStateListCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored state entries",
+		Long: `List every stored state entry: the .envrc its chain ends at, when it was
+last written, and how many variables its diff holds. Entries whose .envrc
+no longer exists are marked missing. State files that cannot be read are
+reported and skipped.`,
+		Example: `  cascade state list
+  cascade state list --json`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStateList(cmd.OutOrStdout(), cmd.ErrOrStderr(), jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+
+	return cmd
+}
+
+func newStateShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show PATH",
+		Short: "Print the stored state for an .envrc",
+		Long: `Print the state stored for the chain ending at PATH, an .envrc or the
+directory holding one, as JSON: the diff export applied, the content hash
+of the .envrc, and any sourced files, on_unload commands and failures.
+Values of sensitive_env variables are never stored.`,
+		Example:     `  cascade state show ~/work/api/.envrc`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStateShow(cmd.OutOrStdout(), args[0])
+		},
+	}
+}
+
+// stateCleanOptions holds the state clean command's flags.
+type stateCleanOptions struct {
+	olderThan string // Remove entries not written within this age
+	missing   bool   // Remove entries whose .envrc no longer exists
+	dryRun    bool
+}
+
+func newStateCleanCmd() *cobra.Command {
+	var opts stateCleanOptions
+
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove stale state entries",
+		Long: `Remove state entries whose .envrc no longer exists (--missing) or that
+were last written longer ago than --older-than, a duration such as 30d or
+12h. Without either flag, --missing is implied.
+
+Removing the entry of a chain loaded in some shell only loses what that
+shell's next export compares against: its diff is kept in CASCADE_DIFF,
+and the entry is written again on the next prompt.`,
+		Example: `  cascade state clean
+  cascade state clean --older-than 30d --missing
+  cascade state clean --older-than 90d --dry-run`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStateClean(cmd.OutOrStdout(), cmd.ErrOrStderr(), opts, time.Now())
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.olderThan, "older-than", "", "Remove entries not written within this long (e.g. 30d)")
+	cmd.Flags().BoolVar(&opts.missing, "missing", false, "Remove entries whose .envrc no longer exists")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Report how many entries would be removed without removing them")
+
+	return cmd
+}
+
+// listStates returns the stored states, warning on stderr about each
+// state file that could not be read.
+func listStates(stderr io.Writer) (*state.Store, []*state.DirState, []state.CorruptFile, error) {
+	store, err := state.NewStore()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("open state store: %w", err)
+	}
+	states, corrupt, err := store.List()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, c := range corrupt {
+		fmt.Fprintf(stderr, "cascade: warning: skipping unreadable state file %s: %v\n", c.File, c.Err)
+	}
+	return store, states, corrupt, nil
+}
+
+func runStateList(stdout, stderr io.Writer, jsonOutput bool) error {
+	_, states, corrupt, err := listStates(stderr)
+	if err != nil {
+		return err
+	}
+
+	output := StateListOutput{Entries: []StateEntry{}}
+	for _, st := range states {
+		output.Entries = append(output.Entries, StateEntry{
+			Path:      st.Path,
+			Timestamp: st.LastUsed(),
+			Vars:      diffVarCount(st.Diff),
+			Missing:   !fileExists(st.Path),
+			Failures:  st.Failures,
+		})
+	}
+	for _, c := range corrupt {
+		output.Corrupt = append(output.Corrupt, c.File)
+	}
+
+	if jsonOutput {
+		output.OutputHeader = newOutputHeader()
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
+	}
+
+	if len(output.Entries) == 0 {
+		fmt.Fprintln(stdout, "No stored state")
+		return nil
+	}
+
+	home, _ := os.UserHomeDir()
+	fmt.Fprintf(stdout, "%-19s  %4s  %s\n", "SAVED", "VARS", "PATH")
+	for _, e := range output.Entries {
+		line := fmt.Sprintf("%-19s  %4d  %s", e.Timestamp.Local().Format(time.DateTime), e.Vars, shortenPath(e.Path, home))
+		if e.Missing {
+			line += " (missing)"
+		}
+		if e.Failures > 0 {
+			line += fmt.Sprintf(" (%d failed)", e.Failures)
+		}
+		fmt.Fprintln(stdout, line)
+	}
+	return nil
+}
+
+func runStateShow(stdout io.Writer, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolve path: %w", err)
+	}
+	if isDir(abs) {
+		abs = filepath.Join(abs, ".envrc")
+	}
+
+	store, err := state.NewStore()
+	if err != nil {
+		return fmt.Errorf("open state store: %w", err)
+	}
+	st, err := store.Load(abs)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return fmt.Errorf("no state stored for %s", abs)
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(st)
+}
+
+func runStateClean(stdout, stderr io.Writer, opts stateCleanOptions, now time.Time) error {
+	maxAge, err := config.ParseAge(opts.olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid --older-than: %w", err)
+	}
+	if opts.olderThan != "" && maxAge == 0 {
+		return errors.New("invalid --older-than: must be more than 0")
+	}
+	if opts.olderThan == "" {
+		opts.missing = true
+	}
+
+	store, states, _, err := listStates(stderr)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, st := range states {
+		stale := opts.missing && !fileExists(st.Path)
+		old := maxAge > 0 && now.Sub(st.LastUsed()) > maxAge
+		if !stale && !old {
+			continue
+		}
+		if !opts.dryRun {
+			if err := store.Delete(st.Path); err != nil {
+				return err
+			}
+		}
+		removed++
+	}
+
+	noun := "entries"
+	if removed == 1 {
+		noun = "entry"
+	}
+	if opts.dryRun {
+		fmt.Fprintf(stdout, "Would remove %d state %s\n", removed, noun)
+	} else {
+		fmt.Fprintf(stdout, "Removed %d state %s\n", removed, noun)
+	}
+	return nil
+}
+
+// diffVarCount returns how many variables diff sets or unsets.
+func diffVarCount(diff *env.EnvDiff) int {
+	if diff == nil {
+		return 0
+	}
+	names := make(map[string]bool, len(diff.Next)+len(diff.Sensitive))
+	for name := range diff.Prev {
+		names[name] = true
+	}
+	for name := range diff.Next {
+		names[name] = true
+	}
+	for _, name := range diff.Sensitive {
+		names[name] = true
+	}
+	return len(names)
+}
+
+// fileExists reports whether path names an existing file or symlink.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/state"
+)
+
+// setupStateStore points the state store at a temporary directory and saves
+// an entry for an .envrc that exists and one for an .envrc that does not.
+func setupStateStore(t *testing.T) (store *state.Store, kept, missing string) {
+	t.Helper()
+
+	root := t.TempDir()
+	t.Setenv(dataDirEnv, filepath.Join(root, "data"))
+	store, err := state.NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept = filepath.Join(root, "kept", ".envrc")
+	if err := os.MkdirAll(filepath.Dir(kept), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kept, []byte("export A=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing = filepath.Join(root, "gone", ".envrc")
+
+	diff := env.BuildEnvDiff(env.Env{"B": "old"}, env.Env{"A": "1", "B": "new"})
+	for _, path := range []string{kept, missing} {
+		if err := store.Save(path, "hash", diff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, kept, missing
+}
+
+func TestRunStateList(t *testing.T) {
+	_, kept, missing := setupStateStore(t)
+	var stdout, stderr bytes.Buffer
+
+	if err := runStateList(&stdout, &stderr, true); err != nil {
+		t.Fatal(err)
+	}
+	var output StateListOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatalf("parse: %v\n%s", err, stdout.String())
+	}
+	if len(output.Entries) != 2 {
+		t.Fatalf("entries = %+v, want 2", output.Entries)
+	}
+	for _, e := range output.Entries {
+		if e.Vars != 2 || e.Timestamp.IsZero() || e.Missing != (e.Path == missing) {
+			t.Errorf("entry = %+v, want 2 vars, a timestamp, and missing only for %s", e, missing)
+		}
+	}
+
+	stdout.Reset()
+	if err := runStateList(&stdout, &stderr, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), kept) || !strings.Contains(stdout.String(), missing+" (missing)") {
+		t.Errorf("list output =\n%s\nwant both entries, %s marked missing", stdout.String(), missing)
+	}
+}
+
+func TestRunStateClean(t *testing.T) {
+	store, kept, missing := setupStateStore(t)
+	var stdout, stderr bytes.Buffer
+	exists := func(path string) bool {
+		st, err := store.Load(path)
+		return err == nil && st != nil
+	}
+
+	// Without flags, only entries for deleted files go
+	if err := runStateClean(&stdout, &stderr, stateCleanOptions{dryRun: true}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "Would remove 1 state entry\n" || !exists(missing) {
+		t.Errorf("dry run: %q, want one entry counted and none removed", stdout.String())
+	}
+	stdout.Reset()
+	if err := runStateClean(&stdout, &stderr, stateCleanOptions{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if exists(missing) || !exists(kept) {
+		t.Errorf("clean removed the wrong entries: %q", stdout.String())
+	}
+
+	// --older-than alone does not remove recent entries for existing files
+	if err := runStateClean(&stdout, &stderr, stateCleanOptions{olderThan: "30d"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(kept) {
+		t.Error("clean --older-than 30d removed a recent entry")
+	}
+	if err := runStateClean(&stdout, &stderr, stateCleanOptions{olderThan: "30d"}, time.Now().AddDate(0, 0, 31)); err != nil {
+		t.Fatal(err)
+	}
+	if exists(kept) {
+		t.Error("clean --older-than 30d kept an entry 31 days old")
+	}
+
+	if err := runStateClean(&stdout, &stderr, stateCleanOptions{olderThan: "soon"}, time.Now()); err == nil {
+		t.Error("clean accepted --older-than soon")
+	}
+}
+
+func TestRunStateShow(t *testing.T) {
+	_, kept, _ := setupStateStore(t)
+	var stdout bytes.Buffer
+
+	// A directory names its .envrc
+	if err := runStateShow(&stdout, filepath.Dir(kept)); err != nil {
+		t.Fatal(err)
+	}
+	var st state.DirState
+	if err := json.Unmarshal(stdout.Bytes(), &st); err != nil {
+		t.Fatalf("parse: %v\n%s", err, stdout.String())
+	}
+	if st.Path != kept || st.Diff == nil || st.Diff.Next["B"] != "new" {
+		t.Errorf("show = %+v, want the diff stored for %s", st, kept)
+	}
+
+	if err := runStateShow(&stdout, filepath.Join(t.TempDir(), ".envrc")); err == nil || !strings.Contains(err.Error(), "no state stored") {
+		t.Errorf("show without state: err = %v, want no state stored", err)
+	}
+}
@@ -1,35 +1,109 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/config"
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/policy"
+	"github.com/unrss/cascade/internal/run"
+	"github.com/unrss/cascade/internal/shell"
+	"github.com/unrss/cascade/internal/state"
 )
 
 // StatusOutput is the JSON representation of cascade status.
 type StatusOutput struct {
-	Active          bool              `json:"active"`
-	Directory       string            `json:"directory,omitempty"`
-	Chain           []ChainEntry      `json:"chain"`
-	Variables       map[string]string `json:"variables,omitempty"`
-	Watches         []WatchEntry      `json:"watches,omitempty"`
-	TrustedSubtrees []string          `json:"trusted_subtrees,omitempty"`
+	OutputHeader
+	Active          bool                   `json:"active"`
+	Directory       string                 `json:"directory,omitempty"`
+	Target          string                 `json:"target,omitempty"` // Analyzed directory, when --dir is not the working directory
+	Chain           []ChainEntry           `json:"chain"`
+	Variables       map[string]StatusValue `json:"variables,omitempty"`
+	Watches         []WatchEntry           `json:"watches,omitempty"`
+	TrustedSubtrees []string               `json:"trusted_subtrees,omitempty"`
+	Refresh         *RefreshStatus         `json:"refresh,omitempty"`
+	Divergence      *Divergence            `json:"divergence,omitempty"`
+
+	// Added lists, for path-like variables, the entries the chain added to
+	// the value the shell had before, in order.
+	Added map[string][]string `json:"added,omitempty"`
+
+	// Pending lists the files of the chain that are not allowed yet, so
+	// export skips them, deepest last. CASCADE_DIR stays at the deepest
+	// allowed file above them.
+	Pending []string `json:"pending,omitempty"`
+
+	// StoreNotWritable says why nothing can be allowed, denied or trusted
+	// when the allow store's directory cannot be written.
+	StoreNotWritable string `json:"store_not_writable,omitempty"`
+
+	// PolicyError says why the chain's policy file was ignored.
+	PolicyError string `json:"policy_error,omitempty"`
+
+	// HookDetected says whether this shell runs the hook. It is only
+	// checked when the chain has allowed files and $SHELL is a supported
+	// shell, and nil otherwise. When false, HookShell and HookRCFile name
+	// the shell and its RC file, and HookInRCFile says whether the RC file
+	// already loads the hook, so the shell only needs restarting.
+	HookDetected *bool  `json:"hook_detected,omitempty"`
+	HookShell    string `json:"hook_shell,omitempty"`
+	HookRCFile   string `json:"hook_rc_file,omitempty"`
+	HookInRCFile bool   `json:"hook_in_rc_file,omitempty"`
+}
+
+// RefreshStatus describes the last evaluations of the chain, from the state
+// saved by export.
+type RefreshStatus struct {
+	LastRefreshed *time.Time `json:"last_refreshed,omitempty"` // Last successful evaluation, nil if never
+	Failures      int        `json:"failures,omitempty"`       // Consecutive failures since then
+	FailedPath    string     `json:"failed_path,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// StatusValue is the value of a variable set by the chain. JSON shows it as
+// the value itself, or as {"masked": true} without the value when the
+// variable looks like a secret (see --show-secrets).
+type StatusValue struct {
+	Value  string // As human output shows it, masked if Masked
+	Masked bool
+}
+
+func (v StatusValue) MarshalJSON() ([]byte, error) {
+	if v.Masked {
+		return []byte(`{"masked":true}`), nil
+	}
+	return json.Marshal(v.Value)
 }
 
 // ChainEntry represents a single .envrc file in the chain.
 type ChainEntry struct {
 	Path   string `json:"path"`
 	Exists bool   `json:"exists"`
-	Status string `json:"status"` // "allowed", "denied", "not_allowed"
+	Status string `json:"status"`           // "allowed", "denied", "not_allowed"
+	Source string `json:"source,omitempty"` // "global", "workspace", "trust", "whitelist", "system", "policy"
+
+	// PolicyMismatch is set for a file not allowed though the chain's
+	// policy file lists it, because it changed since the policy pinned it
+	PolicyMismatch bool `json:"policy_mismatch,omitempty"`
+
+	// Ancestor files this .envrc pulled in with source_up at the last export
+	Sourced []string `json:"sourced,omitempty"`
 }
 
 // WatchEntry represents a watched file.
@@ -38,48 +112,228 @@ type WatchEntry struct {
 	Exists  bool   `json:"exists"`
 	Changed bool   `json:"changed"`
 	Extra   bool   `json:"extra,omitempty"` // True if added via watch_file (not an .envrc)
+	Dir     bool   `json:"dir,omitempty"`   // True if added via watch_dir: changes with its entries
 }
 
-func newStatusCmd() *cobra.Command {
+func newStatusCmd(stdlib string) *cobra.Command {
 	var jsonOutput bool
+	var full bool
+	var dir string
+	var watch, showSecrets bool
+	var interval time.Duration
 
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show cascade status for the current directory",
-		Long:  `Display the current cascade state including loaded .envrc files and environment changes.`,
-		Args:  cobra.NoArgs,
+		Long: `Display the current cascade state including loaded .envrc files and environment changes.
+
+Status also compares this shell's environment with what the .envrc chain
+would set, and lists variables that are missing or differ. The expected
+values come from the state saved by the last prompt when it is current,
+otherwise from evaluating the chain (using the evaluation cache).
+
+With --dir, the chain and its refresh state are shown for another
+directory. "Active", the variables set, and the watched files still
+describe this shell, and the comparison with this shell is skipped.
+
+With --watch, status is gathered again every --interval until interrupted,
+to follow an .envrc that keeps re-evaluating or whose variables flap. The
+screen is cleared between samples, and variables that changed since the
+previous sample are marked. This shell's environment is the one status
+was started in; the chain, watched files, last refresh and comparison are
+sampled afresh. With --json, each sample is written as one line of JSON.
+
+Values of variables that look like secrets (names containing SECRET, TOKEN,
+PASSWORD or CREDENTIAL or ending in KEY, and those matching mask_patterns
+or cache_exclude) are masked, and --json shows them as {"masked": true}
+without the value; --show-secrets shows them.`,
+		Example: `  cascade status
+  cascade status --dir ~/work/api
+  cascade status --json | jq .chain
+  cascade status --watch --interval 5s
+  cascade status --watch --json | jq -c .divergence`,
+		Annotations: map[string]string{envAnnotation: shellEnv},
+		Args:        cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runStatus(cmd.OutOrStdout(), jsonOutput)
+			current := env.FromGoEnv(os.Environ())
+			if !watch {
+				if cmd.Flags().Changed("interval") {
+					return errors.New("--interval requires --watch")
+				}
+				return runStatus(cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, current, dir, jsonOutput, full, showSecrets)
+			}
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive, got %s", interval)
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+			return runStatusWatch(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, current, dir, jsonOutput, full, showSecrets, interval)
 		},
 	}
 
 	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&full, "full", false, "Do not truncate long values")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the values of variables that look like secrets")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Show status again every --interval until interrupted")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "Time between samples with --watch")
+	addDirFlag(cmd, &dir)
 
 	return cmd
 }
 
-func runStatus(w io.Writer, jsonOutput bool) error {
-	status, err := gatherStatus()
+// runStatus reports status for the shell environment current and the chain
+// for dir (the working directory if empty).
+func runStatus(w, stderr io.Writer, stdlib string, current env.Env, dir string, jsonOutput, full, showSecrets bool) error {
+	target, err := resolveTargetDir(dir)
+	if err != nil {
+		return err
+	}
+	status, err := sampleStatus(stderr, stdlib, current, target, newSecretMask(showSecrets))
 	if err != nil {
 		return err
 	}
 
 	if jsonOutput {
+		status.OutputHeader = newOutputHeader()
 		return outputJSON(w, status)
 	}
 
-	return outputHuman(w, status)
+	return outputHuman(w, status, full, nil)
 }
 
-func gatherStatus() (*StatusOutput, error) {
+// runStatusWatch reports status like runStatus every interval until ctx is
+// done, which is not an error. Human output clears a terminal before each
+// sample and marks the variables that changed since the previous one; JSON
+// output is a stream of StatusOutput objects, one per line.
+func runStatusWatch(ctx context.Context, w, stderr io.Writer, stdlib string, current env.Env, dir string, jsonOutput, full, showSecrets bool, interval time.Duration) error {
+	target, err := resolveTargetDir(dir)
+	if err != nil {
+		return err
+	}
+	clearScreen := false
+	if f, ok := w.(*os.File); ok {
+		clearScreen = term.IsTerminal(int(f.Fd()))
+	}
+	enc := json.NewEncoder(w)
+
+	mask := newSecretMask(showSecrets)
+	var prev *StatusOutput
+	for {
+		status, err := sampleStatus(stderr, stdlib, current, target, mask)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			status.OutputHeader = newOutputHeader()
+			if err := enc.Encode(status); err != nil {
+				return err
+			}
+		} else {
+			if clearScreen {
+				fmt.Fprint(w, "\033[H\033[2J")
+			} else if prev != nil {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "Every %s: cascade status    %s\n\n", interval, time.Now().Format(time.TimeOnly))
+			if err := outputHuman(w, status, full, changedVariables(prev, status)); err != nil {
+				return err
+			}
+		}
+		prev = status
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// changedVariables returns the names of the variables whose value in the
+// shell, or whose divergence from the chain, differs between samples prev
+// and next. It is nil for the first sample.
+func changedVariables(prev, next *StatusOutput) map[string]bool {
+	if prev == nil {
+		return nil
+	}
+	changed := make(map[string]bool)
+	for name, value := range next.Variables {
+		if old, ok := prev.Variables[name]; !ok || old != value {
+			changed[name] = true
+		}
+	}
+	for name := range prev.Variables {
+		if _, ok := next.Variables[name]; !ok {
+			changed[name] = true
+		}
+	}
+
+	divergent := func(s *StatusOutput) map[string]string {
+		m := make(map[string]string)
+		if s.Divergence != nil {
+			for _, v := range s.Divergence.Variables {
+				m[v.Name] = v.Reason + "\x00" + strings.Join(v.MissingEntries, "\x00")
+			}
+		}
+		return m
+	}
+	before, after := divergent(prev), divergent(next)
+	for name, v := range after {
+		if old, ok := before[name]; !ok || old != v {
+			changed[name] = true
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed[name] = true
+		}
+	}
+	return changed
+}
+
+// sampleStatus gathers status for target and compares the shell
+// environment current with the chain, unless target is another directory.
+// The values of the variables set are masked with mask.
+func sampleStatus(stderr io.Writer, stdlib string, current env.Env, target string, mask secretMask) (*StatusOutput, error) {
+	status, err := gatherStatus(current, target)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range status.Variables {
+		if value, masked := mask.value(name, v.Value); masked {
+			// The added entries would show parts of the value
+			status.Variables[name] = StatusValue{Value: value, Masked: true}
+			delete(status.Added, name)
+		}
+	}
+
+	// Comparing this shell with another directory's chain says nothing
+	// useful. Best effort: status is still useful when the chain cannot be
+	// planned.
+	if status.Target == "" {
+		if plan, err := planDir(target); err == nil {
+			status.Divergence, err = gatherDivergence(stderr, stdlib, plan, current)
+			if err != nil {
+				fmt.Fprintf(stderr, "cascade: warning: cannot compare environment: %v\n", err)
+			}
+		}
+	}
+	return status, nil
+}
+
+// gatherStatus describes the chain for target and the cascade state
+// recorded in current, the shell's environment.
+func gatherStatus(current env.Env, target string) (*StatusOutput, error) {
 	status := &StatusOutput{
 		Chain:     []ChainEntry{},
-		Variables: make(map[string]string),
+		Variables: make(map[string]StatusValue),
 		Watches:   []WatchEntry{},
 	}
 
 	// Check if cascade is active
-	cascadeDir := os.Getenv("CASCADE_DIR")
+	cascadeDir := current["CASCADE_DIR"]
 	status.Active = cascadeDir != ""
 	status.Directory = cascadeDir
 
@@ -89,51 +343,76 @@ func gatherStatus() (*StatusOutput, error) {
 		return nil, fmt.Errorf("get cascade root: %w", err)
 	}
 
-	// Get current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("get working directory: %w", err)
+	if !isShellDir(target) {
+		status.Target = target
 	}
 
-	// Find .envrc chain from home to cwd
-	chain, err := envrc.FindChain(home, cwd)
+	// Find .envrc chain from home to target, or from where
+	// chain_outside_root starts it if target is not under home
+	chain, _, _, err := envrc.FindRootedChain(home, target, chainOptions())
 	if err != nil {
-		// If cwd is not under home, just use cwd itself
-		chain, err = envrc.FindChain(cwd, cwd)
-		if err != nil {
-			return nil, fmt.Errorf("find envrc chain: %w", err)
-		}
+		return nil, fmt.Errorf("find envrc chain: %w", err)
 	}
 
-	// Create allow store
-	store, err := allow.NewStore()
-	if err != nil {
+	// Create allow store, which can be read even if it cannot be written
+	store, err := openAllowStore()
+	var notWritable *allow.NotWritableError
+	if errors.As(err, &notWritable) {
+		status.StoreNotWritable = notWritableHint(notWritable).Error()
+	} else if err != nil {
 		return nil, fmt.Errorf("create allow store: %w", err)
 	}
 
+	// Files the store leaves not allowed may be allowed by a policy file
+	pol, err := policy.Find(run.ChainDirs(chain), store, cfg)
+	if err != nil {
+		status.PolicyError = err.Error()
+	}
+	resolver := policy.NewResolver(store, pol)
+
 	// Build chain entries (existing files only for display)
 	for _, rc := range chain {
 		if !rc.Exists {
 			continue
 		}
 
+		allowStatus, source := resolver.Explain(rc, cfg)
 		entry := ChainEntry{
-			Path:   rc.Path,
-			Exists: rc.Exists,
-			Status: store.CheckWithWhitelist(rc, cfg).String(),
+			Path:           rc.Path,
+			Exists:         rc.Exists,
+			Status:         allowStatus.String(),
+			Source:         string(source),
+			PolicyMismatch: resolver.Mismatch(rc, allowStatus),
 		}
 		status.Chain = append(status.Chain, entry)
+		if allowStatus == allow.NotAllowed {
+			status.Pending = append(status.Pending, rc.Path)
+		}
 	}
 
+	checkHook(status, current)
+
 	// Parse CASCADE_DIFF to get variables
-	cascadeDiff := os.Getenv("CASCADE_DIFF")
+	cascadeDiff := current["CASCADE_DIFF"]
 	if cascadeDiff != "" {
 		diff, err := env.Unmarshal(cascadeDiff)
 		if err == nil && diff != nil {
 			for k, v := range diff.Next {
-				if v != "" { // Only include set variables, not deletions
-					status.Variables[k] = v
+				if v == "" { // Only include set variables, not deletions
+					continue
 				}
+				status.Variables[k] = StatusValue{Value: v}
+				if treeIsPathLikeVar(k) {
+					if added, _ := pathComponentDiff(diff.Prev[k], v); len(added) > 0 {
+						if status.Added == nil {
+							status.Added = make(map[string][]string)
+						}
+						status.Added[k] = added
+					}
+				}
+			}
+			for _, k := range diff.Sensitive { // Listed, but the value was never recorded
+				status.Variables[k] = StatusValue{Value: env.Redacted}
 			}
 		}
 	}
@@ -145,7 +424,7 @@ func gatherStatus() (*StatusOutput, error) {
 	}
 
 	// Parse CASCADE_WATCHES to get watched files
-	cascadeWatches := os.Getenv("CASCADE_WATCHES")
+	cascadeWatches := current["CASCADE_WATCHES"]
 	if cascadeWatches != "" {
 		watchList, err := env.ParseWatchList(cascadeWatches)
 		if err == nil {
@@ -155,12 +434,26 @@ func gatherStatus() (*StatusOutput, error) {
 					Exists:  ft.Exists,
 					Changed: ft.Check(),
 					Extra:   !envrcPaths[ft.Path], // Extra if not an .envrc file
+					Dir:     ft.Dir,
 				}
 				status.Watches = append(status.Watches, entry)
 			}
 		}
 	}
 
+	// Export keys state by the deepest allowed .envrc
+	for i := len(status.Chain) - 1; i >= 0; i-- {
+		if status.Chain[i].Status == allow.Allowed.String() {
+			if st := loadState(status.Chain[i].Path); st != nil {
+				status.Refresh = refreshStatus(st)
+				for j := range status.Chain {
+					status.Chain[j].Sourced = st.Sourced[status.Chain[j].Path]
+				}
+			}
+			break
+		}
+	}
+
 	// Get trusted subtrees
 	trustedPaths, err := store.ListTrustedSubtrees()
 	if err == nil && len(trustedPaths) > 0 {
@@ -171,30 +464,94 @@ func gatherStatus() (*StatusOutput, error) {
 	return status, nil
 }
 
+// loadState reads the saved state for the chain ending at leafPath.
+// Returns nil if export has never evaluated it.
+func loadState(leafPath string) *state.DirState {
+	stateStore, err := state.NewStore()
+	if err != nil {
+		return nil
+	}
+	st, err := stateStore.Load(leafPath)
+	if err != nil {
+		return nil
+	}
+	return st
+}
+
+// refreshStatus describes the last evaluations recorded in st.
+func refreshStatus(st *state.DirState) *RefreshStatus {
+	refresh := &RefreshStatus{
+		Failures:   st.Failures,
+		FailedPath: st.FailedPath,
+		LastError:  st.LastError,
+	}
+	if !st.Timestamp.IsZero() {
+		refresh.LastRefreshed = &st.Timestamp
+	}
+	return refresh
+}
+
 func outputJSON(w io.Writer, status *StatusOutput) error {
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	return enc.Encode(status)
 }
 
-func outputHuman(w io.Writer, status *StatusOutput) error {
+// checkHook sets status.HookDetected when the hook matters: the chain has
+// allowed files, which nothing loads without it, and $SHELL in current is a
+// shell cascade has a hook for. A shell that ran the hook has
+// CASCADE_HOOK_VERSION, CASCADE_DIR or CASCADE_NEGCACHE set; a hook that
+// looks cascade up on PATH sets no version, but then sets CASCADE_DIR for
+// a chain with allowed files.
+func checkHook(status *StatusOutput, current env.Env) {
+	hasAllowed := slices.ContainsFunc(status.Chain, func(e ChainEntry) bool {
+		return e.Status == allow.Allowed.String()
+	})
+	shellName := supportedShell(current["SHELL"])
+	if !hasAllowed || shellName == "" {
+		return
+	}
+
+	detected := current["CASCADE_HOOK_VERSION"] != "" || current["CASCADE_DIR"] != "" || current[negCacheVar] != ""
+	status.HookDetected = &detected
+	if !detected {
+		status.HookShell = shellName
+		status.HookRCFile, status.HookInRCFile, _ = rcFileHook(shellName)
+	}
+}
+
+// outputHuman prints status for people. Variables in changed, as from
+// changedVariables, are marked as changed since the previous sample.
+func outputHuman(w io.Writer, status *StatusOutput, full bool, changed map[string]bool) error {
 	c := newColorizer(w)
 
 	// Get home directory for path shortening
 	home, _ := os.UserHomeDir()
 
+	// With --dir elsewhere, keep what describes this shell apart from the
+	// analysis of the target directory
+	inShell, forTarget := "", ""
+	if status.Target != "" {
+		inShell = " in this shell"
+		forTarget = " for " + shortenPath(status.Target, home)
+	}
+
 	// Active state
 	if status.Active {
-		fmt.Fprintf(w, "%s\n", c.bold("Cascade is active"))
+		fmt.Fprintf(w, "%s\n", c.bold("Cascade is active"+inShell))
 		fmt.Fprintf(w, "  Directory: %s\n", status.Directory)
 	} else {
-		fmt.Fprintf(w, "%s\n", c.dim("Cascade is not active"))
+		fmt.Fprintf(w, "%s\n", c.dim("Cascade is not active"+inShell))
 	}
 	fmt.Fprintln(w)
 
+	if status.HookDetected != nil && !*status.HookDetected {
+		printMissingHook(w, c, status, home)
+	}
+
 	// .envrc chain
 	if len(status.Chain) > 0 {
-		fmt.Fprintf(w, "%s\n", c.bold(".envrc chain:"))
+		fmt.Fprintf(w, "%s\n", c.bold(".envrc chain"+forTarget+":"))
 		for _, entry := range status.Chain {
 			displayPath := shortenPath(entry.Path, home)
 
@@ -214,16 +571,55 @@ func outputHuman(w io.Writer, status *StatusOutput) error {
 				statusText = entry.Status
 			}
 
+			if label := sourceLabel(entry.Source); label != "" {
+				statusText += c.dim(" via " + label)
+			}
+			if entry.PolicyMismatch {
+				statusText += c.yellow(", differs from the policy")
+			}
+
 			fmt.Fprintf(w, "  %s %s (%s)\n", icon, displayPath, statusText)
+			for _, file := range entry.Sourced {
+				fmt.Fprintf(w, "      %s %s\n", c.dim("↳ sources"), shortenPath(file, home))
+			}
 		}
 		fmt.Fprintln(w)
 	} else {
-		fmt.Fprintf(w, "%s\n\n", c.dim("No .envrc files found"))
+		fmt.Fprintf(w, "%s\n\n", c.dim("No .envrc files found"+forTarget))
+	}
+	if len(status.Pending) > 0 {
+		printPending(w, c, status.Pending, home)
+	}
+	if status.StoreNotWritable != "" {
+		fmt.Fprintf(w, "%s %s\n\n", c.yellow("⚠"), status.StoreNotWritable)
+	}
+	if status.PolicyError != "" {
+		fmt.Fprintf(w, "%s ignoring the policy file: %s\n\n", c.yellow("⚠"), status.PolicyError)
+	}
+
+	// Last evaluation
+	if r := status.Refresh; r != nil {
+		last := "never"
+		if r.LastRefreshed != nil {
+			last = formatAgo(time.Since(*r.LastRefreshed))
+		}
+		if r.Failures > 0 {
+			fmt.Fprintf(w, "Last refreshed: %s %s\n", last, c.red("(stale — refresh failing)"))
+			fmt.Fprintf(w, "  %s failed %d times in a row: %s\n", shortenPath(r.FailedPath, home), r.Failures, r.LastError)
+		} else {
+			fmt.Fprintf(w, "Last refreshed: %s\n", last)
+		}
+		fmt.Fprintln(w)
+	}
+
+	// Comparison with this shell's environment
+	if status.Divergence != nil {
+		printDivergence(w, c, status.Divergence, status.Active, changed)
 	}
 
 	// Variables set (only if cascade is active and has variables)
 	if status.Active && len(status.Variables) > 0 {
-		fmt.Fprintf(w, "%s\n", c.bold("Variables set:"))
+		fmt.Fprintf(w, "%s\n", c.bold("Variables set"+inShell+":"))
 
 		// Sort variable names for consistent output
 		varNames := make([]string, 0, len(status.Variables))
@@ -241,17 +637,28 @@ func outputHuman(w io.Writer, status *StatusOutput) error {
 		}
 
 		for _, name := range varNames {
-			// Truncate long values for display
-			value := status.Variables[name]
-			displayValue := truncateValue(value, 50)
-			fmt.Fprintf(w, "  %-*s = %s\n", maxLen, name, displayValue)
+			// A path-like variable shows the entries added to it, and
+			// other long values are truncated
+			if added := status.Added[name]; len(added) > 0 && !full {
+				entries := make([]string, len(added))
+				for i, entry := range added {
+					entries[i] = shortenPath(entry, home)
+				}
+				fmt.Fprintf(w, "  %-*s += %s%s\n", maxLen, name, strings.Join(entries, ", "), changedMark(c, changed[name]))
+				continue
+			}
+			displayValue := status.Variables[name].Value
+			if !full {
+				displayValue = truncateValue(displayValue, valueWidth())
+			}
+			fmt.Fprintf(w, "  %-*s = %s%s\n", maxLen, name, displayValue, changedMark(c, changed[name]))
 		}
 		fmt.Fprintln(w)
 	}
 
 	// Watched files (only if cascade is active and has watches)
 	if status.Active && len(status.Watches) > 0 {
-		fmt.Fprintf(w, "%s\n", c.bold("Watched files:"))
+		fmt.Fprintf(w, "%s\n", c.bold("Watched files"+inShell+":"))
 		for _, watch := range status.Watches {
 			displayPath := shortenPath(watch.Path, home)
 
@@ -262,7 +669,9 @@ func outputHuman(w io.Writer, status *StatusOutput) error {
 				changeStatus = c.dim("unchanged")
 			}
 
-			if watch.Extra {
+			if watch.Dir {
+				fmt.Fprintf(w, "  %s/ (%s - %s)\n", displayPath, c.dim("directory"), changeStatus)
+			} else if watch.Extra {
 				fmt.Fprintf(w, "  %s (%s - %s)\n", displayPath, c.dim("extra"), changeStatus)
 			} else {
 				fmt.Fprintf(w, "  %s (%s)\n", displayPath, changeStatus)
@@ -283,20 +692,115 @@ func outputHuman(w io.Writer, status *StatusOutput) error {
 	return nil
 }
 
+// changedMark returns the suffix that marks a changed line in watch mode.
+func changedMark(c *colorizer, changed bool) string {
+	if !changed {
+		return ""
+	}
+	return "  " + c.yellow("(changed)")
+}
+
+// sourceLabel describes where a non-default allow/deny decision came from.
+// Returns empty for the global store, which is the default.
+func sourceLabel(source string) string {
+	switch allow.Source(source) {
+	case allow.SourceWorkspace:
+		return "workspace store"
+	case allow.SourceTrust:
+		return "trusted subtree"
+	case allow.SourceWhitelist:
+		return "whitelist"
+	case allow.SourceSystem:
+		return "system store"
+	case allow.SourcePolicy:
+		return "policy"
+	default:
+		return ""
+	}
+}
+
 // shortenPath replaces home directory prefix with ~
 func shortenPath(path, home string) string {
 	if home != "" {
-		if rel, err := filepath.Rel(home, path); err == nil && !filepath.IsAbs(rel) {
-			return "~/" + rel
+		rel, err := filepath.Rel(home, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "~" + string(filepath.Separator) + rel
 		}
 	}
 	return path
 }
 
-// truncateValue shortens long values for display
+// formatAgo describes a duration in the past, e.g. "23 minutes ago".
+func formatAgo(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// valueWidth returns the value_width setting, or its default when no
+// config was loaded.
+func valueWidth() int {
+	if cfg == nil {
+		return config.DefaultValueWidth
+	}
+	return cfg.ValueWidth
+}
+
+// truncateValue shortens long values for display. A maxLen of 0 or less
+// never truncates.
 func truncateValue(value string, maxLen int) string {
-	if len(value) <= maxLen {
+	if maxLen <= 0 || len(value) <= maxLen {
 		return value
 	}
+	if maxLen <= 3 {
+		return value[:maxLen]
+	}
 	return value[:maxLen-3] + "..."
 }
+
+// printPending lists the files export skips until they are allowed.
+func printPending(w io.Writer, c *colorizer, pending []string, home string) {
+	noun := "files"
+	if len(pending) == 1 {
+		noun = "file"
+	}
+	fmt.Fprintf(w, "%s %s\n", c.yellow("⚠"), c.bold(fmt.Sprintf("%d %s pending approval:", len(pending), noun)))
+	for _, path := range pending {
+		fmt.Fprintf(w, "  %s\n", shortenPath(path, home))
+	}
+	fmt.Fprintf(w, "  Run `cascade allow %s` to load it.\n", shortenPath(pending[len(pending)-1], home))
+	fmt.Fprintln(w)
+}
+
+// printMissingHook explains why nothing is loaded in this shell: the hook
+// is in the shell's RC file but the shell predates it, or it is missing.
+func printMissingHook(w io.Writer, c *colorizer, status *StatusOutput, home string) {
+	rcFile := shortenPath(status.HookRCFile, home)
+	switch {
+	case status.HookInRCFile:
+		fmt.Fprintf(w, "%s %s\n", c.yellow("⚠"), c.bold("Hook not loaded in this shell"))
+		fmt.Fprintf(w, "  %s loads it, but this shell started before it was added.\n", rcFile)
+		fmt.Fprintf(w, "  Start a new shell, or run: source %s\n", rcFile)
+	case rcFile != "":
+		fmt.Fprintf(w, "%s %s\n", c.yellow("⚠"), c.bold("Hook not detected — allowed .envrc files are not loaded"))
+		fmt.Fprintf(w, "  Add to %s: %s\n", rcFile, shell.LoadLine(status.HookShell))
+	default:
+		fmt.Fprintf(w, "%s %s\n", c.yellow("⚠"), c.bold("Hook not detected — allowed .envrc files are not loaded"))
+		fmt.Fprintf(w, "  Load it in your shell's startup file: %s\n", shell.LoadLine(status.HookShell))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+)
+
+func TestChangedVariables(t *testing.T) {
+	prev := &StatusOutput{
+		Variables: map[string]StatusValue{"SAME": {Value: "1"}, "FLAPS": {Value: "a"}, "GONE": {Value: "x"}},
+		Divergence: &Divergence{Variables: []DivergentVar{
+			{Name: "STEADY", Reason: "missing"},
+			{Name: "WORSE", Reason: "differs"},
+		}},
+	}
+	next := &StatusOutput{
+		Variables: map[string]StatusValue{"SAME": {Value: "1"}, "FLAPS": {Value: "b"}, "NEW": {Value: "y"}},
+		Divergence: &Divergence{Variables: []DivergentVar{
+			{Name: "STEADY", Reason: "missing"},
+			{Name: "WORSE", Reason: "differs", MissingEntries: []string{"/opt/bin"}},
+		}},
+	}
+
+	if got := changedVariables(nil, next); got != nil {
+		t.Errorf("changedVariables(nil, next) = %v, want nil for the first sample", got)
+	}
+	got := changedVariables(prev, next)
+	for _, name := range []string{"FLAPS", "GONE", "NEW", "WORSE"} {
+		if !got[name] {
+			t.Errorf("%s not reported as changed", name)
+		}
+	}
+	for _, name := range []string{"SAME", "STEADY"} {
+		if got[name] {
+			t.Errorf("%s reported as changed", name)
+		}
+	}
+
+	var out bytes.Buffer
+	next.Active = true
+	if err := outputHuman(&out, next, false, got); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		marked := strings.HasSuffix(line, "(changed)")
+		if strings.Contains(line, "SAME") && marked || strings.Contains(line, "FLAPS") && !marked {
+			t.Errorf("line %q marked = %v", line, marked)
+		}
+	}
+}
+
+func TestOutputHuman_AddedEntries(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	long := strings.Repeat("x", 100)
+	status := &StatusOutput{
+		Active: true,
+		Variables: map[string]StatusValue{
+			"PATH": {Value: filepath.Join(home, "project", "bin") + ":/opt/tools:/usr/bin:/bin"},
+			"NOTE": {Value: long},
+		},
+		Added: map[string][]string{"PATH": {filepath.Join(home, "project", "bin"), "/opt/tools"}},
+	}
+
+	var out bytes.Buffer
+	if err := outputHuman(&out, status, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "PATH += ~/project/bin, /opt/tools\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+	if strings.Contains(out.String(), "/usr/bin") || strings.Contains(out.String(), long) {
+		t.Errorf("output should show only the added entries and truncate long values:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := outputHuman(&out, status, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), status.Variables["PATH"].Value) || !strings.Contains(out.String(), long) {
+		t.Errorf("--full should show whole values:\n%s", out.String())
+	}
+}
+
+func TestCheckHook(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	bashrc := filepath.Join(home, ".bashrc")
+	allowed := []ChainEntry{{Path: "/p/.envrc", Exists: true, Status: allow.Allowed.String()}}
+	bash := env.Env{"SHELL": "/bin/bash"}
+
+	check := func(chain []ChainEntry, current env.Env) *StatusOutput {
+		status := &StatusOutput{Chain: chain}
+		checkHook(status, current)
+		return status
+	}
+
+	// Not checked when nothing would be loaded, or for an unknown shell
+	if s := check([]ChainEntry{{Path: "/p/.envrc", Exists: true, Status: allow.NotAllowed.String()}}, bash); s.HookDetected != nil {
+		t.Errorf("checked without allowed files: %+v", s)
+	}
+	if s := check(allowed, env.Env{"SHELL": "/bin/tcsh"}); s.HookDetected != nil {
+		t.Errorf("checked for an unsupported shell: %+v", s)
+	}
+
+	for _, name := range []string{"CASCADE_HOOK_VERSION", "CASCADE_DIR", negCacheVar} {
+		if s := check(allowed, env.Env{"SHELL": "/bin/bash", name: "x"}); s.HookDetected == nil || !*s.HookDetected {
+			t.Errorf("hook not detected with %s set: %+v", name, s)
+		}
+	}
+
+	s := check(allowed, bash)
+	if s.HookDetected == nil || *s.HookDetected || s.HookShell != "bash" || s.HookInRCFile {
+		t.Errorf("without a hook: %+v, want not detected and not in the RC file", s)
+	}
+
+	if err := os.WriteFile(bashrc, []byte("eval \"$(cascade hook bash)\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = check(allowed, bash)
+	if s.HookDetected == nil || *s.HookDetected || s.HookRCFile != bashrc || !s.HookInRCFile {
+		t.Errorf("with the hook in .bashrc: %+v, want not detected but in %s", s, bashrc)
+	}
+	var out bytes.Buffer
+	printMissingHook(&out, newColorizer(&out), s, home)
+	if !strings.Contains(out.String(), "source ~/.bashrc") {
+		t.Errorf("hint = %q, want to source ~/.bashrc", out.String())
+	}
+}
@@ -15,14 +15,18 @@ import (
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
 	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/run"
 )
 
 // TreeOutput is the JSON representation of cascade tree.
 type TreeOutput struct {
+	OutputHeader
 	Root        string            `json:"root"`
 	Current     string            `json:"current"`
 	Levels      []TreeLevel       `json:"levels"`
 	FinalValues map[string]string `json:"final_values,omitempty"`
+	Masked      []string          `json:"masked,omitempty"`  // Variables in FinalValues whose values are masked
+	Skipped     string            `json:"skipped,omitempty"` // Directory whose skip marker ended the chain
 }
 
 // TreeLevel represents a single directory level in the cascade chain.
@@ -30,21 +34,81 @@ type TreeLevel struct {
 	Path      string     `json:"path"`
 	Dir       string     `json:"dir"`
 	Exists    bool       `json:"exists"`
-	Status    string     `json:"status"` // "allowed", "denied", "not_allowed", "" (if !Exists)
+	Status    string     `json:"status"`           // "allowed", "denied", "not_allowed", "skipped" (--show-ignored), "" (if !Exists)
+	Source    string     `json:"source,omitempty"` // What decided Status, as for status
 	IsCurrent bool       `json:"is_current"`
 	Variables []VarEntry `json:"variables,omitempty"`
+	Sourced   []string   `json:"sourced,omitempty"` // Files pulled in by source_up and source_env (as named by source_env if not evaluated)
+
+	// Includes nests the files sourced with source_env under the level
+	// (set with --all only).
+	Includes []TreeInclude `json:"includes,omitempty"`
+
+	// Seq is the file's position among those its directory contributes
+	// (.envrc, then envrc.d/*.envrc), or 0 if there is only the .envrc.
+	Seq int `json:"seq,omitempty"`
+
+	// Unsearched is why the level was not searched for an .envrc: "other
+	// filesystem" or "timeout" (see cross_filesystem).
+	Unsearched string `json:"unsearched,omitempty"`
+
+	// PolicyMismatch is set for a file not allowed because it changed
+	// since the chain's policy file pinned it.
+	PolicyMismatch bool `json:"policy_mismatch,omitempty"`
+
+	// Set for evaluated levels.
+	Cached     bool  `json:"cached,omitempty"`      // Result reused from the evaluation cache
+	DurationMS int64 `json:"duration_ms,omitempty"` // Time spent evaluating (or loading from cache)
+}
+
+// TreeInclude is a file sourced with source_env by a level, or by another
+// file sourced that way.
+type TreeInclude struct {
+	Path      string        `json:"path"`
+	Exists    bool          `json:"exists"`
+	Status    string        `json:"status"` // As for TreeLevel; "" if !Exists
+	Cycle     bool          `json:"cycle,omitempty"`
+	Variables []VarEntry    `json:"variables,omitempty"` // Previewed from the file, as for levels not evaluated
+	Sourced   []TreeInclude `json:"sourced,omitempty"`   // Not listed for a Cycle
 }
 
 // VarEntry represents a variable change at a tree level.
 type VarEntry struct {
 	Name   string `json:"name"`
-	Action string `json:"action"` // set, prepend, append, override, modify, unset
+	Action string `json:"action"` // set, prepend, append, override, modify, unset, declared
 	Value  string `json:"value,omitempty"`
+
+	// Static marks an entry read from a level that was not evaluated (not
+	// allowed or denied) instead of observed: "declared" for an export or
+	// PATH_add, "unset" for an unset. Its value is as written in the file.
+	Static bool `json:"static,omitempty"`
+
+	// Added and Removed list the components changed at this level,
+	// for path-like and merged (merge_var) variables only.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+
+	// Masked is set when the variable looks like a secret and its value
+	// and components are masked (see --show-secrets).
+	Masked bool `json:"masked,omitempty"`
+}
+
+// treeOptions holds the tree command's flags.
+type treeOptions struct {
+	json    bool
+	values  bool
+	full    bool
+	fresh   bool // Evaluate every level instead of reusing cached results
+	profile bool // Report per-level evaluation time and cache hits
+
+	all         bool   // Nest the files sourced with source_env under each level
+	showSecrets bool   // Do not mask the values of variables that look like secrets
+	showIgnored bool   // List levels left out by a skip marker
+	dir         string // Directory to analyze instead of the working directory
 }
 
 func newTreeCmd(stdlib string) *cobra.Command {
-	var jsonOutput bool
-	var showValues bool
+	var opts treeOptions
 
 	cmd := &cobra.Command{
 		Use:   "tree [VAR...]",
@@ -56,8 +120,32 @@ The tree shows each directory from the cascade root to the current
 directory, with the trust status of each .envrc file and the
 variables it sets.
 
-Examples:
-  # Show the full cascade tree
+Files that are not allowed or are denied are not run. For them, the tree
+previews the variables they appear to set, read from their top-level
+export, PATH_add, unset and source_env lines, dimmed and marked "(not
+evaluated)"; the preview misses anything conditional or computed.
+
+Levels whose .envrc and upstream environment are unchanged since the last
+export reuse its cached results instead of being evaluated again. Use
+--profile (or --timings) to see which levels were cached and how long the
+others took, and --fresh to evaluate them all. The JSON output always
+includes them.
+
+A directory containing a .cascade-skip marker (or a name listed in
+skip_markers) ends the chain: it and everything below contribute nothing.
+Use --show-ignored to list the .envrc files that were left out.
+
+As in status, values of variables that look like secrets are masked unless
+--show-secrets is given.
+
+Use --all to show the files each level sources with source_env (or
+source_env_if_exists) nested under it, with their own trust status and the
+variables they appear to set, previewed as for files not evaluated. A file
+that sources one already being sourced is marked "(cycle)" and not followed.
+
+Use --dir to show the chain for another directory without changing into it
+(and so without running your own hook there).`,
+		Example: `  # Show the full cascade tree
   cascade tree
 
   # Show tree with variable values
@@ -69,99 +157,107 @@ Examples:
   # Show multiple variables with their values
   cascade tree PATH GOPATH --values
 
+  # Show values without truncation
+  cascade tree --values --full
+
+  # Include files sourced with source_env
+  cascade tree --all
+
   # Output as JSON for scripting
   cascade tree --json`,
-		Args: cobra.ArbitraryArgs,
+		Annotations:       map[string]string{envAnnotation: "CASCADE_REFRESH: When set, every level is evaluated again, as with --fresh"},
+		Args:              cobra.ArbitraryArgs,
+		ValidArgsFunction: completeLoadedVars,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runTree(cmd.OutOrStdout(), cmd.ErrOrStderr(), args, stdlib, jsonOutput, showValues)
+			return runTree(cmd.OutOrStdout(), cmd.ErrOrStderr(), args, stdlib, opts)
 		},
 	}
 
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
-	cmd.Flags().BoolVarP(&showValues, "values", "v", false, "Show variable values")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVarP(&opts.values, "values", "v", false, "Show variable values")
+	cmd.Flags().BoolVar(&opts.full, "full", false, "Do not truncate long values")
+	cmd.Flags().BoolVar(&opts.fresh, "fresh", false, "Evaluate every level, ignoring cached results")
+	cmd.Flags().BoolVar(&opts.profile, "profile", false, "Show evaluation time and cache hits per level")
+	cmd.Flags().BoolVar(&opts.profile, "timings", false, "Same as --profile")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Show files sourced with source_env under the level that sourced them")
+	cmd.Flags().BoolVar(&opts.showIgnored, "show-ignored", false, "Show .envrc files left out by a skip marker")
+	cmd.Flags().BoolVar(&opts.showSecrets, "show-secrets", false, "Show the values of variables that look like secrets")
+	addDirFlag(cmd, &opts.dir)
 
 	return cmd
 }
 
-func runTree(stdout, stderr io.Writer, filterVars []string, stdlib string, jsonOutput, showValues bool) error {
-	output, err := gatherTree(stderr, filterVars, stdlib, showValues)
+func runTree(stdout, stderr io.Writer, filterVars []string, stdlib string, opts treeOptions) error {
+	output, err := gatherTree(stderr, filterVars, stdlib, opts)
 	if err != nil {
 		return err
 	}
+	maskTree(output, newSecretMask(opts.showSecrets))
 
-	if jsonOutput {
+	if opts.json {
 		return outputTreeJSON(stdout, output)
 	}
 
-	return outputTreeHuman(stdout, output, filterVars, showValues)
+	return outputTreeHuman(stdout, output, filterVars, opts)
 }
 
-func gatherTree(stderr io.Writer, filterVars []string, stdlib string, showValues bool) (*TreeOutput, error) {
-	// Get cascade root for chain traversal (from config or default to home)
-	root, err := cfg.GetCascadeRoot()
-	if err != nil {
-		return nil, fmt.Errorf("get cascade root: %w", err)
-	}
-
-	// Get current working directory
-	cwd, err := os.Getwd()
+func gatherTree(stderr io.Writer, filterVars []string, stdlib string, opts treeOptions) (*TreeOutput, error) {
+	// Find and authorize the .envrc chain from root to the target directory
+	plan, err := planDir(opts.dir)
 	if err != nil {
-		return nil, fmt.Errorf("get working directory: %w", err)
+		return nil, err
 	}
 
 	output := &TreeOutput{
-		Root:    root,
-		Current: cwd,
+		Root:    plan.Root,
+		Current: plan.Target,
 		Levels:  []TreeLevel{},
+		Skipped: plan.Skipped,
 	}
 
-	// Find .envrc chain from root to cwd
-	chain, err := envrc.FindChain(root, cwd)
-	if err != nil {
-		// If cwd is not under root, just use cwd itself
-		chain, err = envrc.FindChain(cwd, cwd)
-		if err != nil {
-			return nil, fmt.Errorf("find envrc chain: %w", err)
-		}
-		output.Root = cwd
-	}
-
-	// Create allow store
-	store, err := allow.NewStore()
-	if err != nil {
-		return nil, fmt.Errorf("create allow store: %w", err)
+	// Build levels from chain, indexing existing files by path
+	statuses := make(map[string]*run.Level, len(plan.Levels))
+	for _, level := range plan.Levels {
+		statuses[level.RC.Path] = level
 	}
 
-	// Build levels from chain and collect allowed RCs for evaluation
-	var allowedRCs []*envrc.RC
 	levelIndices := make(map[string]int) // Map RC path to level index
-
-	for _, rc := range chain {
+	for _, rc := range plan.Chain {
 		level := TreeLevel{
 			Path:      rc.Path,
 			Dir:       rc.Dir,
 			Exists:    rc.Exists,
-			IsCurrent: rc.Dir == cwd,
-		}
+			IsCurrent: rc.Dir == plan.Target,
 
-		// Determine status for existing files
-		if rc.Exists {
-			status := store.CheckWithWhitelist(rc, cfg)
-			level.Status = status.String()
+			Seq:        rc.Seq,
+			Unsearched: rc.Unsearched,
+		}
 
-			// Track allowed RCs for variable evaluation
-			if status == allow.Allowed {
-				levelIndices[rc.Path] = len(output.Levels)
-				allowedRCs = append(allowedRCs, rc)
+		// Determine status for existing files, previewing those that are
+		// not evaluated
+		if l, ok := statuses[rc.Path]; ok {
+			level.Status = l.Status.String()
+			level.Source = string(l.Source)
+			level.PolicyMismatch = l.PolicyMismatch
+			levelIndices[rc.Path] = len(output.Levels)
+			if l.Status == allow.NotAllowed || l.Status == allow.Denied {
+				level.Variables, level.Sourced = declaredVariables(rc, filterVars, opts.values)
+				if opts.all {
+					level.Includes = newIncludeBuilder(filterVars, opts.values, scannedIncludes).build(rc.Path, []string{rc.Path})
+				}
 			}
 		}
 
 		output.Levels = append(output.Levels, level)
 	}
 
+	if opts.showIgnored && plan.Skipped != "" {
+		output.Levels = append(output.Levels, skippedLevels(plan.Skipped, plan.Target)...)
+	}
+
 	// Evaluate allowed RCs to track variable changes
-	if len(allowedRCs) > 0 {
-		finalEnv, err := evaluateVariables(stderr, stdlib, allowedRCs, output, levelIndices, filterVars, showValues)
+	if len(plan.Filter(allow.Allowed)) > 0 {
+		finalEnv, err := evaluateVariables(stderr, stdlib, plan, output, levelIndices, filterVars, opts)
 		if err != nil {
 			// Log warning but don't fail the command
 			fmt.Fprintf(stderr, "cascade: warning: error evaluating variables: %v\n", err)
@@ -181,54 +277,263 @@ func gatherTree(stderr io.Writer, filterVars []string, stdlib string, showValues
 	return output, nil
 }
 
-// evaluateVariables evaluates each allowed RC and tracks variable changes.
-// Returns the final environment after all evaluations (for final value summary).
-func evaluateVariables(stderr io.Writer, stdlib string, allowedRCs []*envrc.RC, output *TreeOutput, levelIndices map[string]int, filterVars []string, showValues bool) (env.Env, error) {
-	// Get self path for evaluator
-	selfPath, err := os.Executable()
-	if err != nil {
-		return nil, fmt.Errorf("get executable path: %w", err)
+// maskTree masks the values of variables that look like secrets in output.
+func maskTree(output *TreeOutput, mask secretMask) {
+	var maskIncludes func([]TreeInclude)
+	maskIncludes = func(includes []TreeInclude) {
+		for i := range includes {
+			mask.entries(includes[i].Variables)
+			maskIncludes(includes[i].Sourced)
+		}
+	}
+	for i := range output.Levels {
+		mask.entries(output.Levels[i].Variables)
+		maskIncludes(output.Levels[i].Includes)
 	}
+	output.Masked = mask.vars(output.FinalValues)
+}
 
-	// Create evaluator
-	evaluator, err := eval.New("", stdlib, selfPath)
-	if err != nil {
-		return nil, fmt.Errorf("create evaluator: %w", err)
+// skippedLevels lists the directories from skipped down to target, which a
+// skip marker left out of the chain. Their .envrc files are only statted,
+// never authorized or evaluated.
+func skippedLevels(skipped, target string) []TreeLevel {
+	dirs := []string{target}
+	for dir := target; dir != skipped; {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+		dirs = append(dirs, dir)
 	}
+	slices.Reverse(dirs)
+
+	levels := make([]TreeLevel, 0, len(dirs))
+	for _, dir := range dirs {
+		path := filepath.Join(dir, ".envrc")
+		_, err := os.Lstat(path)
+		levels = append(levels, TreeLevel{
+			Path:      path,
+			Dir:       dir,
+			Exists:    err == nil,
+			Status:    "skipped",
+			IsCurrent: dir == target,
+		})
+	}
+	return levels
+}
 
-	// Start with current environment (filtered)
-	currentEnv := env.FromGoEnv(os.Environ())
-	workingEnv := currentEnv.Filtered()
+// evaluateVariables evaluates each allowed RC and tracks variable changes.
+// Returns the final environment after all evaluations (for final value
+// summary), with the values of sensitive_env variables redacted.
+//
+// Evaluation starts from the same base export used and goes through the
+// evaluation cache, whose key covers the .envrc content and its entire input
+// environment. A level is therefore only reused when it and everything
+// upstream of it are unchanged; any upstream change misses downstream.
+func evaluateVariables(stderr io.Writer, stdlib string, plan *run.Plan, output *TreeOutput, levelIndices map[string]int, filterVars []string, opts treeOptions) (env.Env, error) {
+	evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled && !opts.fresh)
+	if err != nil {
+		return nil, err
+	}
 
 	// Evaluate each allowed RC in order, tracking variable changes
-	for _, rc := range allowedRCs {
-		prevEnv := workingEnv.Copy()
+	result := run.Run(plan, revertedEnv(stderr), evaluator, run.Options{
+		ContinueOnError: true,
+		CollectDiffs:    true,
+		Progress:        warnOnLevelError(stderr),
+	})
 
-		result, err := evaluator.Evaluate(rc, workingEnv)
-		if err != nil {
-			fmt.Fprintf(stderr, "cascade: warning: error evaluating %s: %v\n", rc.Path, err)
+	for _, level := range plan.Filter(allow.Allowed) {
+		if !level.Evaluated {
 			continue
 		}
+		includes := newIncludeBuilder(filterVars, opts.values, recordedIncludes(level))
 
 		// Find variable changes
-		vars := detectVariableChanges(prevEnv, result.Env, showValues)
+		vars := detectVariableChanges(level.Before, level.After, result.Merge, opts.values)
+		for i := range vars {
+			if vars[i].Value != "" && slices.Contains(result.Sensitive, vars[i].Name) {
+				vars[i].Value = env.Redacted
+			}
+		}
 
 		// Apply filter if specified
 		vars = filterVariables(vars, filterVars)
 
 		// Update the corresponding level
-		if idx, ok := levelIndices[rc.Path]; ok {
+		if idx, ok := levelIndices[level.RC.Path]; ok {
 			output.Levels[idx].Variables = vars
+			output.Levels[idx].Sourced = level.Sourced
+			for _, inc := range includes.children(level.RC.Path) {
+				if !slices.Contains(output.Levels[idx].Sourced, inc.Path) {
+					output.Levels[idx].Sourced = append(output.Levels[idx].Sourced, inc.Path)
+				}
+			}
+			if opts.all {
+				output.Levels[idx].Includes = includes.build(level.RC.Path, []string{level.RC.Path})
+			}
+			output.Levels[idx].Cached = level.Cached
+			output.Levels[idx].DurationMS = level.Duration.Milliseconds()
+		}
+	}
+
+	return redactNames(result.Env, result.Sensitive), nil
+}
+
+// includeBuilder builds the nodes tree --all nests under a level for the
+// files sourced with source_env, from the edges children reports.
+type includeBuilder struct {
+	auth       *lazyAuthorizer
+	filterVars []string
+	showValues bool
+	children   func(from string) []eval.Include
+}
+
+func newIncludeBuilder(filterVars []string, showValues bool, children func(from string) []eval.Include) *includeBuilder {
+	return &includeBuilder{
+		auth:       &lazyAuthorizer{},
+		filterVars: filterVars,
+		showValues: showValues,
+		children:   children,
+	}
+}
+
+// build returns the nodes for the files from sources, once each, and theirs
+// in turn. ancestors holds the files being sourced on the way to from: one
+// sourced again is a cycle, listed without following it.
+func (b *includeBuilder) build(from string, ancestors []string) []TreeInclude {
+	var nodes []TreeInclude
+	seen := make(map[string]bool)
+	for _, inc := range b.children(from) {
+		if seen[inc.Path] {
+			continue
+		}
+		seen[inc.Path] = true
+
+		node := TreeInclude{Path: inc.Path}
+		rc, err := envrc.NewRC(inc.Path)
+		if err == nil && rc.Exists {
+			node.Exists = true
+			status, _ := b.auth.Explain(rc, cfg)
+			node.Status = status.String()
+		}
+		switch {
+		case inc.Cycle || slices.Contains(ancestors, inc.Path):
+			node.Cycle = true
+		case node.Exists:
+			node.Variables, _ = declaredVariables(rc, b.filterVars, b.showValues)
+			node.Sourced = b.build(inc.Path, append(slices.Clone(ancestors), inc.Path))
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// recordedIncludes returns the children function for an evaluated level:
+// what its evaluation recorded sourcing. The level's own .envrc is recorded
+// by path (or as "" by an older stdlib).
+func recordedIncludes(level *run.Level) func(from string) []eval.Include {
+	return func(from string) []eval.Include {
+		var children []eval.Include
+		for _, inc := range level.Includes {
+			if inc.From == from || (inc.From == "" && from == level.RC.Path) {
+				children = append(children, inc)
+			}
+		}
+		return children
+	}
+}
+
+// scannedIncludes is the children function for a level that was not
+// evaluated: the source_env lines envrc.Scan finds in from. A directory
+// names its .envrc; paths holding a variable reference are left out.
+func scannedIncludes(from string) []eval.Include {
+	rc, err := envrc.NewRC(from)
+	if err != nil || !rc.Exists {
+		return nil
+	}
+	_, sourced := declaredVariables(rc, nil, false)
+
+	var children []eval.Include
+	for _, path := range sourced {
+		if strings.ContainsAny(path, "$`~") {
+			continue
+		}
+		if isDir(path) {
+			path = filepath.Join(path, ".envrc")
+		}
+		children = append(children, eval.Include{From: from, Path: path})
+	}
+	return children
+}
+
+// declaredVariables previews what an .envrc that is not evaluated would
+// change, by scanning it for exports, PATH_add, unset and source_env (see
+// envrc.Scan), and returns the static entries with the files it sources.
+// The last export or unset of a variable wins; PATH_add directories are
+// listed as added to PATH. Relative paths are resolved against the file's
+// directory unless they hold a variable reference.
+func declaredVariables(rc *envrc.RC, filterVars []string, showValues bool) ([]VarEntry, []string) {
+	content, err := rc.Content()
+	if err != nil {
+		return nil, nil
+	}
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) || strings.ContainsAny(path, "$`~") {
+			return path
+		}
+		return filepath.Join(rc.Dir, path)
+	}
+
+	var (
+		vars    []VarEntry
+		sourced []string
+		index   = make(map[string]int)
+	)
+	for _, d := range envrc.Scan(content) {
+		if d.Action == envrc.DeclareSource {
+			sourced = append(sourced, resolve(d.Value))
+			continue
 		}
 
-		workingEnv = result.Env
+		i, ok := index[d.Name]
+		if !ok {
+			i = len(vars)
+			index[d.Name] = i
+			vars = append(vars, VarEntry{Name: d.Name, Static: true})
+		}
+		v := &vars[i]
+		switch d.Action {
+		case envrc.DeclareUnset:
+			*v = VarEntry{Name: d.Name, Action: "unset", Static: true}
+		case envrc.DeclarePath:
+			if v.Action != "declared" || v.Value != "" {
+				*v = VarEntry{Name: d.Name, Static: true}
+			}
+			v.Action = "declared"
+			if showValues {
+				// Each PATH_add prepends, so the last is first
+				v.Added = slices.Insert(v.Added, 0, resolve(d.Value))
+			}
+		default:
+			*v = VarEntry{Name: d.Name, Action: "declared", Static: true}
+			if showValues {
+				v.Value = d.Value
+			}
+		}
 	}
 
-	return workingEnv, nil
+	vars = filterVariables(vars, filterVars)
+	slices.SortFunc(vars, func(a, b VarEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return vars, sourced
 }
 
 // detectVariableChanges compares before/after environments and returns variable entries.
-func detectVariableChanges(before, after env.Env, showValues bool) []VarEntry {
+// Path-like and merged (merge_var) variables also carry their added/removed components.
+func detectVariableChanges(before, after env.Env, merge env.MergeSpec, showValues bool) []VarEntry {
 	// Pre-allocate with reasonable capacity
 	entries := make([]VarEntry, 0, len(after))
 
@@ -247,7 +552,9 @@ func detectVariableChanges(before, after env.Env, showValues bool) []VarEntry {
 		if !existed {
 			entry.Action = "set"
 		} else if newVal != oldVal {
-			if treeIsPathLikeVar(key) {
+			if sep, ok := merge[key]; ok {
+				entry.Action = detectListAction(oldVal, newVal, sep)
+			} else if treeIsPathLikeVar(key) {
 				entry.Action = treeDetectPathAction(oldVal, newVal)
 			} else {
 				entry.Action = "override"
@@ -260,6 +567,11 @@ func detectVariableChanges(before, after env.Env, showValues bool) []VarEntry {
 		if showValues {
 			entry.Value = newVal
 		}
+		if sep, ok := merge[key]; ok {
+			entry.Added, entry.Removed = env.ListDiff(oldVal, newVal, sep)
+		} else if treeIsPathLikeVar(key) {
+			entry.Added, entry.Removed = pathComponentDiff(oldVal, newVal)
+		}
 
 		entries = append(entries, entry)
 	}
@@ -276,6 +588,11 @@ func detectVariableChanges(before, after env.Env, showValues bool) []VarEntry {
 				Name:   key,
 				Action: "unset",
 			}
+			if sep, ok := merge[key]; ok {
+				_, entry.Removed = env.ListDiff(before[key], "", sep)
+			} else if treeIsPathLikeVar(key) {
+				_, entry.Removed = pathComponentDiff(before[key], "")
+			}
 			entries = append(entries, entry)
 		}
 	}
@@ -356,13 +673,49 @@ func treeDetectPathAction(oldValue, newValue string) string {
 	return "override"
 }
 
+// pathComponentDiff returns the components of a colon-separated list that
+// were added or removed between oldValue and newValue, in order of
+// appearance. Reordering alone is not a change; duplicates are counted, so
+// adding a second copy of an existing entry reports it as added.
+func pathComponentDiff(oldValue, newValue string) (added, removed []string) {
+	oldParts := filepath.SplitList(oldValue)
+	newParts := filepath.SplitList(newValue)
+
+	remaining := make(map[string]int, len(oldParts))
+	for _, part := range oldParts {
+		remaining[part]++
+	}
+	for _, part := range newParts {
+		if remaining[part] > 0 {
+			remaining[part]--
+			continue
+		}
+		added = append(added, part)
+	}
+
+	kept := make(map[string]int, len(newParts))
+	for _, part := range newParts {
+		kept[part]++
+	}
+	for _, part := range oldParts {
+		if kept[part] > 0 {
+			kept[part]--
+			continue
+		}
+		removed = append(removed, part)
+	}
+
+	return added, removed
+}
+
 func outputTreeJSON(w io.Writer, output *TreeOutput) error {
+	output.OutputHeader = newOutputHeader()
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	return enc.Encode(output)
 }
 
-func outputTreeHuman(w io.Writer, output *TreeOutput, filterVars []string, showValues bool) error {
+func outputTreeHuman(w io.Writer, output *TreeOutput, filterVars []string, opts treeOptions) error {
 	c := newColorizer(w)
 
 	// Get home directory for path shortening
@@ -378,97 +731,232 @@ func outputTreeHuman(w io.Writer, output *TreeOutput, filterVars []string, showV
 
 	if len(existingLevels) == 0 {
 		fmt.Fprintf(w, "%s\n", c.dim("No .envrc files found in cascade chain"))
+		printUnsearchedNote(w, c, output, home)
+		printSkipNote(w, c, output, opts, home)
 		return nil
 	}
 
-	// Render each level
-	for _, level := range existingLevels {
-		displayDir := shortenPath(level.Dir, home)
+	// The chain ends at the working directory unless --dir named another
+	marker := "<- current"
+	if !isShellDir(output.Current) {
+		marker = "<- target"
+	}
 
-		// Add current marker
-		if level.IsCurrent {
-			displayDir += " " + c.dim("<- current")
+	// Render each level
+	for i, level := range existingLevels {
+		// Print directory path, once for the files of a directory
+		if i == 0 || existingLevels[i-1].Dir != level.Dir {
+			displayDir := shortenPath(level.Dir, home)
+			if level.IsCurrent {
+				displayDir += " " + c.dim(marker)
+			}
+			fmt.Fprintln(w, displayDir)
 		}
 
-		// Print directory path
-		fmt.Fprintln(w, displayDir)
-
 		// Print .envrc line with status
-		var icon, statusText string
-		switch level.Status {
-		case "allowed":
-			icon = c.green("\u2713")
-			statusText = c.green("allowed")
-		case "denied":
-			icon = c.red("\u2717")
-			statusText = c.red("denied")
-		case "not allowed":
-			icon = c.yellow("\u26a0")
-			statusText = c.yellow("not allowed")
-		default:
-			icon = "?"
-			statusText = level.Status
+		icon, statusText := statusLabel(c, level.Status)
+		switch {
+		case level.Source == string(allow.SourcePolicy):
+			statusText += " " + c.dim("(policy)")
+		case level.PolicyMismatch:
+			statusText += " " + c.yellow("(differs from the policy)")
+		}
+
+		if opts.profile && level.Status == "allowed" {
+			if level.Cached {
+				statusText += " " + c.dim("(cached)")
+			} else {
+				statusText += " " + c.dim(fmt.Sprintf("(%dms)", level.DurationMS))
+			}
 		}
 
 		// Determine if we have variables to show
 		hasVars := len(level.Variables) > 0
+		if hasVars && level.Variables[0].Static {
+			statusText += " " + c.dim("(not evaluated)")
+		}
+
+		// With --all, files sourced with source_env are shown as nodes (a
+		// level not evaluated lists them as named, maybe by directory)
+		sourced := level.Sourced
+		if opts.all {
+			sourced = slices.DeleteFunc(slices.Clone(sourced), func(path string) bool {
+				return slices.ContainsFunc(level.Includes, func(inc TreeInclude) bool {
+					return inc.Path == path || filepath.Dir(inc.Path) == path
+				})
+			})
+		}
 
 		// Use different tree characters based on whether we have variables
 		if hasVars {
-			fmt.Fprintf(w, "\u251c\u2500\u2500 %s %s %s\n", filepath.Base(level.Path), icon, statusText)
-			renderVariables(w, c, level.Variables, showValues, home)
+			fmt.Fprintf(w, "\u251c\u2500\u2500 %s %s %s\n", level.label(), icon, statusText)
+			renderSourced(w, c, sourced, "\u2502   ", home)
+			renderIncludes(w, c, level.Includes, "\u2502   ", opts, home)
+			renderVariables(w, c, level.Variables, opts.values, opts.full, home)
 		} else {
-			fmt.Fprintf(w, "\u2514\u2500\u2500 %s %s %s\n", filepath.Base(level.Path), icon, statusText)
+			fmt.Fprintf(w, "\u2514\u2500\u2500 %s %s %s\n", level.label(), icon, statusText)
+			renderSourced(w, c, sourced, "    ", home)
+			renderIncludes(w, c, level.Includes, "    ", opts, home)
 		}
 		fmt.Fprintln(w)
 	}
 
 	// Render final value summary when filtering
 	if len(filterVars) > 0 && len(output.FinalValues) > 0 {
-		renderFinalValues(w, c, output.FinalValues, filterVars, home)
+		renderFinalValues(w, c, output.FinalValues, filterVars, opts.full, home)
 	}
 
+	printUnsearchedNote(w, c, output, home)
+	printSkipNote(w, c, output, opts, home)
 	return nil
 }
 
+// label names the level's file within its directory, numbered in
+// evaluation order when the directory contributes more than one.
+func (l TreeLevel) label() string {
+	name := (&envrc.RC{Path: l.Path, Dir: l.Dir}).Label()
+	if l.Seq > 0 {
+		return fmt.Sprintf("%d. %s", l.Seq, name)
+	}
+	return name
+}
+
+// printUnsearchedNote names the first level that was not searched for an
+// .envrc and why; the levels below it were not searched either.
+func printUnsearchedNote(w io.Writer, c *colorizer, output *TreeOutput, home string) {
+	for _, level := range output.Levels {
+		switch level.Unsearched {
+		case "":
+			continue
+		case envrc.UnsearchedOtherFilesystem:
+			fmt.Fprintf(w, "%s\n", c.dim("Not searched from "+shortenPath(level.Dir, home)+" down (other filesystem; see cross_filesystem)"))
+		default:
+			fmt.Fprintf(w, "%s\n", c.dim("Not searched from "+shortenPath(level.Dir, home)+" down ("+level.Unsearched+")"))
+		}
+		return
+	}
+}
+
+// printSkipNote names the directory whose skip marker ended the chain.
+func printSkipNote(w io.Writer, c *colorizer, output *TreeOutput, opts treeOptions, home string) {
+	if output.Skipped == "" || !opts.showIgnored {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", c.dim("Chain skipped from "+shortenPath(output.Skipped, home)+" down (skip marker)"))
+}
+
+// statusLabel returns the icon and text shown for a trust status.
+func statusLabel(c *colorizer, status string) (icon, text string) {
+	switch status {
+	case "allowed":
+		return c.green("\u2713"), c.green("allowed")
+	case "denied":
+		return c.red("\u2717"), c.red("denied")
+	case "not allowed":
+		return c.yellow("\u26a0"), c.yellow("not allowed")
+	case "skipped":
+		return c.dim("-"), c.dim("skipped")
+	default:
+		return "?", status
+	}
+}
+
+// renderSourced lists the files a level pulled in with source_up or
+// source_env.
+func renderSourced(w io.Writer, c *colorizer, sourced []string, prefix, home string) {
+	for _, file := range sourced {
+		fmt.Fprintf(w, "%s%s %s\n", prefix, c.dim("\u21b3 sources"), shortenPath(file, home))
+	}
+}
+
+// renderIncludes lists the files sourced with source_env (tree --all),
+// each with its status and the variables it declares, and the files it
+// sources indented below it.
+func renderIncludes(w io.Writer, c *colorizer, includes []TreeInclude, prefix string, opts treeOptions, home string) {
+	for _, inc := range includes {
+		var label string
+		switch {
+		case inc.Cycle:
+			label = c.dim("(cycle)")
+		case !inc.Exists:
+			label = c.dim("(not found)")
+		default:
+			icon, statusText := statusLabel(c, inc.Status)
+			label = icon + " " + statusText
+		}
+		fmt.Fprintf(w, "%s%s %s %s\n", prefix, c.dim("\u21b3 sources"), shortenPath(inc.Path, home), label)
+
+		nested := prefix + "    "
+		for _, v := range inc.Variables {
+			line := c.dim(v.Name) + " " + c.dim(formatActionSymbol(v.Action))
+			if opts.values && v.Value != "" && len(v.Added) == 0 {
+				value := displayVarValue(v.Name, v.Value, home)
+				if !opts.full {
+					value = truncateValue(value, valueWidth())
+				}
+				line += " " + c.dim(value)
+			}
+			fmt.Fprintf(w, "%s%s\n", nested, line)
+			if opts.values {
+				for _, part := range v.Added {
+					fmt.Fprintf(w, "%s  %s %s\n", nested, c.dim("+"), shortenPath(part, home))
+				}
+			}
+		}
+		renderIncludes(w, c, inc.Sourced, nested, opts, home)
+	}
+}
+
 // renderVariables renders the variable entries under a tree level.
-func renderVariables(w io.Writer, c *colorizer, vars []VarEntry, showValues bool, home string) {
+// Path-like and merged variables list the components added (+) and
+// removed (-) at this level instead of the whole value.
+func renderVariables(w io.Writer, c *colorizer, vars []VarEntry, showValues, full bool, home string) {
 	for i, v := range vars {
 		isLast := i == len(vars)-1
 
-		// Tree connector
-		var connector string
+		// Tree connector, and the prefix for lines nested under it
+		var connector, nested string
 		if isLast {
 			connector = "\u2514\u2500\u2500"
+			nested = "    "
 		} else {
 			connector = "\u251c\u2500\u2500"
+			nested = "\u2502   "
 		}
 
-		// Format action symbol
+		// Format action symbol; what a level not evaluated declares is dimmed
 		actionSymbol := formatActionSymbol(v.Action)
+		name, plus := c.cyan(v.Name), c.green("+")
+		if v.Static {
+			name, plus = c.dim(v.Name), c.dim("+")
+		}
 
 		// Build the line
-		if showValues && v.Value != "" {
-			displayValue := v.Value
-			// Shorten paths in values
-			if treeIsPathLikeVar(v.Name) {
-				displayValue = shortenPathList(displayValue, home)
-			} else {
-				displayValue = shortenPath(displayValue, home)
+		switch {
+		case showValues && len(v.Added)+len(v.Removed) > 0:
+			fmt.Fprintf(w, "\u2502   %s %s %s\n", connector, name, c.dim(actionSymbol))
+			for _, part := range v.Added {
+				fmt.Fprintf(w, "\u2502   %s  %s %s\n", nested, plus, shortenPath(part, home))
 			}
-			// Truncate long values
-			if len(displayValue) > 60 {
-				displayValue = displayValue[:57] + "..."
+			for _, part := range v.Removed {
+				fmt.Fprintf(w, "\u2502   %s  %s %s\n", nested, c.red("-"), c.dim(shortenPath(part, home)))
 			}
-			fmt.Fprintf(w, "\u2502   %s %s %s %s\n", connector, c.cyan(v.Name), c.dim(actionSymbol), c.dim(displayValue))
-		} else {
-			fmt.Fprintf(w, "\u2502   %s %s %s\n", connector, c.cyan(v.Name), c.dim(actionSymbol))
+		case showValues && v.Value != "":
+			displayValue := displayVarValue(v.Name, v.Value, home)
+			if !full {
+				displayValue = truncateValue(displayValue, valueWidth())
+			}
+			fmt.Fprintf(w, "\u2502   %s %s %s %s\n", connector, name, c.dim(actionSymbol), c.dim(displayValue))
+		default:
+			fmt.Fprintf(w, "\u2502   %s %s %s\n", connector, name, c.dim(actionSymbol))
 		}
 	}
 }
 
 // renderFinalValues renders the final value summary for filtered variables.
-func renderFinalValues(w io.Writer, c *colorizer, finalValues map[string]string, filterVars []string, home string) {
+// Path-like variables are listed one component per line, without repeated
+// entries unless full is set.
+func renderFinalValues(w io.Writer, c *colorizer, finalValues map[string]string, filterVars []string, full bool, home string) {
 	fmt.Fprintln(w, c.bold("Final values:"))
 
 	// Iterate in the order specified by filterVars for consistent output
@@ -480,23 +968,50 @@ func renderFinalValues(w io.Writer, c *colorizer, finalValues map[string]string,
 			continue
 		}
 
-		// Shorten the value for display
-		displayValue := val
-		if treeIsPathLikeVar(varName) {
-			displayValue = shortenPathList(displayValue, home)
-		} else {
-			displayValue = shortenPath(displayValue, home)
+		if treeIsPathLikeVar(varName) && val != "" {
+			fmt.Fprintf(w, "  %s =\n", c.cyan(varName))
+			parts := filepath.SplitList(val)
+			total := len(parts)
+			if !full {
+				// A repeated entry is shadowed by its first occurrence
+				seen := make(map[string]bool, len(parts))
+				parts = slices.DeleteFunc(parts, func(part string) bool {
+					dup := seen[part]
+					seen[part] = true
+					return dup
+				})
+			}
+			for _, part := range parts {
+				fmt.Fprintf(w, "    %s\n", shortenPath(part, home))
+			}
+			if hidden := total - len(parts); hidden > 0 {
+				noun := "entries"
+				if hidden == 1 {
+					noun = "entry"
+				}
+				fmt.Fprintf(w, "    %s\n", c.dim(fmt.Sprintf("(%d duplicate %s hidden, see --full)", hidden, noun)))
+			}
+			continue
 		}
 
-		// Truncate very long values
-		if len(displayValue) > 80 {
-			displayValue = displayValue[:77] + "..."
+		// Shorten the value for display, truncating very long values
+		displayValue := displayVarValue(varName, val, home)
+		if !full {
+			displayValue = truncateValue(displayValue, valueWidth())
 		}
 
 		fmt.Fprintf(w, "  %s = %s\n", c.cyan(varName), displayValue)
 	}
 }
 
+// displayVarValue shortens home-relative paths in a value for display.
+func displayVarValue(name, value, home string) string {
+	if treeIsPathLikeVar(name) {
+		return shortenPathList(value, home)
+	}
+	return shortenPath(value, home)
+}
+
 // formatActionSymbol returns a symbol representing the action.
 func formatActionSymbol(action string) string {
 	switch action {
@@ -512,6 +1027,8 @@ func formatActionSymbol(action string) string {
 		return "~="
 	case "unset":
 		return "x"
+	case "declared":
+		return "?="
 	default:
 		return "?"
 	}
@@ -3,7 +3,13 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/unrss/cascade/internal/envrc"
 )
 
 func TestFilterVariables(t *testing.T) {
@@ -274,7 +280,7 @@ func TestDetectVariableChanges(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := detectVariableChanges(tt.before, tt.after, tt.showValues)
+			got := detectVariableChanges(tt.before, tt.after, nil, tt.showValues)
 			if len(got) != len(tt.want) {
 				t.Errorf("detectVariableChanges() returned %d items, want %d", len(got), len(tt.want))
 				t.Errorf("got: %+v", got)
@@ -295,6 +301,185 @@ func TestDetectVariableChanges(t *testing.T) {
 	}
 }
 
+func TestPathComponentDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldValue    string
+		newValue    string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:      "prepend",
+			oldValue:  "/usr/bin:/bin",
+			newValue:  "/work/bin:/work/node_modules/.bin:/usr/bin:/bin",
+			wantAdded: []string{"/work/bin", "/work/node_modules/.bin"},
+		},
+		{
+			name:      "set from empty",
+			oldValue:  "",
+			newValue:  "/a:/b",
+			wantAdded: []string{"/a", "/b"},
+		},
+		{
+			name:        "unset",
+			oldValue:    "/a:/b",
+			newValue:    "",
+			wantRemoved: []string{"/a", "/b"},
+		},
+		{
+			name:        "added and removed",
+			oldValue:    "/a:/b:/c",
+			newValue:    "/x:/a:/c",
+			wantAdded:   []string{"/x"},
+			wantRemoved: []string{"/b"},
+		},
+		{
+			name:     "reordered only",
+			oldValue: "/a:/b:/c",
+			newValue: "/c:/a:/b",
+		},
+		{
+			name:      "duplicate added",
+			oldValue:  "/a:/b",
+			newValue:  "/b:/a:/b",
+			wantAdded: []string{"/b"},
+		},
+		{
+			name:        "duplicate removed",
+			oldValue:    "/a:/b:/a",
+			newValue:    "/a:/b",
+			wantRemoved: []string{"/a"},
+		},
+		{
+			name:     "unchanged",
+			oldValue: "/a:/b",
+			newValue: "/a:/b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := pathComponentDiff(tt.oldValue, tt.newValue)
+			if !slices.Equal(added, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", added, tt.wantAdded)
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestDetectVariableChanges_PathComponents(t *testing.T) {
+	before := map[string]string{"PATH": "/usr/bin:/old", "MANPATH": "/man"}
+	after := map[string]string{"PATH": "/new:/usr/bin", "FOO": "bar"}
+
+	got := detectVariableChanges(before, after, nil, false)
+
+	byName := make(map[string]VarEntry, len(got))
+	for _, v := range got {
+		byName[v.Name] = v
+	}
+
+	path := byName["PATH"]
+	if !slices.Equal(path.Added, []string{"/new"}) || !slices.Equal(path.Removed, []string{"/old"}) {
+		t.Errorf("PATH added/removed = %v/%v, want [/new]/[/old]", path.Added, path.Removed)
+	}
+	if manpath := byName["MANPATH"]; !slices.Equal(manpath.Removed, []string{"/man"}) {
+		t.Errorf("MANPATH removed = %v, want [/man]", manpath.Removed)
+	}
+	if foo := byName["FOO"]; foo.Added != nil || foo.Removed != nil {
+		t.Errorf("FOO should not carry components, got %+v", foo)
+	}
+}
+
+func TestRenderVariables_PathComponents(t *testing.T) {
+	long := "/home/user/" + strings.Repeat("x", 80)
+	vars := []VarEntry{
+		{Name: "NOTE", Action: "set", Value: long},
+		{Name: "PATH", Action: "prepend", Value: "/home/user/work/bin:/usr/bin", Added: []string{"/home/user/work/bin"}},
+	}
+
+	var buf bytes.Buffer
+	renderVariables(&buf, newColorizer(&buf), vars, true, false, "/home/user")
+	out := buf.String()
+
+	if !strings.Contains(out, "+ ~/work/bin") {
+		t.Errorf("output missing added component:\n%s", out)
+	}
+	if strings.Contains(out, "/usr/bin") {
+		t.Errorf("output should only show changed components:\n%s", out)
+	}
+	if !strings.Contains(out, "...") {
+		t.Errorf("long value should be truncated:\n%s", out)
+	}
+
+	buf.Reset()
+	renderVariables(&buf, newColorizer(&buf), vars, true, true, "/home/user")
+	if !strings.Contains(buf.String(), "~/"+strings.Repeat("x", 80)) {
+		t.Errorf("--full output should not truncate:\n%s", buf.String())
+	}
+}
+
+func TestRenderFinalValues_Duplicates(t *testing.T) {
+	finalValues := map[string]string{"PATH": "/home/user/bin:/usr/bin:/home/user/bin:/bin:/usr/bin"}
+
+	var buf bytes.Buffer
+	renderFinalValues(&buf, newColorizer(&buf), finalValues, []string{"PATH"}, false, "/home/user")
+	out := buf.String()
+	if n := strings.Count(out, "~/bin\n"); n != 1 {
+		t.Errorf("~/bin listed %d times, want once:\n%s", n, out)
+	}
+	if !strings.Contains(out, "(2 duplicate entries hidden, see --full)") {
+		t.Errorf("output missing the hidden count:\n%s", out)
+	}
+
+	buf.Reset()
+	renderFinalValues(&buf, newColorizer(&buf), finalValues, []string{"PATH"}, true, "/home/user")
+	if out := buf.String(); strings.Count(out, "~/bin\n") != 2 || strings.Contains(out, "hidden") {
+		t.Errorf("--full should list every entry:\n%s", out)
+	}
+}
+
+func TestDeclaredVariables(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".envrc")
+	content := "export B=1\nexport A=old\nPATH_add bin\nPATH_add /opt/tools\nexport A=new\nunset B\nsource_env ../shared.envrc\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := envrc.NewRC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars, sourced := declaredVariables(rc, nil, true)
+	want := []VarEntry{
+		{Name: "A", Action: "declared", Value: "new", Static: true},
+		{Name: "B", Action: "unset", Static: true},
+		{Name: "PATH", Action: "declared", Added: []string{"/opt/tools", filepath.Join(dir, "bin")}, Static: true},
+	}
+	if len(vars) != len(want) {
+		t.Fatalf("declaredVariables() = %+v, want %+v", vars, want)
+	}
+	for i := range want {
+		if vars[i].Name != want[i].Name || vars[i].Action != want[i].Action || vars[i].Value != want[i].Value ||
+			!slices.Equal(vars[i].Added, want[i].Added) || !vars[i].Static {
+			t.Errorf("declaredVariables()[%d] = %+v, want %+v", i, vars[i], want[i])
+		}
+	}
+	if want := filepath.Join(filepath.Dir(dir), "shared.envrc"); len(sourced) != 1 || sourced[0] != want {
+		t.Errorf("sourced = %v, want [%s]", sourced, want)
+	}
+
+	// Without --values, only names and actions; filters apply
+	vars, _ = declaredVariables(rc, []string{"A"}, false)
+	if len(vars) != 1 || vars[0].Name != "A" || vars[0].Value != "" {
+		t.Errorf("declaredVariables(A) = %+v, want A without a value", vars)
+	}
+}
+
 func TestFormatActionSymbol(t *testing.T) {
 	tests := []struct {
 		action string
@@ -306,6 +491,7 @@ func TestFormatActionSymbol(t *testing.T) {
 		{"override", ":="},
 		{"modify", "~="},
 		{"unset", "x"},
+		{"declared", "?="},
 		{"unknown", "?"},
 		{"", "?"},
 	}
@@ -415,6 +601,35 @@ func TestTreeOutputJSON(t *testing.T) {
 	}
 }
 
+func TestPrintUnsearchedNote(t *testing.T) {
+	output := &TreeOutput{Levels: []TreeLevel{
+		{Dir: "/home/user"},
+		{Dir: "/home/user/mnt", Unsearched: "other filesystem"},
+		{Dir: "/home/user/mnt/data", Unsearched: "other filesystem"},
+	}}
+
+	var buf bytes.Buffer
+	printUnsearchedNote(&buf, newColorizer(&buf), output, "/home/user")
+	want := "Not searched from ~/mnt down (other filesystem; see cross_filesystem)\n"
+	if buf.String() != want {
+		t.Errorf("note = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	output.Levels[1].Unsearched, output.Levels[2].Unsearched = "timeout", "timeout"
+	printUnsearchedNote(&buf, newColorizer(&buf), output, "/home/user")
+	if want := "Not searched from ~/mnt down (timeout)\n"; buf.String() != want {
+		t.Errorf("note = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	output.Levels = output.Levels[:1]
+	printUnsearchedNote(&buf, newColorizer(&buf), output, "/home/user")
+	if buf.Len() != 0 {
+		t.Errorf("note with every level searched = %q, want none", buf.String())
+	}
+}
+
 func TestTreeLevelJSONOmitEmpty(t *testing.T) {
 	// Test that empty Variables slice is omitted from JSON
 	level := TreeLevel{
@@ -3,9 +3,8 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
-	"sort"
 
 	"github.com/spf13/cobra"
 
@@ -14,8 +13,9 @@ import (
 
 func newTrustCmd() *cobra.Command {
 	var (
-		list   bool
-		remove bool
+		listOpts  recordListOptions
+		remove    bool
+		checkMode bool
 	)
 
 	cmd := &cobra.Command{
@@ -24,36 +24,51 @@ func newTrustCmd() *cobra.Command {
 		Long: `Mark a directory subtree as trusted, allowing all .envrc files
 under it to be evaluated without individual approval.
 
-Examples:
-  cascade trust ~/work          # Trust all .envrc files under ~/work
+Trusting prints "cascade: trusted <path>", or "cascade: unchanged <path>"
+if the subtree was already trusted, in which case nothing is written.
+--check-mode and --json work as for allow.
+
+trust export and trust import copy the allows, denies and trusted subtrees
+of the store to another machine.`,
+		Example: `  cascade trust ~/work          # Trust all .envrc files under ~/work
   cascade trust --list          # List all trusted subtrees
-  cascade trust --remove ~/work # Remove trust for ~/work`,
+  cascade trust --list --stale  # List trusted subtrees that no longer exist
+  cascade trust --remove ~/work # Remove trust for ~/work
+  cascade trust --check-mode ~/work  # Prints would-trust or unchanged
+  cascade trust export > trust.json  # Then on another machine:
+  cascade trust import trust.json`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			store, err := allow.NewStore()
+			store, err := newAllowStore()
 			if err != nil {
 				return fmt.Errorf("create allow store: %w", err)
 			}
 
-			if list {
-				return runTrustList(cmd, store)
+			if checkMode && (listOpts.list || remove) {
+				return errors.New("--check-mode cannot be used with --list or --remove")
+			}
+
+			if listOpts.list {
+				return runRecordList(cmd.OutOrStdout(), args, store, allow.KindTrust, listOpts)
 			}
 
 			if remove {
 				return runTrustRemove(cmd, args, store)
 			}
 
-			return runTrustAdd(cmd, args, store)
+			return runTrustAdd(cmd, args, store, checkMode, listOpts.json)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&list, "list", "l", false, "List all trusted subtrees")
+	addRecordListFlags(cmd, &listOpts, "all trusted subtrees")
 	cmd.Flags().BoolVarP(&remove, "remove", "d", false, "Remove trust for a subtree")
+	addCheckModeFlag(cmd, &checkMode)
+	cmd.AddCommand(newTrustExportCmd(), newTrustImportCmd())
 
 	return cmd
 }
 
-func runTrustAdd(cmd *cobra.Command, args []string, store *allow.Store) error {
+func runTrustAdd(cmd *cobra.Command, args []string, store *allow.Store, checkMode, jsonOutput bool) error {
 	if len(args) == 0 {
 		return errors.New("path required")
 	}
@@ -64,12 +79,22 @@ func runTrustAdd(cmd *cobra.Command, args []string, store *allow.Store) error {
 		return fmt.Errorf("resolve path: %w", err)
 	}
 
-	if err := store.TrustSubtree(absPath); err != nil {
+	return trustSubtree(cmd.OutOrStdout(), store.WithTrigger("cascade trust"), absPath, checkMode, jsonOutput)
+}
+
+// trustSubtree trusts the directory absPath, unless checkMode, and reports
+// the change with printChange.
+func trustSubtree(w io.Writer, store *allow.Store, absPath string, checkMode, jsonOutput bool) error {
+	change, err := store.TrustChange(absPath)
+	if err != nil {
 		return fmt.Errorf("trust subtree: %w", err)
 	}
-
-	fmt.Fprintf(cmd.OutOrStdout(), "cascade: trusted subtree %s\n", absPath)
-	return nil
+	if !checkMode {
+		if err := store.TrustSubtree(absPath); err != nil {
+			return fmt.Errorf("trust subtree: %w", err)
+		}
+	}
+	return printChange(w, change, absPath, checkMode, jsonOutput)
 }
 
 func runTrustRemove(cmd *cobra.Command, args []string, store *allow.Store) error {
@@ -83,46 +108,10 @@ func runTrustRemove(cmd *cobra.Command, args []string, store *allow.Store) error
 		return fmt.Errorf("resolve path: %w", err)
 	}
 
-	if err := store.UntrustSubtree(absPath); err != nil {
+	if err := store.WithTrigger("cascade trust --remove").UntrustSubtree(absPath); err != nil {
 		return fmt.Errorf("untrust subtree: %w", err)
 	}
 
 	fmt.Fprintf(cmd.OutOrStdout(), "cascade: removed trust for %s\n", absPath)
 	return nil
 }
-
-func runTrustList(cmd *cobra.Command, store *allow.Store) error {
-	paths, err := store.ListTrustedSubtrees()
-	if err != nil {
-		return fmt.Errorf("list trusted subtrees: %w", err)
-	}
-
-	if len(paths) == 0 {
-		fmt.Fprintln(cmd.OutOrStdout(), "No trusted subtrees")
-		return nil
-	}
-
-	// Sort for consistent output
-	sort.Strings(paths)
-
-	// Get home directory for path shortening
-	home, _ := os.UserHomeDir()
-
-	fmt.Fprintln(cmd.OutOrStdout(), "Trusted subtrees:")
-	for _, p := range paths {
-		displayPath := shortenPathForDisplay(p, home)
-		fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", displayPath)
-	}
-
-	return nil
-}
-
-// shortenPathForDisplay replaces home directory prefix with ~
-func shortenPathForDisplay(path, home string) string {
-	if home != "" {
-		if rel, err := filepath.Rel(home, path); err == nil && !filepath.IsAbs(rel) {
-			return "~/" + rel
-		}
-	}
-	return path
-}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// TrustDocument is what cascade trust export writes and cascade trust
+// import reads. Paths under the home directory are written as ~/..., so a
+// document applies on machines with another home directory.
+type TrustDocument struct {
+	OutputHeader
+	Home    string       `json:"home"` // Home directory of the exporting machine
+	Allows  []TrustAllow `json:"allows"`
+	Denies  []string     `json:"denies"`
+	Trusted []string     `json:"trusted"`
+}
+
+// TrustAllow is an allowed .envrc and the content hash it was allowed at.
+// Content hashes cover the absolute path, so an importer recomputes them
+// with the exporting machine's path.
+type TrustAllow struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// importSkipped is the status of an entry trust import cannot apply.
+const importSkipped = "skipped"
+
+func newTrustExportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "export",
+		Short: "Print the allow store as a document for trust import",
+		Long: `Print the explicit allows (path and content hash), denies and trusted
+subtrees of the allow store as JSON, for cascade trust import on another
+machine. Paths under your home directory are written as ~/..., and
+resolved against the home directory of the importing machine.
+
+Only the global store is exported: not the workspace or system stores,
+whitelist_prefix, or trusted_remotes.`,
+		Example: `  cascade trust export > cascade-trust.json
+  ssh laptop cascade trust export | cascade trust import -`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := readAllowStore()
+			if err != nil {
+				return fmt.Errorf("open allow store: %w", err)
+			}
+			return runTrustExport(cmd.OutOrStdout(), store)
+		},
+	}
+}
+
+func runTrustExport(w io.Writer, store *allow.Store) error {
+	home, _ := os.UserHomeDir()
+	doc := TrustDocument{
+		OutputHeader: newOutputHeader(),
+		Home:         home,
+		Allows:       []TrustAllow{},
+		Denies:       []string{},
+	}
+
+	allows, err := store.ListAllows()
+	if err != nil {
+		return err
+	}
+	for _, entry := range allows {
+		doc.Allows = append(doc.Allows, TrustAllow{Path: shortenPath(entry.Path, home), Hash: entry.Hash})
+	}
+	denies, err := store.ListDenies()
+	if err != nil {
+		return err
+	}
+	for _, entry := range denies {
+		doc.Denies = append(doc.Denies, shortenPath(entry.Path, home))
+	}
+	trusted, err := store.ListTrustedSubtrees()
+	if err != nil {
+		return err
+	}
+	slices.Sort(trusted)
+	doc.Trusted = make([]string, len(trusted))
+	for i, path := range trusted {
+		doc.Trusted[i] = shortenPath(path, home)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func newTrustImportCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Apply a document written by trust export",
+		Long: `Apply the allows, denies and trusted subtrees of a document written by
+cascade trust export ("-" reads it from stdin).
+
+An allow applies only if the file exists at its path with the content
+hash that was exported; otherwise it is skipped, and the file must be
+reviewed and allowed here. Denies apply unconditionally, after the allows.
+Trusts apply to every directory that exists.
+
+Each entry is reported as "cascade: <status> <path>", the statuses being
+those of allow, deny and trust, or "skipped" with the reason. With
+--dry-run, nothing is written and changes are reported as would-allow,
+would-deny and so on.`,
+		Example: `  cascade trust import cascade-trust.json
+  cascade trust import --dry-run cascade-trust.json`,
+		Annotations: map[string]string{envAnnotation: dataEnv},
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, err := readTrustDocument(cmd.InOrStdin(), args[0])
+			if err != nil {
+				return err
+			}
+			store, err := newAllowStore()
+			if err != nil {
+				return fmt.Errorf("create allow store: %w", err)
+			}
+			return runTrustImport(cmd.OutOrStdout(), store.WithTrigger("cascade trust import"), doc, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would change without writing")
+
+	return cmd
+}
+
+// readTrustDocument reads a TrustDocument from file, or stdin for "-".
+func readTrustDocument(stdin io.Reader, file string) (*TrustDocument, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var doc TrustDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: not a trust export: %w", file, err)
+	}
+	return &doc, nil
+}
+
+func runTrustImport(w io.Writer, store *allow.Store, doc *TrustDocument, dryRun bool) error {
+	home, _ := os.UserHomeDir()
+	var changed, unchanged, skipped int
+	report := func(path string, change allow.Change, err error) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "cascade: %s %s: %v\n", importSkipped, path, err)
+			skipped++
+		case change.Changed():
+			fmt.Fprintf(w, "cascade: %s %s\n", changeStatus(change, dryRun), path)
+			changed++
+		default:
+			fmt.Fprintf(w, "cascade: %s %s\n", change, path)
+			unchanged++
+		}
+	}
+
+	for _, path := range doc.Trusted {
+		path, err := importPath(path, home)
+		if err != nil {
+			report(path, "", err)
+			continue
+		}
+		change, err := store.TrustChange(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = errors.New("directory not found")
+		}
+		if err == nil && !dryRun {
+			err = store.TrustSubtree(path)
+		}
+		report(path, change, err)
+	}
+
+	// Each path's exported versions; the one on disk must be among them
+	hashes := make(map[string][]string)
+	exported := make(map[string]string)
+	var paths []string
+	for _, entry := range doc.Allows {
+		path, err := importPath(entry.Path, home)
+		if err != nil {
+			report(path, "", err)
+			continue
+		}
+		if _, ok := hashes[path]; !ok {
+			paths = append(paths, path)
+			exported[path], _ = importPath(entry.Path, doc.Home)
+		}
+		hashes[path] = append(hashes[path], entry.Hash)
+	}
+	for _, path := range paths {
+		// Check the bytes rc was hashed from, so the hash allowed is of the
+		// content that matched the export even if the file is replaced
+		rc, err := envrc.NewRC(path)
+		var content []byte
+		if err == nil && rc.Exists {
+			content, err = rc.Snapshot()
+		}
+		switch {
+		case err != nil:
+		case !rc.Exists:
+			err = errors.New("file not found")
+		case !slices.Contains(hashes[path], envrc.HashContent(exported[path], content)):
+			err = errors.New("content differs from the exported version; review it and run `cascade allow`")
+		}
+		if err != nil {
+			report(path, "", err)
+			continue
+		}
+		change := store.AllowChange(rc)
+		if !dryRun {
+			err = store.Allow(rc)
+		}
+		report(rc.Path, change, err)
+	}
+
+	for _, path := range doc.Denies {
+		path, err := importPath(path, home)
+		if err != nil {
+			report(path, "", err)
+			continue
+		}
+		rc, err := envrc.NewRC(path)
+		if err != nil {
+			report(path, "", err)
+			continue
+		}
+		change := store.DenyChange(rc)
+		if !dryRun {
+			err = store.Deny(rc)
+		}
+		report(rc.Path, change, err)
+	}
+
+	_, err := fmt.Fprintf(w, "cascade: %d changed, %d unchanged, %d skipped\n", changed, unchanged, skipped)
+	return err
+}
+
+// importPath resolves a path of a TrustDocument: a leading ~ is the home
+// directory, and anything else must be absolute.
+func importPath(path, home string) (string, error) {
+	if home != "" && (path == "~" || strings.HasPrefix(path, "~/")) {
+		return filepath.Join(home, path[1:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return path, errors.New("not an absolute path")
+	}
+	return filepath.Clean(path), nil
+}
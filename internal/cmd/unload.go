@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/shell"
+)
+
+func newUnloadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unload SHELL",
+		Short: "Revert the loaded environment and run its on_unload commands",
+		Long: `Print shell commands that run the on_unload commands of the chain loaded
+in this shell and revert the changes recorded in CASCADE_DIFF, as leaving
+the directory does. Evaluate the output in the shell, as the hook does
+with export.
+
+Nothing is evaluated. Only the loaded environment is undone: the next
+prompt loads the chain of the current directory again.`,
+		Example: `  eval "$(cascade unload bash)"
+  cascade unload fish | source`,
+		Annotations: map[string]string{envAnnotation: `CASCADE_DIFF: Read to revert the changes it records
+CASCADE_FILE: Read to find the on_unload commands to run`},
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sh := exportShell(args[0])
+			if sh == nil {
+				return fmt.Errorf("unsupported shell: %s (supported: %v)", args[0], shell.Supported())
+			}
+			return runUnload(cmd.OutOrStdout(), cmd.ErrOrStderr(), sh)
+		},
+	}
+
+	return cmd
+}
+
+// runUnload prints the commands that unload the chain loaded in this shell,
+// wrapped by sh so the shell applies all of them or none.
+func runUnload(stdout, stderr io.Writer, sh shell.Shell) error {
+	if os.Getenv("CASCADE_DIFF") == "" && os.Getenv("CASCADE_FILE") == "" {
+		fmt.Fprintln(stderr, "cascade: nothing is loaded in this shell")
+		return nil
+	}
+
+	currentEnv := env.FromGoEnv(os.Environ())
+	prevDiff, err := env.Unmarshal(os.Getenv("CASCADE_DIFF"))
+	if err != nil {
+		return fmt.Errorf("invalid CASCADE_DIFF: %w", err)
+	}
+	if !prevDiff.ConsistentWith(currentEnv) {
+		fmt.Fprintln(stderr, inconsistentDiffWarning)
+		prevDiff = &env.EnvDiff{}
+	}
+	prevDiff = keepOverridden(stderr, prevDiff, currentEnv)
+
+	var out bytes.Buffer
+	if err := handleNoEnvrc(&out, stderr, sh, prevDiff, nil, nil, nil); err != nil {
+		return err
+	}
+	_, err = io.WriteString(stdout, sh.Wrap(out.String()))
+	return err
+}
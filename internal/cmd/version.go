@@ -1,20 +1,406 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-func newVersionCmd(version string) *cobra.Command {
-	return &cobra.Command{
+// exitUpdateAvailable is the exit status of version --check when the
+// manifest names a newer version.
+const exitUpdateAvailable = 10
+
+// manifestTimeout bounds fetching the update manifest.
+const manifestTimeout = 5 * time.Second
+
+// latestReleaseURL is the GitHub API endpoint version --check asks for the
+// latest release when no update_manifest is set.
+const latestReleaseURL = "https://api.github.com/repos/unrss/cascade/releases/latest"
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// VersionOutput is the JSON representation of cascade version.
+type VersionOutput struct {
+	OutputHeader
+	BuildInfo
+	Update *UpdateCheck `json:"update,omitempty"`
+}
+
+// UpdateCheck is the result of version --check.
+type UpdateCheck struct {
+	Manifest     string `json:"manifest"`
+	Latest       string `json:"latest"`
+	Available    bool   `json:"available"`
+	ChangelogURL string `json:"changelog_url,omitempty"`
+}
+
+// updateManifest is the document update_manifest points at.
+type updateManifest struct {
+	Version      string `json:"version"`
+	ChangelogURL string `json:"changelog_url"`
+}
+
+// updateSource looks up the latest version of cascade: an update manifest,
+// or the latest GitHub release.
+type updateSource interface {
+	latest(ctx context.Context) (*updateManifest, error)
+	String() string // Where it looks, for messages and UpdateCheck.Manifest
+}
+
+// newUpdateSource returns the update_manifest at location, or the latest
+// GitHub release if location is empty.
+func newUpdateSource(location string) updateSource {
+	if location == "" {
+		return githubReleases{url: latestReleaseURL}
+	}
+	return manifestSource(location)
+}
+
+// manifestSource is an update manifest at an http(s) URL or a local path.
+type manifestSource string
+
+func (m manifestSource) String() string { return string(m) }
+
+func (m manifestSource) latest(ctx context.Context) (*updateManifest, error) {
+	return fetchManifest(ctx, string(m))
+}
+
+// githubReleases is the GitHub API endpoint of a repository's latest
+// release, which leaves out drafts and pre-releases.
+type githubReleases struct {
+	url string
+}
+
+func (g githubReleases) String() string { return g.url }
+
+func (g githubReleases) latest(ctx context.Context) (*updateManifest, error) {
+	data, err := fetchURL(ctx, g.url, "GitHub releases", map[string]string{
+		"Accept":     "application/vnd.github+json",
+		"User-Agent": "cascade/" + cascadeVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("invalid GitHub release %s: %w", g.url, err)
+	}
+	if release.TagName == "" {
+		return nil, fmt.Errorf("invalid GitHub release %s: no tag_name", g.url)
+	}
+	return &updateManifest{Version: release.TagName, ChangelogURL: release.HTMLURL}, nil
+}
+
+// newBuildInfo fills in build metadata from the release ldflags, falling
+// back to the VCS stamp Go embeds in source builds, then "unknown".
+func newBuildInfo(assets Assets) BuildInfo {
+	info := BuildInfo{
+		Commit:    assets.Commit,
+		Date:      assets.Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+func newVersionCmd(info BuildInfo) *cobra.Command {
+	var jsonOutput bool
+	var check bool
+
+	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Print cascade version",
-		Args:  cobra.NoArgs,
+		Long: `Print the cascade version and build metadata.
+
+With --check (or --check-update), compare against the latest GitHub
+release, or the version manifest named by the update_manifest setting (a
+URL or a local file), and exit with status 10 if a newer version is
+available. Nothing is ever downloaded or installed, and the network is
+only used with --check. Packaged installs that update through their
+package manager can set disable_update_check = true to turn it off.`,
+		Example: `  cascade version
+  cascade version --json
+
+  # Exits 10 when a newer release is out
+  cascade version --check`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipConfig: ""},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Fprintln(cmd.OutOrStdout(), strings.TrimSpace(version))
-			return nil
+			// Only the update check reads the configuration
+			var source updateSource
+			if check {
+				if _, err := loadConfig(); err != nil {
+					return err
+				}
+				if cfg.DisableUpdateCheck {
+					return errors.New("update checks are disabled (disable_update_check = true)")
+				}
+				source = newUpdateSource(cfg.UpdateManifest)
+			}
+			return runVersion(cmd.Context(), cmd.OutOrStdout(), info, jsonOutput, source)
 		},
 	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&check, "check", false, "Check for a newer version")
+	cmd.Flags().BoolVar(&check, "check-update", false, "Same as --check")
+
+	return cmd
+}
+
+// runVersion prints info, and whether source has a newer version unless
+// source is nil.
+func runVersion(ctx context.Context, w io.Writer, info BuildInfo, jsonOutput bool, source updateSource) error {
+	output := VersionOutput{OutputHeader: newOutputHeader(), BuildInfo: info}
+
+	if source != nil {
+		update, err := checkForUpdate(ctx, source, cascadeVersion)
+		if err != nil {
+			return err
+		}
+		output.Update = update
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(output); err != nil {
+			return err
+		}
+	} else {
+		outputVersionHuman(w, output)
+	}
+
+	if output.Update != nil && output.Update.Available {
+		return &ExitError{Code: exitUpdateAvailable}
+	}
+	return nil
+}
+
+func outputVersionHuman(w io.Writer, output VersionOutput) {
+	fmt.Fprintln(w, output.Version)
+	fmt.Fprintf(w, "  commit: %s\n", output.Commit)
+	fmt.Fprintf(w, "  built:  %s\n", output.Date)
+	fmt.Fprintf(w, "  go:     %s %s\n", output.GoVersion, output.Platform)
+
+	if u := output.Update; u != nil {
+		fmt.Fprintln(w)
+		if u.Available {
+			fmt.Fprintf(w, "Update available: %s -> %s\n", output.Version, u.Latest)
+			if u.ChangelogURL != "" {
+				fmt.Fprintf(w, "Changelog: %s\n", u.ChangelogURL)
+			}
+		} else {
+			fmt.Fprintf(w, "Up to date (latest: %s)\n", u.Latest)
+		}
+	}
+}
+
+// checkForUpdate looks up the latest version in source and compares it
+// with current.
+func checkForUpdate(ctx context.Context, source updateSource, current string) (*UpdateCheck, error) {
+	currentVer, err := parseSemver(current)
+	if err != nil {
+		return nil, fmt.Errorf("current version: %w", err)
+	}
+
+	manifest, err := source.latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latestVer, err := parseSemver(manifest.Version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latest version from %s: %w", source, err)
+	}
+
+	return &UpdateCheck{
+		Manifest:     source.String(),
+		Latest:       manifest.Version,
+		Available:    latestVer.compare(currentVer) > 0,
+		ChangelogURL: manifest.ChangelogURL,
+	}, nil
+}
+
+// fetchManifest reads and decodes the manifest from an http(s) URL or a
+// local file path.
+func fetchManifest(ctx context.Context, source string) (*updateManifest, error) {
+	var data []byte
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var err error
+		if data, err = fetchURL(ctx, source, "update manifest", nil); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("read update manifest: %w", err)
+		}
+	}
+
+	var manifest updateManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid update manifest %s: %w", source, err)
+	}
+	if manifest.Version == "" {
+		return nil, fmt.Errorf("invalid update manifest %s: no version", source)
+	}
+	return &manifest, nil
+}
+
+// fetchURL GETs url with headers, within manifestTimeout. what names the
+// document in errors.
+func fetchURL(ctx context.Context, url, what string, headers map[string]string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s URL: %w", what, err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s %s (offline?): %w", what, url, errors.Unwrap(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s %s: %s", what, url, resp.Status)
+	}
+	// A release with its notes and assets stays well below this
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read %s %s: %w", what, url, err)
+	}
+	return data, nil
+}
+
+// semver is a parsed MAJOR.MINOR.PATCH[-PRERELEASE] version; build
+// metadata is ignored.
+type semver struct {
+	nums [3]int
+	pre  []string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+	core := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	core, _, _ = strings.Cut(core, "+")
+	core, pre, hasPre := strings.Cut(core, "-")
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("%q is not a semantic version", s)
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a semantic version", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a semantic version", s)
+		}
+		v.nums[i] = n
+	}
+	return v, nil
+}
+
+// compare returns -1, 0, or 1 following semver precedence: a pre-release
+// sorts before its release, and numeric identifiers compare numerically.
+func (v semver) compare(o semver) int {
+	for i := range v.nums {
+		if v.nums[i] != o.nums[i] {
+			if v.nums[i] < o.nums[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, b := v.pre[i], o.pre[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil: // Numeric identifiers sort first
+			return -1
+		case bErr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.pre) < len(o.pre):
+		return -1
+	case len(v.pre) > len(o.pre):
+		return 1
+	}
+	return 0
 }
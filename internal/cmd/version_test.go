@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/config"
+)
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"0.1.0-dev", "0.1.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0+build.5", "1.0.0", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			a, err := parseSemver(tt.a)
+			if err != nil {
+				t.Fatalf("parseSemver(%q): %v", tt.a, err)
+			}
+			b, err := parseSemver(tt.b)
+			if err != nil {
+				t.Fatalf("parseSemver(%q): %v", tt.b, err)
+			}
+			if got := a.compare(b); got != tt.want {
+				t.Errorf("compare = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookVersionMismatch(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.2.3", "1.2.3", false},
+		{"1.2.3", "1.2.9", false},
+		{"v1.2.3", "1.2.4-rc.1", false},
+		{"1.2.3", "1.3.0", true},
+		{"1.3.0", "1.2.3", true},
+		{"1.2.3", "2.2.3", true},
+		{"0.1.0-dev", "0.2.0", true},
+		{"dev", "1.2.3", false},
+		{"1.2.3", "", false},
+	}
+	for _, tt := range tests {
+		if got := hookVersionMismatch(tt.a, tt.b); got != tt.want {
+			t.Errorf("hookVersionMismatch(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseSemver_Invalid(t *testing.T) {
+	for _, s := range []string{"", "unknown", "1.2", "1.2.3.4", "1.x.3", "1.2.3-", "-1.2.3"} {
+		if _, err := parseSemver(s); err == nil {
+			t.Errorf("parseSemver(%q) succeeded, want error", s)
+		}
+	}
+}
+
+// manifestServer serves body as the update manifest.
+func manifestServer(t *testing.T, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/cascade.json"
+}
+
+// manifestFile writes body to a local manifest file.
+func manifestFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cascade.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckForUpdate(t *testing.T) {
+	const (
+		newer     = `{"version": "0.3.0", "changelog_url": "https://example.com/changelog"}`
+		equal     = `{"version": "0.2.0"}`
+		malformed = `{"version": `
+		notSemver = `{"version": "latest"}`
+	)
+
+	tests := []struct {
+		name          string
+		source        func(t *testing.T) string
+		wantAvailable bool
+		wantErr       string
+	}{
+		{"newer over http", func(t *testing.T) string { return manifestServer(t, newer) }, true, ""},
+		{"equal over http", func(t *testing.T) string { return manifestServer(t, equal) }, false, ""},
+		{"malformed over http", func(t *testing.T) string { return manifestServer(t, malformed) }, false, "invalid update manifest"},
+		{"newer in file", func(t *testing.T) string { return manifestFile(t, newer) }, true, ""},
+		{"equal in file", func(t *testing.T) string { return "file://" + manifestFile(t, equal) }, false, ""},
+		{"not semver in file", func(t *testing.T) string { return manifestFile(t, notSemver) }, false, "not a semantic version"},
+		{"missing file", func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing.json") }, false, "read update manifest"},
+		{"unreachable", func(t *testing.T) string {
+			srv := httptest.NewServer(http.NotFoundHandler())
+			srv.Close()
+			return srv.URL + "/cascade.json"
+		}, false, "cannot reach update manifest"},
+		{"http error", func(t *testing.T) string {
+			srv := httptest.NewServer(http.NotFoundHandler())
+			t.Cleanup(srv.Close)
+			return srv.URL
+		}, false, "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := checkForUpdate(context.Background(), newUpdateSource(tt.source(t)), "0.2.0")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("checkForUpdate() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkForUpdate() error = %v", err)
+			}
+			if update.Available != tt.wantAvailable {
+				t.Errorf("Available = %v, want %v", update.Available, tt.wantAvailable)
+			}
+		})
+	}
+}
+
+func TestRunVersion_CheckExitCode(t *testing.T) {
+	prevCfg, prevVersion := cfg, cascadeVersion
+	t.Cleanup(func() { cfg, cascadeVersion = prevCfg, prevVersion })
+	cfg = config.Default()
+	cascadeVersion = "0.2.0"
+
+	info := BuildInfo{Commit: "abc123", Date: "2026-01-02", GoVersion: "go1.25.0", Platform: "linux/amd64"}
+
+	// Without --check nothing is fetched
+	var out bytes.Buffer
+	if err := runVersion(context.Background(), &out, info, false, nil); err != nil {
+		t.Fatalf("runVersion() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "commit: abc123") {
+		t.Errorf("output missing commit:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := runVersion(context.Background(), &out, info, false, fakeUpdateSource{version: "0.3.0", changelog: "https://example.com/changelog"})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != exitUpdateAvailable {
+		t.Fatalf("runVersion() error = %v, want exit status %d", err, exitUpdateAvailable)
+	}
+	if !strings.Contains(out.String(), "Changelog: https://example.com/changelog") {
+		t.Errorf("output missing changelog:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runVersion(context.Background(), &out, info, false, fakeUpdateSource{version: "0.2.0"}); err != nil {
+		t.Fatalf("runVersion() up to date error = %v", err)
+	}
+
+	// Offline, the check fails without printing anything
+	out.Reset()
+	err = runVersion(context.Background(), &out, info, false, fakeUpdateSource{err: errors.New("cannot reach GitHub releases (offline?)")})
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Errorf("runVersion() offline error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("runVersion() offline printed %q", out.String())
+	}
+}
+
+// fakeUpdateSource reports version as the latest, or fails with err.
+type fakeUpdateSource struct {
+	version, changelog string
+	err                error
+}
+
+func (f fakeUpdateSource) String() string { return "fake" }
+
+func (f fakeUpdateSource) latest(context.Context) (*updateManifest, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &updateManifest{Version: f.version, ChangelogURL: f.changelog}, nil
+}
+
+func TestGithubReleases(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte(`{"tag_name": "v0.3.0", "html_url": "https://github.com/unrss/cascade/releases/tag/v0.3.0", "prerelease": false}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	prevVersion := cascadeVersion
+	t.Cleanup(func() { cascadeVersion = prevVersion })
+	cascadeVersion = "0.2.0"
+
+	update, err := checkForUpdate(context.Background(), githubReleases{url: srv.URL}, "0.2.0")
+	if err != nil {
+		t.Fatalf("checkForUpdate() error = %v", err)
+	}
+	if !update.Available || update.Latest != "v0.3.0" || update.ChangelogURL != "https://github.com/unrss/cascade/releases/tag/v0.3.0" {
+		t.Errorf("checkForUpdate() = %+v, want v0.3.0 available with its release page", update)
+	}
+	if userAgent != "cascade/0.2.0" {
+		t.Errorf("User-Agent = %q, want cascade/0.2.0", userAgent)
+	}
+
+	if _, ok := newUpdateSource("").(githubReleases); !ok {
+		t.Error("newUpdateSource(\"\") should check GitHub releases")
+	}
+}
+
+func TestNewBuildInfo(t *testing.T) {
+	// Release builds pass the ldflags values through
+	info := newBuildInfo(Assets{Commit: "abc123", Date: "2026-01-02T03:04:05Z"})
+	if info.Commit != "abc123" || info.Date != "2026-01-02T03:04:05Z" {
+		t.Errorf("newBuildInfo() = %+v, want the ldflags commit and date", info)
+	}
+	if info.GoVersion != runtime.Version() || info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("newBuildInfo() = %+v, want the running Go version and platform", info)
+	}
+
+	// Without them, the VCS stamp is used if there is one, then "unknown"
+	info = newBuildInfo(Assets{})
+	if info.Commit == "" || info.Date == "" {
+		t.Errorf("newBuildInfo() = %+v, want fallbacks for commit and date", info)
+	}
+	if bi, _ := debug.ReadBuildInfo(); !slices.ContainsFunc(bi.Settings, func(s debug.BuildSetting) bool { return s.Key == "vcs.revision" }) {
+		if info.Commit != "unknown" || info.Date != "unknown" {
+			t.Errorf("newBuildInfo() = %+v, want unknown commit and date without a VCS stamp", info)
+		}
+	}
+}
+
+func TestRunVersion_JSON(t *testing.T) {
+	prevVersion := cascadeVersion
+	t.Cleanup(func() { cascadeVersion = prevVersion })
+	cascadeVersion = "0.2.0"
+
+	info := BuildInfo{Commit: "abc123", Date: "2026-01-02", GoVersion: "go1.25.0", Platform: "linux/amd64"}
+	var out bytes.Buffer
+	if err := runVersion(context.Background(), &out, info, true, fakeUpdateSource{version: "0.2.0"}); err != nil {
+		t.Fatalf("runVersion() error = %v", err)
+	}
+
+	var output map[string]any
+	if err := json.Unmarshal(out.Bytes(), &output); err != nil {
+		t.Fatalf("json.Unmarshal: %v\n%s", err, out.String())
+	}
+	want := map[string]any{
+		"version":    "0.2.0",
+		"commit":     "abc123",
+		"date":       "2026-01-02",
+		"go_version": "go1.25.0",
+		"platform":   "linux/amd64",
+	}
+	for key, value := range want {
+		if output[key] != value {
+			t.Errorf("%s = %v, want %v", key, output[key], value)
+		}
+	}
+	update, ok := output["update"].(map[string]any)
+	if !ok || update["latest"] != "0.2.0" || update["available"] != false || update["manifest"] != "fake" {
+		t.Errorf("update = %v, want latest 0.2.0, not available, from fake", output["update"])
+	}
+}
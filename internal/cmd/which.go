@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -14,153 +16,320 @@ import (
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
 	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/run"
 )
 
 // WhichOutput is the JSON representation of cascade which.
 type WhichOutput struct {
+	OutputHeader
 	Variable string       `json:"variable"`
+	Target   string       `json:"target,omitempty"` // Analyzed directory, when --dir is not the working directory
 	Value    string       `json:"value,omitempty"`
 	SetBy    []SetByEntry `json:"set_by,omitempty"`
 	NotFound bool         `json:"not_found,omitempty"`
+
+	// Source is where the answer came from: "cache" when replayed from
+	// cached per-level results for the chain loaded in this shell,
+	// "evaluation" when the chain was evaluated.
+	Source string `json:"source,omitempty"`
+
+	// Separator is set when the variable is list-merged (merge_var).
+	Separator string `json:"separator,omitempty"`
+
+	// Divergence is set with --compare when this shell's value disagrees
+	// with what the chain would set.
+	Divergence *DivergentVar `json:"divergence,omitempty"`
+
+	// Masked is set when the variable looks like a secret and its value
+	// and components are masked (see --show-secrets).
+	Masked bool `json:"masked,omitempty"`
 }
 
 // SetByEntry represents a single .envrc file that set or modified a variable.
 type SetByEntry struct {
 	Path   string `json:"path"`
 	Action string `json:"action"` // "set", "append", "prepend", "override"
+
+	// Added and Removed list the components this file changed, for
+	// path-like and merged (merge_var) variables only.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
 }
 
+// WhichAllOutput is the JSON representation of cascade which --all: the
+// attribution of every variable the chain set or changed.
+type WhichAllOutput struct {
+	OutputHeader
+	Target    string                  `json:"target,omitempty"` // Analyzed directory, when --dir is not the working directory
+	Source    string                  `json:"source,omitempty"` // As in WhichOutput
+	Variables map[string]*WhichOutput `json:"variables"`
+}
+
+// whichOptions holds the which command's flags.
+type whichOptions struct {
+	json     bool
+	all      bool   // Attribute every variable the chain set
+	compare  bool   // Compare the chain's value with this shell's
+	dir      string // Directory to analyze instead of the working directory
+	evaluate bool   // Always evaluate the chain, never answer from the cache
+
+	showSecrets bool // Do not mask the value if the variable looks like a secret
+}
+
+// Sources of a which answer.
+const (
+	whichSourceCache      = "cache"
+	whichSourceEvaluation = "evaluation"
+)
+
 func newWhichCmd(stdlib string) *cobra.Command {
-	var jsonOutput bool
+	var opts whichOptions
 
 	cmd := &cobra.Command{
-		Use:   "which VAR",
+		Use:   "which VAR | --all",
 		Short: "Show which .envrc file set a variable",
 		Long: `Show which .envrc file(s) set or modified the specified environment variable.
 
 For path-like variables (PATH, MANPATH, etc.), shows which files added entries.
-For regular variables, shows which file set the value and any overrides.`,
+For regular variables, shows which file set the value and any overrides.
+
+With --compare, also check whether this shell actually has the value the
+chain would set, e.g. in a terminal where the hook is not active.
+
+Use --dir to ask about the chain of another directory without changing
+into it. --compare only applies to the current directory.
+
+When the chain was loaded in this shell (CASCADE_DIR is its deepest
+.envrc's directory), no .envrc is run: a variable CASCADE_DIFF does not
+record was not set by the chain, and the files that set one are found
+from cached per-level results. If a level has no cached result, or with
+--evaluate, the chain is evaluated. The JSON "source" field says which:
+"cache" or "evaluation".
+
+With --all, every variable the chain set or changed is attributed at
+once, from a single evaluation, grouped by the file that changed it. The
+JSON output maps each variable to what which VAR would print for it.
+
+As in status, the value of a variable that looks like a secret is masked
+unless --show-secrets is given.`,
 		Example: `  cascade which PATH
+  cascade which --all
   cascade which MY_VAR
-  cascade which --json PATH`,
-		Args: cobra.ExactArgs(1),
+  cascade which --compare MY_VAR
+  cascade which --json PATH
+  cascade which --evaluate MY_VAR`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if opts.all {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeLoadedVar,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runWhich(cmd.OutOrStdout(), cmd.ErrOrStderr(), args[0], stdlib, jsonOutput)
+			if opts.all {
+				return runWhichAll(cmd.OutOrStdout(), cmd.ErrOrStderr(), stdlib, env.FromGoEnv(os.Environ()), opts)
+			}
+			return runWhich(cmd.OutOrStdout(), cmd.ErrOrStderr(), args[0], stdlib, env.FromGoEnv(os.Environ()), opts)
 		},
 	}
 
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Attribute every variable the chain set")
+	cmd.Flags().BoolVar(&opts.compare, "compare", false, "Compare with the value in this shell")
+	cmd.Flags().BoolVar(&opts.evaluate, "evaluate", false, "Evaluate the chain instead of answering from the cache")
+	cmd.Flags().BoolVar(&opts.showSecrets, "show-secrets", false, "Show the value even if the variable looks like a secret")
+	addDirFlag(cmd, &opts.dir)
 
 	return cmd
 }
 
-func runWhich(stdout, stderr io.Writer, varName, stdlib string, jsonOutput bool) error {
-	output, err := gatherWhich(stderr, varName, stdlib)
+// runWhich reports on varName for the shell environment current.
+func runWhich(stdout, stderr io.Writer, varName, stdlib string, current env.Env, opts whichOptions) error {
+	output, err := gatherWhich(stderr, varName, stdlib, current, opts)
 	if err != nil {
 		return err
 	}
+	maskWhich(output, newSecretMask(opts.showSecrets))
 
-	if jsonOutput {
+	if opts.json {
 		return outputWhichJSON(stdout, output)
 	}
 
 	return outputWhichHuman(stdout, output)
 }
 
-func gatherWhich(stderr io.Writer, varName, stdlib string) (*WhichOutput, error) {
-	output := &WhichOutput{
-		Variable: varName,
-		SetBy:    []SetByEntry{},
-	}
-
-	// Get home directory for chain root
-	home, err := os.UserHomeDir()
+// runWhichAll reports on every variable the chain set or changed for the
+// shell environment current.
+func runWhichAll(stdout, stderr io.Writer, stdlib string, current env.Env, opts whichOptions) error {
+	attr, err := gatherAttribution(stderr, nil, stdlib, current, opts)
 	if err != nil {
-		return nil, fmt.Errorf("get home directory: %w", err)
+		return err
+	}
+	output := &WhichAllOutput{
+		Target:    attr.target,
+		Source:    attr.source,
+		Variables: make(map[string]*WhichOutput),
+	}
+	mask := newSecretMask(opts.showSecrets)
+	for _, name := range attr.changed() {
+		which := attr.which(name, current, opts)
+		which.Target, which.Source = "", ""
+		maskWhich(which, mask)
+		output.Variables[name] = which
 	}
 
-	// Get current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("get working directory: %w", err)
+	if opts.json {
+		output.OutputHeader = newOutputHeader()
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
 	}
+	return outputWhichAllHuman(stdout, output, attr.allowed)
+}
 
-	// Find .envrc chain from home to cwd
-	chain, err := envrc.FindChain(home, cwd)
-	if err != nil {
-		// If cwd is not under home, just use cwd itself
-		chain, err = envrc.FindChain(cwd, cwd)
-		if err != nil {
-			return nil, fmt.Errorf("find envrc chain: %w", err)
+// maskWhich masks the value and components in output if the variable
+// looks like a secret.
+func maskWhich(output *WhichOutput, mask secretMask) {
+	if !mask.matches(output.Variable) {
+		return
+	}
+	output.Value, output.Masked = mask.value(output.Variable, output.Value)
+	lists := make([][]string, 0, 2*len(output.SetBy)+1)
+	for _, entry := range output.SetBy {
+		lists = append(lists, entry.Added, entry.Removed)
+	}
+	if output.Divergence != nil {
+		lists = append(lists, output.Divergence.MissingEntries)
+	}
+	for _, list := range lists {
+		for i := range list {
+			list[i] = env.Mask(list[i])
+			output.Masked = true
 		}
 	}
+}
 
-	// Filter to existing files only
-	existing := envrc.ExistingOnly(chain)
-
-	if len(existing) == 0 {
-		output.NotFound = true
-		return output, nil
+func gatherWhich(stderr io.Writer, varName, stdlib string, current env.Env, opts whichOptions) (*WhichOutput, error) {
+	attr, err := gatherAttribution(stderr, []string{varName}, stdlib, current, opts)
+	if err != nil {
+		return nil, err
 	}
+	return attr.which(varName, current, opts), nil
+}
+
+// attribution is the chain evaluated once, from which the files that set
+// each variable are found.
+type attribution struct {
+	target  string       // Analyzed directory, when --dir is not the working directory
+	source  string       // whichSourceCache or whichSourceEvaluation, empty if nothing was allowed
+	allowed []*run.Level // Allowed levels, in order
+	base    env.Env      // The environment without cascade's changes
+	result  *run.Result  // Nil if no level is allowed
+}
 
-	// Create allow store
-	store, err := allow.NewStore()
+// gatherAttribution plans and evaluates the chain for which, answering from
+// the cache when it can. names are the variables asked about, or nil for
+// all of them.
+func gatherAttribution(stderr io.Writer, names []string, stdlib string, current env.Env, opts whichOptions) (*attribution, error) {
+	// Find and authorize the .envrc chain from the cascade root to the target
+	plan, err := planDir(opts.dir)
 	if err != nil {
-		return nil, fmt.Errorf("create allow store: %w", err)
+		return nil, err
+	}
+	if opts.compare && !isShellDir(plan.Target) {
+		return nil, errors.New("--compare compares with this shell, so it cannot be used with --dir for another directory")
 	}
 
-	// Filter to allowed files only
-	var allowed []*envrc.RC
-	for _, rc := range existing {
-		if store.Check(rc) == allow.Allowed {
-			allowed = append(allowed, rc)
-		}
+	attr := &attribution{allowed: plan.Filter(allow.Allowed)}
+	if !isShellDir(plan.Target) {
+		attr.target = plan.Target
+	}
+	if len(attr.allowed) == 0 {
+		return attr, nil
 	}
 
-	if len(allowed) == 0 {
-		output.NotFound = true
-		return output, nil
+	// Start from the environment without cascade's own changes
+	attr.base = revertedEnvFrom(stderr, current)
+
+	attr.source = whichSourceCache
+	if !opts.evaluate {
+		attr.result = replayFromCache(stderr, names, plan, attr.allowed, attr.base, current)
 	}
+	if attr.result == nil {
+		evaluator, err := newEvaluator(stderr, stdlib, cfg.CacheEnabled)
+		if err != nil {
+			return nil, err
+		}
 
-	// Get self path for evaluator
-	selfPath, err := os.Executable()
-	if err != nil {
-		return nil, fmt.Errorf("get executable path: %w", err)
+		// Evaluate each allowed .envrc in order
+		attr.source = whichSourceEvaluation
+		attr.result = run.Run(plan, attr.base, evaluator, run.Options{
+			ContinueOnError: true,
+			CollectDiffs:    true,
+			Progress:        warnOnLevelError(stderr),
+		})
 	}
+	return attr, nil
+}
 
-	// Create evaluator
-	evaluator, err := eval.New("", stdlib, selfPath)
-	if err != nil {
-		return nil, fmt.Errorf("create evaluator: %w", err)
+// changed returns the variables any evaluated level set, changed or
+// unset, sorted.
+func (a *attribution) changed() []string {
+	seen := make(map[string]bool)
+	for _, level := range a.allowed {
+		if !level.Evaluated {
+			continue
+		}
+		for _, vars := range []env.Env{level.Before, level.After} {
+			for name := range vars {
+				if !seen[name] && !env.IgnoredEnv(name) && level.Before[name] != level.After[name] {
+					seen[name] = true
+				}
+			}
+		}
 	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
 
-	// Start with current environment (filtered)
-	currentEnv := env.FromGoEnv(os.Environ())
-	workingEnv := currentEnv.Filtered()
+// which attributes varName to the files that set or changed it.
+func (a *attribution) which(varName string, current env.Env, opts whichOptions) *WhichOutput {
+	output := &WhichOutput{
+		Variable: varName,
+		Target:   a.target,
+		SetBy:    []SetByEntry{},
+	}
+	if a.result == nil {
+		output.NotFound = true
+		return output
+	}
+	output.Source = a.source
+	result := a.result
 
 	// Track the variable value before and after each .envrc
 	isPathLike := isPathLikeVar(varName)
-	var prevValue string
-
-	// Evaluate each allowed .envrc in order, tracking changes to the variable
-	for _, rc := range allowed {
-		prevValue = workingEnv[varName]
-
-		result, err := evaluator.Evaluate(rc, workingEnv)
-		if err != nil {
-			fmt.Fprintf(stderr, "cascade: warning: error evaluating %s: %v\n", rc.Path, err)
+	for _, level := range a.allowed {
+		if !level.Evaluated {
 			continue
 		}
 
-		newValue := result.Env[varName]
-		workingEnv = result.Env
+		prevValue := level.Before[varName]
+		newValue := level.After[varName]
 
 		// Check if this file changed the variable
 		if newValue != prevValue {
-			entry := SetByEntry{Path: rc.Path}
+			entry := SetByEntry{Path: level.RC.Path}
 
-			if isPathLike {
+			if sep, ok := result.Merge[varName]; ok {
+				entry.Action = detectListAction(prevValue, newValue, sep)
+				entry.Added, entry.Removed = env.ListDiff(prevValue, newValue, sep)
+			} else if isPathLike {
 				entry.Action = detectPathAction(prevValue, newValue)
+				entry.Added, entry.Removed = pathComponentDiff(prevValue, newValue)
 			} else {
 				if prevValue == "" {
 					entry.Action = "set"
@@ -174,14 +343,90 @@ func gatherWhich(stderr io.Writer, varName, stdlib string) (*WhichOutput, error)
 	}
 
 	// Set the final value
-	output.Value = workingEnv[varName]
+	output.Value = result.Env[varName]
+	output.Separator = result.Merge[varName]
 
 	// If no .envrc set this variable, mark as not found
 	if len(output.SetBy) == 0 {
 		output.NotFound = true
 	}
 
-	return output, nil
+	if opts.compare && !output.NotFound {
+		sep := output.Separator
+		if sep == "" && isPathLikeVar(varName) {
+			sep = ":"
+		}
+		output.Divergence = compareVar(varName, a.base[varName], output.Value, sep, current)
+	}
+
+	// Only the provenance of a sensitive_env variable is shown
+	if output.Value != "" && slices.Contains(result.Sensitive, varName) {
+		output.Value = env.Redacted
+		output.Separator = ""
+		for i := range output.SetBy {
+			output.SetBy[i].Added, output.SetBy[i].Removed = nil, nil
+		}
+		if output.Divergence != nil {
+			output.Divergence.MissingEntries = nil
+		}
+	}
+
+	return output
+}
+
+// errNotCached is returned by cacheOnly for a level with no cached result.
+var errNotCached = errors.New("no cached result")
+
+// cacheOnly is a run.Evaluator that answers from the evaluation cache
+// alone, never running an .envrc.
+type cacheOnly struct {
+	cache *eval.Cache
+}
+
+func (c cacheOnly) Evaluate(rc *envrc.RC, inputEnv env.Env) (*eval.Result, error) {
+	if result, ok := c.cache.Get(eval.CacheKey(rc, inputEnv), rc.Path); ok {
+		return result, nil
+	}
+	return nil, errNotCached
+}
+
+// replayFromCache answers for names, or every variable if nil, without
+// running any .envrc, if plan is the chain loaded in the shell whose
+// environment is current: CASCADE_DIR is the directory of its deepest
+// allowed level and CASCADE_DIFF is valid. Variables the diff does not
+// record, even as sensitive or kept, were not set by the chain, so if none
+// of names is recorded the result leaves them as in base; otherwise the
+// chain is run from base with cached per-level results. It returns nil if
+// the chain is not loaded or any level has no cached result.
+func replayFromCache(stderr io.Writer, names []string, plan *run.Plan, allowed []*run.Level, base, current env.Env) *run.Result {
+	if !cfg.CacheEnabled || os.Getenv("CASCADE_REFRESH") != "" || current["CASCADE_DIR"] != allowed[len(allowed)-1].RC.Dir {
+		return nil
+	}
+	if current["CASCADE_DIFF"] == "" {
+		return nil
+	}
+	diff, err := env.Unmarshal(current["CASCADE_DIFF"])
+	if err != nil || !diff.ConsistentWith(current) {
+		return nil
+	}
+	recorded := func(name string) bool {
+		_, prev := diff.Prev[name]
+		_, next := diff.Next[name]
+		return prev || next || slices.Contains(diff.Sensitive, name) || slices.Contains(diff.Kept, name)
+	}
+	if names != nil && !slices.ContainsFunc(names, recorded) {
+		return &run.Result{Env: base}
+	}
+
+	cache := openCache(stderr)
+	if cache == nil {
+		return nil
+	}
+	result := run.Run(plan, base, cacheOnly{cache: cache}, run.Options{CollectDiffs: true})
+	if result.Err != nil {
+		return nil
+	}
+	return result
 }
 
 // isPathLikeVar returns true if the variable is typically a colon-separated path.
@@ -205,22 +450,28 @@ func isPathLikeVar(name string) bool {
 
 // detectPathAction determines if a path was prepended, appended, or replaced.
 func detectPathAction(oldValue, newValue string) string {
+	return detectListAction(oldValue, newValue, ":")
+}
+
+// detectListAction determines if a sep-separated list was prepended,
+// appended, or replaced.
+func detectListAction(oldValue, newValue, sep string) string {
 	if oldValue == "" {
 		return "set"
 	}
 
 	// Check if old value is a suffix (new value was prepended)
-	if strings.HasSuffix(newValue, ":"+oldValue) {
+	if strings.HasSuffix(newValue, sep+oldValue) {
 		return "prepend"
 	}
 
 	// Check if old value is a prefix (new value was appended)
-	if strings.HasPrefix(newValue, oldValue+":") {
+	if strings.HasPrefix(newValue, oldValue+sep) {
 		return "append"
 	}
 
 	// Check if old value is contained (both prepend and append happened)
-	if strings.Contains(newValue, ":"+oldValue+":") {
+	if strings.Contains(newValue, sep+oldValue+sep) {
 		return "modify"
 	}
 
@@ -229,6 +480,7 @@ func detectPathAction(oldValue, newValue string) string {
 }
 
 func outputWhichJSON(w io.Writer, output *WhichOutput) error {
+	output.OutputHeader = newOutputHeader()
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	return enc.Encode(output)
@@ -240,6 +492,10 @@ func outputWhichHuman(w io.Writer, output *WhichOutput) error {
 	// Get home directory for path shortening
 	home, _ := os.UserHomeDir()
 
+	if output.Target != "" {
+		fmt.Fprintf(w, "%s\n", c.dim("In "+shortenPath(output.Target, home)+":"))
+	}
+
 	if output.NotFound {
 		fmt.Fprintf(w, "%s is not set by any .envrc file\n", c.bold(output.Variable))
 		if output.Value != "" {
@@ -259,12 +515,23 @@ func outputWhichHuman(w io.Writer, output *WhichOutput) error {
 		displayPath := shortenPath(entry.Path, home)
 		actionDesc := formatAction(entry.Action, i == 0)
 		fmt.Fprintf(w, "  %s  %s\n", displayPath, c.dim("("+actionDesc+")"))
+		for _, part := range entry.Added {
+			fmt.Fprintf(w, "      %s %s\n", c.green("+"), part)
+		}
+		for _, part := range entry.Removed {
+			fmt.Fprintf(w, "      %s %s\n", c.red("-"), c.dim(part))
+		}
 	}
 
 	fmt.Fprintln(w)
 
 	// Show the current value
-	if isPathLikeVar(output.Variable) {
+	if output.Separator != "" {
+		fmt.Fprintf(w, "%s\n", c.bold("Current value:"))
+		for _, part := range env.SplitList(output.Value, output.Separator) {
+			fmt.Fprintf(w, "  %s\n", part)
+		}
+	} else if isPathLikeVar(output.Variable) {
 		fmt.Fprintf(w, "%s\n", c.bold("Current value:"))
 		// Split path and show each entry on its own line
 		parts := filepath.SplitList(output.Value)
@@ -275,7 +542,95 @@ func outputWhichHuman(w io.Writer, output *WhichOutput) error {
 	} else {
 		fmt.Fprintf(w, "%s %s\n", c.bold("Value:"), formatValue(output.Value))
 	}
+	if output.Source == whichSourceCache {
+		fmt.Fprintf(w, "%s\n", c.dim("(from cached results; use --evaluate to run the chain)"))
+	}
 
+	if d := output.Divergence; d != nil {
+		fmt.Fprintln(w)
+		switch {
+		case d.Reason == "missing":
+			fmt.Fprintf(w, "%s this shell does not have %s (hook not active here?)\n", c.yellow("⚠"), output.Variable)
+		case d.Reason == "not_unset":
+			fmt.Fprintf(w, "%s this shell still has %s, which the chain unsets\n", c.yellow("⚠"), output.Variable)
+		case len(d.MissingEntries) > 0:
+			fmt.Fprintf(w, "%s this shell's %s lacks entries the chain adds:\n", c.yellow("⚠"), output.Variable)
+			for _, part := range d.MissingEntries {
+				fmt.Fprintf(w, "  %s\n", shortenPath(part, home))
+			}
+		default:
+			fmt.Fprintf(w, "%s this shell's %s differs from the value above\n", c.yellow("⚠"), output.Variable)
+		}
+	}
+
+	return nil
+}
+
+// outputWhichAllHuman prints the variables of output grouped by the files
+// of allowed that changed them, in chain order. A list's entries are shown
+// under each file; another variable's value is shown under the file that
+// set it last.
+func outputWhichAllHuman(w io.Writer, output *WhichAllOutput, allowed []*run.Level) error {
+	c := newColorizer(w)
+	home, _ := os.UserHomeDir()
+
+	if output.Target != "" {
+		fmt.Fprintf(w, "%s\n", c.dim("In "+shortenPath(output.Target, home)+":"))
+	}
+	if len(output.Variables) == 0 {
+		fmt.Fprintln(w, "No variables are set by any .envrc file")
+		return nil
+	}
+
+	names := make([]string, 0, len(output.Variables))
+	for name := range output.Variables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, level := range allowed {
+		printed := false
+		for _, name := range names {
+			which := output.Variables[name]
+			for i, entry := range which.SetBy {
+				if entry.Path != level.RC.Path {
+					continue
+				}
+				if !printed {
+					fmt.Fprintln(w, shortenPath(level.RC.Path, home))
+					printed = true
+				}
+				line := fmt.Sprintf("  %s %s", c.cyan(name), c.dim("("+formatAction(entry.Action, i == 0)+")"))
+				isList := which.Separator != "" || isPathLikeVar(name)
+				if i == len(which.SetBy)-1 && !isList && which.Value != "" {
+					line += " = " + formatValue(which.Value)
+				}
+				fmt.Fprintln(w, line)
+				for _, part := range entry.Added {
+					fmt.Fprintf(w, "      %s %s\n", c.green("+"), shortenPath(part, home))
+				}
+				for _, part := range entry.Removed {
+					fmt.Fprintf(w, "      %s %s\n", c.red("-"), c.dim(shortenPath(part, home)))
+				}
+			}
+		}
+		if printed {
+			fmt.Fprintln(w)
+		}
+	}
+
+	var divergent []string
+	for _, name := range names {
+		if output.Variables[name].Divergence != nil {
+			divergent = append(divergent, name)
+		}
+	}
+	if len(divergent) > 0 {
+		fmt.Fprintf(w, "%s this shell differs from the chain for %s (see which --compare VAR)\n", c.yellow("⚠"), strings.Join(divergent, ", "))
+	}
+	if output.Source == whichSourceCache {
+		fmt.Fprintf(w, "%s\n", c.dim("(from cached results; use --evaluate to run the chain)"))
+	}
 	return nil
 }
 
@@ -2,11 +2,17 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/unrss/cascade/internal/logging"
+	"github.com/unrss/cascade/internal/shell"
 )
 
 // Config holds cascade configuration.
@@ -30,26 +36,241 @@ type Config struct {
 	// LogEnvDiff controls whether to log environment variable changes to stderr.
 	// When true (default), prints +VAR/-VAR/~VAR when loading/unloading .envrc files.
 	LogEnvDiff bool `mapstructure:"log_env_diff"`
+
+	// LogEnvDiffMax is how many changed variables the env diff log lists by
+	// name. Above it, only counts are printed. Zero or less never summarizes.
+	LogEnvDiffMax int `mapstructure:"log_env_diff_max"`
+
+	// LogEnvDiffValues appends a short preview of the value to added and
+	// changed variables in the env diff log. Secret values are never shown.
+	LogEnvDiffValues bool `mapstructure:"log_env_diff_values"`
+
+	// WorkspaceStore names an allow store kept inside the workspace (e.g. ".cascade").
+	// When set, allow/deny records for .envrc files under a directory containing
+	// this store are also written there, so they survive data directory wipes.
+	WorkspaceStore string `mapstructure:"workspace_store"`
+
+	// EvalStderrLines caps how many stderr lines each .envrc may print per prompt.
+	// Further output is captured (bounded) instead of shown. 0 disables the cap.
+	EvalStderrLines int `mapstructure:"eval_stderr_lines"`
+
+	// SlowWarningMS is how long, in milliseconds, export may spend
+	// evaluating the chain before it warns and names the slowest .envrc.
+	// 0 disables the warning.
+	SlowWarningMS int `mapstructure:"slow_warning_ms"`
+
+	// TrustedRemotes lists git origin URL prefixes whose clean checkouts are
+	// auto-allowed on first export (e.g. "git@github.com:ourorg/").
+	TrustedRemotes []string `mapstructure:"trusted_remotes"`
+
+	// CacheMaxAge is how long an evaluation cache entry is used, as a
+	// duration such as "30d" or "12h" (see ParseAge). Older entries are
+	// misses and are removed. "0" keeps entries for any age.
+	CacheMaxAge string `mapstructure:"cache_max_age"`
+
+	// CacheMaxEntries and CacheMaxSizeMB bound the evaluation cache: cache
+	// GC removes the oldest entries above either. Zero means no limit.
+	CacheMaxEntries int `mapstructure:"cache_max_entries"`
+	CacheMaxSizeMB  int `mapstructure:"cache_max_size_mb"`
+
+	// CacheExclude lists variable name patterns (e.g. "*_TOKEN") whose
+	// values are never written to disk by the evaluation cache or cache_output.
+	CacheExclude []string `mapstructure:"cache_exclude"`
+
+	// MaskPatterns lists variable name patterns whose values status, tree
+	// and which mask, in addition to env.MaskPatterns and CacheExclude.
+	MaskPatterns []string `mapstructure:"mask_patterns"`
+
+	// WatchHash fingerprints watched files by content instead of relying on
+	// mtimes alone, for filesystems whose clocks disagree with this host.
+	WatchHash bool `mapstructure:"watch_hash"`
+
+	// ExportFastPath lets export print nothing without planning the chain
+	// when the shell is still in the directory of the deepest .envrc loaded,
+	// no watched file changed and neither did a directory of the chain, the
+	// config file or the user's or system allow store. It is off while
+	// WorkspaceStore or TrustedRemotes is set.
+	ExportFastPath bool `mapstructure:"export_fast_path"`
+
+	// SystemDataDir is a read-only allow/deny/trust store shared by all users,
+	// consulted after the user's own store. It is used only when it exists,
+	// is owned by root, and is not writable by other users. Empty disables it.
+	SystemDataDir string `mapstructure:"system_data_dir"`
+
+	// SkipMarkers names extra marker files that, like .cascade-skip, stop
+	// chain discovery at the directory containing them.
+	SkipMarkers []string `mapstructure:"skip_markers"`
+
+	// UpdateManifest is the URL or local path of a version manifest that
+	// cascade version --check compares against. Empty checks the latest
+	// GitHub release instead.
+	UpdateManifest string `mapstructure:"update_manifest"`
+
+	// DisableUpdateCheck makes cascade version --check fail without
+	// contacting anything, for packaged installs that update otherwise.
+	DisableUpdateCheck bool `mapstructure:"disable_update_check"`
+
+	// RootEnvrc is RootEnvrcOptional or RootEnvrcRequired. When optional, a
+	// failure of the .envrc at the cascade root is reported as a warning and
+	// the rest of the chain is applied without it.
+	RootEnvrc string `mapstructure:"root_envrc"`
+
+	// SessionExportFile, when set, is where export also writes the variables
+	// it applies, so tmux windows and similar can start with them. $VAR
+	// references are expanded, and $CASCADE_DIR_HASH names the directory.
+	SessionExportFile string `mapstructure:"session_export_file"`
+
+	// HookResolvePath makes generated shell hooks look cascade up on PATH at
+	// every prompt instead of running the binary that generated them.
+	HookResolvePath bool `mapstructure:"hook_resolve_path"`
+
+	// AuditKeepContent keeps the content of every allowed file next to the
+	// audit log, named by content hash.
+	AuditKeepContent bool `mapstructure:"audit_keep_content"`
+
+	// AuditMaxSizeMB rotates the audit log once it reaches this size. One
+	// previous log is kept.
+	AuditMaxSizeMB int `mapstructure:"audit_max_size_mb"`
+
+	// CrossFilesystem lets chain discovery search directories on another
+	// filesystem than the cascade root. When false, they are treated as
+	// having no .envrc, so network mounts are not statted at every prompt.
+	CrossFilesystem bool `mapstructure:"cross_filesystem"`
+
+	// CrossFilesystemAllow lists mount points searched even when
+	// CrossFilesystem is false. A leading ~ is the home directory.
+	CrossFilesystemAllow []string `mapstructure:"cross_filesystem_allow"`
+
+	// ChainOutsideRoot is ChainOutsideRootCwdOnly or ChainOutsideRootWalkUp.
+	// It decides where the chain of a directory that is not under the
+	// cascade root starts: at the directory itself, or at its topmost
+	// ancestor on the same filesystem, like direnv.
+	ChainOutsideRoot string `mapstructure:"chain_outside_root"`
+
+	// MaxWalkDepth bounds how many directories above the current one
+	// ChainOutsideRootWalkUp looks at. Zero means no bound.
+	MaxWalkDepth int `mapstructure:"max_walk_depth"`
+
+	// RevertMode is RevertModeKeep or RevertModeForce. When keep, a variable
+	// changed in the shell after export applied it is left as it is instead
+	// of being reverted, and export stops managing it for the session.
+	RevertMode string `mapstructure:"revert_mode"`
+
+	// LogLevel is how much cascade prints on stderr: error, warn, info or
+	// debug (see logging.ParseLevel). The --log-level flag overrides it.
+	LogLevel string `mapstructure:"log_level"`
+
+	// FishListVars are the variables export fish sets as lists, one
+	// element per colon-separated segment.
+	FishListVars []string `mapstructure:"fish_list_vars"`
+
+	// ValueWidth is how many characters of a long value status and tree
+	// show before truncating it (see --full). 0 never truncates.
+	ValueWidth int `mapstructure:"value_width"`
+
+	// DirenvCompat makes export also set DIRENV_DIR and DIRENV_FILE to
+	// the values of CASCADE_DIR and CASCADE_FILE, for tools that look for
+	// direnv's.
+	DirenvCompat bool `mapstructure:"direnv_compat"`
+
+	// File is the config file Load read, or empty if none was found.
+	File string `mapstructure:"-"`
+
+	// Profile is the profile LoadForDir applied, or nil.
+	Profile *Profile `mapstructure:"-"`
 }
 
+// DefaultSystemDataDir is where a system-wide allow store is looked for.
+const DefaultSystemDataDir = "/usr/local/share/cascade"
+
+// Default evaluation cache limits.
+const (
+	DefaultCacheMaxAge     = "30d"
+	DefaultCacheMaxEntries = 10000
+	DefaultCacheMaxSizeMB  = 100
+)
+
+// DefaultAuditMaxSizeMB is the size at which the audit log is rotated.
+const DefaultAuditMaxSizeMB = 4
+
+// DefaultValueWidth is how many characters of a long value status and
+// tree show by default.
+const DefaultValueWidth = 60
+
+// Values of root_envrc.
+const (
+	RootEnvrcOptional = "optional"
+	RootEnvrcRequired = "required"
+)
+
+// Values of chain_outside_root.
+const (
+	ChainOutsideRootCwdOnly = "cwd-only"
+	ChainOutsideRootWalkUp  = "walk-up"
+)
+
+// Values of revert_mode.
+const (
+	RevertModeKeep  = "keep"
+	RevertModeForce = "force"
+)
+
 // Default returns a Config with default values.
 func Default() *Config {
 	return &Config{
-		WhitelistPrefix: nil,
-		BashPath:        "",
-		DisabledShells:  nil,
-		CascadeRoot:     "",
-		CacheEnabled:    true,
-		LogEnvDiff:      true,
+		WhitelistPrefix:    nil,
+		BashPath:           "",
+		DisabledShells:     nil,
+		CascadeRoot:        "",
+		CacheEnabled:       true,
+		LogEnvDiff:         true,
+		LogEnvDiffMax:      10,
+		LogEnvDiffValues:   false,
+		WorkspaceStore:     "",
+		EvalStderrLines:    20,
+		SlowWarningMS:      500,
+		TrustedRemotes:     nil,
+		CacheMaxAge:        DefaultCacheMaxAge,
+		CacheMaxEntries:    DefaultCacheMaxEntries,
+		CacheMaxSizeMB:     DefaultCacheMaxSizeMB,
+		CacheExclude:       nil,
+		MaskPatterns:       nil,
+		WatchHash:          false,
+		ExportFastPath:     false,
+		SystemDataDir:      DefaultSystemDataDir,
+		SkipMarkers:        nil,
+		UpdateManifest:     "",
+		DisableUpdateCheck: false,
+		RootEnvrc:          RootEnvrcOptional,
+		SessionExportFile:  "",
+		HookResolvePath:    false,
+		AuditKeepContent:   false,
+		AuditMaxSizeMB:     DefaultAuditMaxSizeMB,
+		CrossFilesystem:    false,
+		ChainOutsideRoot:   ChainOutsideRootCwdOnly,
+		MaxWalkDepth:       0,
+		RevertMode:         RevertModeKeep,
+		LogLevel:           logging.DefaultLevel.String(),
+		FishListVars:       shell.DefaultFishListVars,
+		ValueWidth:         DefaultValueWidth,
+		DirenvCompat:       false,
 	}
 }
 
 // Load reads configuration from file and environment variables.
 // Configuration is loaded from (in order of precedence):
 //  1. Environment variables (CASCADE_*)
-//  2. Config file ($XDG_CONFIG_HOME/cascade/config.toml or ~/.config/cascade/config.toml)
-//  3. Default values
+//  2. The matching profile in the config file, with LoadForDir
+//  3. Config file ($XDG_CONFIG_HOME/cascade/config.toml or ~/.config/cascade/config.toml)
+//  4. Default values
 func Load() (*Config, error) {
+	return LoadForDir("")
+}
+
+// LoadForDir is Load with the profile matching dir merged over the config
+// file: the one whose match_prefix contains dir, the longest if several
+// do (see Profile). With dir empty, no profile applies.
+func LoadForDir(dir string) (*Config, error) {
 	v := viper.New()
 
 	// Set defaults for all config keys
@@ -59,6 +280,37 @@ func Load() (*Config, error) {
 	v.SetDefault("cascade_root", "")
 	v.SetDefault("cache_enabled", true)
 	v.SetDefault("log_env_diff", true)
+	v.SetDefault("log_env_diff_max", 10)
+	v.SetDefault("log_env_diff_values", false)
+	v.SetDefault("workspace_store", "")
+	v.SetDefault("eval_stderr_lines", 20)
+	v.SetDefault("slow_warning_ms", 500)
+	v.SetDefault("trusted_remotes", []string{})
+	v.SetDefault("cache_max_age", DefaultCacheMaxAge)
+	v.SetDefault("cache_max_entries", DefaultCacheMaxEntries)
+	v.SetDefault("cache_max_size_mb", DefaultCacheMaxSizeMB)
+	v.SetDefault("cache_exclude", []string{})
+	v.SetDefault("mask_patterns", []string{})
+	v.SetDefault("watch_hash", false)
+	v.SetDefault("export_fast_path", false)
+	v.SetDefault("system_data_dir", DefaultSystemDataDir)
+	v.SetDefault("skip_markers", []string{})
+	v.SetDefault("update_manifest", "")
+	v.SetDefault("disable_update_check", false)
+	v.SetDefault("root_envrc", RootEnvrcOptional)
+	v.SetDefault("session_export_file", "")
+	v.SetDefault("hook_resolve_path", false)
+	v.SetDefault("audit_keep_content", false)
+	v.SetDefault("audit_max_size_mb", DefaultAuditMaxSizeMB)
+	v.SetDefault("cross_filesystem", false)
+	v.SetDefault("cross_filesystem_allow", []string{})
+	v.SetDefault("chain_outside_root", ChainOutsideRootCwdOnly)
+	v.SetDefault("max_walk_depth", 0)
+	v.SetDefault("revert_mode", RevertModeKeep)
+	v.SetDefault("log_level", logging.DefaultLevel.String())
+	v.SetDefault("fish_list_vars", shell.DefaultFishListVars)
+	v.SetDefault("value_width", DefaultValueWidth)
+	v.SetDefault("direnv_compat", false)
 
 	// Config file settings
 	v.SetConfigName("config")
@@ -85,34 +337,41 @@ func Load() (*Config, error) {
 			return nil, err
 		}
 	}
+	profile, err := applyProfile(v, dir)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := Default()
 	if err := v.Unmarshal(cfg); err != nil {
 		return nil, err
 	}
+	cfg.File = v.ConfigFileUsed()
+	cfg.Profile = profile
 
-	return cfg, nil
-}
-
-// ConfigFile returns the path to the config file that was loaded, or empty if none.
-func ConfigFile() string {
-	v := viper.New()
-	v.SetConfigName("config")
-	v.SetConfigType("toml")
-
-	if xdgConfig := os.Getenv("XDG_CONFIG_HOME"); xdgConfig != "" {
-		v.AddConfigPath(filepath.Join(xdgConfig, "cascade"))
+	if cfg.RootEnvrc != RootEnvrcOptional && cfg.RootEnvrc != RootEnvrcRequired {
+		return nil, fmt.Errorf("invalid root_envrc %q (want %q or %q)", cfg.RootEnvrc, RootEnvrcOptional, RootEnvrcRequired)
 	}
-
-	if home, err := os.UserHomeDir(); err == nil {
-		v.AddConfigPath(filepath.Join(home, ".config", "cascade"))
+	if cfg.ChainOutsideRoot != ChainOutsideRootCwdOnly && cfg.ChainOutsideRoot != ChainOutsideRootWalkUp {
+		return nil, fmt.Errorf("invalid chain_outside_root %q (want %q or %q)", cfg.ChainOutsideRoot, ChainOutsideRootCwdOnly, ChainOutsideRootWalkUp)
 	}
-
-	if err := v.ReadInConfig(); err != nil {
-		return ""
+	if cfg.MaxWalkDepth < 0 {
+		return nil, fmt.Errorf("invalid max_walk_depth %d (want 0 or more)", cfg.MaxWalkDepth)
+	}
+	if cfg.ValueWidth < 0 {
+		return nil, fmt.Errorf("invalid value_width %d (want 0 or more)", cfg.ValueWidth)
+	}
+	if cfg.RevertMode != RevertModeKeep && cfg.RevertMode != RevertModeForce {
+		return nil, fmt.Errorf("invalid revert_mode %q (want %q or %q)", cfg.RevertMode, RevertModeKeep, RevertModeForce)
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("invalid log_level: %w", err)
+	}
+	if _, err := ParseAge(cfg.CacheMaxAge); err != nil {
+		return nil, fmt.Errorf("invalid cache_max_age: %w", err)
 	}
 
-	return v.ConfigFileUsed()
+	return cfg, nil
 }
 
 // IsWhitelisted checks if a path is under any whitelisted prefix.
@@ -131,20 +390,60 @@ func (c *Config) IsWhitelisted(path string) bool {
 			continue
 		}
 
-		// Check if path is under prefix
-		// We need to ensure it's a proper prefix (directory boundary)
-		if strings.HasPrefix(cleanPath, cleanPrefix) {
-			// Ensure we're at a directory boundary
-			rest := cleanPath[len(cleanPrefix):]
-			if rest == "" || rest[0] == filepath.Separator {
-				return true
-			}
+		// Check if path is under prefix, at a directory boundary
+		if underPrefix(cleanPath, cleanPrefix) {
+			return true
 		}
 	}
 
 	return false
 }
 
+// CrossFilesystemMounts returns CrossFilesystemAllow as absolute, clean
+// paths, with a leading ~ expanded to the home directory. Entries that
+// cannot be made absolute are dropped.
+func (c *Config) CrossFilesystemMounts() []string {
+	if c == nil {
+		return nil
+	}
+	var mounts []string
+	for _, mount := range c.CrossFilesystemAllow {
+		mount = expandHome(mount)
+		if !filepath.IsAbs(mount) {
+			continue
+		}
+		mounts = append(mounts, filepath.Clean(mount))
+	}
+	return mounts
+}
+
+// CacheMaxAgeDuration returns CacheMaxAge as a duration, 0 for no limit.
+// Load rejects values that do not parse, which are treated as no limit.
+func (c *Config) CacheMaxAgeDuration() time.Duration {
+	if c == nil {
+		return 0
+	}
+	d, _ := ParseAge(c.CacheMaxAge)
+	return d
+}
+
+// ParseAge parses a non-negative age: a number of days such as "30d", or a
+// Go duration such as "12h" or "90m". "0" and "" are zero.
+func ParseAge(s string) (time.Duration, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%q is not a duration like 30d or 12h", s)
+}
+
 // IsShellDisabled checks if a shell is in the disabled list.
 func (c *Config) IsShellDisabled(shell string) bool {
 	if c == nil {
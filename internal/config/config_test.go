@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefault(t *testing.T) {
@@ -30,6 +33,10 @@ func TestDefault(t *testing.T) {
 	if !cfg.LogEnvDiff {
 		t.Error("LogEnvDiff should default to true")
 	}
+
+	if cfg.LogEnvDiffMax != 10 || cfg.LogEnvDiffValues {
+		t.Errorf("LogEnvDiffMax = %d, LogEnvDiffValues = %v, want 10 and false", cfg.LogEnvDiffMax, cfg.LogEnvDiffValues)
+	}
 }
 
 func TestIsWhitelisted(t *testing.T) {
@@ -323,6 +330,10 @@ func TestLoad_NoConfigFile(t *testing.T) {
 	if !cfg.LogEnvDiff {
 		t.Error("LogEnvDiff should default to true")
 	}
+
+	if cfg.File != "" {
+		t.Errorf("File = %q, want empty without a config file", cfg.File)
+	}
 }
 
 func TestLoad_EnvOverride(t *testing.T) {
@@ -353,3 +364,381 @@ func TestLoad_EnvOverride(t *testing.T) {
 		t.Error("LogEnvDiff should be false from env")
 	}
 }
+
+func TestLoad_RootEnvrc(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RootEnvrc != RootEnvrcOptional {
+		t.Errorf("RootEnvrc = %q, want %q", cfg.RootEnvrc, RootEnvrcOptional)
+	}
+
+	t.Setenv("CASCADE_ROOT_ENVRC", "required")
+	if cfg, err := Load(); err != nil || cfg.RootEnvrc != RootEnvrcRequired {
+		t.Errorf("Load() = %v, %v; want root_envrc required", cfg, err)
+	}
+
+	t.Setenv("CASCADE_ROOT_ENVRC", "sometimes")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid root_envrc") {
+		t.Errorf("Load() error = %v, want invalid root_envrc", err)
+	}
+}
+
+func TestLoad_RevertMode(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RevertMode != RevertModeKeep {
+		t.Errorf("RevertMode = %q, want %q", cfg.RevertMode, RevertModeKeep)
+	}
+
+	t.Setenv("CASCADE_REVERT_MODE", "force")
+	if cfg, err := Load(); err != nil || cfg.RevertMode != RevertModeForce {
+		t.Errorf("Load() = %v, %v; want revert_mode force", cfg, err)
+	}
+
+	t.Setenv("CASCADE_REVERT_MODE", "never")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid revert_mode") {
+		t.Errorf("Load() error = %v, want invalid revert_mode", err)
+	}
+}
+
+func TestLoad_LogLevel(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogLevel != "info" {
+		t.Errorf("LogLevel = %q, want info", cfg.LogLevel)
+	}
+
+	t.Setenv("CASCADE_LOG_LEVEL", "debug")
+	if cfg, err := Load(); err != nil || cfg.LogLevel != "debug" {
+		t.Errorf("Load() = %v, %v; want log_level debug", cfg, err)
+	}
+
+	t.Setenv("CASCADE_LOG_LEVEL", "loud")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid log_level") {
+		t.Errorf("Load() error = %v, want invalid log_level", err)
+	}
+}
+
+func TestLoad_DisableUpdateCheck(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DisableUpdateCheck {
+		t.Error("DisableUpdateCheck = true, want false by default")
+	}
+
+	t.Setenv("CASCADE_DISABLE_UPDATE_CHECK", "true")
+	if cfg, err := Load(); err != nil || !cfg.DisableUpdateCheck {
+		t.Errorf("Load() = %v, %v; want disable_update_check true", cfg, err)
+	}
+}
+
+func TestLoad_FishListVars(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := []string{"PATH", "MANPATH", "CDPATH"}; !slices.Equal(cfg.FishListVars, want) {
+		t.Errorf("FishListVars = %q, want %q", cfg.FishListVars, want)
+	}
+
+	configDir := filepath.Join(home, ".config", "cascade")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(`fish_list_vars = ["PATH", "XDG_DATA_DIRS"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := []string{"PATH", "XDG_DATA_DIRS"}; !slices.Equal(cfg.FishListVars, want) {
+		t.Errorf("FishListVars = %q, want %q", cfg.FishListVars, want)
+	}
+}
+
+func TestLoad_ChainOutsideRoot(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ChainOutsideRoot != ChainOutsideRootCwdOnly || cfg.MaxWalkDepth != 0 {
+		t.Errorf("ChainOutsideRoot, MaxWalkDepth = %q, %d, want %q, 0", cfg.ChainOutsideRoot, cfg.MaxWalkDepth, ChainOutsideRootCwdOnly)
+	}
+
+	t.Setenv("CASCADE_CHAIN_OUTSIDE_ROOT", "walk-up")
+	t.Setenv("CASCADE_MAX_WALK_DEPTH", "3")
+	if cfg, err := Load(); err != nil || cfg.ChainOutsideRoot != ChainOutsideRootWalkUp || cfg.MaxWalkDepth != 3 {
+		t.Errorf("Load() = %v, %v; want chain_outside_root walk-up, max_walk_depth 3", cfg, err)
+	}
+
+	t.Setenv("CASCADE_MAX_WALK_DEPTH", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid max_walk_depth") {
+		t.Errorf("Load() error = %v, want invalid max_walk_depth", err)
+	}
+
+	t.Setenv("CASCADE_MAX_WALK_DEPTH", "")
+	t.Setenv("CASCADE_CHAIN_OUTSIDE_ROOT", "everywhere")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid chain_outside_root") {
+		t.Errorf("Load() error = %v, want invalid chain_outside_root", err)
+	}
+}
+
+// writeConfig writes content as the config file under a temporary HOME and
+// returns that HOME.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	home := t.TempDir()
+	configDir := filepath.Join(home, ".config", "cascade")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(content), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	return home
+}
+
+func TestLoadForDir_Profiles(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	home := writeConfig(t, `
+cascade_root = "/base"
+eval_stderr_lines = 5
+
+[profiles.clients]
+match_prefix = "~/clients"
+cascade_root = "/clients"
+slow_warning_ms = 1000
+
+[profiles.acme]
+match_prefix = "~/clients/acme"
+cascade_root = "/acme"
+cache_enabled = false
+`)
+	acme := filepath.Join(home, "clients", "acme", "api")
+
+	tests := []struct {
+		name        string
+		dir         string
+		env         map[string]string
+		wantProfile string
+		wantRoot    string
+		wantCache   bool
+		wantSlow    int
+	}{
+		{"no directory", "", nil, "", "/base", true, 500},
+		{"outside every profile", filepath.Join(home, "personal"), nil, "", "/base", true, 500},
+		{"sibling of a prefix", filepath.Join(home, "clients-old"), nil, "", "/base", true, 500},
+		{"shorter prefix", filepath.Join(home, "clients", "other"), nil, "clients", "/clients", true, 1000},
+		{"longest prefix wins", acme, nil, "acme", "/acme", false, 500},
+		{"prefix itself", filepath.Join(home, "clients", "acme"), nil, "acme", "/acme", false, 500},
+		{"environment over profile", acme, map[string]string{"CASCADE_CASCADE_ROOT": "/env"}, "acme", "/env", false, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadForDir(tt.dir)
+			if err != nil {
+				t.Fatalf("LoadForDir() error = %v", err)
+			}
+
+			gotProfile := ""
+			if cfg.Profile != nil {
+				gotProfile = cfg.Profile.Name
+			}
+			if gotProfile != tt.wantProfile {
+				t.Errorf("Profile = %q, want %q", gotProfile, tt.wantProfile)
+			}
+			if cfg.CascadeRoot != tt.wantRoot || cfg.CacheEnabled != tt.wantCache || cfg.SlowWarningMS != tt.wantSlow {
+				t.Errorf("cascade_root, cache_enabled, slow_warning_ms = %q, %v, %d, want %q, %v, %d",
+					cfg.CascadeRoot, cfg.CacheEnabled, cfg.SlowWarningMS, tt.wantRoot, tt.wantCache, tt.wantSlow)
+			}
+			// Base file values the profile does not set still apply
+			if cfg.EvalStderrLines != 5 {
+				t.Errorf("EvalStderrLines = %d, want 5 from the base file", cfg.EvalStderrLines)
+			}
+		})
+	}
+
+	// Settings overridden by the environment did not come from the profile
+	t.Setenv("CASCADE_CASCADE_ROOT", "/env")
+	cfg, err := LoadForDir(acme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Profile.Settings["cascade_root"]; ok || cfg.Profile.Settings["cache_enabled"] != false || cfg.Profile.MatchPrefix != "~/clients/acme" {
+		t.Errorf("Profile = %+v, want cache_enabled only", cfg.Profile)
+	}
+}
+
+func TestLoadForDir_InvalidProfile(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	for name, content := range map[string]string{
+		"match_prefix is not set": "[profiles.acme]\ncache_enabled = false\n",
+		"not a table":             "profiles = { acme = 1 }\n",
+		"invalid revert_mode":     "[profiles.acme]\nmatch_prefix = \"/\"\nrevert_mode = \"never\"\n",
+	} {
+		writeConfig(t, content)
+		if _, err := LoadForDir(t.TempDir()); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("LoadForDir() with %q: error = %v, want %s", content, err, name)
+		}
+	}
+}
+
+func TestLoad_CacheMaxAge(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.CacheMaxAgeDuration(); got != 30*24*time.Hour {
+		t.Errorf("CacheMaxAgeDuration() = %v, want 30 days", got)
+	}
+
+	t.Setenv("CASCADE_CACHE_MAX_AGE", "12h")
+	if cfg, err := Load(); err != nil || cfg.CacheMaxAgeDuration() != 12*time.Hour {
+		t.Errorf("Load() = %v, %v; want cache_max_age 12h", cfg, err)
+	}
+
+	t.Setenv("CASCADE_CACHE_MAX_AGE", "a month")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid cache_max_age") {
+		t.Errorf("Load() error = %v, want invalid cache_max_age", err)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"-1d", 0, true},
+		{"-5m", 0, true},
+		{"d", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAge(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCrossFilesystemMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	cfg := &Config{CrossFilesystemAllow: []string{"~/mnt/projects", "/srv/data/", "relative/dir", "~"}}
+	got := cfg.CrossFilesystemMounts()
+	want := []string{filepath.Join(home, "mnt", "projects"), "/srv/data", home}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("CrossFilesystemMounts() = %v, want %v", got, want)
+	}
+
+	var nilCfg *Config
+	if got := nilCfg.CrossFilesystemMounts(); got != nil {
+		t.Errorf("nil CrossFilesystemMounts() = %v, want nil", got)
+	}
+}
+
+func TestLoad_ValueWidth(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ValueWidth != DefaultValueWidth {
+		t.Errorf("ValueWidth = %d, want %d", cfg.ValueWidth, DefaultValueWidth)
+	}
+
+	t.Setenv("CASCADE_VALUE_WIDTH", "0")
+	if cfg, err := Load(); err != nil || cfg.ValueWidth != 0 {
+		t.Errorf("Load() = %v, %v; want value_width 0", cfg, err)
+	}
+
+	t.Setenv("CASCADE_VALUE_WIDTH", "-5")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid value_width") {
+		t.Errorf("Load() error = %v, want invalid value_width", err)
+	}
+}
+
+func TestLoad_DirenvCompat(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DirenvCompat {
+		t.Error("DirenvCompat should be off by default")
+	}
+
+	t.Setenv("CASCADE_DIRENV_COMPAT", "1")
+	if cfg, err := Load(); err != nil || !cfg.DirenvCompat {
+		t.Errorf("Load() = %v, %v; want direnv_compat with CASCADE_DIRENV_COMPAT=1", cfg, err)
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Profile is the entry of the [profiles] table LoadForDir applied:
+//
+//	[profiles.acme]
+//	match_prefix = "~/clients/acme"
+//	cache_enabled = false
+//
+// Its settings take precedence over the rest of the config file, and
+// environment variables over them.
+type Profile struct {
+	Name        string         `json:"name"`
+	MatchPrefix string         `json:"match_prefix"` // As written, with a leading ~ unexpanded
+	Settings    map[string]any `json:"settings"`     // Values it set that no environment variable overrides
+}
+
+// applyProfile merges the profile matching dir into v's config file
+// settings and returns it, or nil if none matches.
+func applyProfile(v *viper.Viper, dir string) (*Profile, error) {
+	tables := v.GetStringMap("profiles")
+	if len(tables) == 0 || dir == "" {
+		return nil, nil
+	}
+	dir = filepath.Clean(dir)
+
+	var best *Profile
+	bestLen := -1
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Ties go to the first name
+	for _, name := range names {
+		table, ok := tables[name].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid profile %q: not a table", name)
+		}
+		prefix, _ := table["match_prefix"].(string)
+		if prefix == "" {
+			return nil, fmt.Errorf("invalid profile %q: match_prefix is not set", name)
+		}
+		if _, ok := table["profiles"]; ok {
+			return nil, fmt.Errorf("invalid profile %q: profiles cannot be nested", name)
+		}
+		expanded := expandHome(prefix)
+		if !filepath.IsAbs(expanded) || !underPrefix(dir, expanded) || len(expanded) <= bestLen {
+			continue
+		}
+		best = &Profile{Name: name, MatchPrefix: prefix, Settings: table}
+		bestLen = len(expanded)
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	settings := make(map[string]any, len(best.Settings))
+	for key, value := range best.Settings {
+		if key != "match_prefix" {
+			settings[key] = value
+		}
+	}
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("apply profile %q: %w", best.Name, err)
+	}
+
+	// What an environment variable overrides did not come from the profile
+	best.Settings = make(map[string]any, len(settings))
+	for key, value := range settings {
+		if _, ok := os.LookupEnv("CASCADE_" + strings.ToUpper(key)); !ok {
+			best.Settings[key] = value
+		}
+	}
+	return best, nil
+}
+
+// expandHome replaces a leading ~ in path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// underPrefix reports whether path is prefix or below it. Both must be
+// clean. Windows paths are compared without regard to case.
+func underPrefix(path, prefix string) bool {
+	if runtime.GOOS == "windows" {
+		path, prefix = strings.ToLower(path), strings.ToLower(prefix)
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	rest := path[len(prefix):]
+	return rest == "" || rest[0] == filepath.Separator || strings.HasSuffix(prefix, string(filepath.Separator))
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package config
+
+import "testing"
+
+func TestIsWhitelisted_Windows(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{WhitelistPrefix: []string{`C:\Work\Trusted`, `d:\`}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{`C:\Work\Trusted\project`, true},
+		{`c:\work\trusted\project`, true},
+		{`C:\WORK\TRUSTED`, true},
+		{`C:\Work\TrustedNot\project`, false},
+		{`D:\anything`, true},
+		{`E:\Work\Trusted\project`, false},
+	}
+	for _, tt := range tests {
+		if got := cfg.IsWhitelisted(tt.path); got != tt.want {
+			t.Errorf("IsWhitelisted(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package env
 
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
 // EnvDiff represents changes between two environments.
 // It captures the minimal information needed to transform one environment
 // into another, and to reverse that transformation.
@@ -15,6 +22,29 @@ type EnvDiff struct {
 	// For added keys: the new value from e2.
 	// For removed keys: empty string (key should be deleted).
 	Next map[string]string `json:"n"`
+
+	// Merge lists the list-merged variables among the keys, with their
+	// separators. Patch moves only their changed components when the
+	// variable was modified outside cascade.
+	Merge MergeSpec `json:"m,omitempty"`
+
+	// Sensitive lists variables the diff sets whose values are withheld
+	// (declared with sensitive_env), sorted. They are in neither Prev nor
+	// Next, so their values never reach CASCADE_DIFF or the state store.
+	// Reverting the diff unsets them; a value they had before cannot be
+	// restored.
+	Sensitive []string `json:"s,omitempty"`
+
+	// Kept lists variables changed outside cascade after a diff applied
+	// them, sorted (see Overridden and Keep). They are in neither Prev nor
+	// Next: later diffs in the session neither set nor revert them.
+	Kept []string `json:"k,omitempty"`
+
+	// KeptIn maps each Kept variable to the directory of the chain loaded
+	// when it was kept (CASCADE_DIR). It stays kept only while the chain
+	// loaded is that directory's or one below it, which still holds the
+	// level that set it (see KeepFrom).
+	KeptIn map[string]string `json:"ki,omitempty"`
 }
 
 // BuildEnvDiff computes the diff from e1 (before) to e2 (after).
@@ -59,6 +89,10 @@ func BuildEnvDiff(e1, e2 Env) *EnvDiff {
 
 // Patch applies the diff to an environment (for applying changes).
 // Keys with empty values in Next are deleted from the environment.
+// Sensitive variables have no recorded value and are left as they are.
+// Merged variables whose current value is not Prev (e.g. edited in the
+// shell since) get only the components that differ between Prev and Next
+// added or removed, leaving other components untouched.
 // Returns a new environment; the original is not modified.
 func (d *EnvDiff) Patch(env Env) Env {
 	if d == nil {
@@ -71,6 +105,10 @@ func (d *EnvDiff) Patch(env Env) Env {
 	}
 
 	for key, value := range d.Next {
+		if sep, ok := d.Merge[key]; ok && result[key] != d.Prev[key] {
+			added, removed := ListDiff(d.Prev[key], value, sep)
+			value = MergeList(result[key], added, removed, sep)
+		}
 		if value == "" {
 			delete(result, key)
 		} else {
@@ -82,7 +120,9 @@ func (d *EnvDiff) Patch(env Env) Env {
 }
 
 // Reverse returns a new diff that undoes this diff.
-// Applying the reversed diff restores the original environment.
+// Applying the reversed diff restores the original environment, except
+// that Sensitive variables are unset rather than restored. Reversing the
+// result again cannot bring their values back.
 func (d *EnvDiff) Reverse() *EnvDiff {
 	if d == nil {
 		return &EnvDiff{
@@ -91,10 +131,155 @@ func (d *EnvDiff) Reverse() *EnvDiff {
 		}
 	}
 
-	return &EnvDiff{
-		Prev: copyMap(d.Next),
-		Next: copyMap(d.Prev),
+	reversed := &EnvDiff{
+		Prev:  copyMap(d.Next),
+		Next:  copyMap(d.Prev),
+		Merge: d.Merge,
+	}
+	for _, key := range d.Sensitive {
+		reversed.Next[key] = ""
+	}
+	return reversed
+}
+
+// Conceal returns a copy of d with the variables in names moved from Prev
+// and Next to Sensitive, so the diff can be persisted without their
+// values. Names the diff does not change are ignored.
+func (d *EnvDiff) Conceal(names []string) *EnvDiff {
+	if d == nil {
+		return nil
+	}
+
+	concealed := &EnvDiff{
+		Prev:      copyMap(d.Prev),
+		Next:      copyMap(d.Next),
+		Merge:     d.Merge,
+		Sensitive: slices.Clone(d.Sensitive),
+		Kept:      d.Kept,
+		KeptIn:    d.KeptIn,
+	}
+	for _, key := range names {
+		if _, ok := concealed.Next[key]; !ok {
+			continue
+		}
+		delete(concealed.Prev, key)
+		delete(concealed.Next, key)
+		if _, ok := concealed.Merge[key]; ok {
+			concealed.Merge = concealed.Merge.Subset(concealed.Next)
+		}
+		concealed.Sensitive = append(concealed.Sensitive, key)
+	}
+	slices.Sort(concealed.Sensitive)
+	concealed.Sensitive = slices.Compact(concealed.Sensitive)
+	return concealed
+}
+
+// Overridden returns the variables d applied whose value in current is no
+// longer the one d set, sorted: changed, set, or unset in the shell since,
+// by the user or another tool. A variable d unsets is overridden if current
+// has it at all, even empty. List-merged variables are never reported, as
+// Patch already keeps edits to them, and neither are Sensitive ones, whose
+// applied value is not recorded.
+func (d *EnvDiff) Overridden(current Env) []string {
+	if d == nil {
+		return nil
+	}
+
+	var keys []string
+	for key, next := range d.Next {
+		if _, ok := d.Merge[key]; ok {
+			continue
+		}
+		value, set := current[key]
+		if next == "" && !set || next != "" && set && value == next {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ConsistentWith reports whether current still looks like an environment
+// d was applied to. A diff that set two or more variables fails the check
+// when none of them still has the value it set, as when CASCADE_DIFF
+// outlived a rebuild of the environment it describes; reverting it would
+// then restore values that were never there. Variables changed by hand,
+// while others keep their values, do not fail it. Merged variables and
+// the values d withholds are not compared.
+func (d *EnvDiff) ConsistentWith(current Env) bool {
+	if d == nil {
+		return true
+	}
+
+	compared, mismatched := 0, 0
+	for key, next := range d.Next {
+		if _, ok := d.Merge[key]; ok {
+			continue
+		}
+		compared++
+		if value, set := current[key]; value != next || set != (next != "") {
+			mismatched++
+		}
+	}
+	return compared < 2 || mismatched < compared
+}
+
+// Keep returns a copy of d that leaves keys alone: they are removed from
+// Prev and Next, so neither Patch nor the reversed diff touches them, and
+// recorded in Kept as kept in dir. A key already kept keeps its directory.
+func (d *EnvDiff) Keep(keys []string, dir string) *EnvDiff {
+	if d == nil || len(keys) == 0 {
+		return d
+	}
+
+	kept := &EnvDiff{
+		Prev:      copyMap(d.Prev),
+		Next:      copyMap(d.Next),
+		Merge:     d.Merge,
+		Sensitive: d.Sensitive,
+		Kept:      slices.Clone(d.Kept),
+		KeptIn:    maps.Clone(d.KeptIn),
+	}
+	if kept.KeptIn == nil {
+		kept.KeptIn = make(map[string]string, len(keys))
+	}
+	for _, key := range keys {
+		delete(kept.Prev, key)
+		delete(kept.Next, key)
+		if !slices.Contains(kept.Kept, key) {
+			kept.Kept = append(kept.Kept, key)
+			kept.KeptIn[key] = dir
+		}
+	}
+	kept.Merge = kept.Merge.Subset(kept.Next)
+	slices.Sort(kept.Kept)
+	return kept
+}
+
+// KeepFrom returns d keeping the variables prev kept, each in the
+// directory it was kept in, if dir is that directory or below it. The
+// others are left to d: the chain loaded at dir no longer holds every
+// level that could have set them, as after moving to a sibling directory,
+// so its values apply again. A variable kept without a directory is not
+// carried over.
+func (d *EnvDiff) KeepFrom(prev *EnvDiff, dir string) *EnvDiff {
+	if prev == nil {
+		return d
+	}
+	for _, key := range prev.Kept {
+		keptIn, ok := prev.KeptIn[key]
+		if ok && inOrBelow(dir, keptIn) {
+			d = d.Keep([]string{key}, keptIn)
+		}
 	}
+	return d
+}
+
+// inOrBelow reports whether dir is parent or below it. Both must be clean.
+func inOrBelow(dir, parent string) bool {
+	sep := string(filepath.Separator)
+	return dir == parent || strings.HasPrefix(dir, strings.TrimSuffix(parent, sep)+sep)
 }
 
 // IsEmpty returns true if no changes are recorded in the diff.
@@ -102,7 +287,7 @@ func (d *EnvDiff) IsEmpty() bool {
 	if d == nil {
 		return true
 	}
-	return len(d.Next) == 0 && len(d.Prev) == 0
+	return len(d.Next) == 0 && len(d.Prev) == 0 && len(d.Sensitive) == 0 && len(d.Kept) == 0
 }
 
 // Equal returns true if two diffs represent the same changes.
@@ -113,7 +298,8 @@ func (d *EnvDiff) Equal(other *EnvDiff) bool {
 	if d == nil || other == nil {
 		return false
 	}
-	if len(d.Next) != len(other.Next) || len(d.Prev) != len(other.Prev) {
+	if len(d.Next) != len(other.Next) || len(d.Prev) != len(other.Prev) ||
+		!slices.Equal(d.Sensitive, other.Sensitive) || !slices.Equal(d.Kept, other.Kept) || !maps.Equal(d.KeptIn, other.KeptIn) {
 		return false
 	}
 	for k, v := range d.Next {
@@ -139,7 +325,7 @@ func (d *EnvDiff) EqualEffect(other *EnvDiff) bool {
 	if d == nil || other == nil {
 		return false
 	}
-	if len(d.Next) != len(other.Next) {
+	if len(d.Next) != len(other.Next) || !slices.Equal(d.Sensitive, other.Sensitive) || !slices.Equal(d.Kept, other.Kept) {
 		return false
 	}
 	for k, v := range d.Next {
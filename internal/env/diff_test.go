@@ -1,6 +1,10 @@
 package env
 
-import "testing"
+import (
+	"maps"
+	"slices"
+	"testing"
+)
 
 func TestEnvDiff_EqualEffect(t *testing.T) {
 	tests := []struct {
@@ -241,3 +245,331 @@ func TestEnvDiff_Equal(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvDiff_Conceal(t *testing.T) {
+	d := &EnvDiff{
+		Prev:  map[string]string{"TOKEN": "", "APP": "old", "LIST": "a"},
+		Next:  map[string]string{"TOKEN": "s3cret", "APP": "new", "LIST": "a,b"},
+		Merge: MergeSpec{"LIST": ","},
+	}
+
+	concealed := d.Conceal([]string{"TOKEN", "LIST", "UNCHANGED", "TOKEN"})
+
+	if _, ok := concealed.Next["TOKEN"]; ok {
+		t.Error("TOKEN still in Next")
+	}
+	if _, ok := concealed.Prev["TOKEN"]; ok {
+		t.Error("TOKEN still in Prev")
+	}
+	if concealed.Next["APP"] != "new" || concealed.Prev["APP"] != "old" {
+		t.Errorf("APP changed: %q -> %q", concealed.Prev["APP"], concealed.Next["APP"])
+	}
+	if got, want := concealed.Sensitive, []string{"LIST", "TOKEN"}; !slices.Equal(got, want) {
+		t.Errorf("Sensitive = %q, want %q", got, want)
+	}
+	if len(concealed.Merge) != 0 {
+		t.Errorf("Merge = %v, want LIST dropped", concealed.Merge)
+	}
+
+	// The original is untouched
+	if d.Next["TOKEN"] != "s3cret" || len(d.Sensitive) != 0 || d.Merge["LIST"] != "," {
+		t.Error("Conceal modified the original diff")
+	}
+
+	if again := concealed.Conceal([]string{"TOKEN"}); !again.Equal(concealed) {
+		t.Errorf("concealing twice changed the diff: %+v", again)
+	}
+}
+
+func TestEnvDiff_SensitivePatchReverse(t *testing.T) {
+	d := (&EnvDiff{
+		Prev: map[string]string{"TOKEN": "", "APP": ""},
+		Next: map[string]string{"TOKEN": "s3cret", "APP": "x"},
+	}).Conceal([]string{"TOKEN"})
+
+	// Applying leaves the sensitive variable as the shell has it
+	applied := d.Patch(Env{"TOKEN": "from-shell"})
+	if applied["TOKEN"] != "from-shell" || applied["APP"] != "x" {
+		t.Errorf("Patch() = %v", applied)
+	}
+
+	// Reverting unsets it, even if it existed before
+	reverted := d.Reverse().Patch(Env{"TOKEN": "s3cret", "APP": "x", "OTHER": "1"})
+	if _, ok := reverted["TOKEN"]; ok {
+		t.Error("TOKEN not unset by the reversed diff")
+	}
+	if _, ok := reverted["APP"]; ok {
+		t.Error("APP not reverted")
+	}
+	if reverted["OTHER"] != "1" {
+		t.Error("OTHER changed")
+	}
+	if d.Reverse().Sensitive != nil {
+		t.Error("reversed diff should not list sensitive variables")
+	}
+}
+
+func TestEnvDiff_SensitiveCompare(t *testing.T) {
+	base := func(sensitive ...string) *EnvDiff {
+		return &EnvDiff{Prev: map[string]string{}, Next: map[string]string{}, Sensitive: sensitive}
+	}
+
+	if base("TOKEN").IsEmpty() {
+		t.Error("diff with only a sensitive variable reported empty")
+	}
+	if !base().IsEmpty() {
+		t.Error("empty diff reported non-empty")
+	}
+	if base("TOKEN").Equal(base("OTHER")) || base("TOKEN").EqualEffect(base()) {
+		t.Error("diffs with different sensitive variables compared equal")
+	}
+	if !base("TOKEN").Equal(base("TOKEN")) || !base("TOKEN").EqualEffect(base("TOKEN")) {
+		t.Error("identical diffs compared unequal")
+	}
+}
+
+func TestMarshal_Sensitive(t *testing.T) {
+	d := (&EnvDiff{
+		Prev: map[string]string{"TOKEN": "", "APP": ""},
+		Next: map[string]string{"TOKEN": "s3cret", "APP": "x"},
+	}).Conceal([]string{"TOKEN"})
+
+	encoded, err := Marshal(d)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded, err := Unmarshal(encoded)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !decoded.Equal(d) {
+		t.Errorf("round trip = %+v, want %+v", decoded, d)
+	}
+
+	// A diff listing a sensitive variable with a value is refused
+	leaky := &EnvDiff{
+		Prev:      map[string]string{"TOKEN": ""},
+		Next:      map[string]string{"TOKEN": "s3cret"},
+		Sensitive: []string{"TOKEN"},
+	}
+	if _, err := Marshal(leaky); err == nil {
+		t.Error("Marshal encoded a sensitive value")
+	}
+}
+
+// TestEnvDiff_RevertOverridden reverts a diff after the shell changed one
+// variable, keeping it as Overridden and Keep do and as revert_mode
+// "force" would not.
+func TestEnvDiff_RevertOverridden(t *testing.T) {
+	unset := "<unset>"
+	tests := []struct {
+		name       string
+		prev, next string // Diff values; "" in next means the diff unset it
+		merge      bool
+		current    string // Value in the shell, or unset
+		overridden bool
+		want       string // Value after reverting with overridden keys kept
+	}{
+		{name: "added, untouched", prev: "", next: "nvim", current: "nvim", want: unset},
+		{name: "changed, untouched", prev: "vim", next: "nvim", current: "nvim", want: "vim"},
+		{name: "removed, untouched", prev: "vim", next: "", current: unset, want: "vim"},
+		{name: "added, changed manually", prev: "", next: "nvim", current: "emacs", overridden: true, want: "emacs"},
+		{name: "changed, changed manually", prev: "vim", next: "nvim", current: "emacs", overridden: true, want: "emacs"},
+		{name: "added, unset manually", prev: "", next: "nvim", current: unset, overridden: true, want: unset},
+		{name: "changed, unset manually", prev: "vim", next: "nvim", current: unset, overridden: true, want: unset},
+		{name: "changed, emptied manually", prev: "vim", next: "nvim", current: "", overridden: true, want: ""},
+		{name: "removed, set manually", prev: "vim", next: "", current: "emacs", overridden: true, want: "emacs"},
+		{name: "removed, set empty manually", prev: "vim", next: "", current: "", overridden: true, want: ""},
+		{name: "changed back to the original", prev: "vim", next: "nvim", current: "vim", overridden: true, want: "vim"},
+		{name: "merged, edited", prev: "a", next: "a:b", merge: true, current: "a:b:c", want: "a:c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &EnvDiff{
+				Prev: map[string]string{"EDITOR": tt.prev, "OTHER": "old"},
+				Next: map[string]string{"EDITOR": tt.next, "OTHER": "new"},
+			}
+			if tt.merge {
+				d.Merge = MergeSpec{"EDITOR": ":"}
+			}
+			current := Env{"OTHER": "new"}
+			if tt.current != unset {
+				current["EDITOR"] = tt.current
+			}
+
+			overridden := d.Overridden(current)
+			if got := slices.Contains(overridden, "EDITOR"); got != tt.overridden || slices.Contains(overridden, "OTHER") {
+				t.Fatalf("Overridden() = %q, want EDITOR: %v", overridden, tt.overridden)
+			}
+
+			kept := d.Keep(overridden, "/p")
+			reverted := kept.Reverse().Patch(current)
+			got, ok := reverted["EDITOR"]
+			if !ok {
+				got = unset
+			}
+			if got != tt.want {
+				t.Errorf("reverted EDITOR = %q, want %q", got, tt.want)
+			}
+			if reverted["OTHER"] != "old" {
+				t.Errorf("reverted OTHER = %q, want it reverted", reverted["OTHER"])
+			}
+			if tt.overridden != slices.Equal(kept.Kept, []string{"EDITOR"}) {
+				t.Errorf("Kept = %q", kept.Kept)
+			}
+		})
+	}
+}
+
+func TestEnvDiff_ConsistentWith(t *testing.T) {
+	d := &EnvDiff{
+		Prev:  map[string]string{"A": "", "B": "old", "C": "old", "GONE": "old", "LIST": "x"},
+		Next:  map[string]string{"A": "1", "B": "2", "C": "3", "GONE": "", "LIST": "x:y"},
+		Merge: MergeSpec{"LIST": ":"},
+	}
+	applied := Env{"A": "1", "B": "2", "C": "3", "LIST": "x:y"}
+
+	tests := []struct {
+		name    string
+		changes Env // Values to change in applied; "" unsets
+		want    bool
+	}{
+		{"as applied", nil, true},
+		{"one changed by hand", Env{"B": "edited"}, true},
+		{"all but one changed", Env{"A": "", "B": "edited", "C": "other"}, true},
+		{"all changed", Env{"A": "", "B": "edited", "C": "other", "GONE": "back"}, false},
+		{"merged variables are not compared", Env{"LIST": "z", "A": "", "B": "x", "C": "y", "GONE": "back"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := applied.Copy()
+			for key, value := range tt.changes {
+				if value == "" {
+					delete(current, key)
+				} else {
+					current[key] = value
+				}
+			}
+			if got := d.ConsistentWith(current); got != tt.want {
+				t.Errorf("ConsistentWith() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A single variable is never enough to call a diff stale
+	single := &EnvDiff{Prev: map[string]string{"PATH": "/bin"}, Next: map[string]string{"PATH": "/p/bin:/bin"}}
+	if !single.ConsistentWith(Env{"PATH": "/usr/bin"}) {
+		t.Error("ConsistentWith() = false for a one-variable diff")
+	}
+	if !(*EnvDiff)(nil).ConsistentWith(Env{}) {
+		t.Error("nil diff not consistent")
+	}
+}
+
+func TestEnvDiff_Keep(t *testing.T) {
+	d := &EnvDiff{
+		Prev:      map[string]string{"A": "", "LIST": "x", "B": "old"},
+		Next:      map[string]string{"A": "1", "LIST": "x,y", "B": "new"},
+		Merge:     MergeSpec{"LIST": ","},
+		Sensitive: []string{"TOKEN"},
+		Kept:      []string{"Z"},
+	}
+
+	kept := d.Keep([]string{"LIST", "A", "A", "Z"}, "/p")
+	if _, ok := kept.Next["A"]; ok {
+		t.Error("A still in Next")
+	}
+	if _, ok := kept.Prev["LIST"]; ok {
+		t.Error("LIST still in Prev")
+	}
+	if kept.Next["B"] != "new" || kept.Prev["B"] != "old" {
+		t.Errorf("B changed: %q -> %q", kept.Prev["B"], kept.Next["B"])
+	}
+	if got, want := kept.Kept, []string{"A", "LIST", "Z"}; !slices.Equal(got, want) {
+		t.Errorf("Kept = %q, want %q", got, want)
+	}
+	if got, want := kept.KeptIn, map[string]string{"A": "/p", "LIST": "/p"}; !maps.Equal(got, want) {
+		t.Errorf("KeptIn = %v, want %v (Z kept before keeps no directory)", got, want)
+	}
+	if len(kept.Merge) != 0 {
+		t.Errorf("Merge = %v, want LIST dropped", kept.Merge)
+	}
+	if !slices.Equal(kept.Sensitive, d.Sensitive) {
+		t.Errorf("Sensitive = %q, want it unchanged", kept.Sensitive)
+	}
+
+	// The original is untouched
+	if d.Next["A"] != "1" || len(d.Kept) != 1 || d.Merge["LIST"] != "," {
+		t.Error("Keep modified the original diff")
+	}
+
+	// A diff that only keeps variables is still recorded
+	onlyKept := (&EnvDiff{Prev: map[string]string{"A": ""}, Next: map[string]string{"A": "1"}}).Keep([]string{"A"}, "/p")
+	if onlyKept.IsEmpty() {
+		t.Error("diff with only a kept variable reported empty")
+	}
+	encoded, err := Marshal(onlyKept)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded, err := Unmarshal(encoded)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !decoded.Equal(onlyKept) {
+		t.Errorf("round trip = %+v, want %+v", decoded, onlyKept)
+	}
+	if decoded.EqualEffect(&EnvDiff{}) {
+		t.Error("diffs with different kept variables compared equal")
+	}
+}
+
+func TestEnvDiff_KeepFrom(t *testing.T) {
+	prev := (&EnvDiff{
+		Prev: map[string]string{"AWS_PROFILE": "", "REGION": ""},
+		Next: map[string]string{"AWS_PROFILE": "a", "REGION": "eu"},
+	}).Keep([]string{"AWS_PROFILE"}, "/p/a")
+	prev.Kept = append(prev.Kept, "ORPHAN") // from an older cascade, without a directory
+
+	tests := []struct {
+		name string
+		dir  string
+		kept bool
+	}{
+		{"same chain", "/p/a", true},
+		{"deeper", "/p/a/sub", true},
+		{"sibling", "/p/b", false},
+		{"sibling with the same prefix", "/p/ab", false},
+		{"parent", "/p", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &EnvDiff{
+				Prev: map[string]string{"AWS_PROFILE": "manual"},
+				Next: map[string]string{"AWS_PROFILE": "b"},
+			}
+			got := next.KeepFrom(prev, tt.dir)
+
+			if slices.Contains(got.Kept, "ORPHAN") {
+				t.Error("variable kept without a directory carried over")
+			}
+			if tt.kept {
+				if !slices.Equal(got.Kept, []string{"AWS_PROFILE"}) || got.KeptIn["AWS_PROFILE"] != "/p/a" {
+					t.Errorf("Kept = %q in %v, want AWS_PROFILE in /p/a", got.Kept, got.KeptIn)
+				}
+				if _, ok := got.Next["AWS_PROFILE"]; ok {
+					t.Error("kept AWS_PROFILE still set by the new diff")
+				}
+				return
+			}
+			if len(got.Kept) != 0 || got.Next["AWS_PROFILE"] != "b" {
+				t.Errorf("Kept = %q, Next = %v; want AWS_PROFILE=b applied again", got.Kept, got.Next)
+			}
+		})
+	}
+
+	if d := (&EnvDiff{}); d.KeepFrom(nil, "/p") != d {
+		t.Error("KeepFrom(nil) changed the diff")
+	}
+}
@@ -0,0 +1,187 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseDotenv parses the content of a .env file into variables.
+//
+// Each assignment is KEY=VALUE, optionally prefixed with export. Blank
+// lines and lines starting with # are skipped. A value is either:
+//   - single-quoted: taken literally, and may span lines;
+//   - double-quoted: may span lines, with the escapes \n, \r, \t, \", \\,
+//     \$ and \`, and a backslash before a newline joining the lines;
+//   - unquoted: the rest of the line, up to a # preceded by whitespace,
+//     with surrounding whitespace removed.
+//
+// Nothing is expanded: $VAR and $(cmd) are kept as they are. A later
+// assignment of the same key wins. Errors name the line they occur on.
+func ParseDotenv(data []byte) (Env, error) {
+	p := &dotenvParser{src: string(data), line: 1}
+	vars := make(Env)
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return vars, nil
+		}
+		line := p.line
+		key, value, err := p.assignment()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		vars[key] = value
+	}
+}
+
+// dotenvParser scans src from pos, counting lines for errors.
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// skipBlank skips whitespace, blank lines and comment lines.
+func (p *dotenvParser) skipBlank() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// skipLine moves past the end of the current line.
+func (p *dotenvParser) skipLine() {
+	if i := strings.IndexByte(p.src[p.pos:], '\n'); i >= 0 {
+		p.pos += i + 1
+		p.line++
+	} else {
+		p.pos = len(p.src)
+	}
+}
+
+// restOfLine returns the current line from pos, without the newline, and
+// moves past it.
+func (p *dotenvParser) restOfLine() string {
+	start := p.pos
+	p.skipLine()
+	return strings.TrimSuffix(strings.TrimSuffix(p.src[start:p.pos], "\n"), "\r")
+}
+
+// assignment parses one KEY=VALUE assignment starting at pos.
+func (p *dotenvParser) assignment() (key, value string, err error) {
+	if rest, ok := strings.CutPrefix(p.src[p.pos:], "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+		p.pos += len("export")
+		for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+			p.pos++
+		}
+	}
+
+	end := strings.IndexAny(p.src[p.pos:], "=\n")
+	if end < 0 || p.src[p.pos+end] != '=' {
+		return "", "", fmt.Errorf("expected KEY=VALUE, got %q", strings.TrimSpace(p.restOfLine()))
+	}
+	key = strings.TrimRight(p.src[p.pos:p.pos+end], " \t")
+	if !validName(key) {
+		return "", "", fmt.Errorf("invalid variable name %q", key)
+	}
+	p.pos += end + 1
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+
+	if p.pos >= len(p.src) {
+		return key, "", nil
+	}
+	switch p.src[p.pos] {
+	case '\'', '"':
+		value, err = p.quoted()
+		if err != nil {
+			return "", "", err
+		}
+		if rest := strings.TrimSpace(p.restOfLine()); rest != "" && rest[0] != '#' {
+			return "", "", fmt.Errorf("unexpected %q after the closing quote of %s", rest, key)
+		}
+		return key, value, nil
+	default:
+		return key, unquotedValue(p.restOfLine()), nil
+	}
+}
+
+// quoted parses a single- or double-quoted value starting at pos and moves
+// past its closing quote.
+func (p *dotenvParser) quoted() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return sb.String(), nil
+		case c == '\n':
+			p.line++
+			sb.WriteByte(c)
+		case c == '\\' && quote == '"' && p.pos < len(p.src):
+			next := p.src[p.pos]
+			p.pos++
+			switch next {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '"', '\\', '$', '`':
+				sb.WriteByte(next)
+			case '\n':
+				p.line++ // Line continuation
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(next)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated quoted value")
+}
+
+// unquotedValue returns an unquoted value without a trailing comment and
+// surrounding whitespace.
+func unquotedValue(s string) string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '#' && (s[i-1] == ' ' || s[i-1] == '\t') {
+			s = s[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// validName reports whether name can be exported as a shell variable.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
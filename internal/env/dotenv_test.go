@@ -0,0 +1,68 @@
+package env
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Env
+	}{
+		{"empty", "", Env{}},
+		{"comments and blank lines", "# comment\n\n  # indented\nA=1\n", Env{"A": "1"}},
+		{"export prefix", "export A=1\nexport\tB=2\n", Env{"A": "1", "B": "2"}},
+		{"name starting with export", "exported=1\n", Env{"exported": "1"}},
+		{"spaces around equals", "A = 1 \n", Env{"A": "1"}},
+		{"empty value", "A=\nB=", Env{"A": "", "B": ""}},
+		{"unquoted inline comment", "A=one two # comment\nB=x#y\n", Env{"A": "one two", "B": "x#y"}},
+		{"unquoted keeps quotes inside", "A=it's\n", Env{"A": "it's"}},
+		{"no expansion", "A=$HOME\nB=\"$(id)\"\nC='${X}'\n", Env{"A": "$HOME", "B": "$(id)", "C": "${X}"}},
+		{"single quotes are literal", `A='a\nb "c" # d'`, Env{"A": `a\nb "c" # d`}},
+		{"double quote escapes", "A=\"a\\nb\\t\\\"c\\\" \\\\ \\$ \\` \\x\"", Env{"A": "a\nb\t\"c\" \\ $ ` \\x"}},
+		{"multiline quoted", "A=\"one\ntwo\"\nB='x\ny'\n", Env{"A": "one\ntwo", "B": "x\ny"}},
+		{"escaped newline", "A=\"one \\\ntwo\"\n", Env{"A": "one two"}},
+		{"comment after quote", `A="x" # comment`, Env{"A": "x"}},
+		{"CRLF line endings", "A=1\r\nB=\"2\"\r\n", Env{"A": "1", "B": "2"}},
+		{"later assignment wins", "A=1\nA=2\n", Env{"A": "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDotenv([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("ParseDotenv() error = %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("ParseDotenv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDotenv_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"missing equals", "A=1\nJUST_A_NAME\n", "line 2: expected KEY=VALUE"},
+		{"invalid name", "1A=x\n", `line 1: invalid variable name "1A"`},
+		{"name with dash", "MY-VAR=x\n", `invalid variable name "MY-VAR"`},
+		{"unterminated quote", "A=1\nB=\"open\nC=2\n", "line 2: unterminated quoted value"},
+		{"text after closing quote", "A='x' y\n", `line 1: unexpected "y"`},
+		{"line after multiline value", "A=\"x\ny\"\nBAD\n", "line 3: expected KEY=VALUE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDotenv([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseDotenv() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
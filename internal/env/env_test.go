@@ -1,7 +1,10 @@
 package env
 
 import (
+	"errors"
+	"maps"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -183,6 +186,9 @@ func TestIgnoredEnv(t *testing.T) {
 		{"CASCADE_DIFF", true},
 		{"CASCADE_DIR", true},
 		{"CASCADE_", true},
+		{"DIRENV_DIR", true},
+		{"DIRENV_FILE", true},
+		{"DIRENV_DIFF", false}, // Not mirrored
 		{"PATH", false},
 		{"HOME", false},
 		{"USER", false},
@@ -547,6 +553,163 @@ func TestMarshalUnmarshal(t *testing.T) {
 	})
 }
 
+// TestUnmarshal_Truncated checks that every prefix of an encoded diff, as
+// a shell may be left with when export is killed mid-write, either fails
+// to decode or decodes to the whole diff.
+func TestUnmarshal_Truncated(t *testing.T) {
+	original := &EnvDiff{
+		Prev: map[string]string{"PATH": "/usr/bin:/bin", "FOO": ""},
+		Next: map[string]string{"PATH": "/home/user/bin:/usr/bin:/bin", "FOO": "bar"},
+	}
+	encoded, err := Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n < len(encoded); n++ {
+		decoded, err := Unmarshal(encoded[:n])
+		if err != nil {
+			continue
+		}
+		if !decoded.Equal(original) {
+			t.Errorf("Unmarshal(first %d of %d bytes) = %+v, want an error or the whole diff", n, len(encoded), decoded)
+		}
+	}
+
+	// A wrong checksum is reported as such
+	corrupt := encoded[:len(encoded)-1] + "0"
+	if strings.HasSuffix(encoded, "0") {
+		corrupt = encoded[:len(encoded)-1] + "1"
+	}
+	if _, err := Unmarshal(corrupt); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Unmarshal(corrupt checksum) error = %v, want ErrChecksum", err)
+	}
+
+	// Format 1 values written before the checksum are still read, but a
+	// versioned one must have it
+	body, _, _ := strings.Cut(encoded, ".")
+	legacy := strings.TrimPrefix(body, "v2:")
+	if decoded, err := Unmarshal(legacy); err != nil || !decoded.Equal(original) {
+		t.Errorf("Unmarshal(without checksum) = %+v, %v, want the whole diff", decoded, err)
+	}
+	if _, err := Unmarshal(body); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Unmarshal(v2 without checksum) error = %v, want ErrChecksum", err)
+	}
+}
+
+// TestUnmarshal_Formats checks that both formats decode to the same diffs:
+// format 1, with and without its optional checksum, and DiffFormat, which
+// Marshal writes.
+func TestUnmarshal_Formats(t *testing.T) {
+	if DiffFormat != 2 {
+		t.Fatalf("DiffFormat = %d; add the new format to this test", DiffFormat)
+	}
+
+	diffs := []*EnvDiff{
+		{
+			Prev: map[string]string{"FOO": "old", "ADDED": ""},
+			Next: map[string]string{"FOO": "new", "ADDED": "fresh"},
+		},
+		{
+			Prev:  map[string]string{"PATH": "/usr/bin"},
+			Next:  map[string]string{"PATH": "/opt/bin:/usr/bin"},
+			Merge: MergeSpec{"PATH": ":"},
+		},
+		{
+			Prev:      map[string]string{"GONE": "value"},
+			Next:      map[string]string{"GONE": ""},
+			Sensitive: []string{"TOKEN"},
+			Kept:      []string{"EDITOR"},
+		},
+		{
+			Prev: map[string]string{"QUOTES": "", "UNICODE": ""},
+			Next: map[string]string{"QUOTES": `it's "quoted" \ $HOME`, "UNICODE": "日本語\n\t"},
+		},
+	}
+	for i, original := range diffs {
+		encoded, err := Marshal(original)
+		if err != nil {
+			t.Fatalf("diff %d: Marshal() error: %v", i, err)
+		}
+		if !strings.HasPrefix(encoded, "v2:") {
+			t.Errorf("diff %d: Marshal() = %q, want a v2: prefix", i, encoded)
+		}
+		v1 := strings.TrimPrefix(encoded, "v2:")
+		v1Unchecked, _, _ := strings.Cut(v1, ".")
+
+		for format, value := range map[string]string{
+			"v2":                   encoded,
+			"v1":                   v1,
+			"v1 without checksum":  v1Unchecked,
+			"v1: prefixed":         "v1:" + v1,
+			"v1: without checksum": "v1:" + v1Unchecked,
+		} {
+			decoded, err := Unmarshal(value)
+			if err != nil {
+				t.Errorf("diff %d: Unmarshal(%s) error: %v", i, format, err)
+				continue
+			}
+			if !decoded.Equal(original) || !maps.Equal(decoded.Merge, original.Merge) {
+				t.Errorf("diff %d: Unmarshal(%s) = %+v, want %+v", i, format, decoded, original)
+			}
+
+			// Marshaling what was read writes the current format
+			if again, err := Marshal(decoded); err != nil || again != encoded {
+				t.Errorf("diff %d: Marshal(Unmarshal(%s)) = %q, %v; want %q", i, format, again, err, encoded)
+			}
+		}
+	}
+
+	// Other versions, such as one written by a newer release, are refused
+	encoded, _ := Marshal(diffs[0])
+	for _, version := range []string{"v3", "v0", "v", "x2", ""} {
+		value := version + strings.TrimPrefix(encoded, "v2")
+		if _, err := Unmarshal(value); !errors.Is(err, ErrDiffFormat) {
+			t.Errorf("Unmarshal(%q...) error = %v, want ErrDiffFormat", value[:4], err)
+		}
+	}
+}
+
+// FuzzUnmarshal checks that no value, however a shell or user mangled it,
+// makes Unmarshal panic, and that whatever it accepts marshals back to a
+// value it reads as the same diff.
+func FuzzUnmarshal(f *testing.F) {
+	for _, diff := range []*EnvDiff{
+		{Prev: map[string]string{"FOO": ""}, Next: map[string]string{"FOO": "bar"}},
+		{Prev: map[string]string{"PATH": "/a"}, Next: map[string]string{"PATH": "/b:/a"}, Merge: MergeSpec{"PATH": ":"}},
+	} {
+		encoded, err := Marshal(diff)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encoded)
+		f.Add(strings.TrimPrefix(encoded, "v2:"))
+		f.Add(encoded[:len(encoded)/2])
+	}
+	for _, seed := range []string{"", ":", ".", "v2:", "v2:.", "v9:eJw", "eJw.", "aGVsbG8=", "v2:v2:x.y"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		diff, err := Unmarshal(value)
+		if err != nil {
+			return
+		}
+		if diff.Prev == nil || diff.Next == nil {
+			t.Fatalf("Unmarshal(%q) left nil maps: %+v", value, diff)
+		}
+		encoded, err := Marshal(diff)
+		if err != nil {
+			// Only a diff naming a sensitive variable with a value is refused
+			return
+		}
+		again, err := Unmarshal(encoded)
+		if err != nil || !again.Equal(diff) {
+			t.Errorf("Unmarshal(Marshal(Unmarshal(%q))) = %+v, %v; want %+v", value, again, err, diff)
+		}
+	})
+}
+
 func TestUnmarshalErrors(t *testing.T) {
 	tests := []struct {
 		name  string
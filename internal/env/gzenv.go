@@ -3,19 +3,63 @@ package env
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
-// Marshal encodes an EnvDiff to the gzenv format (JSON → zlib → base64 URL-safe).
-// Returns an empty string for nil or empty diffs.
+// ErrChecksum is returned by Unmarshal for a value whose checksum does not
+// match, as when a shell applied a truncated export.
+var ErrChecksum = errors.New("checksum mismatch (truncated or corrupted)")
+
+// checksumSep separates the encoded diff from its checksum. It is not in
+// the base64 URL-safe alphabet.
+const checksumSep = "."
+
+// DiffFormat is the version of the format Marshal writes, named in a
+// "v2:" prefix. A value without a prefix, or with "v1:", is format 1, as
+// written before versions were recorded: the same encoding, with an
+// optional checksum.
+// Unmarshal reads both, and the next Marshal writes the value again in
+// this format, so a shell upgrades its CASCADE_DIFF at the next export.
+const DiffFormat = 2
+
+// versionSep ends the version prefix. Like checksumSep, it is not in the
+// base64 URL-safe alphabet, so no format 1 value contains it.
+const versionSep = ":"
+
+// ErrDiffFormat is returned by Unmarshal for a value in a format it does
+// not know, as when a newer release wrote it.
+var ErrDiffFormat = errors.New("unknown CASCADE_DIFF format")
+
+// checksum returns the hex of the first 8 bytes of the SHA256 of encoded.
+func checksum(encoded string) string {
+	sum := sha256.Sum256([]byte(encoded))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Marshal encodes an EnvDiff to the gzenv format (JSON → zlib → base64 URL-safe),
+// prefixed with the DiffFormat version and followed by "." and a checksum
+// of the encoding. Returns an empty string for nil or empty diffs.
 func Marshal(diff *EnvDiff) (string, error) {
 	if diff == nil || diff.IsEmpty() {
 		return "", nil
 	}
 
+	// A sensitive variable's value must never be encoded
+	for _, key := range diff.Sensitive {
+		_, inPrev := diff.Prev[key]
+		_, inNext := diff.Next[key]
+		if inPrev || inNext {
+			return "", fmt.Errorf("sensitive variable %s has a recorded value", key)
+		}
+	}
+
 	// JSON encode
 	jsonData, err := json.Marshal(diff)
 	if err != nil {
@@ -35,11 +79,14 @@ func Marshal(diff *EnvDiff) (string, error) {
 	// Base64 URL-safe encode
 	encoded := base64.URLEncoding.EncodeToString(compressed.Bytes())
 
-	return encoded, nil
+	return fmt.Sprintf("v%d%s%s%s%s", DiffFormat, versionSep, encoded, checksumSep, checksum(encoded)), nil
 }
 
 // Unmarshal decodes a gzenv string back to EnvDiff.
-// Returns an empty diff for empty input.
+// Returns an empty diff for empty input, ErrChecksum if the checksum does
+// not match, and ErrDiffFormat for a version other than 1 or DiffFormat.
+// A format 1 value without a checksum, from releases that did not write
+// one, is still accepted; a DiffFormat value must have one.
 func Unmarshal(gzenv string) (*EnvDiff, error) {
 	if gzenv == "" {
 		return &EnvDiff{
@@ -48,8 +95,25 @@ func Unmarshal(gzenv string) (*EnvDiff, error) {
 		}, nil
 	}
 
+	format := 1
+	if version, rest, ok := strings.Cut(gzenv, versionSep); ok {
+		switch version {
+		case "v1":
+		case fmt.Sprintf("v%d", DiffFormat):
+			format = DiffFormat
+		default:
+			return nil, fmt.Errorf("%w %q", ErrDiffFormat, version)
+		}
+		gzenv = rest
+	}
+
+	encoded, sum, checked := strings.Cut(gzenv, checksumSep)
+	if checked && sum != checksum(encoded) || !checked && format != 1 {
+		return nil, ErrChecksum
+	}
+
 	// Base64 URL-safe decode
-	compressed, err := base64.URLEncoding.DecodeString(gzenv)
+	compressed, err := base64.URLEncoding.DecodeString(encoded)
 	if err != nil {
 		return nil, fmt.Errorf("base64 decode: %w", err)
 	}
@@ -1,6 +1,9 @@
 package env
 
-import "strings"
+import (
+	"path"
+	"strings"
+)
 
 // ignoredKeys contains environment variables that should be excluded from diffs.
 // These are shell-managed or session-specific variables that change frequently
@@ -11,10 +14,13 @@ var ignoredKeys = map[string]bool{
 	"SHLVL":           true, // Shell nesting level
 	"_":               true, // Last command executed
 	"TERM_SESSION_ID": true, // Terminal session identifier
+	"DIRENV_DIR":      true, // Mirror of CASCADE_DIR (direnv_compat)
+	"DIRENV_FILE":     true, // Mirror of CASCADE_FILE (direnv_compat)
 }
 
 // IgnoredEnv returns true for env vars that should be excluded from diffs.
-// This includes PWD, OLDPWD, SHLVL, _, TERM_SESSION_ID, and all CASCADE_* vars.
+// This includes PWD, OLDPWD, SHLVL, _, TERM_SESSION_ID, DIRENV_DIR,
+// DIRENV_FILE, and all CASCADE_* vars.
 func IgnoredEnv(key string) bool {
 	if ignoredKeys[key] {
 		return true
@@ -22,3 +28,14 @@ func IgnoredEnv(key string) bool {
 	// Ignore all CASCADE_* variables to prevent feedback loops
 	return strings.HasPrefix(key, "CASCADE_")
 }
+
+// MatchAny reports whether key matches any of the glob patterns
+// (path.Match syntax, e.g. "*_TOKEN"). Malformed patterns never match.
+func MatchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, key); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package env
+
+import (
+	"strings"
+)
+
+// MergeSpec maps list-merged variable names to their separators.
+//
+// A merged variable accumulates each .envrc's contributions (deduplicated,
+// in chain order) instead of taking the last value written, and reverting
+// it removes only the components the chain added.
+type MergeSpec map[string]string
+
+// ParseMergeSpec parses newline-separated VAR=SEP entries, as written by the
+// stdlib merge_var helper. Later entries override earlier ones.
+func ParseMergeSpec(s string) MergeSpec {
+	spec := make(MergeSpec)
+	for _, line := range strings.Split(s, "\n") {
+		name, sep, ok := strings.Cut(line, "=")
+		if !ok || name == "" || sep == "" {
+			continue
+		}
+		spec[name] = sep
+	}
+	return spec
+}
+
+// With returns a spec containing s overlaid with other.
+func (s MergeSpec) With(other MergeSpec) MergeSpec {
+	if len(other) == 0 {
+		return s
+	}
+	merged := make(MergeSpec, len(s)+len(other))
+	for name, sep := range s {
+		merged[name] = sep
+	}
+	for name, sep := range other {
+		merged[name] = sep
+	}
+	return merged
+}
+
+// Subset returns the entries of s whose names are keys of vars, or nil if
+// there are none.
+func (s MergeSpec) Subset(vars map[string]string) MergeSpec {
+	var sub MergeSpec
+	for name, sep := range s {
+		if _, ok := vars[name]; ok {
+			if sub == nil {
+				sub = make(MergeSpec)
+			}
+			sub[name] = sep
+		}
+	}
+	return sub
+}
+
+// Apply returns after with every merged variable rebuilt from before plus
+// the components the level added or removed. Variables the level unset
+// stay unset.
+func (s MergeSpec) Apply(before, after Env) Env {
+	if len(s) == 0 {
+		return after
+	}
+
+	merged := after.Copy()
+	for name, sep := range s {
+		value, ok := after[name]
+		if !ok {
+			continue
+		}
+		added, removed := ListDiff(before[name], value, sep)
+		merged[name] = MergeList(before[name], added, removed, sep)
+	}
+	return merged
+}
+
+// SplitList splits a sep-separated value into its non-empty components.
+func SplitList(value, sep string) []string {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, sep)
+	components := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			components = append(components, part)
+		}
+	}
+	return components
+}
+
+// ListDiff returns the distinct components of newValue missing from
+// oldValue (added) and of oldValue missing from newValue (removed), in
+// order of appearance. Reordering and duplication are not changes.
+func ListDiff(oldValue, newValue, sep string) (added, removed []string) {
+	oldParts := SplitList(oldValue, sep)
+	newParts := SplitList(newValue, sep)
+
+	inOld := make(map[string]bool, len(oldParts))
+	for _, part := range oldParts {
+		inOld[part] = true
+	}
+	inNew := make(map[string]bool, len(newParts))
+	for _, part := range newParts {
+		inNew[part] = true
+	}
+
+	for _, part := range newParts {
+		if !inOld[part] {
+			added = append(added, part)
+			inOld[part] = true // Report each component once
+		}
+	}
+	for _, part := range oldParts {
+		if !inNew[part] {
+			removed = append(removed, part)
+			inNew[part] = true
+		}
+	}
+
+	return added, removed
+}
+
+// MergeList applies added and removed components to base: base's remaining
+// components keep their order, followed by added components not already
+// present. Duplicates are dropped.
+func MergeList(base string, added, removed []string, sep string) string {
+	drop := make(map[string]bool, len(removed))
+	for _, part := range removed {
+		drop[part] = true
+	}
+
+	seen := make(map[string]bool)
+	var components []string
+	for _, part := range append(SplitList(base, sep), added...) {
+		if drop[part] || seen[part] {
+			continue
+		}
+		seen[part] = true
+		components = append(components, part)
+	}
+
+	return strings.Join(components, sep)
+}
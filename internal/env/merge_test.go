@@ -0,0 +1,102 @@
+package env
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMergeSpec(t *testing.T) {
+	spec := ParseMergeSpec("FLAGS=,\nLIBS=:\n\nBAD\nFLAGS=;")
+
+	if len(spec) != 2 || spec["FLAGS"] != ";" || spec["LIBS"] != ":" {
+		t.Errorf("ParseMergeSpec() = %v, want FLAGS=; LIBS=:", spec)
+	}
+}
+
+func TestListDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldValue    string
+		newValue    string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{"append", "a,b", "a,b,c", []string{"c"}, nil},
+		{"from unset with leading separator", "", ",a", []string{"a"}, nil},
+		{"removed", "a,b,c", "a,c", nil, []string{"b"}},
+		{"reordered only", "a,b,c", "c,b,a", nil, nil},
+		{"duplicates ignored", "a,b", "a,b,a,c,c", []string{"c"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := ListDiff(tt.oldValue, tt.newValue, ",")
+			if !slices.Equal(added, tt.wantAdded) || !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("ListDiff() = %v, %v, want %v, %v", added, removed, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestMergeList(t *testing.T) {
+	got := MergeList("a,,b,a", []string{"c", "b", "d"}, []string{"a"}, ",")
+	if got != "b,c,d" {
+		t.Errorf("MergeList() = %q, want %q", got, "b,c,d")
+	}
+}
+
+func TestMergeSpec_Apply(t *testing.T) {
+	spec := MergeSpec{"FLAGS": ","}
+	before := Env{"FLAGS": "base,one", "OTHER": "x"}
+
+	// A level that re-appends an existing flag and prepends a new one
+	after := Env{"FLAGS": "two,base,one,one", "OTHER": "y"}
+	got := spec.Apply(before, after)
+
+	if got["FLAGS"] != "base,one,two" {
+		t.Errorf("FLAGS = %q, want %q", got["FLAGS"], "base,one,two")
+	}
+	if got["OTHER"] != "y" {
+		t.Errorf("OTHER = %q, want unmerged variables untouched", got["OTHER"])
+	}
+	if after["FLAGS"] != "two,base,one,one" {
+		t.Error("Apply() must not modify its input")
+	}
+
+	// Unsetting a merged variable is respected
+	if got := spec.Apply(before, Env{}); len(got) != 0 {
+		t.Errorf("Apply() = %v, want unset variable to stay unset", got)
+	}
+}
+
+func TestEnvDiff_PatchMergedRevert(t *testing.T) {
+	ambient := Env{"FLAGS": "ambient"}
+	loaded := Env{"FLAGS": "ambient,a,b"}
+
+	diff := BuildEnvDiff(ambient, loaded)
+	diff.Merge = MergeSpec{"FLAGS": ","}
+
+	// Untouched since load: revert restores the ambient value exactly
+	if got := diff.Reverse().Patch(loaded); got["FLAGS"] != "ambient" {
+		t.Errorf("revert = %q, want %q", got["FLAGS"], "ambient")
+	}
+
+	// Edited in the shell since load: revert removes only the chain's components
+	edited := Env{"FLAGS": "mine,ambient,a,b"}
+	if got := diff.Reverse().Patch(edited); got["FLAGS"] != "mine,ambient" {
+		t.Errorf("revert after edit = %q, want %q", got["FLAGS"], "mine,ambient")
+	}
+
+	// Merge survives serialization
+	encoded, err := Marshal(diff)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded, err := Unmarshal(encoded)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if decoded.Merge["FLAGS"] != "," {
+		t.Errorf("decoded Merge = %v, want FLAGS=,", decoded.Merge)
+	}
+}
@@ -0,0 +1,92 @@
+package env
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces the value of a variable that looks like a secret.
+const Redacted = "[redacted]"
+
+// SecretPatterns are variable name patterns (see MatchAny) whose values are
+// treated as secrets and redacted from diagnostic output.
+var SecretPatterns = []string{
+	"*TOKEN*",
+	"*SECRET*",
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*CREDENTIAL*",
+	"*API_KEY*",
+	"*ACCESS_KEY*",
+	"*PRIVATE_KEY*",
+	"*_AUTH",
+	"*_AUTH_*",
+}
+
+// IsSecret reports whether the value of key should be redacted: its
+// upper-cased name matches SecretPatterns, or the name matches one of the
+// extra patterns (e.g. the user's cache_exclude list).
+func IsSecret(key string, extra []string) bool {
+	return MatchAny(SecretPatterns, strings.ToUpper(key)) || MatchAny(extra, key)
+}
+
+// MaskPatterns are variable name patterns (see MatchAny) whose values
+// status, tree and which mask: SecretPatterns plus any name ending in KEY
+// or containing a _KEY_ part.
+var MaskPatterns = append(slices.Clone(SecretPatterns), "*KEY", "*_KEY_*")
+
+// IsMasked reports whether the value of key should be masked in output
+// meant for people: its upper-cased name matches MaskPatterns, or the name
+// matches one of the extra patterns (e.g. the user's mask_patterns).
+func IsMasked(key string, extra []string) bool {
+	return MatchAny(MaskPatterns, strings.ToUpper(key)) || MatchAny(extra, key)
+}
+
+// maskDots replaces the hidden part of a masked value.
+const maskDots = "••••"
+
+// minMaskRevealLen is the shortest value Mask shows the start of; the first
+// two characters would give away too much of a shorter one.
+const minMaskRevealLen = 8
+
+// Mask returns value's first two characters followed by "••••", or only
+// "••••" for a value shorter than minMaskRevealLen characters.
+func Mask(value string) string {
+	if utf8.RuneCountInString(value) < minMaskRevealLen {
+		return maskDots
+	}
+	_, n1 := utf8.DecodeRuneInString(value)
+	_, n2 := utf8.DecodeRuneInString(value[n1:])
+	return value[:n1+n2] + maskDots
+}
+
+// Redact returns a copy of vars with secret values replaced by Redacted.
+func Redact(vars map[string]string, extra []string) map[string]string {
+	if vars == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(vars))
+	for key, value := range vars {
+		if IsSecret(key, extra) {
+			value = Redacted
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// minRedactLen is the shortest secret value RedactText replaces; shorter
+// values would mangle unrelated text.
+const minRedactLen = 4
+
+// RedactText replaces the values of secret variables in vars (see IsSecret)
+// wherever they appear in text, for free-form output such as error messages.
+func RedactText(text string, vars map[string]string, extra []string) string {
+	for key, value := range vars {
+		if len(value) >= minRedactLen && IsSecret(key, extra) {
+			text = strings.ReplaceAll(text, value, Redacted)
+		}
+	}
+	return text
+}
@@ -3,24 +3,76 @@ package env
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
+// FutureTolerance is how far a file's mtime may be ahead of the local clock
+// before it is treated as clock skew (e.g. from an NFS server).
+const FutureTolerance = 2 * time.Second
+
 // FileTime tracks a file's modification state.
 type FileTime struct {
-	Path    string `json:"p"` // Absolute path
-	Modtime int64  `json:"m"` // Unix timestamp (0 if doesn't exist)
-	Exists  bool   `json:"e"` // Whether file existed at check time
+	Path     string `json:"p"`           // Absolute path
+	Modtime  int64  `json:"m"`           // Unix timestamp (0 if doesn't exist)
+	Exists   bool   `json:"e"`           // Whether file existed at check time
+	Recorded int64  `json:"r,omitempty"` // Local wall clock when recorded (Unix)
+	Hash     string `json:"h,omitempty"` // Content fingerprint (watch_hash mode only)
+	Adopted  bool   `json:"a,omitempty"` // Future Modtime already reported as a change
+
+	// Dir marks a directory watch (see DirWatch), whose Hash is a
+	// signature of the directory's entries.
+	Dir bool `json:"d,omitempty"`
+}
+
+// DirWatch returns the watch path that watches the entries of directory
+// dir, as watch_dir records it: dir with a trailing separator. Such a
+// watch changes when an entry is added, removed, renamed or modified, even
+// if the directory's own mtime does not.
+func DirWatch(dir string) string {
+	dir = filepath.Clean(dir)
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		return dir // The root
+	}
+	return dir + string(filepath.Separator)
+}
+
+// splitDirWatch returns the directory a DirWatch path watches, and whether
+// path is one.
+func splitDirWatch(path string) (string, bool) {
+	if len(path) > 1 && strings.HasSuffix(path, string(filepath.Separator)) {
+		return filepath.Clean(path), true
+	}
+	return path, false
+}
+
+// watchPath returns the watch path ft was recorded for.
+func (ft FileTime) watchPath() string {
+	if ft.Dir {
+		return DirWatch(ft.Path)
+	}
+	return ft.Path
 }
 
 // NewFileTime creates a FileTime by stat'ing the path.
 // Uses os.Stat which follows symlinks.
 func NewFileTime(path string) FileTime {
-	ft := FileTime{Path: path}
+	return statFileTime(path, false, time.Now())
+}
+
+// statFileTime records the current state of path at local time now,
+// fingerprinting regular files when withHash is set.
+func statFileTime(path string, withHash bool, now time.Time) FileTime {
+	ft := FileTime{Path: path, Recorded: now.Unix()}
 
 	info, err := os.Stat(path)
 	if err != nil {
@@ -32,39 +84,206 @@ func NewFileTime(path string) FileTime {
 
 	ft.Exists = true
 	ft.Modtime = info.ModTime().Unix()
+	if withHash && info.Mode().IsRegular() {
+		ft.Hash = hashFile(path)
+	}
 	return ft
 }
 
+// statWatch records the current state of the watch path at local time
+// now: a directory signature for a DirWatch path, the file otherwise.
+func statWatch(path string, withHash bool, now time.Time) FileTime {
+	if dir, ok := splitDirWatch(path); ok {
+		return statDirTime(dir, now)
+	}
+	return statFileTime(path, withHash, now)
+}
+
+// statDirTime records the current state of directory dir at local time
+// now, with the signature of its entries as Hash.
+func statDirTime(dir string, now time.Time) FileTime {
+	ft := statFileTime(dir, false, now)
+	ft.Dir = true
+	if ft.Exists {
+		ft.Hash = dirSignature(dir)
+	}
+	return ft
+}
+
+// dirSignature returns a hex SHA256 over the sorted names and mtimes of
+// dir's entries, or "" if it cannot be read. Subdirectories are not
+// descended into.
+func dirSignature(dir string) string {
+	entries, err := os.ReadDir(dir) // Sorted by name
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, entry := range entries {
+		var modtime int64
+		if info, err := entry.Info(); err == nil {
+			modtime = info.ModTime().UnixNano()
+		}
+		fmt.Fprintf(h, "%s\x00%d\n", entry.Name(), modtime)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hex SHA256 of path's content, or "" if unreadable.
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// InFuture reports whether the mtime was ahead of the local clock by more
+// than FutureTolerance when this FileTime was recorded.
+func (ft FileTime) InFuture() bool {
+	return ft.Exists && ft.Recorded != 0 && ft.Modtime > ft.Recorded+int64(FutureTolerance/time.Second)
+}
+
 // Check returns true if the file has changed since this FileTime was created.
-// Changes include: modification, creation, or deletion.
+// Changes include: modification, creation, or deletion. For a directory
+// watch, they include any entry added, removed, renamed or modified.
 func (ft FileTime) Check() bool {
-	current := NewFileTime(ft.Path)
+	if ft.Dir {
+		return ft.changedFrom(statDirTime(ft.Path, time.Now()))
+	}
+	return ft.changedFrom(statFileTime(ft.Path, ft.Hash != "", time.Now()))
+}
 
+// changedFrom compares the recorded state against current.
+//
+// A fingerprinted file or directory changes only when its content or
+// entries do, so clock skew cannot affect it. Otherwise an mtime that was in the future when
+// recorded is reported as a change once; Rebase then adopts it as the
+// baseline so it does not re-trigger on every prompt.
+func (ft FileTime) changedFrom(current FileTime) bool {
 	// Existence changed (created or deleted)
 	if ft.Exists != current.Exists {
 		return true
 	}
+	if !ft.Exists {
+		return false
+	}
+
+	if ft.Hash != "" && current.Hash != "" {
+		return ft.Hash != current.Hash
+	}
 
-	// If file exists, check modtime
-	if ft.Exists && ft.Modtime != current.Modtime {
+	if ft.Modtime != current.Modtime {
 		return true
 	}
 
-	return false
+	// Same mtime, but recorded ahead of our clock: edits within the skew
+	// window may share it, so report once until the baseline is adopted
+	return ft.InFuture() && !ft.Adopted
+}
+
+// rebase returns current as the new baseline for ft. A future mtime that
+// ft already recorded carries over as adopted, so it is reported only once.
+func (ft FileTime) rebase(current FileTime) FileTime {
+	if current.Exists && current.Modtime == ft.Modtime && (ft.InFuture() || ft.Adopted) {
+		current.Adopted = true
+	}
+	return current
 }
 
 // WatchList is a collection of files being watched.
 type WatchList []FileTime
 
-// NewWatchList creates a WatchList from a list of paths.
+// IsWatchPattern reports whether a watch path is a glob pattern, such as
+// config/*.yaml, rather than a literal path.
+func IsWatchPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// ExpandWatches returns paths with each glob pattern replaced by the files
+// it matches, in order, followed by the directory holding them, so a file
+// created later that matches the pattern changes the directory's mtime.
+// A pattern that matches nothing is watched as that directory alone. The
+// directory is the longest leading part of the pattern without glob
+// characters. Malformed patterns are kept as literal paths, and each path
+// appears once.
+func ExpandWatches(paths []string) []string {
+	expanded := make([]string, 0, len(paths))
+	add := func(path string) {
+		if !slices.Contains(expanded, path) {
+			expanded = append(expanded, path)
+		}
+	}
+	for _, path := range paths {
+		if !IsWatchPattern(path) {
+			add(path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			add(path)
+			continue
+		}
+		for _, match := range matches {
+			add(match)
+		}
+		add(patternDir(path))
+	}
+	return expanded
+}
+
+// patternDir returns the deepest directory of pattern whose path has no
+// glob characters.
+func patternDir(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for IsWatchPattern(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// NewWatchList creates a WatchList from a list of paths. Glob patterns
+// among them are expanded as by ExpandWatches, and DirWatch paths watch
+// their directory's entries.
 func NewWatchList(paths []string) WatchList {
+	paths = ExpandWatches(paths)
+	now := time.Now()
 	wl := make(WatchList, len(paths))
 	for i, path := range paths {
-		wl[i] = NewFileTime(path)
+		wl[i] = statWatch(path, false, now)
 	}
 	return wl
 }
 
+// Rebase records paths as a new WatchList, carrying over adopted future
+// mtimes from wl (the previous list) for unchanged files. When withHash is
+// set, regular files are also fingerprinted by content. Glob patterns among
+// paths are expanded as by ExpandWatches, and DirWatch paths watch their
+// directory's entries.
+func (wl WatchList) Rebase(paths []string, withHash bool) WatchList {
+	paths = ExpandWatches(paths)
+	prev := make(map[string]FileTime, len(wl))
+	for _, ft := range wl {
+		prev[ft.watchPath()] = ft
+	}
+
+	now := time.Now()
+	next := make(WatchList, len(paths))
+	for i, path := range paths {
+		next[i] = statWatch(path, withHash, now)
+		if ft, ok := prev[path]; ok {
+			next[i] = ft.rebase(next[i])
+		}
+	}
+	return next
+}
+
 // Check returns true if any watched file has changed.
 func (wl WatchList) Check() bool {
 	for _, ft := range wl {
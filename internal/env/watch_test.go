@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -287,3 +288,325 @@ func TestNewFileTime_Symlink(t *testing.T) {
 		t.Errorf("symlink modtime = %d, target modtime = %d", ftLink.Modtime, ftTarget.Modtime)
 	}
 }
+
+func TestFileTime_FutureMtimeReportedOnce(t *testing.T) {
+	now := time.Now().Unix()
+	future := now + 40 // NFS server clock 40s ahead
+
+	recorded := FileTime{Path: "/nfs/.envrc", Modtime: future, Exists: true, Recorded: now}
+	current := FileTime{Path: "/nfs/.envrc", Modtime: future, Exists: true, Recorded: now + 1}
+
+	if !recorded.InFuture() {
+		t.Fatal("InFuture() = false, want true for mtime 40s ahead")
+	}
+	if !recorded.changedFrom(current) {
+		t.Error("future mtime should be reported as a change once")
+	}
+
+	// The next baseline adopts the future mtime and stops re-triggering
+	baseline := recorded.rebase(current)
+	if !baseline.Adopted {
+		t.Fatal("rebase() should adopt the future mtime")
+	}
+	later := FileTime{Path: "/nfs/.envrc", Modtime: future, Exists: true, Recorded: now + 2}
+	if baseline.changedFrom(later) {
+		t.Error("adopted future mtime should not be reported again")
+	}
+	if next := baseline.rebase(later); !next.Adopted {
+		t.Error("adoption should carry over while the mtime is unchanged")
+	}
+}
+
+func TestFileTime_FutureMtimeEditDetected(t *testing.T) {
+	now := time.Now().Unix()
+
+	baseline := FileTime{Path: "/nfs/.envrc", Modtime: now + 40, Exists: true, Recorded: now, Adopted: true}
+	edited := FileTime{Path: "/nfs/.envrc", Modtime: now + 45, Exists: true, Recorded: now + 5}
+
+	if !baseline.changedFrom(edited) {
+		t.Error("edit within the skew window should be detected")
+	}
+	if next := baseline.rebase(edited); next.Adopted {
+		t.Error("a new mtime should not inherit adoption")
+	}
+}
+
+func TestFileTime_SmallSkewTolerated(t *testing.T) {
+	now := time.Now().Unix()
+
+	ft := FileTime{Path: "/x/.envrc", Modtime: now + 1, Exists: true, Recorded: now}
+	if ft.InFuture() {
+		t.Error("skew within FutureTolerance should not count as future")
+	}
+	if ft.changedFrom(ft) {
+		t.Error("unchanged file within tolerance should not be reported")
+	}
+
+	// Entries recorded before Recorded existed are never treated as future
+	legacy := FileTime{Path: "/x/.envrc", Modtime: now + 100, Exists: true}
+	if legacy.InFuture() {
+		t.Error("InFuture() should be false without a recorded clock")
+	}
+}
+
+func TestFileTime_HashIgnoresMtime(t *testing.T) {
+	now := time.Now().Unix()
+
+	recorded := FileTime{Path: "/nfs/.envrc", Modtime: now + 40, Exists: true, Recorded: now, Hash: "aaa"}
+	touched := FileTime{Path: "/nfs/.envrc", Modtime: now + 90, Exists: true, Recorded: now + 1, Hash: "aaa"}
+	edited := FileTime{Path: "/nfs/.envrc", Modtime: now + 40, Exists: true, Recorded: now + 1, Hash: "bbb"}
+
+	if recorded.changedFrom(touched) {
+		t.Error("fingerprinted file with same content should not change")
+	}
+	if !recorded.changedFrom(edited) {
+		t.Error("fingerprinted file with new content should change even with same mtime")
+	}
+}
+
+func TestWatchList_RebaseWithHash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".envrc")
+	if err := os.WriteFile(path, []byte("export A=1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wl := WatchList(nil).Rebase([]string{path, filepath.Join(dir, "missing")}, true)
+	if len(wl) != 2 || wl[0].Hash == "" || wl[1].Hash != "" {
+		t.Fatalf("Rebase() = %+v, want a fingerprint for the existing file only", wl)
+	}
+	if wl.Check() {
+		t.Error("Check() = true, want false for unchanged files")
+	}
+
+	// Same mtime, different content
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("export A=2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.Check() {
+		t.Error("Check() = false, want true after content change")
+	}
+}
+
+func TestExpandWatches(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	if err := os.Mkdir(config, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.yaml", "b.yaml", "my app.yaml", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(config, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	literal := filepath.Join(dir, ".tool-versions")
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"literal", []string{literal}, []string{literal}},
+		{
+			"many matches",
+			[]string{filepath.Join(config, "*.yaml"), literal},
+			[]string{
+				filepath.Join(config, "a.yaml"),
+				filepath.Join(config, "b.yaml"),
+				filepath.Join(config, "my app.yaml"),
+				config,
+				literal,
+			},
+		},
+		{"no matches", []string{filepath.Join(config, "*.json")}, []string{config}},
+		{"spaces", []string{filepath.Join(config, "my *.yaml")}, []string{filepath.Join(config, "my app.yaml"), config}},
+		{"pattern directory", []string{filepath.Join(dir, "*", "notes.txt")}, []string{filepath.Join(config, "notes.txt"), dir}},
+		{"malformed", []string{filepath.Join(config, "[a")}, []string{filepath.Join(config, "[a")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpandWatches(tt.paths); !slices.Equal(got, tt.want) {
+				t.Errorf("ExpandWatches(%q) = %q, want %q", tt.paths, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchList_PatternDetectsNewFile(t *testing.T) {
+	dir := t.TempDir()
+	wl := NewWatchList([]string{filepath.Join(dir, "*.yaml")})
+	if len(wl) != 1 || wl[0].Path != dir {
+		t.Fatalf("NewWatchList() = %+v, want the directory alone", wl)
+	}
+
+	// Creating a matching file changes the directory's mtime
+	if err := os.WriteFile(filepath.Join(dir, "new.yaml"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newTime := time.Now().Add(2 * time.Second)
+	if err := os.Chtimes(dir, newTime, newTime); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.Check() {
+		t.Error("Check() = false, want true after a matching file was created")
+	}
+
+	wl = wl.Rebase([]string{filepath.Join(dir, "*.yaml")}, false)
+	if len(wl) != 2 || wl[0].Path != filepath.Join(dir, "new.yaml") {
+		t.Errorf("Rebase() = %+v, want the new file and the directory", wl)
+	}
+}
+
+// setupWatchedDir creates a directory with two files and returns it with a
+// DirWatch-mode WatchList for it.
+func setupWatchedDir(t *testing.T) (string, WatchList) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "config")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.yaml", "b.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wl := NewWatchList([]string{DirWatch(dir)})
+	if len(wl) != 1 || !wl[0].Dir || wl[0].Path != dir || wl[0].Hash == "" {
+		t.Fatalf("NewWatchList() = %+v, want a directory watch of %s", wl, dir)
+	}
+	return dir, wl
+}
+
+// pinMtime resets the mtime of dir to that recorded in ft, so only the
+// signature of its entries can reveal a change.
+func pinMtime(t *testing.T, dir string, ft FileTime) {
+	t.Helper()
+	mtime := time.Unix(ft.Modtime, 0)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirWatch(t *testing.T) {
+	for _, dir := range []string{"/work/config", "/work/config/", "/work/./config"} {
+		if got := DirWatch(dir); got != "/work/config/" {
+			t.Errorf("DirWatch(%q) = %q, want /work/config/", dir, got)
+		}
+	}
+	if got := DirWatch("/"); got != "/" {
+		t.Errorf("DirWatch(/) = %q, want /", got)
+	}
+}
+
+func TestDirWatch_Untouched(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	// Reading the directory or its files is not a change
+	if _, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.ReadFile(filepath.Join(dir, "a.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if wl.Check() {
+		t.Error("Check() = true, want false for an untouched directory")
+	}
+}
+
+func TestDirWatch_AddFile(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	if err := os.WriteFile(filepath.Join(dir, "c.yaml"), []byte("c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pinMtime(t, dir, wl[0])
+	if !wl.Check() {
+		t.Error("Check() = false, want true after a file was added")
+	}
+}
+
+func TestDirWatch_RemoveFile(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	if err := os.Remove(filepath.Join(dir, "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	pinMtime(t, dir, wl[0])
+	if !wl.Check() {
+		t.Error("Check() = false, want true after a file was removed")
+	}
+}
+
+func TestDirWatch_Rename(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	if err := os.Rename(filepath.Join(dir, "b.yaml"), filepath.Join(dir, "c.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	pinMtime(t, dir, wl[0])
+	if !wl.Check() {
+		t.Error("Check() = false, want true after a file was renamed")
+	}
+}
+
+func TestDirWatch_ModifyFile(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	// Editing a file in place leaves the directory's mtime alone
+	file := filepath.Join(dir, "a.yaml")
+	newTime := time.Now().Add(2 * time.Second)
+	if err := os.Chtimes(file, newTime, newTime); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.Check() {
+		t.Error("Check() = false, want true after a file was modified")
+	}
+}
+
+func TestDirWatch_RemovedDir(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !wl.Check() {
+		t.Error("Check() = false, want true after the directory was removed")
+	}
+}
+
+func TestDirWatch_SerializeAndRebase(t *testing.T) {
+	dir, wl := setupWatchedDir(t)
+
+	encoded, err := wl.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ParseWatchList(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || !decoded[0].Dir || decoded[0].Hash != wl[0].Hash {
+		t.Fatalf("ParseWatchList() = %+v, want %+v", decoded, wl)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c.yaml"), []byte("c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pinMtime(t, dir, wl[0])
+	if !decoded.Check() {
+		t.Error("Check() = false after a round trip, want true after a file was added")
+	}
+
+	// Rebasing records the new entries
+	rebased := decoded.Rebase([]string{DirWatch(dir)}, true)
+	if len(rebased) != 1 || !rebased[0].Dir || rebased[0].Hash == wl[0].Hash || rebased.Check() {
+		t.Errorf("Rebase() = %+v, want the directory's current signature", rebased)
+	}
+}
@@ -0,0 +1,163 @@
+package envrc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// caseProbes caches CaseInsensitive per volume, keyed by device number.
+var caseProbes sync.Map
+
+// CaseInsensitive reports whether the volume holding path, which must
+// exist, treats names differing only in case as the same file (the macOS
+// default). It probes by looking up a case-swapped form of a name on the
+// path, so nothing is written; the answer is cached per volume.
+func CaseInsensitive(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	dev, ok := volumeID(info)
+	if ok {
+		if cached, found := caseProbes.Load(dev); found {
+			return cached.(bool)
+		}
+	}
+
+	insensitive := probeCase(path, dev, ok)
+	if ok {
+		caseProbes.Store(dev, insensitive)
+	}
+	return insensitive
+}
+
+// probeCase looks up the deepest name on path that has letters with its
+// case swapped. Finding the same file means the volume folds case. A name
+// on another volume than dev ends the probe, since it answers for that
+// volume instead.
+func probeCase(path string, dev uint64, haveDev bool) bool {
+	for p := path; ; p = filepath.Dir(p) {
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		name := filepath.Base(p)
+		swapped := swapCase(name)
+		if swapped == name {
+			continue
+		}
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			return false
+		}
+		if id, ok := volumeID(info); haveDev && ok && id != dev {
+			return false
+		}
+		other, err := os.Lstat(filepath.Join(parent, swapped))
+		return err == nil && os.SameFile(info, other)
+	}
+}
+
+// swapCase swaps upper and lower case letters in name.
+func swapCase(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
+
+// canonicalCase returns path with every component spelled as it is on
+// disk, where names lists a directory. On a case-insensitive volume
+// /Users/Me and /users/me name the same directory, and allow records are
+// keyed by path, so both must hash the same. Components that cannot be
+// listed or matched are kept as given. If insensitive is false the path is
+// returned unchanged.
+func canonicalCase(path string, insensitive bool, names func(dir string) ([]string, error)) string {
+	if !insensitive || !filepath.IsAbs(path) {
+		return path
+	}
+
+	// Start at the root of the volume: / or C:\
+	path = filepath.Clean(path)
+	volume := filepath.VolumeName(path)
+	parts := strings.Split(strings.TrimPrefix(path[len(volume):], string(filepath.Separator)), string(filepath.Separator))
+	dir := volume + string(filepath.Separator)
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		entries, err := names(dir)
+		if err != nil {
+			return filepath.Join(append([]string{dir}, parts[i:]...)...)
+		}
+		parts[i] = matchCase(part, entries)
+		dir = filepath.Join(dir, parts[i])
+	}
+	return dir
+}
+
+// matchCase returns the entry spelling name, preferring an exact match,
+// or name itself if no entry matches.
+func matchCase(name string, entries []string) string {
+	match := name
+	for _, entry := range entries {
+		if entry == name {
+			return name
+		}
+		if match == name && strings.EqualFold(entry, name) {
+			match = entry
+		}
+	}
+	return match
+}
+
+// readNames lists the names in dir, unsorted.
+func readNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return f.Readdirnames(-1)
+}
+
+// CanonicalCase returns path, which must be absolute, spelled as it is on
+// disk if its volume is case-insensitive, and unchanged otherwise.
+func CanonicalCase(path string) string {
+	existing := path
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path
+		}
+		existing = parent
+	}
+	return canonicalCase(path, CaseInsensitive(existing), readNames)
+}
+
+// caseVariants returns the names in dir that spell name in another case,
+// such as .Envrc for .envrc, and whether name itself is there.
+func caseVariants(dir, name string) (exact bool, variants []string) {
+	entries, err := readNames(dir)
+	if err != nil {
+		return false, nil
+	}
+	for _, entry := range entries {
+		switch {
+		case entry == name:
+			exact = true
+		case strings.EqualFold(entry, name):
+			variants = append(variants, entry)
+		}
+	}
+	return exact, variants
+}
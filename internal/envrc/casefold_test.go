@@ -0,0 +1,162 @@
+package envrc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// probeTempCase reports whether the volume holding dir folds case, by
+// creating a file and looking it up with its name in upper case.
+func probeTempCase(t *testing.T, dir string) bool {
+	t.Helper()
+	probe := filepath.Join(dir, "case-probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(probe) }()
+	_, err := os.Lstat(filepath.Join(dir, "CASE-PROBE"))
+	return err == nil
+}
+
+func TestCanonicalCase(t *testing.T) {
+	t.Parallel()
+
+	tree := map[string][]string{
+		"/":                {"Users", "tmp"},
+		"/Users":           {"Me", "me2"},
+		"/Users/Me":        {"Work", ".envrc", ".Envrc"},
+		"/Users/Me/Work":   {"API"},
+		"/Users/Me/Work/A": {},
+	}
+	names := func(dir string) ([]string, error) {
+		entries, ok := tree[dir]
+		if !ok {
+			return nil, errors.New("not a directory")
+		}
+		return entries, nil
+	}
+
+	tests := []struct {
+		path        string
+		insensitive bool
+		want        string
+	}{
+		{"/users/me/work/api", true, "/Users/Me/Work/API"},
+		{"/Users/Me/Work/API", true, "/Users/Me/Work/API"},
+		{"/USERS/ME2", true, "/Users/me2"},
+		{"/users/me/.envrc", true, "/Users/Me/.envrc"}, // Exact match preferred
+		{"/users/me/.ENVRC", true, "/Users/Me/.envrc"}, // First match otherwise
+		{"/users/me/missing/x", true, "/Users/Me/missing/x"},
+		{"/users/me/work/api/deeper", true, "/Users/Me/Work/API/deeper"}, // Unlistable kept
+		{"/users/me/work/api", false, "/users/me/work/api"},
+		{"relative/path", true, "relative/path"},
+	}
+	for _, tt := range tests {
+		if got := canonicalCase(tt.path, tt.insensitive, names); got != tt.want {
+			t.Errorf("canonicalCase(%q, %v) = %q, want %q", tt.path, tt.insensitive, got, tt.want)
+		}
+	}
+}
+
+func TestCaseInsensitive_MatchesProbe(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "Project")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := CaseInsensitive(sub), probeTempCase(t, dir); got != want {
+		t.Errorf("CaseInsensitive = %v, probe in test says %v", got, want)
+	}
+}
+
+func TestFindChain_CaseVariant(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	root, _ = filepath.EvalSymlinks(root)
+	project := filepath.Join(root, "project")
+	if err := os.Mkdir(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, ".Envrc"), []byte("export A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := FindChain(root, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := chain[len(chain)-1]
+	if leaf.Exists || !slices.Equal(leaf.CaseVariants, []string{".Envrc"}) {
+		t.Errorf("leaf = %+v, want a missing .envrc with variant .Envrc", leaf)
+	}
+	if chain[0].CaseVariants != nil {
+		t.Errorf("root variants = %v, want none", chain[0].CaseVariants)
+	}
+
+	// A case-sensitive volume can hold both; .envrc still loads
+	if probeTempCase(t, root) {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(project, ".envrc"), []byte("export A=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chain, err = FindChain(root, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf = chain[len(chain)-1]
+	if !leaf.Exists || !slices.Equal(leaf.CaseVariants, []string{".Envrc"}) {
+		t.Errorf("leaf = %+v, want .envrc loaded with variant .Envrc", leaf)
+	}
+}
+
+func TestNewRC_CaseDrift(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dir, _ = filepath.EvalSymlinks(dir)
+	if !probeTempCase(t, dir) {
+		t.Skip("filesystem is case-sensitive")
+	}
+	project := filepath.Join(dir, "Project")
+	if err := os.Mkdir(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	envrcPath := filepath.Join(project, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drifted := filepath.Join(dir, "PROJECT", ".envrc")
+
+	want, err := NewRC(envrcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewRC(drifted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != envrcPath || got.ContentHash != want.ContentHash {
+		t.Errorf("NewRC(%s) = %s, hash match %v; want %s", drifted, got.Path, got.ContentHash == want.ContentHash, envrcPath)
+	}
+
+	wantHash, _ := PathHash(envrcPath)
+	if gotHash, _ := PathHash(drifted); gotHash != wantHash {
+		t.Errorf("PathHash differs between %s and %s", envrcPath, drifted)
+	}
+
+	chain, err := FindChain(strings.ToUpper(dir[:2])+dir[2:], filepath.Join(dir, "project"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := chain[len(chain)-1]; leaf.Path != envrcPath {
+		t.Errorf("chain leaf = %s, want %s", leaf.Path, envrcPath)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package envrc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalCase_Windows(t *testing.T) {
+	t.Parallel()
+
+	tree := map[string][]string{
+		`C:\`:                 {"Users", "Windows"},
+		`C:\Users`:            {"Me"},
+		`C:\Users\Me`:         {"Work"},
+		`C:\Users\Me\Work`:    {},
+		`\\server\share\`:     {"Team"},
+		`\\server\share\Team`: {},
+	}
+	names := func(dir string) ([]string, error) {
+		entries, ok := tree[dir]
+		if !ok {
+			return nil, errors.New("not a directory")
+		}
+		return entries, nil
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\users\me\work`, `C:\Users\Me\Work`},
+		{`C:\USERS\ME\missing`, `C:\Users\Me\missing`},
+		{`\\server\share\team`, `\\server\share\Team`},
+	}
+	for _, tt := range tests {
+		if got := canonicalCase(tt.path, true, names); got != tt.want {
+			t.Errorf("canonicalCase(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package envrc
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// indentUnit is one level of indentation in formatted output.
+const indentUnit = "  "
+
+// Format rewrites an .envrc in a canonical layout: two-space indentation
+// by block depth, no trailing whitespace, at most one blank line in a row,
+// and contiguous runs of simple exports (export NAME=VALUE, nothing else
+// on the line) sorted by name when no export in the run references
+// another's variable.
+//
+// Format is conservative. Constructs spanning several lines (backslash
+// continuations, multi-line quotes, heredocs) and case statements are
+// copied verbatim, and if the block structure cannot be followed the rest
+// of the file is copied verbatim. Files with carriage returns are returned
+// unchanged. Formatting formatted output changes nothing.
+func Format(src []byte) []byte {
+	if len(src) == 0 || slices.Contains(src, '\r') {
+		return src
+	}
+
+	f := &formatter{}
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	for i := 0; i < len(lines); {
+		c, next, ok := nextChunk(lines, i)
+		if !ok {
+			f.flushRun()
+			f.raw(lines[i:]...)
+			f.giveUp = true
+			break
+		}
+		f.add(c)
+		i = next
+	}
+	f.flushRun()
+
+	out := f.out
+	if !f.giveUp {
+		for len(out) > 0 && out[len(out)-1] == "" {
+			out = out[:len(out)-1]
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// chunkKind classifies a chunk of physical lines.
+type chunkKind int
+
+const (
+	chunkBlank    chunkKind = iota // A line with only whitespace
+	chunkComment                   // A line with only a comment
+	chunkLine                      // A single line of commands
+	chunkVerbatim                  // Several lines forming one command, copied as is
+)
+
+// chunk is one or more physical lines that form a unit of shell input.
+type chunk struct {
+	kind   chunkKind
+	lines  []string
+	tokens []token // Top-level words and operators
+	lexed  lexResult
+}
+
+// token is a top-level word or operator.
+type token struct {
+	text string
+	op   bool
+}
+
+// heredoc is a pending here-document body.
+type heredoc struct {
+	delim     string
+	stripTabs bool // <<- strips leading tabs from body lines
+}
+
+// lexResult is what lex found in a piece of shell input.
+type lexResult struct {
+	tokens      []token
+	heredocs    []heredoc
+	open        bool // Ended inside quotes, a substitution, or a subshell
+	cont        bool // Ended with a backslash continuation
+	comment     bool // Had a top-level comment
+	unsupported bool // Had a construct the formatter does not follow
+}
+
+// nextChunk reads the chunk starting at lines[i] and returns it with the
+// index of the line after it. ok is false if the input ends inside the
+// chunk or the chunk cannot be followed.
+func nextChunk(lines []string, i int) (c chunk, next int, ok bool) {
+	trimmed := strings.TrimSpace(lines[i])
+	switch {
+	case trimmed == "":
+		return chunk{kind: chunkBlank, lines: lines[i : i+1]}, i + 1, true
+	case strings.HasPrefix(trimmed, "#"):
+		return chunk{kind: chunkComment, lines: lines[i : i+1]}, i + 1, true
+	}
+
+	end := i + 1
+	text := lines[i]
+	r := lex(text)
+	for r.open || r.cont {
+		if end == len(lines) || r.unsupported {
+			return chunk{}, 0, false
+		}
+		text += "\n" + lines[end]
+		end++
+		r = lex(text)
+	}
+	if r.unsupported {
+		return chunk{}, 0, false
+	}
+
+	for _, h := range r.heredocs {
+		for {
+			if end == len(lines) {
+				return chunk{}, 0, false
+			}
+			body := lines[end]
+			end++
+			if h.stripTabs {
+				body = strings.TrimLeft(body, "\t")
+			}
+			if body == h.delim {
+				break
+			}
+		}
+	}
+
+	kind := chunkLine
+	if end-i > 1 {
+		kind = chunkVerbatim
+	}
+	return chunk{kind: kind, lines: lines[i:end], tokens: r.tokens, lexed: r}, end, true
+}
+
+// lex splits shell input into top-level words and operators, tracking
+// quotes and substitutions well enough to know where a command ends.
+func lex(text string) lexResult {
+	var r lexResult
+	var stack []byte // Open contexts: ' " ` ( { and S for a subshell
+	var word strings.Builder
+	inWord := false
+
+	flush := func() {
+		if inWord {
+			r.tokens = append(r.tokens, token{text: word.String()})
+			word.Reset()
+			inWord = false
+		}
+	}
+	op := func(s string) {
+		flush()
+		r.tokens = append(r.tokens, token{text: s, op: true})
+	}
+	write := func(b byte) {
+		word.WriteByte(b)
+		inWord = true
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		var top byte
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		switch top {
+		case '\'':
+			write(c)
+			if c == '\'' {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		case '"', '`':
+			write(c)
+			switch {
+			case c == '\\' && i+1 < len(text):
+				i++
+				write(text[i])
+			case c == top:
+				stack = stack[:len(stack)-1]
+			case c == '`':
+				stack = append(stack, '`')
+			case c == '$' && i+1 < len(text) && (text[i+1] == '(' || text[i+1] == '{'):
+				i++
+				write(text[i])
+				stack = append(stack, text[i])
+			}
+			continue
+		}
+
+		// Top level, or inside $( ), ${ }, or a subshell
+		if c == '\\' {
+			if i+1 == len(text) {
+				r.cont = true
+				write(c)
+				continue
+			}
+			write(c)
+			i++
+			write(text[i])
+			continue
+		}
+		if c == '#' && !inWord {
+			if top == 0 {
+				r.comment = true
+			}
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+			continue
+		}
+		if c == '<' && strings.HasPrefix(text[i:], "<<") && !strings.HasPrefix(text[i:], "<<<") {
+			if top != 0 {
+				r.unsupported = true
+				return r
+			}
+			h, n := parseHeredoc(text[i+2:])
+			if h.delim == "" {
+				r.unsupported = true
+				return r
+			}
+			flush()
+			r.heredocs = append(r.heredocs, h)
+			i += 1 + n
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			stack = append(stack, c)
+			write(c)
+		case '$':
+			write(c)
+			if i+1 < len(text) && (text[i+1] == '(' || text[i+1] == '{') {
+				i++
+				write(text[i])
+				stack = append(stack, text[i])
+			}
+		case '(':
+			if top == 0 {
+				op("(")
+				stack = append(stack, 'S')
+			} else {
+				write(c)
+				stack = append(stack, '(')
+			}
+		case ')':
+			switch top {
+			case 'S':
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					op(")")
+				}
+			case '(':
+				write(c)
+				stack = stack[:len(stack)-1]
+			case 0:
+				op(")") // case pattern
+			default:
+				write(c)
+			}
+		case '}':
+			write(c)
+			if top == '{' {
+				stack = stack[:len(stack)-1]
+			}
+		case ' ', '\t':
+			if top == 0 {
+				flush()
+			} else {
+				write(c)
+			}
+		case '\n', ';', '&', '|', '<', '>':
+			if top == 0 {
+				op(string(c))
+			} else {
+				write(c)
+			}
+		default:
+			write(c)
+		}
+	}
+	flush()
+
+	r.open = len(stack) > 0
+	return r
+}
+
+// parseHeredoc parses the delimiter following << and returns it with the
+// number of bytes consumed.
+func parseHeredoc(s string) (heredoc, int) {
+	var h heredoc
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		h.stripTabs = true
+		i++
+	}
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+
+	var delim strings.Builder
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return heredoc{}, i
+			}
+			delim.WriteString(s[i+1 : i+1+end])
+			i += end + 2
+		case c == '\\' && i+1 < len(s):
+			delim.WriteByte(s[i+1])
+			i += 2
+		case strings.IndexByte(" \t\n;&|<>()", c) >= 0:
+			h.delim = delim.String()
+			return h, i
+		default:
+			delim.WriteByte(c)
+			i++
+		}
+	}
+	h.delim = delim.String()
+	return h, i
+}
+
+// Reserved words that open and close blocks, and those that continue one
+// at the enclosing depth.
+var (
+	blockOpeners  = []string{"if", "for", "while", "until", "select", "{"}
+	blockClosers  = []string{"fi", "done", "}"}
+	blockMiddles  = []string{"then", "do", "else", "elif"}
+	commandPrefix = []string{"then", "do", "else", "elif", "if", "while", "until", "!", "{", "time"}
+)
+
+// blockCounts summarizes the reserved words in a chunk.
+type blockCounts struct {
+	first         string // First word, if the chunk starts with one
+	opens, closes int    // Block openers and closers, other than case
+	cases, esacs  int
+}
+
+// countBlocks counts the block openers and closers among tokens, only where
+// a command may start.
+func countBlocks(tokens []token) blockCounts {
+	var b blockCounts
+	cmdPos := true
+	for i, t := range tokens {
+		if t.op {
+			cmdPos = strings.Contains(";&|()\n", t.text)
+			continue
+		}
+		if i == 0 {
+			b.first = t.text
+		}
+		if cmdPos {
+			switch {
+			case t.text == "case":
+				b.cases++
+			case t.text == "esac":
+				b.esacs++
+			case slices.Contains(blockOpeners, t.text):
+				b.opens++
+			case slices.Contains(blockClosers, t.text):
+				b.closes++
+			}
+		} else if t.text == "{" && i == len(tokens)-1 {
+			// function name {
+			b.opens++
+		}
+		cmdPos = cmdPos && slices.Contains(commandPrefix, t.text)
+	}
+	return b
+}
+
+// assignment matches the NAME= prefix of an assignment word.
+var assignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=`)
+
+// varRef matches a variable reference in a value.
+var varRef = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// export is a simple export line awaiting sorting.
+type export struct {
+	name, value, line string
+}
+
+// parseSimpleExport reports whether c is a line that only exports one
+// variable, with no command substitution whose side effects could depend
+// on order.
+func parseSimpleExport(c chunk) (export, bool) {
+	if c.kind != chunkLine || c.lexed.comment || len(c.lexed.heredocs) > 0 || len(c.tokens) != 2 {
+		return export{}, false
+	}
+	if c.tokens[0].op || c.tokens[0].text != "export" || c.tokens[1].op {
+		return export{}, false
+	}
+	word := c.tokens[1].text
+	m := assignment.FindStringSubmatch(word)
+	if m == nil || strings.Contains(word, "$(") || strings.Contains(word, "`") {
+		return export{}, false
+	}
+	line := strings.TrimSpace(c.lines[0])
+	if strings.HasSuffix(line, "\\") {
+		return export{}, false
+	}
+	return export{name: m[1], value: word[len(m[0]):], line: "export " + word}, true
+}
+
+// formatter accumulates formatted lines.
+type formatter struct {
+	out    []string
+	depth  int
+	cases  int // Depth of case statements being copied verbatim
+	giveUp bool
+	run    []export
+}
+
+func (f *formatter) raw(lines ...string) {
+	f.out = append(f.out, lines...)
+}
+
+func (f *formatter) add(c chunk) {
+	if f.giveUp {
+		f.raw(c.lines...)
+		return
+	}
+
+	if c.kind == chunkBlank {
+		f.flushRun()
+		if len(f.out) > 0 && f.out[len(f.out)-1] != "" {
+			f.out = append(f.out, "")
+		}
+		return
+	}
+
+	b := countBlocks(c.tokens)
+
+	// Case statements are copied as written, so their own layout survives
+	if f.cases > 0 || b.cases > b.esacs {
+		f.flushRun()
+		f.raw(c.lines...)
+		f.cases += b.cases - b.esacs
+		if f.cases < 0 {
+			f.cases = 0
+		}
+		return
+	}
+
+	if c.kind == chunkComment {
+		f.flushRun()
+		f.out = append(f.out, f.indent(f.depth)+strings.TrimSpace(c.lines[0]))
+		return
+	}
+
+	if e, ok := parseSimpleExport(c); ok {
+		e.line = f.indent(f.depth) + e.line
+		f.run = append(f.run, e)
+		return
+	}
+	f.flushRun()
+
+	depth := f.depth
+	lineDepth := depth
+	switch {
+	case slices.Contains(blockClosers, b.first):
+		depth--
+		b.closes--
+		lineDepth = depth
+	case slices.Contains(blockMiddles, b.first):
+		lineDepth = depth - 1
+	}
+	if lineDepth < 0 {
+		f.raw(c.lines...)
+		f.giveUp = true
+		return
+	}
+
+	if c.kind == chunkVerbatim {
+		f.raw(c.lines...)
+	} else {
+		line := strings.TrimRight(c.lines[0], " \t")
+		if strings.HasSuffix(line, "\\") {
+			// Trimming would turn an escaped space into a continuation
+			line = c.lines[0]
+		}
+		f.out = append(f.out, f.indent(lineDepth)+strings.TrimLeft(line, " \t"))
+	}
+
+	f.depth = depth + b.opens - b.closes
+	if f.depth < 0 {
+		f.giveUp = true
+	}
+}
+
+// flushRun writes the pending run of simple exports, sorted by name unless
+// one of them references a variable another sets or a name repeats.
+func (f *formatter) flushRun() {
+	run := f.run
+	f.run = nil
+	if len(run) == 0 {
+		return
+	}
+
+	names := make(map[string]bool, len(run))
+	for _, e := range run {
+		if names[e.name] {
+			f.writeRun(run)
+			return
+		}
+		names[e.name] = true
+	}
+	for _, e := range run {
+		for _, m := range varRef.FindAllStringSubmatch(e.value, -1) {
+			if m[1] != e.name && names[m[1]] {
+				f.writeRun(run)
+				return
+			}
+		}
+	}
+
+	slices.SortStableFunc(run, func(a, b export) int {
+		return strings.Compare(a.name, b.name)
+	})
+	f.writeRun(run)
+}
+
+func (f *formatter) writeRun(run []export) {
+	for _, e := range run {
+		f.out = append(f.out, e.line)
+	}
+}
+
+func (f *formatter) indent(depth int) string {
+	return strings.Repeat(indentUnit, depth)
+}
@@ -0,0 +1,74 @@
+package envrc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFormat_Golden formats each testdata/fmt/*.input and compares the
+// result with the matching .golden file, then checks that formatting the
+// golden file changes nothing.
+func TestFormat_Golden(t *testing.T) {
+	t.Parallel()
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "fmt", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no golden inputs found")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(input, ".input") + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := Format(src); !bytes.Equal(got, want) {
+				t.Errorf("Format(%s):\n%s\nwant:\n%s", name, got, want)
+			}
+			if again := Format(want); !bytes.Equal(again, want) {
+				t.Errorf("Format is not idempotent on %s.golden:\n%s", name, again)
+			}
+		})
+	}
+}
+
+func TestFormat_Edges(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "", ""},
+		{"only blank lines", "\n\n  \n", ""},
+		{"adds final newline", "export A=1", "export A=1\n"},
+		{"carriage returns untouched", "export B=1\r\nexport A=1\r\n", "export B=1\r\nexport A=1\r\n"},
+		{"unterminated heredoc untouched", "export B=1\nexport A=1\ncat <<EOF\n  x  \n", "export A=1\nexport B=1\ncat <<EOF\n  x  \n"},
+		{"unterminated quote untouched", "export B=1\nexport A=\"x\n  y  \n", "export B=1\nexport A=\"x\n  y  \n"},
+		{"blank lines collapse inside blocks", "if a; then\n\n\n  b\nfi\n", "if a; then\n\n  b\nfi\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := string(Format([]byte(tt.src))); got != tt.want {
+				t.Errorf("Format(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
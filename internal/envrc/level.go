@@ -0,0 +1,56 @@
+package envrc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FragmentDir is the directory whose *.envrc files a level includes after
+// its .envrc, such as envrc.d/10-base.envrc and envrc.d/20-secrets.envrc.
+const FragmentDir = "envrc.d"
+
+// fragmentExt is the suffix of a file FragmentDir includes.
+const fragmentExt = ".envrc"
+
+// LevelFiles returns the files directory dir contributes to the chain, in
+// the order they are evaluated:
+//
+//  1. dir/.envrc, whether or not it exists
+//  2. the *.envrc files in dir/envrc.d, in lexical (byte) order of their
+//     names, so 10-base.envrc runs before 20-secrets.envrc but also
+//     before 2-x.envrc; pad numeric prefixes to the same width
+//
+// Fragments whose names start with "." and entries that are directories
+// are left out. Each file is allowed and watched on its own.
+func LevelFiles(dir string) []string {
+	files := []string{filepath.Join(dir, envrcName)}
+
+	fragDir := filepath.Join(dir, FragmentDir)
+	entries, err := os.ReadDir(fragDir)
+	if err != nil {
+		return files
+	}
+
+	// ReadDir sorts by name, which is the evaluation order
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fragmentExt) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(fragDir, name)); err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, filepath.Join(fragDir, name))
+	}
+	return files
+}
+
+// Label returns how rc is named within its directory: .envrc, or
+// envrc.d/<name> for a fragment.
+func (rc *RC) Label() string {
+	if rel, err := filepath.Rel(rc.Dir, rc.Path); err == nil {
+		return rel
+	}
+	return filepath.Base(rc.Path)
+}
@@ -0,0 +1,178 @@
+package envrc
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLevelFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string // Created under the directory; a trailing / makes a directory
+		links map[string]string
+		want  []string // Relative to the directory
+	}{
+		{
+			name: "empty directory",
+			want: []string{".envrc"},
+		},
+		{
+			name:  "only .envrc",
+			files: []string{".envrc"},
+			want:  []string{".envrc"},
+		},
+		{
+			name:  "empty envrc.d",
+			files: []string{".envrc", "envrc.d/"},
+			want:  []string{".envrc"},
+		},
+		{
+			name:  "fragments without .envrc",
+			files: []string{"envrc.d/10-base.envrc"},
+			want:  []string{".envrc", "envrc.d/10-base.envrc"},
+		},
+		{
+			name:  "lexical order",
+			files: []string{".envrc", "envrc.d/20-secrets.envrc", "envrc.d/10-base.envrc", "envrc.d/30-local.envrc"},
+			want:  []string{".envrc", "envrc.d/10-base.envrc", "envrc.d/20-secrets.envrc", "envrc.d/30-local.envrc"},
+		},
+		{
+			name:  "byte order, not numeric",
+			files: []string{"envrc.d/2-b.envrc", "envrc.d/10-a.envrc", "envrc.d/B.envrc", "envrc.d/a.envrc"},
+			want:  []string{".envrc", "envrc.d/10-a.envrc", "envrc.d/2-b.envrc", "envrc.d/B.envrc", "envrc.d/a.envrc"},
+		},
+		{
+			name:  "other names are ignored",
+			files: []string{"envrc.d/README.md", "envrc.d/10-base.envrc.bak", "envrc.d/10-base.envrc~", "envrc.d/envrc", "envrc.d/10-base.envrc"},
+			want:  []string{".envrc", "envrc.d/10-base.envrc"},
+		},
+		{
+			name:  "hidden fragments are ignored",
+			files: []string{"envrc.d/.envrc", "envrc.d/.10-draft.envrc", "envrc.d/20-x.envrc"},
+			want:  []string{".envrc", "envrc.d/20-x.envrc"},
+		},
+		{
+			name:  "directories are ignored",
+			files: []string{"envrc.d/10-dir.envrc/", "envrc.d/20-x.envrc"},
+			want:  []string{".envrc", "envrc.d/20-x.envrc"},
+		},
+		{
+			name:  "nested fragments are not included",
+			files: []string{"envrc.d/sub/10-x.envrc"},
+			want:  []string{".envrc"},
+		},
+		{
+			name:  "envrc.d is a file",
+			files: []string{".envrc", "envrc.d"},
+			want:  []string{".envrc"},
+		},
+		{
+			name:  "symlinked fragment",
+			files: []string{"shared.sh"},
+			links: map[string]string{"envrc.d/10-shared.envrc": "../shared.sh"},
+			want:  []string{".envrc", "envrc.d/10-shared.envrc"},
+		},
+		{
+			name:  "dangling symlink is ignored",
+			files: []string{"envrc.d/20-x.envrc"},
+			links: map[string]string{"envrc.d/10-gone.envrc": "../missing"},
+			want:  []string{".envrc", "envrc.d/20-x.envrc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if name[len(name)-1] == '/' {
+					if err := os.MkdirAll(path, 0o755); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.WriteFile(path, []byte("true\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, target := range tt.links {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for _, path := range LevelFiles(dir) {
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, rel)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("LevelFiles() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindChain_Fragments(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(root, "project")
+	for _, path := range []string{
+		filepath.Join(root, ".envrc"),
+		filepath.Join(project, ".envrc"),
+		filepath.Join(project, FragmentDir, "20-b.envrc"),
+		filepath.Join(project, FragmentDir, "10-a.envrc"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("export X=1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := FindChain(root, project)
+	if err != nil {
+		t.Fatalf("FindChain: %v", err)
+	}
+
+	want := []struct {
+		label string
+		dir   string
+		seq   int
+	}{
+		{".envrc", root, 0},
+		{".envrc", project, 1},
+		{"envrc.d/10-a.envrc", project, 2},
+		{"envrc.d/20-b.envrc", project, 3},
+	}
+	if len(chain) != len(want) {
+		t.Fatalf("len(chain) = %d, want %d", len(chain), len(want))
+	}
+	for i, rc := range chain {
+		if rc.Label() != want[i].label || rc.Dir != want[i].dir || rc.Seq != want[i].seq {
+			t.Errorf("chain[%d] = %s in %s (seq %d), want %s in %s (seq %d)",
+				i, rc.Label(), rc.Dir, rc.Seq, want[i].label, want[i].dir, want[i].seq)
+		}
+		if !rc.Exists || rc.ContentHash == "" {
+			t.Errorf("chain[%d] %s: Exists = %v, ContentHash = %q", i, rc.Path, rc.Exists, rc.ContentHash)
+		}
+	}
+	if chain[2].ContentHash == chain[3].ContentHash {
+		t.Error("fragments with the same content share a hash; each must be allowed on its own")
+	}
+}
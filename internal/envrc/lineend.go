@@ -0,0 +1,20 @@
+package envrc
+
+import "bytes"
+
+// utf8BOM is the byte order mark some Windows editors put at the start of
+// a UTF-8 file.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// NormalizeLineEndings returns src with a leading UTF-8 byte order mark
+// removed and CRLF line endings turned into LF, and whether either was
+// found. Bash reads the CR as part of the last word on every line ("\r:
+// command not found") and the BOM as part of the first command. A CR not
+// followed by LF is left alone. src itself is not modified.
+func NormalizeLineEndings(src []byte) ([]byte, bool) {
+	out, bom := bytes.CutPrefix(src, utf8BOM)
+	if !bytes.Contains(out, []byte("\r\n")) {
+		return out, bom
+	}
+	return bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n")), true
+}
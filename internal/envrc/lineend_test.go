@@ -0,0 +1,36 @@
+package envrc
+
+import "testing"
+
+func TestNormalizeLineEndings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		changed bool
+	}{
+		{"unix", "export A=1\nexport B=2\n", "export A=1\nexport B=2\n", false},
+		{"empty", "", "", false},
+		{"bom only", "\xEF\xBB\xBFexport A=1\n", "export A=1\n", true},
+		{"crlf only", "export A=1\r\nexport B=2\r\n", "export A=1\nexport B=2\n", true},
+		{"bom and crlf", "\xEF\xBB\xBFexport A=1\r\n", "export A=1\n", true},
+		{"mixed endings", "export A=1\r\nexport B=2\nexport C=3\r\n", "export A=1\nexport B=2\nexport C=3\n", true},
+		{"lone cr kept", "export A='x\ry'\n", "export A='x\ry'\n", false},
+		{"bom not at start kept", "export A='\xEF\xBB\xBF'\n", "export A='\xEF\xBB\xBF'\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			src := []byte(tt.src)
+			got, changed := NormalizeLineEndings(src)
+			if string(got) != tt.want || changed != tt.changed {
+				t.Errorf("NormalizeLineEndings(%q) = %q, %v; want %q, %v", tt.src, got, changed, tt.want, tt.changed)
+			}
+			if string(src) != tt.src {
+				t.Errorf("NormalizeLineEndings modified its input: %q", src)
+			}
+		})
+	}
+}
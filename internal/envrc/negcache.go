@@ -0,0 +1,113 @@
+package envrc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// racyWindow is how old a directory's mtime must be before a NegativeCache
+// relies on it. A file added within the same timestamp tick as the
+// recorded mtime would leave it unchanged, and coarse filesystems (FAT,
+// some NFS servers) tick in whole seconds.
+const racyWindow = 2 * time.Second
+
+// NegativeCache records that the chain for a working directory had no
+// .envrc anywhere, with the mtime of every directory that was looked at.
+// Adding or removing a file changes its directory's mtime, so while the
+// working directory and every recorded mtime are unchanged, the chain
+// still has no .envrc and discovery can be skipped.
+type NegativeCache struct {
+	Dir  string    `json:"d"` // Working directory the chain was found for
+	Key  string    `json:"k"` // Fingerprint of the settings the chain was found with
+	Dirs []DirTime `json:"t"` // Directories looked at, root first
+}
+
+// DirTime is a directory's mtime when a NegativeCache was recorded.
+type DirTime struct {
+	Path    string `json:"p"`
+	Modtime int64  `json:"m"` // Unix nanoseconds
+}
+
+// NewNegativeCache records the mtimes of dirs for the chain found for dir
+// with settings key. It reports false if a directory cannot be statted or
+// changed too recently to be relied on (see racyWindow); the next lookup
+// then records it again.
+func NewNegativeCache(dir, key string, dirs []string) (*NegativeCache, bool) {
+	c := &NegativeCache{Dir: dir, Key: key, Dirs: make([]DirTime, 0, len(dirs))}
+	settled := time.Now().Add(-racyWindow)
+	for _, path := range dirs {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(settled) {
+			return nil, false
+		}
+		c.Dirs = append(c.Dirs, DirTime{Path: path, Modtime: info.ModTime().UnixNano()})
+	}
+	return c, true
+}
+
+// Valid reports whether the chain for dir with settings key still has no
+// .envrc: c was recorded for them and no directory's mtime has changed.
+// It costs one stat per recorded directory.
+func (c *NegativeCache) Valid(dir, key string) bool {
+	if c == nil || c.Dir != dir || c.Key != key || len(c.Dirs) == 0 {
+		return false
+	}
+	for _, d := range c.Dirs {
+		info, err := os.Stat(d.Path)
+		if err != nil || info.ModTime().UnixNano() != d.Modtime {
+			return false
+		}
+	}
+	return true
+}
+
+// Serialize encodes c for storage in an environment variable
+// (JSON → zlib → base64 URL-safe).
+func (c *NegativeCache) Serialize() (string, error) {
+	jsonData, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("json encode: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(jsonData); err != nil {
+		return "", fmt.Errorf("zlib write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("zlib close: %w", err)
+	}
+
+	return base64.URLEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+// ParseNegativeCache decodes a serialized NegativeCache.
+func ParseNegativeCache(encoded string) (*NegativeCache, error) {
+	compressed, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode: %w", err)
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("zlib reader: %w", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	jsonData, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("zlib read: %w", err)
+	}
+
+	var c NegativeCache
+	if err := json.Unmarshal(jsonData, &c); err != nil {
+		return nil, fmt.Errorf("json decode: %w", err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,195 @@
+package envrc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// settledTree creates root/a/b/c with every directory's mtime moved an
+// hour back, past racyWindow, and returns root and the chain's directories.
+func settledTree(t testing.TB) (root string, dirs []string) {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs = []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b"), filepath.Join(root, "a", "b", "c")}
+	if err := os.MkdirAll(dirs[len(dirs)-1], 0o755); err != nil {
+		t.Fatal(err)
+	}
+	settle(t, dirs...)
+	return root, dirs
+}
+
+// settle moves the mtimes of paths an hour back.
+func settle(t testing.TB, paths ...string) {
+	t.Helper()
+	past := time.Now().Add(-time.Hour)
+	for _, path := range paths {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNegativeCache_Valid(t *testing.T) {
+	_, dirs := settledTree(t)
+	cwd := dirs[len(dirs)-1]
+
+	c, ok := NewNegativeCache(cwd, "key", dirs)
+	if !ok {
+		t.Fatal("NewNegativeCache() not recorded for settled directories")
+	}
+	if !c.Valid(cwd, "key") {
+		t.Error("Valid() = false for an unchanged chain")
+	}
+	if c.Valid(dirs[1], "key") {
+		t.Error("Valid() = true after cd to another directory")
+	}
+	if c.Valid(cwd, "other") {
+		t.Error("Valid() = true with other settings")
+	}
+	var nilCache *NegativeCache
+	if nilCache.Valid(cwd, "key") {
+		t.Error("Valid() = true for a nil cache")
+	}
+}
+
+// TestNegativeCache_NewEnvrc verifies that an .envrc created at any level
+// of the chain, including a parent several levels up, invalidates the
+// cache through its directory's mtime.
+func TestNegativeCache_NewEnvrc(t *testing.T) {
+	for level := range 4 {
+		t.Run(fmt.Sprintf("level %d", level), func(t *testing.T) {
+			_, dirs := settledTree(t)
+			cwd := dirs[len(dirs)-1]
+			c, ok := NewNegativeCache(cwd, "key", dirs)
+			if !ok {
+				t.Fatal("NewNegativeCache() not recorded for settled directories")
+			}
+
+			if err := os.WriteFile(filepath.Join(dirs[level], ".envrc"), []byte("export A=1\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if c.Valid(cwd, "key") {
+				t.Errorf("Valid() = true after creating %s/.envrc", dirs[level])
+			}
+		})
+	}
+}
+
+func TestNegativeCache_RemovedDirectory(t *testing.T) {
+	_, dirs := settledTree(t)
+	cwd := dirs[len(dirs)-1]
+	c, ok := NewNegativeCache(cwd, "key", dirs)
+	if !ok {
+		t.Fatal("NewNegativeCache() not recorded for settled directories")
+	}
+	if err := os.Remove(cwd); err != nil {
+		t.Fatal(err)
+	}
+	if c.Valid(cwd, "key") {
+		t.Error("Valid() = true after the working directory was removed")
+	}
+}
+
+// TestNegativeCache_Racy verifies that a directory changed within
+// racyWindow is not recorded, since a file added in the same timestamp
+// tick would leave its mtime unchanged.
+func TestNegativeCache_Racy(t *testing.T) {
+	_, dirs := settledTree(t)
+	now := time.Now()
+	if err := os.Chtimes(dirs[1], now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewNegativeCache(dirs[len(dirs)-1], "key", dirs); ok {
+		t.Error("NewNegativeCache() recorded a directory changed just now")
+	}
+
+	future := now.Add(time.Hour)
+	if err := os.Chtimes(dirs[1], future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewNegativeCache(dirs[len(dirs)-1], "key", dirs); ok {
+		t.Error("NewNegativeCache() recorded a directory with a future mtime")
+	}
+}
+
+func TestNegativeCache_SerializeRoundTrip(t *testing.T) {
+	_, dirs := settledTree(t)
+	cwd := dirs[len(dirs)-1]
+	c, ok := NewNegativeCache(cwd, "key", dirs)
+	if !ok {
+		t.Fatal("NewNegativeCache() not recorded for settled directories")
+	}
+
+	encoded, err := c.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	parsed, err := ParseNegativeCache(encoded)
+	if err != nil {
+		t.Fatalf("ParseNegativeCache: %v", err)
+	}
+	if !parsed.Valid(cwd, "key") {
+		t.Error("parsed cache is not valid for an unchanged chain")
+	}
+
+	if _, err := ParseNegativeCache("not base64!"); err == nil {
+		t.Error("ParseNegativeCache() accepted garbage")
+	}
+}
+
+// BenchmarkNoEnvrcPrompt compares a prompt outside any project with and
+// without the negative cache: discovering a 16-level chain that has no
+// .envrc, against confirming a cache recorded for it.
+func BenchmarkNoEnvrcPrompt(b *testing.B) {
+	root, err := filepath.EvalSymlinks(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	dirs := []string{root}
+	for i := range 16 {
+		dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], fmt.Sprintf("d%02d", i)))
+	}
+	target := dirs[len(dirs)-1]
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	settle(b, dirs...)
+
+	b.Run("discover", func(b *testing.B) {
+		for b.Loop() {
+			chain, _, err := FindChainSkip(root, target, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(ExistingOnly(chain)) != 0 {
+				b.Fatal("found an .envrc")
+			}
+		}
+	})
+
+	c, ok := NewNegativeCache(target, "key", dirs)
+	if !ok {
+		b.Fatal("NewNegativeCache() not recorded for settled directories")
+	}
+	encoded, err := c.Serialize()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("negative cache", func(b *testing.B) {
+		for b.Loop() {
+			parsed, err := ParseNegativeCache(encoded)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !parsed.Valid(target, "key") {
+				b.Fatal("cache not valid")
+			}
+		}
+	})
+}
@@ -14,19 +14,44 @@ import (
 // RC represents a single .envrc file.
 type RC struct {
 	Path        string // Absolute path to .envrc
-	Dir         string // Directory containing the .envrc
+	Dir         string // Directory of the chain level (for a fragment, the one holding envrc.d)
 	Exists      bool   // Whether the file currently exists
 	ContentHash string // SHA256(absolutePath + "\n" + content), empty if !Exists
+	Digest      string // SHA256(content) alone, as sha256sum prints it, from the same read; empty if !Exists
+
+	// Names in Dir that spell .envrc in another case (such as .Envrc). They
+	// are never loaded; set by FindChain only.
+	CaseVariants []string
+
+	// Position among the files its directory contributes (see LevelFiles),
+	// from 1, or 0 if the directory contributes only its .envrc. Set by
+	// FindChain only.
+	Seq int
+
+	// Why the level was not searched (UnsearchedOtherFilesystem or
+	// UnsearchedTimeout), or empty. Set by FindChain only.
+	Unsearched string
 }
 
 // NewRC creates an RC from a path, computing hash if file exists.
-// The path is resolved to an absolute path and symlinks are evaluated.
+// The path is resolved to an absolute path and symlinks are evaluated. On
+// a case-insensitive volume, the directory is spelled as it is on disk.
 func NewRC(path string) (*RC, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("absolute path: %w", err)
 	}
+	return newRC(canonicalDir(absPath))
+}
 
+// canonicalDir spells the directory of absPath as it is on disk (see
+// CanonicalCase), keeping the file name as given.
+func canonicalDir(absPath string) string {
+	return filepath.Join(CanonicalCase(filepath.Dir(absPath)), filepath.Base(absPath))
+}
+
+// newRC is NewRC for an absolute path that is already canonical.
+func newRC(absPath string) (*RC, error) {
 	// Check if file exists before resolving symlinks
 	info, err := os.Lstat(absPath)
 	if err != nil {
@@ -40,25 +65,23 @@ func NewRC(path string) (*RC, error) {
 		return nil, fmt.Errorf("stat %s: %w", absPath, err)
 	}
 
-	// Resolve symlinks for the actual file path
-	resolvedPath := absPath
-	if info.Mode()&os.ModeSymlink != 0 {
-		resolvedPath, err = filepath.EvalSymlinks(absPath)
-		if err != nil {
-			return nil, fmt.Errorf("resolve symlink %s: %w", absPath, err)
-		}
+	resolvedPath, err := resolveFile(absPath, info)
+	if err != nil {
+		return nil, err
 	}
 
-	hash, err := fileHash(resolvedPath)
+	content, err := os.ReadFile(resolvedPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("read file %s: %w", resolvedPath, err)
 	}
+	digest := sha256.Sum256(content)
 
 	return &RC{
 		Path:        absPath,
 		Dir:         filepath.Dir(absPath),
 		Exists:      true,
-		ContentHash: hash,
+		ContentHash: contentHash(resolvedPath, content),
+		Digest:      hex.EncodeToString(digest[:]),
 	}, nil
 }
 
@@ -70,6 +93,54 @@ func (rc *RC) Content() ([]byte, error) {
 	return os.ReadFile(rc.Path)
 }
 
+// ErrChanged is returned by Snapshot when the file no longer matches the
+// ContentHash it was approved with.
+var ErrChanged = errors.New("file changed between approval and evaluation")
+
+// Snapshot reads the file once and returns the bytes, verifying that they
+// still hash to ContentHash. Evaluating the returned bytes instead of
+// re-reading the path means the content that runs is exactly the content
+// that was approved, even if the file is replaced in the meantime.
+func (rc *RC) Snapshot() ([]byte, error) {
+	if !rc.Exists {
+		return nil, fmt.Errorf("file does not exist: %s", rc.Path)
+	}
+
+	info, err := os.Lstat(rc.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", rc.Path, ErrChanged)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", rc.Path, err)
+	}
+	resolvedPath, err := resolveFile(rc.Path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %w", resolvedPath, err)
+	}
+	if contentHash(resolvedPath, content) != rc.ContentHash {
+		return nil, fmt.Errorf("%s: %w", rc.Path, ErrChanged)
+	}
+	return content, nil
+}
+
+// resolveFile returns the file an .envrc path refers to, following the
+// path itself if it is a symlink.
+func resolveFile(absPath string, info fs.FileInfo) (string, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return absPath, nil
+	}
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", fmt.Errorf("resolve symlink %s: %w", absPath, err)
+	}
+	return resolved, nil
+}
+
 // fileHash computes SHA256 of (absolute path + "\n" + content).
 // This prevents both content modification AND symlink attacks.
 func fileHash(path string) (string, error) {
@@ -77,16 +148,27 @@ func fileHash(path string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("read file %s: %w", path, err)
 	}
+	return contentHash(path, content), nil
+}
+
+// HashContent returns the content hash an .envrc at path with the given
+// content would have, for comparing with a hash recorded on another machine.
+func HashContent(path string, content []byte) string {
+	return contentHash(path, content)
+}
 
+func contentHash(path string, content []byte) string {
 	h := sha256.New()
 	h.Write([]byte(path))
 	h.Write([]byte("\n"))
 	h.Write(content)
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-// PathHash computes SHA256 of just the absolute path (for deny files).
+// PathHash computes SHA256 of just the absolute path (for deny files). Like
+// NewRC, it spells the directory as it is on disk, so a path reached with
+// different case on a case-insensitive volume hashes the same.
 func PathHash(path string) (string, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -100,6 +182,7 @@ func PathHash(path string) (string, error) {
 			absPath = resolved
 		}
 	}
+	absPath = canonicalDir(absPath)
 
 	h := sha256.New()
 	h.Write([]byte(absPath))
@@ -3,9 +3,14 @@ package envrc
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewRC_ExistingFile(t *testing.T) {
@@ -263,6 +268,129 @@ func TestFindChain_TargetNotUnderRoot(t *testing.T) {
 	}
 }
 
+func TestFindChainSkip(t *testing.T) {
+	tests := []struct {
+		name        string
+		marker      string // Directory (relative to root) holding the marker
+		markerName  string
+		extra       []string
+		wantDirs    []string
+		wantSkipped string
+	}{
+		{"no marker", "", "", nil, []string{".", "a", "a/b", "a/b/c"}, ""},
+		{"marker at root", ".", SkipMarker, nil, []string{}, "."},
+		{"marker mid-chain", "a/b", SkipMarker, nil, []string{".", "a"}, "a/b"},
+		{"marker at target", "a/b/c", SkipMarker, nil, []string{".", "a", "a/b"}, "a/b/c"},
+		{"extra marker name", "a", ".no-envrc", []string{".no-envrc"}, []string{"."}, "a"},
+		{"unconfigured marker name", "a", ".no-envrc", nil, []string{".", "a", "a/b", "a/b/c"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(root, "a", "b", "c")
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+			if tt.marker != "" {
+				if err := os.WriteFile(filepath.Join(root, tt.marker, tt.markerName), nil, 0o644); err != nil {
+					t.Fatalf("write marker: %v", err)
+				}
+			}
+
+			chain, skipped, err := FindChainSkip(root, target, tt.extra)
+			if err != nil {
+				t.Fatalf("FindChainSkip: %v", err)
+			}
+
+			var gotDirs []string
+			for _, rc := range chain {
+				rel, _ := filepath.Rel(root, rc.Dir)
+				gotDirs = append(gotDirs, filepath.ToSlash(rel))
+			}
+			if strings.Join(gotDirs, " ") != strings.Join(tt.wantDirs, " ") {
+				t.Errorf("chain = %v, want %v", gotDirs, tt.wantDirs)
+			}
+
+			wantSkipped := ""
+			if tt.wantSkipped != "" {
+				wantSkipped = filepath.Join(root, tt.wantSkipped)
+			}
+			if skipped != wantSkipped {
+				t.Errorf("skipped = %q, want %q", skipped, wantSkipped)
+			}
+		})
+	}
+}
+
+func TestFindChainSkip_MarkerContentIgnored(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "a")
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, SkipMarker), []byte("generated tree, see README\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, skipped, err := FindChainSkip(root, target, nil)
+	if err != nil {
+		t.Fatalf("FindChainSkip: %v", err)
+	}
+	if len(chain) != 1 || skipped != target {
+		t.Errorf("chain has %d levels, skipped = %q; want the marker with content to cut the chain at %s", len(chain), skipped, target)
+	}
+}
+
+// BenchmarkFindChain_DeepTree compares discovery of a 64-level chain with
+// and without a skip marker near the top; levels is how many directories
+// were statted for an .envrc.
+func BenchmarkFindChain_DeepTree(b *testing.B) {
+	root, err := filepath.EvalSymlinks(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	target := root
+	for i := range 64 {
+		target = filepath.Join(target, fmt.Sprintf("d%02d", i))
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	generated := filepath.Join(root, "d00", "d01")
+
+	for _, bc := range []struct {
+		name   string
+		marker bool
+	}{{"no marker", false}, {"marker at depth 2", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			marker := filepath.Join(generated, SkipMarker)
+			if bc.marker {
+				if err := os.WriteFile(marker, nil, 0o644); err != nil {
+					b.Fatal(err)
+				}
+				defer os.Remove(marker)
+			}
+
+			var levels int
+			for b.Loop() {
+				chain, _, err := FindChainSkip(root, target, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				levels = len(chain)
+			}
+			b.ReportMetric(float64(levels), "levels")
+		})
+	}
+}
+
 func TestExistingOnly(t *testing.T) {
 	chain := []*RC{
 		{Path: "/a/.envrc", Exists: true},
@@ -327,3 +455,281 @@ func TestNewRC_Symlink(t *testing.T) {
 		t.Errorf("ContentHash = %q, want %q (based on resolved path)", rc.ContentHash, expectedHash)
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("eval symlinks dir: %v", err)
+	}
+	approved := []byte("export SNAP=approved\n")
+	target := filepath.Join(dir, "target.envrc")
+	other := filepath.Join(dir, "other.envrc")
+	for _, path := range []string{target, other} {
+		if err := os.WriteFile(path, approved, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		link    bool                   // .envrc is a symlink to target
+		swap    func(envrcPath string) // Runs between NewRC and Snapshot
+		wantErr bool
+	}{
+		{"unchanged", false, func(string) {}, false},
+		{"unchanged symlink", true, func(string) {}, false},
+		{"content replaced", false, func(path string) {
+			_ = os.WriteFile(path, []byte("export SNAP=evil\n"), 0o644)
+		}, true},
+		{"file renamed over", false, func(path string) {
+			evil := path + ".tmp"
+			_ = os.WriteFile(evil, []byte("export SNAP=evil\n"), 0o644)
+			_ = os.Rename(evil, path)
+		}, true},
+		{"removed", false, func(path string) { _ = os.Remove(path) }, true},
+		{"symlink retargeted", true, func(path string) {
+			// Same bytes, different file: the hash covers the resolved path
+			_ = os.Remove(path)
+			_ = os.Symlink(other, path)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-"), ".envrc")
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.link {
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.WriteFile(path, approved, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			rc, err := NewRC(path)
+			if err != nil {
+				t.Fatalf("NewRC: %v", err)
+			}
+			tt.swap(path)
+
+			content, err := rc.Snapshot()
+			if tt.wantErr {
+				if !errors.Is(err, ErrChanged) {
+					t.Fatalf("Snapshot() error = %v, want ErrChanged", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Snapshot() error = %v", err)
+			}
+			if string(content) != string(approved) {
+				t.Errorf("Snapshot() = %q, want %q", content, approved)
+			}
+		})
+	}
+}
+
+// fakeDevices replaces statDevice for the test: directories at or below a
+// key of mounts report its device, everything else device 1. A key with a
+// nil device never answers, like a dead network mount.
+func fakeDevices(t *testing.T, mounts map[string]*uint64) {
+	t.Helper()
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+
+	orig := statDevice
+	t.Cleanup(func() { statDevice = orig })
+	statDevice = func(dir string) (uint64, bool, error) {
+		var dev *uint64
+		best := ""
+		for mount, d := range mounts {
+			if underAny(dir, []string{mount}) && len(mount) > len(best) {
+				best, dev = mount, d
+			}
+		}
+		if best == "" {
+			return 1, true, nil
+		}
+		if dev == nil {
+			<-hang
+			return 0, false, errors.New("unreachable")
+		}
+		return *dev, true, nil
+	}
+}
+
+func TestFindChainWith_FilesystemBoundary(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt := filepath.Join(root, "mnt")
+	target := filepath.Join(mnt, "data", "x")
+	for _, dir := range []string{root, mnt, filepath.Join(mnt, "data"), target} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, ".envrc"), []byte("export A=1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := uint64(2)
+
+	tests := []struct {
+		name   string
+		mounts map[string]*uint64
+		opts   ChainOptions
+		want   []string // Unsearched for each level, root first
+	}{
+		{
+			name: "same filesystem",
+			want: []string{"", "", "", ""},
+		},
+		{
+			name:   "mount is skipped by default",
+			mounts: map[string]*uint64{filepath.Join(mnt, "data"): &other},
+			want:   []string{"", "", UnsearchedOtherFilesystem, UnsearchedOtherFilesystem},
+		},
+		{
+			name:   "cross_filesystem searches the mount",
+			mounts: map[string]*uint64{filepath.Join(mnt, "data"): &other},
+			opts:   ChainOptions{CrossFilesystem: true},
+			want:   []string{"", "", "", ""},
+		},
+		{
+			name:   "allowed mount point",
+			mounts: map[string]*uint64{filepath.Join(mnt, "data"): &other},
+			opts:   ChainOptions{CrossAllow: []string{filepath.Join(mnt, "data") + "/"}},
+			want:   []string{"", "", "", ""},
+		},
+		{
+			name:   "allowed path below the mount point",
+			mounts: map[string]*uint64{filepath.Join(mnt, "data"): &other},
+			opts:   ChainOptions{CrossAllow: []string{target}},
+			want:   []string{"", "", UnsearchedOtherFilesystem, ""},
+		},
+		{
+			name:   "unrelated allowed mount point",
+			mounts: map[string]*uint64{mnt: &other},
+			opts:   ChainOptions{CrossAllow: []string{filepath.Join(root, "other")}},
+			want:   []string{"", UnsearchedOtherFilesystem, UnsearchedOtherFilesystem, UnsearchedOtherFilesystem},
+		},
+		{
+			name:   "dead mount times out",
+			mounts: map[string]*uint64{filepath.Join(mnt, "data"): nil},
+			opts:   ChainOptions{CrossFilesystem: true, StatTimeout: 20 * time.Millisecond},
+			want:   []string{"", "", UnsearchedTimeout, UnsearchedTimeout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDevices(t, tt.mounts)
+
+			chain, _, err := FindChainWith(root, target, tt.opts)
+			if err != nil {
+				t.Fatalf("FindChainWith: %v", err)
+			}
+			if len(chain) != len(tt.want) {
+				t.Fatalf("len(chain) = %d, want %d", len(chain), len(tt.want))
+			}
+			for i, rc := range chain {
+				if rc.Unsearched != tt.want[i] {
+					t.Errorf("%s: Unsearched = %q, want %q", rc.Dir, rc.Unsearched, tt.want[i])
+				}
+				if wantExists := tt.want[i] == ""; rc.Exists != wantExists {
+					t.Errorf("%s: Exists = %v, want %v", rc.Dir, rc.Exists, wantExists)
+				}
+			}
+		})
+	}
+}
+
+func TestFindRootedChain_OutsideRoot(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(base, "home")
+	outside := filepath.Join(base, "srv", "projects")
+	target := filepath.Join(outside, "api", "cmd")
+	for _, dir := range []string{root, filepath.Join(outside, "api"), target} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{outside, target} {
+		if err := os.WriteFile(filepath.Join(dir, ".envrc"), []byte("export A=1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := uint64(2)
+
+	tests := []struct {
+		name      string
+		mounts    map[string]*uint64
+		opts      ChainOptions
+		wantStart string
+	}{
+		{
+			name:      "cwd-only by default",
+			wantStart: target,
+		},
+		{
+			name:      "walk-up to the filesystem root",
+			opts:      ChainOptions{OutsideRoot: OutsideRootWalkUp},
+			wantStart: "/",
+		},
+		{
+			name:      "walk-up bounded by max_walk_depth",
+			opts:      ChainOptions{OutsideRoot: OutsideRootWalkUp, MaxWalkDepth: 2},
+			wantStart: outside,
+		},
+		{
+			name:      "walk-up stops at the target's filesystem",
+			mounts:    map[string]*uint64{filepath.Join(base, "srv"): &other},
+			opts:      ChainOptions{OutsideRoot: OutsideRootWalkUp},
+			wantStart: filepath.Join(base, "srv"),
+		},
+		{
+			name:      "walk-up crosses filesystems with cross_filesystem",
+			mounts:    map[string]*uint64{filepath.Join(base, "srv"): &other},
+			opts:      ChainOptions{OutsideRoot: OutsideRootWalkUp, CrossFilesystem: true},
+			wantStart: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDevices(t, tt.mounts)
+
+			chain, start, _, err := FindRootedChain(root, target, tt.opts)
+			if err != nil {
+				t.Fatalf("FindRootedChain: %v", err)
+			}
+			if start != tt.wantStart {
+				t.Errorf("start = %q, want %q", start, tt.wantStart)
+			}
+			var existing []string // Levels above base are the machine's own
+			for _, rc := range ExistingOnly(chain) {
+				if underAny(rc.Dir, []string{base}) {
+					existing = append(existing, rc.Dir)
+				}
+			}
+			want := []string{target}
+			if tt.wantStart != target {
+				want = []string{outside, target}
+			}
+			if !slices.Equal(existing, want) {
+				t.Errorf("existing levels = %v, want %v", existing, want)
+			}
+		})
+	}
+
+	// A target under root is not affected
+	if _, start, _, err := FindRootedChain(base, target, ChainOptions{OutsideRoot: OutsideRootWalkUp}); err != nil || start != base {
+		t.Errorf("FindRootedChain under root: start = %q, %v, want %q", start, err, base)
+	}
+}
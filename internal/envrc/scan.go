@@ -0,0 +1,152 @@
+package envrc
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Actions of a Declaration.
+const (
+	DeclareExport = "export"     // export NAME[=VALUE]
+	DeclarePath   = "PATH_add"   // PATH_add DIR
+	DeclareUnset  = "unset"      // unset NAME
+	DeclareSource = "source_env" // source_env FILE
+)
+
+// Declaration is a change an .envrc appears to make, as found by Scan.
+type Declaration struct {
+	Action string // One of the Declare* actions
+	Name   string // The variable: PATH for DeclarePath, "" for DeclareSource
+	Value  string // The value, directory or file as written, with quotes removed
+}
+
+// varName matches a whole variable name.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Scan lists the exports, PATH_add, unset and source_env commands in an
+// .envrc without running it, in file order, as a preview of what it would
+// do. It is best effort: only commands at the top level of the file are
+// read, so anything inside a conditional, loop, function, or after && or ||
+// is left out, as are commands spanning several lines. Values are as
+// written; variable references and substitutions are not expanded.
+func Scan(src []byte) []Declaration {
+	var decls []Declaration
+	src, _ = NormalizeLineEndings(src)
+	lines := strings.Split(string(src), "\n")
+	depth, cases := 0, 0
+	for i := 0; i < len(lines); {
+		c, next, ok := nextChunk(lines, i)
+		if !ok {
+			break
+		}
+		i = next
+		if c.kind == chunkBlank || c.kind == chunkComment {
+			continue
+		}
+
+		b := countBlocks(c.tokens)
+		top := depth == 0 && cases == 0 && b.opens == 0 && b.cases == 0
+		depth = max(depth+b.opens-b.closes, 0)
+		cases = max(cases+b.cases-b.esacs, 0)
+		if !top || c.kind != chunkLine || len(c.lexed.heredocs) > 0 {
+			continue
+		}
+		for _, words := range simpleCommands(c.tokens) {
+			decls = append(decls, declarations(words)...)
+		}
+	}
+	return decls
+}
+
+// simpleCommands splits tokens at ; into the words of each command. A
+// command with any other operator (a pipe, &&, a redirection, a
+// subshell) is left out.
+func simpleCommands(tokens []token) [][]string {
+	var cmds [][]string
+	var words []string
+	skip := false
+	for _, t := range append(tokens, token{text: ";", op: true}) {
+		switch {
+		case t.op && (t.text == ";" || t.text == "\n"):
+			if !skip && len(words) > 0 {
+				cmds = append(cmds, words)
+			}
+			words, skip = nil, false
+		case t.op:
+			skip = true
+		default:
+			words = append(words, t.text)
+		}
+	}
+	return cmds
+}
+
+// declarations returns what the command words declare, if anything.
+func declarations(words []string) []Declaration {
+	var decls []Declaration
+	args := words[1:]
+	switch words[0] {
+	case "export":
+		if slices.ContainsFunc(args, func(a string) bool { return a == "-n" || a == "-f" }) {
+			return nil
+		}
+		for _, arg := range args {
+			if m := assignment.FindStringSubmatch(arg); m != nil {
+				decls = append(decls, Declaration{Action: DeclareExport, Name: m[1], Value: unquote(arg[len(m[0]):])})
+			} else if varName.MatchString(arg) {
+				decls = append(decls, Declaration{Action: DeclareExport, Name: arg})
+			}
+		}
+	case "PATH_add":
+		for _, arg := range args {
+			decls = append(decls, Declaration{Action: DeclarePath, Name: "PATH", Value: unquote(arg)})
+		}
+	case "unset":
+		if slices.Contains(args, "-f") {
+			return nil
+		}
+		for _, arg := range args {
+			if varName.MatchString(arg) {
+				decls = append(decls, Declaration{Action: DeclareUnset, Name: arg})
+			}
+		}
+	case "source_env":
+		if len(args) > 0 {
+			decls = append(decls, Declaration{Action: DeclareSource, Value: unquote(args[0])})
+		}
+	}
+	return decls
+}
+
+// unquote removes the quotes and backslash escapes from a shell word,
+// leaving variable references and substitutions as written.
+func unquote(word string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				out.WriteByte(c)
+			}
+		case c == '\\' && i+1 < len(word) && (quote == 0 || strings.IndexByte("\\\"$`", word[i+1]) >= 0):
+			i++
+			out.WriteByte(word[i])
+		case c == '"':
+			if quote == '"' {
+				quote = 0
+			} else {
+				quote = c
+			}
+		case c == '\'' && quote == 0:
+			quote = c
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
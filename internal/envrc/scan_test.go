@@ -0,0 +1,110 @@
+package envrc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		src  string
+		want []Declaration
+	}{
+		{"empty", "", nil},
+		{
+			"exports",
+			"export A=1\nexport B='two words' C=\"$HOME/x\"\nexport D\n",
+			[]Declaration{
+				{Action: DeclareExport, Name: "A", Value: "1"},
+				{Action: DeclareExport, Name: "B", Value: "two words"},
+				{Action: DeclareExport, Name: "C", Value: "$HOME/x"},
+				{Action: DeclareExport, Name: "D"},
+			},
+		},
+		{
+			"other commands",
+			"PATH_add bin 'tools dir'\nunset OLD OTHER\nsource_env ../shared.envrc\n",
+			[]Declaration{
+				{Action: DeclarePath, Name: "PATH", Value: "bin"},
+				{Action: DeclarePath, Name: "PATH", Value: "tools dir"},
+				{Action: DeclareUnset, Name: "OLD"},
+				{Action: DeclareUnset, Name: "OTHER"},
+				{Action: DeclareSource, Value: "../shared.envrc"},
+			},
+		},
+		{
+			"commands joined by semicolons",
+			"export A=1; export B=2 # comment\n",
+			[]Declaration{
+				{Action: DeclareExport, Name: "A", Value: "1"},
+				{Action: DeclareExport, Name: "B", Value: "2"},
+			},
+		},
+		{
+			"byte order mark and crlf",
+			"\xEF\xBB\xBFexport A=1\r\nPATH_add bin\r\n",
+			[]Declaration{
+				{Action: DeclareExport, Name: "A", Value: "1"},
+				{Action: DeclarePath, Name: "PATH", Value: "bin"},
+			},
+		},
+		{
+			"conditionals are skipped",
+			"if true; then\n  export A=1\nfi\n[ -f x ] && export B=1\nif x; then export C=1; fi\nexport D=1\n",
+			[]Declaration{{Action: DeclareExport, Name: "D", Value: "1"}},
+		},
+		{
+			"functions and case are skipped",
+			"f() {\n  export A=1\n}\ncase $x in\n  a) export B=1 ;;\nesac\nexport C=1\n",
+			[]Declaration{{Action: DeclareExport, Name: "C", Value: "1"}},
+		},
+		{
+			"multi-line commands are skipped",
+			"export A=\"one\ntwo\"\nexport B=1 \\\n  C=2\nexport D=1\n",
+			[]Declaration{{Action: DeclareExport, Name: "D", Value: "1"}},
+		},
+		{
+			"flags",
+			"export -n A\nunset -f func\nunset -v B\n",
+			[]Declaration{{Action: DeclareUnset, Name: "B"}},
+		},
+		{
+			"carriage returns",
+			"export A=1\r\nexport B=2\r\n",
+			[]Declaration{
+				{Action: DeclareExport, Name: "A", Value: "1"},
+				{Action: DeclareExport, Name: "B", Value: "2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := Scan([]byte(tt.src)); !slices.Equal(got, tt.want) {
+				t.Errorf("Scan(%q) =\n%+v\nwant\n%+v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		`plain`:          "plain",
+		`'single $x'`:    "single $x",
+		`"double \"q\""`: `double "q"`,
+		`a\ b`:           "a b",
+		`"$(cmd)"`:       "$(cmd)",
+		`'it'\''s'`:      "it's",
+	}
+	for word, want := range tests {
+		if got := unquote(word); got != want {
+			t.Errorf("unquote(%s) = %q, want %q", word, got, want)
+		}
+	}
+}
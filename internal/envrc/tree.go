@@ -1,16 +1,27 @@
 package envrc
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const envrcName = ".envrc"
 
+// SkipMarker is the file that stops chain discovery: a directory containing
+// it, and everything below, contributes no levels to the chain.
+const SkipMarker = ".cascade-skip"
+
 // FindChain discovers all .envrc files from root to target directory.
 // Returns ordered slice from root (first) to target (last).
 // Includes entries for directories without .envrc (Exists=false) for watch tracking.
+// A directory with fragments in FragmentDir has an entry for each, in the
+// order LevelFiles gives.
+// The chain stops above any directory containing SkipMarker, and levels on
+// another filesystem than root are not searched (see FindChainWith).
 //
 // Example: FindChain("/home/user", "/home/user/work/api")
 // Returns RCs for:
@@ -18,31 +29,160 @@ const envrcName = ".envrc"
 //   - /home/user/work/.envrc (if exists, or Exists=false)
 //   - /home/user/work/api/.envrc (if exists, or Exists=false)
 func FindChain(root, target string) ([]*RC, error) {
+	chain, _, err := FindChainSkip(root, target, nil)
+	return chain, err
+}
+
+// FindChainSkip is FindChain with extra skip marker names besides
+// SkipMarker (see FindChainWith).
+func FindChainSkip(root, target string, markers []string) (chain []*RC, skipped string, err error) {
+	return FindChainWith(root, target, ChainOptions{Markers: markers})
+}
+
+// DefaultStatTimeout is how long callers that run at every prompt wait
+// for a directory on the chain before treating it as unreachable.
+const DefaultStatTimeout = time.Second
+
+// Reasons a level of the chain was not searched (see RC.Unsearched).
+const (
+	UnsearchedOtherFilesystem = "other filesystem"
+	UnsearchedTimeout         = "timeout"
+)
+
+// ChainOptions controls chain discovery beyond its root and target.
+type ChainOptions struct {
+	// Markers are extra skip marker names besides SkipMarker.
+	Markers []string
+
+	// CrossFilesystem searches levels on another filesystem than root.
+	// By default they are treated as having no .envrc, so a network
+	// mount below root is not statted at every prompt.
+	CrossFilesystem bool
+
+	// CrossAllow lists absolute mount points whose levels, and those
+	// below them, are searched even without CrossFilesystem.
+	CrossAllow []string
+
+	// StatTimeout bounds the wait for each level's stat. A level that does
+	// not answer in time, and every level below it, is not searched.
+	// Zero waits forever.
+	StatTimeout time.Duration
+
+	// OutsideRoot is where FindRootedChain starts the chain of a target
+	// that is not under root: OutsideRootCwdOnly (the default if empty) or
+	// OutsideRootWalkUp.
+	OutsideRoot string
+
+	// MaxWalkDepth bounds how many directories above target
+	// OutsideRootWalkUp looks at. Zero means no bound.
+	MaxWalkDepth int
+}
+
+// Values of ChainOptions.OutsideRoot.
+const (
+	OutsideRootCwdOnly = "cwd-only" // The chain is target alone
+	OutsideRootWalkUp  = "walk-up"  // The chain starts at target's topmost ancestor on its filesystem
+)
+
+// FindRootedChain is FindChainWith, except that a target that is not under
+// root has a chain of its own, which starts where opts.OutsideRoot says:
+// at target itself, or at its topmost ancestor on the same filesystem (or
+// any filesystem with opts.CrossFilesystem), at most opts.MaxWalkDepth
+// levels up. start is the directory the chain starts at.
+func FindRootedChain(root, target string, opts ChainOptions) (chain []*RC, start, skipped string, err error) {
+	chain, skipped, err = FindChainWith(root, target, opts)
+	if err == nil {
+		return chain, root, skipped, nil
+	}
+
+	start = target
+	if opts.OutsideRoot == OutsideRootWalkUp {
+		if start, err = walkUpRoot(target, opts); err != nil {
+			return nil, "", "", err
+		}
+	}
+	chain, skipped, err = FindChainWith(start, target, opts)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return chain, start, skipped, nil
+}
+
+// walkUpRoot returns the topmost ancestor of target that OutsideRootWalkUp
+// starts its chain at. The walk stops below a directory on another
+// filesystem, unless opts.CrossFilesystem is set, and below one whose stat
+// fails or times out.
+func walkUpRoot(target string, opts ChainOptions) (string, error) {
+	dir, err := filepath.Abs(target)
+	if err != nil {
+		return "", fmt.Errorf("absolute target path: %w", err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", fmt.Errorf("resolve target symlinks: %w", err)
+	}
+	dir = CanonicalCase(dir)
+
+	dev, haveDev, _ := statDevice(dir)
+	for depth := 0; opts.MaxWalkDepth <= 0 || depth < opts.MaxWalkDepth; depth++ {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		parentDev, haveParentDev, err := statDeviceWithin(parent, opts.StatTimeout)
+		if err != nil {
+			break
+		}
+		if !opts.CrossFilesystem && haveDev && haveParentDev && parentDev != dev {
+			break
+		}
+		dir = parent
+	}
+	return dir, nil
+}
+
+// FindChainWith is FindChain with options. Directories are visited from
+// root down, and the first one containing a skip marker ends the chain: it
+// and the directories below it are not looked at again, so a marker high
+// in a large tree saves a stat per level. skipped is that directory, or
+// empty if the chain reached target.
+//
+// Markers are only found on the way down from root, so a marker cannot
+// remove levels above the directory it is in.
+//
+// A level on another device than root, unless allowed by opts, or whose
+// stat times out, is kept in the chain with Exists=false and Unsearched
+// set, and so is every level below it. Resolving root and target still
+// stats every component of their paths, so the timeout is best-effort.
+func FindChainWith(root, target string, opts ChainOptions) (chain []*RC, skipped string, err error) {
 	// Resolve to absolute paths
 	absRoot, err := filepath.Abs(root)
 	if err != nil {
-		return nil, fmt.Errorf("absolute root path: %w", err)
+		return nil, "", fmt.Errorf("absolute root path: %w", err)
 	}
 
 	absTarget, err := filepath.Abs(target)
 	if err != nil {
-		return nil, fmt.Errorf("absolute target path: %w", err)
+		return nil, "", fmt.Errorf("absolute target path: %w", err)
 	}
 
 	// Resolve symlinks
 	absRoot, err = filepath.EvalSymlinks(absRoot)
 	if err != nil {
-		return nil, fmt.Errorf("resolve root symlinks: %w", err)
+		return nil, "", fmt.Errorf("resolve root symlinks: %w", err)
 	}
 
 	absTarget, err = filepath.EvalSymlinks(absTarget)
 	if err != nil {
-		return nil, fmt.Errorf("resolve target symlinks: %w", err)
+		return nil, "", fmt.Errorf("resolve target symlinks: %w", err)
 	}
 
+	// On a case-insensitive volume, spell both as they are on disk
+	absRoot = CanonicalCase(absRoot)
+	absTarget = CanonicalCase(absTarget)
+
 	// Ensure target is under root
 	if !strings.HasPrefix(absTarget, absRoot) {
-		return nil, fmt.Errorf("target %s is not under root %s", absTarget, absRoot)
+		return nil, "", fmt.Errorf("target %s is not under root %s", absTarget, absRoot)
 	}
 
 	// Walk UP from target to root, collecting directories
@@ -56,7 +196,7 @@ func FindChain(root, target string) ([]*RC, error) {
 		parent := filepath.Dir(current)
 		if parent == current {
 			// Reached filesystem root without finding our root
-			return nil, fmt.Errorf("target %s is not under root %s", absTarget, absRoot)
+			return nil, "", fmt.Errorf("target %s is not under root %s", absTarget, absRoot)
 		}
 		current = parent
 	}
@@ -66,18 +206,126 @@ func FindChain(root, target string) ([]*RC, error) {
 		dirs[i], dirs[j] = dirs[j], dirs[i]
 	}
 
-	// Create RC for each directory
-	chain := make([]*RC, 0, len(dirs))
+	markers := append([]string{SkipMarker}, opts.Markers...)
+	rootDev, haveRootDev, _ := statDevice(absRoot)
+
+	// Create RC for each directory, stopping at the first skip marker
+	chain = make([]*RC, 0, len(dirs))
+	crossed := "" // Why the level above was not searched
 	for _, dir := range dirs {
-		envrcPath := filepath.Join(dir, envrcName)
-		rc, err := NewRC(envrcPath)
-		if err != nil {
-			return nil, fmt.Errorf("create RC for %s: %w", envrcPath, err)
+		allowed := opts.CrossFilesystem || underAny(dir, opts.CrossAllow)
+		reason := ""
+		if crossed == UnsearchedTimeout || (crossed != "" && !allowed) {
+			reason = crossed
+		} else {
+			dev, haveDev, err := statDeviceWithin(dir, opts.StatTimeout)
+			switch {
+			case errors.Is(err, errStatTimeout):
+				reason = UnsearchedTimeout
+			case haveRootDev && haveDev && dev != rootDev && !allowed:
+				reason = UnsearchedOtherFilesystem
+			}
+		}
+		if reason != "" {
+			crossed = reason
+			chain = append(chain, &RC{Path: filepath.Join(dir, envrcName), Dir: dir, Unsearched: reason})
+			continue
+		}
+
+		if hasMarker(dir, markers) {
+			return chain, dir, nil
+		}
+
+		// The .envrc itself comes first, present or not. A case-insensitive
+		// volume would open .Envrc under that name too, so a variant alone
+		// means none.
+		files := LevelFiles(dir)
+		exact, variants := caseVariants(dir, envrcName)
+		for i, path := range files {
+			rc := &RC{Path: path, Dir: dir}
+			if i > 0 || exact || len(variants) == 0 {
+				if rc, err = newRC(path); err != nil {
+					return nil, "", fmt.Errorf("create RC for %s: %w", path, err)
+				}
+			}
+			rc.Dir = dir // A fragment belongs to the level, not to envrc.d
+			if i == 0 {
+				rc.CaseVariants = variants
+			}
+			if len(files) > 1 {
+				rc.Seq = i + 1
+			}
+			chain = append(chain, rc)
+		}
+	}
+
+	return chain, "", nil
+}
+
+// statDevice returns the device number of the volume holding dir, if stat
+// provides one. Tests replace it to simulate mount points.
+var statDevice = func(dir string) (dev uint64, ok bool, err error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0, false, err
+	}
+	dev, ok = volumeID(info)
+	return dev, ok, nil
+}
+
+// errStatTimeout is returned by statDeviceWithin when stat does not answer.
+var errStatTimeout = errors.New("stat timed out")
+
+// statDeviceWithin is statDevice giving up after timeout, if positive. A
+// stat on a dead network mount may never return; its goroutine is left
+// behind, which is harmless in a process that exits after one prompt.
+func statDeviceWithin(dir string, timeout time.Duration) (uint64, bool, error) {
+	if timeout <= 0 {
+		return statDevice(dir)
+	}
+
+	type result struct {
+		dev uint64
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		dev, ok, err := statDevice(dir)
+		done <- result{dev, ok, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.dev, r.ok, r.err
+	case <-timer.C:
+		return 0, false, errStatTimeout
+	}
+}
+
+// underAny reports whether dir is one of dirs or below one of them.
+func underAny(dir string, dirs []string) bool {
+	for _, d := range dirs {
+		d = filepath.Clean(d)
+		if dir == d || strings.HasPrefix(dir, strings.TrimSuffix(d, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
 		}
-		chain = append(chain, rc)
 	}
+	return false
+}
 
-	return chain, nil
+// hasMarker reports whether dir contains any of the marker files. Only the
+// name matters: a marker's content, and whether it is a regular file, are
+// not checked.
+func hasMarker(dir string, markers []string) bool {
+	for _, name := range markers {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // ExistingOnly filters to only RCs where Exists=true.
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package envrc
+
+import "os"
+
+// volumeID reports no device number where stat does not provide one, so
+// case probes are not cached.
+func volumeID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package envrc
+
+import (
+	"os"
+	"syscall"
+)
+
+// volumeID returns the device number of the volume holding a file.
+func volumeID(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true //nolint:unconvert // Dev is int32 on some platforms
+}
@@ -9,38 +9,95 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/xdg"
 )
 
-// cacheEntry is the on-disk format for cached evaluation results.
+// cacheFormat is the version of cacheEntry. Entries in another format,
+// including those written before it was recorded, are stale, so a hit never
+// replays an entry missing fields the current Result carries.
+const cacheFormat = 2
+
+// cacheEntry is the on-disk format for cached evaluation results. It holds
+// every field of a Result except Sensitive and Sourced, as results with
+// either set are never cached (see Evaluate), and Stderr, as a hit runs
+// nothing to show.
 type cacheEntry struct {
-	Timestamp    time.Time `json:"timestamp"`
-	RCPath       string    `json:"rc_path"` // For debugging
-	Result       env.Env   `json:"result"`
-	ExtraWatches []string  `json:"extra_watches,omitempty"`
+	Format       int           `json:"format"`
+	Timestamp    time.Time     `json:"timestamp"`
+	RCPath       string        `json:"rc_path"`
+	Result       env.Env       `json:"result"`
+	ExtraWatches []string      `json:"extra_watches,omitempty"`
+	Watched      []string      `json:"watched,omitempty"` // ExtraWatches that existed when stored
+	Merge        env.MergeSpec `json:"merge,omitempty"`
+	Includes     []Include     `json:"includes,omitempty"`
+	Unload       []string      `json:"unload,omitempty"`
+}
+
+// stale reports whether the entry is in another format (see cacheFormat) or
+// refers to files that are gone: its .envrc, or a watched file that existed
+// when it was stored. Watches that did not exist yet (watch_file on a file
+// to be created) don't count.
+func (e *cacheEntry) stale() bool {
+	if e.Format != cacheFormat || !exists(e.RCPath) {
+		return true
+	}
+	for _, path := range e.Watched {
+		if !exists(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// existingWatches returns the watches of an entry for rcPath that exist,
+// with relative paths resolved against the directory of the .envrc.
+func existingWatches(rcPath string, watches []string) []string {
+	var existing []string
+	for _, path := range watches {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(rcPath), path)
+		}
+		if exists(path) {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
 }
 
 // Cache stores evaluated .envrc results to avoid re-execution.
 // Each entry is stored as a JSON file in the cache directory.
 type Cache struct {
-	dir string // e.g., ~/.cache/cascade/
+	dir     string        // e.g., ~/.cache/cascade/
+	exclude []string      // Variable name patterns whose values are never cached
+	maxAge  time.Duration // Entries stored longer ago are misses; 0 for no limit
 }
 
-// NewCache creates a cache using XDG_CACHE_HOME or ~/.cache/cascade.
-func NewCache() (*Cache, error) {
-	cacheDir := os.Getenv("XDG_CACHE_HOME")
-	if cacheDir == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("get home directory: %w", err)
-		}
-		cacheDir = filepath.Join(home, ".cache")
+// CacheDir returns the cache directory, $XDG_CACHE_HOME/cascade or
+// ~/.cache/cascade, without creating it.
+func CacheDir() (string, error) {
+	cacheDir, err := xdg.CacheHome()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(cacheDir, "cascade"), nil
+}
 
-	dir := filepath.Join(cacheDir, "cascade")
+// NewCache creates a cache in CacheDir.
+func NewCache() (*Cache, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return nil, fmt.Errorf("create cache directory: %w", err)
 	}
@@ -48,19 +105,65 @@ func NewCache() (*Cache, error) {
 	return &Cache{dir: dir}, nil
 }
 
+// WithExclude returns a copy of the Cache that does not store results in
+// which the .envrc set or changed a variable matching any of the patterns.
+func (c *Cache) WithExclude(patterns []string) *Cache {
+	cp := *c
+	cp.exclude = patterns
+	return &cp
+}
+
+// WithMaxAge returns a copy of the Cache whose entries are misses, and are
+// removed, once they were stored longer than maxAge ago. Zero means no limit.
+func (c *Cache) WithMaxAge(maxAge time.Duration) *Cache {
+	cp := *c
+	cp.maxAge = maxAge
+	return &cp
+}
+
+// expired reports whether an entry stored at ts is older than the cache's
+// maximum age.
+func (c *Cache) expired(ts, now time.Time) bool {
+	return c.maxAge > 0 && now.Sub(ts) > c.maxAge
+}
+
+// excludes reports whether the evaluation from input to output changed a
+// variable that must not be written to disk.
+func (c *Cache) excludes(input, output env.Env) bool {
+	if len(c.exclude) == 0 {
+		return false
+	}
+	for key, value := range output {
+		if prev, ok := input[key]; (!ok || prev != value) && env.MatchAny(c.exclude, key) {
+			return true
+		}
+	}
+	return false
+}
+
 // CacheKey computes a unique key for an evaluation.
-// Key = SHA256(rc.ContentHash + inputEnvHash)
+// Key = SHA256(rc.Path + rc.ContentHash + inputEnvHash)
 // This ensures cache invalidates when either the file OR input env changes.
+//
+// Variables cascade never tracks (see env.IgnoredEnv) are left out of the
+// input: the chain's base environment is filtered of them, but each level's
+// output carries what bash and the evaluator set (PWD, OLDPWD, CASCADE_DIR
+// and so on), so without filtering a level's key would change with the
+// working directory whenever an ancestor is evaluated rather than cached.
+// The key thus covers the base environment and what the ancestors changed.
 func CacheKey(rc *envrc.RC, inputEnv env.Env) string {
 	h := sha256.New()
 
-	// Include the RC content hash (which already includes the file path)
+	// The content hash covers the path of the resolved file, which symlinked
+	// .envrc files share; include the path the chain found it at too
+	h.Write([]byte(rc.Path))
+	h.Write([]byte("\n"))
 	h.Write([]byte(rc.ContentHash))
 	h.Write([]byte("\n"))
 
 	// Include a hash of the input environment
 	// ToGoEnv returns sorted keys for deterministic output
-	for _, entry := range inputEnv.ToGoEnv() {
+	for _, entry := range inputEnv.Filtered().ToGoEnv() {
 		h.Write([]byte(entry))
 		h.Write([]byte("\x00"))
 	}
@@ -68,9 +171,12 @@ func CacheKey(rc *envrc.RC, inputEnv env.Env) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// Get retrieves a cached result if valid.
-// Returns nil, false if not cached.
-func (c *Cache) Get(key string) (*Result, bool) {
+// Get retrieves a cached result if valid for the .envrc at rcPath.
+// Returns nil, false if not cached. An entry stored for another path, in
+// another format, whose .envrc or watched files have since been deleted, or
+// older than the maximum age, is a miss, and stale or expired entries are
+// removed.
+func (c *Cache) Get(key, rcPath string) (*Result, bool) {
 	path := c.entryPath(key)
 
 	data, err := os.ReadFile(path)
@@ -88,19 +194,36 @@ func (c *Cache) Get(key string) (*Result, bool) {
 		return nil, false
 	}
 
+	if entry.RCPath != rcPath {
+		return nil, false
+	}
+	if entry.stale() || c.expired(entry.Timestamp, time.Now()) {
+		_ = os.Remove(path)
+		return nil, false
+	}
+
 	return &Result{
 		Env:          entry.Result,
 		ExtraWatches: entry.ExtraWatches,
+		Merge:        entry.Merge,
+		Includes:     entry.Includes,
+		Unload:       entry.Unload,
+		Cached:       true,
 	}, true
 }
 
 // Set stores an evaluation result.
 func (c *Cache) Set(key string, result *Result, rcPath string) error {
 	entry := cacheEntry{
+		Format:       cacheFormat,
 		Timestamp:    time.Now(),
 		RCPath:       rcPath,
 		Result:       result.Env,
 		ExtraWatches: result.ExtraWatches,
+		Watched:      existingWatches(rcPath, result.ExtraWatches),
+		Merge:        result.Merge,
+		Includes:     result.Includes,
+		Unload:       result.Unload,
 	}
 
 	data, err := json.Marshal(entry)
@@ -155,6 +278,108 @@ func (c *Cache) Clear() error {
 	return nil
 }
 
+// GCOptions limits the entries GC keeps. Zero values mean no limit.
+type GCOptions struct {
+	MaxAge     time.Duration // Remove entries stored longer ago than this
+	MaxEntries int           // Then remove the oldest entries above this many
+	MaxBytes   int64         // ... and above this total size
+	DryRun     bool          // Count what would be removed, but remove nothing
+}
+
+// GC removes entries that can no longer be used: unreadable ones, those in
+// another format, those whose .envrc or watched files have been deleted,
+// and those older than opts.MaxAge. If more than opts.MaxEntries entries or
+// opts.MaxBytes bytes remain, the oldest are removed until both limits are
+// met. It returns the number of entries removed, or that would be with
+// opts.DryRun.
+func (c *Cache) GC(opts GCOptions) (int, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read cache directory: %w", err)
+	}
+
+	type keptEntry struct {
+		path      string
+		timestamp time.Time
+		size      int64
+	}
+	var kept, remove []keptEntry
+	var total int64
+	now := time.Now()
+	byAge := &Cache{maxAge: opts.MaxAge}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var ce cacheEntry
+		if err := json.Unmarshal(data, &ce); err != nil || ce.stale() || byAge.expired(ce.Timestamp, now) {
+			remove = append(remove, keptEntry{path: path})
+			continue
+		}
+		kept = append(kept, keptEntry{path: path, timestamp: ce.Timestamp, size: int64(len(data))})
+		total += int64(len(data))
+	}
+
+	// Over a limit: the oldest go first
+	slices.SortFunc(kept, func(a, b keptEntry) int { return a.timestamp.Compare(b.timestamp) })
+	for len(kept) > 0 && ((opts.MaxEntries > 0 && len(kept) > opts.MaxEntries) || (opts.MaxBytes > 0 && total > opts.MaxBytes)) {
+		remove = append(remove, kept[0])
+		total -= kept[0].size
+		kept = kept[1:]
+	}
+
+	if opts.DryRun {
+		return len(remove), nil
+	}
+
+	removed := 0
+	var errs []error
+	for _, entry := range remove {
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("failed to remove %d cache entries", len(errs))
+	}
+	return removed, nil
+}
+
+// gcMarker is the file whose mtime records when GCIfDue last ran GC.
+const gcMarker = ".last-gc"
+
+// GCIfDue runs GC with opts unless it already ran within interval, so
+// frequent callers such as export collect the cache about once per
+// interval. It reports whether GC ran. The time is recorded before
+// collecting, so concurrent callers don't all run it.
+func (c *Cache) GCIfDue(interval time.Duration, opts GCOptions) (bool, error) {
+	marker := filepath.Join(c.dir, gcMarker)
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < interval {
+		return false, nil
+	}
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		return false, fmt.Errorf("record cache collection: %w", err)
+	}
+	// Truncating an already empty file need not update its mtime
+	now := time.Now()
+	_ = os.Chtimes(marker, now, now)
+
+	_, err := c.GC(opts)
+	return true, err
+}
+
 // entryPath returns the file path for a cache key.
 func (c *Cache) entryPath(key string) string {
 	return filepath.Join(c.dir, key+".json")
@@ -1,9 +1,13 @@
 package eval
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
@@ -19,26 +23,29 @@ func TestCache_GetSet(t *testing.T) {
 		t.Fatalf("NewCache: %v", err)
 	}
 
+	rcPath := writeRC(t, t.TempDir(), "export FOO=bar")
 	key := "test-key-abc123"
 	result := &Result{
 		Env: env.Env{
 			"FOO": "bar",
 			"BAZ": "qux",
 		},
+		Includes: []Include{{From: rcPath, Path: "/shared/.envrc"}},
+		Unload:   []string{"docker compose down"},
 	}
 
 	// Initially should be a miss
-	if got, ok := cache.Get(key); ok {
+	if got, ok := cache.Get(key, rcPath); ok {
 		t.Errorf("expected cache miss, got %v", got)
 	}
 
 	// Set the value
-	if err := cache.Set(key, result, "/path/to/.envrc"); err != nil {
+	if err := cache.Set(key, result, rcPath); err != nil {
 		t.Fatalf("Set: %v", err)
 	}
 
 	// Now should be a hit
-	got, ok := cache.Get(key)
+	got, ok := cache.Get(key, rcPath)
 	if !ok {
 		t.Fatal("expected cache hit, got miss")
 	}
@@ -49,6 +56,12 @@ func TestCache_GetSet(t *testing.T) {
 	if got.Env["BAZ"] != "qux" {
 		t.Errorf("BAZ = %q, want %q", got.Env["BAZ"], "qux")
 	}
+	if !slices.Equal(got.Includes, result.Includes) {
+		t.Errorf("Includes = %v, want %v", got.Includes, result.Includes)
+	}
+	if !slices.Equal(got.Unload, result.Unload) {
+		t.Errorf("Unload = %q, want %q", got.Unload, result.Unload)
+	}
 }
 
 func TestCache_Clear(t *testing.T) {
@@ -61,15 +74,16 @@ func TestCache_Clear(t *testing.T) {
 	}
 
 	// Add some entries
+	rcPath := writeRC(t, t.TempDir(), "export N=1")
 	for i := range 3 {
 		key := "key-" + string(rune('a'+i))
-		if err := cache.Set(key, &Result{Env: env.Env{"N": string(rune('0' + i))}}, "/test"); err != nil {
+		if err := cache.Set(key, &Result{Env: env.Env{"N": string(rune('0' + i))}}, rcPath); err != nil {
 			t.Fatalf("Set: %v", err)
 		}
 	}
 
 	// Verify they exist
-	if _, ok := cache.Get("key-a"); !ok {
+	if _, ok := cache.Get("key-a", rcPath); !ok {
 		t.Fatal("expected key-a to exist")
 	}
 
@@ -79,10 +93,10 @@ func TestCache_Clear(t *testing.T) {
 	}
 
 	// Verify they're gone
-	if _, ok := cache.Get("key-a"); ok {
+	if _, ok := cache.Get("key-a", rcPath); ok {
 		t.Error("expected key-a to be cleared")
 	}
-	if _, ok := cache.Get("key-b"); ok {
+	if _, ok := cache.Get("key-b", rcPath); ok {
 		t.Error("expected key-b to be cleared")
 	}
 }
@@ -166,6 +180,30 @@ func TestCacheKey_SameInputsSameKey(t *testing.T) {
 	}
 }
 
+func TestCacheKey_IgnoresUntrackedVariables(t *testing.T) {
+	tmpDir := t.TempDir()
+	envrcPath := filepath.Join(tmpDir, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte(`export FOO=bar`), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	// As a parent level's output from another working directory would be
+	base := env.Env{"PATH": "/usr/bin", "PWD": "/a", "OLDPWD": "/", "CASCADE_DIR": "/a"}
+	moved := env.Env{"PATH": "/usr/bin", "PWD": "/a/b", "OLDPWD": "/a", "CASCADE_DIR": "/a/b", "SHLVL": "2"}
+
+	if CacheKey(rc, base) != CacheKey(rc, moved) {
+		t.Error("expected the same key when only untracked variables differ")
+	}
+	if CacheKey(rc, base) == CacheKey(rc, env.Env{"PATH": "/bin", "PWD": "/a"}) {
+		t.Error("expected different keys when a tracked variable differs")
+	}
+}
+
 func TestEvaluator_CacheHit(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv("XDG_CACHE_HOME", tmpDir)
@@ -212,7 +250,7 @@ func TestEvaluator_CacheHit(t *testing.T) {
 
 	// Verify it's in the cache
 	key := CacheKey(rc, inputEnv)
-	if _, ok := cache.Get(key); !ok {
+	if _, ok := cache.Get(key, rc.Path); !ok {
 		t.Error("expected result to be cached")
 	}
 
@@ -225,6 +263,63 @@ func TestEvaluator_CacheHit(t *testing.T) {
 	if result2.Env["FOO"] != "bar" {
 		t.Errorf("FOO = %q, want %q", result2.Env["FOO"], "bar")
 	}
+	if result1.Cached || !result2.Cached {
+		t.Errorf("Cached = %v, %v, want false, true", result1.Cached, result2.Cached)
+	}
+}
+
+func TestEvaluator_CacheHitKeepsWatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+
+	project := filepath.Join(tmpDir, "project")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "shared.env"), []byte("X=1"), 0o644); err != nil {
+		t.Fatalf("write watched file: %v", err)
+	}
+	// What watch_file and watch_dir record in the real stdlib
+	rcPath := writeRC(t, project, "export CASCADE_EXTRA_WATCHES=$'shared.env\\n*.cfg'\nexport CASCADE_WATCH_DIRS=conf\nexport FOO=bar\n")
+
+	rc, err := envrc.NewRC(rcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	evaluator, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	evaluator = evaluator.WithCache(cache)
+
+	inputEnv := env.Env{"HOME": "/home/test"}
+	result1, err := evaluator.Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate (first): %v", err)
+	}
+	result2, err := evaluator.Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate (second): %v", err)
+	}
+
+	if result1.Cached || !result2.Cached {
+		t.Fatalf("Cached = %v, %v, want false, true", result1.Cached, result2.Cached)
+	}
+	want := []string{
+		filepath.Join(project, "shared.env"),
+		filepath.Join(project, "*.cfg"),
+		env.DirWatch(filepath.Join(project, "conf")),
+	}
+	if !slices.Equal(result1.ExtraWatches, want) {
+		t.Errorf("ExtraWatches (evaluated) = %q, want %q", result1.ExtraWatches, want)
+	}
+	if !slices.Equal(result2.ExtraWatches, result1.ExtraWatches) {
+		t.Errorf("ExtraWatches (cached) = %q, want %q", result2.ExtraWatches, result1.ExtraWatches)
+	}
 }
 
 func TestEvaluator_CacheMissOnEnvChange(t *testing.T) {
@@ -365,7 +460,7 @@ func TestCache_CorruptedEntry(t *testing.T) {
 	}
 
 	// Should return miss, not error
-	if _, ok := cache.Get("corrupted-key"); ok {
+	if _, ok := cache.Get("corrupted-key", "/test/.envrc"); ok {
 		t.Error("expected cache miss for corrupted entry")
 	}
 }
@@ -426,3 +521,494 @@ func TestEvaluator_WithoutCache(t *testing.T) {
 		t.Errorf("FOO = %q, want %q", result.Env["FOO"], "bar")
 	}
 }
+
+func TestCache_ExcludesMatchingChanges(t *testing.T) {
+	t.Parallel()
+
+	cache := (&Cache{dir: t.TempDir()}).WithExclude([]string{"*_TOKEN"})
+	input := env.Env{"GITHUB_TOKEN": "from-shell", "HOME": "/home/test"}
+
+	tests := []struct {
+		name   string
+		output env.Env
+		want   bool
+	}{
+		{"unchanged secret from input", env.Env{"GITHUB_TOKEN": "from-shell", "FOO": "bar"}, false},
+		{"secret set by envrc", env.Env{"VAULT_TOKEN": "s3cr3t"}, true},
+		{"secret changed by envrc", env.Env{"GITHUB_TOKEN": "other"}, true},
+		{"no secrets", env.Env{"FOO": "bar"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cache.excludes(input, tt.output); got != tt.want {
+				t.Errorf("excludes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluator_RefreshBypassesCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+
+	envrcPath := filepath.Join(tmpDir, "project", ".envrc")
+	if err := os.MkdirAll(filepath.Dir(envrcPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(envrcPath, []byte(`export REFRESH="${CASCADE_REFRESH:-}"`), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	evaluator, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	evaluator = evaluator.WithCache(cache)
+
+	inputEnv := env.Env{"HOME": "/home/test"}
+	if _, err := evaluator.Evaluate(rc, inputEnv); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	result, err := evaluator.WithRefresh(true).Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate (refresh): %v", err)
+	}
+	if result.Env["REFRESH"] != "1" {
+		t.Errorf("REFRESH = %q, want %q (cached result returned?)", result.Env["REFRESH"], "1")
+	}
+}
+
+func TestEvaluator_DryRunStoresNothing(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+
+	envrcPath := filepath.Join(tmpDir, "project", ".envrc")
+	if err := os.MkdirAll(filepath.Dir(envrcPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(envrcPath, []byte(`export DRY_RUN="${CASCADE_DRY_RUN:-}"`), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	evaluator, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	evaluator = evaluator.WithCache(cache)
+
+	inputEnv := env.Env{"HOME": "/home/test"}
+	result, err := evaluator.WithDryRun().Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate (dry run): %v", err)
+	}
+	if result.Env["DRY_RUN"] != "1" {
+		t.Errorf("DRY_RUN = %q, want %q", result.Env["DRY_RUN"], "1")
+	}
+	if _, ok := cache.Get(CacheKey(rc, inputEnv), rc.Path); ok {
+		t.Error("dry run stored its result in the cache")
+	}
+
+	// A cached result is still used
+	if _, err := evaluator.Evaluate(rc, inputEnv); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	result, err = evaluator.WithDryRun().Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate (dry run): %v", err)
+	}
+	if result.Env["DRY_RUN"] != "" {
+		t.Errorf("DRY_RUN = %q, want the cached result", result.Env["DRY_RUN"])
+	}
+}
+
+func TestEvaluator_SensitiveNotCached(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+
+	// sensitive_env records names in CASCADE_SENSITIVE_VARS, inherited
+	// from earlier levels through the input environment
+	envrcPath := filepath.Join(tmpDir, "project", ".envrc")
+	if err := os.MkdirAll(filepath.Dir(envrcPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	content := "export DB_PASSWORD=s3cret\nexport CASCADE_SENSITIVE_VARS=\"${CASCADE_SENSITIVE_VARS:+$CASCADE_SENSITIVE_VARS\n}DB_PASSWORD\"\n"
+	if err := os.WriteFile(envrcPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	evaluator, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	evaluator = evaluator.WithCache(cache)
+
+	inputEnv := env.Env{"HOME": "/home/test", "CASCADE_SENSITIVE_VARS": "API_KEY"}
+	result, err := evaluator.Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	if got, want := result.Sensitive, []string{"API_KEY", "DB_PASSWORD"}; !slices.Equal(got, want) {
+		t.Errorf("Sensitive = %q, want %q", got, want)
+	}
+	if _, ok := result.Env["CASCADE_SENSITIVE_VARS"]; ok {
+		t.Error("CASCADE_SENSITIVE_VARS left in the result environment")
+	}
+	if result.Env["DB_PASSWORD"] != "s3cret" {
+		t.Errorf("DB_PASSWORD = %q, want the value exported", result.Env["DB_PASSWORD"])
+	}
+	if _, ok := cache.Get(CacheKey(rc, inputEnv), rc.Path); ok {
+		t.Error("result with sensitive variables was cached")
+	}
+}
+
+// writeRC writes an .envrc with content to dir and returns its path.
+func writeRC(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, ".envrc")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+	return path
+}
+
+func TestCache_IdenticalContentDifferentPath(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+	template := "export PROJECT=template"
+
+	// Two projects sharing an .envrc through symlinks to one template have
+	// the same content hash; each must get its own entry
+	shared := writeRC(t, t.TempDir(), template)
+	oldPath := filepath.Join(t.TempDir(), ".envrc")
+	newPath := filepath.Join(t.TempDir(), ".envrc")
+	for _, path := range []string{oldPath, newPath} {
+		if err := os.Symlink(shared, path); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+
+	oldRC, err := envrc.NewRC(oldPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+	newRC, err := envrc.NewRC(newPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+	if oldRC.ContentHash != newRC.ContentHash {
+		t.Fatal("expected symlinked .envrc files to share a content hash")
+	}
+
+	inputEnv := env.Env{"PATH": "/usr/bin"}
+	if CacheKey(oldRC, inputEnv) == CacheKey(newRC, inputEnv) {
+		t.Error("expected different keys for .envrc files at different paths")
+	}
+
+	// Even an entry stored under the same key is not served to another path
+	key := CacheKey(oldRC, inputEnv)
+	result := &Result{Env: env.Env{"PROJECT": "old"}, ExtraWatches: []string{filepath.Join(filepath.Dir(oldPath), "go.mod")}}
+	if err := cache.Set(key, result, oldRC.Path); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, ok := cache.Get(key, newRC.Path); ok {
+		t.Error("entry stored for one .envrc was served to another")
+	}
+	if _, ok := cache.Get(key, oldRC.Path); !ok {
+		t.Error("expected a hit for the .envrc the entry was stored for")
+	}
+}
+
+func TestCache_DanglingWatchIsMiss(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+	dir := t.TempDir()
+	rcPath := writeRC(t, dir, "export FOO=bar")
+
+	watched := filepath.Join(dir, "shared.env")
+	if err := os.WriteFile(watched, []byte("X=1"), 0o644); err != nil {
+		t.Fatalf("write watched file: %v", err)
+	}
+	notYet := filepath.Join(dir, ".env.local")
+
+	result := &Result{Env: env.Env{"FOO": "bar"}, ExtraWatches: []string{watched, notYet}}
+	if err := cache.Set("key", result, rcPath); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// A watch that never existed doesn't invalidate the entry
+	got, ok := cache.Get("key", rcPath)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if !slices.Equal(got.ExtraWatches, result.ExtraWatches) {
+		t.Errorf("ExtraWatches = %q, want %q", got.ExtraWatches, result.ExtraWatches)
+	}
+
+	// One that existed when stored and is now gone does, and the entry is removed
+	if err := os.Remove(watched); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("key", rcPath); ok {
+		t.Error("expected cache miss after a watched file was deleted")
+	}
+	if _, err := os.Stat(cache.entryPath("key")); !os.IsNotExist(err) {
+		t.Error("expected the stale entry to be removed")
+	}
+}
+
+func TestCache_OtherFormatIsMiss(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+	rcPath := writeRC(t, t.TempDir(), "export FOO=bar")
+
+	// An entry from before the format was recorded, missing fields a
+	// current Result would carry
+	data, err := json.Marshal(map[string]any{
+		"timestamp": time.Now(),
+		"rc_path":   rcPath,
+		"result":    env.Env{"FOO": "bar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.entryPath("key"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get("key", rcPath); ok {
+		t.Error("expected cache miss for an entry in another format")
+	}
+	if _, err := os.Stat(cache.entryPath("key")); !os.IsNotExist(err) {
+		t.Error("expected the old entry to be removed")
+	}
+}
+
+// TestCache_RoundTripsResult guards against a Result field being added
+// without the cache storing it: a hit would silently drop it.
+func TestCache_RoundTripsResult(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+	dir := t.TempDir()
+	rcPath := writeRC(t, dir, "export FOO=bar")
+
+	result := &Result{
+		Env:          env.Env{"FOO": "bar"},
+		ExtraWatches: []string{filepath.Join(dir, "w.txt"), env.DirWatch(filepath.Join(dir, "conf"))},
+		Merge:        env.MergeSpec{"LIST": ","},
+		Includes:     []Include{{From: rcPath, Path: filepath.Join(dir, "shared.envrc")}},
+		Unload:       []string{"docker compose down"},
+	}
+	// Fields a hit leaves zero: see cacheEntry
+	notCached := []string{"Sensitive", "Sourced", "Stderr", "Cached"}
+
+	rt := reflect.TypeFor[Result]()
+	rv := reflect.ValueOf(result).Elem()
+	for i := range rt.NumField() {
+		name := rt.Field(i).Name
+		if !slices.Contains(notCached, name) && rv.Field(i).IsZero() {
+			t.Fatalf("Result.%s is not set in this test; set it and make the cache store it", name)
+		}
+	}
+
+	if err := cache.Set("key", result, rcPath); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, ok := cache.Get("key", rcPath)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	want := *result
+	want.Cached = true
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("Get() = %+v, want %+v", *got, want)
+	}
+}
+
+func TestCache_GC(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+
+	kept := writeRC(t, t.TempDir(), "export A=1")
+	deletedDir := t.TempDir()
+	deleted := writeRC(t, deletedDir, "export A=1")
+
+	if err := cache.Set("kept", &Result{Env: env.Env{"A": "1"}}, kept); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := cache.Set("deleted", &Result{Env: env.Env{"A": "1"}}, deleted); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := os.WriteFile(cache.entryPath("corrupted"), []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(deletedDir); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := cache.GC(GCOptions{})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("GC removed %d entries, want 2", removed)
+	}
+	if _, ok := cache.Get("kept", kept); !ok {
+		t.Error("GC removed an entry whose .envrc still exists")
+	}
+	for _, key := range []string{"deleted", "corrupted"} {
+		if _, err := os.Stat(cache.entryPath(key)); !os.IsNotExist(err) {
+			t.Errorf("GC kept entry %q", key)
+		}
+	}
+}
+
+// setAged stores an entry for rcPath under key as if it had been stored age ago.
+func setAged(t *testing.T, cache *Cache, key, rcPath string, age time.Duration) {
+	t.Helper()
+	data, err := json.Marshal(cacheEntry{Format: cacheFormat, Timestamp: time.Now().Add(-age), RCPath: rcPath, Result: env.Env{"A": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.entryPath(key), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache_MaxAgeIsMiss(t *testing.T) {
+	cache := (&Cache{dir: t.TempDir()}).WithMaxAge(24 * time.Hour)
+	rcPath := writeRC(t, t.TempDir(), "export A=1")
+
+	setAged(t, cache, "fresh", rcPath, time.Hour)
+	setAged(t, cache, "old", rcPath, 48*time.Hour)
+
+	if _, ok := cache.Get("fresh", rcPath); !ok {
+		t.Error("expected a hit for an entry younger than the maximum age")
+	}
+	if _, ok := cache.Get("old", rcPath); ok {
+		t.Error("expected a miss for an entry older than the maximum age")
+	}
+	if _, err := os.Stat(cache.entryPath("old")); !os.IsNotExist(err) {
+		t.Error("expected the expired entry to be removed")
+	}
+
+	// Without a maximum age, age doesn't matter
+	setAged(t, cache, "old", rcPath, 48*time.Hour)
+	if _, ok := cache.WithMaxAge(0).Get("old", rcPath); !ok {
+		t.Error("expected a hit without a maximum age")
+	}
+}
+
+func TestCache_GCLimits(t *testing.T) {
+	rcPath := writeRC(t, t.TempDir(), "export A=1")
+
+	// Entries from oldest to newest
+	keys := []string{"e1", "e2", "e3", "e4"}
+	fill := func(t *testing.T) *Cache {
+		cache := &Cache{dir: t.TempDir()}
+		for i, key := range keys {
+			setAged(t, cache, key, rcPath, time.Duration(len(keys)-i)*time.Hour)
+		}
+		return cache
+	}
+	remaining := func(t *testing.T, cache *Cache) []string {
+		var got []string
+		for _, key := range keys {
+			if _, err := os.Stat(cache.entryPath(key)); err == nil {
+				got = append(got, key)
+			}
+		}
+		return got
+	}
+	info, err := os.Stat(fill(t).entryPath("e1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := info.Size() // Entries differ by a few bytes at most
+
+	tests := []struct {
+		name        string
+		opts        GCOptions
+		wantRemoved int
+		wantKept    []string
+	}{
+		{"no limits", GCOptions{}, 0, keys},
+		{"max age", GCOptions{MaxAge: 150 * time.Minute}, 2, []string{"e3", "e4"}},
+		{"max entries", GCOptions{MaxEntries: 3}, 1, []string{"e2", "e3", "e4"}},
+		{"max bytes", GCOptions{MaxBytes: 2*size + size/2}, 2, []string{"e3", "e4"}},
+		{"strictest limit wins", GCOptions{MaxEntries: 3, MaxBytes: size + size/2}, 3, []string{"e4"}},
+		{"dry run", GCOptions{MaxEntries: 1, DryRun: true}, 3, keys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := fill(t)
+			removed, err := cache.GC(tt.opts)
+			if err != nil {
+				t.Fatalf("GC: %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("GC removed %d entries, want %d", removed, tt.wantRemoved)
+			}
+			if got := remaining(t, cache); !slices.Equal(got, tt.wantKept) {
+				t.Errorf("entries left = %v, want %v", got, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestCache_GCIfDue(t *testing.T) {
+	cache := &Cache{dir: t.TempDir()}
+	rcPath := writeRC(t, t.TempDir(), "export A=1")
+	opts := GCOptions{MaxEntries: 1}
+
+	setAged(t, cache, "old", rcPath, 2*time.Hour)
+	setAged(t, cache, "new", rcPath, time.Hour)
+	if ran, err := cache.GCIfDue(time.Hour, opts); err != nil || !ran {
+		t.Fatalf("first GCIfDue() = %v, %v; want it to run", ran, err)
+	}
+	if _, err := os.Stat(cache.entryPath("old")); !os.IsNotExist(err) {
+		t.Error("GCIfDue did not collect the cache")
+	}
+
+	// Within the interval, nothing is collected
+	setAged(t, cache, "old", rcPath, 2*time.Hour)
+	if ran, err := cache.GCIfDue(time.Hour, opts); err != nil || ran {
+		t.Errorf("second GCIfDue() = %v, %v; want it to skip", ran, err)
+	}
+	if _, err := os.Stat(cache.entryPath("old")); err != nil {
+		t.Error("GCIfDue collected the cache again within the interval")
+	}
+
+	// Once the interval has passed, it runs again
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(cache.dir, gcMarker), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if ran, err := cache.GCIfDue(time.Hour, opts); err != nil || !ran {
+		t.Errorf("GCIfDue() after the interval = %v, %v; want it to run", ran, err)
+	}
+}
@@ -0,0 +1,160 @@
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/unrss/cascade/internal/env"
+)
+
+// DumpFormatVar asks cascade dump json for a dump format. The evaluator
+// sets it to DumpFormatV2; without it, the dump is plain JSON.
+const DumpFormatVar = "CASCADE_DUMP_FORMAT"
+
+// DumpFormatV2 is a framed dump carrying the environment twice: as JSON,
+// and as NUL-separated KEY=VALUE entries to fall back on if the JSON
+// cannot be decoded:
+//
+//	cascade-dump json-v2\n
+//	json <n>\n<n bytes of JSON>\n
+//	env <m>\n<m bytes of KEY=VALUE\0...>\n
+//
+// The env section is last, so it can be found from the end even when the
+// JSON section's framing is damaged.
+const DumpFormatV2 = "json-v2"
+
+const dumpHeader = "cascade-dump " + DumpFormatV2 + "\n"
+
+// DumpV2 outputs the environment in the DumpFormatV2 framing.
+func DumpV2(e env.Env, w io.Writer) error {
+	var jsonBuf bytes.Buffer
+	if err := DumpJSON(e, &jsonBuf); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var envBuf bytes.Buffer
+	for _, key := range keys {
+		envBuf.WriteString(key)
+		envBuf.WriteByte('=')
+		envBuf.WriteString(e[key])
+		envBuf.WriteByte(0)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(dumpHeader)
+	fmt.Fprintf(bw, "json %d\n", jsonBuf.Len())
+	bw.Write(jsonBuf.Bytes())
+	fmt.Fprintf(bw, "\nenv %d\n", envBuf.Len())
+	bw.Write(envBuf.Bytes())
+	bw.WriteByte('\n')
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write env dump: %w", err)
+	}
+	return nil
+}
+
+// ParseDump parses the output of cascade dump json, framed (DumpFormatV2)
+// or plain JSON as older or replaced CASCADE_BIN binaries print it.
+//
+// If a framed dump's JSON cannot be used, the environment is decoded from
+// its KEY=VALUE section instead and fallback reports what was wrong with
+// the JSON; err is set only when neither section is usable.
+func ParseDump(data []byte) (vars env.Env, fallback error, err error) {
+	if !bytes.HasPrefix(data, []byte(dumpHeader)) {
+		vars, err = ParseJSON(bytes.NewReader(data))
+		return vars, nil, err
+	}
+
+	jsonErr := errors.New("missing json section")
+	if section, ok := dumpSection(data[len(dumpHeader):], "json"); ok {
+		if vars, jsonErr = ParseJSON(bytes.NewReader(section)); jsonErr == nil {
+			return vars, nil, nil
+		}
+	}
+
+	section, ok := lastDumpSection(data, "env")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w; no usable KEY=VALUE section", jsonErr)
+	}
+	vars, err = parseNulEnv(section)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w; KEY=VALUE section: %w", jsonErr, err)
+	}
+	return vars, jsonErr, nil
+}
+
+// dumpSection returns the content of the section named name at the start
+// of data, if it is framed correctly.
+func dumpSection(data []byte, name string) ([]byte, bool) {
+	line, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil, false
+	}
+	n, ok := sectionLength(line, name)
+	if !ok || len(rest) < n+1 || rest[n] != '\n' {
+		return nil, false
+	}
+	return rest[:n], true
+}
+
+// lastDumpSection returns the content of the section named name that ends
+// data, found from the end so damage before it does not matter.
+func lastDumpSection(data []byte, name string) ([]byte, bool) {
+	marker := []byte("\n" + name + " ")
+	for end := len(data); ; {
+		i := bytes.LastIndex(data[:end], marker)
+		if i < 0 {
+			return nil, false
+		}
+		line, rest, ok := bytes.Cut(data[i+1:], []byte("\n"))
+		if n, valid := sectionLength(line, name); ok && valid && len(rest) == n+1 && rest[n] == '\n' {
+			return rest[:n], true
+		}
+		end = i
+	}
+}
+
+// sectionLength parses a section header line "<name> <length>".
+func sectionLength(line []byte, name string) (int, bool) {
+	rest, ok := bytes.CutPrefix(line, []byte(name+" "))
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(rest))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseNulEnv decodes NUL-terminated KEY=VALUE entries, as printed by
+// env -0. Entries without a name are skipped; if none is usable, it fails.
+func parseNulEnv(data []byte) (env.Env, error) {
+	vars := make(env.Env)
+	var bad int
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		if len(entry) == 0 {
+			continue
+		}
+		key, value, ok := bytes.Cut(entry, []byte("="))
+		if !ok || len(key) == 0 {
+			bad++
+			continue
+		}
+		vars[string(key)] = string(value)
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("no entries (%d malformed)", bad)
+	}
+	return vars, nil
+}
@@ -0,0 +1,164 @@
+package eval
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+func TestDumpV2_RoundTrip(t *testing.T) {
+	original := env.Env{
+		"FOO":   "bar",
+		"MULTI": "line1\nline2",
+		"ODD":   "tab\there \"quoted\" json v2\nenv 3\n",
+		"EMPTY": "",
+	}
+
+	var buf bytes.Buffer
+	if err := DumpV2(original, &buf); err != nil {
+		t.Fatalf("DumpV2: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "cascade-dump json-v2\njson ") {
+		t.Errorf("dump does not start with the v2 header: %q", buf.String())
+	}
+
+	parsed, fallback, err := ParseDump(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseDump: %v", err)
+	}
+	if fallback != nil {
+		t.Errorf("fallback = %v, want JSON to be used", fallback)
+	}
+	if !maps.Equal(parsed, original) {
+		t.Errorf("parsed = %v, want %v", parsed, original)
+	}
+
+	// The KEY=VALUE section alone carries the same environment
+	section, ok := lastDumpSection(buf.Bytes(), "env")
+	if !ok {
+		t.Fatal("env section not found")
+	}
+	fromEnv, err := parseNulEnv(section)
+	if err != nil {
+		t.Fatalf("parseNulEnv: %v", err)
+	}
+	if !maps.Equal(fromEnv, original) {
+		t.Errorf("env section = %v, want %v", fromEnv, original)
+	}
+}
+
+func TestParseDump_Fixtures(t *testing.T) {
+	want := env.Env{"FOO": "bar", "MULTI": "line1\nline2"}
+
+	tests := []struct {
+		file     string
+		want     env.Env
+		fallback string // Substring of the fallback reason, empty if JSON is used
+		err      string // Substring of the error, empty if parsing succeeds
+	}{
+		{file: "v1.json", want: want},
+		{file: "v2-valid.dump", want: want},
+		{file: "v2-bad-json.dump", want: want, fallback: "decode env json"},
+		{file: "v2-bad-json-length.dump", want: want, fallback: "missing json section"},
+		{file: "v2-fake-env-header.dump", want: env.Env{"FOO": "bar", "TRAP": "x\nenv 3\nabc"}, fallback: "decode env json"},
+		{file: "v2-bad-both.dump", err: "no entries (1 malformed)"},
+		{file: "v2-truncated.dump", err: "no usable KEY=VALUE section"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "dump", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, fallback, err := ParseDump(data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseDump() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDump: %v", err)
+			}
+			switch {
+			case tt.fallback == "" && fallback != nil:
+				t.Errorf("fallback = %v, want JSON to be used", fallback)
+			case tt.fallback != "" && (fallback == nil || !strings.Contains(fallback.Error(), tt.fallback)):
+				t.Errorf("fallback = %v, want %q", fallback, tt.fallback)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("ParseDump() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEvaluate_BrokenDumper overrides CASCADE_BIN with a dumper whose JSON
+// is corrupt and verifies that the evaluation recovers the environment
+// from the KEY=VALUE section, warns, and is not cached.
+func TestEvaluate_BrokenDumper(t *testing.T) {
+	tmpDir := t.TempDir()
+	envrcPath := filepath.Join(tmpDir, ".envrc")
+	if err := os.WriteFile(envrcPath, []byte("export FOO=\"bar baz\"\n"), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	// Frames a JSON section with an unescaped control byte and a correct
+	// KEY=VALUE section, as long as it is asked for json-v2
+	dumper := filepath.Join(tmpDir, "cascade-broken")
+	script := `#!/bin/bash
+[[ "$1" == "dump" && "$2" == "json" && "$CASCADE_DUMP_FORMAT" == "json-v2" ]] || exit 1
+json=$'{"FOO":"\x01'
+vars=$(mktemp)
+env -0 >"$vars"
+printf 'cascade-dump json-v2\njson %d\n%s\nenv %d\n' "${#json}" "$json" "$(($(wc -c <"$vars")))"
+cat "$vars"
+printf '\n'
+rm -f "$vars"
+`
+	if err := os.WriteFile(dumper, []byte(script), 0o755); err != nil {
+		t.Fatalf("write dumper: %v", err)
+	}
+
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	var stderr bytes.Buffer
+	eval, err := New("", testStdlib, dumper)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	eval = eval.WithStderr(&stderr, 0).WithCache(cache)
+
+	inputEnv := env.Env{"PATH": "/usr/bin:/bin"}
+	result, err := eval.Evaluate(rc, inputEnv)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if result.Env["FOO"] != "bar baz" {
+		t.Errorf("FOO = %q, want %q", result.Env["FOO"], "bar baz")
+	}
+
+	want := "cascade: warning: " + rc.Path + ": environment dump JSON is unreadable (decode env json: "
+	if !strings.HasPrefix(stderr.String(), want) || !strings.Contains(stderr.String(), "variables from its KEY=VALUE fallback\n") {
+		t.Errorf("stderr = %q, want warning starting %q", stderr.String(), want)
+	}
+
+	if _, ok := cache.Get(CacheKey(rc, inputEnv), rc.Path); ok {
+		t.Error("result recovered from a damaged dump was cached")
+	}
+}
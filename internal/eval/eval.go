@@ -7,26 +7,87 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/unrss/cascade/internal/env"
 	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/logging"
 )
 
 // Result holds the output of an .envrc evaluation.
 type Result struct {
-	Env          env.Env  // Resulting environment variables
-	ExtraWatches []string // Additional files to watch (from watch_file)
+	Env          env.Env       // Resulting environment variables
+	ExtraWatches []string      // Additional files to watch (from watch_file)
+	Merge        env.MergeSpec // Variables marked list-merged (from merge_var)
+	Sensitive    []string      // Variables whose values must not be written to disk (from sensitive_env)
+	Sourced      []string      // Ancestor files pulled in by source_up, in order
+	Includes     []Include     // Files sourced by source_env and source_env_if_exists, in order
+	Unload       []string      // Commands to run in the shell on leaving (from on_unload), in order
+	Cached       bool          // True if served from the cache without running the .envrc
 }
 
+// Include is a file sourced with source_env or source_env_if_exists.
+type Include struct {
+	From  string `json:"from"`            // File that sourced it: the .envrc evaluated, or another included file
+	Path  string `json:"path"`            // File sourced
+	Cycle bool   `json:"cycle,omitempty"` // Path was already being sourced, so it was not sourced again
+}
+
+// ExitError is returned when an .envrc evaluation exits with a non-zero status.
+type ExitError struct {
+	Path     string // .envrc that failed
+	ExitCode int    // bash exit status
+	Stdout   string // Captured stdout
+	Stderr   string // Captured stderr (bounded), empty unless WithStderr set a line limit
+}
+
+// Error includes the captured stdout, or else the last line of captured
+// stderr, which is usually the reason and may be past the lines shown live.
+func (e *ExitError) Error() string {
+	if e.Stdout != "" {
+		return fmt.Sprintf("bash exited with status %d: %s", e.ExitCode, e.Stdout)
+	}
+	if line := lastLine(e.Stderr); line != "" {
+		return fmt.Sprintf("bash exited with status %d: %s", e.ExitCode, line)
+	}
+	return fmt.Sprintf("bash exited with status %d", e.ExitCode)
+}
+
+// lastLine returns the last non-blank line of s, trimmed.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// pipeWaitDelay is how long Evaluate keeps reading bash's output once it
+// has exited, for pipes a background process still holds open.
+const pipeWaitDelay = 200 * time.Millisecond
+
 // Evaluator executes .envrc files and captures environment changes.
 type Evaluator struct {
 	bashPath string // Path to bash binary
 	stdlib   string // Embedded stdlib.sh content
 	selfPath string // Path to cascade binary (for callbacks)
 	cache    *Cache // Optional cache for evaluation results
+
+	stderr      io.Writer // Where .envrc stderr is shown (default os.Stderr)
+	stderrLines int       // Max stderr lines shown live per evaluation; 0 passes through unmodified
+
+	refresh bool   // Bypass cached results and ask stdlib helpers to recompute
+	dryRun  bool   // Store nothing in the cache, and ask stdlib helpers not to either
+	root    string // Cascade root, where source_up stops
+	dataDir string // Allow store override passed on to callbacks, empty for the default
+
+	log *logging.Logger // Debugging details of each evaluation, nil for none
 }
 
+// ErrWindowsBash is returned by New on Windows when no bash path is given.
+var ErrWindowsBash = errors.New(`bash_path is not set: on Windows, set it to the bash of Git for Windows (e.g. bash_path = 'C:\Program Files\Git\bin\bash.exe'), or run cascade inside WSL`)
+
 // New creates an Evaluator.
 //
 // bashPath: path to bash (if empty, uses exec.LookPath("bash"))
@@ -34,6 +95,11 @@ type Evaluator struct {
 // selfPath: path to cascade binary (for source_env callbacks)
 func New(bashPath, stdlib, selfPath string) (*Evaluator, error) {
 	if bashPath == "" {
+		// The bash.exe on PATH is usually the WSL launcher, which would
+		// evaluate in another filesystem with Linux paths
+		if runtime.GOOS == "windows" {
+			return nil, ErrWindowsBash
+		}
 		var err error
 		bashPath, err = exec.LookPath("bash")
 		if err != nil {
@@ -53,6 +119,7 @@ func New(bashPath, stdlib, selfPath string) (*Evaluator, error) {
 		bashPath: bashPath,
 		stdlib:   stdlib,
 		selfPath: selfPath,
+		stderr:   os.Stderr,
 	}, nil
 }
 
@@ -63,6 +130,63 @@ func (e *Evaluator) WithCache(c *Cache) *Evaluator {
 	return &cp
 }
 
+// WithStderr returns a copy of the Evaluator that shows .envrc stderr on w.
+// If maxLines is positive, at most maxLines lines are shown live per
+// evaluation and a bounded copy is kept in ExitError.Stderr if it fails.
+// If maxLines is zero or negative, stderr passes through unmodified.
+func (e *Evaluator) WithStderr(w io.Writer, maxLines int) *Evaluator {
+	cp := *e
+	cp.stderr = w
+	cp.stderrLines = max(0, maxLines)
+	return &cp
+}
+
+// WithLogger returns a copy of the Evaluator that logs the bash command line
+// and duration of each evaluation, and cache hits, at debug level to l.
+func (e *Evaluator) WithLogger(l *logging.Logger) *Evaluator {
+	cp := *e
+	cp.log = l
+	return &cp
+}
+
+// WithRefresh returns a copy of the Evaluator that, when refresh is true,
+// ignores cached results and sets CASCADE_REFRESH=1 in the subprocess so
+// helpers such as cache_output recompute their values. Fresh results are
+// still written to the cache.
+func (e *Evaluator) WithRefresh(refresh bool) *Evaluator {
+	cp := *e
+	cp.refresh = refresh
+	return &cp
+}
+
+// WithDryRun returns a copy of the Evaluator that writes nothing: cached
+// results are still used, but fresh ones are not stored, and
+// CASCADE_DRY_RUN=1 in the subprocess keeps helpers such as cache_output
+// from storing values.
+func (e *Evaluator) WithDryRun() *Evaluator {
+	cp := *e
+	cp.dryRun = true
+	return &cp
+}
+
+// WithRoot returns a copy of the Evaluator that tells the subprocess the
+// cascade root (CASCADE_ROOT_DIR), so source_up stops there instead of at
+// the filesystem root.
+func (e *Evaluator) WithRoot(root string) *Evaluator {
+	cp := *e
+	cp.root = root
+	return &cp
+}
+
+// WithDataDir returns a copy of the Evaluator that passes an allow store
+// override to the subprocess (CASCADE_DATA_DIR), so callbacks such as the
+// allow check in source_up consult the same store as the caller.
+func (e *Evaluator) WithDataDir(dir string) *Evaluator {
+	cp := *e
+	cp.dataDir = dir
+	return &cp
+}
+
 // Evaluate executes an RC file with the given input environment.
 // Returns the resulting environment and any extra watched files.
 //
@@ -70,12 +194,20 @@ func (e *Evaluator) WithCache(c *Cache) *Evaluator {
 //
 // Process:
 //  1. Check cache (if enabled)
-//  2. Spawn bash with stdlib eval and __main__ call
-//  3. Set CASCADE_BIN, CASCADE_DIR, CASCADE_STDLIB in subprocess env
-//  4. Capture JSON from fd 3, let stderr pass through
-//  5. Parse JSON to Env map
-//  6. Extract CASCADE_EXTRA_WATCHES for additional file watching
-//  7. Store result in cache (if enabled)
+//  2. Re-verify the content hash and copy the approved bytes to a private file
+//     (fails with envrc.ErrChanged if the file changed since approval)
+//  3. Spawn bash with stdlib eval and __main__ call, which sources the file
+//     by its own name while it matches the copy, and the copy otherwise
+//  4. Set CASCADE_BIN, CASCADE_DIR, CASCADE_RC_HASH, CASCADE_STDLIB,
+//     CASCADE_DUMP_FORMAT (and CASCADE_REFRESH, CASCADE_DRY_RUN,
+//     CASCADE_ROOT_DIR and CASCADE_DATA_DIR, if set) in subprocess env
+//  5. Capture the env dump from fd 3, let stderr pass through
+//  6. Parse the dump to Env map, falling back to its KEY=VALUE section if
+//     the JSON is unreadable (see ParseDump)
+//  7. Extract CASCADE_EXTRA_WATCHES and CASCADE_WATCH_DIRS for additional
+//     file and directory watching, and the other CASCADE_* variables stdlib
+//     helpers record declarations in
+//  8. Store result in cache (if enabled, and not a dry run)
 func (e *Evaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*Result, error) {
 	if !rc.Exists {
 		return nil, fmt.Errorf("rc file does not exist: %s", rc.Path)
@@ -85,11 +217,30 @@ func (e *Evaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*Result, error) {
 	var cacheKey string
 	if e.cache != nil {
 		cacheKey = CacheKey(rc, inputEnv)
-		if cached, ok := e.cache.Get(cacheKey); ok {
+		if cached, ok := e.cache.Get(cacheKey, rc.Path); ok && !e.refresh {
+			e.log.Debugf("%s: cached result", rc.Path)
 			return cached, nil
 		}
 	}
 
+	// Run exactly the approved bytes: a copy taken after re-verifying the
+	// hash cannot be swapped before or during evaluation
+	content, err := rc.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	// A file saved on Windows runs as if it had Unix line endings; the hash
+	// above is still of the bytes on disk, so approval is unaffected
+	content, normalized := envrc.NormalizeLineEndings(content)
+	if normalized {
+		e.log.Debugf("%s: removed byte order mark or CRLF line endings", rc.Path)
+	}
+	snapshot, err := WriteSnapshot(content)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(snapshot) }()
+
 	// Create pipe for fd 3 (JSON output)
 	jsonReader, jsonWriter, err := os.Pipe()
 	if err != nil {
@@ -98,26 +249,50 @@ func (e *Evaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*Result, error) {
 	defer jsonReader.Close()
 
 	// Build bash command: eval stdlib then call __main__
-	script := fmt.Sprintf(`eval "$CASCADE_STDLIB" && __main__ %q`, rc.Path)
+	script := fmt.Sprintf(`eval "$CASCADE_STDLIB" && __main__ %q %q %q`, rc.Path, snapshot, rc.Dir)
 
 	cmd := exec.Command(e.bashPath, "-c", script) //nolint:gosec // intentional shell evaluation
+	cmd.WaitDelay = pipeWaitDelay
 
 	// Set up environment
 	cmd.Env = inputEnv.ToGoEnv()
 	cmd.Env = append(cmd.Env, "CASCADE_BIN="+e.selfPath)
 	cmd.Env = append(cmd.Env, "CASCADE_DIR="+rc.Dir)
+	cmd.Env = append(cmd.Env, "CASCADE_RC_HASH="+rc.ContentHash)
 	cmd.Env = append(cmd.Env, "CASCADE_STDLIB="+e.stdlib)
+	cmd.Env = append(cmd.Env, DumpFormatVar+"="+DumpFormatV2)
+	if e.refresh {
+		cmd.Env = append(cmd.Env, "CASCADE_REFRESH=1")
+	}
+	if e.dryRun {
+		cmd.Env = append(cmd.Env, "CASCADE_DRY_RUN=1")
+	}
+	if e.root != "" {
+		cmd.Env = append(cmd.Env, "CASCADE_ROOT_DIR="+e.root)
+	}
+	if e.dataDir != "" {
+		cmd.Env = append(cmd.Env, "CASCADE_DATA_DIR="+e.dataDir)
+	}
 
 	// fd 3 is the JSON output channel
 	// ExtraFiles[0] becomes fd 3 in the child process
 	cmd.ExtraFiles = []*os.File{jsonWriter}
 
 	// Capture stdout for error messages, let stderr pass through
+	// (bounded to stderrLines when a limit is set)
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
-	cmd.Stderr = os.Stderr
+	var capture *stderrCapture
+	if e.stderrLines > 0 {
+		capture = newStderrCapture(e.stderr, e.stderrLines)
+		cmd.Stderr = capture
+	} else {
+		cmd.Stderr = e.stderr
+	}
 
 	// Start the command
+	e.log.Debugf("%s: running %s -c %s", rc.Path, e.bashPath, script)
+	started := time.Now()
 	if err := cmd.Start(); err != nil {
 		jsonWriter.Close()
 		return nil, fmt.Errorf("start bash: %w", err)
@@ -128,24 +303,43 @@ func (e *Evaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*Result, error) {
 
 	// Read JSON output from fd 3
 	var jsonBuf bytes.Buffer
-	if _, err := io.Copy(&jsonBuf, jsonReader); err != nil {
-		// Kill the process if we can't read
-		_ = cmd.Process.Kill()
-		_ = cmd.Wait()
+	jsonDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(&jsonBuf, jsonReader)
+		jsonDone <- err
+	}()
+
+	// Wait for command to complete. A process the .envrc left running in
+	// the background keeps fd 3 and the output pipes open; the dump was
+	// written before bash exited, so stop reading once pipeWaitDelay passes.
+	waitErr := cmd.Wait()
+	if errors.Is(waitErr, exec.ErrWaitDelay) {
+		waitErr = nil
+	}
+	_ = jsonReader.SetReadDeadline(time.Now().Add(pipeWaitDelay))
+	if err := <-jsonDone; err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
 		return nil, fmt.Errorf("read json output: %w", err)
 	}
+	e.log.Debugf("%s: bash finished in %s (%s)", rc.Path, time.Since(started).Round(time.Microsecond), cmd.ProcessState)
+
+	var capturedStderr string
+	if capture != nil {
+		capture.finish(rc.Path)
+		capturedStderr = capture.String()
+	}
 
-	// Wait for command to complete
-	if err := cmd.Wait(); err != nil {
+	if waitErr != nil {
 		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			// Include stdout in error message for debugging
-			if stdout.Len() > 0 {
-				return nil, fmt.Errorf("bash exited with status %d: %s", exitErr.ExitCode(), stdout.String())
+		if errors.As(waitErr, &exitErr) {
+			// Carry captured output in the error for debugging
+			return nil, &ExitError{
+				Path:     rc.Path,
+				ExitCode: exitErr.ExitCode(),
+				Stdout:   stdout.String(),
+				Stderr:   capturedStderr,
 			}
-			return nil, fmt.Errorf("bash exited with status %d", exitErr.ExitCode())
 		}
-		return nil, fmt.Errorf("wait bash: %w", err)
+		return nil, fmt.Errorf("wait bash: %w", waitErr)
 	}
 
 	// Parse JSON output
@@ -153,33 +347,148 @@ func (e *Evaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*Result, error) {
 		return nil, errors.New("no json output from bash")
 	}
 
-	envResult, err := ParseJSON(&jsonBuf)
+	envResult, fallback, err := ParseDump(jsonBuf.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("parse env output: %w", err)
 	}
+	if fallback != nil {
+		fmt.Fprintf(e.stderr, "cascade: warning: %s: environment dump JSON is unreadable (%v); recovered %d variables from its KEY=VALUE fallback\n",
+			rc.Path, fallback, len(envResult))
+	}
 
-	// Extract extra watches from CASCADE_EXTRA_WATCHES
+	// Extract extra watches from CASCADE_EXTRA_WATCHES. Relative paths and
+	// glob patterns are resolved against the .envrc's directory; patterns
+	// are kept as such and expanded whenever the watch list is recorded
+	// (see env.ExpandWatches), so files created later are picked up
 	var extraWatches []string
 	if watches, ok := envResult["CASCADE_EXTRA_WATCHES"]; ok {
 		for _, path := range strings.Split(watches, "\n") {
 			path = strings.TrimSpace(path)
-			if path != "" {
-				extraWatches = append(extraWatches, path)
+			if path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(rc.Dir, path)
 			}
+			extraWatches = append(extraWatches, path)
 		}
 		delete(envResult, "CASCADE_EXTRA_WATCHES") // Don't export this internal variable
 	}
 
+	// Extract directory watches from CASCADE_WATCH_DIRS; they are recorded
+	// as env.DirWatch paths among the extra watches
+	if dirs, ok := envResult["CASCADE_WATCH_DIRS"]; ok {
+		for _, dir := range strings.Split(dirs, "\n") {
+			dir = strings.TrimSpace(dir)
+			if dir == "" {
+				continue
+			}
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(rc.Dir, dir)
+			}
+			extraWatches = append(extraWatches, env.DirWatch(dir))
+		}
+		delete(envResult, "CASCADE_WATCH_DIRS") // Don't export this internal variable
+	}
+
+	// Extract list-merged variables from CASCADE_MERGE_VARS
+	var merge env.MergeSpec
+	if spec, ok := envResult["CASCADE_MERGE_VARS"]; ok {
+		merge = env.ParseMergeSpec(spec)
+		delete(envResult, "CASCADE_MERGE_VARS") // Don't export this internal variable
+	}
+
+	// Extract sensitive variables from CASCADE_SENSITIVE_VARS
+	var sensitive []string
+	if names, ok := envResult["CASCADE_SENSITIVE_VARS"]; ok {
+		for _, name := range strings.Split(names, "\n") {
+			if name != "" && !slices.Contains(sensitive, name) {
+				sensitive = append(sensitive, name)
+			}
+		}
+		delete(envResult, "CASCADE_SENSITIVE_VARS") // Don't export this internal variable
+	}
+
+	// Extract ancestors sourced by source_up from CASCADE_SOURCED_FILES.
+	// The variable is set whenever source_up ran, even if it found nothing.
+	var sourced []string
+	files, usedSourceUp := envResult["CASCADE_SOURCED_FILES"]
+	if usedSourceUp {
+		for _, path := range strings.Split(files, "\n") {
+			if path != "" {
+				sourced = append(sourced, path)
+			}
+		}
+		delete(envResult, "CASCADE_SOURCED_FILES") // Don't export this internal variable
+	}
+
+	// Extract files sourced by source_env from CASCADE_SOURCED_ENV, one
+	// "FROM<tab>PATH[<tab>cycle]" line each
+	var includes []Include
+	if lines, ok := envResult["CASCADE_SOURCED_ENV"]; ok {
+		for _, line := range strings.Split(lines, "\n") {
+			fields := strings.Split(line, "\t")
+			if len(fields) < 2 || fields[1] == "" {
+				continue
+			}
+			includes = append(includes, Include{
+				From:  fields[0],
+				Path:  fields[1],
+				Cycle: len(fields) > 2 && fields[2] == "cycle",
+			})
+		}
+		delete(envResult, "CASCADE_SOURCED_ENV") // Don't export this internal variable
+	}
+
+	// Extract commands registered with on_unload from CASCADE_UNLOAD_CMDS
+	var unload []string
+	if cmds, ok := envResult["CASCADE_UNLOAD_CMDS"]; ok {
+		for _, cmd := range strings.Split(cmds, "\n") {
+			if strings.TrimSpace(cmd) != "" {
+				unload = append(unload, cmd)
+			}
+		}
+		delete(envResult, "CASCADE_UNLOAD_CMDS") // Don't export this internal variable
+	}
+
 	result := &Result{
 		Env:          envResult,
 		ExtraWatches: extraWatches,
+		Merge:        merge,
+		Sensitive:    sensitive,
+		Sourced:      sourced,
+		Includes:     includes,
+		Unload:       unload,
 	}
 
-	// Store in cache
-	if e.cache != nil && cacheKey != "" {
+	// Store in cache, unless the .envrc set a variable excluded from caching.
+	// Sensitive declarations are inherited by later levels, so no result
+	// that could hold a sensitive value is cached. Neither is one that
+	// called source_up: the key covers only this .envrc, not its ancestors.
+	// A result recovered from a damaged dump is not kept either.
+	if e.cache != nil && !e.dryRun && cacheKey != "" && len(sensitive) == 0 && !usedSourceUp && fallback == nil && !e.cache.excludes(inputEnv, result.Env) {
 		// Ignore cache write errors - they're not fatal
 		_ = e.cache.Set(cacheKey, result, rc.Path)
 	}
 
 	return result, nil
 }
+
+// WriteSnapshot copies the verified content of an .envrc to a private
+// temporary file and returns its path. The caller removes it.
+func WriteSnapshot(content []byte) (string, error) {
+	f, err := os.CreateTemp("", "cascade-envrc-*")
+	if err != nil {
+		return "", fmt.Errorf("create snapshot: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("write snapshot: %w", err)
+	}
+	return f.Name(), nil
+}
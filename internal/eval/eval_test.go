@@ -2,6 +2,7 @@ package eval
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,6 +19,7 @@ set -euo pipefail
 
 __main__() {
     local envrc_file="${1:-}"
+    local source_file="${2:-$envrc_file}"
     if [[ -z "$envrc_file" ]]; then
         echo "no .envrc file specified" >&2
         exit 1
@@ -29,7 +31,7 @@ __main__() {
     export CASCADE_DIR
     CASCADE_DIR="$(cd "$(dirname "$envrc_file")" && pwd)"
     trap __dump_at_exit EXIT
-    source "$envrc_file"
+    source "$source_file"
 }
 
 __dump_at_exit() {
@@ -216,6 +218,131 @@ func TestEvaluate_NonExistentRC(t *testing.T) {
 	}
 }
 
+func TestEvaluate_RefusesFileChangedAfterApproval(t *testing.T) {
+	tmpDir := t.TempDir()
+	envrcPath := filepath.Join(tmpDir, ".envrc")
+	marker := filepath.Join(tmpDir, "swapped-ran")
+	if err := os.WriteFile(envrcPath, []byte(`export FOO="approved"`), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	// Swap the file after it was hashed, as a racing process would
+	swapped := "touch " + marker + "\nexport FOO=\"swapped\"\n"
+	if err := os.WriteFile(envrcPath+".new", []byte(swapped), 0o644); err != nil {
+		t.Fatalf("write swap: %v", err)
+	}
+	if err := os.Rename(envrcPath+".new", envrcPath); err != nil {
+		t.Fatalf("rename swap: %v", err)
+	}
+
+	eval, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	_, err = eval.Evaluate(rc, env.Env{"PATH": os.Getenv("PATH")})
+	if !errors.Is(err, envrc.ErrChanged) {
+		t.Fatalf("Evaluate() error = %v, want envrc.ErrChanged", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("swapped content was executed")
+	}
+}
+
+func TestEvaluate_SourcesApprovedSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	snapshots := t.TempDir()
+	t.Setenv("TMPDIR", snapshots)
+
+	envrcPath := filepath.Join(tmpDir, ".envrc")
+	content := "export FOO=\"bar baz\"\nexport DIR=\"$CASCADE_DIR\"\n"
+	if err := os.WriteFile(envrcPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("write .envrc: %v", err)
+	}
+
+	rc, err := envrc.NewRC(envrcPath)
+	if err != nil {
+		t.Fatalf("NewRC: %v", err)
+	}
+
+	eval, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	result, err := eval.Evaluate(rc, env.Env{"PATH": os.Getenv("PATH")})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if result.Env["FOO"] != "bar baz" {
+		t.Errorf("FOO = %q, want %q", result.Env["FOO"], "bar baz")
+	}
+	if result.Env["DIR"] != tmpDir {
+		t.Errorf("CASCADE_DIR = %q, want the .envrc directory %q", result.Env["DIR"], tmpDir)
+	}
+
+	// The private copy is removed once evaluation finishes
+	left, err := os.ReadDir(snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("snapshot left behind: %v", left)
+	}
+}
+
+func TestEvaluate_WindowsLineEndings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"bom", "\xEF\xBB\xBFexport FOO=bar\nexport BAR=baz\n"},
+		{"crlf", "export FOO=bar\r\nexport BAR=baz\r\n"},
+		{"mixed", "\xEF\xBB\xBFexport FOO=bar\r\nexport BAR=baz\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			envrcPath := filepath.Join(tmpDir, ".envrc")
+			if err := os.WriteFile(envrcPath, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("write .envrc: %v", err)
+			}
+
+			rc, err := envrc.NewRC(envrcPath)
+			if err != nil {
+				t.Fatalf("NewRC: %v", err)
+			}
+			// Approval covers the bytes on disk, not the normalized copy
+			if want := envrc.HashContent(envrcPath, []byte(tt.content)); rc.ContentHash != want {
+				t.Errorf("ContentHash = %s, want the hash of the raw content %s", rc.ContentHash, want)
+			}
+
+			var stderr bytes.Buffer
+			eval, err := New("", testStdlib, createMockCascadeBin(t, tmpDir))
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			eval = eval.WithStderr(&stderr, 0)
+
+			result, err := eval.Evaluate(rc, env.Env{"PATH": os.Getenv("PATH")})
+			if err != nil {
+				t.Fatalf("Evaluate: %v (stderr: %s)", err, stderr.String())
+			}
+			if result.Env["FOO"] != "bar" || result.Env["BAR"] != "baz" {
+				t.Errorf("FOO, BAR = %q, %q; want \"bar\", \"baz\"", result.Env["FOO"], result.Env["BAR"])
+			}
+			if strings.Contains(stderr.String(), "command not found") {
+				t.Errorf("stderr = %q", stderr.String())
+			}
+		})
+	}
+}
+
 func TestDumpJSON(t *testing.T) {
 	e := env.Env{
 		"FOO": "bar",
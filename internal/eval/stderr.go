@@ -0,0 +1,108 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// stderrCaptureBytes bounds each of the head and tail kept by stderrCapture.
+const stderrCaptureBytes = 8 << 10
+
+// stderrCapture receives an .envrc's stderr. It streams at most maxLines
+// lines to live and keeps a bounded copy (first and last stderrCaptureBytes)
+// for the error of a failed evaluation, so a noisy .envrc cannot flood every
+// prompt.
+type stderrCapture struct {
+	live     io.Writer
+	maxLines int
+
+	lines   int  // Complete lines seen
+	partial bool // Trailing bytes after the last newline
+
+	head    []byte
+	tail    []byte
+	dropped int // Bytes discarded between head and tail
+}
+
+func newStderrCapture(live io.Writer, maxLines int) *stderrCapture {
+	return &stderrCapture{live: live, maxLines: maxLines}
+}
+
+func (c *stderrCapture) Write(p []byte) (int, error) {
+	c.stream(p)
+	c.keep(p)
+	return len(p), nil
+}
+
+// stream forwards the part of p that falls within the first maxLines lines.
+func (c *stderrCapture) stream(p []byte) {
+	rest := p
+	for len(rest) > 0 {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			if c.lines < c.maxLines {
+				_, _ = c.live.Write(rest)
+			}
+			c.partial = true
+			return
+		}
+		if c.lines < c.maxLines {
+			_, _ = c.live.Write(rest[:i+1])
+		}
+		c.lines++
+		c.partial = false
+		rest = rest[i+1:]
+	}
+}
+
+// keep records p in the bounded head/tail buffers.
+func (c *stderrCapture) keep(p []byte) {
+	if room := stderrCaptureBytes - len(c.head); room > 0 {
+		n := min(room, len(p))
+		c.head = append(c.head, p[:n]...)
+		p = p[n:]
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	c.tail = append(c.tail, p...)
+	if over := len(c.tail) - stderrCaptureBytes; over > 0 {
+		c.dropped += over
+		c.tail = append(c.tail[:0], c.tail[over:]...)
+	}
+}
+
+// totalLines counts lines including an unterminated final line.
+func (c *stderrCapture) totalLines() int {
+	if c.partial {
+		return c.lines + 1
+	}
+	return c.lines
+}
+
+// suppressed returns the number of lines that were not streamed live.
+func (c *stderrCapture) suppressed() int {
+	return max(0, c.totalLines()-c.maxLines)
+}
+
+// finish terminates a cut-off line and reports suppressed lines on live.
+func (c *stderrCapture) finish(rcPath string) {
+	n := c.suppressed()
+	if n == 0 {
+		return
+	}
+	if c.partial && c.lines < c.maxLines {
+		_, _ = io.WriteString(c.live, "\n")
+	}
+	fmt.Fprintf(c.live, "cascade: … %d more lines of stderr from %s suppressed (set eval_stderr_lines = 0 to show all)\n", n, rcPath)
+}
+
+// String returns the captured output with a marker where bytes were dropped.
+func (c *stderrCapture) String() string {
+	if c.dropped == 0 {
+		return string(c.head) + string(c.tail)
+	}
+	return fmt.Sprintf("%s\n… [%d bytes truncated] …\n%s", c.head, c.dropped, c.tail)
+}
@@ -0,0 +1,101 @@
+// Package gitinfo answers simple questions about git work trees.
+// Every query runs git with a short timeout so it is safe on the prompt path.
+package gitinfo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds each git invocation.
+const DefaultTimeout = 500 * time.Millisecond
+
+// ErrNotRepo is returned when a path is not inside a git work tree.
+var ErrNotRepo = errors.New("not a git work tree")
+
+// OriginURL returns the URL of the "origin" remote for the work tree
+// containing dir. Returns ErrNotRepo if dir is not in a work tree.
+func OriginURL(ctx context.Context, dir string) (string, error) {
+	if _, err := git(ctx, dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		}
+		return "", ErrNotRepo
+	}
+
+	out, err := git(ctx, dir, "config", "--get", "remote.origin.url")
+	if err != nil {
+		return "", fmt.Errorf("no origin remote: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// IsClean reports whether the file at path is tracked and unmodified
+// relative to HEAD (neither staged nor unstaged changes).
+func IsClean(ctx context.Context, path string) (bool, error) {
+	dir, base := filepath.Split(path)
+
+	// Untracked (or ignored) files are never clean
+	if _, err := git(ctx, dir, "ls-files", "--error-unmatch", "--", base); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	out, err := git(ctx, dir, "status", "--porcelain", "--", base)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "", nil
+}
+
+// Tracked reports whether git tracks path, or any file under it if it is a
+// directory. A path outside any work tree, or anywhere git is not
+// installed, is not tracked.
+func Tracked(ctx context.Context, path string) (bool, error) {
+	dir, base := filepath.Split(path)
+	out, err := git(ctx, dir, "ls-files", "--", base)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return false, err
+		}
+		// Outside a work tree; anything else is an answer git failed to give
+		if _, repoErr := git(ctx, dir, "rev-parse", "--is-inside-work-tree"); repoErr != nil && !errors.Is(repoErr, context.DeadlineExceeded) {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// hardened are the options every git invocation starts with. The
+// repositories asked about are often fresh clones, whose config could
+// otherwise name an fsmonitor or hook program for git to run.
+var hardened = []string{"-c", "core.fsmonitor=", "-c", "core.hooksPath=/dev/null"}
+
+// git runs a git subcommand in dir, bounded by DefaultTimeout and with the
+// hardened options.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	argv := append(slices.Clone(hardened), "-C", dir)
+	cmd := exec.CommandContext(ctx, "git", append(argv, args...)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], ctx.Err())
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,132 @@
+// Package kv persists small values produced while evaluating .envrc files,
+// such as the output of the stdlib cache_output helper.
+//
+// Entries are keyed by the .envrc content hash and a caller-chosen key, so
+// editing an .envrc invalidates everything it stored. Each entry carries its
+// own expiry and lives independently of the evaluation cache.
+package kv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/xdg"
+)
+
+// entry is the on-disk format for a stored value.
+type entry struct {
+	Key     string    `json:"key"` // For debugging
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store holds values in a private directory, one JSON file per entry.
+type Store struct {
+	dir     string   // e.g., ~/.cache/cascade/kv/
+	exclude []string // Key patterns never written to disk
+}
+
+// NewStore creates a store using XDG_CACHE_HOME or ~/.cache/cascade/kv.
+func NewStore() (*Store, error) {
+	cacheDir, err := xdg.CacheHome()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStoreWithDir(filepath.Join(cacheDir, "cascade", "kv")), nil
+}
+
+// NewStoreWithDir creates a store with a custom directory (for testing).
+func NewStoreWithDir(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// WithExclude returns a copy of the Store that refuses to persist keys
+// matching any of the given patterns (see env.MatchAny).
+func (s *Store) WithExclude(patterns []string) *Store {
+	cp := *s
+	cp.exclude = patterns
+	return &cp
+}
+
+// Excluded reports whether values for key are never persisted.
+func (s *Store) Excluded(key string) bool {
+	return env.MatchAny(s.exclude, key)
+}
+
+// Get returns the value stored for key by the .envrc with the given content
+// hash. Missing, expired, and unreadable entries all report ok = false.
+func (s *Store) Get(rcHash, key string) (string, bool) {
+	data, err := os.ReadFile(s.entryPath(rcHash, key))
+	if err != nil {
+		return "", false
+	}
+
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return "", false
+	}
+	if e.Key != key || !time.Now().Before(e.Expires) {
+		return "", false
+	}
+
+	return e.Value, true
+}
+
+// Set stores value for key until ttl elapses. Excluded keys are silently
+// not stored, so callers simply recompute them next time.
+func (s *Store) Set(rcHash, key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid duration %s: must be positive", ttl)
+	}
+	if s.Excluded(key) {
+		return nil
+	}
+
+	data, err := json.Marshal(entry{Key: key, Value: value, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create kv directory: %w", err)
+	}
+
+	// Write atomically via temp file
+	path := s.entryPath(rcHash, key)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write entry: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("rename entry: %w", err)
+	}
+
+	return nil
+}
+
+// Clear removes all stored entries.
+func (s *Store) Clear() error {
+	if err := os.RemoveAll(s.dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove kv directory: %w", err)
+	}
+	return nil
+}
+
+// entryPath returns the file path for an entry.
+func (s *Store) entryPath(rcHash, key string) string {
+	h := sha256.New()
+	h.Write([]byte(rcHash))
+	h.Write([]byte("\x00"))
+	h.Write([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
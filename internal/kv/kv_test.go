@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_SetGet(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(t.TempDir())
+
+	if _, ok := store.Get("hash", "TOKEN"); ok {
+		t.Fatal("expected miss before Set")
+	}
+
+	if err := store.Set("hash", "TOKEN", "value\nwith newline", time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	got, ok := store.Get("hash", "TOKEN")
+	if !ok || got != "value\nwith newline" {
+		t.Errorf("Get = %q, %v, want stored value", got, ok)
+	}
+
+	// Entries are scoped to the .envrc content hash
+	if _, ok := store.Get("other-hash", "TOKEN"); ok {
+		t.Error("expected miss for a different content hash")
+	}
+}
+
+func TestStore_Expired(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(t.TempDir())
+	if err := store.Set("hash", "TOKEN", "value", time.Nanosecond); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, ok := store.Get("hash", "TOKEN"); ok {
+		t.Error("expected miss for an expired entry")
+	}
+}
+
+func TestStore_InvalidDuration(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(t.TempDir())
+	if err := store.Set("hash", "TOKEN", "value", 0); err == nil {
+		t.Error("expected error for non-positive duration")
+	}
+}
+
+func TestStore_Permissions(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(t.TempDir())
+	if err := store.Set("hash", "TOKEN", "value", time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	info, err := os.Stat(store.entryPath("hash", "TOKEN"))
+	if err != nil {
+		t.Fatalf("stat entry: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("entry permissions = %o, want 600", perm)
+	}
+}
+
+func TestStore_Exclude(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(filepath.Join(t.TempDir(), "kv")).WithExclude([]string{"*_SECRET"})
+	if err := store.Set("hash", "DB_SECRET", "value", time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if _, ok := store.Get("hash", "DB_SECRET"); ok {
+		t.Error("excluded key should not be stored")
+	}
+	if _, err := os.Stat(store.dir); !os.IsNotExist(err) {
+		t.Errorf("excluded key should not touch disk, stat err = %v", err)
+	}
+}
+
+func TestStore_Clear(t *testing.T) {
+	t.Parallel()
+
+	store := NewStoreWithDir(t.TempDir())
+	if err := store.Set("hash", "TOKEN", "value", time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	if _, ok := store.Get("hash", "TOKEN"); ok {
+		t.Error("expected miss after Clear")
+	}
+	// Clearing an empty store is not an error
+	if err := store.Clear(); err != nil {
+		t.Errorf("Clear (empty): %v", err)
+	}
+}
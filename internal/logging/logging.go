@@ -0,0 +1,88 @@
+// Package logging prints cascade's messages on stderr at a chosen level of
+// detail: errors always, then warnings, reminders such as files waiting to
+// be allowed, and debugging details of evaluation.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Level is how much a Logger prints. Each level includes the ones before
+// it.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// DefaultLevel is the level without a log_level setting.
+const DefaultLevel = LevelInfo
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+// ParseLevel returns the level called name: error, warn, info or debug.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want %s)", name, strings.Join(levelNames, ", "))
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Logger writes messages up to its level to w, each on its own line and
+// prefixed with "cascade: ". A nil *Logger prints nothing.
+type Logger struct {
+	w     io.Writer
+	level Level
+}
+
+// New returns a Logger that writes messages up to level to w.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{w: w, level: level}
+}
+
+// Enabled reports whether messages at level are printed.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level <= l.level
+}
+
+// Errorf prints an error, which is printed at every level.
+func (l *Logger) Errorf(format string, args ...any) {
+	l.logf(LevelError, "error: ", format, args)
+}
+
+// Warnf prints a warning.
+func (l *Logger) Warnf(format string, args ...any) {
+	l.logf(LevelWarn, "warning: ", format, args)
+}
+
+// Infof prints a reminder or notice.
+func (l *Logger) Infof(format string, args ...any) {
+	l.logf(LevelInfo, "", format, args)
+}
+
+// Debugf prints a detail for debugging.
+func (l *Logger) Debugf(format string, args ...any) {
+	l.logf(LevelDebug, "debug: ", format, args)
+}
+
+func (l *Logger) logf(level Level, prefix, format string, args []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	fmt.Fprintf(l.w, "cascade: %s%s\n", prefix, msg)
+}
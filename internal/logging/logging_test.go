@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want Level
+	}{
+		{"error", LevelError},
+		{"warn", LevelWarn},
+		{"INFO", LevelInfo},
+		{"debug", LevelDebug},
+	} {
+		got, err := ParseLevel(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) should fail")
+	}
+}
+
+func TestLogger_Levels(t *testing.T) {
+	for _, tt := range []struct {
+		level Level
+		want  string
+	}{
+		{LevelError, "cascade: error: e\n"},
+		{LevelWarn, "cascade: error: e\ncascade: warning: w\n"},
+		{LevelInfo, "cascade: error: e\ncascade: warning: w\ncascade: i\n"},
+		{LevelDebug, "cascade: error: e\ncascade: warning: w\ncascade: i\ncascade: debug: d\n"},
+	} {
+		var buf bytes.Buffer
+		l := New(&buf, tt.level)
+		l.Errorf("e")
+		l.Warnf("w\n") // A trailing newline is not doubled
+		l.Infof("%s", "i")
+		l.Debugf("d")
+		if buf.String() != tt.want {
+			t.Errorf("level %v printed %q, want %q", tt.level, buf.String(), tt.want)
+		}
+	}
+
+	var nilLogger *Logger
+	nilLogger.Errorf("nothing") // Must not panic
+	if nilLogger.Enabled(LevelError) {
+		t.Error("nil Logger should print nothing")
+	}
+}
@@ -0,0 +1,156 @@
+// Package policy reads team allow policies. A repository ships a
+// .cascade-policy.toml listing its .envrc files, relative to the policy's
+// directory, with the SHA256 of the content each is blessed at:
+//
+//	[[envrc]]
+//	path = "services/api/.envrc"
+//	sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//
+// A file whose content matches its entry is allowed without being allowed
+// one by one (see Resolver); a file that changed since is not. The policy
+// file itself is only followed once the user allowed it, like an .envrc,
+// with cascade allow; a policy edited since is ignored.
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// FileName is the name of a policy file.
+const FileName = ".cascade-policy.toml"
+
+// ErrNotAllowed is returned by Find for a policy file the user has not
+// allowed at its current content.
+var ErrNotAllowed = errors.New("not allowed")
+
+// Policy is a parsed policy file.
+type Policy struct {
+	File   string            // Path of the policy file
+	Dir    string            // Directory the entry paths are relative to
+	RC     *envrc.RC         // The policy file, hashed from the bytes that were parsed
+	hashes map[string]string // Absolute .envrc path to its expected SHA256
+}
+
+// document is the TOML layout of a policy file.
+type document struct {
+	Envrc []struct {
+		Path   string `toml:"path"`
+		SHA256 string `toml:"sha256"`
+	} `toml:"envrc"`
+}
+
+// Verdict is what a policy says about an .envrc.
+type Verdict int
+
+const (
+	Unlisted Verdict = iota // The policy has no entry for the file
+	Match                   // The file's content is the one the policy pins
+	Mismatch                // The file changed since the policy pinned it
+)
+
+// Hash returns the SHA256 a policy entry pins content at.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Parse parses the policy file file, whose entries are relative to dir.
+// Unknown keys, and paths that are absolute or leave dir, are errors.
+func Parse(file, dir string, data []byte) (*Policy, error) {
+	var doc document
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	p := &Policy{File: file, Dir: dir, hashes: make(map[string]string, len(doc.Envrc))}
+	for i, entry := range doc.Envrc {
+		rel := filepath.FromSlash(entry.Path)
+		if !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("%s: envrc %d: path %q is not relative to %s", file, i+1, entry.Path, dir)
+		}
+		hash := strings.ToLower(entry.SHA256)
+		if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+			return nil, fmt.Errorf("%s: envrc %d: sha256 %q is not a SHA256 hex digest", file, i+1, entry.SHA256)
+		}
+		p.hashes[filepath.Join(dir, rel)] = hash
+	}
+	return p, nil
+}
+
+// Load reads the policy file file, relative to its directory. Symlinks in
+// the directory are resolved, as they are in the paths of envrc.RC. The
+// file is read once: the content parsed is the content Policy.RC hashes.
+func Load(file string) (*Policy, error) {
+	rc, err := envrc.NewRC(file)
+	if err != nil {
+		return nil, err
+	}
+	if !rc.Exists {
+		return nil, fmt.Errorf("%s: %w", file, fs.ErrNotExist)
+	}
+	data, err := rc.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(rc.Path)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	p, err := Parse(file, dir, data)
+	if err != nil {
+		return nil, err
+	}
+	p.RC = rc
+	return p, nil
+}
+
+// Find loads the policy of a chain: the one in the topmost of dirs, which
+// are listed root first, that holds a policy file. It returns nil if none
+// does. A policy file auth does not allow at its current content is
+// ignored: Find returns nil and an error wrapping ErrNotAllowed.
+func Find(dirs []string, auth Authorizer, wl allow.Whitelister) (*Policy, error) {
+	for _, dir := range dirs {
+		p, err := Load(filepath.Join(dir, FileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status, _ := auth.Explain(p.RC, wl); status != allow.Allowed {
+			return nil, fmt.Errorf("%s is %w (review it, then run `cascade allow %s`)", p.File, ErrNotAllowed, p.File)
+		}
+		return p, nil
+	}
+	return nil, nil
+}
+
+// Check compares rc with its entry, using the digest rc was hashed with
+// rather than reading the file again. A nil Policy lists nothing.
+func (p *Policy) Check(rc *envrc.RC) Verdict {
+	if p == nil || !rc.Exists {
+		return Unlisted
+	}
+	want, ok := p.hashes[rc.Path]
+	if !ok {
+		return Unlisted
+	}
+	if rc.Digest != want {
+		return Mismatch
+	}
+	return Match
+}
@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// writeFile writes content to path, creating its directory.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newRC returns the RC of path.
+func newRC(t *testing.T, path string) *envrc.RC {
+	t.Helper()
+	rc, err := envrc.NewRC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+// policyEntry returns a policy file entry pinning path at content.
+func policyEntry(path, content string) string {
+	return "[[envrc]]\npath = \"" + path + "\"\nsha256 = \"" + Hash([]byte(content)) + "\"\n"
+}
+
+// tempDir returns a temporary directory with symlinks resolved, as the
+// paths of envrc.RC are.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestParse_Invalid(t *testing.T) {
+	hash := Hash([]byte("x"))
+	for _, tt := range []struct {
+		name, data, want string
+	}{
+		{"syntax", "[[envrc]\n", "parse"},
+		{"unknown key", "[[envrc]]\npath = \".envrc\"\nsha256 = \"" + hash + "\"\nowner = \"me\"\n", "parse"},
+		{"absolute path", "[[envrc]]\npath = \"/etc/.envrc\"\nsha256 = \"" + hash + "\"\n", "not relative"},
+		{"path leaving the directory", "[[envrc]]\npath = \"../other/.envrc\"\nsha256 = \"" + hash + "\"\n", "not relative"},
+		{"empty path", "[[envrc]]\nsha256 = \"" + hash + "\"\n", "not relative"},
+		{"short hash", "[[envrc]]\npath = \".envrc\"\nsha256 = \"abc\"\n", "not a SHA256"},
+		{"not hex", "[[envrc]]\npath = \".envrc\"\nsha256 = \"" + strings.Repeat("g", 64) + "\"\n", "not a SHA256"},
+	} {
+		_, err := Parse(FileName, "/repo", []byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse() error = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	repo := tempDir(t)
+	writeFile(t, filepath.Join(repo, ".envrc"), "export ROOT=1\n")
+	writeFile(t, filepath.Join(repo, "api", ".envrc"), "export API=2\n")
+	writeFile(t, filepath.Join(repo, "web", ".envrc"), "export WEB=3\n")
+	writeFile(t, filepath.Join(repo, FileName),
+		policyEntry(".envrc", "export ROOT=1\n")+
+			policyEntry("api/.envrc", "export API=1\n")+
+			// Upper case digests are accepted
+			strings.Replace(policyEntry("gone/.envrc", ""), "sha256 = \"e3", "sha256 = \"E3", 1))
+
+	p, err := Load(filepath.Join(repo, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]Verdict{
+		".envrc":      Match,
+		"api/.envrc":  Mismatch,
+		"web/.envrc":  Unlisted,
+		"gone/.envrc": Unlisted,
+	} {
+		if got := p.Check(newRC(t, filepath.Join(repo, path))); got != want {
+			t.Errorf("Check(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	// The file is compared as it was read for rc, not as it is now
+	rc := newRC(t, filepath.Join(repo, ".envrc"))
+	writeFile(t, rc.Path, "export ROOT=2\n")
+	if got := p.Check(rc); got != Match {
+		t.Errorf("Check() after the file changed = %v, want Match for the content rc hashed", got)
+	}
+
+	var none *Policy
+	if got := none.Check(newRC(t, filepath.Join(repo, ".envrc"))); got != Unlisted {
+		t.Errorf("nil Policy: Check() = %v, want Unlisted", got)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := tempDir(t)
+	store := allow.NewStoreWithBase(filepath.Join(tempDir(t), "store"))
+	repo := filepath.Join(root, "repo")
+	sub := filepath.Join(repo, "sub")
+	writeFile(t, filepath.Join(repo, FileName), "")
+	writeFile(t, filepath.Join(sub, FileName), "")
+	for _, dir := range []string{repo, sub} {
+		if err := store.Allow(newRC(t, filepath.Join(dir, FileName))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The topmost directory with a policy file wins
+	p, err := Find([]string{root, repo, sub}, store, nil)
+	if err != nil || p == nil || p.Dir != repo {
+		t.Errorf("Find() = %+v, %v; want the policy in %s", p, err, repo)
+	}
+
+	if p, err := Find([]string{root}, store, nil); p != nil || err != nil {
+		t.Errorf("Find() without a policy = %+v, %v; want nil", p, err)
+	}
+
+	// A policy edited since it was allowed is ignored, even with an allowed
+	// one below it
+	writeFile(t, filepath.Join(repo, FileName), policyEntry(".envrc", "export EVIL=1\n"))
+	if p, err := Find([]string{root, repo, sub}, store, nil); p != nil || !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Find() with an edited policy = %+v, %v; want nil and ErrNotAllowed", p, err)
+	}
+	if err := store.Allow(newRC(t, filepath.Join(repo, FileName))); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Find([]string{root, repo, sub}, store, nil); err != nil || p == nil || p.Dir != repo {
+		t.Errorf("Find() once allowed again = %+v, %v; want the policy in %s", p, err, repo)
+	}
+
+	// A whitelisted policy needs no allow
+	other := filepath.Join(root, "other")
+	writeFile(t, filepath.Join(other, FileName), "")
+	if p, err := Find([]string{other}, store, whitelist(other)); err != nil || p == nil {
+		t.Errorf("Find() with a whitelisted policy = %+v, %v; want it", p, err)
+	}
+
+	writeFile(t, filepath.Join(repo, FileName), "not toml [")
+	if _, err := Find([]string{root, repo, sub}, store, nil); err == nil || errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Find() with a broken policy file = %v, want a parse error", err)
+	}
+}
+
+type whitelist string
+
+func (w whitelist) IsWhitelisted(path string) bool {
+	return strings.HasPrefix(path, string(w))
+}
+
+// TestResolver checks the policy's place in the precedence: below every
+// deny and allow of the store, above not allowed.
+func TestResolver(t *testing.T) {
+	repo := tempDir(t)
+	store := allow.NewStoreWithBase(filepath.Join(tempDir(t), "store"))
+	files := map[string]string{
+		"pinned":    "export A=1\n",
+		"denied":    "export B=1\n",
+		"allowed":   "export C=1\n",
+		"trusted":   "export D=1\n",
+		"changed":   "export E=2\n",
+		"unlisted":  "export F=1\n",
+		"whitelist": "export G=1\n",
+	}
+	var doc strings.Builder
+	for dir, content := range files {
+		writeFile(t, filepath.Join(repo, dir, ".envrc"), content)
+		pinned := content
+		switch dir {
+		case "changed":
+			pinned = "export E=1\n"
+		case "unlisted":
+			continue
+		}
+		doc.WriteString(policyEntry(dir+"/.envrc", pinned))
+	}
+	writeFile(t, filepath.Join(repo, FileName), doc.String())
+	if err := store.Allow(newRC(t, filepath.Join(repo, FileName))); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Find([]string{repo}, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := func(dir string) *envrc.RC { return newRC(t, filepath.Join(repo, dir, ".envrc")) }
+	if err := store.Deny(rc("denied")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Allow(rc("allowed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TrustSubtree(filepath.Join(repo, "trusted")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := NewResolver(store, p)
+	wl := whitelist(filepath.Join(repo, "whitelist"))
+	for _, tt := range []struct {
+		dir      string
+		status   allow.AllowStatus
+		source   allow.Source
+		mismatch bool
+	}{
+		{"pinned", allow.Allowed, allow.SourcePolicy, false},
+		{"denied", allow.Denied, allow.SourceGlobal, false},
+		{"allowed", allow.Allowed, allow.SourceGlobal, false},
+		{"trusted", allow.Allowed, allow.SourceTrust, false},
+		{"whitelist", allow.Allowed, allow.SourceWhitelist, false},
+		{"changed", allow.NotAllowed, allow.SourceNone, true},
+		{"unlisted", allow.NotAllowed, allow.SourceNone, false},
+	} {
+		status, source := resolver.Explain(rc(tt.dir), wl)
+		if status != tt.status || source != tt.source {
+			t.Errorf("Explain(%s) = %v, %q; want %v, %q", tt.dir, status, source, tt.status, tt.source)
+		}
+		if got := resolver.Mismatch(rc(tt.dir), status); got != tt.mismatch {
+			t.Errorf("Mismatch(%s) = %v, want %v", tt.dir, got, tt.mismatch)
+		}
+	}
+
+	// Without a policy the store decides alone
+	if status, source := NewResolver(store, nil).Explain(rc("pinned"), nil); status != allow.NotAllowed || source != allow.SourceNone {
+		t.Errorf("nil policy: Explain(pinned) = %v, %q; want not allowed", status, source)
+	}
+}
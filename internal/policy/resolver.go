@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/envrc"
+)
+
+// Authorizer decides whether an .envrc may be evaluated. *allow.Store
+// implements it.
+type Authorizer interface {
+	Explain(rc *envrc.RC, wl allow.Whitelister) (allow.AllowStatus, allow.Source)
+}
+
+// Resolver composes an Authorizer with a Policy. The policy ranks below
+// every record of the base: a file the base denies stays denied, and one
+// it allows keeps its source. Only a file the base leaves not allowed is
+// allowed by a matching policy entry, with allow.SourcePolicy.
+type Resolver struct {
+	base   Authorizer
+	policy *Policy
+}
+
+// NewResolver returns a Resolver consulting policy after base. A nil
+// policy allows nothing.
+func NewResolver(base Authorizer, policy *Policy) *Resolver {
+	return &Resolver{base: base, policy: policy}
+}
+
+// Explain is base's Explain, falling back to the policy.
+func (r *Resolver) Explain(rc *envrc.RC, wl allow.Whitelister) (allow.AllowStatus, allow.Source) {
+	status, source := r.base.Explain(rc, wl)
+	if status == allow.NotAllowed && r.policy.Check(rc) == Match {
+		return allow.Allowed, allow.SourcePolicy
+	}
+	return status, source
+}
+
+// Mismatch reports whether rc is not allowed though the policy lists it,
+// because it changed since the policy pinned it.
+func (r *Resolver) Mismatch(rc *envrc.RC, status allow.AllowStatus) bool {
+	return status == allow.NotAllowed && r.policy.Check(rc) == Mismatch
+}
@@ -0,0 +1,236 @@
+// Package run discovers, authorizes, and evaluates an .envrc chain.
+//
+// It is the single engine behind export, tree, and which: callers build a
+// Plan for a directory, inspect the per-level authorization, and then Run
+// the allowed levels in order with the options they need.
+package run
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/eval"
+	"github.com/unrss/cascade/internal/policy"
+)
+
+// Evaluator executes a single .envrc file. *eval.Evaluator implements it.
+type Evaluator interface {
+	Evaluate(rc *envrc.RC, inputEnv env.Env) (*eval.Result, error)
+}
+
+// Authorizer decides whether an .envrc may be evaluated. *allow.Store implements it.
+type Authorizer interface {
+	Explain(rc *envrc.RC, wl allow.Whitelister) (allow.AllowStatus, allow.Source)
+}
+
+// Level is a single existing .envrc in the chain and its outcome.
+type Level struct {
+	RC     *envrc.RC
+	Status allow.AllowStatus
+	Source allow.Source
+
+	// PolicyMismatch is set for a level not allowed though the chain's
+	// policy lists it, because it changed since the policy pinned it.
+	PolicyMismatch bool
+
+	// Populated by Run for allowed levels.
+	Evaluated    bool           // True if evaluation succeeded
+	Before       env.Env        // Input environment (only with Options.CollectDiffs)
+	After        env.Env        // Resulting environment (only with Options.CollectDiffs)
+	ExtraWatches []string       // Files added via watch_file
+	Sourced      []string       // Ancestor files pulled in by source_up
+	Includes     []eval.Include // Files sourced with source_env
+	Unload       []string       // Commands registered with on_unload
+	Cached       bool           // True if the evaluator reused a cached result
+	Duration     time.Duration  // Time spent in the evaluator
+	Err          error          // Evaluation error, if any
+}
+
+// Plan is a discovered and authorized chain, ready for evaluation.
+type Plan struct {
+	Root   string      // Effective root (where the chain starts if Target is not under the configured root)
+	Target string      // Directory the chain ends at
+	Chain  []*envrc.RC // Every directory from Root to Target, including ones without an .envrc
+	Levels []*Level    // Existing .envrc files only, root first
+
+	// Skipped is the directory whose skip marker ended the chain early, or
+	// empty. It and every directory below it down to Target are left out.
+	Skipped string
+
+	// Policy is the policy file of the chain, or nil (see policy.Find).
+	// PolicyErr is set instead if it could not be read or is not allowed;
+	// the chain is then authorized without it.
+	Policy    *policy.Policy
+	PolicyErr error
+}
+
+// NewPlan finds the chain from root to target and checks each existing file.
+// If target is not under root, the chain starts where opts.OutsideRoot says:
+// at target itself by default (see envrc.FindRootedChain). The chain stops
+// at a directory containing envrc.SkipMarker or one of opts.Markers, and
+// levels opts leaves unsearched have no .envrc (see envrc.FindChainWith).
+// Files auth leaves not allowed are allowed by the chain's policy file, if
+// auth allows the policy file itself and it pins their content (see
+// policy.Resolver).
+func NewPlan(root, target string, opts envrc.ChainOptions, auth Authorizer, wl allow.Whitelister) (*Plan, error) {
+	plan := &Plan{Target: target}
+
+	chain, start, skipped, err := envrc.FindRootedChain(root, target, opts)
+	if err != nil {
+		return nil, fmt.Errorf("find envrc chain: %w", err)
+	}
+	plan.Root = start
+	plan.Chain = chain
+	plan.Skipped = skipped
+	plan.Policy, plan.PolicyErr = policy.Find(ChainDirs(chain), auth, wl)
+
+	resolver := policy.NewResolver(auth, plan.Policy)
+	for _, rc := range envrc.ExistingOnly(chain) {
+		status, source := resolver.Explain(rc, wl)
+		plan.Levels = append(plan.Levels, &Level{
+			RC:             rc,
+			Status:         status,
+			Source:         source,
+			PolicyMismatch: resolver.Mismatch(rc, status),
+		})
+	}
+
+	return plan, nil
+}
+
+// ChainDirs returns the directories of chain, root first, once each: a
+// directory contributing several files appears once.
+func ChainDirs(chain []*envrc.RC) []string {
+	var dirs []string
+	for _, rc := range chain {
+		if len(dirs) == 0 || dirs[len(dirs)-1] != rc.Dir {
+			dirs = append(dirs, rc.Dir)
+		}
+	}
+	return dirs
+}
+
+// Filter returns the levels with the given status, in chain order.
+func (p *Plan) Filter(status allow.AllowStatus) []*Level {
+	var levels []*Level
+	for _, l := range p.Levels {
+		if l.Status == status {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// Progress describes a level starting or finishing evaluation.
+type Progress struct {
+	Index int    // Position among the allowed levels (0-based)
+	Total int    // Number of allowed levels
+	Level *Level // The level being evaluated
+	Done  bool   // False when starting, true when finished
+}
+
+// Options controls how a Plan is evaluated.
+type Options struct {
+	// ContinueOnError keeps evaluating later levels after a failure,
+	// carrying the last good environment forward. When false, Run stops
+	// at the first failure and reports it in Result.Err.
+	ContinueOnError bool
+
+	// CollectDiffs records each level's input and output environment.
+	CollectDiffs bool
+
+	// Progress, if set, is called before and after each level.
+	Progress func(Progress)
+
+	// Optional, if set, reports levels whose failure never stops
+	// evaluation: the error is kept in Level.Err and later levels start
+	// from the environment before the failed one.
+	Optional func(*Level) bool
+}
+
+// Result is the outcome of evaluating a Plan.
+type Result struct {
+	Env          env.Env       // Final environment after all successful levels
+	ExtraWatches []string      // Extra watches from all successful levels
+	Merge        env.MergeSpec // List-merged variables declared by successful levels
+	Sensitive    []string      // Variables declared sensitive by successful levels, sorted
+	Last         *Level        // Deepest successfully evaluated level, nil if none
+	Failed       *Level        // Level that stopped evaluation when !ContinueOnError
+	Err          error         // Error from Failed
+}
+
+// Run evaluates the allowed levels of a Plan in order, starting from base.
+func Run(p *Plan, base env.Env, ev Evaluator, opts Options) *Result {
+	allowed := p.Filter(allow.Allowed)
+	result := &Result{Env: base}
+
+	for i, level := range allowed {
+		if opts.Progress != nil {
+			opts.Progress(Progress{Index: i, Total: len(allowed), Level: level})
+		}
+
+		start := time.Now()
+		out, err := ev.Evaluate(level.RC, sensitiveInput(result.Env, result.Sensitive))
+		level.Duration = time.Since(start)
+		level.Err = err
+		if err == nil {
+			// Merged variables accumulate contributions instead of being overwritten
+			result.Merge = result.Merge.With(out.Merge)
+			result.Sensitive = mergeNames(result.Sensitive, out.Sensitive)
+			after := result.Merge.Apply(result.Env, out.Env)
+
+			level.Evaluated = true
+			level.Cached = out.Cached
+			level.ExtraWatches = out.ExtraWatches
+			level.Sourced = out.Sourced
+			level.Includes = out.Includes
+			level.Unload = out.Unload
+			if opts.CollectDiffs {
+				level.Before = result.Env
+				level.After = after
+			}
+			result.Env = after
+			result.ExtraWatches = append(result.ExtraWatches, out.ExtraWatches...)
+			result.Last = level
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(Progress{Index: i, Total: len(allowed), Level: level, Done: true})
+		}
+
+		if err != nil && !opts.ContinueOnError && (opts.Optional == nil || !opts.Optional(level)) {
+			result.Failed = level
+			result.Err = err
+			return result
+		}
+	}
+
+	return result
+}
+
+// sensitiveInput returns in with the sensitive declarations of earlier
+// levels passed down in CASCADE_SENSITIVE_VARS, so sensitive_env in later
+// levels extends them and the evaluator knows not to cache the result.
+func sensitiveInput(in env.Env, sensitive []string) env.Env {
+	if len(sensitive) == 0 {
+		return in
+	}
+	cp := in.Copy()
+	cp["CASCADE_SENSITIVE_VARS"] = strings.Join(sensitive, "\n")
+	return cp
+}
+
+// mergeNames returns the sorted union of names and more.
+func mergeNames(names, more []string) []string {
+	if len(more) == 0 {
+		return names
+	}
+	merged := append(slices.Clone(names), more...)
+	slices.Sort(merged)
+	return slices.Compact(merged)
+}
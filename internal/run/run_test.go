@@ -0,0 +1,465 @@
+package run
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/unrss/cascade/internal/allow"
+	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/envrc"
+	"github.com/unrss/cascade/internal/eval"
+)
+
+// fakeEvaluator applies fixed variables per RC without spawning bash.
+// Each RC path maps to the variables it sets, or to an error.
+type fakeEvaluator struct {
+	sets      map[string]map[string]string
+	appends   map[string]map[string]string // Appended as "$VAR,value"
+	merges    map[string]env.MergeSpec
+	sensitive map[string][]string
+	fails     map[string]error
+	calls     []string
+	inputs    map[string]env.Env
+}
+
+func (f *fakeEvaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*eval.Result, error) {
+	f.calls = append(f.calls, rc.Path)
+	if f.inputs != nil {
+		f.inputs[rc.Path] = inputEnv
+	}
+	if err := f.fails[rc.Path]; err != nil {
+		return nil, err
+	}
+	out := inputEnv.Copy()
+	if out == nil {
+		out = make(env.Env)
+	}
+	delete(out, "CASCADE_SENSITIVE_VARS") // Consumed like the real evaluator does
+	for k, v := range f.sets[rc.Path] {
+		out[k] = v
+	}
+	for k, v := range f.appends[rc.Path] {
+		out[k] = out[k] + "," + v
+	}
+	return &eval.Result{Env: out, ExtraWatches: []string{rc.Path + ".watch"}, Merge: f.merges[rc.Path], Sensitive: f.sensitive[rc.Path]}, nil
+}
+
+// fakeAuthorizer returns a fixed status per path, NotAllowed by default.
+type fakeAuthorizer map[string]allow.AllowStatus
+
+func (f fakeAuthorizer) Explain(rc *envrc.RC, _ allow.Whitelister) (allow.AllowStatus, allow.Source) {
+	if status, ok := f[rc.Path]; ok {
+		return status, allow.SourceGlobal
+	}
+	return allow.NotAllowed, allow.SourceNone
+}
+
+// setupChain creates root/.envrc, root/a/.envrc, root/a/b/.envrc and
+// returns the resolved root and the three .envrc paths.
+func setupChain(t *testing.T) (string, []string) {
+	t.Helper()
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("eval symlinks: %v", err)
+	}
+
+	dirs := []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b")}
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		paths[i] = filepath.Join(dir, ".envrc")
+		if err := os.WriteFile(paths[i], []byte("# test"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	return root, paths
+}
+
+func TestNewPlan_ClassifiesLevels(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Denied}
+
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	if plan.Root != root {
+		t.Errorf("Root = %q, want %q", plan.Root, root)
+	}
+	if len(plan.Chain) != 3 || len(plan.Levels) != 3 {
+		t.Fatalf("len(Chain), len(Levels) = %d, %d, want 3, 3", len(plan.Chain), len(plan.Levels))
+	}
+
+	want := []allow.AllowStatus{allow.Allowed, allow.Denied, allow.NotAllowed}
+	for i, level := range plan.Levels {
+		if level.Status != want[i] {
+			t.Errorf("Levels[%d].Status = %v, want %v", i, level.Status, want[i])
+		}
+	}
+
+	if got := plan.Filter(allow.Denied); len(got) != 1 || got[0].RC.Path != paths[1] {
+		t.Errorf("Filter(Denied) = %v, want [%s]", got, paths[1])
+	}
+}
+
+func TestNewPlan_TargetOutsideRoot(t *testing.T) {
+	t.Parallel()
+
+	root, _ := setupChain(t)
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("eval symlinks: %v", err)
+	}
+
+	plan, err := NewPlan(root, outside, envrc.ChainOptions{}, fakeAuthorizer{}, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	if plan.Root != outside {
+		t.Errorf("Root = %q, want fallback to target %q", plan.Root, outside)
+	}
+	if len(plan.Chain) != 1 || len(plan.Levels) != 0 {
+		t.Errorf("len(Chain), len(Levels) = %d, %d, want 1, 0", len(plan.Chain), len(plan.Levels))
+	}
+
+	// With walk-up, the chain starts above target
+	inner := filepath.Join(outside, "a")
+	if err := os.Mkdir(inner, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = NewPlan(root, inner, envrc.ChainOptions{OutsideRoot: envrc.OutsideRootWalkUp, MaxWalkDepth: 1}, fakeAuthorizer{}, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+	if plan.Root != outside || len(plan.Chain) != 2 {
+		t.Errorf("Root = %q, len(Chain) = %d, want %q, 2", plan.Root, len(plan.Chain), outside)
+	}
+}
+
+func TestRun_AccumulatesAllowedLevels(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.NotAllowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	ev := &fakeEvaluator{sets: map[string]map[string]string{
+		paths[0]: {"A": "root", "B": "root"},
+		paths[2]: {"B": "leaf"},
+	}}
+
+	result := Run(plan, env.Env{"BASE": "1"}, ev, Options{CollectDiffs: true})
+	if result.Err != nil {
+		t.Fatalf("Run: %v", result.Err)
+	}
+
+	if len(ev.calls) != 2 {
+		t.Errorf("evaluated %v, want only the allowed levels", ev.calls)
+	}
+
+	want := env.Env{"BASE": "1", "A": "root", "B": "leaf"}
+	for k, v := range want {
+		if result.Env[k] != v {
+			t.Errorf("Env[%s] = %q, want %q", k, result.Env[k], v)
+		}
+	}
+
+	if result.Last == nil || result.Last.RC.Path != paths[2] {
+		t.Errorf("Last = %v, want %s", result.Last, paths[2])
+	}
+	if len(result.ExtraWatches) != 2 {
+		t.Errorf("ExtraWatches = %v, want 2 entries", result.ExtraWatches)
+	}
+
+	leaf := plan.Levels[2]
+	if leaf.Before["B"] != "root" || leaf.After["B"] != "leaf" {
+		t.Errorf("leaf Before/After B = %q/%q, want root/leaf", leaf.Before["B"], leaf.After["B"])
+	}
+}
+
+func TestRun_StopsOnError(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Allowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	boom := errors.New("boom")
+	ev := &fakeEvaluator{fails: map[string]error{paths[1]: boom}}
+
+	result := Run(plan, env.Env{}, ev, Options{})
+	if !errors.Is(result.Err, boom) {
+		t.Fatalf("Err = %v, want %v", result.Err, boom)
+	}
+	if result.Failed == nil || result.Failed.RC.Path != paths[1] {
+		t.Errorf("Failed = %v, want %s", result.Failed, paths[1])
+	}
+	if len(ev.calls) != 2 {
+		t.Errorf("evaluated %v, want to stop after the failure", ev.calls)
+	}
+}
+
+func TestRun_ContinueOnError(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Allowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	ev := &fakeEvaluator{
+		sets:  map[string]map[string]string{paths[0]: {"A": "1"}, paths[2]: {"C": "3"}},
+		fails: map[string]error{paths[1]: errors.New("boom")},
+	}
+
+	var events []Progress
+	result := Run(plan, env.Env{}, ev, Options{
+		ContinueOnError: true,
+		Progress:        func(p Progress) { events = append(events, p) },
+	})
+
+	if result.Err != nil {
+		t.Errorf("Err = %v, want nil with ContinueOnError", result.Err)
+	}
+	if result.Env["A"] != "1" || result.Env["C"] != "3" {
+		t.Errorf("Env = %v, want A=1 and C=3", result.Env)
+	}
+	if plan.Levels[1].Err == nil || plan.Levels[1].Evaluated {
+		t.Errorf("middle level Err/Evaluated = %v/%v, want error recorded", plan.Levels[1].Err, plan.Levels[1].Evaluated)
+	}
+
+	// One start and one finish event per allowed level, in order
+	if len(events) != 6 {
+		t.Fatalf("got %d progress events, want 6", len(events))
+	}
+	for i, p := range events {
+		if p.Index != i/2 || p.Total != 3 || p.Done != (i%2 == 1) {
+			t.Errorf("events[%d] = {Index:%d Total:%d Done:%v}", i, p.Index, p.Total, p.Done)
+		}
+	}
+}
+
+func TestRun_OptionalLevel(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Allowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	boom := errors.New("boom")
+	ev := &fakeEvaluator{
+		sets:  map[string]map[string]string{paths[0]: {"ROOT": "1"}, paths[2]: {"C": "3"}},
+		fails: map[string]error{paths[0]: boom, paths[1]: boom},
+	}
+	rootOnly := func(l *Level) bool { return l.RC.Path == paths[0] }
+
+	// The optional root failing does not stop the chain; the middle level
+	// failing still does
+	result := Run(plan, env.Env{"BASE": "x"}, ev, Options{Optional: rootOnly})
+	if result.Failed == nil || result.Failed.RC.Path != paths[1] {
+		t.Fatalf("Failed = %v, want %s", result.Failed, paths[1])
+	}
+	if !errors.Is(plan.Levels[0].Err, boom) || plan.Levels[0].Evaluated {
+		t.Errorf("root level Err/Evaluated = %v/%v, want the error recorded", plan.Levels[0].Err, plan.Levels[0].Evaluated)
+	}
+
+	delete(ev.fails, paths[1])
+	result = Run(plan, env.Env{"BASE": "x"}, ev, Options{Optional: rootOnly})
+	if result.Err != nil {
+		t.Fatalf("Err = %v, want nil when only the optional level fails", result.Err)
+	}
+	if result.Env["BASE"] != "x" || result.Env["C"] != "3" || result.Env["ROOT"] != "" {
+		t.Errorf("Env = %v, want BASE and C without ROOT", result.Env)
+	}
+	if result.Last == nil || result.Last.RC.Path != paths[2] {
+		t.Errorf("Last = %v, want %s", result.Last, paths[2])
+	}
+}
+
+func TestRun_SensitiveVariables(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Allowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	ev := &fakeEvaluator{
+		sets:      map[string]map[string]string{paths[0]: {"TOKEN": "s3cret"}, paths[2]: {"KEY": "k"}},
+		sensitive: map[string][]string{paths[0]: {"TOKEN"}, paths[2]: {"KEY", "TOKEN"}},
+		inputs:    make(map[string]env.Env),
+	}
+	result := Run(plan, env.Env{"BASE": "x"}, ev, Options{})
+	if result.Err != nil {
+		t.Fatalf("Run: %v", result.Err)
+	}
+
+	if got, want := result.Sensitive, []string{"KEY", "TOKEN"}; !slices.Equal(got, want) {
+		t.Errorf("Sensitive = %q, want %q", got, want)
+	}
+	if _, ok := ev.inputs[paths[0]]["CASCADE_SENSITIVE_VARS"]; ok {
+		t.Error("first level received CASCADE_SENSITIVE_VARS before any declaration")
+	}
+	for _, path := range paths[1:] {
+		if got := ev.inputs[path]["CASCADE_SENSITIVE_VARS"]; got != "TOKEN" {
+			t.Errorf("%s received CASCADE_SENSITIVE_VARS=%q, want TOKEN", path, got)
+		}
+	}
+	if result.Env["TOKEN"] != "s3cret" {
+		t.Errorf("TOKEN = %q, want the value applied", result.Env["TOKEN"])
+	}
+}
+
+func TestRun_MergedVariables(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupChain(t)
+	// Middle level is skipped; the root declares the merge for the rest of the chain
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.NotAllowed, paths[2]: allow.Allowed}
+	plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+	if err != nil {
+		t.Fatalf("NewPlan: %v", err)
+	}
+
+	ev := &fakeEvaluator{
+		appends: map[string]map[string]string{
+			paths[0]: {"FLAGS": "a,b"},
+			paths[1]: {"FLAGS": "b,c"},
+			paths[2]: {"FLAGS": "b,d,ambient"},
+		},
+		merges: map[string]env.MergeSpec{paths[0]: {"FLAGS": ","}},
+	}
+
+	ambient := env.Env{"FLAGS": "ambient"}
+	result := Run(plan, ambient, ev, Options{CollectDiffs: true})
+	if result.Err != nil {
+		t.Fatalf("Run: %v", result.Err)
+	}
+
+	if got := result.Env["FLAGS"]; got != "ambient,a,b,d" {
+		t.Errorf("FLAGS = %q, want %q (deduplicated, in chain order)", got, "ambient,a,b,d")
+	}
+	if result.Merge["FLAGS"] != "," {
+		t.Errorf("Merge = %v, want FLAGS=,", result.Merge)
+	}
+	if leaf := plan.Levels[2]; leaf.After["FLAGS"] != "ambient,a,b,d" {
+		t.Errorf("leaf After FLAGS = %q, want merged value", leaf.After["FLAGS"])
+	}
+
+	// Reverting the chain restores the ambient value untouched
+	diff := env.BuildEnvDiff(ambient, result.Env)
+	diff.Merge = result.Merge.Subset(diff.Next)
+	if got := diff.Reverse().Patch(result.Env); got["FLAGS"] != "ambient" {
+		t.Errorf("reverted FLAGS = %q, want %q", got["FLAGS"], "ambient")
+	}
+}
+
+// cachingEvaluator serves results from an eval.Cache keyed the way
+// eval.Evaluator keys it, falling back to next on a miss.
+type cachingEvaluator struct {
+	next  Evaluator
+	cache *eval.Cache
+}
+
+func (c *cachingEvaluator) Evaluate(rc *envrc.RC, inputEnv env.Env) (*eval.Result, error) {
+	key := eval.CacheKey(rc, inputEnv)
+	if cached, ok := c.cache.Get(key, rc.Path); ok {
+		return cached, nil
+	}
+	result, err := c.next.Evaluate(rc, inputEnv)
+	if err == nil {
+		_ = c.cache.Set(key, result, rc.Path)
+	}
+	return result, err
+}
+
+func TestRun_CachedLevelsRequireUnchangedUpstream(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	root, paths := setupChain(t)
+	auth := fakeAuthorizer{paths[0]: allow.Allowed, paths[1]: allow.Allowed, paths[2]: allow.Allowed}
+	cache, err := eval.NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	fake := &fakeEvaluator{appends: map[string]map[string]string{
+		paths[0]: {"X": "root"},
+		paths[1]: {"X": "a"},
+		paths[2]: {"X": "b"},
+	}}
+	ev := &cachingEvaluator{next: fake, cache: cache}
+
+	// run re-plans (picking up content changes) and returns which levels
+	// were evaluated rather than served from the cache
+	run := func() (*Result, []string) {
+		t.Helper()
+		plan, err := NewPlan(root, filepath.Join(root, "a", "b"), envrc.ChainOptions{}, auth, nil)
+		if err != nil {
+			t.Fatalf("NewPlan: %v", err)
+		}
+		fake.calls = nil
+		result := Run(plan, env.Env{"X": "base"}, ev, Options{CollectDiffs: true})
+		for i, level := range plan.Levels {
+			if level.Cached == slices.Contains(fake.calls, paths[i]) {
+				t.Errorf("level %d: Cached = %v, but evaluated = %v", i, level.Cached, !level.Cached)
+			}
+		}
+		return result, fake.calls
+	}
+	edit := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("# edited "+path), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if _, calls := run(); len(calls) != 3 {
+		t.Fatalf("first run evaluated %v, want all levels", calls)
+	}
+	if _, calls := run(); len(calls) != 0 {
+		t.Errorf("second run evaluated %v, want all cached", calls)
+	}
+
+	// Editing the leaf only misses the leaf
+	edit(paths[2])
+	if _, calls := run(); !slices.Equal(calls, paths[2:]) {
+		t.Errorf("after leaf edit evaluated %v, want %v", calls, paths[2:])
+	}
+
+	// Changing the middle level's output invalidates everything below it,
+	// even though the leaf's own content is unchanged
+	edit(paths[1])
+	fake.appends[paths[1]] = map[string]string{"X": "a2"}
+	result, calls := run()
+	if !slices.Equal(calls, paths[1:]) {
+		t.Errorf("after middle edit evaluated %v, want %v", calls, paths[1:])
+	}
+	if got := result.Env["X"]; got != "base,root,a2,b" {
+		t.Errorf("X = %q, want %q", got, "base,root,a2,b")
+	}
+}
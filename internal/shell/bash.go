@@ -16,10 +16,10 @@ var Bash Shell = &bashShell{}
 // bashHookTemplate is the template for the bash hook.
 // It preserves exit status, traps SIGINT during eval, and handles
 // PROMPT_COMMAND as both string and array.
-const bashHookTemplate = `_cascade_hook() {
+const bashHookTemplate = `{{.Marker}}_cascade_hook() {
   local previous_exit_status=$?;
   trap -- '' SIGINT;
-  eval "$("{{.SelfPath}}" export bash)";
+  eval "$({{if .ResolvePath}}"$(type -P cascade || echo "{{.SelfPath}}")"{{else}}"{{.SelfPath}}"{{end}} export bash)";
   trap - SIGINT;
   return $previous_exit_status;
 };
@@ -38,16 +38,18 @@ func (b *bashShell) Name() string {
 	return "bash"
 }
 
-func (b *bashShell) Hook(selfPath string) string {
+func (b *bashShell) Hook(opts HookOptions) string {
 	var buf bytes.Buffer
 	data := struct {
-		SelfPath string
+		HookOptions
+		Marker string
 	}{
-		SelfPath: selfPath,
+		HookOptions: opts,
+		Marker:      versionMarker(b, opts),
 	}
 	// Template is validated at init time, so this cannot fail.
 	_ = bashHookTmpl.Execute(&buf, data)
-	return buf.String()
+	return guardHook("bash", buf.String())
 }
 
 func (b *bashShell) Export(e ShellExport) string {
@@ -68,13 +70,26 @@ func (b *bashShell) Export(e ShellExport) string {
 		if value == nil {
 			fmt.Fprintf(&sb, "unset %s;\n", key)
 		} else {
-			fmt.Fprintf(&sb, "export %s=\"%s\";\n", key, BashEscape(*value))
+			fmt.Fprintf(&sb, "export %s=%s;\n", key, BashQuote(*value))
 		}
 	}
 
 	return sb.String()
 }
 
+func (b *bashShell) Wrap(script string) string {
+	return wrapPosix(script)
+}
+
+// wrapPosix implements Wrap for bash and zsh. The function is removed once
+// called so it does not linger among the user's functions.
+func wrapPosix(script string) string {
+	if script == "" {
+		return ""
+	}
+	return applyFunc + "() {\n" + script + "};\n" + applyFunc + "; unset -f " + applyFunc + ";\n"
+}
+
 func (b *bashShell) Dump(env map[string]string) string {
 	if len(env) == 0 {
 		return ""
@@ -89,7 +104,7 @@ func (b *bashShell) Dump(env map[string]string) string {
 
 	var sb strings.Builder
 	for _, key := range keys {
-		fmt.Fprintf(&sb, "export %s=\"%s\";\n", key, BashEscape(env[key]))
+		fmt.Fprintf(&sb, "export %s=%s;\n", key, BashQuote(env[key]))
 	}
 
 	return sb.String()
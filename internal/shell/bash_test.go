@@ -1,6 +1,8 @@
 package shell
 
 import (
+	"os"
+	"os/exec"
 	"strings"
 	"testing"
 )
@@ -12,7 +14,7 @@ func TestBashName(t *testing.T) {
 }
 
 func TestBashHook(t *testing.T) {
-	hook := Bash.Hook("/usr/local/bin/cascade")
+	hook := hookBody(t, Bash.Hook(HookOptions{SelfPath: "/usr/local/bin/cascade", Version: "1.2.3"}))
 
 	t.Run("contains _cascade_hook function", func(t *testing.T) {
 		if !strings.Contains(hook, "_cascade_hook()") {
@@ -49,6 +51,59 @@ func TestBashHook(t *testing.T) {
 			t.Error("hook should restore SIGINT trap after eval")
 		}
 	})
+
+	t.Run("exports hook version", func(t *testing.T) {
+		if !strings.Contains(hook, `export CASCADE_HOOK_VERSION=`) || !strings.Contains(hook, "1.2.3") {
+			t.Error("hook should export CASCADE_HOOK_VERSION")
+		}
+	})
+
+	t.Run("does not look cascade up", func(t *testing.T) {
+		if strings.Contains(hook, "type -P cascade") {
+			t.Error("hook without ResolvePath should run selfPath directly")
+		}
+	})
+}
+
+// TestBashHook_ResolvePath runs a ResolvePath hook with a cascade on PATH
+// that differs from SelfPath and checks the hook uses it.
+func TestBashHook_ResolvePath(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	bin := t.TempDir()
+	fake := "#!/bin/sh\necho \"export RAN=$0\"\n"
+	if err := os.WriteFile(bin+"/cascade", []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Bash.Hook(HookOptions{SelfPath: "/nonexistent/cascade", Version: "1.2.3", ResolvePath: true})
+	script := hook + `_cascade_hook
+printf '%s|%s' "$RAN" "${CASCADE_HOOK_VERSION-unset}"`
+	cmd := exec.Command(bash, "--norc", "--noprofile", "-c", script)
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin", "CASCADE_HOOK_VERSION=0.9.0"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bash: %v", err)
+	}
+	if want := bin + "/cascade|unset"; string(out) != want {
+		t.Errorf("hook ran %q, want %q", out, want)
+	}
+
+	// Without cascade on PATH, the hook falls back to SelfPath
+	hook = Bash.Hook(HookOptions{SelfPath: bin + "/cascade", Version: "1.2.3", ResolvePath: true})
+	cmd = exec.Command(bash, "--norc", "--noprofile", "-c", hook+`_cascade_hook
+printf '%s' "$RAN"`)
+	cmd.Env = []string{"PATH=/usr/bin:/bin"}
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("bash: %v", err)
+	}
+	if want := bin + "/cascade"; string(out) != want {
+		t.Errorf("hook without cascade on PATH ran %q, want %q", out, want)
+	}
 }
 
 func TestBashExport(t *testing.T) {
@@ -70,7 +125,7 @@ func TestBashExport(t *testing.T) {
 				e.Set("FOO", "bar")
 				return e
 			}(),
-			contains: []string{`export FOO="bar";`},
+			contains: []string{`export FOO='bar';`},
 		},
 		{
 			name: "unset single variable",
@@ -91,9 +146,9 @@ func TestBashExport(t *testing.T) {
 				return e
 			}(),
 			contains: []string{
-				`export PATH="/usr/bin";`,
+				`export PATH='/usr/bin';`,
 				`unset OLD_VAR;`,
-				`export HOME="/home/user";`,
+				`export HOME='/home/user';`,
 			},
 		},
 		{
@@ -103,7 +158,7 @@ func TestBashExport(t *testing.T) {
 				e.Set("MSG", `hello "world" $HOME`)
 				return e
 			}(),
-			contains: []string{`export MSG="hello \"world\" \$HOME";`},
+			contains: []string{`export MSG='hello "world" $HOME';`},
 		},
 	}
 
@@ -158,7 +213,7 @@ func TestBashDump(t *testing.T) {
 			env: map[string]string{
 				"FOO": "bar",
 			},
-			contains: []string{`export FOO="bar";`},
+			contains: []string{`export FOO='bar';`},
 		},
 		{
 			name: "multiple variables",
@@ -167,8 +222,8 @@ func TestBashDump(t *testing.T) {
 				"HOME": "/home/user",
 			},
 			contains: []string{
-				`export PATH="/usr/bin";`,
-				`export HOME="/home/user";`,
+				`export PATH='/usr/bin';`,
+				`export HOME='/home/user';`,
 			},
 		},
 	}
@@ -204,7 +259,7 @@ func TestBashDumpDeterministic(t *testing.T) {
 	}
 }
 
-func TestBashEscape(t *testing.T) {
+func TestBashQuote(t *testing.T) {
 	tests := []struct {
 		name  string
 		input string
@@ -213,70 +268,155 @@ func TestBashEscape(t *testing.T) {
 		{
 			name:  "simple string",
 			input: "hello",
-			want:  "hello",
+			want:  `'hello'`,
 		},
 		{
 			name:  "double quotes",
 			input: `say "hello"`,
-			want:  `say \"hello\"`,
+			want:  `'say "hello"'`,
 		},
 		{
 			name:  "backslash",
 			input: `path\to\file`,
-			want:  `path\\to\\file`,
+			want:  `'path\to\file'`,
 		},
 		{
 			name:  "dollar sign",
 			input: "$HOME/bin",
-			want:  `\$HOME/bin`,
+			want:  `'$HOME/bin'`,
 		},
 		{
 			name:  "backtick",
 			input: "echo `date`",
-			want:  "echo \\`date\\`",
+			want:  "'echo `date`'",
 		},
 		{
-			name:  "newline",
-			input: "line1\nline2",
-			want:  `line1\nline2`,
+			name:  "history expansion",
+			input: "hunter2!!",
+			want:  `'hunter2!!'`,
 		},
 		{
-			name:  "carriage return",
-			input: "line1\rline2",
-			want:  `line1\rline2`,
+			name:  "newline",
+			input: "line1\nline2",
+			want:  "'line1\nline2'",
 		},
 		{
-			name:  "tab",
-			input: "col1\tcol2",
-			want:  `col1\tcol2`,
+			name:  "single quote",
+			input: "it's",
+			want:  `'it'\''s'`,
 		},
 		{
-			name:  "combined special chars",
-			input: "echo \"$HOME\"\n`date`",
-			want:  `echo \"\$HOME\"\n\` + "`date\\`",
+			name:  "leading and repeated single quotes",
+			input: "''x'",
+			want:  `\'\''x'\'`,
 		},
 		{
 			name:  "empty string",
 			input: "",
-			want:  "",
+			want:  `''`,
 		},
 		{
 			name:  "unicode",
 			input: "héllo wörld 日本語",
-			want:  "héllo wörld 日本語",
+			want:  "'héllo wörld 日本語'",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := BashEscape(tt.input)
+			got := BashQuote(tt.input)
 			if got != tt.want {
-				t.Errorf("BashEscape(%q) = %q, want %q", tt.input, got, tt.want)
+				t.Errorf("BashQuote(%q) = %q, want %q", tt.input, got, tt.want)
 			}
 		})
 	}
 }
 
+// TestBashWrap_Truncated evals every prefix of wrapped export output, as a
+// shell reads it when export is killed mid-write, and checks that each
+// applies all of the changes or none.
+func TestBashWrap_Truncated(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	e := make(ShellExport)
+	e.Set("A", "first")
+	e.Set("B", "it's\nsecond")
+	e.Unset("C")
+	script := Bash.Wrap(Bash.Export(e))
+	if !strings.HasSuffix(script, "\n_cascade_apply; unset -f _cascade_apply;\n") {
+		t.Fatalf("Wrap() does not end with the call:\n%s", script)
+	}
+
+	// One subshell per prefix, all in one bash
+	apply := `for s in "$@"; do (eval "$s" 2>/dev/null; printf '%s|%s|%s|%s\0' "${A-unset}" "${B-unset}" "${C-unset}" "$(type -t _cascade_apply)"); done`
+	args := []string{"--norc", "--noprofile", "-c", apply, "bash"}
+	for n := 0; n <= len(script); n++ {
+		args = append(args, script[:n])
+	}
+	cmd := exec.Command(bash, args...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "C=old"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bash: %v", err)
+	}
+
+	results := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if len(results) != len(script)+1 {
+		t.Fatalf("got %d results, want %d", len(results), len(script)+1)
+	}
+	// A prefix that defines the function but stops short of calling it
+	// leaves the function behind, which the next export redefines
+	none, all := "unset|unset|old|", "first|it's\nsecond|unset|"
+	for n, got := range results {
+		got = strings.TrimSuffix(got, "function")
+		if got != none && got != all {
+			t.Errorf("eval of the first %d of %d bytes left %q, want %q or %q", n, len(script), got, none, all)
+		}
+	}
+	if got := results[len(script)]; got != all {
+		t.Errorf("eval of the whole output left %q, want %q", got, all)
+	}
+}
+
+// FuzzBashQuote checks that every quoted value survives a round trip
+// through bash's eval, the way the hook applies the export output.
+func FuzzBashQuote(f *testing.F) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		f.Skip("bash not installed")
+	}
+
+	for _, seed := range []string{
+		"", "!", "a!b", "!!", "!$", "^a^b", "it's", "''", `"`, `\`, `\'`, "a\nb", "\r\n",
+		"$(id)", "`id`", "${HOME}", "*", "~", "#", "\xff\xfe", "日本語",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if strings.IndexByte(value, 0) >= 0 {
+			t.Skip("bash variables cannot hold NUL")
+		}
+
+		// histexpand is on so a regression to double quotes would show up
+		script := `set -H; eval "$1"; printf %s "$VAR"`
+		cmd := exec.Command(bash, "--norc", "--noprofile", "-c", script, "bash", "export VAR="+BashQuote(value)+";")
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		got, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("bash: %v\n%s", err, stderr.String())
+		}
+		if string(got) != value {
+			t.Errorf("round trip of %q through bash = %q", value, got)
+		}
+	})
+}
+
 func TestGet(t *testing.T) {
 	tests := []struct {
 		name      string
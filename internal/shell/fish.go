@@ -8,22 +8,43 @@ import (
 	"text/template"
 )
 
-type fishShell struct{}
+type fishShell struct {
+	listVars []string
+}
+
+// DefaultFishListVars are the variables Fish exports as lists.
+var DefaultFishListVars = []string{"PATH", "MANPATH", "CDPATH"}
 
 // Fish is the Shell implementation for fish.
-var Fish Shell = &fishShell{}
+var Fish Shell = NewFish(DefaultFishListVars)
+
+// NewFish returns the Shell implementation for fish, exporting the
+// variables named in listVars as lists. fish keeps PATH as a list, one
+// element per directory, and joins it with ':' for child processes; set to
+// a single colon-separated string, it works for children but not for
+// fish_add_path, builtins, or prompts iterating $PATH.
+func NewFish(listVars []string) Shell {
+	return &fishShell{listVars: listVars}
+}
 
 // fishHookTemplate is the template for the fish hook.
 // It uses fish's event system to trigger on prompt and directory changes.
 // The PWD variable hook handles cd, pushd, popd, and any other directory changes.
-const fishHookTemplate = `function __cascade_export_eval --on-event fish_prompt
-    "{{.SelfPath}}" export fish | source
+// Both functions save $status on entry and return it, so a prompt that
+// shows the previous command's exit status is not clobbered, as in bash.
+const fishHookTemplate = `{{.Marker}}function __cascade_export_eval --on-event fish_prompt
+    set -l previous_exit_status $status
+    {{if .ResolvePath}}set -l cascade (command -s cascade; or echo "{{.SelfPath}}")
+    "$cascade"{{else}}"{{.SelfPath}}"{{end}} export fish | source
+    return $previous_exit_status
 end
 
 function __cascade_cd_hook --on-variable PWD
+    set -l previous_exit_status $status
     if test "$CASCADE_FISH_MODE" != "disable_arrow"
         __cascade_export_eval
     end
+    return $previous_exit_status
 end
 `
 
@@ -33,16 +54,18 @@ func (f *fishShell) Name() string {
 	return "fish"
 }
 
-func (f *fishShell) Hook(selfPath string) string {
+func (f *fishShell) Hook(opts HookOptions) string {
 	var buf bytes.Buffer
 	data := struct {
-		SelfPath string
+		HookOptions
+		Marker string
 	}{
-		SelfPath: selfPath,
+		HookOptions: opts,
+		Marker:      versionMarker(f, opts),
 	}
 	// Template is validated at init time, so this cannot fail.
 	_ = fishHookTmpl.Execute(&buf, data)
-	return buf.String()
+	return guardHook("fish", buf.String())
 }
 
 func (f *fishShell) Export(e ShellExport) string {
@@ -63,13 +86,20 @@ func (f *fishShell) Export(e ShellExport) string {
 		if value == nil {
 			fmt.Fprintf(&sb, "set -e %s;\n", key)
 		} else {
-			fmt.Fprintf(&sb, "set -gx %s '%s';\n", key, FishEscape(*value))
+			f.writeSet(&sb, key, *value)
 		}
 	}
 
 	return sb.String()
 }
 
+func (f *fishShell) Wrap(script string) string {
+	if script == "" {
+		return ""
+	}
+	return "function " + applyFunc + "\n" + script + "end\n" + applyFunc + "; functions -e " + applyFunc + ";\n"
+}
+
 func (f *fishShell) Dump(env map[string]string) string {
 	if len(env) == 0 {
 		return ""
@@ -84,8 +114,29 @@ func (f *fishShell) Dump(env map[string]string) string {
 
 	var sb strings.Builder
 	for _, key := range keys {
-		fmt.Fprintf(&sb, "set -gx %s '%s';\n", key, FishEscape(env[key]))
+		f.writeSet(&sb, key, env[key])
 	}
 
 	return sb.String()
 }
+
+// writeSet writes the command setting key to value. A list variable gets
+// one element per colon-separated segment, empty ones included, so fish
+// joins it back to value. fish treats names ending in PATH as colon-joined
+// path variables; any other list variable is set with --path to be.
+func (f *fishShell) writeSet(sb *strings.Builder, key, value string) {
+	if !slices.Contains(f.listVars, key) {
+		fmt.Fprintf(sb, "set -gx %s '%s';\n", key, FishEscape(value))
+		return
+	}
+
+	sb.WriteString("set -gx ")
+	if !strings.HasSuffix(key, "PATH") {
+		sb.WriteString("--path ")
+	}
+	sb.WriteString(key)
+	for _, segment := range strings.Split(value, ":") {
+		fmt.Fprintf(sb, " '%s'", FishEscape(segment))
+	}
+	sb.WriteString(";\n")
+}
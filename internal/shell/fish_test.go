@@ -1,6 +1,8 @@
 package shell
 
 import (
+	"os"
+	"os/exec"
 	"strings"
 	"testing"
 )
@@ -12,7 +14,7 @@ func TestFishName(t *testing.T) {
 }
 
 func TestFishHook(t *testing.T) {
-	hook := Fish.Hook("/usr/local/bin/cascade")
+	hook := Fish.Hook(HookOptions{SelfPath: "/usr/local/bin/cascade", Version: "1.2.3"})
 
 	t.Run("contains __cascade_export_eval function", func(t *testing.T) {
 		if !strings.Contains(hook, "__cascade_export_eval") {
@@ -55,6 +57,73 @@ func TestFishHook(t *testing.T) {
 			t.Error("hook should pipe export output to source")
 		}
 	})
+
+	t.Run("preserves exit status", func(t *testing.T) {
+		for _, fn := range []string{"__cascade_export_eval --on-event", "__cascade_cd_hook --on-variable"} {
+			body := fishFunction(t, hook, fn)
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			if first := strings.TrimSpace(lines[0]); first != "set -l previous_exit_status $status" {
+				t.Errorf("%s should save $status first, starts with %q", fn, first)
+			}
+			if last := strings.TrimSpace(lines[len(lines)-1]); last != "return $previous_exit_status" {
+				t.Errorf("%s should return the saved status last, ends with %q", fn, last)
+			}
+		}
+	})
+
+	t.Run("does not look cascade up", func(t *testing.T) {
+		if strings.Contains(hook, "command -s cascade") {
+			t.Error("hook without ResolvePath should run selfPath directly")
+		}
+	})
+}
+
+// fishFunction returns the body of the fish function in hook whose
+// definition line starts with "function "+prefix.
+func fishFunction(t *testing.T, hook, prefix string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(hook, "function "+prefix)
+	if !ok {
+		t.Fatalf("hook has no function %s", prefix)
+	}
+	_, rest, _ = strings.Cut(rest, "\n")
+	body, _, ok := strings.Cut(rest, "\nend")
+	if !ok {
+		t.Fatalf("function %s has no end", prefix)
+	}
+	return body
+}
+
+// TestFishHook_ResolvePath checks that a ResolvePath hook looks cascade up
+// on PATH, falling back to SelfPath, and runs it when fish is installed.
+func TestFishHook_ResolvePath(t *testing.T) {
+	hook := Fish.Hook(HookOptions{SelfPath: "/nonexistent/cascade", Version: "1.2.3", ResolvePath: true})
+	if !strings.Contains(hook, "command -s cascade") || !strings.Contains(hook, `echo "/nonexistent/cascade"`) {
+		t.Errorf("hook should look cascade up on PATH and fall back to SelfPath:\n%s", hook)
+	}
+	if strings.Contains(hook, "1.2.3") {
+		t.Error("hook should not record a version it may not run")
+	}
+
+	fish, err := exec.LookPath("fish")
+	if err != nil {
+		t.Skip("fish not installed")
+	}
+	bin := t.TempDir()
+	fake := "#!/bin/sh\necho \"set -gx RAN $0\"\n"
+	if err := os.WriteFile(bin+"/cascade", []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := hook + "__cascade_export_eval\nprintf '%s|%s' \"$RAN\" (set -q CASCADE_HOOK_VERSION; and echo set; or echo unset)"
+	cmd := exec.Command(fish, "--no-config", "-c", script)
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin", "HOME=" + t.TempDir(), "CASCADE_HOOK_VERSION=0.9.0"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("fish: %v", err)
+	}
+	if want := bin + "/cascade|unset"; string(out) != want {
+		t.Errorf("hook ran %q, want %q", out, want)
+	}
 }
 
 func TestFishExport(t *testing.T) {
@@ -115,10 +184,10 @@ func TestFishExport(t *testing.T) {
 			name: "value with backslash",
 			export: func() ShellExport {
 				e := make(ShellExport)
-				e.Set("PATH", `C:\Users\test`)
+				e.Set("DIR", `C:\Users\test`)
 				return e
 			}(),
-			contains: []string{`set -gx PATH 'C:\\Users\\test';`},
+			contains: []string{`set -gx DIR 'C:\\Users\\test';`},
 		},
 	}
 
@@ -139,6 +208,31 @@ func TestFishExport(t *testing.T) {
 	}
 }
 
+func TestFishExport_ListVars(t *testing.T) {
+	e := make(ShellExport)
+	e.Set("PATH", "/a::/b:")
+	e.Set("CDPATH", "")
+	e.Set("GOPATH", "/go:/src")
+	e.Unset("MANPATH")
+	want := "set -gx CDPATH '';\n" +
+		"set -gx GOPATH '/go:/src';\n" +
+		"set -e MANPATH;\n" +
+		"set -gx PATH '/a' '' '/b' '';\n"
+	if got := Fish.Export(e); got != want {
+		t.Errorf("Export() = %q, want %q", got, want)
+	}
+
+	// With other list variables, names not ending in PATH are set with --path
+	fish := NewFish([]string{"GOPATH", "XDG_DATA_DIRS"})
+	got := fish.Dump(map[string]string{"PATH": "/a:/b", "GOPATH": "/go:/src", "XDG_DATA_DIRS": "/usr/share:/it's"})
+	want = "set -gx GOPATH '/go' '/src';\n" +
+		"set -gx PATH '/a:/b';\n" +
+		"set -gx --path XDG_DATA_DIRS '/usr/share' '/it\\'s';\n"
+	if got != want {
+		t.Errorf("Dump() = %q, want %q", got, want)
+	}
+}
+
 func TestFishExportDeterministic(t *testing.T) {
 	e := make(ShellExport)
 	e.Set("Z_VAR", "last")
@@ -157,6 +251,16 @@ func TestFishExportDeterministic(t *testing.T) {
 	}
 }
 
+func TestFishWrap(t *testing.T) {
+	if got := Fish.Wrap(""); got != "" {
+		t.Errorf("Wrap(\"\") = %q, want empty", got)
+	}
+	want := "function _cascade_apply\nset -gx A 'b';\nend\n_cascade_apply; functions -e _cascade_apply;\n"
+	if got := Fish.Wrap("set -gx A 'b';\n"); got != want {
+		t.Errorf("Wrap() = %q, want %q", got, want)
+	}
+}
+
 func TestFishDump(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,66 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hookShell describes how to recognise a shell at runtime and how its hook
+// is meant to be loaded.
+type hookShell struct {
+	name       string
+	versionVar string // Set by the shell itself, never exported
+	load       string // The rc-file line that installs the hook
+}
+
+// hookShells lists the shells a hook may be sourced by, in guard order.
+var hookShells = []hookShell{
+	{name: "bash", versionVar: "BASH_VERSION", load: `eval "$(cascade hook bash)"`},
+	{name: "zsh", versionVar: "ZSH_VERSION", load: `eval "$(cascade hook zsh)"`},
+	{name: "fish", versionVar: "FISH_VERSION", load: `cascade hook fish | source`},
+}
+
+// pwshLoad is the profile line that installs the PowerShell hook, which is
+// not guarded and so not in hookShells.
+const pwshLoad = `Invoke-Expression (& cascade hook pwsh | Out-String)`
+
+// LoadLine returns the rc-file line that installs the named shell's hook,
+// or "" for an unsupported shell.
+func LoadLine(name string) string {
+	if name == "pwsh" {
+		return pwshLoad
+	}
+	for _, sh := range hookShells {
+		if sh.name == name {
+			return sh.load
+		}
+	}
+	return ""
+}
+
+// guardHook wraps a hook body so that only the shell it was generated for
+// evaluates it. Any other shell prints a single line naming itself and the
+// command it should use instead, and defines nothing.
+//
+// The guard must parse in bash, zsh and fish alike, which leaves only simple
+// commands joined by && (fish 3.0+). The body is passed to eval as a single
+// word, quoted so that every one of them reads it alike (see guardQuote), so
+// the wrong shell never parses it.
+func guardHook(name, body string) string {
+	var b strings.Builder
+	var target hookShell
+	var versions strings.Builder
+	for _, sh := range hookShells {
+		versions.WriteString("$" + sh.versionVar)
+		if sh.name == name {
+			target = sh
+			continue
+		}
+		fmt.Fprintf(&b, "test -n \"$%s\" && echo 'cascade: this is the %s hook, but the current shell is %s; use: %s' >&2\n",
+			sh.versionVar, name, sh.name, sh.load)
+	}
+	fmt.Fprintf(&b, "test -z \"%s\" && echo 'cascade: this is the %s hook, but the current shell is not bash, zsh or fish' >&2\n",
+		versions.String(), name)
+	fmt.Fprintf(&b, "test -n \"$%s\" && eval %s\n", target.versionVar, guardQuote(body))
+	return b.String()
+}
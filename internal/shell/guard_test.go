@@ -0,0 +1,169 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hookFunctions are the functions any of the hooks define.
+var hookFunctions = []string{"_cascade_hook", "_cascade_precmd_seq", "__cascade_export_eval", "__cascade_cd_hook"}
+
+// sourceHook loads the hook in file with the given interpreter, the way the
+// README tells users to, and reports which hook functions and prompt
+// registrations exist afterwards.
+func sourceHook(t *testing.T, interp, file string) (stdout, stderr string) {
+	t.Helper()
+
+	path, err := exec.LookPath(interp)
+	if err != nil {
+		t.Skipf("%s not installed", interp)
+	}
+
+	names := strings.Join(hookFunctions, " ")
+	var cmd *exec.Cmd
+	switch interp {
+	case "bash":
+		cmd = exec.Command(path, "--norc", "--noprofile", "-c",
+			`eval "$(cat "$1")"; for f in `+names+`; do declare -F $f >/dev/null && echo "defined $f"; done; echo "PROMPT_COMMAND=$PROMPT_COMMAND"`,
+			"bash", file)
+	case "zsh":
+		cmd = exec.Command(path, "-f", "-c",
+			`eval "$(cat "$1")"; for f in `+names+`; do (( $+functions[$f] )) && echo "defined $f"; done; echo "precmd_functions=$precmd_functions"`,
+			"zsh", file)
+	case "fish":
+		cmd = exec.Command(path, "--no-config", "-c",
+			`cat $argv[1] | source; for f in `+names+`; functions -q $f; and echo "defined $f"; end`,
+			file)
+	}
+
+	var out, errOut strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	_ = cmd.Run() // A refused hook leaves a non-zero status
+	return out.String(), errOut.String()
+}
+
+func TestHookGuard(t *testing.T) {
+	dir := t.TempDir()
+	for _, target := range []Shell{Bash, Zsh, Fish} {
+		file := filepath.Join(dir, target.Name()+".hook")
+		if err := os.WriteFile(file, []byte(target.Hook(HookOptions{SelfPath: "/usr/local/bin/cascade", Version: "1.2.3"})), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, interp := range []string{"bash", "zsh", "fish"} {
+			t.Run(target.Name()+" hook in "+interp, func(t *testing.T) {
+				stdout, stderr := sourceHook(t, interp, file)
+
+				if interp == target.Name() {
+					if stderr != "" {
+						t.Errorf("stderr = %q, want empty", stderr)
+					}
+					if !strings.Contains(stdout, "defined ") {
+						t.Errorf("hook defined no functions:\n%s", stdout)
+					}
+					return
+				}
+
+				lines := strings.Split(strings.TrimSpace(stderr), "\n")
+				if len(lines) != 1 {
+					t.Fatalf("stderr = %q, want a single line", stderr)
+				}
+				want := "this is the " + target.Name() + " hook, but the current shell is " + interp + "; use: "
+				if !strings.Contains(lines[0], want) || !strings.Contains(lines[0], "cascade hook "+interp) {
+					t.Errorf("stderr = %q, want it to contain %q and the %s hook command", lines[0], want, interp)
+				}
+				if strings.Contains(stdout, "defined ") {
+					t.Errorf("hook partially installed:\n%s", stdout)
+				}
+				if strings.Contains(stdout, "_cascade_hook") {
+					t.Errorf("hook registered with the prompt:\n%s", stdout)
+				}
+			})
+		}
+	}
+}
+
+// hookBody returns the body the guard of hook evals, read back from the
+// word guardQuote made of it.
+func hookBody(t *testing.T, hook string) string {
+	t.Helper()
+
+	_, word, ok := strings.Cut(hook, " && eval ")
+	if !ok {
+		t.Fatalf("hook has no guarded body:\n%s", hook)
+	}
+	word = strings.TrimSuffix(word, "\n")
+	var b strings.Builder
+	for len(word) > 0 {
+		switch word[0] {
+		case '\'':
+			end := strings.IndexByte(word[1:], '\'')
+			if end < 0 {
+				t.Fatalf("unterminated quote in guarded body: %q", word)
+			}
+			b.WriteString(word[1 : end+1])
+			word = word[end+2:]
+		case '\\':
+			if len(word) < 2 {
+				t.Fatalf("trailing backslash in guarded body")
+			}
+			b.WriteByte(word[1])
+			word = word[2:]
+		default:
+			t.Fatalf("unquoted %q in guarded body", word[0])
+		}
+	}
+	return b.String()
+}
+
+func TestGuardHook_QuotesBody(t *testing.T) {
+	hook := guardHook("bash", "echo 'it''s'\n")
+	want := `test -n "$BASH_VERSION" && eval 'echo '\''it'\'\''s'\''` + "\n'\n"
+	if !strings.HasSuffix(hook, want) {
+		t.Errorf("hook = %q, want suffix %q", hook, want)
+	}
+	if body := hookBody(t, hook); body != "echo 'it''s'\n" {
+		t.Errorf("hookBody() = %q, want the body back", body)
+	}
+}
+
+func TestGuardQuote(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", `''`},
+		{"plain $HOME `date`", "'plain $HOME `date`'"},
+		{"it's", `'it'\''s'`},
+		{`a\'b`, `'a'\\\''b'`},
+		{`ends\`, `'ends'\\`},
+		{`\\n`, `\\\\'n'`},
+		{"printf '%s\\n' x\n", `'printf '\''%s'\\'n'\'' x` + "\n'"},
+	}
+	for _, tt := range tests {
+		got := guardQuote(tt.input)
+		if got != tt.want {
+			t.Errorf("guardQuote(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+
+		// Every shell the guard runs in reads it back as the input
+		for _, interp := range []string{"bash", "zsh", "fish"} {
+			path, err := exec.LookPath(interp)
+			if err != nil {
+				continue
+			}
+			out, err := exec.Command(path, "-c", "printf %s "+got).Output()
+			if err != nil {
+				t.Errorf("%s: %v", interp, err)
+				continue
+			}
+			if string(out) != tt.input {
+				t.Errorf("%s reads guardQuote(%q) as %q", interp, tt.input, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FormatNull encodes e in a shell-neutral form: one NUL-terminated entry
+// per variable, sorted by name. A set variable is written as NAME=VALUE and
+// an unset one as a bare NAME. Environment variables cannot contain NUL, so
+// values need no quoting and a few lines of any shell can apply the result.
+func FormatNull(e ShellExport) []byte {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(k)
+		if v := e[k]; v != nil {
+			buf.WriteByte('=')
+			buf.WriteString(*v)
+		}
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// ParseNull decodes the output of FormatNull.
+func ParseNull(data []byte) (ShellExport, error) {
+	e := make(ShellExport)
+	if len(data) == 0 {
+		return e, nil
+	}
+	if data[len(data)-1] != 0 {
+		return nil, errors.New("missing NUL after last entry")
+	}
+
+	for _, entry := range strings.Split(string(data[:len(data)-1]), "\x00") {
+		name, value, set := strings.Cut(entry, "=")
+		if name == "" {
+			return nil, fmt.Errorf("entry without a name: %q", entry)
+		}
+		if set {
+			e.Set(name, value)
+		} else {
+			e.Unset(name)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,82 @@
+package shell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestFormatNull(t *testing.T) {
+	e := make(ShellExport)
+	e.Set("B", "two\nlines")
+	e.Set("A", "x=y")
+	e.Set("EMPTY", "")
+	e.Unset("GONE")
+
+	want := "A=x=y\x00B=two\nlines\x00EMPTY=\x00GONE\x00"
+	if got := string(FormatNull(e)); got != want {
+		t.Errorf("FormatNull() = %q, want %q", got, want)
+	}
+
+	back, err := ParseNull(FormatNull(e))
+	if err != nil {
+		t.Fatalf("ParseNull: %v", err)
+	}
+	if len(back) != len(e) {
+		t.Fatalf("ParseNull() has %d entries, want %d", len(back), len(e))
+	}
+	for k, v := range e {
+		got, ok := back[k]
+		switch {
+		case !ok:
+			t.Errorf("%s missing after round trip", k)
+		case (v == nil) != (got == nil):
+			t.Errorf("%s: set/unset changed in round trip", k)
+		case v != nil && *v != *got:
+			t.Errorf("%s = %q, want %q", k, *got, *v)
+		}
+	}
+}
+
+func TestParseNull_Invalid(t *testing.T) {
+	for _, data := range []string{"A=1", "A=1\x00=2\x00", "\x00"} {
+		if _, err := ParseNull([]byte(data)); err == nil {
+			t.Errorf("ParseNull(%q) succeeded, want error", data)
+		}
+	}
+
+	if e, err := ParseNull(nil); err != nil || len(e) != 0 {
+		t.Errorf("ParseNull(nil) = %v, %v; want empty", e, err)
+	}
+}
+
+// TestFormatNull_BashLoader applies FormatNull output with the loader shown
+// in the README, so the documented snippet keeps working.
+func TestFormatNull_BashLoader(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	e := make(ShellExport)
+	e.Set("VALUE", "it's $HOME\nand more")
+	e.Unset("GONE")
+
+	script := `while IFS= read -r -d '' kv; do
+  case $kv in
+    *=*) export "$kv" ;;
+    *) unset "$kv" ;;
+  esac
+done
+printf '%s|%s' "$VALUE" "${GONE-unset}"`
+	cmd := exec.Command(bash, "--norc", "--noprofile", "-c", script)
+	cmd.Env = []string{"GONE=still here"}
+	cmd.Stdin = strings.NewReader(string(FormatNull(e)))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bash: %v", err)
+	}
+	if want := "it's $HOME\nand more|unset"; string(out) != want {
+		t.Errorf("loaded %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,115 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+type pwshShell struct{}
+
+// Pwsh is the Shell implementation for PowerShell (pwsh, 7 and later).
+var Pwsh Shell = &pwshShell{}
+
+// pwshHookTemplate is the template for the PowerShell hook.
+// It wraps the prompt function, saving the original once so loading the
+// hook twice does not run export twice, and preserves $LASTEXITCODE,
+// which running cascade would otherwise overwrite.
+const pwshHookTemplate = `{{.Marker}}function global:__cascade_hook {
+  $previousExitCode = $global:LASTEXITCODE
+  {{if .ResolvePath}}$cascade = Get-Command cascade -CommandType Application -ErrorAction SilentlyContinue | Select-Object -First 1 -ExpandProperty Source
+  if (-not $cascade) { $cascade = {{.Self}} }
+  {{else}}$cascade = {{.Self}}
+  {{end}}$exports = & $cascade export pwsh | Out-String
+  if ($exports) { Invoke-Expression $exports }
+  $global:LASTEXITCODE = $previousExitCode
+}
+if (-not (Test-Path Variable:global:__cascade_prompt)) {
+  $global:__cascade_prompt = $function:prompt
+}
+function global:prompt {
+  __cascade_hook
+  & $global:__cascade_prompt
+}
+`
+
+var pwshHookTmpl = template.Must(template.New("pwsh-hook").Parse(pwshHookTemplate))
+
+func (p *pwshShell) Name() string {
+	return "pwsh"
+}
+
+// Hook returns the PowerShell hook. Unlike the other hooks it is not
+// wrapped by guardHook: PowerShell cannot parse the guard, and the other
+// shells cannot parse Invoke-Expression, so each fails on the other's hook.
+func (p *pwshShell) Hook(opts HookOptions) string {
+	var buf bytes.Buffer
+	data := struct {
+		HookOptions
+		Marker string
+		Self   string
+	}{
+		HookOptions: opts,
+		Marker:      versionMarker(p, opts),
+		Self:        PwshQuote(opts.SelfPath),
+	}
+	// Template is validated at init time, so this cannot fail.
+	_ = pwshHookTmpl.Execute(&buf, data)
+	return buf.String()
+}
+
+// Export formats environment changes as PowerShell statements. PowerShell
+// removes a variable set to "", so an empty value reads back as unset.
+func (p *pwshShell) Export(e ShellExport) string {
+	if len(e) == 0 {
+		return ""
+	}
+
+	// Sort keys for deterministic output
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		value := e[key]
+		if value == nil {
+			fmt.Fprintf(&sb, "Remove-Item Env:%s -ErrorAction SilentlyContinue;\n", key)
+		} else {
+			fmt.Fprintf(&sb, "$env:%s = %s;\n", key, PwshQuote(*value))
+		}
+	}
+
+	return sb.String()
+}
+
+func (p *pwshShell) Wrap(script string) string {
+	if script == "" {
+		return ""
+	}
+	return "function " + applyFunc + " {\n" + script + "}\n" + applyFunc + "; Remove-Item Function:" + applyFunc + ";\n"
+}
+
+func (p *pwshShell) Dump(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+
+	// Sort keys for deterministic output
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "$env:%s = %s;\n", key, PwshQuote(env[key]))
+	}
+
+	return sb.String()
+}
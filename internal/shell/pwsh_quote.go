@@ -0,0 +1,30 @@
+package shell
+
+import "strings"
+
+// PwshQuote returns s as a PowerShell double-quoted string. The backtick is
+// PowerShell's escape character, and inside double quotes it must precede:
+//   - the backtick itself;
+//   - $, which would otherwise start a variable or subexpression;
+//   - the double quote, and the typographic quotes “ ” „ that PowerShell
+//     also accepts as double quotes.
+//
+// Everything else, including newlines and backslashes, is literal.
+//
+// Values cannot contain NUL bytes; neither can environment variables.
+func PwshQuote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '`', '$', '"', '“', '”', '„':
+			b.WriteByte('`')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
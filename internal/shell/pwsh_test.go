@@ -0,0 +1,417 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPwshName(t *testing.T) {
+	if got := Pwsh.Name(); got != "pwsh" {
+		t.Errorf("Name() = %q, want %q", got, "pwsh")
+	}
+}
+
+func TestPwshHook(t *testing.T) {
+	hook := Pwsh.Hook(HookOptions{SelfPath: "/usr/local/bin/cascade", Version: "1.2.3"})
+
+	t.Run("contains __cascade_hook function", func(t *testing.T) {
+		if !strings.Contains(hook, "function global:__cascade_hook") {
+			t.Error("hook should contain __cascade_hook function definition")
+		}
+	})
+
+	t.Run("wraps the prompt function", func(t *testing.T) {
+		if !strings.Contains(hook, "function global:prompt") {
+			t.Error("hook should redefine the prompt function")
+		}
+		if !strings.Contains(hook, "Test-Path Variable:global:__cascade_prompt") {
+			t.Error("hook should save the original prompt only once")
+		}
+	})
+
+	t.Run("preserves LASTEXITCODE", func(t *testing.T) {
+		if !strings.Contains(hook, "$previousExitCode = $global:LASTEXITCODE") {
+			t.Error("hook should save LASTEXITCODE")
+		}
+		if !strings.Contains(hook, "$global:LASTEXITCODE = $previousExitCode") {
+			t.Error("hook should restore LASTEXITCODE")
+		}
+	})
+
+	t.Run("contains quoted selfPath", func(t *testing.T) {
+		if !strings.Contains(hook, `$cascade = "/usr/local/bin/cascade"`) {
+			t.Error("hook should contain the quoted selfPath")
+		}
+	})
+
+	t.Run("invokes export pwsh", func(t *testing.T) {
+		if !strings.Contains(hook, "export pwsh | Out-String") || !strings.Contains(hook, "Invoke-Expression $exports") {
+			t.Error("hook should invoke the output of cascade export pwsh")
+		}
+	})
+
+	t.Run("exports hook version", func(t *testing.T) {
+		if !strings.Contains(hook, `$env:CASCADE_HOOK_VERSION = "1.2.3";`) {
+			t.Error("hook should set CASCADE_HOOK_VERSION")
+		}
+	})
+
+	t.Run("is not guarded", func(t *testing.T) {
+		if strings.Contains(hook, "test -n") {
+			t.Error("hook should not contain the sh-style guard")
+		}
+	})
+
+	t.Run("quotes a selfPath with special characters", func(t *testing.T) {
+		hook := Pwsh.Hook(HookOptions{SelfPath: `C:\Users\$me\cascade.exe`})
+		if !strings.Contains(hook, "\"C:\\Users\\`$me\\cascade.exe\"") {
+			t.Errorf("hook does not escape $ in selfPath:\n%s", hook)
+		}
+	})
+}
+
+// TestPwshHook_ResolvePath runs a ResolvePath hook with a cascade on PATH
+// that differs from SelfPath and checks the hook uses it.
+func TestPwshHook_ResolvePath(t *testing.T) {
+	pwsh, err := exec.LookPath("pwsh")
+	if err != nil {
+		t.Skip("pwsh not installed")
+	}
+
+	bin := t.TempDir()
+	fake := "#!/bin/sh\necho \"\\$env:RAN = '$0'\"\n"
+	if err := os.WriteFile(bin+"/cascade", []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Pwsh.Hook(HookOptions{SelfPath: "/nonexistent/cascade", Version: "1.2.3", ResolvePath: true})
+	script := hook + `__cascade_hook
+[Console]::Out.Write("$env:RAN|$(if (Test-Path Env:CASCADE_HOOK_VERSION) { 'set' } else { 'unset' })")`
+	cmd := exec.Command(pwsh, "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin", "HOME=" + t.TempDir(), "CASCADE_HOOK_VERSION=0.9.0"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("pwsh: %v", err)
+	}
+	if want := bin + "/cascade|unset"; string(out) != want {
+		t.Errorf("hook ran %q, want %q", out, want)
+	}
+}
+
+func TestPwshExport(t *testing.T) {
+	tests := []struct {
+		name     string
+		export   ShellExport
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "empty export",
+			export:   ShellExport{},
+			contains: nil,
+		},
+		{
+			name: "set single variable",
+			export: func() ShellExport {
+				e := make(ShellExport)
+				e.Set("FOO", "bar")
+				return e
+			}(),
+			contains: []string{`$env:FOO = "bar";`},
+		},
+		{
+			name: "unset single variable",
+			export: func() ShellExport {
+				e := make(ShellExport)
+				e.Unset("FOO")
+				return e
+			}(),
+			contains: []string{`Remove-Item Env:FOO -ErrorAction SilentlyContinue;`},
+		},
+		{
+			name: "set and unset multiple",
+			export: func() ShellExport {
+				e := make(ShellExport)
+				e.Set("PATH", "/usr/bin")
+				e.Unset("OLD_VAR")
+				e.Set("HOME", "/home/user")
+				return e
+			}(),
+			contains: []string{
+				`$env:PATH = "/usr/bin";`,
+				`Remove-Item Env:OLD_VAR -ErrorAction SilentlyContinue;`,
+				`$env:HOME = "/home/user";`,
+			},
+		},
+		{
+			name: "value with special characters",
+			export: func() ShellExport {
+				e := make(ShellExport)
+				e.Set("MSG", "hello \"world\" $HOME `date`")
+				return e
+			}(),
+			contains: []string{"$env:MSG = \"hello `\"world`\" `$HOME ``date``\";"},
+		},
+		{
+			name: "value with backslash",
+			export: func() ShellExport {
+				e := make(ShellExport)
+				e.Set("DIR", `C:\Users\test`)
+				return e
+			}(),
+			contains: []string{`$env:DIR = "C:\Users\test";`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Pwsh.Export(tt.export)
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("Export() = %q, should contain %q", got, want)
+				}
+			}
+			for _, exclude := range tt.excludes {
+				if strings.Contains(got, exclude) {
+					t.Errorf("Export() = %q, should not contain %q", got, exclude)
+				}
+			}
+		})
+	}
+}
+
+func TestPwshWrap(t *testing.T) {
+	if got := Pwsh.Wrap(""); got != "" {
+		t.Errorf("Wrap(\"\") = %q, want empty", got)
+	}
+	want := "function _cascade_apply {\n$env:A = 'b';\n}\n_cascade_apply; Remove-Item Function:_cascade_apply;\n"
+	if got := Pwsh.Wrap("$env:A = 'b';\n"); got != want {
+		t.Errorf("Wrap() = %q, want %q", got, want)
+	}
+}
+
+func TestPwshExportDeterministic(t *testing.T) {
+	e := make(ShellExport)
+	e.Set("Z_VAR", "last")
+	e.Set("A_VAR", "first")
+	e.Set("M_VAR", "middle")
+
+	got := Pwsh.Export(e)
+
+	// Check that A comes before M comes before Z
+	aIdx := strings.Index(got, "A_VAR")
+	mIdx := strings.Index(got, "M_VAR")
+	zIdx := strings.Index(got, "Z_VAR")
+
+	if aIdx > mIdx || mIdx > zIdx {
+		t.Errorf("Export() output not sorted: A at %d, M at %d, Z at %d", aIdx, mIdx, zIdx)
+	}
+}
+
+func TestPwshDump(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		contains []string
+	}{
+		{
+			name:     "empty env",
+			env:      map[string]string{},
+			contains: nil,
+		},
+		{
+			name: "single variable",
+			env: map[string]string{
+				"FOO": "bar",
+			},
+			contains: []string{`$env:FOO = "bar";`},
+		},
+		{
+			name: "multiple variables",
+			env: map[string]string{
+				"PATH": "/usr/bin",
+				"HOME": "/home/user",
+			},
+			contains: []string{
+				`$env:PATH = "/usr/bin";`,
+				`$env:HOME = "/home/user";`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Pwsh.Dump(tt.env)
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("Dump() = %q, should contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPwshDumpDeterministic(t *testing.T) {
+	env := map[string]string{
+		"Z_VAR": "last",
+		"A_VAR": "first",
+		"M_VAR": "middle",
+	}
+
+	got := Pwsh.Dump(env)
+
+	// Check that A comes before M comes before Z
+	aIdx := strings.Index(got, "A_VAR")
+	mIdx := strings.Index(got, "M_VAR")
+	zIdx := strings.Index(got, "Z_VAR")
+
+	if aIdx > mIdx || mIdx > zIdx {
+		t.Errorf("Dump() output not sorted: A at %d, M at %d, Z at %d", aIdx, mIdx, zIdx)
+	}
+}
+
+func TestPwshQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "simple string",
+			input: "hello",
+			want:  `"hello"`,
+		},
+		{
+			name:  "double quotes",
+			input: `say "hello"`,
+			want:  "\"say `\"hello`\"\"",
+		},
+		{
+			name:  "typographic double quotes",
+			input: "“a” „b”",
+			want:  "\"`“a`” `„b`”\"",
+		},
+		{
+			name:  "single quotes",
+			input: "it's",
+			want:  `"it's"`,
+		},
+		{
+			name:  "backslash",
+			input: `path\to\file`,
+			want:  `"path\to\file"`,
+		},
+		{
+			name:  "dollar sign",
+			input: "$HOME/bin",
+			want:  "\"`$HOME/bin\"",
+		},
+		{
+			name:  "subexpression",
+			input: "$(Get-Date)",
+			want:  "\"`$(Get-Date)\"",
+		},
+		{
+			name:  "backtick",
+			input: "a`nb",
+			want:  "\"a``nb\"",
+		},
+		{
+			name:  "trailing backtick",
+			input: "x`",
+			want:  "\"x``\"",
+		},
+		{
+			name:  "newline",
+			input: "line1\nline2",
+			want:  "\"line1\nline2\"",
+		},
+		{
+			name:  "empty string",
+			input: "",
+			want:  `""`,
+		},
+		{
+			name:  "unicode",
+			input: "héllo wörld 日本語",
+			want:  `"héllo wörld 日本語"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PwshQuote(tt.input)
+			if got != tt.want {
+				t.Errorf("PwshQuote(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzPwshQuote checks that every quoted value survives a round trip
+// through Invoke-Expression, the way the hook applies the export output.
+func FuzzPwshQuote(f *testing.F) {
+	pwsh, err := exec.LookPath("pwsh")
+	if err != nil {
+		f.Skip("pwsh not installed")
+	}
+
+	for _, seed := range []string{
+		"x", "it's", `"`, "“”„", "`", "``", "`\"", "$", "$env:HOME", "$(Get-Date)", "${x}",
+		`\`, "a\nb", "\r\n", "#", "@(1)", "日本語",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if value == "" || strings.IndexByte(value, 0) >= 0 {
+			t.Skip("PowerShell variables cannot be empty or hold NUL")
+		}
+
+		script := `Invoke-Expression $env:EXPORTS; [Console]::Out.Write($env:VAR)`
+		cmd := exec.Command(pwsh, "-NoProfile", "-NonInteractive", "-Command", script)
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + t.TempDir(), "EXPORTS=$env:VAR = " + PwshQuote(value) + ";"}
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		got, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("pwsh: %v\n%s", err, stderr.String())
+		}
+		if string(got) != value {
+			t.Errorf("round trip of %q through pwsh = %q", value, got)
+		}
+	})
+}
+
+func TestGetPwsh(t *testing.T) {
+	got := Get("pwsh")
+	if got == nil {
+		t.Fatal("Get(\"pwsh\") returned nil")
+	}
+	if got.Name() != "pwsh" {
+		t.Errorf("Get(\"pwsh\").Name() = %q, want %q", got.Name(), "pwsh")
+	}
+}
+
+func TestSupportedIncludesPwsh(t *testing.T) {
+	supported := Supported()
+
+	found := false
+	for _, s := range supported {
+		if s == "pwsh" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("Supported() should include 'pwsh'")
+	}
+}
+
+func TestLoadLinePwsh(t *testing.T) {
+	if got := LoadLine("pwsh"); !strings.Contains(got, "cascade hook pwsh") {
+		t.Errorf("LoadLine(\"pwsh\") = %q, want the pwsh hook command", got)
+	}
+}
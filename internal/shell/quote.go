@@ -2,31 +2,63 @@ package shell
 
 import "strings"
 
-// BashEscape escapes a string for safe use in bash double quotes.
-// Handles: backslashes, double quotes, dollar signs, backticks, newlines.
-func BashEscape(s string) string {
+// BashQuote returns s as a bash word made of single-quoted runs. Nothing is
+// special inside single quotes in bash or zsh ($, `, \, !, newlines, and so
+// on are all literal, and history expansion does not apply), so the only
+// character that needs care is the single quote itself, which is written
+// as \' between the quoted runs:
+//
+//	it's  ->  'it'\''s'
+//
+// Empty runs are omitted so no two quotes are ever adjacent, which zsh's
+// RC_QUOTES option would otherwise read as a literal quote.
+//
+// Values cannot contain NUL bytes; neither can environment variables.
+func BashQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
 	var b strings.Builder
-	b.Grow(len(s) + 10) // Pre-allocate with some headroom for escapes
+	b.Grow(len(s) + 2)
+	for i, run := range strings.Split(s, "'") {
+		if i > 0 {
+			b.WriteString(`\'`)
+		}
+		if run != "" {
+			b.WriteString("'" + run + "'")
+		}
+	}
 
-	for _, r := range s {
-		switch r {
-		case '\\':
-			b.WriteString(`\\`)
-		case '"':
-			b.WriteString(`\"`)
-		case '$':
-			b.WriteString(`\$`)
-		case '`':
-			b.WriteString("\\`")
-		case '\n':
-			b.WriteString(`\n`)
-		case '\r':
-			b.WriteString(`\r`)
-		case '\t':
-			b.WriteString(`\t`)
-		default:
-			b.WriteRune(r)
+	return b.String()
+}
+
+// guardQuote is BashQuote for a word bash, zsh and fish all read the same
+// way, as the hook guard needs. Fish reads \\ and \' inside single quotes
+// as escapes, so backslashes are written between the quoted runs too, as
+// \\, which every one of them reads as a single backslash:
+//
+//	a\'b  ->  'a'\\\''b'
+func guardQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] != '\'' && s[i] != '\\' {
+			continue
+		}
+		if i > start {
+			b.WriteString("'" + s[start:i] + "'")
+		}
+		if i < len(s) {
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
 		}
+		start = i + 1
 	}
 
 	return b.String()
@@ -18,24 +18,62 @@ func (e ShellExport) Unset(key string) {
 
 // Shell defines the interface for shell-specific output.
 type Shell interface {
-	// Name returns the shell name (bash, zsh, fish).
+	// Name returns the shell name (bash, zsh, fish, pwsh).
 	Name() string
 
 	// Hook returns the shell hook code to be eval'd in shell config.
-	// selfPath is the path to the cascade binary.
-	Hook(selfPath string) string
+	Hook(opts HookOptions) string
 
 	// Export formats environment changes as shell commands.
 	Export(e ShellExport) string
 
 	// Dump formats a complete environment as shell commands.
 	Dump(env map[string]string) string
+
+	// Wrap makes script, the output of an export, apply all at once or
+	// not at all: the commands become the body of a function that only the
+	// last line calls. A shell left with truncated output, as when export
+	// is killed mid-write, fails to parse the body or never reaches the
+	// call. Wrap returns "" for an empty script.
+	Wrap(script string) string
+}
+
+// applyFunc is the name of the function Wrap defines and calls.
+const applyFunc = "_cascade_apply"
+
+// HookOptions configures a generated shell hook.
+type HookOptions struct {
+	// SelfPath is the path to the cascade binary.
+	SelfPath string
+
+	// Version is the version of cascade generating the hook. The hook
+	// exports it as CASCADE_HOOK_VERSION so export can tell when the
+	// prompt is still running a hook from another release.
+	Version string
+
+	// ResolvePath makes the hook look cascade up on PATH at every prompt,
+	// falling back to SelfPath, so upgrades apply without reloading the
+	// hook. Such a hook unsets CASCADE_HOOK_VERSION instead.
+	ResolvePath bool
+}
+
+// versionMarker returns the statements a hook generated with opts uses to
+// set or clear CASCADE_HOOK_VERSION in sh.
+func versionMarker(sh Shell, opts HookOptions) string {
+	marker := make(ShellExport)
+	if opts.ResolvePath || opts.Version == "" {
+		marker.Unset("CASCADE_HOOK_VERSION")
+	} else {
+		marker.Set("CASCADE_HOOK_VERSION", opts.Version)
+	}
+	return sh.Export(marker)
 }
 
 // shells is the registry of supported shell implementations.
 var shells = map[string]Shell{
 	"bash": Bash,
 	"fish": Fish,
+	"pwsh": Pwsh,
 	"zsh":  Zsh,
 }
 
@@ -27,14 +27,23 @@ var Zsh Shell = &zshShell{}
 //
 // The hook traps SIGINT during eval to prevent interruption of environment
 // updates.
-const zshHookTemplate = `_cascade_precmd_seq() { (( ++_cascade_prompt_seq )) }
+//
+// Powerlevel10k's instant prompt breaks when anything is printed before the
+// first real prompt, as export's warnings are. While it is active
+// (P9K_INSTANT_PROMPT or __p9k_instant_prompt_active is set), the first run
+// is deferred to the next prompt.
+const zshHookTemplate = `{{.Marker}}_cascade_precmd_seq() { (( ++_cascade_prompt_seq )) }
 
 _cascade_hook() {
   [[ "$_cascade_last_run" == "$_cascade_prompt_seq" ]] && return
+  if [[ -z "$_cascade_deferred" && ( -n "${P9K_INSTANT_PROMPT-}" || -n "${__p9k_instant_prompt_active+set}" ) ]]; then
+    _cascade_deferred=1
+    return
+  fi
   _cascade_last_run=$_cascade_prompt_seq
 
   trap -- '' SIGINT
-  eval "$("{{.SelfPath}}" export zsh)"
+  eval "$({{if .ResolvePath}}"$(whence -p cascade || echo "{{.SelfPath}}")"{{else}}"{{.SelfPath}}"{{end}} export zsh)"
   trap - SIGINT
 }
 
@@ -57,16 +66,18 @@ func (z *zshShell) Name() string {
 	return "zsh"
 }
 
-func (z *zshShell) Hook(selfPath string) string {
+func (z *zshShell) Hook(opts HookOptions) string {
 	var buf bytes.Buffer
 	data := struct {
-		SelfPath string
+		HookOptions
+		Marker string
 	}{
-		SelfPath: selfPath,
+		HookOptions: opts,
+		Marker:      versionMarker(z, opts),
 	}
 	// Template is validated at init time, so this cannot fail.
 	_ = zshHookTmpl.Execute(&buf, data)
-	return buf.String()
+	return guardHook("zsh", buf.String())
 }
 
 // Export formats environment changes as shell commands.
@@ -89,13 +100,18 @@ func (z *zshShell) Export(e ShellExport) string {
 		if value == nil {
 			fmt.Fprintf(&sb, "unset %s;\n", key)
 		} else {
-			fmt.Fprintf(&sb, "export %s=\"%s\";\n", key, BashEscape(*value))
+			fmt.Fprintf(&sb, "export %s=%s;\n", key, BashQuote(*value))
 		}
 	}
 
 	return sb.String()
 }
 
+// Wrap wraps script as for bash.
+func (z *zshShell) Wrap(script string) string {
+	return wrapPosix(script)
+}
+
 // Dump formats a complete environment as shell commands.
 // Zsh uses the same export syntax as bash.
 func (z *zshShell) Dump(env map[string]string) string {
@@ -112,7 +128,7 @@ func (z *zshShell) Dump(env map[string]string) string {
 
 	var sb strings.Builder
 	for _, key := range keys {
-		fmt.Fprintf(&sb, "export %s=\"%s\";\n", key, BashEscape(env[key]))
+		fmt.Fprintf(&sb, "export %s=%s;\n", key, BashQuote(env[key]))
 	}
 
 	return sb.String()
@@ -1,6 +1,8 @@
 package shell
 
 import (
+	"os"
+	"os/exec"
 	"strings"
 	"testing"
 )
@@ -12,7 +14,7 @@ func TestZshName(t *testing.T) {
 }
 
 func TestZshHook(t *testing.T) {
-	hook := Zsh.Hook("/usr/local/bin/cascade")
+	hook := hookBody(t, Zsh.Hook(HookOptions{SelfPath: "/usr/local/bin/cascade", Version: "1.2.3"}))
 
 	t.Run("contains _cascade_hook function", func(t *testing.T) {
 		if !strings.Contains(hook, "_cascade_hook()") {
@@ -80,6 +82,55 @@ func TestZshHook(t *testing.T) {
 			t.Error("hook should record when it ran")
 		}
 	})
+
+	t.Run("defers the first run during instant prompt", func(t *testing.T) {
+		guard := `if [[ -z "$_cascade_deferred" && ( -n "${P9K_INSTANT_PROMPT-}" || -n "${__p9k_instant_prompt_active+set}" ) ]]; then`
+		if !strings.Contains(hook, guard) {
+			t.Error("hook should check for powerlevel10k's instant prompt")
+		}
+		// Deferred before recording the run, so the next prompt evaluates
+		if strings.Index(hook, guard) > strings.Index(hook, "_cascade_last_run=$_cascade_prompt_seq") {
+			t.Error("hook should defer before recording that it ran")
+		}
+	})
+
+	t.Run("does not look cascade up", func(t *testing.T) {
+		if strings.Contains(hook, "whence -p cascade") {
+			t.Error("hook without ResolvePath should run selfPath directly")
+		}
+	})
+}
+
+// TestZshHook_ResolvePath checks that a ResolvePath hook looks cascade up
+// on PATH, falling back to SelfPath, and runs it when zsh is installed.
+func TestZshHook_ResolvePath(t *testing.T) {
+	hook := Zsh.Hook(HookOptions{SelfPath: "/nonexistent/cascade", Version: "1.2.3", ResolvePath: true})
+	if !strings.Contains(hook, "whence -p cascade") || !strings.Contains(hook, `echo "/nonexistent/cascade"`) {
+		t.Errorf("hook should look cascade up on PATH and fall back to SelfPath:\n%s", hook)
+	}
+	if strings.Contains(hook, "1.2.3") {
+		t.Error("hook should not record a version it may not run")
+	}
+
+	zsh, err := exec.LookPath("zsh")
+	if err != nil {
+		t.Skip("zsh not installed")
+	}
+	bin := t.TempDir()
+	fake := "#!/bin/sh\necho \"export RAN=$0\"\n"
+	if err := os.WriteFile(bin+"/cascade", []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := hook + "_cascade_hook\nprint -rn -- \"$RAN|${CASCADE_HOOK_VERSION-unset}\""
+	cmd := exec.Command(zsh, "-f", "-c", script)
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin", "HOME=" + t.TempDir(), "CASCADE_HOOK_VERSION=0.9.0"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("zsh: %v", err)
+	}
+	if want := bin + "/cascade|unset"; string(out) != want {
+		t.Errorf("hook ran %q, want %q", out, want)
+	}
 }
 
 func TestZshExport(t *testing.T) {
@@ -100,7 +151,7 @@ func TestZshExport(t *testing.T) {
 				e.Set("FOO", "bar")
 				return e
 			}(),
-			contains: []string{`export FOO="bar";`},
+			contains: []string{`export FOO='bar';`},
 		},
 		{
 			name: "unset single variable",
@@ -121,9 +172,9 @@ func TestZshExport(t *testing.T) {
 				return e
 			}(),
 			contains: []string{
-				`export PATH="/usr/bin";`,
+				`export PATH='/usr/bin';`,
 				`unset OLD_VAR;`,
-				`export HOME="/home/user";`,
+				`export HOME='/home/user';`,
 			},
 		},
 		{
@@ -133,7 +184,7 @@ func TestZshExport(t *testing.T) {
 				e.Set("MSG", `hello "world" $HOME`)
 				return e
 			}(),
-			contains: []string{`export MSG="hello \"world\" \$HOME";`},
+			contains: []string{`export MSG='hello "world" $HOME';`},
 		},
 	}
 
@@ -198,7 +249,7 @@ func TestZshDump(t *testing.T) {
 			env: map[string]string{
 				"FOO": "bar",
 			},
-			contains: []string{`export FOO="bar";`},
+			contains: []string{`export FOO='bar';`},
 		},
 		{
 			name: "multiple variables",
@@ -207,8 +258,8 @@ func TestZshDump(t *testing.T) {
 				"HOME": "/home/user",
 			},
 			contains: []string{
-				`export PATH="/usr/bin";`,
-				`export HOME="/home/user";`,
+				`export PATH='/usr/bin';`,
+				`export HOME='/home/user';`,
 			},
 		},
 	}
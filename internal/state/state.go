@@ -11,9 +11,12 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/unrss/cascade/internal/env"
+	"github.com/unrss/cascade/internal/xdg"
 )
 
 // Store manages persistent environment state for cascade.
@@ -26,19 +29,46 @@ type DirState struct {
 	Path        string       `json:"path"` // Absolute .envrc path
 	ContentHash string       `json:"hash"` // Content hash when saved
 	Diff        *env.EnvDiff `json:"diff"` // Applied diff
-	Timestamp   time.Time    `json:"ts"`   // Save time
+	Timestamp   time.Time    `json:"ts"`   // Save time (last successful evaluation)
+
+	// Ancestor files pulled in by source_up, keyed by the .envrc that did so.
+	Sourced map[string][]string `json:"sourced,omitempty"`
+
+	// Commands registered with on_unload, by level of the chain, root first.
+	Unload []UnloadHooks `json:"unload,omitempty"`
+
+	// Consecutive failed evaluations since the last success, reset by Save.
+	Failures    int       `json:"failures,omitempty"`
+	FailedPath  string    `json:"failed_path,omitempty"`  // .envrc that failed last
+	LastError   string    `json:"last_error,omitempty"`   // Bounded to MaxErrorLen
+	LastFailure time.Time `json:"last_failure,omitempty"` // Time of the last failure
 }
 
+// UnloadHooks are the on_unload commands of one .envrc, in the order it
+// registered them.
+type UnloadHooks struct {
+	Path     string   `json:"path"`
+	Commands []string `json:"commands"`
+}
+
+// MaxErrorLen bounds the error message kept by RecordFailure.
+const MaxErrorLen = 500
+
+// dataDirEnv moves the state directory along with the allow store
+// (allow.DataDirEnv).
+const dataDirEnv = "CASCADE_DATA_DIR"
+
 // NewStore creates a state store, creating the directory if needed.
-// Uses $XDG_DATA_HOME/cascade/state/ or ~/.local/share/cascade/state/.
+// Uses $CASCADE_DATA_DIR/state/ if set, else $XDG_DATA_HOME/cascade/state/
+// or ~/.local/share/cascade/state/.
 func NewStore() (*Store, error) {
-	dataHome := os.Getenv("XDG_DATA_HOME")
-	if dataHome == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("get home directory: %w", err)
-		}
-		dataHome = filepath.Join(home, ".local", "share")
+	if dir := os.Getenv(dataDirEnv); dir != "" {
+		return NewStoreWithDir(filepath.Join(dir, "state"))
+	}
+
+	dataHome, err := xdg.DataHome()
+	if err != nil {
+		return nil, err
 	}
 
 	stateDir := filepath.Join(dataHome, "cascade", "state")
@@ -53,9 +83,16 @@ func NewStoreWithDir(dir string) (*Store, error) {
 	return &Store{dir: dir}, nil
 }
 
-// Save persists the diff applied for an .envrc file.
-// Uses path hash as filename: <state-dir>/<sha256(path)>.json
+// Save persists the diff applied for an .envrc file, clearing any recorded
+// failures. Uses path hash as filename: <state-dir>/<sha256(path)>.json
 func (s *Store) Save(rcPath string, contentHash string, diff *env.EnvDiff) error {
+	return s.SaveChain(rcPath, contentHash, diff, nil, nil)
+}
+
+// SaveChain is Save, also recording the ancestor files each level of the
+// chain pulled in with source_up and the commands levels registered with
+// on_unload.
+func (s *Store) SaveChain(rcPath string, contentHash string, diff *env.EnvDiff, sourced map[string][]string, unload []UnloadHooks) error {
 	absPath, err := filepath.Abs(rcPath)
 	if err != nil {
 		return fmt.Errorf("resolve path: %w", err)
@@ -66,14 +103,52 @@ func (s *Store) Save(rcPath string, contentHash string, diff *env.EnvDiff) error
 		ContentHash: contentHash,
 		Diff:        diff,
 		Timestamp:   time.Now(),
+		Sourced:     sourced,
+		Unload:      unload,
+	}
+
+	return s.write(state)
+}
+
+// RecordFailure counts a failed evaluation of the chain ending at rcPath,
+// keeping the previously saved diff and success time. failedPath is the
+// .envrc that failed and errMsg its (already redacted) error, which is
+// truncated to MaxErrorLen bytes. Returns the updated state.
+func (s *Store) RecordFailure(rcPath, failedPath, errMsg string) (*DirState, error) {
+	absPath, err := filepath.Abs(rcPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve path: %w", err)
 	}
 
+	state, err := s.Load(absPath)
+	if err != nil || state == nil {
+		// Unreadable state is replaced; there is no diff worth keeping
+		state = &DirState{Path: absPath}
+	}
+
+	if len(errMsg) > MaxErrorLen {
+		errMsg = strings.ToValidUTF8(errMsg[:MaxErrorLen], "") + "..."
+	}
+
+	state.Failures++
+	state.FailedPath = failedPath
+	state.LastError = errMsg
+	state.LastFailure = time.Now()
+
+	if err := s.write(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// write atomically replaces the state file for state.Path.
+func (s *Store) write(state *DirState) error {
 	data, err := json.Marshal(state)
 	if err != nil {
 		return fmt.Errorf("marshal state: %w", err)
 	}
 
-	pathHash := hashPath(absPath)
+	pathHash := hashPath(state.Path)
 	stateFile := filepath.Join(s.dir, pathHash+".json")
 	tmpFile := stateFile + ".tmp"
 
@@ -136,6 +211,59 @@ func (s *Store) Delete(rcPath string) error {
 	return nil
 }
 
+// CorruptFile is a state file List could not read or decode.
+type CorruptFile struct {
+	File string // Path of the state file
+	Err  error
+}
+
+// List returns every stored state, sorted by .envrc path. Files that cannot
+// be read or decoded are skipped and returned as corrupt, so that one bad
+// file does not hide the others; err is only set if the directory itself
+// cannot be read.
+func (s *Store) List() (states []*DirState, corrupt []CorruptFile, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read state directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue // Not a state file, such as a leftover .tmp
+		}
+		file := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			corrupt = append(corrupt, CorruptFile{File: file, Err: err})
+			continue
+		}
+		var state DirState
+		if err := json.Unmarshal(data, &state); err != nil {
+			corrupt = append(corrupt, CorruptFile{File: file, Err: err})
+			continue
+		}
+		if state.Path == "" {
+			corrupt = append(corrupt, CorruptFile{File: file, Err: errors.New("no .envrc path")})
+			continue
+		}
+		states = append(states, &state)
+	}
+
+	slices.SortFunc(states, func(a, b *DirState) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return states, corrupt, nil
+}
+
+// LastUsed returns when the state was last written: its last successful
+// evaluation or its last failure, whichever is later.
+func (d *DirState) LastUsed() time.Time {
+	if d.LastFailure.After(d.Timestamp) {
+		return d.LastFailure
+	}
+	return d.Timestamp
+}
+
 // hashPath computes SHA256 of the absolute path.
 func hashPath(absPath string) string {
 	h := sha256.New()
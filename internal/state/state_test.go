@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/unrss/cascade/internal/env"
 )
@@ -67,6 +69,24 @@ func TestNewStore_UsesXDGDataHome(t *testing.T) {
 	}
 }
 
+func TestNewStore_UsesCascadeDataDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("CASCADE_DATA_DIR", filepath.Join(dir, "data"))
+
+	if _, err := NewStore(); err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	// State moves along with the allow store
+	if info, err := os.Stat(filepath.Join(dir, "data", "state")); err != nil || !info.IsDir() {
+		t.Errorf("state dir not created under CASCADE_DATA_DIR: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "xdg")); err == nil {
+		t.Error("XDG_DATA_HOME used although CASCADE_DATA_DIR is set")
+	}
+}
+
 func TestNewStore_FallsBackToLocalShare(t *testing.T) {
 	// Create a temp home directory
 	dir := t.TempDir()
@@ -736,6 +756,113 @@ func TestRelativePath_ResolvedToAbsolute(t *testing.T) {
 	}
 }
 
+func TestRecordFailure_CountsUntilSave(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewStoreWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStoreWithDir: %v", err)
+	}
+
+	diff := &env.EnvDiff{Prev: map[string]string{}, Next: map[string]string{"FOO": "bar"}}
+	if err := store.Save("/project/.envrc", "hash", diff); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	saved, _ := store.Load("/project/.envrc")
+
+	for i := 1; i <= 3; i++ {
+		state, err := store.RecordFailure("/project/.envrc", "/project/.envrc", "exit status 1")
+		if err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if state.Failures != i {
+			t.Errorf("Failures = %d, want %d", state.Failures, i)
+		}
+	}
+
+	state, _ := store.Load("/project/.envrc")
+	if !state.Timestamp.Equal(saved.Timestamp) || state.Diff.Next["FOO"] != "bar" {
+		t.Errorf("RecordFailure lost the saved diff or success time: %+v", state)
+	}
+
+	if err := store.Save("/project/.envrc", "hash2", diff); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	state, _ = store.Load("/project/.envrc")
+	if state.Failures != 0 || state.LastError != "" {
+		t.Errorf("Save did not clear failures: %+v", state)
+	}
+}
+
+func TestRecordFailure_BoundsError(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewStoreWithDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStoreWithDir: %v", err)
+	}
+
+	state, err := store.RecordFailure("/project/.envrc", "/project/.envrc", strings.Repeat("x", 10*MaxErrorLen))
+	if err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	if len(state.LastError) > MaxErrorLen+len("...") {
+		t.Errorf("LastError length = %d, want at most %d", len(state.LastError), MaxErrorLen+len("..."))
+	}
+	if !state.Timestamp.IsZero() {
+		t.Errorf("Timestamp = %v, want zero for a chain that never loaded", state.Timestamp)
+	}
+}
+
+func TestList_SkipsCorruptFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewStoreWithDir(dir)
+	if err != nil {
+		t.Fatalf("NewStoreWithDir: %v", err)
+	}
+
+	diff := &env.EnvDiff{Prev: map[string]string{}, Next: map[string]string{"FOO": "bar"}}
+	for _, path := range []string{"/b/.envrc", "/a/.envrc"} {
+		if err := store.Save(path, "hash", diff); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	corruptFile := filepath.Join(dir, testHashPath("/c/.envrc")+".json")
+	if err := os.WriteFile(corruptFile, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "x.json.tmp"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	states, corrupt, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(states) != 2 || states[0].Path != "/a/.envrc" || states[1].Path != "/b/.envrc" {
+		t.Errorf("List states = %+v, want /a/.envrc and /b/.envrc in order", states)
+	}
+	if len(corrupt) != 1 || corrupt[0].File != corruptFile || corrupt[0].Err == nil {
+		t.Errorf("List corrupt = %+v, want only %s", corrupt, corruptFile)
+	}
+}
+
+func TestDirState_LastUsed(t *testing.T) {
+	t.Parallel()
+
+	saved := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := &DirState{Timestamp: saved}
+	if got := state.LastUsed(); !got.Equal(saved) {
+		t.Errorf("LastUsed = %v, want the save time %v", got, saved)
+	}
+	state.LastFailure = saved.Add(time.Hour)
+	if got := state.LastUsed(); !got.Equal(state.LastFailure) {
+		t.Errorf("LastUsed = %v, want the later failure %v", got, state.LastFailure)
+	}
+}
+
 // testHashPath is a test helper that mirrors the internal hashPath function.
 func testHashPath(absPath string) string {
 	h := sha256.New()
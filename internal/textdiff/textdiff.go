@@ -0,0 +1,192 @@
+// Package textdiff produces line-based unified diffs of small text files.
+package textdiff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// context is how many unchanged lines surround each change in a hunk.
+const context = 3
+
+// opKind is what an edit does to a line.
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// edit is one line of an edit script turning old into new.
+type edit struct {
+	kind opKind
+	line string // Including its newline, if it had one
+}
+
+// Unified returns the unified diff turning old into new, with oldName and
+// newName in the --- and +++ header lines, or "" if they are equal. A last
+// line without a newline is marked as in diff(1).
+func Unified(oldName, newName, old, new string) string {
+	if old == new {
+		return ""
+	}
+	edits := diffLines(splitLines(old), splitLines(new))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(edits) {
+		writeHunk(&sb, edits, h)
+	}
+	return sb.String()
+}
+
+// splitLines splits s after each newline, keeping the newlines, so a missing
+// final newline is a difference.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script from a to b, using Myers'
+// O(ND) algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	limit := n + m
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+
+	// trace[d] is v as it was before round d, for walking back the path
+	var trace [][]int
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insert b[y]
+			} else {
+				x = v[offset+k-1] + 1 // Right: delete a[x]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{opEqual, a[x]})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{opInsert, b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{opDelete, a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		edits = append(edits, edit{opEqual, a[x]})
+	}
+	slices.Reverse(edits)
+	return edits
+}
+
+// hunk is a range of edits, changes with their surrounding context.
+type hunk struct {
+	start, end int // edits[start:end]
+}
+
+// hunks groups the changes in edits, joining changes whose context would
+// overlap.
+func hunks(edits []edit) []hunk {
+	var hs []hunk
+	for i, e := range edits {
+		if e.kind == opEqual {
+			continue
+		}
+		start := max(i-context, 0)
+		end := min(i+1+context, len(edits))
+		if len(hs) > 0 && start <= hs[len(hs)-1].end {
+			hs[len(hs)-1].end = end
+			continue
+		}
+		hs = append(hs, hunk{start, end})
+	}
+	return hs
+}
+
+// writeHunk writes the header and lines of h.
+func writeHunk(sb *strings.Builder, edits []edit, h hunk) {
+	// Line numbers are 1-based positions in old and new where h starts
+	oldLine, newLine := 1, 1
+	for _, e := range edits[:h.start] {
+		if e.kind != opInsert {
+			oldLine++
+		}
+		if e.kind != opDelete {
+			newLine++
+		}
+	}
+	var oldCount, newCount int
+	for _, e := range edits[h.start:h.end] {
+		if e.kind != opInsert {
+			oldCount++
+		}
+		if e.kind != opDelete {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+	for _, e := range edits[h.start:h.end] {
+		prefix := " "
+		switch e.kind {
+		case opDelete:
+			prefix = "-"
+		case opInsert:
+			prefix = "+"
+		}
+		sb.WriteString(prefix + e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats one side of a hunk header. An empty side names the line
+// before it, as in diff(1).
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
@@ -0,0 +1,89 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"changed line",
+			"a\nb\nc\n", "a\nB\nc\n",
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"from empty",
+			"", "a\nb\n",
+			"@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			"to empty",
+			"a\n", "",
+			"@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			"missing final newline",
+			"a\nb\n", "a\nb",
+			"@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "1\nX\n3\n4\n5\n6\n7\n8\n9\n10\n11\nY\n",
+			"@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+Y\n",
+		},
+		{
+			"nearby changes share a hunk",
+			"1\n2\n3\n4\n5\n6\n7\n8\n", "X\n2\n3\n4\n5\n6\n7\nY\n",
+			"@@ -1,8 +1,8 @@\n-1\n+X\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+Y\n",
+		},
+		{
+			"insertion in the middle",
+			"a\nb\nc\nd\n", "a\nb\nnew\nc\nd\n",
+			"@@ -1,4 +1,5 @@\n a\n b\n+new\n c\n d\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("old", "new", tt.old, tt.new)
+			want := tt.want
+			if want != "" {
+				want = "--- old\n+++ new\n" + want
+			}
+			if got != want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestUnified_Apply checks that applying the diff's lines to old gives new
+// for edits the table does not spell out.
+func TestUnified_Apply(t *testing.T) {
+	pairs := [][2]string{
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n"},
+		{"x\n", "y\n"},
+		{"same\nsame\nsame\n", "same\n"},
+	}
+	for _, p := range pairs {
+		edits := diffLines(splitLines(p[0]), splitLines(p[1]))
+		var oldText, newText strings.Builder
+		for _, e := range edits {
+			if e.kind != opInsert {
+				oldText.WriteString(e.line)
+			}
+			if e.kind != opDelete {
+				newText.WriteString(e.line)
+			}
+		}
+		if oldText.String() != p[0] || newText.String() != p[1] {
+			t.Errorf("edits for %q -> %q rebuild %q -> %q", p[0], p[1], oldText.String(), newText.String())
+		}
+	}
+}
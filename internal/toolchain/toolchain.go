@@ -0,0 +1,221 @@
+// Package toolchain finds language runtimes installed by version managers,
+// for the stdlib's use_node, use_go and use_python helpers.
+//
+// Each manager keeps one directory per installed version: nvm in
+// $NVM_DIR/versions/node/v18.17.0, mise in
+// $MISE_DATA_DIR/installs/node/18.17.0, goenv and pyenv in
+// $GOENV_ROOT/versions/1.22.1 and $PYENV_ROOT/versions/3.12.2, and
+// golang.org/dl in ~/sdk/go1.22.1. Find picks the newest of them matching a
+// version constraint.
+package toolchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Tools are the tools Dirs knows the managers of.
+var Tools = []string{"node", "go", "python"}
+
+// Install is an installed version of a tool.
+type Install struct {
+	Name    string // Directory name, as the manager spells the version
+	Root    string // The version's directory, with bin/ in it (GOROOT for go)
+	version []int
+}
+
+// Dirs returns the directories that hold one subdirectory per installed
+// version of tool, in order of preference between equal versions. home is
+// the home directory, and getenv looks up the managers' variables.
+func Dirs(tool, home string, getenv func(string) string) ([]string, error) {
+	orHome := func(name string, rel ...string) string {
+		if dir := getenv(name); dir != "" {
+			return dir
+		}
+		return filepath.Join(append([]string{home}, rel...)...)
+	}
+	mise := getenv("MISE_DATA_DIR")
+	if mise == "" {
+		if data := getenv("XDG_DATA_HOME"); data != "" {
+			mise = filepath.Join(data, "mise")
+		} else {
+			mise = filepath.Join(home, ".local", "share", "mise")
+		}
+	}
+
+	switch tool {
+	case "node":
+		return []string{
+			filepath.Join(orHome("NVM_DIR", ".nvm"), "versions", "node"),
+			filepath.Join(mise, "installs", "node"),
+		}, nil
+	case "go":
+		return []string{
+			filepath.Join(orHome("GOENV_ROOT", ".goenv"), "versions"),
+			filepath.Join(mise, "installs", "go"),
+			filepath.Join(home, "sdk"),
+		}, nil
+	case "python":
+		return []string{
+			filepath.Join(orHome("PYENV_ROOT", ".pyenv"), "versions"),
+			filepath.Join(mise, "installs", "python"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown tool %q (want %s)", tool, strings.Join(Tools, ", "))
+}
+
+// Find returns the newest install in dirs matching constraint (see
+// ParseConstraint), or nil if there is none. Directories that do not exist
+// are skipped, as are entries that are symlinks (mise's aliases such as 18
+// or latest), have no bin/ directory, or are not named by a plain version
+// such as 18.17.0, v18.17.0 or go1.22.1 (prereleases and other builds).
+func Find(dirs []string, constraint string) (*Install, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *Install
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSymlink != 0 || !entry.IsDir() {
+				continue
+			}
+			version, ok := parseVersion(entry.Name())
+			if !ok || !c.matches(version) {
+				continue
+			}
+			root := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(filepath.Join(root, "bin")); err != nil || !info.IsDir() {
+				continue
+			}
+			if best == nil || compareVersions(version, best.version) > 0 {
+				best = &Install{Name: entry.Name(), Root: root, version: version}
+			}
+		}
+	}
+	return best, nil
+}
+
+// Constraint selects versions.
+type Constraint struct {
+	op      string // "", "=", ">", ">=", "<", "<=", "^" or "~"
+	version []int
+}
+
+// ParseConstraint parses a version constraint:
+//
+//	18, 18.17, 18.x    versions starting with these components
+//	=18.17.0           exactly this version
+//	>=18.2, >18, <20, <=18.17
+//	^18.2              18.2 or later with the same major version
+//	~18.2              18.2 or later with the same major and minor version
+//	latest, *          any version
+//
+// A leading v or go on the version is ignored.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "latest" || s == "*" {
+		return Constraint{}, nil
+	}
+	var c Constraint
+	for _, op := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if rest, ok := strings.CutPrefix(s, op); ok {
+			c.op, s = op, strings.TrimSpace(rest)
+			break
+		}
+	}
+	if c.op == "" {
+		for _, wildcard := range []string{".x", ".X", ".*"} {
+			for strings.HasSuffix(s, wildcard) {
+				s = strings.TrimSuffix(s, wildcard)
+			}
+		}
+	}
+	version, ok := parseVersion(s)
+	if !ok {
+		return Constraint{}, fmt.Errorf("invalid version constraint %q", s)
+	}
+	c.version = version
+	return c, nil
+}
+
+// matches reports whether version satisfies c.
+func (c Constraint) matches(version []int) bool {
+	if c.version == nil {
+		return true
+	}
+	cmp := compareVersions(version, c.version)
+	switch c.op {
+	case "":
+		return len(version) >= len(c.version) && slices.Equal(version[:len(c.version)], c.version)
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "^":
+		return cmp >= 0 && version[0] == c.version[0]
+	case "~":
+		n := min(2, len(c.version))
+		return cmp >= 0 && len(version) >= n && slices.Equal(version[:n], c.version[:n])
+	}
+	return false
+}
+
+// parseVersion parses a version such as 18.17.0, v18.17.0 or go1.22.1
+// into its numeric components.
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "go"), "v")
+	if s == "" {
+		return nil, false
+	}
+	parts := strings.Split(s, ".")
+	version := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || strings.HasPrefix(part, "+") {
+			return nil, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+// compareVersions compares a and b component by component, missing
+// components counting as zero.
+func compareVersions(a, b []int) int {
+	for i := range max(len(a), len(b)) {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
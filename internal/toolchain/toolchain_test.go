@@ -0,0 +1,114 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeInstalls creates dir/name/bin for each name.
+func makeInstalls(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(dir, name, "bin"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	nvm := t.TempDir()
+	mise := t.TempDir()
+	makeInstalls(t, nvm, "v16.20.2", "v18.17.0", "v18.9.1", "v20.5.0")
+	makeInstalls(t, mise, "18.19.1", "21.0.0-rc.1", "lts")
+	// Without bin/ it is not a usable install
+	if err := os.MkdirAll(filepath.Join(mise, "22.1.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// mise's alias links are skipped
+	if err := os.Symlink(filepath.Join(nvm, "v16.20.2"), filepath.Join(mise, "23")); err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{nvm, filepath.Join(t.TempDir(), "missing"), mise}
+
+	for _, tt := range []struct {
+		constraint string
+		want       string // Directory name, "" for no match
+	}{
+		{"18", "18.19.1"},
+		{"18.17", "v18.17.0"},
+		{"v18.9", "v18.9.1"},
+		{"18.x", "18.19.1"},
+		{"=18.17.0", "v18.17.0"},
+		{">=18.10", "v20.5.0"},
+		{"<18", "v16.20.2"},
+		{"<=18.17.0", "v18.17.0"},
+		{">20.5.0", ""},
+		{"^18.10", "18.19.1"},
+		{"~18.9", "v18.9.1"},
+		{"latest", "v20.5.0"},
+		{"", "v20.5.0"},
+		{"17", ""},
+		{"1", ""},
+		{"22", ""},
+		{"23", ""},
+	} {
+		install, err := Find(dirs, tt.constraint)
+		if err != nil {
+			t.Errorf("Find(%q): %v", tt.constraint, err)
+			continue
+		}
+		var got string
+		if install != nil {
+			got = install.Name
+		}
+		if got != tt.want {
+			t.Errorf("Find(%q) = %q, want %q", tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestFind_Go(t *testing.T) {
+	sdk := t.TempDir()
+	makeInstalls(t, sdk, "go1.21.13", "go1.22.1", "gotip")
+
+	install, err := Find([]string{sdk}, "1.21")
+	if err != nil || install == nil {
+		t.Fatalf("Find(1.21) = %v, %v", install, err)
+	}
+	if want := filepath.Join(sdk, "go1.21.13"); install.Root != want {
+		t.Errorf("Root = %q, want %q", install.Root, want)
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, s := range []string{"lts", "18.x.1", ">=", "3.12.0rc1", "-1"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q) should fail", s)
+		}
+	}
+}
+
+func TestDirs(t *testing.T) {
+	env := map[string]string{"NVM_DIR": "/opt/nvm", "XDG_DATA_HOME": "/data"}
+	dirs, err := Dirs("node", "/home/u", func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/opt/nvm/versions/node", "/data/mise/installs/node"}
+	if len(dirs) != len(want) || dirs[0] != want[0] || dirs[1] != want[1] {
+		t.Errorf("Dirs(node) = %q, want %q", dirs, want)
+	}
+
+	dirs, err = Dirs("python", "/home/u", func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/home/u/.pyenv/versions"; dirs[0] != want {
+		t.Errorf("Dirs(python)[0] = %q, want %q", dirs[0], want)
+	}
+
+	if _, err := Dirs("ruby", "/home/u", func(string) string { return "" }); err == nil {
+		t.Error("Dirs(ruby) should fail")
+	}
+}
@@ -0,0 +1,41 @@
+// Package xdg locates the base directories cascade keeps its data and
+// cache in. The XDG variables win everywhere; without them, Unix uses the
+// XDG defaults under the home directory and Windows the user's application
+// data directories.
+package xdg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DataHome returns $XDG_DATA_HOME, or ~/.local/share (%AppData% on
+// Windows). The allow store and state live in its cascade directory.
+func DataHome() (string, error) {
+	return baseDir("XDG_DATA_HOME", os.UserConfigDir, ".local", "share")
+}
+
+// CacheHome returns $XDG_CACHE_HOME, or ~/.cache (%LocalAppData% on
+// Windows).
+func CacheHome() (string, error) {
+	return baseDir("XDG_CACHE_HOME", os.UserCacheDir, ".cache")
+}
+
+// baseDir returns the directory named by the variable name, or its default:
+// what windowsDir returns on Windows, rel under the home directory
+// elsewhere.
+func baseDir(name string, windowsDir func() (string, error), rel ...string) (string, error) {
+	if dir := os.Getenv(name); dir != "" {
+		return dir, nil
+	}
+	if runtime.GOOS == "windows" {
+		return windowsDir()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(append([]string{home}, rel...)...), nil
+}
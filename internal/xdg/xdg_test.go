@@ -0,0 +1,36 @@
+package xdg
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestHomes(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_DATA_HOME", "/xdg/data")
+	t.Setenv("XDG_CACHE_HOME", "/xdg/cache")
+
+	if got, err := DataHome(); err != nil || got != "/xdg/data" {
+		t.Errorf("DataHome() = %q, %v; want $XDG_DATA_HOME", got, err)
+	}
+	if got, err := CacheHome(); err != nil || got != "/xdg/cache" {
+		t.Errorf("CacheHome() = %q, %v; want $XDG_CACHE_HOME", got, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return // See TestHomes_Windows
+	}
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	if got, err := DataHome(); err != nil || got != filepath.Join(home, ".local", "share") {
+		t.Errorf("DataHome() = %q, %v; want ~/.local/share", got, err)
+	}
+	if got, err := CacheHome(); err != nil || got != filepath.Join(home, ".cache") {
+		t.Errorf("CacheHome() = %q, %v; want ~/.cache", got, err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package xdg
+
+import "testing"
+
+func TestHomes_Windows(t *testing.T) {
+	// Cannot use t.Parallel() with t.Setenv()
+
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("APPDATA", `C:\Users\Me\AppData\Roaming`)
+	t.Setenv("LOCALAPPDATA", `C:\Users\Me\AppData\Local`)
+
+	if got, err := DataHome(); err != nil || got != `C:\Users\Me\AppData\Roaming` {
+		t.Errorf("DataHome() = %q, %v; want %%AppData%%", got, err)
+	}
+	if got, err := CacheHome(); err != nil || got != `C:\Users\Me\AppData\Local` {
+		t.Errorf("CacheHome() = %q, %v; want %%LocalAppData%%", got, err)
+	}
+}
@@ -19,7 +19,7 @@
 # 3. Go spawns bash, reads fd 3 for structured data, fd 1+2 for user feedback
 #
 # Flow:
-#   Go spawns: bash -c 'source stdlib.sh; __main__ /path/to/.envrc' 3>&1 1>&2
+#   Go spawns: bash -c 'source stdlib.sh; __main__ /path/to/.envrc SNAPSHOT' 3>&1 1>&2
 #   __main__:  Sets up trap, sources .envrc
 #   .envrc:    Runs user code, echo goes to terminal (fd 1 → fd 2 → terminal)
 #   EXIT trap: Calls __dump_at_exit, JSON goes to fd 3 → Go's stdin
@@ -27,6 +27,8 @@
 # Environment variables set by Go before spawning:
 #   CASCADE_BIN  - Absolute path to the cascade binary
 #   CASCADE_DIR  - Directory containing the current .envrc being evaluated
+#   CASCADE_DUMP_FORMAT - json-v2: frame the JSON dump with a NUL-separated
+#                  KEY=VALUE copy that Go falls back on if the JSON is bad
 #
 # =============================================================================
 
@@ -37,30 +39,56 @@ set -euo pipefail
 # -----------------------------------------------------------------------------
 
 # Entry point called by Go. Sets up fd redirection and sources the .envrc.
+# Usage: __main__ <envrc> [<copy>] [<level dir>]
+# <copy> is the approved content to source in place of <envrc>, and
+# <level dir> is the directory the file belongs to in the chain (the parent
+# of envrc.d for a fragment); both default to <envrc> and its directory.
 __main__() {
     local envrc_file="${1:-}"
+    local source_file="${2:-$envrc_file}"
+    local level_dir="${3:-}"
 
     if [[ -z "$envrc_file" ]]; then
         log_error "no .envrc file specified"
         exit 1
     fi
 
-    if [[ ! -f "$envrc_file" ]]; then
+    if [[ ! -f "$source_file" ]]; then
         log_error "file not found: $envrc_file"
         exit 1
     fi
 
-    # Set CASCADE_DIR to the directory containing this .envrc
+    # Set CASCADE_DIR to the directory of this level of the chain
     # This is used by path helpers and source_env for relative path resolution
     export CASCADE_DIR
-    CASCADE_DIR="$(cd "$(dirname "$envrc_file")" && pwd)"
+    CASCADE_DIR="$(cd "${level_dir:-$(dirname "$envrc_file")}" && pwd)"
+
+    # The files being sourced, outermost first, for source_env to record
+    # what sources what and to detect cycles (not exported)
+    __cascade_sourcing="$envrc_file"
 
     # Set up exit trap to dump environment as JSON
     trap __dump_at_exit EXIT
 
-    # Source the .envrc file
+    # Source the .envrc under its own name, so that BASH_SOURCE and bash's
+    # error messages name it, as long as it holds exactly the verified copy;
+    # if it changed since, or its line endings were normalized, source the copy
+    if [[ "$source_file" != "$envrc_file" ]] && __matches_snapshot "$envrc_file" "$source_file"; then
+        source_file="$envrc_file"
+    fi
     # shellcheck source=/dev/null
-    source "$envrc_file"
+    source "$source_file"
+}
+
+# Succeeds if FILE holds exactly the content of SNAPSHOT, the verified copy
+# of it. Files containing a NUL byte never match: read stops there.
+# Usage: __matches_snapshot FILE SNAPSHOT
+__matches_snapshot() {
+    local approved="" current=""
+    [[ -f "$1" ]] || return 1
+    IFS= read -r -d '' approved <"$2" && return 1
+    IFS= read -r -d '' current 2>/dev/null <"$1" && return 1
+    [[ "$current" == "$approved" ]]
 }
 
 # Exit trap handler. Outputs current environment as JSON to fd 3.
@@ -98,10 +126,38 @@ log_error() {
 # Path Manipulation Functions
 # -----------------------------------------------------------------------------
 
+# Print DIR as the path helpers add it: resolved relative to CASCADE_DIR,
+# and canonical (no . or .. components) if it exists. A directory that does
+# not exist is noted on stderr; with CASCADE_STRICT_PATH_ADD=1 (exported by
+# an .envrc, such as the one at the cascade root) it is not added either,
+# and this fails.
+# Usage: __path_dir CALLER DIR
+__path_dir() {
+    local caller="$1" dir="$2"
+
+    # Resolve relative paths against CASCADE_DIR
+    if [[ "$dir" != /* ]]; then
+        dir="${CASCADE_DIR:-$PWD}/$dir"
+    fi
+
+    # Canonicalize the path (remove . and ..)
+    if [[ -d "$dir" ]]; then
+        dir="$(cd "$dir" && pwd)"
+    elif [[ "${CASCADE_STRICT_PATH_ADD:-}" == "1" ]]; then
+        log_status "$caller: not adding $dir: directory does not exist"
+        return 1
+    else
+        log_status "$caller: $dir does not exist (adding it anyway)"
+    fi
+
+    printf '%s\n' "$dir"
+}
+
 # Prepend a directory to PATH.
 # Usage: PATH_add <dir>
 # If <dir> is relative, it's resolved relative to CASCADE_DIR.
-# Does nothing if the directory is already in PATH.
+# Does nothing if the directory is already in PATH. A directory that does
+# not exist is noted, and skipped with CASCADE_STRICT_PATH_ADD=1.
 PATH_add() {
     local dir="${1:-}"
 
@@ -110,15 +166,7 @@ PATH_add() {
         return 1
     fi
 
-    # Resolve relative paths against CASCADE_DIR
-    if [[ "$dir" != /* ]]; then
-        dir="${CASCADE_DIR:-$PWD}/$dir"
-    fi
-
-    # Canonicalize the path (resolve symlinks, remove . and ..)
-    if [[ -d "$dir" ]]; then
-        dir="$(cd "$dir" && pwd)"
-    fi
+    dir="$(__path_dir PATH_add "$dir")" || return 0
 
     # Check if already in PATH (exact match)
     case ":${PATH}:" in
@@ -135,7 +183,8 @@ PATH_add() {
 # Append a directory to PATH.
 # Usage: path_add <dir>
 # If <dir> is relative, it's resolved relative to CASCADE_DIR.
-# Does nothing if the directory is already in PATH.
+# Does nothing if the directory is already in PATH. Missing directories are
+# handled as by PATH_add.
 path_add() {
     local dir="${1:-}"
 
@@ -144,15 +193,7 @@ path_add() {
         return 1
     fi
 
-    # Resolve relative paths against CASCADE_DIR
-    if [[ "$dir" != /* ]]; then
-        dir="${CASCADE_DIR:-$PWD}/$dir"
-    fi
-
-    # Canonicalize the path (resolve symlinks, remove . and ..)
-    if [[ -d "$dir" ]]; then
-        dir="$(cd "$dir" && pwd)"
-    fi
+    dir="$(__path_dir path_add "$dir")" || return 0
 
     # Check if already in PATH (exact match)
     case ":${PATH}:" in
@@ -233,11 +274,14 @@ source_env() {
         return 1
     fi
 
+    __source_env_enter source_env "$envrc_file" || return 0
+
     # Check if the target .envrc is allowed
     # CASCADE_BIN must be set by Go before spawning
     if [[ -n "${CASCADE_BIN:-}" ]]; then
-        if ! "$CASCADE_BIN" status "$envrc_file" >/dev/null 2>&1; then
+        if ! "$CASCADE_BIN" check --silent "$envrc_file"; then
             log_error "source_env: $envrc_file is not allowed (run: cascade allow $envrc_file)"
+            __source_env_leave
             return 1
         fi
     fi
@@ -252,6 +296,44 @@ source_env() {
 
     # Restore CASCADE_DIR
     export CASCADE_DIR="$saved_cascade_dir"
+    __source_env_leave
+}
+
+# Record in CASCADE_SOURCED_ENV that the file being sourced sources FILE,
+# one "SOURCING<tab>FILE" line per call, for cascade tree. Fails, after
+# recording the line with a "<tab>cycle" suffix, if FILE is already being
+# sourced: sourcing it again would never end.
+# Usage: __source_env_enter CALLER FILE
+__source_env_enter() {
+    local caller="$1" file="$2"
+    local entry="${__cascade_sourcing:-}" cycle=0
+    entry="${entry##*$'\n'}"$'\t'"$file"
+
+    case $'\n'"${__cascade_sourcing:-}"$'\n' in
+        *$'\n'"$file"$'\n'*)
+            entry="$entry"$'\t'"cycle"
+            cycle=1
+            ;;
+    esac
+
+    if [[ -n "${CASCADE_SOURCED_ENV:-}" ]]; then
+        CASCADE_SOURCED_ENV="$CASCADE_SOURCED_ENV"$'\n'"$entry"
+    else
+        CASCADE_SOURCED_ENV="$entry"
+    fi
+    export CASCADE_SOURCED_ENV
+
+    if [[ "$cycle" -eq 1 ]]; then
+        log_error "$caller: skipping $file: it is already being sourced (cycle)"
+        return 1
+    fi
+    __cascade_sourcing="${__cascade_sourcing:-}"$'\n'"$file"
+}
+
+# Pop the file __source_env_enter pushed.
+# Usage: __source_env_leave
+__source_env_leave() {
+    __cascade_sourcing="${__cascade_sourcing%$'\n'*}"
 }
 
 # -----------------------------------------------------------------------------
@@ -270,15 +352,7 @@ pathprepend() {
         return 1
     fi
 
-    # Resolve relative paths against CASCADE_DIR
-    if [[ "$dir" != /* ]]; then
-        dir="${CASCADE_DIR:-$PWD}/$dir"
-    fi
-
-    # Canonicalize the path
-    if [[ -d "$dir" ]]; then
-        dir="$(cd "$dir" && pwd)"
-    fi
+    dir="$(__path_dir pathprepend "$dir")" || return 0
 
     local current_value="${!varname:-}"
 
@@ -305,15 +379,7 @@ pathappend() {
         return 1
     fi
 
-    # Resolve relative paths against CASCADE_DIR
-    if [[ "$dir" != /* ]]; then
-        dir="${CASCADE_DIR:-$PWD}/$dir"
-    fi
-
-    # Canonicalize the path
-    if [[ -d "$dir" ]]; then
-        dir="$(cd "$dir" && pwd)"
-    fi
+    dir="$(__path_dir pathappend "$dir")" || return 0
 
     local current_value="${!varname:-}"
 
@@ -349,18 +415,144 @@ source_env_if_exists() {
     fi
 
     if [[ -f "$file" ]]; then
+        __source_env_enter source_env_if_exists "$file" || return 0
+
+        # Security check: only source allowed .envrc files
+        if [[ -n "${CASCADE_BIN:-}" ]]; then
+            if ! "$CASCADE_BIN" check --silent "$file"; then
+                log_error "source_env_if_exists: $file is not allowed (run: cascade allow $file)"
+                __source_env_leave
+                return 1
+            fi
+        fi
         # shellcheck source=/dev/null
         source "$file"
+        __source_env_leave
+    fi
+}
+
+# Source the nearest FILENAME (default .envrc) in a parent directory of the
+# current .envrc, stopping at the cascade root or the filesystem root.
+# Usage: source_up [FILENAME]
+#
+# Inside the cascade root, parent .envrc files are already part of the chain,
+# so finding one there ends the search without sourcing it again. This makes
+# source_up unnecessary (but harmless) in most migrated .envrc files, and it
+# prints a note once per export saying so; it is for projects outside the
+# root, and for other file names.
+source_up() {
+    __source_up source_up 1 "${1:-.envrc}"
+}
+
+# Like source_up, but finding nothing is not an error.
+# Usage: source_up_if_exists [FILENAME]
+source_up_if_exists() {
+    __source_up source_up_if_exists 0 "${1:-.envrc}"
+}
+
+# Shared implementation of source_up and source_up_if_exists.
+# Usage: __source_up CALLER REQUIRED FILENAME
+__source_up() {
+    local caller="$1" required="$2" name="$3"
+
+    if [[ "$name" == */* ]]; then
+        log_error "$caller: expected a file name, not a path: $name"
+        return 1
+    fi
+
+    # Mark the evaluation: its result depends on files outside this .envrc,
+    # so it is not cached even when nothing is found
+    export CASCADE_SOURCED_FILES="${CASCADE_SOURCED_FILES:-}"
+
+    local dir root="" in_root=0
+    dir="$(cd "${CASCADE_DIR:-$PWD}" && pwd -P)" || return 1
+    if [[ -n "${CASCADE_ROOT_DIR:-}" ]] && [[ -d "$CASCADE_ROOT_DIR" ]]; then
+        root="$(cd "$CASCADE_ROOT_DIR" && pwd -P)"
+        if [[ "$dir" == "$root" || "$dir" == "${root%/}"/* ]]; then
+            in_root=1
+        fi
+    fi
+
+    local file=""
+    while [[ "$dir" != "/" ]] && [[ "$in_root" -eq 0 || "$dir" != "$root" ]]; do
+        dir="${dir%/*}"
+        dir="${dir:-/}"
+        if [[ -f "$dir/$name" ]]; then
+            file="$dir/$name"
+            break
+        fi
+    done
+
+    if [[ -z "$file" ]]; then
+        if [[ "$required" -eq 1 ]]; then
+            log_error "$caller: no $name found in a parent directory"
+            return 1
+        fi
+        return 0
+    fi
+
+    # A parent .envrc inside the root is already a level of the chain. Say
+    # so once per export rather than once per file: the marker is passed on
+    # to later levels but, like every CASCADE_ variable, never exported
+    if [[ "$in_root" -eq 1 && "$name" == ".envrc" ]]; then
+        if [[ -z "${CASCADE_SOURCE_UP_NOTED:-}" ]]; then
+            log_status "note: $caller in $CASCADE_DIR is not needed, parent .envrc files under the cascade root are loaded automatically"
+            export CASCADE_SOURCE_UP_NOTED=1
+        fi
+        return 0
+    fi
+
+    # Not allowed: warn and skip, like an unallowed level of the chain.
+    # Otherwise take a copy of the approved content and, as __main__ does,
+    # source the file only while it still matches the copy
+    local source_file="$file" snapshot=""
+    if [[ -n "${CASCADE_BIN:-}" ]]; then
+        if ! snapshot="$("$CASCADE_BIN" internal snapshot "$file" 2>/dev/null)"; then
+            log_error "$caller: skipping $file: not allowed (run: cascade allow $file)"
+            return 0
+        fi
+        if ! __matches_snapshot "$file" "$snapshot"; then
+            source_file="$snapshot"
+        fi
+    fi
+
+    # Reload when the ancestor changes, and report it for status and tree
+    if [[ -n "${CASCADE_EXTRA_WATCHES:-}" ]]; then
+        CASCADE_EXTRA_WATCHES="$CASCADE_EXTRA_WATCHES"$'\n'"$file"
+    else
+        CASCADE_EXTRA_WATCHES="$file"
+    fi
+    export CASCADE_EXTRA_WATCHES
+    if [[ -n "${CASCADE_SOURCED_FILES:-}" ]]; then
+        CASCADE_SOURCED_FILES="$CASCADE_SOURCED_FILES"$'\n'"$file"
+    else
+        CASCADE_SOURCED_FILES="$file"
+    fi
+    export CASCADE_SOURCED_FILES
+
+    local saved_cascade_dir="${CASCADE_DIR:-}"
+    export CASCADE_DIR="$dir"
+
+    # shellcheck source=/dev/null
+    source "$source_file"
+
+    export CASCADE_DIR="$saved_cascade_dir"
+    if [[ -n "$snapshot" ]]; then
+        rm -f "$snapshot"
     fi
 }
 
 # watch_file FILE...
 # Adds files to the watch list so cascade re-evaluates when they change.
-# Relative paths are resolved against CASCADE_DIR.
+# Relative paths are resolved against CASCADE_DIR. A FILE may be a glob
+# pattern, quoted so bash passes it on unexpanded: cascade then watches the
+# files it matches and their directory, so creating a matching file also
+# re-evaluates. A pattern that matches nothing watches the directory.
 #
 # Example:
 #   watch_file .env
 #   watch_file package.json requirements.txt
+#   watch_file 'config/*.yaml'
 #
 watch_file() {
     local file
@@ -392,15 +584,332 @@ watch_file() {
     done
 }
 
-# watch_dir DIR
-# Watches a directory for any file changes (uses directory mtime).
-# Directory mtime changes when files are added, removed, or renamed.
+# watch_dir DIR...
+# Watches directories for changes to their entries: cascade re-evaluates
+# when a file is added, removed, renamed, or modified directly inside DIR.
+# Subdirectories are not descended into. Relative paths are resolved
+# against CASCADE_DIR.
 #
 # Example:
-#   watch_dir config/
+#   watch_dir config
 #
 watch_dir() {
-    watch_file "$1"
+    local dir
+    for dir in "$@"; do
+        [[ -z "$dir" ]] && continue
+
+        if [[ "$dir" != /* ]]; then
+            dir="${CASCADE_DIR:-$PWD}/$dir"
+        fi
+        if [[ -d "$dir" ]]; then
+            dir="$(cd "$dir" && pwd)"
+        fi
+
+        # Add to CASCADE_WATCH_DIRS (newline-separated list)
+        if [[ -n "${CASCADE_WATCH_DIRS:-}" ]]; then
+            CASCADE_WATCH_DIRS="$CASCADE_WATCH_DIRS"$'\n'"$dir"
+        else
+            CASCADE_WATCH_DIRS="$dir"
+        fi
+    done
+    export CASCADE_WATCH_DIRS
+}
+
+# dotenv [FILE]
+# Exports the variables of a .env file (default .env). Relative paths are
+# resolved against CASCADE_DIR. The file is parsed by cascade, not sourced:
+# $VAR and $(cmd) are never expanded, and a malformed file is an error.
+# Cascade re-evaluates when the file changes.
+#
+# Example:
+#   dotenv
+#   dotenv config/dev.env
+#
+dotenv() {
+    __dotenv dotenv 1 "${1:-.env}"
+}
+
+# Like dotenv, but a missing file is not an error. Cascade still
+# re-evaluates when the file is created.
+# Usage: dotenv_if_exists [FILE]
+dotenv_if_exists() {
+    __dotenv dotenv_if_exists 0 "${1:-.env}"
+}
+
+# Shared implementation of dotenv and dotenv_if_exists.
+# Usage: __dotenv CALLER REQUIRED FILE
+__dotenv() {
+    local caller="$1" required="$2" file="$3"
+
+    # Resolve relative paths against CASCADE_DIR
+    if [[ "$file" != /* ]]; then
+        file="${CASCADE_DIR:-$PWD}/$file"
+    fi
+
+    # Reload when the file changes, is created, or is removed
+    if [[ -n "${CASCADE_EXTRA_WATCHES:-}" ]]; then
+        CASCADE_EXTRA_WATCHES="$CASCADE_EXTRA_WATCHES"$'\n'"$file"
+    else
+        CASCADE_EXTRA_WATCHES="$file"
+    fi
+    export CASCADE_EXTRA_WATCHES
+
+    if [[ ! -f "$file" ]]; then
+        if [[ "$required" -eq 1 ]]; then
+            log_error "$caller: $file not found"
+            return 1
+        fi
+        return 0
+    fi
+    if [[ -z "${CASCADE_BIN:-}" ]]; then
+        log_error "$caller: CASCADE_BIN is not set"
+        return 1
+    fi
+
+    local exports
+    if ! exports="$("$CASCADE_BIN" internal dotenv "$file")"; then
+        log_error "$caller: failed to load $file"
+        return 1
+    fi
+    eval "$exports"
+}
+
+# cache_output DURATION VAR -- COMMAND [ARGS...]
+# Runs COMMAND and exports its stdout as VAR, reusing the stored output for
+# DURATION (e.g. 30m, 1h) on later evaluations of this .envrc. Stored values
+# are discarded when the .envrc changes. Set CASCADE_REFRESH=1 to force a re-run.
+#
+# Example:
+#   cache_output 1h VAULT_TOKEN -- vault kv get -field=token secret/ci
+#
+cache_output() {
+    local duration="${1:-}" var="${2:-}"
+
+    if [[ -z "$duration" || -z "$var" || "${3:-}" != "--" || $# -lt 4 ]]; then
+        log_error "cache_output: usage: cache_output DURATION VAR -- COMMAND [ARGS...]"
+        return 1
+    fi
+    if [[ ! "$var" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+        log_error "cache_output: invalid variable name: $var"
+        return 1
+    fi
+    shift 3
+
+    # Sensitive values are never stored, so they are recomputed every time
+    local store=
+    if [[ -n "${CASCADE_BIN:-}" ]] && ! __is_sensitive "$var"; then
+        store=1
+    fi
+
+    local value
+    if [[ -z "${CASCADE_REFRESH:-}" && -n "$store" ]] &&
+        value="$("$CASCADE_BIN" internal kv get "$var" 2>/dev/null)"; then
+        export "$var=$value"
+        return 0
+    fi
+
+    if ! value="$("$@")"; then
+        log_error "cache_output: $1 failed"
+        return 1
+    fi
+    export "$var=$value"
+
+    if [[ -n "$store" ]]; then
+        printf '%s' "$value" | "$CASCADE_BIN" internal kv set "$var" "$duration" ||
+            log_error "cache_output: failed to store $var"
+    fi
+}
+
+# merge_var VAR SEPARATOR
+# Marks VAR as a SEPARATOR-delimited list for the rest of the chain. Each
+# .envrc's additions and removals are merged (deduplicated, in chain order)
+# instead of the last value winning, and leaving the directory removes only
+# the components the chain added.
+#
+# Example:
+#   merge_var FEATURE_FLAGS ,
+#   export FEATURE_FLAGS="$FEATURE_FLAGS,beta"
+#
+merge_var() {
+    local var="${1:-}" sep="${2:-}"
+
+    if [[ -z "$var" || -z "$sep" ]]; then
+        log_error "merge_var: usage: merge_var VAR SEPARATOR"
+        return 1
+    fi
+    if [[ ! "$var" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+        log_error "merge_var: invalid variable name: $var"
+        return 1
+    fi
+    if [[ "$sep" == *$'\n'* ]]; then
+        log_error "merge_var: separator cannot contain a newline"
+        return 1
+    fi
+
+    # Add to CASCADE_MERGE_VARS (newline-separated VAR=SEP entries)
+    if [[ -n "${CASCADE_MERGE_VARS:-}" ]]; then
+        CASCADE_MERGE_VARS="$CASCADE_MERGE_VARS"$'\n'"$var=$sep"
+    else
+        CASCADE_MERGE_VARS="$var=$sep"
+    fi
+    export CASCADE_MERGE_VARS
+}
+
+# sensitive_env VAR...
+# Marks each VAR as sensitive for the rest of the chain. Its value is
+# exported to the shell as usual but never written to disk: CASCADE_DIFF,
+# the saved state, the evaluation cache, and cache_output record only its
+# name. Leaving the directory unsets VAR; a value it had before entering
+# cannot be restored.
+#
+# Example:
+#   sensitive_env DB_PASSWORD
+#   export DB_PASSWORD="$(pass show db/dev)"
+#
+sensitive_env() {
+    if [[ $# -eq 0 ]]; then
+        log_error "sensitive_env: usage: sensitive_env VAR..."
+        return 1
+    fi
+
+    local var
+    for var in "$@"; do
+        if [[ ! "$var" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+            log_error "sensitive_env: invalid variable name: $var"
+            return 1
+        fi
+        __is_sensitive "$var" && continue
+
+        # Add to CASCADE_SENSITIVE_VARS (newline-separated names)
+        if [[ -n "${CASCADE_SENSITIVE_VARS:-}" ]]; then
+            CASCADE_SENSITIVE_VARS="$CASCADE_SENSITIVE_VARS"$'\n'"$var"
+        else
+            CASCADE_SENSITIVE_VARS="$var"
+        fi
+    done
+    export CASCADE_SENSITIVE_VARS
+}
+
+# Reports whether VAR was declared with sensitive_env.
+__is_sensitive() {
+    [[ $'\n'"${CASCADE_SENSITIVE_VARS:-}"$'\n' == *$'\n'"$1"$'\n'* ]]
+}
+
+# on_unload COMMAND...
+# Registers COMMAND to run in your shell when you leave the directory (or
+# on `cascade unload`). It is printed as recorded, before the commands that
+# revert the environment, so it still sees the variables the chain set.
+# The arguments are joined with spaces, as eval does, and the command must
+# fit on one line. It runs in the directory you moved to, in the shell the
+# hook is for; the deepest .envrc's commands run first, each file's in
+# reverse order of registration.
+#
+# Example:
+#   docker compose up -d
+#   on_unload "docker compose --project-directory '$CASCADE_DIR' down"
+#
+on_unload() {
+    if [[ $# -eq 0 ]]; then
+        log_error "on_unload: usage: on_unload COMMAND..."
+        return 1
+    fi
+
+    local cmd="$*"
+    if [[ "$cmd" == *$'\n'* ]]; then
+        log_error "on_unload: command cannot contain a newline"
+        return 1
+    fi
+
+    # Add to CASCADE_UNLOAD_CMDS (newline-separated commands)
+    if [[ -n "${CASCADE_UNLOAD_CMDS:-}" ]]; then
+        CASCADE_UNLOAD_CMDS="$CASCADE_UNLOAD_CMDS"$'\n'"$cmd"
+    else
+        CASCADE_UNLOAD_CMDS="$cmd"
+    fi
+    export CASCADE_UNLOAD_CMDS
+}
+
+# use NAME [ARGS...]
+# Calls use_NAME with ARGS, so "use node 18" runs "use_node 18".
+use() {
+    local name="${1:-}"
+
+    if [[ -z "$name" ]]; then
+        log_error "use: usage: use NAME [ARGS...]"
+        return 1
+    fi
+    if ! declare -F "use_$name" >/dev/null; then
+        log_error "use: unknown program: $name"
+        return 1
+    fi
+    shift
+    "use_$name" "$@"
+}
+
+# use_node VERSION
+# Puts the newest installed Node.js matching VERSION first in PATH. Versions
+# installed by nvm ($NVM_DIR, default ~/.nvm) and mise ($MISE_DATA_DIR,
+# default ~/.local/share/mise) are searched. VERSION is a prefix such as 18
+# or 18.17, an exact =18.17.0, a range such as >=18.2 or <20, ^18.2, ~18.2,
+# or latest. When nothing matches, the environment is left as it was.
+#
+# Example:
+#   use_node 18
+#   use node '>=20.5'
+#
+use_node() {
+    local root
+    root="$(__find_runtime use_node node "$@")" || return 1
+    PATH_add "$root/bin"
+}
+
+# use_go VERSION
+# Like use_node, for Go installed by goenv ($GOENV_ROOT, default ~/.goenv),
+# mise, or golang.org/dl (~/sdk). Also exports GOROOT.
+#
+# Example:
+#   use_go 1.22
+#
+use_go() {
+    local root
+    root="$(__find_runtime use_go go "$@")" || return 1
+    export GOROOT="$root"
+    PATH_add "$root/bin"
+}
+
+# use_python VERSION
+# Like use_node, for Python installed by pyenv ($PYENV_ROOT, default
+# ~/.pyenv) or mise. Also unsets PYTHONHOME.
+#
+# Example:
+#   use_python 3.12
+#
+use_python() {
+    local root
+    root="$(__find_runtime use_python python "$@")" || return 1
+    unset PYTHONHOME
+    PATH_add "$root/bin"
+}
+
+# Prints the root of the newest installed TOOL matching VERSION, as found
+# by cascade internal find-runtime.
+# Usage: __find_runtime CALLER TOOL VERSION
+__find_runtime() {
+    local caller="$1" tool="$2" version="${3:-}" root
+
+    if [[ -z "$version" || $# -gt 3 ]]; then
+        log_error "$caller: usage: $caller VERSION"
+        return 1
+    fi
+    if [[ -z "${CASCADE_BIN:-}" ]]; then
+        log_error "$caller: CASCADE_BIN is not set"
+        return 1
+    fi
+    if ! root="$("$CASCADE_BIN" internal find-runtime "$tool" "$version" 2>&1)"; then
+        log_error "$caller: $root"
+        return 1
+    fi
+    printf '%s\n' "$root"
 }
 
 # Layout helpers for common project types
@@ -409,13 +918,34 @@ layout() {
 
     case "$type" in
         python|python3)
-            # Activate a Python virtual environment
-            local venv_dir="${CASCADE_DIR:-$PWD}/.venv"
-            if [[ -d "$venv_dir" ]]; then
-                export VIRTUAL_ENV="$venv_dir"
-                PATH_add "$venv_dir/bin"
-                unset PYTHONHOME
+            # Activate a Python virtual environment, creating it with
+            # INTERPRETER (default python3) if missing:
+            #   layout python [INTERPRETER]
+            # It lives in .venv, or $CASCADE_VENV_DIR, relative to the
+            # .envrc. Creation is slow, so it only happens once; watching
+            # pyvenv.cfg reloads when the venv is recreated or upgraded.
+            local python="${2:-python3}"
+            local venv_dir="${CASCADE_VENV_DIR:-.venv}"
+            [[ "$venv_dir" == /* ]] || venv_dir="${CASCADE_DIR:-$PWD}/$venv_dir"
+            if [[ ! -f "$venv_dir/pyvenv.cfg" ]]; then
+                if ! command -v "$python" >/dev/null 2>&1; then
+                    log_error "layout python: $python not found"
+                    return 1
+                fi
+                if [[ -n "${CASCADE_DRY_RUN:-}" ]]; then
+                    log_status "layout python: would create $venv_dir with $python"
+                else
+                    log_status "layout python: creating $venv_dir with $python"
+                    if ! "$python" -m venv "$venv_dir" >&2; then
+                        log_error "layout python: could not create $venv_dir"
+                        return 1
+                    fi
+                fi
             fi
+            export VIRTUAL_ENV="$venv_dir"
+            PATH_add "$venv_dir/bin"
+            unset PYTHONHOME
+            watch_file "$venv_dir/pyvenv.cfg"
             ;;
         node)
             # Add node_modules/.bin to PATH